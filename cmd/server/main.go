@@ -2,186 +2,603 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"live-video/internal/handlers"
-	"live-video/pkg/broadcast"
+	"live-video/pkg/engine"
 	"live-video/pkg/storage"
+	"live-video/pkg/vod"
+	"live-video/pkg/webrtc"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	// Load configuration from environment
 	port := getEnv("PORT", "8080")
-	gcsBucket := getEnv("GCS_BUCKET_NAME", "your-gcs-bucket-name")
-	gcsCredentials := getEnv("GCS_CREDENTIALS_FILE", "")
-	videoFolder := getEnv("VIDEO_FOLDER", "upload/videos")
+	cfg := engine.DefaultConfig()
+	cfg.GCSBucket = getEnv("GCS_BUCKET_NAME", cfg.GCSBucket)
+	cfg.GCSCredentialsFile = getEnv("GCS_CREDENTIALS_FILE", "")
+	cfg.GCSServiceAccount = getEnv("GCS_SERVICE_ACCOUNT", "")
+	cfg.StorageLayout = storage.Layout{
+		Live:       getEnv("STORAGE_LIVE_PREFIX", cfg.StorageLayout.Live),
+		VOD:        getEnv("STORAGE_VOD_PREFIX", getEnv("VIDEO_FOLDER", cfg.StorageLayout.VOD)),
+		Recordings: getEnv("STORAGE_RECORDINGS_PREFIX", cfg.StorageLayout.Recordings),
+		Thumbnails: getEnv("STORAGE_THUMBNAILS_PREFIX", cfg.StorageLayout.Thumbnails),
+	}
+	if err := cfg.StorageLayout.Validate(); err != nil {
+		log.Fatalf("Invalid storage layout: %v", err)
+	}
+	storageLifecycle, err := storage.ParseLifecycle(getEnv("STORAGE_LIFECYCLE", ""), cfg.StorageLayout)
+	if err != nil {
+		log.Fatalf("Invalid STORAGE_LIFECYCLE: %v", err)
+	}
+	cfg.BucketSettings.Lifecycle = storageLifecycle
+	for _, origin := range strings.Split(getEnv("BUCKET_CORS_ORIGINS", ""), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.BucketSettings.CORSOrigins = append(cfg.BucketSettings.CORSOrigins, origin)
+		}
+	}
+	cfg.BucketSettings.CORSMaxAge, err = time.ParseDuration(getEnv("BUCKET_CORS_MAX_AGE", "1h"))
+	if err != nil || cfg.BucketSettings.CORSMaxAge < 0 {
+		log.Fatalf("Invalid BUCKET_CORS_MAX_AGE: %v", err)
+	}
+	if value := getEnv("BUCKET_UNIFORM_ACCESS", ""); value != "" {
+		uniform, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("Invalid BUCKET_UNIFORM_ACCESS: %v", err)
+		}
+		cfg.BucketSettings.UniformAccess = &uniform
+	}
+	cfg.BucketSettings.PublicAccessPrevention, err = storage.ParsePublicAccessPrevention(getEnv("BUCKET_PUBLIC_ACCESS_PREVENTION", ""))
+	if err != nil {
+		log.Fatalf("Invalid BUCKET_PUBLIC_ACCESS_PREVENTION: %v", err)
+	}
+	cfg.StoragePolicies, err = storage.ParseOperationPolicies(getEnv("GCS_OPERATION_POLICY", ""))
+	if err != nil {
+		log.Fatalf("Invalid GCS_OPERATION_POLICY: %v", err)
+	}
+	cfg.IngestWatchPrefix = getEnv("INGEST_WATCH_PREFIX", "")
+	cfg.PubSubPushToken = getEnv("PUBSUB_PUSH_TOKEN", "")
+	cfg.ArchiveStorageClass = getEnv("ARCHIVE_STORAGE_CLASS", cfg.ArchiveStorageClass)
+	cfg.PreserveOriginals, err = strconv.ParseBool(getEnv("PRESERVE_ORIGINALS", "false"))
+	if err != nil {
+		log.Fatalf("Invalid PRESERVE_ORIGINALS: %v", err)
+	}
+	cfg.OriginalsStorageClass = getEnv("ORIGINALS_STORAGE_CLASS", cfg.OriginalsStorageClass)
+	cfg.Deinterlacer = getEnv("VOD_DEINTERLACER", cfg.Deinterlacer)
+	if cfg.Deinterlacer != vod.DeinterlaceBwdif && cfg.Deinterlacer != vod.DeinterlaceYadif && cfg.Deinterlacer != vod.DeinterlaceOff {
+		log.Fatalf("Invalid VOD_DEINTERLACER: %q (bwdif, yadif or off)", cfg.Deinterlacer)
+	}
+	cfg.Downmix = getEnv("VOD_DOWNMIX", cfg.Downmix)
+	if cfg.Downmix != vod.DownmixITU && cfg.Downmix != vod.DownmixDialog {
+		log.Fatalf("Invalid VOD_DOWNMIX: %q (itu or dialog)", cfg.Downmix)
+	}
+	cfg.Surround, err = strconv.ParseBool(getEnv("VOD_SURROUND", "false"))
+	if err != nil {
+		log.Fatalf("Invalid VOD_SURROUND: %v", err)
+	}
+	cfg.Segments.Template = getEnv("HLS_SEGMENT_TEMPLATE", cfg.Segments.Template)
+	cfg.Segments.Format = getEnv("HLS_SEGMENT_FORMAT", cfg.Segments.Format)
+	cfg.Segments.Init = getEnv("HLS_INIT_NAME", cfg.Segments.Init)
+	if err := cfg.Segments.Validate(); err != nil {
+		log.Fatalf("Invalid HLS segment naming: %v", err)
+	}
+	segmentCacheMB, err := strconv.ParseInt(getEnv("HLS_PROXY_CACHE_MB", strconv.FormatInt(cfg.SegmentCacheSize>>20, 10)), 10, 64)
+	if err != nil || segmentCacheMB < 0 {
+		log.Fatalf("Invalid HLS_PROXY_CACHE_MB: %v", err)
+	}
+	cfg.SegmentCacheSize = segmentCacheMB << 20
+	cfg.PrefetchSegments, err = strconv.Atoi(getEnv("HLS_PREFETCH_SEGMENTS", strconv.Itoa(cfg.PrefetchSegments)))
+	if err != nil || cfg.PrefetchSegments < 0 {
+		log.Fatalf("Invalid HLS_PREFETCH_SEGMENTS: %v", err)
+	}
+	cfg.BodyLimits, err = handlers.ParseBodyLimits(getEnv("REQUEST_BODY_LIMITS", ""))
+	if err != nil {
+		log.Fatalf("Invalid REQUEST_BODY_LIMITS: %v", err)
+	}
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			cfg.TrustedProxies = append(cfg.TrustedProxies, proxy)
+		}
+	}
+	if value := getEnv("API_V1_DEPRECATED_AT", ""); value != "" {
+		if cfg.V1DeprecatedAt, err = time.Parse(time.DateOnly, value); err != nil {
+			log.Fatalf("Invalid API_V1_DEPRECATED_AT: %v", err)
+		}
+	}
+	if value := getEnv("API_V1_SUNSET", ""); value != "" {
+		if cfg.V1Sunset, err = time.Parse(time.DateOnly, value); err != nil {
+			log.Fatalf("Invalid API_V1_SUNSET: %v", err)
+		}
+	}
+	cfg.CDNBaseURL = getEnv("CDN_BASE_URL", cfg.CDNBaseURL)
+	cfg.CDNUpstream.Timeout, err = time.ParseDuration(getEnv("CDN_PROXY_TIMEOUT", cfg.CDNUpstream.Timeout.String()))
+	if err != nil || cfg.CDNUpstream.Timeout <= 0 {
+		log.Fatalf("Invalid CDN_PROXY_TIMEOUT: %v", err)
+	}
+	cfg.CDNUpstream.MaxAttempts, err = strconv.Atoi(getEnv("CDN_PROXY_ATTEMPTS", strconv.Itoa(cfg.CDNUpstream.MaxAttempts)))
+	if err != nil || cfg.CDNUpstream.MaxAttempts < 1 {
+		log.Fatalf("Invalid CDN_PROXY_ATTEMPTS: %v", err)
+	}
+	cfg.CDNUpstream.FailureThreshold, err = strconv.Atoi(getEnv("CDN_PROXY_BREAKER_FAILURES", strconv.Itoa(cfg.CDNUpstream.FailureThreshold)))
+	if err != nil || cfg.CDNUpstream.FailureThreshold < 1 {
+		log.Fatalf("Invalid CDN_PROXY_BREAKER_FAILURES: %v", err)
+	}
+	cfg.CDNUpstream.Cooldown, err = time.ParseDuration(getEnv("CDN_PROXY_BREAKER_COOLDOWN", cfg.CDNUpstream.Cooldown.String()))
+	if err != nil || cfg.CDNUpstream.Cooldown <= 0 {
+		log.Fatalf("Invalid CDN_PROXY_BREAKER_COOLDOWN: %v", err)
+	}
+	for _, host := range strings.Split(getEnv("CDN_PROXY_ALLOWED_HOSTS", ""), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			cfg.CDNUpstream.AllowedHosts = append(cfg.CDNUpstream.AllowedHosts, host)
+		}
+	}
+	cfg.CDNUpstream.AllowPrivate, err = strconv.ParseBool(getEnv("CDN_PROXY_ALLOW_PRIVATE", "false"))
+	if err != nil {
+		log.Fatalf("Invalid CDN_PROXY_ALLOW_PRIVATE: %v", err)
+	}
+	cdnProxyMaxMB, err := strconv.ParseInt(getEnv("CDN_PROXY_MAX_MB", strconv.FormatInt(cfg.CDNProxyMaxBytes>>20, 10)), 10, 64)
+	if err != nil || cdnProxyMaxMB < 1 {
+		log.Fatalf("Invalid CDN_PROXY_MAX_MB: %v", err)
+	}
+	cfg.CDNProxyMaxBytes = cdnProxyMaxMB << 20
+	cfg.AccountsFile = getEnv("AUTH_ACCOUNTS_FILE", "")
+	cfg.OIDCProvidersFile = getEnv("OIDC_PROVIDERS_FILE", "")
+	cfg.SessionTTL, err = time.ParseDuration(getEnv("AUTH_SESSION_TTL", cfg.SessionTTL.String()))
+	if err != nil {
+		log.Fatalf("Invalid AUTH_SESSION_TTL: %v", err)
+	}
+	cfg.OwnershipSync, err = time.ParseDuration(getEnv("OWNERSHIP_SYNC", cfg.OwnershipSync.String()))
+	if err != nil || cfg.OwnershipSync <= 0 {
+		log.Fatalf("Invalid OWNERSHIP_SYNC: %v", err)
+	}
+	cfg.EmbedTokenSecret = getEnv("EMBED_TOKEN_SECRET", "")
+	cfg.RevocationSync, err = time.ParseDuration(getEnv("TOKEN_REVOCATION_SYNC", "30s"))
+	if err != nil || cfg.RevocationSync <= 0 {
+		log.Fatalf("Invalid TOKEN_REVOCATION_SYNC: %v", err)
+	}
+	cfg.IntegrityKey = getEnv("INTEGRITY_SIGNING_KEY", "")
+	cfg.CDN.CDNKeyName = getEnv("CDN_SIGNING_KEY_NAME", "")
+	cfg.CDN.CDNKey = getEnv("CDN_SIGNING_KEY", "")
+	cfg.CDN.CDNCookieDomain = getEnv("CDN_COOKIE_DOMAIN", "")
+	cdnSignedTTL, err := time.ParseDuration(getEnv("CDN_SIGNED_TTL", "1h"))
+	if err != nil || cdnSignedTTL < time.Second {
+		log.Fatalf("Invalid CDN_SIGNED_TTL: %v", err)
+	}
+	cfg.CDN.CDNSignedTTL = int(cdnSignedTTL.Seconds())
+	cfg.PublicBaseURL = getEnv("PUBLIC_BASE_URL", "")
+	cfg.GeoIPDatabase = getEnv("GEOIP_DATABASE", "")
+	cfg.GeoIPCountryHeader = getEnv("GEOIP_COUNTRY_HEADER", "")
+	cfg.IngestRegionsFile = getEnv("INGEST_REGIONS_FILE", "")
+	cfg.ICEServers = iceServersFromEnv()
+	cfg.PreflightMinUplinkKbps, err = strconv.Atoi(getEnv("PREFLIGHT_MIN_UPLINK_KBPS", strconv.Itoa(cfg.PreflightMinUplinkKbps)))
+	if err != nil {
+		log.Fatalf("Invalid PREFLIGHT_MIN_UPLINK_KBPS: %v", err)
+	}
+	cfg.PreflightMaxSessions, err = strconv.Atoi(getEnv("PREFLIGHT_MAX_SESSIONS", strconv.Itoa(cfg.PreflightMaxSessions)))
+	if err != nil || cfg.PreflightMaxSessions <= 0 {
+		log.Fatalf("Invalid PREFLIGHT_MAX_SESSIONS: %v", err)
+	}
+	cfg.WorkDir = getEnv("WORK_DIR", cfg.WorkDir)
+	cfg.WorkDirFast = getEnv("WORK_DIR_FAST", "")
+	workDirMinFreeMB, err := strconv.ParseUint(getEnv("WORK_DIR_MIN_FREE_MB", "1024"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid WORK_DIR_MIN_FREE_MB: %v", err)
+	}
+	cfg.WorkDirMinFreeBytes = workDirMinFreeMB << 20
+	cfg.CaptureDir = getEnv("DEBUG_CAPTURE_DIR", "")
+	captureMaxMB, err := strconv.ParseInt(getEnv("DEBUG_CAPTURE_MAX_MB", "200"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid DEBUG_CAPTURE_MAX_MB: %v", err)
+	}
+	cfg.CaptureMaxBytes = captureMaxMB << 20
+	captureTotalMB, err := strconv.ParseInt(getEnv("DEBUG_CAPTURE_TOTAL_MB", "2048"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid DEBUG_CAPTURE_TOTAL_MB: %v", err)
+	}
+	cfg.CaptureTotalBytes = captureTotalMB << 20
+	cfg.CaptureRetention, err = time.ParseDuration(getEnv("DEBUG_CAPTURE_RETENTION", "24h"))
+	if err != nil {
+		log.Fatalf("Invalid DEBUG_CAPTURE_RETENTION: %v", err)
+	}
+	cfg.Staging.UploadedRetention, err = time.ParseDuration(getEnv("STAGING_KEEP_UPLOADED", "0s"))
+	if err != nil || cfg.Staging.UploadedRetention < 0 {
+		log.Fatalf("Invalid STAGING_KEEP_UPLOADED: %v", err)
+	}
+	cfg.Staging.FailedRetention, err = time.ParseDuration(getEnv("STAGING_FAILED_RETENTION", "24h"))
+	if err != nil || cfg.Staging.FailedRetention < 0 {
+		log.Fatalf("Invalid STAGING_FAILED_RETENTION: %v", err)
+	}
+	cfg.Staging.QuarantineRetention, err = time.ParseDuration(getEnv("QUARANTINE_RETENTION", "168h"))
+	if err != nil || cfg.Staging.QuarantineRetention < 0 {
+		log.Fatalf("Invalid QUARANTINE_RETENTION: %v", err)
+	}
+	cfg.Limits.MaxLive, err = strconv.Atoi(getEnv("MAX_LIVE_STREAMS", "0"))
+	if err != nil {
+		log.Fatalf("Invalid MAX_LIVE_STREAMS: %v", err)
+	}
+	cfg.Limits.MaxLivePerTenant, err = strconv.Atoi(getEnv("MAX_LIVE_STREAMS_PER_TENANT", "0"))
+	if err != nil {
+		log.Fatalf("Invalid MAX_LIVE_STREAMS_PER_TENANT: %v", err)
+	}
+	cfg.FailoverStallTimeout, err = time.ParseDuration(getEnv("FAILOVER_STALL_TIMEOUT", "12s"))
+	if err != nil || cfg.FailoverStallTimeout <= 0 {
+		log.Fatalf("Invalid FAILOVER_STALL_TIMEOUT: %v", err)
+	}
+	cfg.InputFailoverWindow, err = time.ParseDuration(getEnv("INPUT_FAILOVER_WINDOW", "5s"))
+	if err != nil || cfg.InputFailoverWindow <= 0 {
+		log.Fatalf("Invalid INPUT_FAILOVER_WINDOW: %v", err)
+	}
+	for _, host := range strings.Split(getEnv("INPUT_ALLOWED_HOSTS", ""), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			cfg.SourcePolicy.AllowedHosts = append(cfg.SourcePolicy.AllowedHosts, host)
+		}
+	}
+	cfg.SourcePolicy.AllowPrivate, err = strconv.ParseBool(getEnv("INPUT_ALLOW_PRIVATE", "false"))
+	if err != nil {
+		log.Fatalf("Invalid INPUT_ALLOW_PRIVATE: %v", err)
+	}
+	if ports := getEnv("SRT_PORT_RANGE", ""); ports != "" {
+		first, last, found := strings.Cut(ports, "-")
+		if !found {
+			last = first
+		}
+		cfg.SRT.FirstPort, err = strconv.Atoi(first)
+		if err == nil {
+			cfg.SRT.LastPort, err = strconv.Atoi(last)
+		}
+		if err != nil || cfg.SRT.FirstPort <= 0 || cfg.SRT.LastPort < cfg.SRT.FirstPort || cfg.SRT.LastPort > 65535 {
+			log.Fatalf("Invalid SRT_PORT_RANGE %q, use first-last, e.g. 9000-9099", ports)
+		}
+		cfg.SRT.Host = getEnv("SRT_PUBLIC_HOST", "")
+		if cfg.SRT.Host == "" {
+			log.Fatalf("SRT_PUBLIC_HOST is required with SRT_PORT_RANGE")
+		}
+		cfg.SRT.Latency, err = time.ParseDuration(getEnv("SRT_LATENCY", "120ms"))
+		if err != nil || cfg.SRT.Latency <= 0 {
+			log.Fatalf("Invalid SRT_LATENCY: %v", err)
+		}
+	}
+	cfg.Idle.IdleTimeout, err = time.ParseDuration(getEnv("STREAM_IDLE_TIMEOUT", "10m"))
+	if err != nil || cfg.Idle.IdleTimeout < 0 {
+		log.Fatalf("Invalid STREAM_IDLE_TIMEOUT: %v", err)
+	}
+	cfg.Idle.InputTimeout, err = time.ParseDuration(getEnv("STREAM_INPUT_TIMEOUT", "2m"))
+	if err != nil || cfg.Idle.InputTimeout < 0 {
+		log.Fatalf("Invalid STREAM_INPUT_TIMEOUT: %v", err)
+	}
+	cfg.ViewerSessionTimeout, err = time.ParseDuration(getEnv("VIEWER_SESSION_TIMEOUT", "30s"))
+	if err != nil || cfg.ViewerSessionTimeout < 0 {
+		log.Fatalf("Invalid VIEWER_SESSION_TIMEOUT: %v", err)
+	}
+	cfg.WarmPoolSize, err = strconv.Atoi(getEnv("ENCODER_WARM_POOL_SIZE", "0"))
+	if err != nil || cfg.WarmPoolSize < 0 {
+		log.Fatalf("Invalid ENCODER_WARM_POOL_SIZE: %v", err)
+	}
+	cfg.EncoderCPUHigh, err = strconv.Atoi(getEnv("ENCODER_CPU_HIGH", "90"))
+	if err != nil || cfg.EncoderCPUHigh < 0 || cfg.EncoderCPUHigh > 100 {
+		log.Fatalf("Invalid ENCODER_CPU_HIGH: %v", err)
+	}
+	cfg.StreamPrimeLead, err = time.ParseDuration(getEnv("STREAM_PRIME_LEAD", "15m"))
+	if err != nil || cfg.StreamPrimeLead < 0 {
+		log.Fatalf("Invalid STREAM_PRIME_LEAD: %v", err)
+	}
+	cfg.ReconcileOrphansAfter, err = time.ParseDuration(getEnv("RECONCILE_ORPHANS_AFTER", "10m"))
+	if err != nil || cfg.ReconcileOrphansAfter < 0 {
+		log.Fatalf("Invalid RECONCILE_ORPHANS_AFTER: %v", err)
+	}
+	cfg.ReconcileInterval, err = time.ParseDuration(getEnv("RECONCILE_INTERVAL", "5m"))
+	if err != nil || cfg.ReconcileInterval < 0 {
+		log.Fatalf("Invalid RECONCILE_INTERVAL: %v", err)
+	}
+	cfg.LiveSegmentRetention, err = time.ParseDuration(getEnv("LIVE_SEGMENT_RETENTION", "0"))
+	if err != nil || cfg.LiveSegmentRetention < 0 {
+		log.Fatalf("Invalid LIVE_SEGMENT_RETENTION: %v", err)
+	}
+	cfg.ASRURL = getEnv("ASR_URL", "")
+	cfg.ASRToken = getEnv("ASR_TOKEN", "")
+	cfg.CaptionTranslateURL = getEnv("CAPTION_TRANSLATE_URL", "")
+	cfg.CaptionTranslateToken = getEnv("CAPTION_TRANSLATE_TOKEN", "")
+	cfg.CatalogSync, err = time.ParseDuration(getEnv("CATALOG_SYNC", cfg.CatalogSync.String()))
+	if err != nil || cfg.CatalogSync <= 0 {
+		log.Fatalf("Invalid CATALOG_SYNC: %v", err)
+	}
+	cfg.CatalogReconcile, err = time.ParseDuration(getEnv("CATALOG_RECONCILE_INTERVAL", cfg.CatalogReconcile.String()))
+	if err != nil || cfg.CatalogReconcile < 0 {
+		log.Fatalf("Invalid CATALOG_RECONCILE_INTERVAL: %v", err)
+	}
+	cfg.UsageRebuild, err = time.ParseDuration(getEnv("USAGE_REBUILD_INTERVAL", cfg.UsageRebuild.String()))
+	if err != nil || cfg.UsageRebuild < 0 {
+		log.Fatalf("Invalid USAGE_REBUILD_INTERVAL: %v", err)
+	}
+	cfg.AuditInterval, err = time.ParseDuration(getEnv("AUDIT_INTERVAL", cfg.AuditInterval.String()))
+	if err != nil || cfg.AuditInterval < 0 {
+		log.Fatalf("Invalid AUDIT_INTERVAL: %v", err)
+	}
+	cfg.Audit.Playlists, err = strconv.Atoi(getEnv("AUDIT_PLAYLISTS", strconv.Itoa(cfg.Audit.Playlists)))
+	if err != nil || cfg.Audit.Playlists < 0 {
+		log.Fatalf("Invalid AUDIT_PLAYLISTS: %v", err)
+	}
+	cfg.Audit.Segments, err = strconv.Atoi(getEnv("AUDIT_SEGMENTS", strconv.Itoa(cfg.Audit.Segments)))
+	if err != nil || cfg.Audit.Segments < 0 {
+		log.Fatalf("Invalid AUDIT_SEGMENTS: %v", err)
+	}
+	cfg.Audit.Recordings, err = strconv.Atoi(getEnv("AUDIT_RECORDINGS", strconv.Itoa(cfg.Audit.Recordings)))
+	if err != nil || cfg.Audit.Recordings < 0 {
+		log.Fatalf("Invalid AUDIT_RECORDINGS: %v", err)
+	}
+	cfg.PlaybackCheckTimeout, err = time.ParseDuration(getEnv("PLAYBACK_CHECK_TIMEOUT", cfg.PlaybackCheckTimeout.String()))
+	if err != nil || cfg.PlaybackCheckTimeout < 0 {
+		log.Fatalf("Invalid PLAYBACK_CHECK_TIMEOUT: %v", err)
+	}
+	cfg.CostPrices.Currency = getEnv("COST_CURRENCY", cfg.CostPrices.Currency)
+	for _, price := range []struct {
+		env   string
+		value *float64
+	}{
+		{"COST_CPU_HOUR", &cfg.CostPrices.CPUHour},
+		{"COST_STORAGE_GB_MONTH", &cfg.CostPrices.StorageGBMonth},
+		{"COST_EGRESS_GB", &cfg.CostPrices.EgressGB},
+	} {
+		*price.value, err = strconv.ParseFloat(getEnv(price.env, strconv.FormatFloat(*price.value, 'f', -1, 64)), 64)
+		if err != nil || *price.value < 0 {
+			log.Fatalf("Invalid %s: %v", price.env, err)
+		}
+	}
+	cfg.BillingExport = getEnv("BILLING_EXPORT", "")
+	switch cfg.BillingExport {
+	case "", "gcs":
+	case "bigquery":
+		cfg.BillingBigQueryTable = getEnv("BILLING_BIGQUERY_TABLE", "")
+		if cfg.BillingBigQueryTable == "" {
+			log.Fatal("BILLING_BIGQUERY_TABLE is required with BILLING_EXPORT=bigquery")
+		}
+	default:
+		log.Fatalf("Invalid BILLING_EXPORT: %q (gcs, bigquery or empty)", cfg.BillingExport)
+	}
+	cfg.BillingExportInterval, err = time.ParseDuration(getEnv("BILLING_EXPORT_INTERVAL", cfg.BillingExportInterval.String()))
+	if err != nil || cfg.BillingExportInterval <= 0 {
+		log.Fatalf("Invalid BILLING_EXPORT_INTERVAL: %v", err)
+	}
+	cfg.BillingCSVPrefix = getEnv("BILLING_CSV_PREFIX", cfg.BillingCSVPrefix)
+	cfg.LeaderElection = getEnv("LEADER_ELECTION", "")
+	if cfg.LeaderElection != "" && cfg.LeaderElection != "kubernetes" {
+		log.Fatalf("Invalid LEADER_ELECTION: %q (kubernetes or empty)", cfg.LeaderElection)
+	}
+	cfg.LeaderLeaseName = getEnv("LEADER_LEASE_NAME", cfg.LeaderLeaseName)
+	cfg.LeaderLeaseNamespace = getEnv("LEADER_LEASE_NAMESPACE", "")
+	cfg.LeaderLeaseDuration, err = time.ParseDuration(getEnv("LEADER_LEASE_DURATION", "15s"))
+	if err != nil || cfg.LeaderLeaseDuration < 3*time.Second {
+		log.Fatalf("Invalid LEADER_LEASE_DURATION: %v", err)
+	}
+	cfg.SlateImage = getEnv("SLATE_IMAGE", "")
+	cfg.EventBusURL = getEnv("EVENT_BUS_URL", "")
+	cfg.WebhookURL = getEnv("WEBHOOK_URL", "")
+	cfg.WebhookSecret = getEnv("WEBHOOK_SECRET", "")
+	cfg.WebhookEvents = nil
+	for _, pattern := range strings.Split(getEnv("WEBHOOK_EVENTS", "stream.*"), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			cfg.WebhookEvents = append(cfg.WebhookEvents, pattern)
+		}
+	}
 
 	log.Println("Starting Video Broadcast Service...")
 	log.Printf("Port: %s", port)
-	log.Printf("GCS Bucket: %s", gcsBucket)
-	log.Printf("Video Folder: %s", videoFolder)
-
-	// Initialize context
-	ctx := context.Background()
-
-	// Initialize GCS service
-	gcsService, err := storage.NewGCSService(ctx, gcsBucket, gcsCredentials)
-	if err != nil {
-		log.Fatalf("Failed to initialize GCS service: %v", err)
+	log.Printf("GCS Bucket: %s", cfg.GCSBucket)
+	log.Printf("Storage Layout: live=%s vod=%s recordings=%s thumbnails=%s", cfg.StorageLayout.Live, cfg.StorageLayout.VOD, cfg.StorageLayout.Recordings, cfg.StorageLayout.Thumbnails)
+	if cfg.IngestWatchPrefix != "" {
+		log.Printf("Ingest Watch Prefix: %s", cfg.IngestWatchPrefix)
 	}
-	defer gcsService.Close()
-	log.Println("✓ GCS service initialized")
 
-	// Initialize broadcast manager
-	broadcastManager := broadcast.NewBroadcastManager()
-	log.Println("✓ Broadcast manager initialized")
+	tlsCert, tlsKey := getEnv("TLS_CERT_FILE", ""), getEnv("TLS_KEY_FILE", "")
+	if (tlsCert == "") != (tlsKey == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	tlsConfig := ingestTLSFromEnv(tlsCert != "")
 
-	// Initialize handlers
-	videoHandler := handlers.NewVideoHandler(gcsService, broadcastManager, videoFolder)
-	broadcastHandler := handlers.NewBroadcastHandler(broadcastManager, gcsService)
-	hlsProxyHandler := handlers.NewHLSProxyHandler()
-	log.Println("✓ Handlers initialized")
+	// Gin runs in release mode unless GIN_MODE says otherwise
+	if os.Getenv("GIN_MODE") == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
 
-	// Setup Gin router
-	router := setupRouter(videoHandler, broadcastHandler, hlsProxyHandler)
+	service, err := engine.New(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to start service: %v", err)
+	}
+	defer service.Close()
 
 	// Start server
 	addr := fmt.Sprintf(":%s", port)
-	log.Printf("🚀 Server starting on http://localhost%s", addr)
+	scheme := "http"
+	if tlsCert != "" {
+		scheme = "https"
+	}
+	log.Printf("🚀 Server starting on %s://localhost%s", scheme, addr)
 	log.Println("\nAvailable endpoints:")
 	log.Println("  POST   /api/v1/videos/upload          - Upload video to GCS")
 	log.Println("  GET    /api/v1/videos                 - List all videos")
+	log.Println("  POST   /api/v1/videos/upload-url      - Get direct-to-bucket upload URL")
+	log.Println("  POST   /api/v1/videos/:id/complete    - Complete direct upload and transcode")
+	log.Println("  POST   /api/v1/slideshows             - Stream images and audio as a video")
 	log.Println("  GET    /api/v1/videos/signed-url      - Get signed URL")
 	log.Println("  DELETE /api/v1/videos                 - Delete video")
+	log.Println("  POST   /api/v1/videos/:id/archive     - Archive video to cold storage")
+	log.Println("  POST   /api/v1/videos/:id/retranscode - Re-transcode video with a new ladder or codec")
+	log.Println("  GET    /api/v1/videos/:id/original    - Download URL of the original upload")
+	log.Println("  GET    /api/v1/videos/:id/download    - Download original, recording or MP4 (Range)")
+	log.Println("  GET    /api/v1/videos/:id/frame?t=    - Frame at a timestamp (JPEG/PNG)")
+	log.Println("  POST   /api/v1/{videos,streams}/:id/previews - Generate a GIF/WebM preview clip")
+	log.Println("  GET    /api/v1/previews/:id           - Preview clip status and URL")
+	log.Println("  POST   /api/v1/{videos,streams}/:id/conformance - Check HLS output against Apple's authoring rules")
+	log.Println("  GET    /api/v1/conformance/:id        - Conformance check status and report")
+	log.Println("  GET    /api/v1/jobs/:id               - Transcode job status")
+	log.Println("  GET    /api/v1/staging                - List staged sources (admin)")
+	log.Println("  POST   /api/v1/staging/:id/retry      - Retry a failed conversion (admin)")
+	log.Println("  GET    /api/v1/storage/layout         - Bucket prefixes and lifecycle (admin)")
+	log.Println("  POST   /api/v1/storage/migrate        - Move legacy objects into the layout (admin)")
+	log.Println("  POST   /api/v1/storage/bootstrap      - Apply bucket CORS, access and lifecycle settings (admin)")
+	log.Println("  POST   /api/v1/storage/audits         - Audit stored assets for corruption now (admin)")
+	log.Println("  GET    /api/v1/storage/audits         - Reports of past audits (admin)")
+	log.Println("  GET    /api/v1/usage                  - Storage used by your videos and streams")
+	log.Println("  GET    /api/v1/videos/:id/usage       - Storage used by a video")
+	log.Println("  GET    /api/v1/streams/:id/usage      - Storage used by a stream")
+	log.Println("  POST   /api/v1/cost-estimates         - Estimate transcode, storage and egress cost of a ladder")
+	log.Println("  GET    /api/v1/billing/current        - Usage per tenant not exported for billing yet (admin)")
+	log.Println("  POST   /api/v1/ingest/gcs-notifications - Pub/Sub push endpoint for GCS events")
 	log.Println("")
-	log.Println("  POST   /api/v1/streams                - Create broadcast stream")
+	log.Println("  POST   /api/v1/streams                - Create broadcast stream (srt: true adds an SRT listener)")
+	log.Println("  POST   /api/v1/streams/redundant      - Create primary/backup stream pair")
+	log.Println("  GET    /api/v1/streams/reconciliation - Startup reconciliation report (admin)")
 	log.Println("  GET    /api/v1/streams                - List all streams")
 	log.Println("  GET    /api/v1/streams/:id            - Get stream details")
 	log.Println("  POST   /api/v1/streams/:id/start      - Start broadcasting")
 	log.Println("  POST   /api/v1/streams/:id/stop       - Stop broadcasting")
+	log.Println("  POST   /api/v1/streams/:id/pause      - Pause a live stream")
+	log.Println("  POST   /api/v1/streams/:id/resume     - Resume a paused stream")
+	log.Println("  POST   /api/v1/streams/:id/heartbeat  - Keep an idle live stream running")
 	log.Println("  GET    /api/v1/streams/:id/watch      - Watch stream (SSE)")
+	log.Println("  PUT    /api/v1/streams/:id/viewer-limit - Set viewer limit and waiting room")
+	log.Println("  POST   /api/v1/streams/:id/embed-tokens - Issue domain-bound embed token")
+	log.Println("  POST   /api/v1/token-revocations     - Revoke an embed token or a viewer's tokens")
+	log.Println("  GET    /api/v1/token-revocations     - Revocations in force")
+	log.Println("  DELETE /api/v1/token-revocations/:id - Lift a revocation")
+	log.Println("  GET    /api/v1/streams/:id/geo        - Live viewers by country/region")
+	log.Println("  GET    /embed/:id?embed_token=    - Embeddable player")
+	log.Println("  PUT    /api/v1/streams/:id/metadata   - Set stream name and description")
+	log.Println("  GET    /api/v1/streams/:id/card       - Open Graph/Twitter Card metadata")
 	log.Println("  GET    /api/v1/streams/:id/video      - Get video URL")
 	log.Println("  GET    /api/v1/streams/:id/stats      - Stream statistics")
+	log.Println("  GET    /api/v1/streams/:id/screenshot - Latest live frame (JPEG/PNG)")
+	log.Println("  GET    /api/v1/streams/:id/playback   - Playback descriptor (active source, failover order)")
+	log.Println("  PUT    /api/v1/streams/:id/playlist-windows - DVR window per viewer class")
+	log.Println("  PUT    /api/v1/streams/:id/inputs     - Set WebRTC/RTMP/SRT/RTSP/remote inputs and failover window")
+	log.Println("  GET    /api/v1/streams/:id/inputs     - Inputs and the one playing")
+	log.Println("  GET    /api/v1/streams/:id/ingest-endpoint - Nearest ingest region of the broadcaster")
+	log.Println("  POST   /api/v1/streams/:id/encoder-stats - Push hardware encoder telemetry")
+	log.Println("  POST   /api/v1/streams/:id/broadcaster-messages - Cue or notify the WebRTC broadcaster")
+	log.Println("  PUT    /api/v1/streams/:id/audio-inputs - Mix in or offer alternate audio sources")
+	log.Println("  GET    /api/v1/streams/:id/audio-inputs - Configured and running audio sources")
+	log.Println("  PUT    /api/v1/streams/:id/captions - Turn on live captions and their translations")
+	log.Println("  GET    /api/v1/streams/:id/captions - Caption settings and published caption tracks")
+	log.Println("  PUT    /api/v1/streams/:id/ingest-clients - Client certificates that may push (mTLS)")
+	log.Println("  GET    /api/v1/streams/:id/ingest-clients - Ingest client certificates")
+	log.Println("  PUT    /api/v1/streams/:id/watermark - Forensic A/B watermarking of playback sessions")
+	log.Println("  POST   /api/v1/streams/:id/watermark/trace - Trace leaked segment variants to sessions (admin)")
+	log.Println("  GET    /api/v1/streams/:id/renditions - Renditions a live stream encodes")
+	log.Println("  PUT    /api/v1/streams/:id/priority - Set a stream's priority class under load")
+	log.Println("  PUT    /api/v1/streams/:id/content-type - Tune the encoder for camera or screen content")
+	log.Println("  POST   /api/v1/streams/:id/renditions - Add a rendition to a live stream")
+	log.Println("  DELETE /api/v1/streams/:id/renditions/:rendition - Drop a rendition of a live stream")
+	log.Println("  PUT    /api/v1/streams/:id/clip-policy - DVR clip length per viewer class")
+	log.Println("  POST   /api/v1/streams/:id/clips     - Export a clip of the DVR window")
+	log.Println("  GET    /api/v1/clips/:id             - Clip export status and URL")
+	log.Println("  GET    /api/v1/integrity/key         - Public key of playlist signatures")
+	log.Println("  POST   /api/v1/integrity/verify      - Verify a playlist signature and digests")
+	log.Println("  PUT    /api/v1/streams/:id/schedule - Announce start time (slate until then)")
+	log.Println("  GET    /api/v1/streams/:id/live/:rendition/playlist.m3u8 - Media playlist trimmed to the viewer's window")
 	log.Println("  DELETE /api/v1/streams/:id            - Delete stream")
+	log.Println("  POST   /api/v1/streams/:id/archive    - Archive stream to cold storage")
+	log.Println("  POST   /api/v1/events/provision       - Provision an event's streams from a preset")
+	log.Println("  GET    /api/v1/events/:id             - Event streams and aggregate stats")
+	log.Println("  GET    /api/v1/events/:id/stats       - Event viewers and per-room health")
+	log.Println("  GET    /api/v1/events/:id/geo         - Event viewers by country/region")
+	log.Println("  POST   /api/v1/events/:id/{start,stop} - Start/stop all streams of an event")
+	log.Println("  POST   /api/v1/parties                - Open a watch party for a video or stream")
+	log.Println("  POST   /api/v1/parties/:id/join       - Join a watch party")
+	log.Println("  GET    /api/v1/parties/:id/events     - Watch party state and members (SSE)")
+	log.Println("  POST   /api/v1/parties/:id/control    - Play/pause/seek/transfer (host)")
+	log.Println("  GET    /api/v1/streams/:id/session    - Start playback session (experiment cohorts)")
+	log.Println("  POST   /api/v1/streams/:id/preview-session - Preview without counting as a viewer (operators)")
+	log.Println("  GET    /api/v1/streams/:id/preview-session/watch - Preview over SSE")
+	log.Println("  POST   /api/v1/qoe/beacons            - Playback QoE beacon")
+	log.Println("  POST   /api/v1/experiments            - Create A/B experiment (admin)")
+	log.Println("  GET    /api/v1/experiments/:id        - Experiment results per cohort (admin)")
+	log.Println("  POST   /api/v1/preflight              - Start broadcaster preflight check")
+	log.Println("  GET    /api/v1/preflight/:id          - Preflight readiness report")
+	log.Println("  POST   /api/v1/streams/:id/debug/capture - Toggle raw RTP capture (admin)")
+	log.Println("  GET    /api/v1/debug/captures         - List RTP captures (admin)")
+	log.Println("  GET    /api/v1/archives/:id           - Archive manifest")
+	log.Println("  POST   /api/v1/archives/:id/restore   - Restore archived asset")
+	log.Println("")
+	log.Println("  GET    /api/v1/me                     - Current account")
+	log.Println("  GET    /api/v1/users                  - List accounts (admin)")
+	log.Println("  GET    /auth/login/:provider          - SSO login (OIDC)")
+	log.Println("  POST   /api/v1/auth/token             - Exchange OIDC ID token for API token")
+	log.Println("  POST   /api/v1/{videos,streams}/:id/share - Share asset with a user")
+	log.Println("")
+	log.Println("  GET    /api/v2/streams                - List streams (paged)")
+	log.Println("  POST   /api/v2/streams                - Create stream")
+	log.Println("  GET    /api/v2/streams/:id            - Get stream")
+	log.Println("  DELETE /api/v2/streams/:id            - Delete stream")
+	log.Println("  POST   /api/v2/streams/:id/{start,stop} - Start or stop stream")
+	log.Println("  GET    /api/v2/videos                 - List videos (paged)")
+	log.Println("  GET    /api/v2/videos/:id             - Get video")
 	log.Println("")
 	log.Println("  GET    /health                        - Health check")
+	log.Println("  GET    /ready                         - Readiness and upstream health")
 	log.Println("")
 
-	if err := router.Run(addr); err != nil {
+	server := &http.Server{Addr: addr, Handler: service.Handler(), TLSConfig: tlsConfig}
+	if tlsCert != "" {
+		err = server.ListenAndServeTLS(tlsCert, tlsKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
-func setupRouter(videoHandler *handlers.VideoHandler, broadcastHandler *handlers.BroadcastHandler, hlsProxyHandler *handlers.HLSProxyHandler) *gin.Engine {
-	// Set Gin mode
-	if os.Getenv("GIN_MODE") == "" {
-		gin.SetMode(gin.ReleaseMode)
+// ingestTLSFromEnv builds the TLS config that asks publishers for a client
+// certificate signed by a CA in INGEST_CLIENT_CA_FILE. Clients without one
+// still connect and authenticate otherwise.
+func ingestTLSFromEnv(serving bool) *tls.Config {
+	caFile := getEnv("INGEST_CLIENT_CA_FILE", "")
+	if caFile == "" {
+		return nil
 	}
-
-	router := gin.Default()
-
-	// CORS configuration
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
-
-	// Health check
-	router.GET("/health", broadcastHandler.HealthCheck)
-
-	// HLS Proxy for CDN (avoid CORS issues in local development)
-	router.GET("/hls-proxy/*path", hlsProxyHandler.ProxyCDN)
-
-	// API v1 routes
-	v1 := router.Group("/api/v1")
-	{
-		// Video routes
-		videos := v1.Group("/videos")
-		{
-			videos.POST("/upload", videoHandler.UploadVideo)
-			videos.GET("", videoHandler.ListVideos)
-			videos.GET("/signed-url", videoHandler.GetSignedURL)
-			videos.DELETE("", videoHandler.DeleteVideo)
-		}
-
-		// HLS proxy route for serving HLS files from private bucket
-		// Format: /api/v1/hls/{videoID}/{filename}
-		v1.GET("/hls/:videoID/:filename", videoHandler.ProxyHLSFile)
-
-		// Broadcast stream routes
-		streams := v1.Group("/streams")
-		{
-			streams.POST("", broadcastHandler.CreateStream)
-			streams.GET("", broadcastHandler.ListStreams)
-			streams.GET("/:id", broadcastHandler.GetStream)
-			streams.POST("/:id/start", broadcastHandler.StartStream)
-			streams.POST("/:id/stop", broadcastHandler.StopStream)
-			streams.GET("/:id/watch", broadcastHandler.WatchStream)
-			streams.GET("/:id/video", broadcastHandler.ProxyVideo)
-			streams.GET("/:id/stats", broadcastHandler.GetStreamStats)
-			streams.POST("/:id/chunk", broadcastHandler.UploadStreamChunk)
-			streams.DELETE("/:id", broadcastHandler.DeleteStream)
-
-			// WebRTC routes for live streaming
-			streams.POST("/:id/webrtc/offer", broadcastHandler.WebRTCOffer)
-			streams.POST("/:id/webrtc/answer", broadcastHandler.WebRTCAnswer)
-		}
+	if !serving {
+		log.Fatalf("INGEST_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE: client certificates are only seen when the service terminates TLS")
 	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		log.Fatalf("Failed to read INGEST_CLIENT_CA_FILE: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		log.Fatalf("Invalid INGEST_CLIENT_CA_FILE: no PEM certificates")
+	}
+	log.Printf("Ingest client certificates: verified against %s", caFile)
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+	}
+}
 
-	// Serve static files
-	router.Static("/static", "./static")
-	router.LoadHTMLGlob("templates/*")
-
-	// Landing page
-	router.GET("/", func(c *gin.Context) {
-		c.HTML(200, "index.html", gin.H{
-			"title": "Video Broadcast Service",
-		})
-	})
-
-	// Watch page
-	router.GET("/watch", func(c *gin.Context) {
-		c.HTML(200, "watch.html", gin.H{
-			"title": "Stream Viewer",
-		})
-	})
-
-	// Watch page with stream ID parameter
-	router.GET("/watch/:streamId", func(c *gin.Context) {
-		streamId := c.Param("streamId")
-		c.HTML(200, "watch.html", gin.H{
-			"title":    "Stream Viewer",
-			"streamId": streamId,
-		})
-	})
-
-	// Player page with stream ID parameter (minimal UI)
-	router.GET("/player/:streamId", func(c *gin.Context) {
-		streamId := c.Param("streamId")
-		c.HTML(200, "player.html", gin.H{
-			"title":    "Video Player",
-			"streamId": streamId,
-		})
-	})
-
-	// Live camera broadcast page
-	router.GET("/live", func(c *gin.Context) {
-		c.HTML(200, "live.html", gin.H{
-			"title": "Live Camera Broadcast",
+// iceServersFromEnv builds the STUN/TURN server list from
+// WEBRTC_STUN_URLS and WEBRTC_TURN_URLS (comma separated)
+func iceServersFromEnv() []webrtc.ICEServer {
+	servers := []webrtc.ICEServer{{
+		URLs: strings.Split(getEnv("WEBRTC_STUN_URLS", "stun:stun.l.google.com:19302"), ","),
+	}}
+	if turnURLs := getEnv("WEBRTC_TURN_URLS", ""); turnURLs != "" {
+		servers = append(servers, webrtc.ICEServer{
+			URLs:       strings.Split(turnURLs, ","),
+			Username:   getEnv("WEBRTC_TURN_USERNAME", ""),
+			Credential: getEnv("WEBRTC_TURN_CREDENTIAL", ""),
 		})
-	})
-
-	return router
+	}
+	return servers
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"live-video/pkg/upstream"
+)
+
+// How an extra audio input is used
+const (
+	AudioModeMix       = "mix"       // mixed into the program audio of every rendition
+	AudioModeAlternate = "alternate" // offered as an alternate audio rendition
+)
+
+// AudioRenditionPrefix starts the rendition name of an alternate audio input
+const AudioRenditionPrefix = "audio_"
+
+// maxAudioInputs bounds the inputs of a stream; each is decoded continuously
+const maxAudioInputs = 4
+
+// maxAudioVolume bounds the gain of an audio input
+const maxAudioVolume = 4.0
+
+var audioInputID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// AudioInput is an extra audio source of a live stream, e.g. a translator
+// feed or commentary
+type AudioInput struct {
+	ID       string  `json:"id"`
+	URL      string  `json:"url"`
+	Mode     string  `json:"mode"`
+	Volume   float64 `json:"volume"`             // gain, 1 (the default) keeps the level
+	Language string  `json:"language,omitempty"` // e.g. "es", for alternate renditions
+	Label    string  `json:"label,omitempty"`    // shown in players, for alternate renditions
+}
+
+// Rendition returns the rendition name of an alternate audio input
+func (a AudioInput) Rendition() string {
+	return AudioRenditionPrefix + a.ID
+}
+
+// IsAudioRendition reports whether name can be the rendition of an alternate
+// audio input
+func IsAudioRendition(name string) bool {
+	id, ok := strings.CutPrefix(name, AudioRenditionPrefix)
+	return ok && audioInputID.MatchString(id)
+}
+
+// ValidateAudioInputs checks the audio inputs of a stream and fills in
+// defaults: mix mode, volume 1 and the ID as label. Their URLs must be
+// allowed by policy, like the other sources the server pulls.
+func ValidateAudioInputs(ctx context.Context, inputs []AudioInput, policy upstream.Policy) ([]AudioInput, error) {
+	if len(inputs) > maxAudioInputs {
+		return nil, fmt.Errorf("at most %d audio inputs", maxAudioInputs)
+	}
+	validated := make([]AudioInput, 0, len(inputs))
+	seen := make(map[string]bool)
+	for _, input := range inputs {
+		if !audioInputID.MatchString(input.ID) {
+			return nil, fmt.Errorf("audio input id %q must be lowercase letters, digits and dashes", input.ID)
+		}
+		if seen[input.ID] {
+			return nil, fmt.Errorf("duplicate audio input %s", input.ID)
+		}
+		seen[input.ID] = true
+
+		scheme, _, _ := strings.Cut(input.URL, "://")
+		switch scheme {
+		case "http", "https", "rtmp", "rtmps", "srt":
+		default:
+			return nil, fmt.Errorf("audio input %s needs an http(s), rtmp(s) or srt url", input.ID)
+		}
+		if err := policy.Check(ctx, input.URL); err != nil {
+			return nil, fmt.Errorf("audio input %s not allowed: %w", input.ID, err)
+		}
+		switch input.Mode {
+		case "":
+			input.Mode = AudioModeMix
+		case AudioModeMix, AudioModeAlternate:
+		default:
+			return nil, fmt.Errorf("audio input %s: unknown mode %q", input.ID, input.Mode)
+		}
+		if input.Volume == 0 {
+			input.Volume = 1
+		}
+		if input.Volume < 0 || input.Volume > maxAudioVolume {
+			return nil, fmt.Errorf("audio input %s: volume must be between 0 and %g", input.ID, maxAudioVolume)
+		}
+		if len(input.Language) > 16 || strings.ContainsAny(input.Language, "\",\n") {
+			return nil, fmt.Errorf("audio input %s: invalid language", input.ID)
+		}
+		if input.Label == "" {
+			input.Label = input.ID
+		}
+		if strings.ContainsAny(input.Label, "\"\n") {
+			return nil, fmt.Errorf("audio input %s: label must not contain quotes or newlines", input.ID)
+		}
+		validated = append(validated, input)
+	}
+	return validated, nil
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CaptionRenditionPrefix starts the rendition name of a caption track
+const CaptionRenditionPrefix = "captions_"
+
+// maxCaptionLanguages bounds the languages captions are translated to; each
+// is translated for every segment
+const maxCaptionLanguages = 4
+
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8}){0,2}$`)
+
+// CaptionSettings turns on live captions of a stream: its speech is
+// transcribed, in whatever language is detected, and translated to Languages
+type CaptionSettings struct {
+	Enabled   bool     `json:"enabled"`
+	Languages []string `json:"languages,omitempty"` // e.g. ["es", "fr"], translated to
+}
+
+// CaptionRendition returns the rendition name of the caption track of a
+// language
+func CaptionRendition(language string) string {
+	return CaptionRenditionPrefix + language
+}
+
+// IsCaptionRendition reports whether name can be the rendition of a caption
+// track
+func IsCaptionRendition(name string) bool {
+	language, ok := strings.CutPrefix(name, CaptionRenditionPrefix)
+	return ok && languageTag.MatchString(language)
+}
+
+// NormalizeLanguage returns a language tag in lowercase, e.g. "pt-br" for
+// "pt-BR", and whether it is one
+func NormalizeLanguage(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	return tag, languageTag.MatchString(tag)
+}
+
+// ValidateCaptions checks the caption settings of a stream and normalizes
+// their languages
+func ValidateCaptions(settings CaptionSettings) (CaptionSettings, error) {
+	if len(settings.Languages) > maxCaptionLanguages {
+		return settings, fmt.Errorf("at most %d caption languages", maxCaptionLanguages)
+	}
+	languages := make([]string, 0, len(settings.Languages))
+	seen := make(map[string]bool)
+	for _, tag := range settings.Languages {
+		language, ok := NormalizeLanguage(tag)
+		if !ok {
+			return settings, fmt.Errorf("invalid caption language %q", tag)
+		}
+		if !seen[language] {
+			seen[language] = true
+			languages = append(languages, language)
+		}
+	}
+	if len(languages) == 0 {
+		languages = nil
+	}
+	settings.Languages = languages
+	return settings, nil
+}
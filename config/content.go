@@ -0,0 +1,24 @@
+package config
+
+import "fmt"
+
+// Content types a live ladder is tuned for. Screen content (slides, code,
+// documents) is mostly still and full of sharp edges: x264's stillimage
+// tuning spends the bitrate on detail instead of motion, keyframes come only
+// at segment starts, and lanczos downscaling keeps small text readable on
+// the lower rungs.
+const (
+	ContentCamera = "camera"
+	ContentScreen = "screen"
+)
+
+// ValidateContentType checks a content type, "" meaning camera
+func ValidateContentType(contentType string) (string, error) {
+	switch contentType {
+	case "":
+		return ContentCamera, nil
+	case ContentCamera, ContentScreen:
+		return contentType, nil
+	}
+	return "", fmt.Errorf("content type must be %s or %s", ContentCamera, ContentScreen)
+}
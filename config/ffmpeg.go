@@ -11,9 +11,34 @@ type FFmpegConfig struct {
 	// Enable low-latency HLS
 	LowLatencyMode bool `json:"low_latency_mode" default:"false"`
 
+	// Names and container of the segments
+	Segments SegmentNaming `json:"segments"`
+
+	// First media sequence number, e.g. to continue after a slate
+	StartNumber int `json:"start_number" default:"0"`
+
+	// Frame rate of raw IVF input, whose timestamps FFmpeg can't rely on
+	InputFramerate int `json:"input_framerate" default:"30"`
+
+	// Lay the ladder out for portrait input: each profile is Height wide
+	// and Width tall, e.g. 1080x1920
+	Portrait bool `json:"portrait" default:"false"`
+
 	// ABR ladder profiles
 	Profiles []TranscodeProfile `json:"profiles"`
 
+	// Extra audio sources mixed into the program audio or offered as
+	// alternate audio renditions
+	AudioInputs []AudioInput `json:"audio_inputs,omitempty"`
+
+	// Encode each rung twice, unmarked and with a faint mark, so playlists
+	// can pick the variant of every segment by the viewer's session; see
+	// WatermarkVariant
+	Watermark bool `json:"watermark" default:"false"`
+
+	// What the input shows, ContentCamera or ContentScreen; "" is camera
+	ContentType string `json:"content_type,omitempty"`
+
 	// Recording settings
 	Recording RecordingConfig `json:"recording"`
 
@@ -28,10 +53,18 @@ type TranscodeProfile struct {
 	Height       int    `json:"height"`        // Video height
 	VideoBitrate int    `json:"video_bitrate"` // Video bitrate in kbps
 	AudioBitrate int    `json:"audio_bitrate"` // Audio bitrate in kbps
-	Framerate    int    `json:"framerate"`     // Target framerate
+	Framerate    int    `json:"framerate"`     // Highest framerate, see OutputRate; 0 keeps the source's
 	Preset       string `json:"preset"`        // FFmpeg preset: ultrafast, fast, medium
 }
 
+// Size returns the output size of the profile, swapped for portrait video
+func (p TranscodeProfile) Size(portrait bool) (width, height int) {
+	if portrait {
+		return p.Height, p.Width
+	}
+	return p.Width, p.Height
+}
+
 // RecordingConfig defines recording settings
 type RecordingConfig struct {
 	Enabled      bool   `json:"enabled"`
@@ -43,9 +76,15 @@ type RecordingConfig struct {
 // GCSConfig defines Google Cloud Storage settings
 type GCSConfig struct {
 	Bucket          string `json:"bucket"`
-	BasePath        string `json:"base_path"`        // e.g., "upload/videos"
+	BasePath        string `json:"base_path"`        // e.g., "live"
 	PublicURL       string `json:"public_url"`       // CDN URL
 	SegmentLifetime int    `json:"segment_lifetime"` // Hours to keep segments
+
+	// Cloud CDN signed URLs and cookies; unsigned without a key name
+	CDNKeyName      string `json:"cdn_key_name"`      // Signing key name of the CDN backend
+	CDNKey          string `json:"cdn_key"`           // Base64url-encoded 128-bit key
+	CDNSignedTTL    int    `json:"cdn_signed_ttl"`    // Seconds signed URLs and cookies are valid
+	CDNCookieDomain string `json:"cdn_cookie_domain"` // Domain signed cookies are set for, e.g. "example.com"
 }
 
 // DefaultFFmpegConfig returns default configuration
@@ -54,6 +93,8 @@ func DefaultFFmpegConfig() *FFmpegConfig {
 		SegmentDuration: 4,
 		PlaylistSize:    5,
 		LowLatencyMode:  false,
+		InputFramerate:  30,
+		Segments:        DefaultSegmentNaming(),
 		Profiles: []TranscodeProfile{
 			{
 				Name:         "1080p",
@@ -61,7 +102,7 @@ func DefaultFFmpegConfig() *FFmpegConfig {
 				Height:       1080,
 				VideoBitrate: 5000,
 				AudioBitrate: 128,
-				Framerate:    30,
+				Framerate:    60,
 				Preset:       "veryfast",
 			},
 			{
@@ -70,7 +111,7 @@ func DefaultFFmpegConfig() *FFmpegConfig {
 				Height:       720,
 				VideoBitrate: 2800,
 				AudioBitrate: 128,
-				Framerate:    30,
+				Framerate:    60,
 				Preset:       "veryfast",
 			},
 			{
@@ -100,9 +141,10 @@ func DefaultFFmpegConfig() *FFmpegConfig {
 		},
 		GCS: GCSConfig{
 			Bucket:          "ingka-vugc-infra-dev-assets",
-			BasePath:        "upload/videos",
+			BasePath:        "live",
 			PublicURL:       "https://cdn.dev-vugc.ingka.com/preview/video",
-			SegmentLifetime: 24, // 24 hours
+			SegmentLifetime: 24,   // 24 hours
+			CDNSignedTTL:    3600, // 1 hour
 		},
 	}
 }
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Rate is a frame rate as a fraction, e.g. 30000/1001 for NTSC 29.97fps
+type Rate struct {
+	Num int
+	Den int
+}
+
+// ParseRate parses a frame rate as FFmpeg prints it, e.g. "25/1" or
+// "30000/1001", or as a plain number
+func ParseRate(s string) (Rate, error) {
+	num, den, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		den = "1"
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid frame rate %q", s)
+	}
+	d, err := strconv.Atoi(den)
+	if err != nil || n < 0 || d <= 0 {
+		return Rate{}, fmt.Errorf("invalid frame rate %q", s)
+	}
+	return Rate{Num: n, Den: d}.reduce(), nil
+}
+
+// Known reports whether the rate is set
+func (r Rate) Known() bool {
+	return r.Num > 0 && r.Den > 0
+}
+
+// Float returns the rate in frames per second
+func (r Rate) Float() float64 {
+	if !r.Known() {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// String returns the rate as FFmpeg options take it
+func (r Rate) String() string {
+	if r.Den == 1 {
+		return strconv.Itoa(r.Num)
+	}
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// Frames returns the number of frames in seconds, at least 1
+func (r Rate) Frames(seconds int) int {
+	return max(1, int(math.Round(r.Float()*float64(seconds))))
+}
+
+func (r Rate) reduce() Rate {
+	a, b := r.Num, r.Den
+	for b != 0 {
+		a, b = b, a%b
+	}
+	if a <= 1 {
+		return r
+	}
+	return Rate{Num: r.Num / a, Den: r.Den / a}
+}
+
+// OutputRate returns the frame rate the profile encodes a source at. Sources
+// up to the profile's Framerate keep their rate, so 25 and 50fps stay PAL
+// rates; faster sources drop to an integer fraction of their rate, e.g.
+// 60fps to 30 or 50fps to 25, which drops whole frames evenly instead of
+// juddering. An unknown source rate gives the profile's Framerate.
+func (p TranscodeProfile) OutputRate(source Rate) Rate {
+	switch {
+	case p.Framerate <= 0:
+		return source
+	case !source.Known():
+		return Rate{Num: p.Framerate, Den: 1}
+	}
+	// Allow for NTSC rates just above a limit, e.g. 30.03
+	n := max(1, int(math.Ceil(source.Float()/(float64(p.Framerate)*1.001))))
+	return Rate{Num: source.Num, Den: source.Den * n}.reduce()
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Segment container formats
+const (
+	SegmentsMPEGTS = "mpegts"
+	SegmentsFMP4   = "fmp4" // fragmented MP4 with an initialization section
+)
+
+// Default segment names: segment_000.ts, segment_001.ts, ... and init.mp4
+const (
+	DefaultSegmentTemplate = "segment_{number:3}"
+	DefaultInitName        = "init.mp4"
+)
+
+// Placeholders of segment name templates
+const (
+	renditionPlaceholder = "{rendition}"
+	maxTemplateLength    = 64
+)
+
+var (
+	// numberPlaceholder is {number}, or {number:N} padded to N digits
+	numberPlaceholder = regexp.MustCompile(`\{number(?::([1-9]))?\}`)
+	// templateName is what is left of a template without its placeholders
+	templateName = regexp.MustCompile(`^[A-Za-z0-9_.-]*$`)
+)
+
+// SegmentNaming names the HLS segments and fMP4 initialization sections
+// FFmpeg writes, for live streams and VOD alike. Players and uploaders take
+// the names from the playlists, so any naming works everywhere.
+type SegmentNaming struct {
+	Template string `json:"template"` // segment name without extension, with {number} and optionally {rendition}
+	Format   string `json:"format"`   // SegmentsMPEGTS or SegmentsFMP4
+	Init     string `json:"init"`     // initialization section of fMP4 segments, optionally with {rendition}
+}
+
+// DefaultSegmentNaming returns MPEG-TS segments named by DefaultSegmentTemplate
+func DefaultSegmentNaming() SegmentNaming {
+	return SegmentNaming{Template: DefaultSegmentTemplate, Format: SegmentsMPEGTS, Init: DefaultInitName}
+}
+
+// WithDefaults fills in what n leaves empty from DefaultSegmentNaming
+func (n SegmentNaming) WithDefaults() SegmentNaming {
+	defaults := DefaultSegmentNaming()
+	if n.Template == "" {
+		n.Template = defaults.Template
+	}
+	if n.Format == "" {
+		n.Format = defaults.Format
+	}
+	if n.Init == "" {
+		n.Init = defaults.Init
+	}
+	return n
+}
+
+// Validate checks a naming with its defaults filled in
+func (n SegmentNaming) Validate() error {
+	n = n.WithDefaults()
+	if n.Format != SegmentsMPEGTS && n.Format != SegmentsFMP4 {
+		return fmt.Errorf("unsupported segment format %q, use mpegts or fmp4", n.Format)
+	}
+	if len(n.Template) > maxTemplateLength || len(n.Init) > maxTemplateLength {
+		return fmt.Errorf("segment names are limited to %d characters", maxTemplateLength)
+	}
+	if !numberPlaceholder.MatchString(n.Template) {
+		return fmt.Errorf("segment template %q has no {number}", n.Template)
+	}
+	if !templateName.MatchString(strip(n.Template)) {
+		return fmt.Errorf("segment template %q may only use letters, digits, '.', '_', '-', {rendition} and {number}", n.Template)
+	}
+	if numberPlaceholder.MatchString(n.Init) || !strings.HasSuffix(n.Init, ".mp4") || !templateName.MatchString(strip(n.Init)) {
+		return fmt.Errorf("init name %q must end in .mp4 and may only use letters, digits, '.', '_', '-' and {rendition}", n.Init)
+	}
+	return nil
+}
+
+// strip removes the placeholders of a template
+func strip(template string) string {
+	return numberPlaceholder.ReplaceAllString(strings.ReplaceAll(template, renditionPlaceholder, ""), "")
+}
+
+// Ext returns the extension of the segments
+func (n SegmentNaming) Ext() string {
+	if n.Format == SegmentsFMP4 {
+		return ".m4s"
+	}
+	return ".ts"
+}
+
+// WithPrefix returns the naming with prefix before every name
+func (n SegmentNaming) WithPrefix(prefix string) SegmentNaming {
+	n = n.WithDefaults()
+	n.Template = prefix + n.Template
+	n.Init = prefix + n.Init
+	return n
+}
+
+// SharedDirectory returns the naming of renditions that write to the same
+// directory, whose segment names then differ by rendition
+func (n SegmentNaming) SharedDirectory() SegmentNaming {
+	n = n.WithDefaults()
+	if !strings.Contains(n.Template, renditionPlaceholder) {
+		n.Template = renditionPlaceholder + "_" + n.Template
+	}
+	return n
+}
+
+// SegmentPattern returns the FFmpeg file name pattern of the segments of
+// rendition, e.g. "%v" for each rendition of a ladder
+func (n SegmentNaming) SegmentPattern(rendition string) string {
+	return pattern(n.Template, rendition) + n.Ext()
+}
+
+// InitPattern returns the FFmpeg file name pattern of the initialization
+// section of rendition. FFmpeg writes one per rendition next to its
+// playlist, so a rendition is always part of the name.
+func (n SegmentNaming) InitPattern(rendition string) string {
+	init := n.Init
+	if rendition != "" && !strings.Contains(init, renditionPlaceholder) {
+		init = strings.TrimSuffix(init, ".mp4") + "_" + renditionPlaceholder + ".mp4"
+	}
+	return pattern(init, rendition)
+}
+
+// pattern replaces the placeholders of a template with FFmpeg's
+func pattern(template, rendition string) string {
+	name := strings.ReplaceAll(template, renditionPlaceholder, rendition)
+	return numberPlaceholder.ReplaceAllStringFunc(name, func(placeholder string) string {
+		if digits := numberPlaceholder.FindStringSubmatch(placeholder)[1]; digits != "" {
+			return "%0" + digits + "d"
+		}
+		return "%d"
+	})
+}
+
+// HLSArgs returns the FFmpeg HLS muxer options writing the segments of
+// rendition to dir
+func (n SegmentNaming) HLSArgs(dir, rendition string) []string {
+	n = n.WithDefaults()
+	args := []string{"-hls_segment_type", n.Format}
+	if n.Format == SegmentsFMP4 {
+		args = append(args, "-hls_fmp4_init_filename", n.InitPattern(rendition))
+	}
+	return append(args, "-hls_segment_filename", filepath.Join(dir, n.SegmentPattern(rendition)))
+}
@@ -0,0 +1,65 @@
+package config
+
+import "fmt"
+
+// tsOverhead is the share MPEG-TS packaging adds to the encoded bitrate
+const tsOverhead = 0.1
+
+// h264Levels are the H.264 levels renditions are encoded at, with the
+// largest frame in macroblocks and the most macroblocks per second each
+// allows
+var h264Levels = []struct {
+	level     int // times 10, e.g. 31 for 3.1
+	maxFrame  int
+	maxPerSec int
+}{
+	{30, 1620, 40500},
+	{31, 3600, 108000},
+	{32, 5120, 216000},
+	{40, 8192, 245760},
+	{42, 8704, 522240},
+	{50, 22080, 589824},
+	{51, 36864, 983040},
+	{52, 36864, 2073600},
+}
+
+// h264Level returns the lowest level a width x height video at rate fits
+func h264Level(width, height int, rate Rate) int {
+	frame := ((width + 15) / 16) * ((height + 15) / 16)
+	perSec := int(float64(frame) * rate.Float())
+	for _, l := range h264Levels {
+		if frame <= l.maxFrame && perSec <= l.maxPerSec {
+			return l.level
+		}
+	}
+	return h264Levels[len(h264Levels)-1].level
+}
+
+// InputRate returns the frame rate renditions are encoded from
+func (c *FFmpegConfig) InputRate() Rate {
+	return Rate{Num: c.InputFramerate, Den: 1}
+}
+
+// Codecs returns the CODECS attribute of the profile's renditions: H.264
+// High at the level its size and frame rate need, and AAC-LC audio
+func (c *FFmpegConfig) Codecs(p TranscodeProfile) string {
+	width, height := p.Size(c.Portrait)
+	return fmt.Sprintf("avc1.6400%02x,mp4a.40.2", h264Level(width, height, p.OutputRate(c.InputRate())))
+}
+
+// Bandwidth returns the peak and average bandwidth of the profile's
+// renditions in bits per second. Video is capped at its bitrate, so the
+// peak is the average plus MPEG-TS overhead.
+func (p TranscodeProfile) Bandwidth() (peak, average int) {
+	average = (p.VideoBitrate + p.AudioBitrate) * 1000
+	return int(float64(average) * (1 + tsOverhead)), average
+}
+
+// StreamInf returns the EXT-X-STREAM-INF attributes of the profile's
+// renditions
+func (c *FFmpegConfig) StreamInf(p TranscodeProfile) string {
+	peak, average := p.Bandwidth()
+	width, height := p.Size(c.Portrait)
+	return fmt.Sprintf("BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\",FRAME-RATE=%.3f",
+		peak, average, width, height, c.Codecs(p), p.OutputRate(c.InputRate()).Float())
+}
@@ -0,0 +1,21 @@
+package config
+
+import "fmt"
+
+// watermarkVariantSuffix names the folder of a rendition's marked variant
+const watermarkVariantSuffix = "_b"
+
+// WatermarkVariant returns the rendition name of the marked (B) variant of a
+// rendition. The unmarked A variant keeps the rendition's own name.
+func WatermarkVariant(rendition string) string {
+	return rendition + watermarkVariantSuffix
+}
+
+// WatermarkFilter returns the video filter that marks the B variant of a
+// width x height rendition: a faint block near the top right corner, too
+// light to notice while playing but plain when a frame is compared with the
+// same frame of the A variant
+func WatermarkFilter(width, height int) string {
+	size := max(height/24, 8)
+	return fmt.Sprintf("drawbox=x=%d:y=%d:w=%d:h=%d:color=white@0.04:t=fill", width-width/12-size, height/12, size, size)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Versions of the API. The JSON contracts of each only gain fields; removing
+// a field or changing its meaning takes a new version.
+const (
+	V1 = 1 // routes under /api/v1
+	V2 = 2 // routes under /api/v2
+)
+
+// VersionHeader carries the API version on every API response
+const VersionHeader = "X-API-Version"
+
+// versionKey is the context key of the API version of a request
+const versionKey = "api_version"
+
+// Versioned marks the routes of a group as serving an API version: responses
+// carry VersionHeader and failures take the version's error shape
+func Versioned(version int) gin.HandlerFunc {
+	header := strconv.Itoa(version)
+	return func(c *gin.Context) {
+		c.Set(versionKey, version)
+		c.Header(VersionHeader, header)
+		c.Next()
+	}
+}
+
+// VersionOf returns the API version of a request: the one its route group
+// set, or for middleware running before the group the one its path names.
+// Anything else is V1.
+func VersionOf(c *gin.Context) int {
+	if version := c.GetInt(versionKey); version > 0 {
+		return version
+	}
+	if strings.HasPrefix(c.Request.URL.Path, V2Prefix+"/") || c.Request.URL.Path == V2Prefix {
+		return V2
+	}
+	return V1
+}
+
+// ErrorResponse is the body of a failed /api/v1 request
+type ErrorResponse struct {
+	Success bool   `json:"success"` // always false
+	Error   string `json:"error"`
+}
+
+// Error returns the /api/v1 response of a request that failed with message
+func Error(message string) ErrorResponse {
+	return ErrorResponse{Error: message}
+}
+
+// Fail aborts a request with status and message in the error shape of its
+// API version
+func Fail(c *gin.Context, status int, message string) {
+	FailWith(c, status, message, nil)
+}
+
+// FailWith aborts a request like Fail, with details about the failure. In
+// /api/v1 the details are fields next to the error; in /api/v2 they are the
+// error's details.
+func FailWith(c *gin.Context, status int, message string, details map[string]any) {
+	if VersionOf(c) >= V2 {
+		c.AbortWithStatusJSON(status, V2Error{Error: V2ErrorDetail{
+			Code:    ErrorCode(status),
+			Message: message,
+			Details: details,
+		}})
+		return
+	}
+	if len(details) == 0 {
+		c.AbortWithStatusJSON(status, Error(message))
+		return
+	}
+	body := make(map[string]any, len(details)+2)
+	for key, value := range details {
+		body[key] = value
+	}
+	body["success"] = false
+	body["error"] = message
+	c.AbortWithStatusJSON(status, body)
+}
+
+// MessageResponse is the body of a request that succeeded with nothing to
+// return but a message
+type MessageResponse struct {
+	Success bool   `json:"success"` // always true
+	Message string `json:"message"`
+}
+
+// Message returns the response of a request that succeeded with message
+func Message(message string) MessageResponse {
+	return MessageResponse{Success: true, Message: message}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"live-video/pkg/broadcast"
+)
+
+// StreamResource is a stream as the API returns it: a snapshot of its state
+// and the URLs of its sub-resources
+type StreamResource struct {
+	*broadcast.StreamSnapshot
+	Links StreamLinks `json:"links"`
+}
+
+// StreamLinks are the URLs of a stream's sub-resources
+type StreamLinks struct {
+	Self           string `json:"self"`
+	Watch          string `json:"watch"`
+	Playback       string `json:"playback"`
+	MasterPlaylist string `json:"master_playlist"`
+	Stats          string `json:"stats"`
+	Player         string `json:"player"`
+}
+
+// NewStreamResource returns the resource of a stream snapshot
+func NewStreamResource(snap *broadcast.StreamSnapshot) StreamResource {
+	return StreamResource{StreamSnapshot: snap, Links: NewStreamLinks(snap.ID)}
+}
+
+// NewStreamLinks returns the URLs of the sub-resources of a stream
+func NewStreamLinks(streamID string) StreamLinks {
+	self := "/api/v1/streams/" + streamID
+	return StreamLinks{
+		Self:           self,
+		Watch:          self + "/watch",
+		Playback:       self + "/playback",
+		MasterPlaylist: self + "/master.m3u8",
+		Stats:          self + "/stats",
+		Player:         "/player/" + streamID,
+	}
+}
+
+// StreamResponse is the response of requests returning a stream
+type StreamResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message,omitempty"`
+	Stream  StreamResource `json:"stream"`
+}
+
+// NewStreamResponse returns the response of a request returning a stream,
+// with an optional message
+func NewStreamResponse(snap *broadcast.StreamSnapshot, message string) StreamResponse {
+	return StreamResponse{Success: true, Message: message, Stream: NewStreamResource(snap)}
+}
+
+// StreamListResponse is the response of requests listing streams
+type StreamListResponse struct {
+	Success bool             `json:"success"`
+	Count   int              `json:"count"`
+	Streams []StreamResource `json:"streams"`
+}
+
+// NewStreamListResponse returns the response listing snaps
+func NewStreamListResponse(snaps []*broadcast.StreamSnapshot) StreamListResponse {
+	streams := make([]StreamResource, 0, len(snaps))
+	for _, snap := range snaps {
+		streams = append(streams, NewStreamResource(snap))
+	}
+	return StreamListResponse{Success: true, Count: len(streams), Streams: streams}
+}
+
+// CreateStreamResponse is the response of creating a stream. The flat
+// fields predate Stream and are kept for existing clients.
+type CreateStreamResponse struct {
+	Success   bool                   `json:"success"`
+	Message   string                 `json:"message"`
+	StreamID  string                 `json:"stream_id"`
+	VideoURL  string                 `json:"video_url"`
+	Status    broadcast.StreamStatus `json:"status"`
+	StreamURL string                 `json:"stream_url"`
+	WatchURL  string                 `json:"watch_url"`
+	Stream    StreamResource         `json:"stream"`
+	SRT       *broadcast.SRTEndpoint `json:"srt,omitempty"` // where to send SRT, with its passphrase
+}
+
+// NewCreateStreamResponse returns the response of creating the stream of snap
+func NewCreateStreamResponse(snap *broadcast.StreamSnapshot) CreateStreamResponse {
+	stream := NewStreamResource(snap)
+	return CreateStreamResponse{
+		Success:   true,
+		Message:   "Stream created successfully",
+		StreamID:  snap.ID,
+		VideoURL:  snap.VideoURL,
+		Status:    snap.Status,
+		StreamURL: stream.Links.Self,
+		WatchURL:  stream.Links.Watch,
+		Stream:    stream,
+	}
+}
+
+// StreamStatusResponse is the response of requests changing a stream's status
+type StreamStatusResponse struct {
+	Success bool                   `json:"success"`
+	Message string                 `json:"message,omitempty"`
+	Status  broadcast.StreamStatus `json:"status"`
+}
+
+// CleanupResponse is the response of requests tearing a stream down
+type CleanupResponse struct {
+	Success bool                     `json:"success"`
+	Message string                   `json:"message"`
+	Cleanup *broadcast.CleanupReport `json:"cleanup"`
+}
+
+// StreamStatsResponse is the response of a stream's statistics
+type StreamStatsResponse struct {
+	Success bool                      `json:"success"`
+	Stats   *broadcast.StreamSnapshot `json:"stats"`
+}
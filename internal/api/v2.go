@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-video/pkg/broadcast"
+	"live-video/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route prefixes of the API versions
+const (
+	V1Prefix = "/api/v1"
+	V2Prefix = "/api/v2"
+)
+
+// V2Error is the body of a failed /api/v2 request. Successful requests
+// return the resource itself, without an envelope.
+type V2Error struct {
+	Error V2ErrorDetail `json:"error"`
+}
+
+// V2ErrorDetail describes why a request failed. Code is stable and meant for
+// programs; Message is meant for people and may change.
+type V2ErrorDetail struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// ErrorCode returns the /api/v2 error code of an HTTP status
+func ErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusUnauthorized:
+		return "unauthenticated"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return "unavailable"
+	}
+	if status >= 500 {
+		return "internal"
+	}
+	return "invalid_request"
+}
+
+// Page sizes of /api/v2 listings
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// PageRequest is the page a listing asks for with ?limit= and ?cursor=
+type PageRequest struct {
+	Limit int
+	after string // key of the last item of the previous page
+}
+
+// ParsePage returns the page a listing request asks for
+func ParsePage(c *gin.Context) (PageRequest, error) {
+	req := PageRequest{Limit: DefaultPageSize}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxPageSize {
+			return req, fmt.Errorf("limit must be between 1 and %d", MaxPageSize)
+		}
+		req.Limit = limit
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(after) == 0 {
+			return req, fmt.Errorf("invalid cursor")
+		}
+		req.after = string(after)
+	}
+	return req, nil
+}
+
+// Page is a page of an /api/v2 listing. Passing NextCursor as ?cursor=
+// fetches the next page; it is empty on the last one.
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Paginate returns the page of items req asks for, in ascending order of
+// key, which must be unique. Cursors carry the key of the last item
+// returned, so paging stays consistent while items are added or removed.
+func Paginate[T any](items []T, key func(T) string, req PageRequest) Page[T] {
+	keyed := make([]string, len(items))
+	order := make([]int, len(items))
+	for i, item := range items {
+		keyed[i] = key(item)
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return strings.Compare(keyed[a], keyed[b]) })
+
+	page := Page[T]{Data: make([]T, 0, min(req.Limit, len(items)))}
+	for _, i := range order {
+		if req.after != "" && keyed[i] <= req.after {
+			continue
+		}
+		if len(page.Data) == req.Limit {
+			page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(key(page.Data[len(page.Data)-1])))
+			break
+		}
+		page.Data = append(page.Data, items[i])
+	}
+	return page
+}
+
+// timeKey returns a key ordering items by time, then ID
+func timeKey(at time.Time, id string) string {
+	return fmt.Sprintf("%020d/%s", at.UnixNano(), id)
+}
+
+// NewV2StreamResource returns the /api/v2 resource of a stream snapshot.
+// Links to sub-resources without a v2 route lead to their v1 route.
+func NewV2StreamResource(snap *broadcast.StreamSnapshot) StreamResource {
+	stream := NewStreamResource(snap)
+	stream.Links.Self = V2Prefix + "/streams/" + snap.ID
+	return stream
+}
+
+// NewV2StreamPage returns a page of streams, oldest first
+func NewV2StreamPage(snaps []*broadcast.StreamSnapshot, req PageRequest) Page[StreamResource] {
+	page := Paginate(snaps, func(snap *broadcast.StreamSnapshot) string { return timeKey(snap.CreatedAt, snap.ID) }, req)
+	streams := Page[StreamResource]{Data: make([]StreamResource, 0, len(page.Data)), NextCursor: page.NextCursor}
+	for _, snap := range page.Data {
+		streams.Data = append(streams.Data, NewV2StreamResource(snap))
+	}
+	return streams
+}
+
+// NewV2VideoResource returns the /api/v2 resource of a video
+func NewV2VideoResource(video *storage.VideoMetadata, videoID string) VideoResource {
+	resource := NewVideoResource(video, videoID)
+	resource.Links.Self = V2Prefix + "/videos/" + videoID
+	return resource
+}
+
+// NewV2VideoPage returns a page of videos, oldest first. videoID returns the
+// ID a video's objects are stored under.
+func NewV2VideoPage(videos []*storage.VideoMetadata, videoID func(*storage.VideoMetadata) string, req PageRequest) Page[VideoResource] {
+	page := Paginate(videos, func(video *storage.VideoMetadata) string { return timeKey(video.UploadedAt, videoID(video)) }, req)
+	resources := Page[VideoResource]{Data: make([]VideoResource, 0, len(page.Data)), NextCursor: page.NextCursor}
+	for _, video := range page.Data {
+		resources.Data = append(resources.Data, NewV2VideoResource(video, videoID(video)))
+	}
+	return resources
+}
+
+// Deprecation is what clients are told about routes of an older API version
+// that have a successor: since when they are deprecated and when they go
+// away. A zero time is not announced.
+type Deprecation struct {
+	Since  time.Time
+	Sunset time.Time
+}
+
+// Deprecated links the responses of routes in successors, keyed by method
+// and route pattern ("GET /api/v1/streams/:id"), to the route replacing them
+// ("/api/v2/streams/:id") with a successor-version Link header, and marks
+// them with the Deprecation (RFC 9745) and Sunset (RFC 8594) headers of d.
+// Routes without a successor are left alone, so clients can move over one
+// route at a time.
+func Deprecated(d Deprecation, successors map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		successor, ok := successors[c.Request.Method+" "+c.FullPath()]
+		if ok {
+			for _, param := range c.Params {
+				successor = strings.ReplaceAll(successor, ":"+param.Key, param.Value)
+			}
+			c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+			if !d.Since.IsZero() {
+				c.Header("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+			}
+			if !d.Sunset.IsZero() {
+				c.Header("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+		c.Next()
+	}
+}
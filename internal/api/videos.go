@@ -0,0 +1,53 @@
+package api
+
+import (
+	"live-video/pkg/storage"
+)
+
+// VideoResource is an uploaded video as the API returns it
+type VideoResource struct {
+	*storage.VideoMetadata
+	Links VideoLinks `json:"links"`
+}
+
+// VideoLinks are the URLs of a video's sub-resources
+type VideoLinks struct {
+	Self     string `json:"self,omitempty"` // in /api/v2, which has a route per video
+	Download string `json:"download"`
+	Frame    string `json:"frame"`
+	Original string `json:"original"`
+	Usage    string `json:"usage"`
+}
+
+// NewVideoResource returns the resource of a video. videoID is the ID its
+// objects are stored under, which older metadata doesn't carry.
+func NewVideoResource(video *storage.VideoMetadata, videoID string) VideoResource {
+	self := "/api/v1/videos/" + videoID
+	return VideoResource{
+		VideoMetadata: video,
+		Links: VideoLinks{
+			Download: self + "/download",
+			Frame:    self + "/frame",
+			Original: self + "/original",
+			Usage:    self + "/usage",
+		},
+	}
+}
+
+// VideoListResponse is the response of requests listing videos
+type VideoListResponse struct {
+	Success bool            `json:"success"`
+	Count   int             `json:"count"`
+	Videos  []VideoResource `json:"videos"`
+}
+
+// UploadVideoResponse is the response of uploading a video, with the stream
+// created for it when asked to
+type UploadVideoResponse struct {
+	Success   bool          `json:"success"`
+	Message   string        `json:"message"`
+	JobID     string        `json:"job_id"` // progress of the upload was reported under it
+	Video     VideoResource `json:"video"`
+	StreamID  string        `json:"stream_id,omitempty"`
+	StreamURL string        `json:"stream_url,omitempty"`
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/archive"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ArchiveHandler handles archival and restore of streams and videos
+type ArchiveHandler struct {
+	archiver         *archive.Archiver
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+}
+
+// NewArchiveHandler creates a new archive handler
+func NewArchiveHandler(archiver *archive.Archiver, broadcastManager *broadcast.BroadcastManager, authService *auth.Service) *ArchiveHandler {
+	return &ArchiveHandler{
+		archiver:         archiver,
+		broadcastManager: broadcastManager,
+		authService:      authService,
+	}
+}
+
+// ArchiveStream stops a stream, moves its output to cold storage and removes
+// it from the registry. The manifest keeps what is needed to re-register it.
+func (h *ArchiveHandler) ArchiveStream(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	if stream.CurrentStatus().Active() {
+		stream.Stop()
+	}
+
+	// The snapshot's video URL is the HLS playlist when there is one
+	snap := stream.Snapshot()
+	record := &archive.StreamRecord{
+		VideoURL:       cmp.Or(snap.OriginalVideoURL, snap.VideoURL),
+		HLSPlaylistURL: snap.HLSPlaylistURL,
+		GCSPath:        snap.GCSPath,
+		VideoDuration:  snap.VideoDuration,
+	}
+
+	manifest, err := h.archiver.Archive(c.Request.Context(), streamID, record)
+	if err != nil {
+		log.Printf("[Archive] Failed to archive stream %s: %v", streamID, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to archive stream")
+		return
+	}
+
+	// The archived output stays where it is, in its colder storage class
+	h.broadcastManager.DeleteStream(streamID, true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Stream archived",
+		"manifest": manifest,
+	})
+}
+
+// ArchiveVideo moves an uploaded video to cold storage
+func (h *ArchiveHandler) ArchiveVideo(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionManage) {
+		return
+	}
+
+	manifest, err := h.archiver.Archive(c.Request.Context(), videoID, nil)
+	if err != nil {
+		log.Printf("[Archive] Failed to archive video %s: %v", videoID, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to archive video")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "Video archived",
+		"manifest": manifest,
+	})
+}
+
+// GetManifest returns the archive manifest of an asset
+func (h *ArchiveHandler) GetManifest(c *gin.Context) {
+	if !h.requireArchiveAccess(c, c.Param("id"), auth.PermissionRead) {
+		return
+	}
+
+	manifest, err := h.archiver.GetManifest(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Archive not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"manifest": manifest,
+	})
+}
+
+// RestoreArchive re-hydrates an archived asset to hot storage and
+// re-registers archived streams
+func (h *ArchiveHandler) RestoreArchive(c *gin.Context) {
+	assetID := c.Param("id")
+	if !h.requireArchiveAccess(c, assetID, auth.PermissionManage) {
+		return
+	}
+
+	manifest, report, err := h.archiver.Restore(c.Request.Context(), assetID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Archive not found")
+		return
+	}
+
+	response := gin.H{
+		"success": len(report.MissingObjects) == 0 && len(report.ChecksumMismatch) == 0,
+		"report":  report,
+	}
+
+	if manifest.Stream != nil {
+		rec := manifest.Stream
+		stream, err := h.broadcastManager.RegisterStream(assetID, rec.VideoURL, rec.HLSPlaylistURL, rec.GCSPath)
+		if err != nil {
+			log.Printf("[Archive] Stream %s not re-registered: %v", assetID, err)
+		} else {
+			if rec.VideoDuration > 0 {
+				stream.SetVideoDuration(rec.VideoDuration)
+			}
+			response["stream"] = api.NewStreamResource(stream.Snapshot())
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// requireArchiveAccess checks access to an archived asset, which may have been
+// either a stream or a video
+func (h *ArchiveHandler) requireArchiveAccess(c *gin.Context, assetID string, perm auth.Permission) bool {
+	user := currentUser(c)
+	if h.authService.Can(user, auth.ResourceStream, assetID, perm) {
+		return true
+	}
+	return requirePermission(c, h.authService, auth.ResourceVideo, assetID, perm)
+}
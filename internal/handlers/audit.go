@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"live-video/internal/api"
+	"live-video/pkg/audit"
+	"live-video/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditHandler starts integrity audits of the stored assets and returns
+// their reports
+type AuditHandler struct {
+	auditor     *audit.Auditor
+	authService *auth.Service
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(auditor *audit.Auditor, authService *auth.Service) *AuditHandler {
+	return &AuditHandler{
+		auditor:     auditor,
+		authService: authService,
+	}
+}
+
+// StartAudit starts an audit on this replica outside the schedule. It
+// answers at once with the running audit; its report is read with GetAudit.
+// Admin only.
+func (h *AuditHandler) StartAudit(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+	report, err := h.auditor.Start("manual")
+	if errors.Is(err, audit.ErrRunning) {
+		api.Fail(c, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("[Audit] Failed to start audit: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to start audit")
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"audit":   report,
+	})
+}
+
+// ListAudits lists the reports of past audits, newest first, without their
+// findings. Admin only.
+func (h *AuditHandler) ListAudits(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+	reports, err := h.auditor.List(c.Request.Context())
+	if err != nil {
+		log.Printf("[Audit] Failed to list audit reports: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to list audits")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"audits":  reports,
+	})
+}
+
+// GetAudit returns the report of an audit with its findings. Admin only.
+func (h *AuditHandler) GetAudit(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+	report, err := h.auditor.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, os.ErrNotExist) {
+		api.Fail(c, http.StatusNotFound, "Audit not found")
+		return
+	}
+	if err != nil {
+		log.Printf("[Audit] Failed to read audit report %s: %v", c.Param("id"), err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to read audit")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"audit":   report,
+	})
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextUserKey is the gin context key holding the authenticated *auth.User
+const contextUserKey = "auth.user"
+
+// sessionCookieName is the cookie holding the SSO session token for the web UI
+const sessionCookieName = "lv_session"
+
+// AuthMiddleware resolves the caller's API key or SSO session token to a
+// user. Requests without credentials pass through anonymously so public
+// viewer endpoints keep working; handlers decide what requires an account.
+func AuthMiddleware(authService *auth.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-API-Key")
+		if authz := c.GetHeader("Authorization"); token == "" && strings.HasPrefix(authz, "Bearer ") {
+			token = strings.TrimPrefix(authz, "Bearer ")
+		}
+		if token == "" {
+			token, _ = c.Cookie(sessionCookieName)
+		}
+
+		if token != "" && authService.Enabled() {
+			user, err := authService.AuthenticateToken(token)
+			if err != nil {
+				api.Fail(c, http.StatusUnauthorized, "Invalid API key or session")
+				return
+			}
+			c.Set(contextUserKey, user)
+		}
+
+		c.Next()
+	}
+}
+
+// BearerAuth authenticates /api/v2 requests. Unlike AuthMiddleware it only
+// takes an "Authorization: Bearer" token, never the session cookie, so a
+// browser's session can't be used to make calls on its behalf, and it
+// refuses anonymous requests while accounts are enabled.
+func BearerAuth(authService *auth.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authService.Enabled() {
+			c.Next()
+			return
+		}
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="api"`)
+			api.Fail(c, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		user, err := authService.AuthenticateToken(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			api.Fail(c, http.StatusUnauthorized, "Invalid API key or session")
+			return
+		}
+		c.Set(contextUserKey, user)
+		c.Next()
+	}
+}
+
+// currentUser returns the authenticated user, or nil for anonymous callers
+func currentUser(c *gin.Context) *auth.User {
+	if value, exists := c.Get(contextUserKey); exists {
+		if user, ok := value.(*auth.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// requireAccount aborts with 401 when accounts are enabled and the caller is anonymous
+func requireAccount(c *gin.Context, authService *auth.Service) bool {
+	if authService.Enabled() && currentUser(c) == nil {
+		api.Fail(c, http.StatusUnauthorized, "Authentication required")
+		return false
+	}
+	return true
+}
+
+// requirePermission aborts with 401/403 unless the caller holds perm on the resource
+func requirePermission(c *gin.Context, authService *auth.Service, kind, resourceID string, perm auth.Permission) bool {
+	if !requireAccount(c, authService) {
+		return false
+	}
+	if !authService.Can(currentUser(c), kind, resourceID, perm) {
+		api.Fail(c, http.StatusForbidden, "You do not have access to this "+kind)
+		return false
+	}
+	return true
+}
+
+// requireAdmin aborts with 401/403 unless the caller is an admin. With
+// accounts disabled every caller is treated as an admin.
+func requireAdmin(c *gin.Context, authService *auth.Service) bool {
+	if !requireAccount(c, authService) {
+		return false
+	}
+	if user := currentUser(c); user != nil && user.Role != auth.RoleAdmin {
+		api.Fail(c, http.StatusForbidden, "Admin role required")
+		return false
+	}
+	return true
+}
+
+// isAdmin reports whether the caller is an admin, as every caller is with
+// accounts disabled
+func isAdmin(c *gin.Context, authService *auth.Service) bool {
+	if !authService.Enabled() {
+		return true
+	}
+	user := currentUser(c)
+	return user != nil && user.Role == auth.RoleAdmin
+}
+
+// listScopeAll reports whether a list request should return every asset
+// instead of only the caller's own. Only admins may ask for scope=all, and
+// with accounts disabled every list is unscoped.
+func listScopeAll(c *gin.Context, authService *auth.Service) bool {
+	if !authService.Enabled() {
+		return true
+	}
+	user := currentUser(c)
+	return user != nil && user.Role == auth.RoleAdmin && c.Query("scope") == "all"
+}
+
+// AccountHandler handles account and sharing requests
+type AccountHandler struct {
+	authService *auth.Service
+}
+
+// NewAccountHandler creates a new account handler
+func NewAccountHandler(authService *auth.Service) *AccountHandler {
+	return &AccountHandler{
+		authService: authService,
+	}
+}
+
+// ShareRequest grants a user access to a resource
+type ShareRequest struct {
+	UserID     string          `json:"user_id" binding:"required"`
+	Permission auth.Permission `json:"permission" binding:"required"`
+}
+
+// GetMe returns the authenticated user
+func (h *AccountHandler) GetMe(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"auth_enabled": h.authService.Enabled(),
+		"user":         currentUser(c),
+	})
+}
+
+// ListUsers returns all accounts (admin only)
+func (h *AccountHandler) ListUsers(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"users":   h.authService.ListUsers(),
+		"teams":   h.authService.ListTeams(),
+	})
+}
+
+// ShareStream grants a user read or manage rights on a stream
+func (h *AccountHandler) ShareStream(c *gin.Context) {
+	h.share(c, auth.ResourceStream)
+}
+
+// UnshareStream revokes a user's grant on a stream
+func (h *AccountHandler) UnshareStream(c *gin.Context) {
+	h.unshare(c, auth.ResourceStream)
+}
+
+// ShareVideo grants a user read or manage rights on a video
+func (h *AccountHandler) ShareVideo(c *gin.Context) {
+	h.share(c, auth.ResourceVideo)
+}
+
+// UnshareVideo revokes a user's grant on a video
+func (h *AccountHandler) UnshareVideo(c *gin.Context) {
+	h.unshare(c, auth.ResourceVideo)
+}
+
+// ShareCollection grants a user read or manage rights on a collection
+func (h *AccountHandler) ShareCollection(c *gin.Context) {
+	h.share(c, auth.ResourceCollection)
+}
+
+// UnshareCollection revokes a user's grant on a collection
+func (h *AccountHandler) UnshareCollection(c *gin.Context) {
+	h.unshare(c, auth.ResourceCollection)
+}
+
+// GetStreamAccess returns the ownership record of a stream
+func (h *AccountHandler) GetStreamAccess(c *gin.Context) {
+	h.getAccess(c, auth.ResourceStream)
+}
+
+// GetVideoAccess returns the ownership record of a video
+func (h *AccountHandler) GetVideoAccess(c *gin.Context) {
+	h.getAccess(c, auth.ResourceVideo)
+}
+
+// GetCollectionAccess returns the ownership record of a collection
+func (h *AccountHandler) GetCollectionAccess(c *gin.Context) {
+	h.getAccess(c, auth.ResourceCollection)
+}
+
+func (h *AccountHandler) share(c *gin.Context, kind string) {
+	resourceID := c.Param("id")
+	if !requirePermission(c, h.authService, kind, resourceID, auth.PermissionManage) {
+		return
+	}
+
+	var req ShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.authService.Share(kind, resourceID, req.UserID, req.Permission); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Access granted",
+	})
+}
+
+func (h *AccountHandler) unshare(c *gin.Context, kind string) {
+	resourceID := c.Param("id")
+	if !requirePermission(c, h.authService, kind, resourceID, auth.PermissionManage) {
+		return
+	}
+
+	h.authService.Unshare(kind, resourceID, c.Param("userId"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Access revoked",
+	})
+}
+
+func (h *AccountHandler) getAccess(c *gin.Context, kind string) {
+	resourceID := c.Param("id")
+	if !requirePermission(c, h.authService, kind, resourceID, auth.PermissionRead) {
+		return
+	}
+
+	ownership, exists := h.authService.GetOwnership(kind, resourceID)
+	if !exists {
+		api.Fail(c, http.StatusNotFound, "No ownership record")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"ownership": ownership,
+	})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/billing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BillingHandler reports the usage metered for billing
+type BillingHandler struct {
+	meter       *billing.Meter // nil without a billing export
+	authService *auth.Service
+}
+
+// NewBillingHandler creates a new billing handler
+func NewBillingHandler(meter *billing.Meter, authService *auth.Service) *BillingHandler {
+	return &BillingHandler{
+		meter:       meter,
+		authService: authService,
+	}
+}
+
+// GetBillingUsage returns this replica's usage per tenant in the period
+// not exported yet (admin)
+func (h *BillingHandler) GetBillingUsage(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+	if h.meter == nil {
+		api.Fail(c, http.StatusNotFound, "Billing export is not configured")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"records": h.meter.Current(),
+	})
+}
@@ -1,53 +1,219 @@
 package handlers
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
 	"live-video/pkg/broadcast"
+	"live-video/pkg/captions"
+	"live-video/pkg/events"
+	"live-video/pkg/geoip"
+	"live-video/pkg/integrity"
 	"live-video/pkg/orchestrator"
+	"live-video/pkg/prefetch"
+	"live-video/pkg/qoe"
+	"live-video/pkg/region"
+	"live-video/pkg/slate"
 	"live-video/pkg/storage"
+	"live-video/pkg/upstream"
+	"live-video/pkg/viewers"
+	"live-video/pkg/watermark"
+	"live-video/pkg/webrtc"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
+// sseBandwidthBatch is how much SSE data is timed at once for the bandwidth
+// estimate of a viewer
+const sseBandwidthBatch = 256 << 10
+
 // BroadcastHandler handles broadcast-related HTTP requests
 type BroadcastHandler struct {
 	broadcastManager *broadcast.BroadcastManager
 	gcsService       *storage.GCSService
+	authService      *auth.Service
+	embedSigner      *auth.EmbedSigner
+	audience         *geoip.Audience
+	warmPool         *orchestrator.WarmPool
+	loadGovernor     *orchestrator.LoadGovernor
+	segmentCache     *prefetch.Cache
+	primer           *slate.Primer
+	signer           *integrity.Signer
+	reconciliation   *ReconciliationReport
+	bandwidth        *qoe.Bandwidth
+	cdnSigner        *storage.CDNSigner
+	failoverWindow   time.Duration   // default input failover window
+	sourcePolicy     upstream.Policy // hosts pulled inputs may come from
+	recognizer       captions.Recognizer
+	translator       captions.Translator
+	events           *events.Bus
+	history          *viewers.History
+	segments         config.SegmentNaming
+	watermarks       *watermark.Ledger
+	previews         *qoe.Previews
+	regions          *region.Selector
 }
 
 // NewBroadcastHandler creates a new broadcast handler
-func NewBroadcastHandler(broadcastManager *broadcast.BroadcastManager, gcsService *storage.GCSService) *BroadcastHandler {
+func NewBroadcastHandler(broadcastManager *broadcast.BroadcastManager, gcsService *storage.GCSService, authService *auth.Service, embedSigner *auth.EmbedSigner, audience *geoip.Audience) *BroadcastHandler {
 	return &BroadcastHandler{
 		broadcastManager: broadcastManager,
 		gcsService:       gcsService,
+		authService:      authService,
+		embedSigner:      embedSigner,
+		audience:         audience,
 	}
 }
 
+// SetWarmPool makes starting streams claim warm transcoders from pool
+func (h *BroadcastHandler) SetWarmPool(pool *orchestrator.WarmPool) {
+	h.warmPool = pool
+}
+
+// SetLoadGovernor makes started pipelines degrade their encoding under load
+func (h *BroadcastHandler) SetLoadGovernor(g *orchestrator.LoadGovernor) {
+	h.loadGovernor = g
+}
+
+// SetSourcePolicy restricts the hosts of the sources streams are set to
+// pull, by default to public addresses
+func (h *BroadcastHandler) SetSourcePolicy(policy upstream.Policy) {
+	h.sourcePolicy = policy
+}
+
+// SetEvents makes started pipelines publish what they do on bus
+func (h *BroadcastHandler) SetEvents(bus *events.Bus) {
+	h.events = bus
+}
+
+// SetSegmentCache reports the HLS proxy's segment cache in health checks
+func (h *BroadcastHandler) SetSegmentCache(cache *prefetch.Cache) {
+	h.segmentCache = cache
+}
+
+// SetPrimer makes starting streams take over the slate primer published
+func (h *BroadcastHandler) SetPrimer(primer *slate.Primer) {
+	h.primer = primer
+}
+
+// SetSegmentNaming sets how live pipelines name their segments
+func (h *BroadcastHandler) SetSegmentNaming(segments config.SegmentNaming) {
+	h.segments = segments
+}
+
+// SetSigner makes live playlists carry a detached signature
+func (h *BroadcastHandler) SetSigner(signer *integrity.Signer) {
+	h.signer = signer
+}
+
+// SetBandwidth makes SSE playback count towards the bandwidth estimate of the
+// playback session given as ?session_id=, and playback info report it
+func (h *BroadcastHandler) SetBandwidth(bandwidth *qoe.Bandwidth) {
+	h.bandwidth = bandwidth
+}
+
+// SetHistory records SSE and HLS playback of signed in viewers, and of
+// sessions linked to them, in their watch history
+func (h *BroadcastHandler) SetHistory(history *viewers.History) {
+	h.history = history
+}
+
+// SetCDNSigner makes playback hand out CDN URLs and cookies signed with
+// signer, for CDN backends that only serve signed requests
+func (h *BroadcastHandler) SetCDNSigner(signer *storage.CDNSigner) {
+	h.cdnSigner = signer
+}
+
 // CreateStreamRequest represents the create stream request
 type CreateStreamRequest struct {
-	VideoURL       string  `json:"video_url" binding:"required"`
-	HLSPlaylistURL string  `json:"hls_playlist_url"`
-	GCSPath        string  `json:"gcs_path"`
-	VideoDuration  float64 `json:"video_duration"` // Video duration in seconds for synchronized playback
+	VideoURL       string     `json:"video_url" binding:"required"`
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	HLSPlaylistURL string     `json:"hls_playlist_url"`
+	GCSPath        string     `json:"gcs_path"`
+	VideoDuration  float64    `json:"video_duration"` // Video duration in seconds for synchronized playback
+	MaxViewers     int        `json:"max_viewers"`    // 0 = unlimited
+	WaitingRoom    bool       `json:"waiting_room"`   // queue viewers over max_viewers
+	ScheduledAt    *time.Time `json:"scheduled_at"`   // announced start, primed with a slate
+	SRT            bool       `json:"srt"`            // listen for an SRT encoder besides WebRTC
+	RTSPURL        string     `json:"rtsp_url"`       // IP camera to pull instead of a WebRTC broadcaster
+	SourceURL      string     `json:"source_url"`     // remote HLS or MP4 to restream instead of a WebRTC broadcaster
+	ContentType    string     `json:"content_type"`   // camera (default) or screen
+}
+
+// StreamMetadataRequest updates what viewers see of a stream
+type StreamMetadataRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ViewerLimitRequest updates a stream's viewer limit
+type ViewerLimitRequest struct {
+	MaxViewers  int  `json:"max_viewers"`
+	WaitingRoom bool `json:"waiting_room"`
 }
 
 // CreateStream creates a new broadcast stream
 func (h *BroadcastHandler) CreateStream(c *gin.Context) {
+	stream, ok := h.createStream(c)
+	if !ok {
+		return
+	}
+	response := api.NewCreateStreamResponse(stream.Snapshot())
+	response.SRT = h.broadcastManager.SRTEndpoint(stream)
+	c.JSON(http.StatusCreated, response)
+}
+
+// createStream creates the stream a request asks for, answering when it can't
+func (h *BroadcastHandler) createStream(c *gin.Context) (*broadcast.Stream, bool) {
+	if !requireAccount(c, h.authService) {
+		return nil, false
+	}
+
 	var req CreateStreamRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid request body",
-		})
-		return
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+
+	contentType, err := config.ValidateContentType(req.ContentType)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if req.SRT && !h.broadcastManager.SRTEnabled() {
+		api.Fail(c, http.StatusBadRequest, broadcast.ErrSRTDisabled.Error())
+		return nil, false
+	}
+	var inputs []orchestrator.Input
+	switch {
+	case req.RTSPURL != "" && req.SourceURL != "":
+		api.Fail(c, http.StatusBadRequest, "Set either rtsp_url or source_url")
+		return nil, false
+	case req.RTSPURL != "":
+		inputs = []orchestrator.Input{{Kind: orchestrator.InputRTSP, URL: req.RTSPURL}}
+	case req.SourceURL != "":
+		inputs = []orchestrator.Input{{Kind: orchestrator.InputRemote, URL: req.SourceURL}}
+	}
+	if inputs != nil {
+		if inputs, err = orchestrator.ValidateInputs(c.Request.Context(), inputs, h.sourcePolicy); err != nil {
+			api.Fail(c, http.StatusBadRequest, err.Error())
+			return nil, false
+		}
+	}
+
+	if err := h.broadcastManager.CheckCapacity(callerTenant(c)); err != nil {
+		admissionRefused(c, err)
+		return nil, false
 	}
 
 	// Auto-convert GCS URLs to proxy URLs for private bucket access
@@ -80,77 +246,334 @@ func (h *BroadcastHandler) CreateStream(c *gin.Context) {
 		// Fallback to original video
 		stream = h.broadcastManager.CreateStream(videoURL, req.GCSPath)
 	}
+	if req.SRT {
+		if _, err := h.broadcastManager.EnableSRT(stream); err != nil {
+			log.Printf("[Broadcast] Failed to enable SRT for stream %s: %v", stream.ID, err)
+			h.broadcastManager.DeleteStream(stream.ID, false)
+			api.Fail(c, http.StatusServiceUnavailable, "No SRT port is free, try again later")
+			return nil, false
+		}
+	}
+	h.authService.SetOwner(auth.ResourceStream, stream.ID, currentUser(c))
+	if inputs != nil {
+		stream.SetInputs(inputs, 0)
+	}
+	if req.Name != "" || req.Description != "" {
+		stream.SetMetadata(req.Name, req.Description)
+	}
 
 	// Set video duration if provided for synchronized playback
 	if req.VideoDuration > 0 {
 		stream.SetVideoDuration(req.VideoDuration)
 		log.Printf("Stream %s created with duration: %.2fs", stream.ID, req.VideoDuration)
 	}
+	if req.MaxViewers > 0 {
+		stream.SetViewerLimit(req.MaxViewers, req.WaitingRoom)
+	}
+	if req.ScheduledAt != nil {
+		stream.SetSchedule(req.ScheduledAt)
+	}
+	if contentType != config.ContentCamera {
+		stream.SetContentType(contentType)
+	}
+	return stream, true
+}
+
+// CreateRedundantStream creates a primary/backup stream pair fed by two
+// ingests. Viewers watch the primary and are failed over to the backup when
+// the primary stops producing segments.
+func (h *BroadcastHandler) CreateRedundantStream(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	var req CreateStreamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.broadcastManager.CheckCapacity(callerTenant(c)); err != nil {
+		admissionRefused(c, err)
+		return
+	}
+
+	primary, backup := h.broadcastManager.CreateRedundantPair(req.VideoURL, req.HLSPlaylistURL, req.GCSPath)
+	user := currentUser(c)
+	ingests := make([]gin.H, 0, 2)
+	for _, stream := range []*broadcast.Stream{primary, backup} {
+		h.authService.SetOwner(auth.ResourceStream, stream.ID, user)
+		if req.VideoDuration > 0 {
+			stream.SetVideoDuration(req.VideoDuration)
+		}
+
+		role := broadcast.RolePrimary
+		if stream == backup {
+			role = broadcast.RoleBackup
+		}
+		ingests = append(ingests, gin.H{
+			"role":          role,
+			"stream_id":     stream.ID,
+			"stream_key":    stream.StreamKey,
+			"broadcast_url": fmt.Sprintf("/live?stream=%s&key=%s", stream.ID, stream.StreamKey),
+		})
+	}
+	log.Printf("Redundant stream %s created with backup %s", primary.ID, backup.ID)
 
 	c.JSON(http.StatusCreated, gin.H{
-		"success":    true,
-		"message":    "Stream created successfully",
-		"stream_id":  stream.ID,
-		"video_url":  stream.VideoURL,
-		"status":     stream.Status,
-		"stream_url": fmt.Sprintf("/api/v1/streams/%s", stream.ID),
-		"watch_url":  fmt.Sprintf("/api/v1/streams/%s/watch", stream.ID),
+		"success":             true,
+		"message":             "Redundant stream created successfully",
+		"stream_id":           primary.ID,
+		"stream_url":          fmt.Sprintf("/api/v1/streams/%s", primary.ID),
+		"watch_url":           fmt.Sprintf("/api/v1/streams/%s/watch", primary.ID),
+		"playback_url":        fmt.Sprintf("/api/v1/streams/%s/playback", primary.ID),
+		"master_playlist_url": fmt.Sprintf("/api/v1/streams/%s/master.m3u8", primary.ID),
+		"ingests":             ingests,
 	})
 }
 
-// StartStream starts broadcasting a stream
-func (h *BroadcastHandler) StartStream(c *gin.Context) {
+// GetPlayback returns the playback descriptor of a stream: the playlist
+// viewers should load, for redundant streams every source in failover order,
+// and for signed in viewers of replays where to resume
+func (h *BroadcastHandler) GetPlayback(c *gin.Context) {
 	streamID := c.Param("id")
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+	mode, err := playbackMode(c, h.embedSigner, stream)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if err := stream.Start(); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
+	sources, err := h.broadcastManager.PlaybackSources(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	playbackSources := make([]gin.H, 0, len(sources))
+	for _, source := range sources {
+		sourceURL := h.gcsService.GetHLSMasterPlaylistURL(source.StreamID)
+		if h.cdnSigner != nil {
+			// The cookie signs the relative URIs of the CDN's playlists
+			prefix := h.cdnPrefix(source.StreamID)
+			sourceURL += "?" + h.cdnSigner.PrefixQuery(prefix)
+			http.SetCookie(c.Writer, h.cdnSigner.Cookie(prefix))
+		}
+		playbackSources = append(playbackSources, gin.H{
+			"role":            source.Role,
+			"stream_id":       source.StreamID,
+			"live":            source.Live,
+			"last_segment_at": source.LastSegmentAt,
+			"playlist_url":    sourceURL,
 		})
+	}
+
+	active := stream.ActiveStreamID()
+	playlistURL := h.gcsService.GetHLSMasterPlaylistURL(active)
+	if stream.HasPlaylistWindows() || h.cdnSigner != nil {
+		// The bucket's playlists are not trimmed to the viewer's window, and
+		// their segment URIs are not signed
+		playlistURL = fmt.Sprintf("/api/v1/streams/%s/master.m3u8", streamID)
+	}
+	// The session's mode decides what the player plays
+	modes := playbackModeOptions(stream, sources[0].Live, playlistURL)
+	for _, option := range modes {
+		if option["mode"] == mode {
+			playlistURL = option["url"].(string)
+		}
+	}
+	response := gin.H{
+		"success":             true,
+		"stream_id":           streamID,
+		"active_stream_id":    active,
+		"mode":                mode,
+		"modes":               modes,
+		"playlist_url":        playlistURL,
+		"master_playlist_url": fmt.Sprintf("/api/v1/streams/%s/master.m3u8", streamID),
+		"sources":             playbackSources,
+	}
+	if h.cdnSigner != nil {
+		response["cdn_signed_until"] = h.cdnSigner.Expires()
+	}
+	sessionID := c.Query("session_id")
+	addBandwidth(response, h.bandwidth, sessionID, nil)
+	addResume(response, h.history, viewerOf(c, h.history, sessionID), streamID)
+	c.JSON(http.StatusOK, response)
+}
+
+// MasterPlaylist serves an HLS master playlist advertising every source of a
+// stream, the active one first. ?ladder=720p,480p limits the renditions.
+func (h *BroadcastHandler) MasterPlaylist(c *gin.Context) {
+	stream, err := h.broadcastManager.GetStream(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
 		return
 	}
+	sessionID := c.Query("session_id")
+	if !h.previews.Of(sessionID, stream.ID) {
+		h.history.Record(viewerOf(c, h.history, sessionID), viewers.Play{
+			Kind:      viewers.KindStream,
+			ID:        stream.ID,
+			SessionID: sessionID,
+			Position:  -1,
+		})
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Stream started",
-		"stream":  stream.GetStats(),
-	})
+	sources, err := h.broadcastManager.PlaybackSources(stream.ID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	var ladder []string
+	if value := c.Query("ladder"); value != "" {
+		ladder = strings.Split(value, ",")
+	}
+
+	// Every playback of a watermarked stream is a session of its own
+	var watermarkID string
+	if stream.Watermark() && h.watermarks != nil {
+		session, err := h.beginWatermark(c, stream, sessionID)
+		if err != nil {
+			log.Printf("[Watermark] Failed to begin session of stream %s: %v", stream.ID, err)
+			api.Fail(c, http.StatusInternalServerError, "Failed to begin playback session")
+			return
+		}
+		watermarkID = session.ID
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(broadcast.MasterPlaylist(sources, h.variantURL(c, stream, watermarkID), ladder)))
+}
+
+// StartStream starts broadcasting a stream
+func (h *BroadcastHandler) StartStream(c *gin.Context) {
+	stream, ok := h.startStream(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, api.NewStreamResponse(stream.Snapshot(), "Stream started"))
+}
+
+// startStream starts the stream of the request, answering when it can't
+func (h *BroadcastHandler) startStream(c *gin.Context) (*broadcast.Stream, bool) {
+	streamID := c.Param("id")
+	if !h.requireIngest(c, streamID) {
+		return nil, false
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return nil, false
+	}
+
+	if err := h.broadcastManager.StartStream(stream); err != nil {
+		if admissionRefused(c, err) || transitionRefused(c, err) {
+			return nil, false
+		}
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	h.startPullPipeline(stream)
+	return stream, true
 }
 
 // StopStream stops broadcasting a stream
 func (h *BroadcastHandler) StopStream(c *gin.Context) {
+	stream, ok := h.stopStream(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, api.CleanupResponse{Success: true, Message: "Stream stopped", Cleanup: stream.LastCleanup()})
+}
+
+// stopStream stops the stream of the request, answering when it can't
+func (h *BroadcastHandler) stopStream(c *gin.Context) (*broadcast.Stream, bool) {
 	streamID := c.Param("id")
+	if !h.requireIngest(c, streamID) {
+		return nil, false
+	}
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
-		return
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return nil, false
 	}
 
 	if err := stream.Stop(); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		if transitionRefused(c, err) {
+			return nil, false
+		}
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return stream, true
+}
+
+// PauseStream marks a live stream as paused by its broadcaster, so it is not
+// stopped for lack of input while viewers wait
+func (h *BroadcastHandler) PauseStream(c *gin.Context) {
+	h.changeStatus(c, (*broadcast.Stream).Pause, "Stream paused")
+}
+
+// ResumeStream returns a paused stream to live
+func (h *BroadcastHandler) ResumeStream(c *gin.Context) {
+	h.changeStatus(c, (*broadcast.Stream).Resume, "Stream resumed")
+}
+
+// changeStatus applies a lifecycle change to the stream of the request
+func (h *BroadcastHandler) changeStatus(c *gin.Context, change func(*broadcast.Stream) error, message string) {
+	streamID := c.Param("id")
+	if !h.requireIngest(c, streamID) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Stream stopped",
-	})
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	if err := change(stream); err != nil {
+		if transitionRefused(c, err) {
+			return
+		}
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, api.StreamStatusResponse{Success: true, Message: message, Status: stream.Snapshot().Status})
+}
+
+// Heartbeat records broadcaster activity so a live stream whose broadcaster
+// is connected but not sending media is not stopped as abandoned
+func (h *BroadcastHandler) Heartbeat(c *gin.Context) {
+	streamID := c.Param("id")
+	if !h.requireIngest(c, streamID) {
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	stream.Heartbeat()
+	c.JSON(http.StatusOK, api.StreamStatusResponse{Success: true, Status: stream.Snapshot().Status})
 }
 
 // GetStream returns stream information
@@ -159,70 +582,122 @@ func (h *BroadcastHandler) GetStream(c *gin.Context) {
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"stream":  stream.GetStats(),
-	})
+	c.JSON(http.StatusOK, api.NewStreamResponse(playbackSnapshot(h.embedSigner, stream.Snapshot()), ""))
 }
 
-// ListStreams returns all streams
+// ListStreams returns the caller's streams, or all streams for admins with scope=all
 func (h *BroadcastHandler) ListStreams(c *gin.Context) {
+	c.JSON(http.StatusOK, api.NewStreamListResponse(h.listStreams(c)))
+}
+
+// listStreams returns snapshots of the streams the request lists
+func (h *BroadcastHandler) listStreams(c *gin.Context) []*broadcast.StreamSnapshot {
 	streams := h.broadcastManager.ListStreams()
+	all := listScopeAll(c, h.authService)
+	user := currentUser(c)
 
-	streamStats := make([]map[string]interface{}, 0, len(streams))
+	snaps := make([]*broadcast.StreamSnapshot, 0, len(streams))
 	for _, stream := range streams {
-		streamStats = append(streamStats, stream.GetStats())
+		if !all && !h.authService.IsMine(user, auth.ResourceStream, stream.ID) {
+			continue
+		}
+		snaps = append(snaps, stream.Snapshot())
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"count":   len(streams),
-		"streams": streamStats,
-	})
+	return snaps
 }
 
 // DeleteStream deletes a stream
 func (h *BroadcastHandler) DeleteStream(c *gin.Context) {
+	report, ok := h.deleteStream(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, api.CleanupResponse{Success: true, Message: "Stream deleted", Cleanup: report})
+}
+
+// deleteStream deletes the stream of the request, answering when it can't
+func (h *BroadcastHandler) deleteStream(c *gin.Context) (*broadcast.CleanupReport, bool) {
 	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return nil, false
+	}
 
-	if err := h.broadcastManager.DeleteStream(streamID); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
-		return
+	report, err := h.broadcastManager.DeleteStream(streamID, c.Query("keep_output") == "true")
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, err.Error())
+		return nil, false
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Stream deleted",
-	})
+	h.authService.RemoveResource(auth.ResourceStream, streamID)
+	return report, true
 }
 
 // WatchStream handles SSE (Server-Sent Events) for streaming video to viewers
 func (h *BroadcastHandler) WatchStream(c *gin.Context) {
 	streamID := c.Param("id")
-	viewerID := uuid.New().String()
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
 		return
 	}
 
-	viewer := stream.AddViewer()
+	// A reconnect within the session timeout resumes the viewer session.
+	// EventSource sends the last event ID, which is the session token, when
+	// it reconnects on its own.
+	token := c.Query("session")
+	if token == "" {
+		token = c.GetHeader("Last-Event-ID")
+	}
+	viewer, position, err := stream.ResumeViewer(token)
+	resumed := err == nil
+	if !resumed {
+		viewer, position, err = stream.JoinViewer()
+	}
+	if err != nil {
+		api.Fail(c, http.StatusServiceUnavailable, "Stream is at its viewer limit")
+		return
+	}
+	viewerID := viewer.ID
+	h.audience.Seen(c.Request, c.ClientIP(), streamID, viewerID)
+	defer func() {
+		if stream.DisconnectViewer(viewer) {
+			h.audience.Forget(streamID, viewerID)
+		}
+	}()
 
-	defer stream.RemoveViewer(viewer.ID)
+	// Signed in viewers, or their linked sessions, get the stream in their
+	// history, with how long they watched once they leave
+	sessionID := c.Query("session_id")
+	if userID := viewerOf(c, h.history, sessionID); userID != "" {
+		play := viewers.Play{Kind: viewers.KindStream, ID: streamID, SessionID: sessionID, Position: -1}
+		if play.SessionID == "" {
+			play.SessionID = viewerID
+		}
+		h.history.Record(userID, play)
+		joined := time.Now()
+		defer func() {
+			play.Watched = time.Since(joined)
+			h.history.Record(userID, play)
+		}()
+	}
+	if resumed {
+		log.Printf("Viewer %s resumed session on stream %s (reconnect %d)", viewerID, streamID, viewer.Reconnects)
+	}
+	if position > 0 {
+		log.Printf("Viewer %s waiting for stream %s (position %d)", viewerID, streamID, position)
+	}
 
 	// Set headers for SSE
 	c.Header("Content-Type", "text/event-stream")
@@ -230,14 +705,18 @@ func (h *BroadcastHandler) WatchStream(c *gin.Context) {
 	c.Header("Connection", "keep-alive")
 	c.Header("Access-Control-Allow-Origin", "*")
 
-	// Send initial connection message
-	fmt.Fprintf(c.Writer, "data: {\"type\":\"connected\",\"stream_id\":\"%s\",\"viewer_id\":\"%s\"}\n\n", streamID, viewerID)
+	// Send initial connection message. Its event ID is the session token.
+	fmt.Fprintf(c.Writer, "id: %s\ndata: {\"type\":\"connected\",\"stream_id\":\"%s\",\"viewer_id\":\"%s\",\"session_token\":\"%s\",\"session_timeout\":%d,\"resumed\":%t}\n\n",
+		viewer.Token, streamID, viewerID, viewer.Token, int(stream.SessionTimeout().Seconds()), resumed)
 	c.Writer.(http.Flusher).Flush()
 
-	// Stream data to viewer
+	// Stream data to viewer. Writes are timed in batches big enough to fill
+	// the socket buffers for the session's bandwidth estimate.
 	clientClosed := c.Request.Context().Done()
 	ticker := time.NewTicker(30 * time.Second) // Heartbeat
 	defer ticker.Stop()
+	var batchBytes int64
+	var batchTime time.Duration
 
 	for {
 		select {
@@ -246,13 +725,21 @@ func (h *BroadcastHandler) WatchStream(c *gin.Context) {
 				return
 			}
 			// Send data as SSE
-			fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+			start := time.Now()
+			n, _ := fmt.Fprintf(c.Writer, "data: %s\n\n", data)
 			c.Writer.(http.Flusher).Flush()
+			batchBytes += int64(n)
+			batchTime += time.Since(start)
+			if batchBytes >= sseBandwidthBatch {
+				h.bandwidth.Observe(sessionID, batchBytes, batchTime)
+				batchBytes, batchTime = 0, 0
+			}
 
 		case <-ticker.C:
 			// Send heartbeat
 			fmt.Fprintf(c.Writer, ": heartbeat\n\n")
 			c.Writer.(http.Flusher).Flush()
+			h.audience.Seen(c.Request, c.ClientIP(), streamID, viewerID)
 
 		case <-clientClosed:
 			log.Printf("Client disconnected: %s", viewerID)
@@ -261,21 +748,68 @@ func (h *BroadcastHandler) WatchStream(c *gin.Context) {
 	}
 }
 
+// SetViewerLimit changes a stream's viewer limit and waiting-room mode.
+// Raising the limit admits waiting viewers right away.
+func (h *BroadcastHandler) SetViewerLimit(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req ViewerLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.MaxViewers < 0 {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	stream.SetViewerLimit(req.MaxViewers, req.WaitingRoom)
+	c.JSON(http.StatusOK, api.NewStreamResponse(stream.Snapshot(), ""))
+}
+
+// SetStreamMetadata changes the name and description of a stream
+func (h *BroadcastHandler) SetStreamMetadata(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req StreamMetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	stream.SetMetadata(req.Name, req.Description)
+	c.JSON(http.StatusOK, api.NewStreamResponse(stream.Snapshot(), ""))
+}
+
 // ProxyVideo proxies video from GCS to viewer with range support
 func (h *BroadcastHandler) ProxyVideo(c *gin.Context) {
 	streamID := c.Param("id")
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
 		return
 	}
 
 	// Redirect to video URL (can be GCS public URL or signed URL)
-	c.Redirect(http.StatusFound, stream.VideoURL)
+	c.Redirect(http.StatusFound, playbackURL(h.embedSigner, stream.VideoURL))
 }
 
 // GetStreamStats returns detailed stream statistics
@@ -284,19 +818,54 @@ func (h *BroadcastHandler) GetStreamStats(c *gin.Context) {
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
+		api.Fail(c, http.StatusNotFound, "Stream not found")
 		return
 	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+
+	c.JSON(http.StatusOK, api.StreamStatsResponse{Success: true, Stats: playbackSnapshot(h.embedSigner, stream.Snapshot())})
+}
+
+// callerTenant returns the account the caller's live streams count against
+func callerTenant(c *gin.Context) string {
+	if user := currentUser(c); user != nil {
+		return user.ID
+	}
+	return ""
+}
 
-	stats := stream.GetStats()
+// admissionRefused answers 429 with a Retry-After header and the queue
+// position when err is a live stream limit refusal
+func admissionRefused(c *gin.Context, err error) bool {
+	var refused *broadcast.AdmissionError
+	if !errors.As(err, &refused) {
+		return false
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"stats":   stats,
+	c.Header("Retry-After", strconv.Itoa(int(refused.RetryAfter.Seconds())))
+	api.FailWith(c, http.StatusTooManyRequests, fmt.Sprintf("Live stream limit reached for this %s, try again later", refused.Scope), gin.H{
+		"scope":          refused.Scope,
+		"limit":          refused.Limit,
+		"queue_position": refused.QueuePosition,
+		"retry_after":    int(refused.RetryAfter.Seconds()),
 	})
+	return true
+}
+
+// transitionRefused answers 409 with the stream's status when err is a
+// lifecycle change the stream's status does not allow
+func transitionRefused(c *gin.Context, err error) bool {
+	var refused *broadcast.TransitionError
+	if !errors.As(err, &refused) {
+		return false
+	}
+
+	api.FailWith(c, http.StatusConflict, refused.Error(), gin.H{
+		"status": refused.From,
+	})
+	return true
 }
 
 // HealthCheck returns service health status
@@ -305,17 +874,28 @@ func (h *BroadcastHandler) HealthCheck(c *gin.Context) {
 
 	activeCount := 0
 	for _, stream := range streams {
-		if stream.Status == broadcast.StatusStreaming {
+		if stream.CurrentStatus() == broadcast.StatusStreaming {
 			activeCount++
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"status":         "healthy",
 		"total_streams":  len(streams),
 		"active_streams": activeCount,
+		"capacity":       h.broadcastManager.Capacity(),
 		"timestamp":      time.Now().UTC(),
-	})
+	}
+	if h.warmPool != nil {
+		response["warm_pool"] = h.warmPool.Stats()
+	}
+	if h.loadGovernor != nil {
+		response["load"] = h.loadGovernor.Stats()
+	}
+	if h.segmentCache != nil {
+		response["segment_cache"] = h.segmentCache.Stats()
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // StreamVideo streams video content (for HTTP progressive download)
@@ -324,10 +904,10 @@ func (h *BroadcastHandler) StreamVideo(c *gin.Context) {
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
 		return
 	}
 
@@ -336,52 +916,69 @@ func (h *BroadcastHandler) StreamVideo(c *gin.Context) {
 		// Stream from GCS with range support
 		c.Header("Accept-Ranges", "bytes")
 		c.Header("Content-Type", "video/mp4")
-		c.Redirect(http.StatusFound, stream.VideoURL)
+		c.Redirect(http.StatusFound, playbackURL(h.embedSigner, stream.VideoURL))
 		return
 	}
 
-	c.JSON(http.StatusNotFound, gin.H{
-		"success": false,
-		"error":   "Video source not available",
-	})
+	api.Fail(c, http.StatusNotFound, "Video source not available")
 }
 
-// UploadStreamChunk uploads video chunks for live streaming
+// UploadStreamChunk relays a WebM chunk of the broadcaster to the stream's
+// viewers. Chunks carry the publisher's session nonce (X-Chunk-Session) and
+// their number in it (X-Chunk-Sequence) and are delivered in order.
 func (h *BroadcastHandler) UploadStreamChunk(c *gin.Context) {
 	streamID := c.Param("id")
+	if !h.requireIngest(c, streamID) {
+		return
+	}
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
+		api.Fail(c, http.StatusNotFound, "Stream not found")
 		return
 	}
 
-	// Read chunk data
-	data, err := io.ReadAll(c.Request.Body)
+	sequence, err := strconv.ParseUint(c.GetHeader("X-Chunk-Sequence"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Failed to read chunk data",
-		})
+		api.Fail(c, http.StatusBadRequest, "X-Chunk-Sequence must be a chunk number")
 		return
 	}
 
-	// Encode chunk as base64 for JSON transmission
-	encodedData := base64.StdEncoding.EncodeToString(data)
+	// Read chunk data, one byte past the limit so oversized chunks are caught
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, broadcast.MaxChunkSize+1))
+	if err == nil && len(data) > broadcast.MaxChunkSize {
+		err = &http.MaxBytesError{Limit: broadcast.MaxChunkSize}
+	}
+	if limit, ok := tooLarge(err); ok {
+		c.Request.Close = true
+		bodyTooLarge(c, limit)
+		return
+	}
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, "Failed to read chunk data")
+		return
+	}
 
-	// Create chunk message
-	chunkMessage := fmt.Sprintf(`{"type":"chunk","data":"%s"}`, encodedData)
+	result, err := stream.AcceptChunk(c.GetHeader("X-Chunk-Session"), sequence, c.GetHeader("Content-Type"), data)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, broadcast.ErrChunkSession) || errors.Is(err, broadcast.ErrChunkGap) {
+			status = http.StatusConflict
+		}
+		api.FailWith(c, status, err.Error(), gin.H{
+			"next_sequence": result.NextSequence,
+		})
+		return
+	}
 
-	// Broadcast chunk to all viewers
-	stream.Broadcast([]byte(chunkMessage))
+	stream.Heartbeat()
 
 	c.JSON(http.StatusOK, gin.H{
-		"success":      true,
-		"bytes_sent":   len(data),
-		"viewer_count": stream.ViewerCount,
+		"success":       true,
+		"status":        result.Status,
+		"next_sequence": result.NextSequence,
+		"bytes_sent":    len(data),
+		"viewer_count":  stream.Health().ViewerCount,
 	})
 }
 
@@ -390,45 +987,56 @@ type WebRTCOfferRequest struct {
 	SDP string `json:"sdp" binding:"required"`
 }
 
+// requireIngest lets a broadcaster through with a client certificate the
+// stream lists, the stream's ingest key (X-Stream-Key header or ?key=) unless
+// the stream requires a certificate, or manage permission on the stream
+func (h *BroadcastHandler) requireIngest(c *gin.Context, streamID string) bool {
+	key := c.GetHeader("X-Stream-Key")
+	if key == "" {
+		key = c.Query("key")
+	}
+	if stream, err := h.broadcastManager.GetStream(streamID); err == nil {
+		if cert := clientCertificate(c); cert != nil && stream.ValidIngestClient(cert) {
+			return true
+		}
+		if key != "" && !stream.IngestClients().RequireCertificate && stream.ValidStreamKey(key) {
+			return true
+		}
+	}
+	return requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage)
+}
+
 // WebRTCOffer handles WebRTC offer from broadcaster and returns answer
 func (h *BroadcastHandler) WebRTCOffer(c *gin.Context) {
 	streamID := c.Param("id")
+	if !h.requireIngest(c, streamID) {
+		return
+	}
 
 	var req WebRTCOfferRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid request body: " + err.Error(),
-		})
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
+		api.Fail(c, http.StatusNotFound, "Stream not found")
 		return
 	}
 
 	// Get or create WebRTC ingestion service for this stream
 	ingestService := stream.GetWebRTCIngest()
 	if ingestService == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to create WebRTC ingestion service",
-		})
+		api.Fail(c, http.StatusInternalServerError, "Failed to create WebRTC ingestion service")
 		return
 	}
 
 	// Process browser's offer and create answer
+	ingestService.SetUserAgent(c.Request.UserAgent())
 	answerSDP, err := ingestService.HandleOffer(req.SDP)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to handle WebRTC offer: %v", err),
-		})
+		api.Fail(c, http.StatusInternalServerError, fmt.Sprintf("Failed to handle WebRTC offer: %v", err))
 		return
 	}
 
@@ -438,6 +1046,7 @@ func (h *BroadcastHandler) WebRTCOffer(c *gin.Context) {
 		time.Sleep(2 * time.Second) // Wait for tracks to start
 		if err := h.startStreamOrchestrator(stream, ingestService); err != nil {
 			log.Printf("[WebRTC] Error: Failed to start orchestrator: %v", err)
+			stream.Fail(err)
 		}
 	}()
 
@@ -450,51 +1059,40 @@ func (h *BroadcastHandler) WebRTCOffer(c *gin.Context) {
 // WebRTCAnswer handles WebRTC answer from broadcaster
 func (h *BroadcastHandler) WebRTCAnswer(c *gin.Context) {
 	streamID := c.Param("id")
+	if !h.requireIngest(c, streamID) {
+		return
+	}
 
 	var req WebRTCOfferRequest // Reuse same struct for answer
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid request body",
-		})
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	stream, err := h.broadcastManager.GetStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Stream not found",
-		})
+		api.Fail(c, http.StatusNotFound, "Stream not found")
 		return
 	}
 
 	// Get WebRTC ingestion service
 	ingestService := stream.GetWebRTCIngest()
 	if ingestService == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "WebRTC ingestion service not initialized",
-		})
+		api.Fail(c, http.StatusInternalServerError, "WebRTC ingestion service not initialized")
 		return
 	}
 
 	// Process the answer from browser
 	if err := ingestService.HandleAnswer(req.SDP); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to handle WebRTC answer: %v", err),
-		})
+		api.Fail(c, http.StatusInternalServerError, fmt.Sprintf("Failed to handle WebRTC answer: %v", err))
 		return
 	}
 
 	// Start the streaming orchestrator with WebRTC input
 	if err := h.startStreamOrchestrator(stream, ingestService); err != nil {
 		log.Printf("[WebRTC] Failed to start orchestrator: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to start streaming pipeline: %v", err),
-		})
+		stream.Fail(err)
+		api.Fail(c, http.StatusInternalServerError, fmt.Sprintf("Failed to start streaming pipeline: %v", err))
 		return
 	}
 
@@ -504,16 +1102,49 @@ func (h *BroadcastHandler) WebRTCAnswer(c *gin.Context) {
 	})
 }
 
-// startStreamOrchestrator starts the FFmpeg transcoding and HLS upload pipeline
-func (h *BroadcastHandler) startStreamOrchestrator(stream *broadcast.Stream, ingestService interface{}) error {
-	// Create orchestrator
-	orch := orchestrator.NewStreamOrchestrator(stream.ID, h.gcsService)
-	stream.SetOrchestrator(orch)
-
+// startStreamOrchestrator starts the FFmpeg transcoding and HLS upload
+// pipeline. ingestService is nil for a pipeline pulling its RTMP, SRT or
+// RTSP inputs; a WebRTC ingest joining a pipeline that fails over between
+// inputs becomes available to it instead.
+func (h *BroadcastHandler) startStreamOrchestrator(stream *broadcast.Stream, ingestService *webrtc.IngestService) error {
 	// Get WebRTC video path (audio is problematic with simple OGG writing)
 	// For now, use video-only until we implement proper Opus muxing
-	videoPath := fmt.Sprintf("/tmp/webrtc-ingest/%s/video.ivf", stream.ID)
-	inputURL := videoPath
+	inputURL := ""
+	if ingestService != nil {
+		inputURL = ingestService.GetVideoPath()
+	}
+	if orch := stream.GetOrchestrator(); orch != nil && orch.IsRunning() && inputURL != "" && orch.AttachWebRTC(inputURL) {
+		log.Printf("[Orchestrator] WebRTC ingest joined the inputs of stream %s", stream.ID)
+		return nil
+	}
+
+	// Create orchestrator
+	orch := orchestrator.NewStreamOrchestrator(stream.ID, h.gcsService, stream.WorkDir().StreamHLS(stream.ID))
+	orch.SetPlaylistWindow(stream.MaxPlaylistWindow())
+	orch.SetWarmPool(h.warmPool)
+	if h.loadGovernor != nil {
+		orch.SetLoadGovernor(h.loadGovernor)
+	}
+	orch.SetPriority(stream.Priority())
+	orch.SetSigner(h.signer)
+	orch.SetEvents(h.events)
+	orch.SetSourcePolicy(h.sourcePolicy)
+	if inputs := stream.PipelineInputs(); len(inputs) > 0 {
+		orch.SetInputs(inputs, h.streamFailoverWindow(stream))
+	}
+	orch.SetAudioInputs(stream.AudioInputs())
+	if settings := stream.Captions(); settings.Enabled && h.recognizer != nil {
+		orch.SetCaptions(h.recognizer, h.translator, settings.Languages)
+	}
+	orch.SetSegmentNaming(h.segments)
+	orch.SetWatermark(stream.Watermark())
+	orch.SetContentType(stream.ContentType())
+	orch.SetFailureHandler(func(err error) {
+		stream.Fail(fmt.Errorf("transcoder failed: %w", err))
+	})
+	stream.SetOrchestrator(orch)
+	// Continue the media sequence of the slate the stream was primed with
+	orch.SetStartNumber(h.primer.Release(stream.ID))
 
 	// Start the orchestrator
 	if err := orch.Start(inputURL); err != nil {
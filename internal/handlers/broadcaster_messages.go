@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/webrtc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBroadcasterMessage caps the text of a message to a broadcaster
+const maxBroadcasterMessage = 500
+
+// BroadcasterMessageRequest is a message for a stream's broadcaster, e.g.
+// {"type": "cue", "text": "Wrap up in 2 minutes", "data": {"seconds_left": 120}}
+type BroadcasterMessageRequest struct {
+	Type  string         `json:"type" binding:"required"` // cue, moderation or notice
+	Level string         `json:"level"`                   // info (default), warning or critical
+	Text  string         `json:"text"`
+	Data  map[string]any `json:"data"`
+}
+
+// SendBroadcasterMessage pushes a message to a stream's WebRTC broadcaster
+// on the messages data channel of its ingest connection, on whichever
+// replica it is connected to. Broadcasters not connected, or connected
+// without data channels, don't get it.
+func (h *BroadcastHandler) SendBroadcasterMessage(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req BroadcasterMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	switch req.Type {
+	case webrtc.MessageCue, webrtc.MessageModeration, webrtc.MessageNotice:
+	default:
+		api.Fail(c, http.StatusBadRequest, "type must be cue, moderation or notice")
+		return
+	}
+	if !webrtc.ValidLevel(req.Level) {
+		api.Fail(c, http.StatusBadRequest, "level must be info, warning or critical")
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" && len(req.Data) == 0 {
+		api.Fail(c, http.StatusBadRequest, "A message needs text or data")
+		return
+	}
+	if utf8.RuneCountInString(req.Text) > maxBroadcasterMessage {
+		api.Fail(c, http.StatusBadRequest, "text is too long")
+		return
+	}
+
+	if _, err := h.broadcastManager.GetStream(streamID); err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	h.broadcastManager.MessageBroadcaster(streamID, webrtc.Message{Type: req.Type, Level: req.Level, Text: req.Text, Data: req.Data})
+	log.Printf("[Broadcast] Sent %s message to the broadcaster of stream %s", req.Type, streamID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Message sent to the broadcaster",
+	})
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+
+	"live-video/config"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/captions"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetCaptioning makes streams with captions enabled transcribe their speech
+// with recognizer and translate it with translator, which may be nil
+func (h *BroadcastHandler) SetCaptioning(recognizer captions.Recognizer, translator captions.Translator) {
+	h.recognizer = recognizer
+	h.translator = translator
+}
+
+// SetCaptions sets the live captions of a stream, e.g.
+// {"enabled": true, "languages": ["es", "fr"]}. Captions are published in
+// every language detected in the speech, and translated to languages.
+func (h *BroadcastHandler) SetCaptions(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req config.CaptionSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid request body: " + err.Error(),
+		})
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Stream not found",
+		})
+		return
+	}
+
+	settings, err := config.ValidateCaptions(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if settings.Enabled && h.recognizer == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Speech recognition is not configured",
+		})
+		return
+	}
+	if len(settings.Languages) > 0 && h.translator == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Caption translation is not configured",
+		})
+		return
+	}
+	stream.SetCaptions(settings)
+	log.Printf("[Broadcast] Captions of stream %s set to enabled=%v, languages=%v", streamID, settings.Enabled, settings.Languages)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"captions": h.captionsResponse(stream),
+	})
+}
+
+// GetCaptions returns a stream's caption settings and the caption tracks its
+// running pipeline publishes
+func (h *BroadcastHandler) GetCaptions(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "Stream not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"captions": h.captionsResponse(stream),
+	})
+}
+
+// captionsResponse lists the configured caption settings and, while the
+// pipeline runs, the ones it was started with and the tracks it publishes
+func (h *BroadcastHandler) captionsResponse(stream *broadcast.Stream) gin.H {
+	configured := stream.Captions()
+	response := gin.H{"configured": configured}
+	if orch := stream.GetOrchestrator(); orch != nil && orch.IsRunning() {
+		running := orch.Captions()
+		response["running"] = running
+		response["tracks"] = orch.CaptionTracks()
+		response["pending_restart"] = !reflect.DeepEqual(configured, running)
+	}
+	return response
+}
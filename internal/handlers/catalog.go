@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"live-video/pkg/catalog"
+	"live-video/pkg/m3u8"
+	"live-video/pkg/storage"
+	"live-video/pkg/vod"
+)
+
+// SetCatalog lists videos from catalog and keeps it up to date as videos are
+// published and deleted. Without one, videos are listed from the bucket.
+func (h *VideoHandler) SetCatalog(videoCatalog *catalog.Catalog) {
+	h.catalog = videoCatalog
+}
+
+// catalogVideo adds a published video to the catalog, or updates it
+func (h *VideoHandler) catalogVideo(ctx context.Context, video *storage.VideoMetadata) {
+	if h.catalog == nil {
+		return
+	}
+	if err := h.catalog.Put(ctx, video); err != nil {
+		log.Printf("[Catalog] Failed to catalog video %s: %v", video.VideoID, err)
+	}
+}
+
+// cataloged returns a video of the catalog
+func (h *VideoHandler) cataloged(videoID string) (*storage.VideoMetadata, bool) {
+	if h.catalog == nil {
+		return nil, false
+	}
+	return h.catalog.Get(videoID)
+}
+
+// uncatalogVideo removes a deleted video from the catalog
+func (h *VideoHandler) uncatalogVideo(ctx context.Context, videoID string) {
+	if h.catalog == nil {
+		return
+	}
+	if _, err := h.catalog.Remove(ctx, videoID); err != nil {
+		log.Printf("[Catalog] Failed to remove video %s: %v", videoID, err)
+	}
+}
+
+// DescribeVideo describes a video published without the service knowing, for
+// the catalog, with the duration of its best rendition. It implements
+// catalog.Describer.
+func (h *VideoHandler) DescribeVideo(ctx context.Context, videoID string, size int64, publishedAt time.Time) (*storage.VideoMetadata, error) {
+	folder := filepath.Join(h.videoFolder, videoID)
+	data, err := h.gcsService.ReadFile(ctx, filepath.Join(folder, vod.PlaylistName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	if variant := vod.BestVariant(data); variant != "" {
+		if data, err = h.gcsService.ReadFile(ctx, filepath.Join(folder, variant)); err != nil {
+			return nil, fmt.Errorf("failed to read variant playlist %s: %w", variant, err)
+		}
+	}
+	media, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse playlist: %w", err)
+	}
+
+	video := h.hlsMetadata(videoID, size, "application/vnd.apple.mpegurl", media.Duration())
+	video.UploadedAt = publishedAt
+	return video, nil
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/jobs"
+	"live-video/pkg/storage"
+	"live-video/pkg/vod"
+	"live-video/pkg/workdir"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clipURLExpiration is how long the URL of an exported clip is valid
+const clipURLExpiration = 24 * time.Hour
+
+// ClipHandler exports clips of the DVR window of live streams as MP4 files
+type ClipHandler struct {
+	jobManager       *jobs.Manager
+	gcsService       *storage.GCSService
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+	embedSigner      *auth.EmbedSigner
+	workDir          *workdir.WorkDir
+	slots            chan struct{}
+}
+
+// NewClipHandler creates a clip handler exporting at most concurrency clips
+// at a time
+func NewClipHandler(jobManager *jobs.Manager, gcsService *storage.GCSService, broadcastManager *broadcast.BroadcastManager, authService *auth.Service, embedSigner *auth.EmbedSigner, workDir *workdir.WorkDir, concurrency int) *ClipHandler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &ClipHandler{
+		jobManager:       jobManager,
+		gcsService:       gcsService,
+		broadcastManager: broadcastManager,
+		authService:      authService,
+		embedSigner:      embedSigner,
+		workDir:          workDir,
+		slots:            make(chan struct{}, concurrency),
+	}
+}
+
+// ClipPolicyRequest sets how many seconds long the clips of each viewer class
+// can be, e.g. {"limits": {"authenticated": 60, "premium": 300}}
+type ClipPolicyRequest struct {
+	Limits map[string]float64 `json:"limits"`
+}
+
+// ClipRequest selects a range of the DVR window. Offset is how many seconds
+// behind the live edge the clip starts.
+type ClipRequest struct {
+	Offset   float64 `json:"offset"`
+	Duration float64 `json:"duration"`
+}
+
+// SetClipPolicy sets which viewer classes can clip a stream and how long
+// their clips can be
+func (h *ClipHandler) SetClipPolicy(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req ClipPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	limits := make(map[string]time.Duration, len(req.Limits))
+	for class, seconds := range req.Limits {
+		limits[class] = time.Duration(seconds * float64(time.Second))
+	}
+	if err := stream.SetClipPolicy(limits); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("[Broadcast] Clip policy of stream %s set to %v", streamID, limits)
+
+	c.JSON(http.StatusOK, api.NewStreamResponse(stream.Snapshot(), ""))
+}
+
+// CreateClip queues the export of a range of a stream's DVR window. The
+// range must lie within the window of the viewer's class and the clip within
+// the class's clip limit; managers of the stream can clip anything up to
+// broadcast.MaxClipDuration.
+func (h *ClipHandler) CreateClip(c *gin.Context) {
+	stream, err := h.broadcastManager.GetStream(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+
+	var req ClipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	class := viewerClass(c, h.authService, h.embedSigner, stream)
+	limit, allowed := stream.ClipLimit(class)
+	window, windowed := stream.PlaylistWindow(class)
+	if user := currentUser(c); user != nil && h.authService.Can(user, auth.ResourceStream, stream.ID, auth.PermissionManage) {
+		limit, allowed, windowed = broadcast.MaxClipDuration, true, false
+	}
+	if !allowed {
+		api.Fail(c, http.StatusForbidden, "Clipping is not allowed on this stream")
+		return
+	}
+	switch {
+	case req.Duration <= 0 || req.Duration > limit.Seconds():
+		err = fmt.Errorf("duration must be between 0 and %g seconds", limit.Seconds())
+	case req.Offset < req.Duration:
+		err = fmt.Errorf("the clip must end behind the live edge, offset must be at least the duration")
+	case windowed && req.Offset > window.Seconds():
+		err = fmt.Errorf("offset must be within the DVR window of %g seconds", window.Seconds())
+	}
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	clip, err := h.resolveClip(c.Request.Context(), stream, req)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job := h.jobManager.Create(jobs.OriginDVRClip, "", "", stream.ID+"-clip.mp4", "video/mp4", jobs.StatusQueued)
+	h.jobManager.Update(job.ID, func(j *jobs.Job) {
+		j.StreamID = stream.ID
+		j.Clip = clip
+	})
+	go h.runClipJob(job.ID)
+	log.Printf("[Job %s] Queued %.1fs clip of stream %s from %.1fs behind live", job.ID, req.Duration, stream.ID, req.Offset)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":  true,
+		"job_id":   job.ID,
+		"clip_url": fmt.Sprintf("/api/v1/clips/%s", job.ID),
+	})
+}
+
+// GetClip returns the status of a clip export and, once it is done, a signed
+// URL of the MP4
+func (h *ClipHandler) GetClip(c *gin.Context) {
+	job, err := h.jobManager.Get(c.Param("id"))
+	if err != nil || job.Origin != jobs.OriginDVRClip {
+		api.Fail(c, http.StatusNotFound, "Clip not found")
+		return
+	}
+	if stream, err := h.broadcastManager.GetStream(job.StreamID); err == nil {
+		if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+			return
+		}
+	} else if !requirePermission(c, h.authService, auth.ResourceStream, job.StreamID, auth.PermissionRead) {
+		return
+	}
+
+	response := gin.H{
+		"success": true,
+		"job":     job,
+	}
+	if job.Status == jobs.StatusCompleted && job.Clip.GCSPath != "" {
+		url, err := h.gcsService.GetSignedURL(job.Clip.GCSPath, clipURLExpiration)
+		if err == nil {
+			response["url"] = url
+			response["expires_in"] = int(clipURLExpiration.Seconds())
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// resolveClip pins a range behind the live edge of the stream's active source
+// to the segment it starts in
+func (h *ClipHandler) resolveClip(ctx context.Context, stream *broadcast.Stream, req ClipRequest) (*jobs.Clip, error) {
+	source, err := h.broadcastManager.GetStream(stream.ActiveStreamID())
+	if err != nil {
+		return nil, fmt.Errorf("stream has no live output")
+	}
+	name, data, err := mediaPlaylist(liveSource(ctx, h.gcsService, source).read, vod.PlaylistName)
+	if err != nil {
+		return nil, fmt.Errorf("stream has no live output")
+	}
+	segments, _ := vod.Segments(data)
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("stream has no live output")
+	}
+
+	last := segments[len(segments)-1]
+	start := last.Start + last.Duration - req.Offset
+	if start < 0 {
+		return nil, fmt.Errorf("offset is beyond the DVR window of %.1f seconds", last.Start+last.Duration)
+	}
+	first := 0
+	for first < len(segments)-1 && segments[first].Start+segments[first].Duration <= start {
+		first++
+	}
+	return &jobs.Clip{
+		SourceID:      source.ID,
+		Playlist:      name,
+		FirstSequence: vod.MediaSequence(data) + first,
+		Offset:        start - segments[first].Start,
+		Duration:      req.Duration,
+	}, nil
+}
+
+// runClipJob cuts a clip out of the live output and stores it with the
+// stream's recordings as clips/{job id}.mp4
+func (h *ClipHandler) runClipJob(jobID string) {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+
+	job, err := h.jobManager.Get(jobID)
+	if err != nil {
+		return
+	}
+	h.jobManager.SetStatus(jobID, jobs.StatusProcessing, nil)
+
+	gcsPath, err := h.exportClip(job)
+	if err != nil {
+		log.Printf("[Job %s] Clip export failed: %v", jobID, err)
+		h.jobManager.SetStatus(jobID, jobs.StatusFailed, err)
+		return
+	}
+	h.jobManager.Update(jobID, func(j *jobs.Job) {
+		j.Clip.GCSPath = gcsPath
+		j.Status = jobs.StatusCompleted
+		j.Error = ""
+	})
+	log.Printf("[Job %s] Stored clip %s", jobID, gcsPath)
+}
+
+// exportClip fetches the segments of a clip, cuts them into an MP4 and
+// uploads it
+func (h *ClipHandler) exportClip(job *jobs.Job) (string, error) {
+	ctx := context.Background()
+	clip := job.Clip
+	source, err := h.broadcastManager.GetStream(clip.SourceID)
+	if err != nil {
+		return "", fmt.Errorf("stream no longer exists")
+	}
+	live := liveSource(ctx, h.gcsService, source)
+	data, err := live.read(clip.Playlist)
+	if err != nil {
+		return "", fmt.Errorf("failed to read playlist: %w", err)
+	}
+	segments, initURI := vod.Segments(data)
+	first := clip.FirstSequence - vod.MediaSequence(data)
+	if first < 0 || first >= len(segments) {
+		return "", fmt.Errorf("the clip range has left the DVR window")
+	}
+
+	var selected []vod.Segment
+	covered := -clip.Offset
+	for _, segment := range segments[first:] {
+		if covered >= clip.Duration {
+			break
+		}
+		selected = append(selected, segment)
+		covered += segment.Duration
+	}
+
+	dir, err := os.MkdirTemp(h.workDir.Uploads(), "clip-"+job.ID+"-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	input, err := clipPlaylist(dir, path.Dir(clip.Playlist), selected, initURI, live.fetch)
+	if err != nil {
+		return "", err
+	}
+	output := filepath.Join(dir, "clip.mp4")
+	if err := vod.CutMP4(ctx, input, output, clip.Offset, clip.Duration); err != nil {
+		return "", err
+	}
+
+	gcsPath := h.gcsService.Layout().RecordingPath(job.StreamID, "clips", job.ID+".mp4")
+	if err := h.gcsService.UploadFileAs(ctx, output, gcsPath, "video/mp4", "", nil); err != nil {
+		return "", fmt.Errorf("failed to upload clip: %w", err)
+	}
+	return gcsPath, nil
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/collections"
+	"live-video/pkg/storage"
+	"live-video/pkg/viewers"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CollectionHandler serves collections of videos played in order, such as
+// channels and courses
+type CollectionHandler struct {
+	store       *collections.Store
+	gcsService  *storage.GCSService
+	history     *viewers.History
+	authService *auth.Service
+	embedSigner *auth.EmbedSigner
+	videoFolder string
+}
+
+// NewCollectionHandler creates a new collection handler
+func NewCollectionHandler(store *collections.Store, gcsService *storage.GCSService, history *viewers.History, authService *auth.Service, embedSigner *auth.EmbedSigner, videoFolder string) *CollectionHandler {
+	return &CollectionHandler{
+		store:       store,
+		gcsService:  gcsService,
+		history:     history,
+		authService: authService,
+		embedSigner: embedSigner,
+		videoFolder: videoFolder,
+	}
+}
+
+// CollectionRequest creates or replaces a collection
+type CollectionRequest struct {
+	Title       string             `json:"title" binding:"required"`
+	Description string             `json:"description"`
+	Metadata    map[string]string  `json:"metadata"`
+	Items       []collections.Item `json:"items"`
+	Loop        bool               `json:"loop"`
+}
+
+// CreateCollection creates a collection owned by the caller
+func (h *CollectionHandler) CreateCollection(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+	var req CollectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: title is required")
+		return
+	}
+	collection := &collections.Collection{
+		Title:       req.Title,
+		Description: req.Description,
+		Metadata:    req.Metadata,
+		Items:       req.Items,
+		Loop:        req.Loop,
+	}
+	if user := currentUser(c); user != nil {
+		collection.OwnerID = user.ID
+	}
+	if !h.checkItems(c, collection, nil) {
+		return
+	}
+	if err := h.store.Create(c.Request.Context(), collection); err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.authService.SetOwner(auth.ResourceCollection, collection.ID, currentUser(c))
+	log.Printf("[Collections] Created collection %s with %d videos", collection.ID, len(collection.Items))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":    true,
+		"collection": collection,
+	})
+}
+
+// ListCollections returns the collections the caller can read, most
+// recently updated first
+func (h *CollectionHandler) ListCollections(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+	list, err := h.store.List(c.Request.Context())
+	if err != nil {
+		log.Printf("[Collections] Failed to list collections: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to list collections")
+		return
+	}
+	user := currentUser(c)
+	readable := make([]*collections.Collection, 0, len(list))
+	for _, collection := range list {
+		if h.authService.Can(user, auth.ResourceCollection, collection.ID, auth.PermissionRead) {
+			readable = append(readable, collection)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"collections": readable,
+		"count":       len(readable),
+	})
+}
+
+// GetCollection returns a collection
+func (h *CollectionHandler) GetCollection(c *gin.Context) {
+	collection, ok := h.load(c, auth.PermissionRead)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"collection": collection,
+	})
+}
+
+// UpdateCollection replaces the metadata and items of a collection
+func (h *CollectionHandler) UpdateCollection(c *gin.Context) {
+	collection, ok := h.load(c, auth.PermissionManage)
+	if !ok {
+		return
+	}
+	var req CollectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: title is required")
+		return
+	}
+	previous := collection.Items
+	collection.Title = req.Title
+	collection.Description = req.Description
+	collection.Metadata = req.Metadata
+	collection.Items = req.Items
+	collection.Loop = req.Loop
+	if !h.checkItems(c, collection, previous) {
+		return
+	}
+	if err := h.store.Update(c.Request.Context(), collection); err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"collection": collection,
+	})
+}
+
+// DeleteCollection deletes a collection. Its videos are kept.
+func (h *CollectionHandler) DeleteCollection(c *gin.Context) {
+	collection, ok := h.load(c, auth.PermissionManage)
+	if !ok {
+		return
+	}
+	if err := h.store.Delete(c.Request.Context(), collection.ID); err != nil {
+		h.fail(c, err)
+		return
+	}
+	h.authService.RemoveResource(auth.ResourceCollection, collection.ID)
+	log.Printf("[Collections] Deleted collection %s", collection.ID)
+
+	c.JSON(http.StatusOK, api.Message("Collection deleted successfully"))
+}
+
+// GetCollectionPlayback returns the playback descriptor of a collection:
+// its videos the caller can play, in order, the one to play now and the one
+// up next. ?video_id= plays that video; otherwise playback continues where
+// the viewer left off. ?session_id= stitches anonymous requests of a session
+// to its viewer, like the player's.
+func (h *CollectionHandler) GetCollectionPlayback(c *gin.Context) {
+	collection, ok := h.load(c, auth.PermissionRead)
+	if !ok {
+		return
+	}
+	user := currentUser(c)
+	viewer := viewerOf(c, h.history, c.Query("session_id"))
+
+	// Videos the caller can't read are left out of the order
+	playable := &collections.Collection{Loop: collection.Loop}
+	for _, item := range collection.Items {
+		if h.authService.Can(user, auth.ResourceVideo, item.VideoID, auth.PermissionRead) {
+			playable.Items = append(playable.Items, item)
+		}
+	}
+
+	current := 0
+	if videoID := c.Query("video_id"); videoID != "" {
+		current = playable.Index(videoID)
+		if current < 0 {
+			api.Fail(c, http.StatusNotFound, "Video not in collection")
+			return
+		}
+	} else if viewer != "" {
+		current = h.continueAt(viewer, playable)
+	}
+
+	items := make([]gin.H, 0, len(playable.Items))
+	for i, item := range playable.Items {
+		items = append(items, h.playbackItem(viewer, i, item))
+	}
+	response := gin.H{
+		"success":       true,
+		"collection_id": collection.ID,
+		"title":         collection.Title,
+		"loop":          collection.Loop,
+		"items":         items,
+		"current":       nil,
+		"next":          nil,
+	}
+	if len(items) > 0 {
+		response["current"] = items[current]
+		addResume(response, h.history, viewer, playable.Items[current].VideoID)
+		if next := playable.Next(current); next >= 0 {
+			response["next"] = items[next]
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// continueAt returns where a viewer continues a collection: the video they
+// watched last, or the one after it once they finished it
+func (h *CollectionHandler) continueAt(viewer string, collection *collections.Collection) int {
+	last := -1
+	var latest viewers.Entry
+	for i, item := range collection.Items {
+		entry, ok := h.history.Entry(viewer, item.VideoID)
+		if ok && (last < 0 || entry.LastWatchedAt.After(latest.LastWatchedAt)) {
+			last, latest = i, entry
+		}
+	}
+	if last < 0 {
+		return 0
+	}
+	if next := collection.Next(last); latest.Completed && next >= 0 {
+		return next
+	}
+	return last
+}
+
+// playbackItem describes a video of a collection's playback descriptor
+func (h *CollectionHandler) playbackItem(viewer string, position int, item collections.Item) gin.H {
+	described := gin.H{
+		"position":     position,
+		"video_id":     item.VideoID,
+		"playlist_url": playbackURL(h.embedSigner, hlsProxyPrefix+item.VideoID+"/"+vod.PlaylistName),
+		"completed":    false,
+	}
+	if item.Title != "" {
+		described["title"] = item.Title
+	}
+	if viewer != "" {
+		if entry, ok := h.history.Entry(viewer, item.VideoID); ok {
+			described["completed"] = entry.Completed
+		}
+	}
+	return described
+}
+
+// load returns the collection of the request when the caller holds perm on
+// it
+func (h *CollectionHandler) load(c *gin.Context, perm auth.Permission) (*collections.Collection, bool) {
+	id := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceCollection, id, perm) {
+		return nil, false
+	}
+	collection, err := h.store.Get(c.Request.Context(), id)
+	if err != nil {
+		h.fail(c, err)
+		return nil, false
+	}
+	return collection, true
+}
+
+// checkItems aborts unless the caller can read every video added to a
+// collection, and each has been transcoded. Videos already in it are kept
+// as they are.
+func (h *CollectionHandler) checkItems(c *gin.Context, collection *collections.Collection, previous []collections.Item) bool {
+	if err := collection.Validate(); err != nil {
+		h.fail(c, err)
+		return false
+	}
+	kept := make(map[string]bool, len(previous))
+	for _, item := range previous {
+		kept[item.VideoID] = true
+	}
+	for _, item := range collection.Items {
+		if kept[item.VideoID] {
+			continue
+		}
+		if !h.authService.Can(currentUser(c), auth.ResourceVideo, item.VideoID, auth.PermissionRead) {
+			api.Fail(c, http.StatusForbidden, "You do not have access to video "+item.VideoID)
+			return false
+		}
+		playlist := filepath.Join(h.videoFolder, item.VideoID, vod.PlaylistName)
+		if _, err := h.gcsService.GetObjectAttrs(c.Request.Context(), playlist); err != nil {
+			api.Fail(c, http.StatusBadRequest, "Video not found or not transcoded: "+item.VideoID)
+			return false
+		}
+	}
+	return true
+}
+
+func (h *CollectionHandler) fail(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		api.Fail(c, http.StatusNotFound, "Collection not found")
+	case errors.Is(err, collections.ErrInvalid):
+		api.Fail(c, http.StatusBadRequest, err.Error())
+	default:
+		log.Printf("[Collections] %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to save collection")
+	}
+}
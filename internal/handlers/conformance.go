@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/conformance"
+	"live-video/pkg/storage"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConformanceHandler checks the HLS output of videos and streams against
+// Apple's HLS authoring rules
+type ConformanceHandler struct {
+	tracker          *conformance.Tracker
+	gcsService       *storage.GCSService
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+	videoFolder      string
+}
+
+// NewConformanceHandler creates a new conformance handler
+func NewConformanceHandler(tracker *conformance.Tracker, gcsService *storage.GCSService, broadcastManager *broadcast.BroadcastManager, authService *auth.Service, videoFolder string) *ConformanceHandler {
+	return &ConformanceHandler{
+		tracker:          tracker,
+		gcsService:       gcsService,
+		broadcastManager: broadcastManager,
+		authService:      authService,
+		videoFolder:      videoFolder,
+	}
+}
+
+// CheckVideo queues a conformance check of a video's HLS output
+func (h *ConformanceHandler) CheckVideo(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionManage) {
+		return
+	}
+	folder := filepath.Join(h.videoFolder, videoID)
+	if _, err := h.gcsService.GetObjectAttrs(c.Request.Context(), filepath.Join(folder, vod.PlaylistName)); err != nil {
+		api.Fail(c, http.StatusNotFound, "Video not found")
+		return
+	}
+
+	h.queue(c, "video", videoID, func(ctx context.Context, name string) ([]byte, error) {
+		return h.gcsService.ReadFile(ctx, path.Join(folder, name))
+	})
+}
+
+// CheckStream queues a conformance check of a live stream's current output
+func (h *ConformanceHandler) CheckStream(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	source := liveSource(context.Background(), h.gcsService, stream)
+	h.queue(c, "stream", streamID, func(_ context.Context, name string) ([]byte, error) {
+		return source.read(name)
+	})
+}
+
+// GetCheck returns the status of a conformance check and, once it
+// completed, its report
+func (h *ConformanceHandler) GetCheck(c *gin.Context) {
+	run, err := h.tracker.Get(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Conformance check not found")
+		return
+	}
+	kind := auth.ResourceVideo
+	if run.Kind == "stream" {
+		kind = auth.ResourceStream
+	}
+	if !requirePermission(c, h.authService, kind, run.AssetID, auth.PermissionManage) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"check":   run,
+	})
+}
+
+// queue registers a check, runs it in the background and answers 202
+func (h *ConformanceHandler) queue(c *gin.Context, kind, assetID string, read conformance.Source) {
+	run := h.tracker.Create(kind, assetID)
+	go h.tracker.Run(run.ID, func() (*conformance.Report, error) {
+		report, err := conformance.Check(context.Background(), read, vod.PlaylistName, nil)
+		if err == nil {
+			log.Printf("[Conformance %s] %s %s: %d errors, %d warnings", run.ID, kind, assetID, report.Errors, report.Warnings)
+		}
+		return report, err
+	})
+	log.Printf("[Conformance %s] Queued check of %s %s", run.ID, kind, assetID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":   true,
+		"check_id":  run.ID,
+		"check_url": fmt.Sprintf("/api/v1/conformance/%s", run.ID),
+	})
+}
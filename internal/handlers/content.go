@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentTypeRequest sets what a stream shows, e.g. {"content_type": "screen"}
+type ContentTypeRequest struct {
+	ContentType string `json:"content_type"`
+}
+
+// SetStreamContentType tunes a stream's ladder for camera or screen content.
+// It applies from the next pipeline start on.
+func (h *BroadcastHandler) SetStreamContentType(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req ContentTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	contentType, err := config.ValidateContentType(req.ContentType)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	stream.SetContentType(contentType)
+	log.Printf("[Broadcast] Content type of stream %s set to %s", streamID, contentType)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"content_type": contentType,
+		"stream":       api.NewStreamResource(stream.Snapshot()),
+	})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/cost"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRetentionDays is how long an estimate keeps segments when the
+// request doesn't say
+const defaultRetentionDays = 30
+
+// CostHandler estimates what streaming a ladder costs
+type CostHandler struct {
+	prices      cost.Prices
+	authService *auth.Service
+}
+
+// NewCostHandler creates a new cost handler estimating at prices
+func NewCostHandler(prices cost.Prices, authService *auth.Service) *CostHandler {
+	return &CostHandler{
+		prices:      prices,
+		authService: authService,
+	}
+}
+
+// CostEstimateRequest describes a planned stream or video
+type CostEstimateRequest struct {
+	Ladder          []string `json:"ladder"` // profile names, the default ladder when empty
+	Codec           string   `json:"codec"`
+	FrameRate       string   `json:"frame_rate"` // of the source, e.g. 30 or 30000/1001
+	DurationMinutes float64  `json:"duration_minutes" binding:"required"`
+	Viewers         int      `json:"viewers"`
+	WatchFraction   *float64 `json:"watch_fraction"` // 1 when left out
+	RetentionDays   *int     `json:"retention_days"` // 30 when left out
+	ViewRendition   string   `json:"view_rendition"`
+}
+
+// EstimateCost estimates the transcode, storage and egress cost of a ladder
+// streamed for a duration to a number of viewers, e.g.
+// {"ladder": ["1080p", "720p"], "duration_minutes": 90, "viewers": 2000}
+func (h *CostHandler) EstimateCost(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	var req CostEstimateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	ladder, err := ladderProfiles(req.Ladder)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(ladder) == 0 {
+		ladder = config.DefaultFFmpegConfig().Profiles
+	}
+	plan := cost.Plan{
+		Ladder:        ladder,
+		Codec:         req.Codec,
+		Duration:      time.Duration(req.DurationMinutes * float64(time.Minute)),
+		Viewers:       req.Viewers,
+		WatchFraction: 1,
+		RetentionDays: defaultRetentionDays,
+		ViewRendition: req.ViewRendition,
+	}
+	if req.FrameRate != "" {
+		if plan.FrameRate, err = config.ParseRate(req.FrameRate); err != nil {
+			api.Fail(c, http.StatusBadRequest, "Invalid frame_rate: "+err.Error())
+			return
+		}
+	}
+	if req.WatchFraction != nil {
+		plan.WatchFraction = *req.WatchFraction
+	}
+	if req.RetentionDays != nil {
+		plan.RetentionDays = *req.RetentionDays
+	}
+
+	estimate, err := cost.Make(plan, h.prices)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"estimate": estimate,
+	})
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/webrtc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DebugHandler handles admin-only ingest debugging tools
+type DebugHandler struct {
+	captureStore     *webrtc.CaptureStore
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+}
+
+// NewDebugHandler creates a new debug handler
+func NewDebugHandler(captureStore *webrtc.CaptureStore, broadcastManager *broadcast.BroadcastManager, authService *auth.Service) *DebugHandler {
+	return &DebugHandler{
+		captureStore:     captureStore,
+		broadcastManager: broadcastManager,
+		authService:      authService,
+	}
+}
+
+// CaptureRequest turns raw RTP capture of a stream's ingest on or off
+type CaptureRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SetStreamCapture enables or disables raw RTP capture for a stream. Tracks
+// that are already flowing start or stop capturing immediately.
+func (h *DebugHandler) SetStreamCapture(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	var req CaptureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	ingest := stream.GetWebRTCIngest()
+	if ingest == nil {
+		api.Fail(c, http.StatusInternalServerError, "Failed to create WebRTC ingestion service")
+		return
+	}
+
+	if req.Enabled {
+		ingest.SetCapture(h.captureStore)
+	} else {
+		ingest.SetCapture(nil)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"capture_enabled": ingest.CaptureEnabled(),
+	})
+}
+
+// ListCaptures returns the stored debug captures
+func (h *DebugHandler) ListCaptures(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	captures, err := h.captureStore.List()
+	if err != nil {
+		api.Fail(c, http.StatusInternalServerError, "Failed to list captures")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"captures": captures,
+	})
+}
+
+// DownloadCapture serves a capture file (.rtpdump or its .json metadata)
+func (h *DebugHandler) DownloadCapture(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	path, err := h.captureStore.Path(c.Param("name"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Capture not found")
+		return
+	}
+
+	c.FileAttachment(path, c.Param("name"))
+}
+
+// DeleteCapture removes a capture file
+func (h *DebugHandler) DeleteCapture(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	if err := h.captureStore.Delete(c.Param("name")); err != nil {
+		api.Fail(c, http.StatusNotFound, "Capture not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Capture deleted",
+	})
+}
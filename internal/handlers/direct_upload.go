@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/jobs"
+	"live-video/pkg/staging"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateUploadURLRequest represents a request for a direct-to-bucket upload URL
+type CreateUploadURLRequest struct {
+	FileName    string `json:"file_name" binding:"required"`
+	ContentType string `json:"content_type"`
+	Resumable   bool   `json:"resumable"`
+}
+
+// CompleteUploadRequest represents the completion callback for a direct upload
+type CompleteUploadRequest struct {
+	AutoBroadcast bool `json:"auto_broadcast"`
+}
+
+// CreateUploadURL issues a signed URL so clients can upload the source video
+// directly to GCS instead of proxying it through the API server
+func (h *VideoHandler) CreateUploadURL(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	var req CreateUploadURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(req.FileName))
+	if !allowedVideoExts[ext] {
+		api.Fail(c, http.StatusBadRequest, "Invalid file type. Allowed: mp4, mov, avi, mkv, webm")
+		return
+	}
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	videoID := fmt.Sprintf("%d", time.Now().UnixNano())
+	sourcePath := filepath.Join(h.videoFolder, videoID, "source"+ext)
+
+	expiration := 1 * time.Hour
+	uploadURL, err := h.gcsService.GetSignedUploadURL(sourcePath, contentType, req.Resumable, expiration)
+	if err != nil {
+		log.Printf("Signed upload URL error: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to generate upload URL")
+		return
+	}
+
+	h.authService.SetOwner(auth.ResourceVideo, videoID, currentUser(c))
+	job := h.jobManager.Create(jobs.OriginDirectUpload, videoID, sourcePath, req.FileName, contentType, jobs.StatusPendingUpload)
+
+	method := "PUT"
+	headers := map[string]string{"Content-Type": contentType}
+	if req.Resumable {
+		method = "POST"
+		headers["x-goog-resumable"] = "start"
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":      true,
+		"video_id":     videoID,
+		"job_id":       job.ID,
+		"upload_url":   uploadURL,
+		"method":       method,
+		"headers":      headers,
+		"gcs_path":     sourcePath,
+		"expires_at":   time.Now().Add(expiration).UTC(),
+		"complete_url": fmt.Sprintf("/api/v1/videos/%s/complete", videoID),
+	})
+}
+
+// CompleteUpload is called by the client once the direct upload has finished.
+// It verifies the source object exists and queues the HLS transcode job.
+func (h *VideoHandler) CompleteUpload(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionManage) {
+		return
+	}
+
+	var req CompleteUploadRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			api.Fail(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	job, err := h.jobManager.FindByVideoID(videoID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Upload not found")
+		return
+	}
+
+	if job.Status != jobs.StatusPendingUpload {
+		api.FailWith(c, http.StatusConflict, fmt.Sprintf("Upload already %s", job.Status), gin.H{
+			"job_id": job.ID,
+		})
+		return
+	}
+
+	attrs, err := h.gcsService.GetObjectAttrs(c.Request.Context(), job.SourcePath)
+	if err != nil {
+		log.Printf("Direct upload source missing for %s: %v", videoID, err)
+		api.Fail(c, http.StatusBadRequest, "Uploaded file not found in bucket")
+		return
+	}
+	// Of concurrent completions only the one moving the job out of
+	// pending_upload starts the transcode
+	queued, err := h.jobManager.Transition(job.ID, jobs.StatusPendingUpload, jobs.StatusQueued)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Upload not found")
+		return
+	}
+	if !queued {
+		status := jobs.StatusQueued
+		if current, err := h.jobManager.Get(job.ID); err == nil {
+			status = current.Status
+		}
+		api.FailWith(c, http.StatusConflict, fmt.Sprintf("Upload already %s", status), gin.H{
+			"job_id": job.ID,
+		})
+		return
+	}
+	h.gcsService.TrackObject(attrs)
+
+	h.jobManager.Update(job.ID, func(j *jobs.Job) {
+		j.Size = attrs.Size
+		j.AutoBroadcast = req.AutoBroadcast
+	})
+	go h.runTranscodeJob(job.ID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Transcode job queued",
+		"job_id":  job.ID,
+		"job_url": fmt.Sprintf("/api/v1/jobs/%s", job.ID),
+	})
+}
+
+// GetJob returns the status of a transcode job
+func (h *VideoHandler) GetJob(c *gin.Context) {
+	job, err := h.jobManager.Get(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Job not found")
+		return
+	}
+	if !requirePermission(c, h.authService, auth.ResourceVideo, job.VideoID, auth.PermissionRead) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"job":     job,
+	})
+}
+
+// runTranscodeJob downloads a source object from GCS into the staging area,
+// converts it to HLS and publishes the result. Progress is checkpointed on the
+// job so a job interrupted by a restart resumes from the last completed stage.
+// Jobs retried from the staging area start with the staged source.
+func (h *VideoHandler) runTranscodeJob(jobID string) {
+	job, err := h.jobManager.Get(jobID)
+	if err != nil {
+		return
+	}
+
+	h.jobManager.SetStatus(jobID, jobs.StatusProcessing, nil)
+	log.Printf("[Job %s] Transcoding %s", jobID, job.SourcePath)
+
+	cp := h.usableCheckpoint(job)
+
+	h.progress.Start(jobID, job.VideoID, jobs.ProgressDownloading)
+	if cp == nil {
+		h.progress.Update(jobID, func(p *jobs.Progress) { p.BytesTotal = job.Size })
+		entry, err := h.staging.Receive(job.VideoID, filepath.Base(job.SourcePath), job.ContentType, func(path string) error {
+			return h.gcsService.DownloadFile(context.Background(), job.SourcePath, path)
+		})
+		if err != nil {
+			log.Printf("[Job %s] Failed to download source: %v", jobID, err)
+			h.jobManager.SetStatus(jobID, jobs.StatusFailed, fmt.Errorf("failed to download source"))
+			h.progress.Fail(jobID, fmt.Errorf("failed to download source"))
+			return
+		}
+		h.progress.Update(jobID, func(p *jobs.Progress) {
+			p.BytesReceived = p.BytesTotal
+			p.Stage = jobs.ProgressValidating
+		})
+		job.StagingID = entry.ID
+		h.jobManager.Update(jobID, func(j *jobs.Job) {
+			j.StagingID = entry.ID
+		})
+		h.staging.SetJob(entry.ID, jobID)
+
+		if err := h.validateStaged(entry); err != nil {
+			h.jobManager.SetStatus(jobID, jobs.StatusFailed, err)
+			h.progress.Fail(jobID, err)
+			return
+		}
+		cp = &jobs.Checkpoint{Stage: jobs.StageDownloaded, LocalSource: entry.SourcePath}
+		h.saveCheckpoint(jobID, cp)
+	}
+	h.stage(job.StagingID, staging.StateConverting, nil)
+
+	if cp.Stage == jobs.StageDownloaded {
+		// Segments are published while FFmpeg runs and the video is playable
+		// from the first one. A conversion that was interrupted starts over
+		// and publishes every segment again.
+		cp.Uploaded = nil
+		h.startConverting(jobID, cp.LocalSource)
+		playlistPath, duration, err := h.convertHLS(cp.LocalSource, job.VideoID, h.trackConversion(jobID, vod.Options{OnSegment: func(name string) {
+			cp.Uploaded = append(cp.Uploaded, name)
+			h.saveCheckpoint(jobID, cp)
+		}, OnDeinterlace: h.recordDeinterlacing(jobID), OnSource: h.recordSource(jobID)}), func(duration float64) {
+			metadata := h.hlsMetadata(job.VideoID, job.Size, job.ContentType, duration)
+			h.jobManager.Update(jobID, func(j *jobs.Job) {
+				j.Video = metadata
+				j.Playable = true
+			})
+			log.Printf("[Job %s] Playable while converting: %s", jobID, metadata.HLSPlaylistURL)
+		})
+		if err != nil {
+			h.stage(job.StagingID, staging.StateFailed, err)
+			h.jobManager.SetStatus(jobID, jobs.StatusFailed, err)
+			h.progress.Fail(jobID, err)
+			return
+		}
+		cp.Stage = jobs.StageConverted
+		cp.PlaylistPath, cp.SegmentPath, cp.Duration = playlistPath, filepath.Dir(playlistPath), duration
+		h.saveCheckpoint(jobID, cp)
+	} else {
+		log.Printf("[Job %s] Resuming upload (%d files already uploaded)", jobID, len(cp.Uploaded))
+	}
+	defer h.cleanupHLS(cp.PlaylistPath)
+
+	uploaded := make(map[string]bool, len(cp.Uploaded))
+	for _, name := range cp.Uploaded {
+		uploaded[name] = true
+	}
+	h.startUploading(jobID, filepath.Dir(cp.PlaylistPath), uploaded)
+	err = h.uploadHLS(context.Background(), cp.PlaylistPath, job.VideoID, uploaded, func(name string) {
+		cp.Uploaded = append(cp.Uploaded, name)
+		h.saveCheckpoint(jobID, cp)
+		h.progress.Update(jobID, func(p *jobs.Progress) { p.SegmentsUploaded++ })
+	})
+	if err != nil {
+		h.stage(job.StagingID, staging.StateFailed, err)
+		h.jobManager.SetStatus(jobID, jobs.StatusFailed, err)
+		h.progress.Fail(jobID, err)
+		return
+	}
+	metadata := h.hlsMetadata(job.VideoID, job.Size, job.ContentType, cp.Duration)
+
+	// Without preservation, directly uploaded sources are only needed for
+	// conversion; objects discovered through bucket notifications belong to
+	// other systems
+	if h.preserveOriginals {
+		h.preserveJobOriginal(job, cp.LocalSource)
+	} else if job.Origin == jobs.OriginDirectUpload {
+		if err := h.gcsService.DeleteVideo(context.Background(), job.SourcePath); err != nil {
+			log.Printf("[Job %s] Failed to delete source: %v", jobID, err)
+		}
+	}
+	h.catalogVideo(context.Background(), metadata)
+	h.stage(job.StagingID, staging.StateUploaded, nil)
+
+	var streamID string
+	if job.AutoBroadcast {
+		var owner *auth.User
+		if own, ok := h.authService.GetOwnership(auth.ResourceVideo, job.VideoID); ok {
+			owner, _ = h.authService.GetUser(own.OwnerID)
+		}
+		streamID = h.createBroadcastForVideo(metadata, owner).ID
+	}
+
+	h.jobManager.Update(jobID, func(j *jobs.Job) {
+		j.Status = jobs.StatusCompleted
+		j.Video = metadata
+		j.StreamID = streamID
+		j.Checkpoint = nil
+	})
+	h.progress.Stage(jobID, jobs.ProgressCompleted)
+	log.Printf("[Job %s] Completed: %s", jobID, metadata.HLSPlaylistURL)
+}
+
+// usableCheckpoint returns the job's checkpoint if the local files it refers
+// to are still on disk. A conversion whose output is gone is redone from the
+// staged source; without a source the job starts over with a new download.
+func (h *VideoHandler) usableCheckpoint(job *jobs.Job) *jobs.Checkpoint {
+	cp := job.Checkpoint
+	if cp == nil {
+		return nil
+	}
+
+	if cp.Stage == jobs.StageConverted && fileExists(cp.PlaylistPath) {
+		return cp
+	}
+	if cp.PlaylistPath != "" {
+		h.cleanupHLS(cp.PlaylistPath)
+	}
+	if fileExists(cp.LocalSource) {
+		if cp.Stage == jobs.StageConverted {
+			log.Printf("[Job %s] Converted output missing, converting the staged source again", job.ID)
+			cp = &jobs.Checkpoint{Stage: jobs.StageDownloaded, LocalSource: cp.LocalSource}
+			h.saveCheckpoint(job.ID, cp)
+		}
+		return cp
+	}
+
+	log.Printf("[Job %s] Checkpoint files missing, restarting from the beginning", job.ID)
+	if job.StagingID != "" {
+		h.stage(job.StagingID, staging.StateFailed, fmt.Errorf("staged source missing"))
+		h.staging.Remove(job.StagingID)
+	}
+	h.saveCheckpoint(job.ID, nil)
+	return nil
+}
+
+func (h *VideoHandler) saveCheckpoint(jobID string, cp *jobs.Checkpoint) {
+	var c *jobs.Checkpoint
+	if cp != nil {
+		copied := *cp
+		copied.Uploaded = append([]string(nil), cp.Uploaded...)
+		c = &copied
+	}
+	h.jobManager.Update(jobID, func(j *jobs.Job) {
+		j.Checkpoint = c
+	})
+}
+
+// RecoverJobs resumes transcode jobs interrupted by a restart and removes
+// temporary uploads and HLS output that no job refers to anymore. It must
+// run before any new job starts.
+func (h *VideoHandler) RecoverJobs() error {
+	interrupted, err := h.jobManager.Recover()
+	if err != nil {
+		return err
+	}
+
+	// Everything under the upload and VOD HLS directories that an
+	// interrupted job doesn't need is left over from a crash
+	keep := make(map[string]bool)
+	for _, job := range interrupted {
+		if job.Origin == jobs.OriginSlideshow {
+			keep[h.slideshowDir(job.VideoID)] = true
+		}
+		if cp := job.Checkpoint; cp != nil {
+			keep[filepath.Join(h.workDir.Uploads(), filepath.Base(cp.LocalSource))] = true
+			if rel, err := filepath.Rel(h.workDir.VODHLS(), cp.PlaylistPath); err == nil && !strings.HasPrefix(rel, "..") {
+				keep[filepath.Join(h.workDir.VODHLS(), strings.Split(filepath.ToSlash(rel), "/")[0])] = true
+			}
+		}
+	}
+	for _, dir := range []string{h.workDir.Uploads(), h.workDir.VODHLS()} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if !keep[path] {
+				log.Printf("[Jobs] Removing orphaned %s", path)
+				os.RemoveAll(path)
+			}
+		}
+	}
+
+	for _, job := range interrupted {
+		if job.Origin == jobs.OriginDVRClip {
+			// Its temporary files are gone; viewers can ask for the clip again
+			h.jobManager.SetStatus(job.ID, jobs.StatusFailed, fmt.Errorf("clip export interrupted by a restart"))
+			continue
+		}
+		log.Printf("[Job %s] Recovering interrupted job (attempt %d of %d)", job.ID, job.Attempts, jobs.MaxAttempts)
+		switch job.Origin {
+		case jobs.OriginRetranscode:
+			go h.runRetranscodeJob(job.ID)
+			continue
+		case jobs.OriginSlideshow:
+			go h.runSlideshowJob(job.ID)
+			continue
+		}
+		go h.runTranscodeJob(job.ID)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/jobs"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// downloadCacheTTL is how long an MP4 built from HLS is kept for further
+// (range) requests
+const downloadCacheTTL = 24 * time.Hour
+
+// recordingExts lists the file types of live stream recordings
+var recordingExts = map[string]bool{
+	".mp4":  true,
+	".mkv":  true,
+	".flv":  true,
+	".webm": true,
+	".ts":   true,
+}
+
+// mp4Builds serializes MP4 builds per video so concurrent requests share one
+var mp4Builds = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: make(map[string]*sync.Mutex)}
+
+// DownloadVideo streams the original upload of a video or the recording of
+// a stream as an attachment. Single byte ranges are supported, so clients
+// can resume interrupted downloads. Videos that only exist as HLS can be
+// downloaded with ?format=mp4: the segments are remuxed into an MP4 on the
+// first request, which is cached for later requests.
+func (h *VideoHandler) DownloadVideo(c *gin.Context) {
+	id := c.Param("id")
+	user := currentUser(c)
+	videoAccess := h.authService.Can(user, auth.ResourceVideo, id, auth.PermissionRead)
+	streamAccess := h.authService.Can(user, auth.ResourceStream, id, auth.PermissionRead)
+	if !videoAccess && !streamAccess {
+		requirePermission(c, h.authService, auth.ResourceVideo, id, auth.PermissionRead)
+		return
+	}
+
+	var gcsPath string
+	if videoAccess {
+		gcsPath, _ = h.findOriginal(c.Request.Context(), id)
+	}
+	if gcsPath == "" && streamAccess {
+		gcsPath, _ = h.findRecording(c.Request.Context(), id)
+	}
+	if gcsPath != "" {
+		h.serveObject(c, id, gcsPath)
+		return
+	}
+
+	if !videoAccess {
+		api.Fail(c, http.StatusNotFound, "No recording available for this stream")
+		return
+	}
+	if _, err := h.gcsService.GetObjectAttrs(c.Request.Context(), filepath.Join(h.videoFolder, id, vod.PlaylistName)); err != nil {
+		api.Fail(c, http.StatusNotFound, "Video not found")
+		return
+	}
+	if job, err := h.jobManager.FindByVideoID(id); err == nil && (job.Status == jobs.StatusQueued || job.Status == jobs.StatusProcessing) {
+		api.FailWith(c, http.StatusConflict, "Video is still being converted", gin.H{
+			"job_id": job.ID,
+		})
+		return
+	}
+	if c.Query("format") != "mp4" {
+		api.Fail(c, http.StatusNotFound, "No original available for this video, request ?format=mp4 to download it as MP4")
+		return
+	}
+	h.serveMP4(c, id)
+}
+
+// findRecording returns the GCS path of the largest recording of a stream
+func (h *VideoHandler) findRecording(ctx context.Context, streamID string) (string, error) {
+	objects, err := h.gcsService.ListObjects(ctx, h.gcsService.Layout().RecordingPath(streamID)+"/")
+	if err != nil {
+		return "", err
+	}
+	var best string
+	var bestSize int64
+	for _, attrs := range objects {
+		if recordingExts[strings.ToLower(path.Ext(attrs.Name))] && attrs.Size > bestSize {
+			best, bestSize = attrs.Name, attrs.Size
+		}
+	}
+	if best == "" {
+		return "", errors.New("no recording")
+	}
+	return best, nil
+}
+
+// serveObject streams a bucket object, or the requested byte range of it
+func (h *VideoHandler) serveObject(c *gin.Context, id, gcsPath string) {
+	attrs, err := h.gcsService.GetObjectAttrs(c.Request.Context(), gcsPath)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "File not found")
+		return
+	}
+
+	fileName := attrs.Metadata[originalFileNameKey]
+	if fileName == "" {
+		fileName = id + path.Ext(attrs.Name)
+	}
+	contentType := attrs.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Content-Disposition", attachment(fileName))
+	c.Header("ETag", strconv.Quote(attrs.Etag))
+	c.Header("Last-Modified", attrs.Updated.UTC().Format(http.TimeFormat))
+
+	// A range is only served for the version the client started with.
+	// Multiple ranges are answered with the whole file.
+	start, length, status := int64(0), attrs.Size, http.StatusOK
+	rangeHeader := c.GetHeader("Range")
+	if ifRange := c.GetHeader("If-Range"); ifRange != "" && ifRange != strconv.Quote(attrs.Etag) {
+		rangeHeader = ""
+	}
+	if strings.Contains(rangeHeader, ",") {
+		rangeHeader = ""
+	}
+	if rangeHeader != "" {
+		var ok bool
+		start, length, ok = parseByteRange(rangeHeader, attrs.Size)
+		if !ok {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", attrs.Size))
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		status = http.StatusPartialContent
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, attrs.Size))
+	}
+
+	reader, err := h.gcsService.GetRangeReader(c.Request.Context(), gcsPath, start, length)
+	if err != nil {
+		log.Printf("Failed to read %s: %v", gcsPath, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to read file")
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(status, length, contentType, reader, nil)
+}
+
+// serveMP4 builds an MP4 from the best rendition of a video, unless a cached
+// one is newer than the playlist, and serves it with range support
+func (h *VideoHandler) serveMP4(c *gin.Context, videoID string) {
+	mp4Path, err := h.buildMP4(videoID)
+	if err != nil {
+		log.Printf("Failed to build MP4 of %s: %v", videoID, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to convert video to MP4")
+		return
+	}
+
+	file, err := os.Open(mp4Path)
+	if err != nil {
+		api.Fail(c, http.StatusInternalServerError, "Failed to read MP4")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		api.Fail(c, http.StatusInternalServerError, "Failed to read MP4")
+		return
+	}
+
+	c.Header("Content-Disposition", attachment(videoID+".mp4"))
+	c.Header("Content-Type", "video/mp4")
+	http.ServeContent(c.Writer, c.Request, videoID+".mp4", info.ModTime(), file)
+}
+
+// buildMP4 returns the cached MP4 of a video, building it first when there is
+// none or the video was re-transcoded since. Builds aren't tied to the request
+// that started them, as other requests for the video wait on them.
+func (h *VideoHandler) buildMP4(videoID string) (string, error) {
+	ctx := context.Background()
+	mp4Builds.Lock()
+	lock, ok := mp4Builds.locks[videoID]
+	if !ok {
+		lock = &sync.Mutex{}
+		mp4Builds.locks[videoID] = lock
+	}
+	mp4Builds.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	h.sweepDownloads()
+	mp4Path := filepath.Join(h.workDir.Downloads(), videoID+".mp4")
+	playlist, err := h.gcsService.GetObjectAttrs(ctx, filepath.Join(h.videoFolder, videoID, vod.PlaylistName))
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(mp4Path); err == nil && info.ModTime().After(playlist.Updated) {
+		return mp4Path, nil
+	}
+
+	dir, err := os.MkdirTemp(h.workDir.Uploads(), "download-"+videoID+"-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	local, rendition, err := h.fetchBestRendition(ctx, videoID, dir)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Building MP4 of %s from rendition %s", videoID, rendition)
+	if err := vod.RemuxMP4(ctx, local, mp4Path); err != nil {
+		return "", err
+	}
+	return mp4Path, nil
+}
+
+// sweepDownloads removes cached MP4 files that expired
+func (h *VideoHandler) sweepDownloads() {
+	entries, err := os.ReadDir(h.workDir.Downloads())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > downloadCacheTTL {
+			os.Remove(filepath.Join(h.workDir.Downloads(), entry.Name()))
+		}
+	}
+}
+
+// parseByteRange parses a single "bytes=" range against a file size
+func parseByteRange(header string, size int64) (start, length int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
+
+// attachment returns a Content-Disposition header saving a file as name
+func attachment(name string) string {
+	if disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name}); disposition != "" {
+		return disposition
+	}
+	return "attachment"
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultEmbedTokenTTL is the lifetime of embed tokens unless requested,
+// up to auth.MaxEmbedTokenTTL
+const defaultEmbedTokenTTL = 24 * time.Hour
+
+// EmbedHandler issues embed tokens and serves the embeddable player
+type EmbedHandler struct {
+	broadcastManager *broadcast.BroadcastManager
+	signer           *auth.EmbedSigner
+	authService      *auth.Service
+	publicBaseURL    string
+}
+
+// NewEmbedHandler creates a new embed handler
+func NewEmbedHandler(broadcastManager *broadcast.BroadcastManager, signer *auth.EmbedSigner, authService *auth.Service) *EmbedHandler {
+	return &EmbedHandler{
+		broadcastManager: broadcastManager,
+		signer:           signer,
+		authService:      authService,
+	}
+}
+
+// CreateEmbedTokenRequest binds an embed token to a site
+type CreateEmbedTokenRequest struct {
+	Domain    string `json:"domain" binding:"required"`
+	ExpiresIn string `json:"expires_in"` // duration, e.g. "6h"; defaults to 24h
+	// ViewerClass picks the playlist window of the token's viewers; empty
+	// means anonymous
+	ViewerClass string `json:"viewer_class"`
+	// PlaybackMode pins the playback mode of the token's viewers, e.g.
+	// "low_latency"; empty lets the player pick
+	PlaybackMode string `json:"playback_mode"`
+	// Consent tells the player the embedding site gates its viewers itself,
+	// so they skip the stream's content gate
+	Consent bool `json:"consent"`
+	// Viewer is the site's ID of the viewer the token is for, so their
+	// tokens can be revoked together, e.g. on a ban
+	Viewer string `json:"viewer"`
+}
+
+// EmbedPolicyRequest changes whether a stream can only be played embedded
+type EmbedPolicyRequest struct {
+	EmbedOnly bool `json:"embed_only"`
+}
+
+// CreateEmbedToken issues a token that lets a player embedded on domain play
+// the stream until it expires
+func (h *EmbedHandler) CreateEmbedToken(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req CreateEmbedTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || auth.NormalizeDomain(req.Domain) == "" {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: domain is required")
+		return
+	}
+
+	ttl := defaultEmbedTokenTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > auth.MaxEmbedTokenTTL {
+			api.Fail(c, http.StatusBadRequest, fmt.Sprintf("expires_in must be a duration up to %s", auth.MaxEmbedTokenTTL))
+			return
+		}
+		ttl = d
+	}
+	if req.PlaybackMode != "" && !isPlaybackMode(req.PlaybackMode) {
+		api.Fail(c, http.StatusBadRequest, fmt.Sprintf("playback_mode must be one of %s", strings.Join(playbackModes, ", ")))
+		return
+	}
+
+	if _, err := h.broadcastManager.GetStream(streamID); err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	token := h.signer.IssueClaims(auth.EmbedClaims{
+		StreamID:  streamID,
+		Domain:    req.Domain,
+		ExpiresAt: expiresAt.Unix(),
+		Class:     req.ViewerClass,
+		Mode:      req.PlaybackMode,
+		Consent:   req.Consent,
+		Viewer:    req.Viewer,
+	})
+	embedURL := fmt.Sprintf("/embed/%s?embed_token=%s", streamID, url.QueryEscape(token))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":       true,
+		"token":         token,
+		"token_id":      auth.TokenID(token),
+		"domain":        auth.NormalizeDomain(req.Domain),
+		"expires_at":    expiresAt.UTC(),
+		"viewer_class":  viewerClassOf(req.ViewerClass),
+		"playback_mode": req.PlaybackMode,
+		"consent":       req.Consent,
+		"embed_url":     embedURL,
+		"iframe":        fmt.Sprintf(`<iframe src="%s" width="960" height="540" allow="autoplay; fullscreen" allowfullscreen></iframe>`, embedURL),
+	})
+}
+
+// SetEmbedPolicy turns embed-only playback on or off for a stream
+func (h *EmbedHandler) SetEmbedPolicy(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req EmbedPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	stream.SetEmbedOnly(req.EmbedOnly)
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"embed_only": req.EmbedOnly,
+	})
+}
+
+// EmbedPage serves the player for embedding in an iframe. The token must be
+// valid for the stream and the embedding page must be on the token's domain;
+// browsers are also told not to frame the player anywhere else.
+func (h *EmbedHandler) EmbedPage(c *gin.Context) {
+	streamID := c.Param("streamId")
+
+	claims, err := h.signer.Verify(c.Query("embed_token"), streamID)
+	if err != nil {
+		c.String(http.StatusForbidden, "This player can't be shown: %v", err)
+		return
+	}
+	if host := refererHost(c); host == "" || !claims.AllowsHost(host) {
+		c.String(http.StatusForbidden, "This player can't be shown on this site")
+		return
+	}
+
+	c.Header("Content-Security-Policy", fmt.Sprintf("frame-ancestors https://%[1]s https://*.%[1]s http://%[1]s", claims.Domain))
+	c.HTML(http.StatusOK, "player.html", gin.H{
+		"title":    "Video Player",
+		"streamId": streamID,
+		"card":     h.shareCard(c, streamID, "/player/"+streamID),
+		"gate":     h.pageGate(c, streamID, claims),
+	})
+}
+
+// requirePlayback aborts with 403 when a stream is requested by someone who
+// may not play it: see requireEmbedAccess and requireConsent
+func requirePlayback(c *gin.Context, authService *auth.Service, signer *auth.EmbedSigner, stream *broadcast.Stream) bool {
+	return requireEmbedAccess(c, authService, signer, stream) && requireConsent(c, authService, signer, stream)
+}
+
+// requireEmbedAccess aborts with 403 when an embed-only stream is requested
+// without read access, the stream key or a valid embed token. Token requests
+// must come from the player itself or from a page on the token's domain.
+func requireEmbedAccess(c *gin.Context, authService *auth.Service, signer *auth.EmbedSigner, stream *broadcast.Stream) bool {
+	if !stream.IsEmbedOnly() {
+		return true
+	}
+	if user := currentUser(c); user != nil && authService.Can(user, auth.ResourceStream, stream.ID, auth.PermissionRead) {
+		return true
+	}
+	if key := c.GetHeader("X-Stream-Key"); key != "" && stream.ValidStreamKey(key) {
+		return true
+	}
+
+	_, err := embedClaims(c, signer, stream)
+	if err == nil {
+		return true
+	}
+
+	api.Fail(c, http.StatusForbidden, "This stream can only be played through an authorized embed: "+err.Error())
+	return false
+}
+
+// embedClaims verifies the embed token of a request (X-Embed-Token or
+// ?embed_token=) and that the embedding page is on its domain
+func embedClaims(c *gin.Context, signer *auth.EmbedSigner, stream *broadcast.Stream) (*auth.EmbedClaims, error) {
+	token := c.GetHeader("X-Embed-Token")
+	if token == "" {
+		token = c.Query("embed_token")
+	}
+	claims, err := signer.Verify(token, stream.ID)
+	if err != nil {
+		return nil, err
+	}
+	host := refererHost(c)
+	if host != "" && (auth.NormalizeDomain(host) == auth.NormalizeDomain(c.Request.Host) || claims.AllowsHost(host)) {
+		return claims, nil
+	}
+	return nil, fmt.Errorf("embed token not valid on this site")
+}
+
+// viewerClass returns the viewer class of a request, which picks its
+// playlist window: accounts with read access and stream key holders are
+// authenticated, embed token holders get the class of their token, everyone
+// else is anonymous
+func viewerClass(c *gin.Context, authService *auth.Service, signer *auth.EmbedSigner, stream *broadcast.Stream) string {
+	if user := currentUser(c); user != nil && authService.Can(user, auth.ResourceStream, stream.ID, auth.PermissionRead) {
+		return broadcast.ViewerClassAuthenticated
+	}
+	if key := c.GetHeader("X-Stream-Key"); key != "" && stream.ValidStreamKey(key) {
+		return broadcast.ViewerClassAuthenticated
+	}
+	if claims, err := embedClaims(c, signer, stream); err == nil {
+		return viewerClassOf(claims.Class)
+	}
+	return broadcast.ViewerClassAnonymous
+}
+
+func viewerClassOf(class string) string {
+	if class == "" {
+		return broadcast.ViewerClassAnonymous
+	}
+	return class
+}
+
+// refererHost returns the host of the page a request came from
+func refererHost(c *gin.Context) string {
+	for _, header := range []string{"Origin", "Referer"} {
+		if value := c.GetHeader(header); value != "" {
+			if u, err := url.Parse(value); err == nil && u.Host != "" {
+				return u.Host
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/broadcast"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PushEncoderStats records the telemetry a stream's encoder pushes, e.g.
+// {"dropped_frames": 12, "total_frames": 90210, "bitrate_kbps": 6000,
+// "temperature_c": 71.5}, and returns the stream's health with it. Hardware
+// encoders push with the stream key, every few seconds while they send.
+func (h *BroadcastHandler) PushEncoderStats(c *gin.Context) {
+	streamID := c.Param("id")
+	if !h.requireIngest(c, streamID) {
+		return
+	}
+
+	var stats broadcast.EncoderStats
+	if err := c.ShouldBindJSON(&stats); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if err := stats.Validate(); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	telemetry := stream.ReportEncoderStats(stats)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"encoder": telemetry,
+		"health":  stream.Health(),
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/erasure"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErasureHandler handles requests to purge everything kept about a video,
+// stream or user
+type ErasureHandler struct {
+	eraser      *erasure.Eraser
+	tombstones  *erasure.Tombstones
+	authService *auth.Service
+}
+
+// NewErasureHandler creates a new erasure handler
+func NewErasureHandler(eraser *erasure.Eraser, tombstones *erasure.Tombstones, authService *auth.Service) *ErasureHandler {
+	return &ErasureHandler{
+		eraser:      eraser,
+		tombstones:  tombstones,
+		authService: authService,
+	}
+}
+
+// ErasureRequest names what to erase: {"kind": "video"|"stream"|"user", "id": "…"}
+type ErasureRequest struct {
+	Kind string `json:"kind" binding:"required"`
+	ID   string `json:"id" binding:"required"`
+}
+
+// CreateErasure erases a video or stream, which takes manage permission on
+// it, or a user with all they own, which takes an admin or the user
+// themselves. It answers with the verification report, kept as a tombstone.
+func (h *ErasureHandler) CreateErasure(c *gin.Context) {
+	var req ErasureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	switch req.Kind {
+	case erasure.KindVideo, erasure.KindStream:
+		if !requirePermission(c, h.authService, req.Kind, req.ID, auth.PermissionManage) {
+			return
+		}
+	case erasure.KindUser:
+		if user := currentUser(c); user == nil || user.ID != req.ID {
+			if !requireAdmin(c, h.authService) {
+				return
+			}
+		}
+	default:
+		api.Fail(c, http.StatusBadRequest, "kind must be video, stream or user")
+		return
+	}
+
+	var requestedBy string
+	if user := currentUser(c); user != nil {
+		requestedBy = user.ID
+	}
+	report, err := h.eraser.Erase(c.Request.Context(), erasure.Subject{Kind: req.Kind, ID: req.ID}, requestedBy)
+	switch {
+	case errors.Is(err, erasure.ErrInvalid):
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, erasure.ErrInUse):
+		api.Fail(c, http.StatusConflict, err.Error()+"; retry once it finished")
+		return
+	case err != nil:
+		log.Printf("[Erasure] Failed to erase %s %s: %v", req.Kind, req.ID, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to erase "+req.Kind)
+		return
+	}
+
+	// An incomplete erasure is safe to run again
+	status := http.StatusOK
+	if !report.Complete {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{
+		"success": report.Complete,
+		"erasure": report,
+	})
+}
+
+// ListErasures lists the tombstones of past erasures, or with ?id= those of
+// one video, stream or user. Admin only.
+func (h *ErasureHandler) ListErasures(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+	reports, err := h.tombstones.List(c.Request.Context(), c.Query("id"))
+	if err != nil {
+		log.Printf("[Erasure] Failed to list tombstones: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to list erasures")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"erasures": reports,
+	})
+}
+
+// GetErasure returns the tombstone of an erasure. Admin only.
+func (h *ErasureHandler) GetErasure(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+	report, err := h.tombstones.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, os.ErrNotExist) {
+		api.Fail(c, http.StatusNotFound, "Erasure not found")
+		return
+	}
+	if err != nil {
+		log.Printf("[Erasure] Failed to read tombstone %s: %v", c.Param("id"), err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to read erasure")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"erasure": report,
+	})
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventHandler handles events, groups of streams provisioned together
+type EventHandler struct {
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+}
+
+// NewEventHandler creates a new event handler
+func NewEventHandler(broadcastManager *broadcast.BroadcastManager, authService *auth.Service) *EventHandler {
+	return &EventHandler{
+		broadcastManager: broadcastManager,
+		authService:      authService,
+	}
+}
+
+// ProvisionEventRequest creates an event with count streams from a preset
+type ProvisionEventRequest struct {
+	Name           string  `json:"name" binding:"required"`
+	Preset         string  `json:"preset" binding:"required"`
+	Count          int     `json:"count" binding:"required"`
+	NamePrefix     string  `json:"name_prefix"`
+	VideoURL       string  `json:"video_url"`
+	HLSPlaylistURL string  `json:"hls_playlist_url"`
+	GCSPath        string  `json:"gcs_path"`
+	VideoDuration  float64 `json:"video_duration"`
+}
+
+// CreateEventRequest groups existing streams into a new event
+type CreateEventRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	StreamIDs []string `json:"stream_ids"`
+}
+
+// EventStreamsRequest adds existing streams to an event
+type EventStreamsRequest struct {
+	StreamIDs []string `json:"stream_ids" binding:"required"`
+}
+
+// ListPresets returns the built-in stream presets
+func (h *EventHandler) ListPresets(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"presets": broadcast.Presets(),
+	})
+}
+
+// ProvisionEvent creates an event and all of its streams in one call. The
+// response is the only place the streams' ingest keys are returned.
+func (h *EventHandler) ProvisionEvent(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	var req ProvisionEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	preset, ok := broadcast.GetPreset(req.Preset)
+	if !ok {
+		api.Fail(c, http.StatusBadRequest, fmt.Sprintf("Unknown preset: %s", req.Preset))
+		return
+	}
+
+	event, streams, err := h.broadcastManager.ProvisionEvent(broadcast.ProvisionRequest{
+		EventName:      req.Name,
+		Preset:         preset,
+		Count:          req.Count,
+		NamePrefix:     req.NamePrefix,
+		VideoURL:       req.VideoURL,
+		HLSPlaylistURL: req.HLSPlaylistURL,
+		GCSPath:        req.GCSPath,
+		VideoDuration:  req.VideoDuration,
+	})
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user := currentUser(c)
+	h.authService.SetOwner(auth.ResourceEvent, event.ID, user)
+
+	provisioned := make([]gin.H, 0, len(streams))
+	for _, stream := range streams {
+		h.authService.SetOwner(auth.ResourceStream, stream.ID, user)
+		provisioned = append(provisioned, gin.H{
+			"stream_id":     stream.ID,
+			"name":          stream.Name,
+			"stream_key":    stream.StreamKey,
+			"stream_url":    fmt.Sprintf("/api/v1/streams/%s", stream.ID),
+			"watch_url":     fmt.Sprintf("/api/v1/streams/%s/watch", stream.ID),
+			"player_url":    fmt.Sprintf("/player/%s", stream.ID),
+			"broadcast_url": fmt.Sprintf("/live?stream=%s&key=%s", stream.ID, stream.StreamKey),
+		})
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":   true,
+		"message":   fmt.Sprintf("Provisioned %d streams", len(streams)),
+		"event":     event,
+		"event_url": fmt.Sprintf("/api/v1/events/%s", event.ID),
+		"streams":   provisioned,
+	})
+}
+
+// CreateEvent creates an event from streams the caller can manage
+func (h *EventHandler) CreateEvent(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	var req CreateEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !h.canManageStreams(c, req.StreamIDs) {
+		return
+	}
+
+	event, err := h.broadcastManager.CreateEvent(req.Name, req.StreamIDs)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.authService.SetOwner(auth.ResourceEvent, event.ID, currentUser(c))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":   true,
+		"message":   "Event created",
+		"event":     event,
+		"event_url": fmt.Sprintf("/api/v1/events/%s", event.ID),
+	})
+}
+
+// AddStreams adds existing streams to an event
+func (h *EventHandler) AddStreams(c *gin.Context) {
+	eventID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceEvent, eventID, auth.PermissionManage) {
+		return
+	}
+
+	var req EventStreamsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !h.canManageStreams(c, req.StreamIDs) {
+		return
+	}
+
+	event, err := h.broadcastManager.AddEventStreams(eventID, req.StreamIDs)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"event":   event,
+	})
+}
+
+// RemoveStream takes a stream out of an event without deleting the stream
+func (h *EventHandler) RemoveStream(c *gin.Context) {
+	eventID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceEvent, eventID, auth.PermissionManage) {
+		return
+	}
+
+	event, err := h.broadcastManager.RemoveEventStream(eventID, c.Param("streamId"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"event":   event,
+	})
+}
+
+// DeleteEvent deletes an event. Its streams are kept.
+func (h *EventHandler) DeleteEvent(c *gin.Context) {
+	eventID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceEvent, eventID, auth.PermissionManage) {
+		return
+	}
+
+	if err := h.broadcastManager.DeleteEvent(eventID); err != nil {
+		api.Fail(c, http.StatusNotFound, err.Error())
+		return
+	}
+	h.authService.RemoveResource(auth.ResourceEvent, eventID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Event deleted",
+	})
+}
+
+// StartEvent starts all streams of an event
+func (h *EventHandler) StartEvent(c *gin.Context) {
+	h.cascade(c, "started", h.broadcastManager.StartEvent)
+}
+
+// StopEvent stops all streams of an event
+func (h *EventHandler) StopEvent(c *gin.Context) {
+	h.cascade(c, "stopped", h.broadcastManager.StopEvent)
+}
+
+func (h *EventHandler) cascade(c *gin.Context, verb string, action func(eventID string) ([]broadcast.EventActionResult, error)) {
+	eventID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceEvent, eventID, auth.PermissionManage) {
+		return
+	}
+
+	results, err := action(eventID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		if !result.Success {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("[Event %s] %d of %d streams could not be %s", eventID, failed, len(results), verb)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": failed == 0,
+		"message": fmt.Sprintf("%d of %d streams %s", len(results)-failed, len(results), verb),
+		"results": results,
+	})
+}
+
+// GetEventStats returns aggregate viewers and per-room health of an event
+func (h *EventHandler) GetEventStats(c *gin.Context) {
+	eventID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceEvent, eventID, auth.PermissionRead) {
+		return
+	}
+
+	stats, err := h.broadcastManager.EventStats(eventID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"stats":   stats,
+	})
+}
+
+// canManageStreams aborts with 403 unless the caller can manage every stream
+func (h *EventHandler) canManageStreams(c *gin.Context, streamIDs []string) bool {
+	for _, streamID := range streamIDs {
+		if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListEvents returns the caller's events, or all events for admins with scope=all
+func (h *EventHandler) ListEvents(c *gin.Context) {
+	events := h.broadcastManager.ListEvents()
+	all := listScopeAll(c, h.authService)
+	user := currentUser(c)
+
+	visible := make([]*broadcast.Event, 0, len(events))
+	for _, event := range events {
+		if !all && !h.authService.IsMine(user, auth.ResourceEvent, event.ID) {
+			continue
+		}
+		visible = append(visible, event)
+	}
+	sort.Slice(visible, func(i, j int) bool { return visible[i].CreatedAt.After(visible[j].CreatedAt) })
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"count":   len(visible),
+		"events":  visible,
+	})
+}
+
+// GetEvent returns an event with its member streams and aggregate stats
+func (h *EventHandler) GetEvent(c *gin.Context) {
+	eventID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceEvent, eventID, auth.PermissionRead) {
+		return
+	}
+
+	event, err := h.broadcastManager.GetEvent(eventID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	members, _ := h.broadcastManager.EventStreams(eventID)
+	snaps := make([]*broadcast.StreamSnapshot, 0, len(members))
+	for _, stream := range members {
+		snaps = append(snaps, stream.Snapshot())
+	}
+	stats, _ := h.broadcastManager.EventStats(eventID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"event":   event,
+		"stats":   stats,
+		"streams": api.NewStreamListResponse(snaps).Streams,
+	})
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/storage"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// frameSourceKey is the metadata key naming the segment a cached live
+// screenshot was taken from
+const frameSourceKey = "source-segment"
+
+// maxFrameWidth caps the width frames can be scaled to
+const maxFrameWidth = 3840
+
+// frameStepMillis is the step video frame times are rounded down to, so
+// requests can't bypass the frame cache with ever different times
+const frameStepMillis = 100
+
+// maxFrameExtractions bounds the frames of videos decoded at once; each
+// runs FFmpeg over a segment
+const maxFrameExtractions = 4
+
+// frameFormats maps the image formats of frames to their content type
+var frameFormats = map[string]string{
+	"jpg": "image/jpeg",
+	"png": "image/png",
+}
+
+// GetFrame returns the frame of a video at ?t= seconds as a JPEG or PNG
+// (?format=jpg|png, optionally scaled to ?width=). t is rounded down to a
+// tenth of a second and clamped to the last frame. The frame is decoded from
+// the segment of the best rendition containing t, so it is exact rather than
+// the nearest keyframe. Frames are cached under the thumbnails prefix until
+// the video is re-transcoded.
+func (h *VideoHandler) GetFrame(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionRead) {
+		return
+	}
+	t, err := strconv.ParseFloat(c.Query("t"), 64)
+	if err != nil || t < 0 || math.IsInf(t, 0) || math.IsNaN(t) {
+		api.Fail(c, http.StatusBadRequest, "t must be a time in seconds")
+		return
+	}
+	millis := int64(t*1000) / frameStepMillis * frameStepMillis
+	ext, width, ok := frameOptions(c)
+	if !ok {
+		return
+	}
+
+	folder := filepath.Join(h.videoFolder, videoID)
+	playlist, err := h.gcsService.GetObjectAttrs(c.Request.Context(), filepath.Join(folder, vod.PlaylistName))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Video not found")
+		return
+	}
+
+	cachePath := func() string {
+		return h.gcsService.Layout().ThumbnailPath(videoID, fmt.Sprintf("frame_%d%s.%s", millis, widthSuffix(width), ext))
+	}
+	servedCached := func() bool {
+		cached, err := h.gcsService.GetObjectAttrs(c.Request.Context(), cachePath())
+		if err != nil || !cached.Updated.After(playlist.Updated) {
+			return false
+		}
+		serveCachedFrame(c, h.gcsService, cachePath(), cached.Size, "public, max-age=3600")
+		return true
+	}
+	if servedCached() {
+		return
+	}
+
+	read := func(name string) ([]byte, error) {
+		return h.gcsService.ReadFile(c.Request.Context(), path.Join(folder, name))
+	}
+	name, data, err := mediaPlaylist(read, vod.PlaylistName)
+	if err != nil {
+		log.Printf("Failed to read playlist of %s: %v", videoID, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to read video playlist")
+		return
+	}
+	segments, initURI := vod.Segments(data)
+	if len(segments) == 0 {
+		api.Fail(c, http.StatusNotFound, "Video has no segments")
+		return
+	}
+	last := segments[len(segments)-1]
+	if end := int64((last.Start + last.Duration) * 1000); millis >= end {
+		millis = max(end-1, 0) / frameStepMillis * frameStepMillis
+		if servedCached() {
+			return
+		}
+	}
+	t = float64(millis) / 1000
+	segment, ok := vod.SegmentAt(segments, t)
+	if !ok {
+		api.Fail(c, http.StatusBadRequest, "t is beyond the end of the video")
+		return
+	}
+
+	select {
+	case h.frameSlots <- struct{}{}:
+		defer func() { <-h.frameSlots }()
+	default:
+		c.Header("Retry-After", "1")
+		api.Fail(c, http.StatusServiceUnavailable, "Too many frames being extracted, retry shortly")
+		return
+	}
+
+	dir, err := os.MkdirTemp(h.workDir.Uploads(), "frame-"+videoID+"-")
+	if err != nil {
+		api.Fail(c, http.StatusInternalServerError, "Failed to prepare frame extraction")
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	fetch := func(name, local string) error {
+		return h.gcsService.DownloadFile(c.Request.Context(), path.Join(folder, name), local)
+	}
+	input, err := segmentInput(dir, path.Dir(name), segment.URI, initURI, fetch)
+	if err == nil {
+		err = vod.ExtractFrame(c.Request.Context(), input, t-segment.Start, filepath.Join(dir, "frame."+ext), width)
+	}
+	if err != nil {
+		log.Printf("Failed to extract frame of %s at %.3fs: %v", videoID, t, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to extract frame")
+		return
+	}
+
+	serveFrame(c, h.gcsService, filepath.Join(dir, "frame."+ext), cachePath(), ext, nil, "public, max-age=3600")
+}
+
+// GetScreenshot returns the latest frame of a live stream as a JPEG or PNG
+// (?format=jpg|png, optionally scaled to ?width=), taken from the most recent
+// segment of its best rendition. The screenshot is cached under the
+// thumbnails prefix until a newer segment is written.
+func (h *BroadcastHandler) GetScreenshot(c *gin.Context) {
+	streamID := c.Param("id")
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+	ext, width, ok := frameOptions(c)
+	if !ok {
+		return
+	}
+
+	// Prefer the HLS output of this node, else what was uploaded
+	layout := h.gcsService.Layout()
+	localDir := stream.WorkDir().StreamHLS(streamID)
+	read := func(name string) ([]byte, error) {
+		return h.gcsService.ReadFile(c.Request.Context(), layout.LivePath(streamID, name))
+	}
+	fetch := func(name, local string) error {
+		return h.gcsService.DownloadFile(c.Request.Context(), layout.LivePath(streamID, name), local)
+	}
+	version := func(name string) (string, error) {
+		attrs, err := h.gcsService.GetObjectAttrs(c.Request.Context(), layout.LivePath(streamID, name))
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(attrs.Generation, 10), nil
+	}
+	if _, err := os.Stat(filepath.Join(localDir, vod.PlaylistName)); err == nil {
+		read = func(name string) ([]byte, error) {
+			return os.ReadFile(filepath.Join(localDir, filepath.FromSlash(name)))
+		}
+		fetch = func(name, local string) error {
+			return linkOrCopy(filepath.Join(localDir, filepath.FromSlash(name)), local)
+		}
+		version = func(name string) (string, error) {
+			info, err := os.Stat(filepath.Join(localDir, filepath.FromSlash(name)))
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatInt(info.ModTime().UnixNano(), 10), nil
+		}
+	}
+
+	name, data, err := mediaPlaylist(read, vod.PlaylistName)
+	var segments []vod.Segment
+	var initURI string
+	if err == nil {
+		segments, initURI = vod.Segments(data)
+	}
+	if len(segments) == 0 {
+		api.Fail(c, http.StatusNotFound, "No live segment available")
+		return
+	}
+	segment := segments[len(segments)-1]
+	segmentName := path.Join(path.Dir(name), segment.URI)
+	segmentVersion, err := version(segmentName)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "No live segment available")
+		return
+	}
+	source := segmentName + "@" + segmentVersion
+
+	cachePath := layout.ThumbnailPath(streamID, fmt.Sprintf("screenshot%s.%s", widthSuffix(width), ext))
+	if cached, err := h.gcsService.GetObjectAttrs(c.Request.Context(), cachePath); err == nil && cached.Metadata[frameSourceKey] == source {
+		serveCachedFrame(c, h.gcsService, cachePath, cached.Size, "public, max-age=2")
+		return
+	}
+
+	dir, err := os.MkdirTemp(stream.WorkDir().Uploads(), "screenshot-"+streamID+"-")
+	if err != nil {
+		api.Fail(c, http.StatusInternalServerError, "Failed to prepare screenshot")
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	input, err := segmentInput(dir, path.Dir(name), segment.URI, initURI, fetch)
+	if err == nil {
+		err = vod.ExtractFrame(c.Request.Context(), input, -1, filepath.Join(dir, "frame."+ext), width)
+	}
+	if err != nil {
+		log.Printf("[Broadcast] Failed to take screenshot of stream %s: %v", streamID, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to take screenshot")
+		return
+	}
+
+	serveFrame(c, h.gcsService, filepath.Join(dir, "frame."+ext), cachePath, ext, map[string]string{frameSourceKey: source}, "public, max-age=2")
+}
+
+// serveCachedFrame streams a cached frame from the bucket
+func serveCachedFrame(c *gin.Context, gcsService *storage.GCSService, cachePath string, size int64, cacheControl string) {
+	reader, err := gcsService.GetFileReader(c.Request.Context(), cachePath)
+	if err != nil {
+		api.Fail(c, http.StatusInternalServerError, "Failed to read frame")
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Cache-Control", cacheControl)
+	c.DataFromReader(http.StatusOK, size, frameFormats[strings.TrimPrefix(path.Ext(cachePath), ".")], reader, nil)
+}
+
+// serveFrame caches an extracted frame in the bucket and sends it. A failed
+// upload only costs the cache.
+func serveFrame(c *gin.Context, gcsService *storage.GCSService, framePath, cachePath, ext string, metadata map[string]string, cacheControl string) {
+	if err := gcsService.UploadFileAs(c.Request.Context(), framePath, cachePath, frameFormats[ext], "", metadata); err != nil {
+		log.Printf("Failed to cache frame %s: %v", cachePath, err)
+	}
+	c.Header("Cache-Control", cacheControl)
+	c.Header("Content-Type", frameFormats[ext])
+	c.File(framePath)
+}
+
+// frameOptions parses the image format and width of a frame request
+func frameOptions(c *gin.Context) (string, int, bool) {
+	ext := strings.ToLower(c.DefaultQuery("format", "jpg"))
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	if _, ok := frameFormats[ext]; !ok {
+		api.Fail(c, http.StatusBadRequest, "format must be jpg or png")
+		return "", 0, false
+	}
+
+	width := 0
+	if value := c.Query("width"); value != "" {
+		var err error
+		width, err = strconv.Atoi(value)
+		if err != nil || width < 16 || width > maxFrameWidth {
+			api.Fail(c, http.StatusBadRequest, fmt.Sprintf("width must be between 16 and %d", maxFrameWidth))
+			return "", 0, false
+		}
+	}
+	return ext, width, true
+}
+
+func widthSuffix(width int) string {
+	if width == 0 {
+		return ""
+	}
+	return fmt.Sprintf("_w%d", width)
+}
+
+// mediaPlaylist reads a playlist and, for a master playlist, its best
+// variant. It returns the name and content of the media playlist.
+func mediaPlaylist(read func(name string) ([]byte, error), name string) (string, []byte, error) {
+	data, err := read(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if variant := vod.BestVariant(data); variant != "" {
+		name = path.Join(path.Dir(name), variant)
+		if data, err = read(name); err != nil {
+			return "", nil, err
+		}
+	}
+	return name, data, nil
+}
+
+// segmentInput fetches a segment, relative to the media playlist directory
+// base, into dir. With an fMP4 initialization segment the two are joined into
+// one playable file.
+func segmentInput(dir, base, segmentURI, initURI string, fetch func(name, local string) error) (string, error) {
+	for _, uri := range []string{segmentURI, initURI} {
+		if uri != "" && strings.Contains(uri, "://") || strings.HasPrefix(path.Join(base, uri), "..") {
+			return "", fmt.Errorf("unsupported segment URI %s", uri)
+		}
+	}
+
+	input := filepath.Join(dir, "segment"+path.Ext(segmentURI))
+	if err := fetch(path.Join(base, segmentURI), input); err != nil {
+		return "", fmt.Errorf("failed to fetch segment %s: %w", segmentURI, err)
+	}
+	if initURI == "" {
+		return input, nil
+	}
+
+	initPath := filepath.Join(dir, "init.mp4")
+	if err := fetch(path.Join(base, initURI), initPath); err != nil {
+		return "", fmt.Errorf("failed to fetch init segment %s: %w", initURI, err)
+	}
+	joined := filepath.Join(dir, "input.mp4")
+	out, err := os.Create(joined)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	for _, part := range []string{initPath, input} {
+		in, err := os.Open(part)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return joined, out.Close()
+}
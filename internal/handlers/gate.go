@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+
+	"github.com/gin-gonic/gin"
+)
+
+// consentTTL is how long a viewer's confirmation of a content gate lasts
+const consentTTL = 24 * time.Hour
+
+// ConsentRequest is a viewer's confirmation of a stream's content gate
+type ConsentRequest struct {
+	Acknowledged bool `json:"acknowledged"`  // the viewer accepts the rating
+	AgeConfirmed bool `json:"age_confirmed"` // the viewer is at least the gate's minimum age
+}
+
+// GatePage is the content gate a page shows before its player starts
+type GatePage struct {
+	StreamID string `json:"stream_id"`
+	Rating   string `json:"rating,omitempty"`
+	MinAge   int    `json:"min_age,omitempty"`
+}
+
+// SetContentGate sets the content rating and age gate viewers confirm before
+// they play a stream. The zero gate removes it.
+func (h *EmbedHandler) SetContentGate(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var gate broadcast.ContentGate
+	if err := c.ShouldBindJSON(&gate); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if err := gate.Validate(); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	stream.SetContentGate(gate)
+	log.Printf("[Broadcast] Content gate of stream %s set (rating %q, min age %d, consent %t)", streamID, gate.Rating, gate.MinAge, gate.Consent)
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"content_gate": gate,
+		"gated":        gate.Gated(),
+	})
+}
+
+// GrantConsent records that a viewer confirmed a stream's content gate. It
+// answers with a consent token for players, and sets it as a cookie for
+// same-site pages.
+func (h *EmbedHandler) GrantConsent(c *gin.Context) {
+	stream, err := h.broadcastManager.GetStream(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requireEmbedAccess(c, h.authService, h.signer, stream) {
+		return
+	}
+
+	var req ConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	gate := stream.ContentGate()
+	if !gate.Gated() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"gated":   false,
+		})
+		return
+	}
+	if !req.Acknowledged {
+		api.Fail(c, http.StatusBadRequest, "acknowledged must be true to play this stream")
+		return
+	}
+	if gate.MinAge > 0 && !req.AgeConfirmed {
+		api.Fail(c, http.StatusBadRequest, fmt.Sprintf("age_confirmed must be true: this stream is for viewers aged %d or older", gate.MinAge))
+		return
+	}
+
+	expiresAt := time.Now().Add(consentTTL)
+	token := h.signer.IssueConsent(stream.ID, gate.MinAge, expiresAt)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(consentCookie(stream.ID), token, int(consentTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusCreated, gin.H{
+		"success":       true,
+		"gated":         true,
+		"consent_token": token,
+		"expires_at":    expiresAt.UTC(),
+	})
+}
+
+// requireConsent aborts with 403 when a gated stream is requested without
+// the viewer's consent. The response carries the gate, for players to show.
+func requireConsent(c *gin.Context, authService *auth.Service, signer *auth.EmbedSigner, stream *broadcast.Stream) bool {
+	gate := stream.ContentGate()
+	if !gate.Gated() || hasConsent(c, authService, signer, stream, gate) {
+		return true
+	}
+	api.FailWith(c, http.StatusForbidden, "This stream asks viewers to confirm its content gate first", gin.H{
+		"content_gate": gate,
+		"consent_url":  "/api/v1/streams/" + stream.ID + "/consent",
+	})
+	return false
+}
+
+// hasConsent reports whether a request passes the content gate of stream:
+// accounts that manage it and stream key holders always do, viewers with a
+// consent token (X-Consent-Token, ?consent_token= or its cookie) for the
+// gate's age and embedded players whose token carries consent
+func hasConsent(c *gin.Context, authService *auth.Service, signer *auth.EmbedSigner, stream *broadcast.Stream, gate broadcast.ContentGate) bool {
+	if user := currentUser(c); user != nil && authService.Can(user, auth.ResourceStream, stream.ID, auth.PermissionManage) {
+		return true
+	}
+	if key := c.GetHeader("X-Stream-Key"); key != "" && stream.ValidStreamKey(key) {
+		return true
+	}
+
+	token := c.GetHeader("X-Consent-Token")
+	if token == "" {
+		token = c.Query("consent_token")
+	}
+	if token == "" {
+		token, _ = c.Cookie(consentCookie(stream.ID))
+	}
+	if claims, err := signer.VerifyConsent(token, stream.ID); err == nil && claims.MinAge >= gate.MinAge {
+		return true
+	}
+
+	claims, err := embedClaims(c, signer, stream)
+	return err == nil && claims.Consent
+}
+
+// pageGate returns the content gate a page of streamID shows, or nil when
+// the stream is not gated or the request already passes it. embed are the
+// claims of an embedded player's token.
+func (h *EmbedHandler) pageGate(c *gin.Context, streamID string, embed *auth.EmbedClaims) *GatePage {
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		return nil
+	}
+	gate := stream.ContentGate()
+	if !gate.Gated() || (embed != nil && embed.Consent) || hasConsent(c, h.authService, h.signer, stream, gate) {
+		return nil
+	}
+	return &GatePage{StreamID: streamID, Rating: gate.Rating, MinAge: gate.MinAge}
+}
+
+func consentCookie(streamID string) string {
+	return "consent_" + streamID
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/jobs"
+	"live-video/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GCSIngestHandler receives GCS object-finalize notifications delivered by a
+// Pub/Sub push subscription and transcodes new videos dropped into a watched
+// prefix without going through the upload API
+type GCSIngestHandler struct {
+	videoHandler *VideoHandler
+	jobManager   *jobs.Manager
+	watchPrefix  string
+	pushToken    string
+}
+
+// NewGCSIngestHandler creates a new GCS notification ingest handler
+func NewGCSIngestHandler(videoHandler *VideoHandler, jobManager *jobs.Manager, watchPrefix, pushToken string) *GCSIngestHandler {
+	if watchPrefix != "" && !strings.HasSuffix(watchPrefix, "/") {
+		watchPrefix += "/"
+	}
+	return &GCSIngestHandler{
+		videoHandler: videoHandler,
+		jobManager:   jobManager,
+		watchPrefix:  watchPrefix,
+		pushToken:    pushToken,
+	}
+}
+
+// IngestClaimPrefix is where the replica ingesting an object generation
+// claims it in the bucket, outside the layout prefixes, so each generation is
+// ingested once however often Pub/Sub delivers it and to whichever replica
+const IngestClaimPrefix = "ingest-claims"
+
+// PubSubPushRequest is the envelope Pub/Sub uses for push deliveries
+type PubSubPushRequest struct {
+	Message struct {
+		Attributes  map[string]string `json:"attributes"`
+		Data        []byte            `json:"data"`
+		MessageID   string            `json:"messageId"`
+		PublishTime time.Time         `json:"publishTime"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// HandleNotification handles a Pub/Sub push delivery of a GCS notification.
+// Any 2xx response acknowledges the message, so events we deliberately ignore
+// are answered with 204 and only transient failures return an error status.
+func (h *GCSIngestHandler) HandleNotification(c *gin.Context) {
+	if h.watchPrefix == "" {
+		api.Fail(c, http.StatusNotFound, "GCS ingestion is not enabled")
+		return
+	}
+
+	// Without a token nobody can push, rather than everybody
+	if h.pushToken == "" || subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(h.pushToken)) != 1 {
+		api.Fail(c, http.StatusUnauthorized, "Invalid push token")
+		return
+	}
+
+	var req PubSubPushRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// Malformed messages will never succeed, acknowledge them
+		log.Printf("[GCSIngest] Dropping malformed push message: %v", err)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	attrs := req.Message.Attributes
+	objectName := attrs["objectId"]
+
+	if attrs["eventType"] != "OBJECT_FINALIZE" {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	gcsService := h.videoHandler.gcsService
+	if bucket := attrs["bucketId"]; bucket != gcsService.BucketName() {
+		log.Printf("[GCSIngest] Ignoring notification for bucket %q", bucket)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if !strings.HasPrefix(objectName, h.watchPrefix) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	// Never ingest what the service wrote itself, e.g. preserved originals
+	// or exported clips
+	if _, _, ok := gcsService.Layout().AssetOf(objectName); ok || strings.HasPrefix(objectName, storage.LegacyPrefix+"/") {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(objectName))
+	if !allowedVideoExts[ext] {
+		log.Printf("[GCSIngest] Ignoring non-video object: %s", objectName)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	generation, err := strconv.ParseInt(attrs["objectGeneration"], 10, 64)
+	if err != nil {
+		log.Printf("[GCSIngest] Dropping notification for %s without an object generation", objectName)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	objAttrs, err := gcsService.GetObjectAttrs(c.Request.Context(), objectName)
+	if err != nil {
+		// Let Pub/Sub redeliver; the object may not be readable yet
+		log.Printf("[GCSIngest] Failed to stat %s: %v", objectName, err)
+		api.Fail(c, http.StatusServiceUnavailable, "Object not readable")
+		return
+	}
+	if objAttrs.Generation != generation {
+		// Overwritten since; the newer generation has a notification of its own
+		log.Printf("[GCSIngest] Ignoring superseded generation %d of %s", generation, objectName)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	// Pub/Sub delivers at least once, possibly to several replicas; only the
+	// one that claims the generation ingests it
+	videoID := fmt.Sprintf("%d", time.Now().UnixNano())
+	claimed, err := h.claim(c.Request.Context(), attrs["bucketId"], objectName, generation, req.Message.MessageID, videoID)
+	if err != nil {
+		log.Printf("[GCSIngest] Failed to claim %s#%d: %v", objectName, generation, err)
+		api.Fail(c, http.StatusServiceUnavailable, "Failed to claim object")
+		return
+	}
+	if !claimed {
+		log.Printf("[GCSIngest] Duplicate notification for %s#%d", objectName, generation)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	job := h.jobManager.Create(jobs.OriginGCSNotification, videoID, objectName, filepath.Base(objectName), objAttrs.ContentType, jobs.StatusQueued)
+	h.jobManager.Update(job.ID, func(j *jobs.Job) {
+		j.Size = objAttrs.Size
+	})
+	log.Printf("[GCSIngest] Discovered %s (message %s), queued job %s", objectName, req.Message.MessageID, job.ID)
+
+	go h.videoHandler.runTranscodeJob(job.ID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":  true,
+		"job_id":   job.ID,
+		"video_id": videoID,
+	})
+}
+
+// claim records that this delivery ingests a generation of an object as
+// videoID, reporting false when another delivery claimed it first. A claim
+// whose write failed is removed if it was written after all, so a
+// redelivery can claim; claims of other deliveries are left alone.
+func (h *GCSIngestHandler) claim(ctx context.Context, bucket, objectName string, generation int64, messageID, videoID string) (bool, error) {
+	attempt := uuid.New().String()
+	data, err := json.Marshal(gin.H{
+		"bucket":     bucket,
+		"object":     objectName,
+		"generation": generation,
+		"message_id": messageID,
+		"video_id":   videoID,
+		"attempt":    attempt,
+		"claimed_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return false, err
+	}
+	claimPath := path.Join(IngestClaimPrefix, bucket, objectName, strconv.FormatInt(generation, 10))
+	claimed, err := h.videoHandler.gcsService.CreateBytes(ctx, data, claimPath, "application/json")
+	if err != nil {
+		h.releaseClaim(claimPath, attempt)
+		return false, err
+	}
+	return claimed, nil
+}
+
+// releaseClaim deletes the claim at claimPath if attempt wrote it. The
+// delete is conditioned on the generation read, so a claim another delivery
+// writes meanwhile is kept.
+func (h *GCSIngestHandler) releaseClaim(claimPath, attempt string) {
+	ctx := context.Background()
+	data, claimGeneration, err := h.videoHandler.gcsService.ReadGeneration(ctx, claimPath)
+	if err != nil {
+		if !storage.IsNotExist(err) {
+			log.Printf("[GCSIngest] Failed to read claim %s: %v", claimPath, err)
+		}
+		return
+	}
+	var written struct {
+		Attempt string `json:"attempt"`
+	}
+	if json.Unmarshal(data, &written) != nil || written.Attempt != attempt {
+		return
+	}
+	if _, err := h.videoHandler.gcsService.DeleteGeneration(ctx, claimPath, claimGeneration); err != nil {
+		log.Printf("[GCSIngest] Failed to remove claim %s: %v", claimPath, err)
+	}
+}
+
+// ingestClaim is what a claim records of the ingest of an object generation
+type ingestClaim struct {
+	Bucket     string `json:"bucket"`
+	Object     string `json:"object"`
+	Generation int64  `json:"generation"`
+	VideoID    string `json:"video_id,omitempty"`
+}
+
+// VideoClaims returns the paths of the claims that ingested objects as
+// videoID
+func (h *GCSIngestHandler) VideoClaims(ctx context.Context, videoID string) ([]string, error) {
+	gcsService := h.videoHandler.gcsService
+	objects, err := gcsService.ListObjects(ctx, IngestClaimPrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	var claims []string
+	for _, attrs := range objects {
+		data, err := gcsService.ReadFile(ctx, attrs.Name)
+		if storage.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return claims, err
+		}
+		var claim ingestClaim
+		if json.Unmarshal(data, &claim) == nil && claim.VideoID == videoID {
+			claims = append(claims, attrs.Name)
+		}
+	}
+	return claims, nil
+}
+
+// RedactClaim rewrites a claim without its video, message and attempt. The
+// object generation stays claimed, so a redelivered notification doesn't
+// ingest an erased video again.
+func (h *GCSIngestHandler) RedactClaim(ctx context.Context, claimPath string) error {
+	gcsService := h.videoHandler.gcsService
+	data, err := gcsService.ReadFile(ctx, claimPath)
+	if err != nil {
+		return err
+	}
+	var claim ingestClaim
+	if err := json.Unmarshal(data, &claim); err != nil {
+		return fmt.Errorf("unreadable claim %s: %w", claimPath, err)
+	}
+	claim.VideoID = ""
+	if data, err = json.Marshal(claim); err != nil {
+		return err
+	}
+	return gcsService.UploadBytes(ctx, data, claimPath, "application/json")
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/geoip"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeoHandler serves the geographic breakdown of live audiences
+type GeoHandler struct {
+	audience         *geoip.Audience
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+}
+
+// NewGeoHandler creates a new geo handler
+func NewGeoHandler(audience *geoip.Audience, broadcastManager *broadcast.BroadcastManager, authService *auth.Service) *GeoHandler {
+	return &GeoHandler{
+		audience:         audience,
+		broadcastManager: broadcastManager,
+		authService:      authService,
+	}
+}
+
+// GetStreamGeo returns where a stream's active viewers are, by country and
+// region
+func (h *GeoHandler) GetStreamGeo(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionRead) {
+		return
+	}
+
+	if _, err := h.broadcastManager.GetStream(streamID); err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"stream_id": streamID,
+		"geo":       h.audience.Breakdown(streamID),
+	})
+}
+
+// GetEventGeo returns where the active viewers of all streams of an event
+// are, by country and region
+func (h *GeoHandler) GetEventGeo(c *gin.Context) {
+	eventID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceEvent, eventID, auth.PermissionRead) {
+		return
+	}
+
+	event, err := h.broadcastManager.GetEvent(eventID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Event not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"event_id": eventID,
+		"geo":      h.audience.Breakdown(event.StreamIDs...),
+	})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/viewers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryHandler serves the watch history of signed in viewers
+type HistoryHandler struct {
+	history     *viewers.History
+	authService *auth.Service
+}
+
+// NewHistoryHandler creates a new watch history handler
+func NewHistoryHandler(history *viewers.History, authService *auth.Service) *HistoryHandler {
+	return &HistoryHandler{
+		history:     history,
+		authService: authService,
+	}
+}
+
+// GetHistory returns what the caller watched, most recent first, with where
+// to resume each video. ?kind=stream|video filters by kind, ?continue=true
+// keeps what can be resumed, for "continue watching" rows, and ?limit=
+// caps the count.
+func (h *HistoryHandler) GetHistory(c *gin.Context) {
+	user, ok := h.requireViewer(c)
+	if !ok {
+		return
+	}
+	kind := c.Query("kind")
+	if kind != "" && kind != viewers.KindStream && kind != viewers.KindVideo {
+		api.Fail(c, http.StatusBadRequest, "kind must be stream or video")
+		return
+	}
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			api.Fail(c, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+	resumable := c.Query("continue") == "true"
+
+	history := []viewers.Entry{}
+	for _, entry := range h.history.History(user.ID) {
+		if (kind != "" && entry.Kind != kind) || (resumable && !entry.Resumable()) {
+			continue
+		}
+		history = append(history, entry)
+		if limit > 0 && len(history) == limit {
+			break
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"count":   len(history),
+		"history": history,
+	})
+}
+
+// ClearHistory removes the caller's watch history
+func (h *HistoryHandler) ClearHistory(c *gin.Context) {
+	user, ok := h.requireViewer(c)
+	if !ok {
+		return
+	}
+	removed := h.history.Forget(user.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"removed": removed,
+	})
+}
+
+// requireViewer returns the signed in caller. History is only kept for
+// accounts, so it aborts for anonymous callers.
+func (h *HistoryHandler) requireViewer(c *gin.Context) (*auth.User, bool) {
+	if !requireAccount(c, h.authService) {
+		return nil, false
+	}
+	user := currentUser(c)
+	if user == nil {
+		api.Fail(c, http.StatusNotFound, "Watch history is only kept when accounts are enabled")
+		return nil, false
+	}
+	return user, true
+}
+
+// viewerOf returns the user a playback request counts for: the signed in
+// caller, whose playback session is linked to them, or else the user the
+// session was linked to. Players pass the session as ?session_id=.
+func viewerOf(c *gin.Context, history *viewers.History, sessionID string) string {
+	if user := currentUser(c); user != nil {
+		history.Link(sessionID, user.ID)
+		return user.ID
+	}
+	return history.UserOf(sessionID)
+}
@@ -1,58 +1,270 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"mime"
 	"net/http"
-	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/m3u8"
+	"live-video/pkg/qoe"
+	"live-video/pkg/storage"
+	"live-video/pkg/upstream"
+	"live-video/pkg/vod"
 
 	"github.com/gin-gonic/gin"
 )
 
 // HLSProxyHandler handles proxying HLS requests to avoid CORS issues
-type HLSProxyHandler struct{}
+type HLSProxyHandler struct {
+	bandwidth *qoe.Bandwidth
+	cdnSigner *storage.CDNSigner
+	cdn       *upstream.Client
+	maxBytes  int64
+
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+	embedSigner      *auth.EmbedSigner
+}
+
+// Limits of proxied responses. Playlists are read whole before they are
+// served, segments are streamed up to the handler's maxBytes.
+const (
+	DefaultProxyMaxBytes  = 64 << 20
+	maxProxyPlaylistBytes = 1 << 20
+)
+
+// proxyPath is the shape of a path the proxy fetches: {streamID}/... made of
+// plain name segments only, so it can't step out of the CDN's live prefix
+var proxyPath = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)+$`)
+
+// proxyTypes are the content types the CDN may answer with, by extension of
+// the requested file
+var proxyTypes = map[string][]string{
+	".m3u8": {"application/vnd.apple.mpegurl", "application/x-mpegurl", "audio/mpegurl", "audio/x-mpegurl"},
+	".ts":   {"video/mp2t", "video/mp2ts", "application/octet-stream", "binary/octet-stream"},
+	".m4s":  {"video/iso.segment", "video/mp4", "audio/mp4", "application/octet-stream", "binary/octet-stream"},
+	".mp4":  {"video/mp4", "audio/mp4", "application/octet-stream", "binary/octet-stream"},
+	".aac":  {"audio/aac", "audio/x-aac", "application/octet-stream", "binary/octet-stream"},
+	".vtt":  {"text/vtt", "text/plain"},
+}
+
+// NewHLSProxyHandler creates a new HLS proxy handler fetching from the CDN
+// through cdn
+func NewHLSProxyHandler(cdn *upstream.Client) *HLSProxyHandler {
+	return &HLSProxyHandler{cdn: cdn, maxBytes: DefaultProxyMaxBytes}
+}
+
+// SetMaxBytes caps the size of proxied segments
+func (h *HLSProxyHandler) SetMaxBytes(maxBytes int64) {
+	h.maxBytes = maxBytes
+}
+
+// Upstream returns the client the proxy fetches from the CDN with
+func (h *HLSProxyHandler) Upstream() *upstream.Client {
+	return h.cdn
+}
+
+// SetBandwidth makes proxied downloads count towards the bandwidth estimate
+// of the playback session given as ?session_id=
+func (h *HLSProxyHandler) SetBandwidth(bandwidth *qoe.Bandwidth) {
+	h.bandwidth = bandwidth
+}
+
+// SetCDNSigner makes the proxy sign its CDN requests, for CDN backends that
+// only serve signed requests
+func (h *HLSProxyHandler) SetCDNSigner(signer *storage.CDNSigner) {
+	h.cdnSigner = signer
+}
 
-// NewHLSProxyHandler creates a new HLS proxy handler
-func NewHLSProxyHandler() *HLSProxyHandler {
-	return &HLSProxyHandler{}
+// SetPlayback makes the proxy check that callers may play the stream a path
+// is under, as the stream's other playback endpoints do. Without it the
+// proxy refuses to sign CDN requests.
+func (h *HLSProxyHandler) SetPlayback(broadcastManager *broadcast.BroadcastManager, authService *auth.Service, embedSigner *auth.EmbedSigner) {
+	h.broadcastManager = broadcastManager
+	h.authService = authService
+	h.embedSigner = embedSigner
 }
 
-// ProxyCDN proxies HLS playlist and segment requests to the CDN
+// ProxyCDN proxies HLS playlist and segment requests to the CDN. Requests
+// for a known stream must pass its playback checks; requests for anything
+// else are only proxied unsigned.
 func (h *HLSProxyHandler) ProxyCDN(c *gin.Context) {
 	// Get the CDN path from the URL
 	// Format: /hls-proxy/{streamID}/playlist.m3u8 or /hls-proxy/{streamID}/{variant}/segment_xxx.ts
-	path := c.Param("path")
+	path := strings.TrimPrefix(c.Param("path"), "/")
+	ext := strings.ToLower(filepath.Ext(path))
+	allowedTypes, known := proxyTypes[ext]
+	if !proxyPath.MatchString(path) || strings.Contains(path, "..") || !known {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid HLS path",
+		})
+		return
+	}
+
+	stream := h.viewedStream(path)
+	if stream == nil && h.cdnSigner != nil {
+		// Signed requests reach what the CDN only serves to viewers
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Stream not found",
+		})
+		return
+	}
+	if stream != nil && !h.requirePlayback(c, stream) {
+		return
+	}
 
 	// Build the CDN URL
-	cdnBaseURL := os.Getenv("CDN_BASE_URL")
-	if cdnBaseURL == "" {
-		cdnBaseURL = "https://cdn.example.com"
+	cdnURL := h.cdn.URL(path)
+	if h.cdnSigner != nil {
+		cdnURL = h.cdnSigner.SignURL(cdnURL)
 	}
-	cdnURL := cdnBaseURL + "/" + strings.TrimPrefix(path, "/")
 
 	// Fetch from CDN
-	resp, err := http.Get(cdnURL)
+	resp, err := h.cdn.Get(c.Request.Context(), cdnURL)
+	if errors.Is(err, upstream.ErrOpen) {
+		// Fail fast while the CDN is down instead of piling up requests
+		if retryAt := h.cdn.Health().RetryAt; retryAt != nil {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(*retryAt).Seconds())+1))
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "CDN unavailable: " + err.Error(),
+		})
+		return
+	}
 	if err != nil {
+		log.Printf("[HLSProxy] Failed to fetch %s from CDN: %v", path, err)
 		c.JSON(http.StatusBadGateway, gin.H{
-			"error": "Failed to fetch from CDN: " + err.Error(),
+			"error": "Failed to fetch from CDN",
 		})
 		return
 	}
 	defer resp.Body.Close()
 
+	// Only media of the expected type and size is passed on, so the proxy
+	// can't be used to read anything else the CDN or a redirect serves
+	if resp.StatusCode != http.StatusOK {
+		c.JSON(resp.StatusCode, gin.H{
+			"error": "CDN returned " + resp.Status,
+		})
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !slices.Contains(allowedTypes, strings.ToLower(contentType)) {
+		log.Printf("[HLSProxy] CDN served %s as %q", path, contentType)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Unexpected content type from CDN",
+		})
+		return
+	}
+	maxBytes := h.maxBytes
+	if ext == ".m3u8" {
+		maxBytes = maxProxyPlaylistBytes
+	}
+	if resp.ContentLength > maxBytes {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Response from CDN too large",
+		})
+		return
+	}
+	var playlist []byte
+	if ext == ".m3u8" {
+		playlist, err = io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+		if err == nil && int64(len(playlist)) > maxBytes {
+			err = fmt.Errorf("over %d bytes", maxBytes)
+		}
+		if err != nil {
+			log.Printf("[HLSProxy] Failed to read %s from CDN: %v", path, err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Failed to fetch from CDN",
+			})
+			return
+		}
+	}
+
 	// Set CORS headers
 	c.Header("Access-Control-Allow-Origin", "*")
 	c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
 	c.Header("Access-Control-Allow-Headers", "Content-Type")
 
 	// Copy headers from CDN response
-	c.Header("Content-Type", resp.Header.Get("Content-Type"))
+	c.Header("Content-Type", contentType)
 	c.Header("Cache-Control", resp.Header.Get("Cache-Control"))
+	c.Header("X-Content-Type-Options", "nosniff")
+	if playlist != nil {
+		if stream != nil {
+			playlist = h.viewerPlaylist(c, stream, playlist)
+		}
+		c.Data(http.StatusOK, contentType, playlist)
+		return
+	}
 	if contentLength := resp.Header.Get("Content-Length"); contentLength != "" {
 		c.Header("Content-Length", contentLength)
 	}
 
 	// Stream the response
 	c.Status(resp.StatusCode)
-	io.Copy(c.Writer, resp.Body)
+	start := time.Now()
+	n, _ := io.Copy(c.Writer, io.LimitReader(resp.Body, maxBytes))
+	if n == maxBytes {
+		log.Printf("[HLSProxy] Cut %s off at %d bytes", path, maxBytes)
+	}
+	h.bandwidth.Observe(c.Query("session_id"), n, time.Since(start))
+}
+
+// viewedStream returns the stream viewers of a proxied path watch, or nil
+func (h *HLSProxyHandler) viewedStream(path string) *broadcast.Stream {
+	if h.broadcastManager == nil {
+		return nil
+	}
+	streamID, _, _ := strings.Cut(path, "/")
+	stream, err := h.broadcastManager.ViewedStream(streamID)
+	if err != nil {
+		return nil
+	}
+	return stream
+}
+
+// requirePlayback aborts unless the caller may play stream: see
+// requirePlayback. An embed token the request carries must be valid too, so
+// a revoked token is refused even where none is needed.
+func (h *HLSProxyHandler) requirePlayback(c *gin.Context, stream *broadcast.Stream) bool {
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return false
+	}
+	token := c.GetHeader("X-Embed-Token")
+	if token == "" {
+		token = c.Query("embed_token")
+	}
+	if token != "" {
+		if _, err := h.embedSigner.Verify(token, stream.ID); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Invalid embed token: " + err.Error(),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// viewerPlaylist trims a media playlist of stream to the DVR window of the
+// caller's viewer class and passes the caller's tokens on to the files the
+// playlist lists
+func (h *HLSProxyHandler) viewerPlaylist(c *gin.Context, stream *broadcast.Stream, playlist []byte) []byte {
+	if !m3u8.IsMaster(playlist) && stream.HasPlaylistWindows() {
+		if window, ok := stream.PlaylistWindow(viewerClass(c, h.authService, h.embedSigner, stream)); ok {
+			playlist = vod.TrimPlaylist(playlist, window, "")
+		}
+	}
+	return tokenizePlaylist(c, playlist, "embed_token", "consent_token")
 }
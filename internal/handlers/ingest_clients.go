@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"log"
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientCertificate returns the client certificate of a request that the
+// server verified against its client CA, or nil
+func clientCertificate(c *gin.Context) *x509.Certificate {
+	state := c.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// SetIngestClients sets the client certificates that may push to a stream
+// instead of its stream key, e.g.
+// {"identities": ["van-01.example.com", "sha256:3f5e..."], "require_certificate": true}
+func (h *BroadcastHandler) SetIngestClients(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req broadcast.IngestClients
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	clients, err := broadcast.ValidateIngestClients(req)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	stream.SetIngestClients(clients)
+	log.Printf("[Broadcast] Ingest clients of stream %s set to %v (certificate required: %v)", streamID, clients.Identities, clients.RequireCertificate)
+
+	c.JSON(http.StatusOK, h.ingestClientsResponse(c, stream))
+}
+
+// GetIngestClients returns the client certificates that may push to a
+// stream and the identities of the certificate the request was made with
+func (h *BroadcastHandler) GetIngestClients(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	c.JSON(http.StatusOK, h.ingestClientsResponse(c, stream))
+}
+
+// ingestClientsResponse lists a stream's ingest clients. The identities of
+// the caller's own certificate help list a publisher from its host.
+func (h *BroadcastHandler) ingestClientsResponse(c *gin.Context, stream *broadcast.Stream) gin.H {
+	response := gin.H{
+		"success":        true,
+		"ingest_clients": stream.IngestClients(),
+	}
+	if cert := clientCertificate(c); cert != nil {
+		response["client_identities"] = broadcast.ClientIdentities(cert)
+	}
+	return response
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"live-video/internal/api"
+	"live-video/pkg/region"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetIngestRegions makes broadcasters ask which region to send their
+// streams to
+func (h *BroadcastHandler) SetIngestRegions(regions *region.Selector) {
+	h.regions = regions
+}
+
+// GetIngestEndpoint returns where the broadcaster of a stream sends it: the
+// endpoints of the region it named (?region=), of the region with the lowest
+// round trip time it probed (?rtt=eu-west:38,us-east:112), or of the region
+// serving its country. The regions are listed with their probe URLs, so
+// broadcasters can time them and ask again.
+func (h *BroadcastHandler) GetIngestEndpoint(c *gin.Context) {
+	streamID := c.Param("id")
+	if !h.requireIngest(c, streamID) {
+		return
+	}
+	if h.regions == nil {
+		api.Fail(c, http.StatusNotFound, "Ingest regions are not configured")
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	var selection region.Selection
+	if name := c.Query("region"); name != "" {
+		r, ok := h.regions.Get(name)
+		if !ok {
+			api.Fail(c, http.StatusBadRequest, fmt.Sprintf("Unknown ingest region %q", name))
+			return
+		}
+		selection = region.Selection{Region: r, By: region.ByRequest}
+	} else {
+		rtts, err := region.ParseRTTs(c.Query("rtt"))
+		if err != nil {
+			api.Fail(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		selection = h.regions.Select(c.Request, c.ClientIP(), rtts)
+	}
+
+	base := selection.Region.APIBaseURL + "/api/v1/streams/" + url.PathEscape(streamID)
+	endpoints := gin.H{
+		"stream":       base,
+		"webrtc_offer": base + "/webrtc/offer",
+	}
+	// The SRT listener stays on the replica that allocated its port
+	if srt := h.broadcastManager.SRTEndpoint(stream); srt != nil {
+		endpoints["srt"] = srt
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"stream_id":   streamID,
+		"region":      selection.Region.Name,
+		"selected_by": selection.By,
+		"country":     selection.Country,
+		"endpoints":   endpoints,
+		"regions":     h.regions.Regions(),
+	})
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/orchestrator"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InputsRequest sets the inputs of a stream, e.g.
+// {"inputs": [{"kind": "webrtc", "priority": 0}, {"kind": "rtmp", "url": "rtmp://...", "priority": 1}], "failover_window": 5}
+type InputsRequest struct {
+	Inputs         []orchestrator.Input `json:"inputs"`
+	FailoverWindow float64              `json:"failover_window"` // seconds, 0 for the server default
+}
+
+// SetFailoverWindow sets how long an input may deliver nothing before the
+// pipeline fails over, for streams that set no window of their own
+func (h *BroadcastHandler) SetFailoverWindow(window time.Duration) {
+	h.failoverWindow = window
+}
+
+// SetStreamInputs sets the inputs a stream's pipeline fails over between
+func (h *BroadcastHandler) SetStreamInputs(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req InputsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	inputs, err := orchestrator.ValidateInputs(c.Request.Context(), req.Inputs, h.sourcePolicy)
+	if err == nil {
+		err = stream.SetInputs(inputs, time.Duration(req.FailoverWindow*float64(time.Second)))
+	}
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("[Broadcast] Inputs of stream %s set to %v", streamID, orchestrator.RedactInputs(inputs))
+
+	// A started stream pulling from RTMP, SRT, RTSP or a remote URL needs no
+	// broadcaster to start its pipeline
+	if stream.CurrentStatus().Active() {
+		h.startPullPipeline(stream)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"inputs":  h.inputsResponse(stream),
+	})
+}
+
+// GetStreamInputs returns a stream's inputs and, while its pipeline runs,
+// which one is playing
+func (h *BroadcastHandler) GetStreamInputs(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"inputs":  h.inputsResponse(stream),
+	})
+}
+
+func (h *BroadcastHandler) inputsResponse(stream *broadcast.Stream) gin.H {
+	inputs, window := stream.Inputs()
+	if window == 0 {
+		window = h.streamFailoverWindow(stream)
+	}
+	response := gin.H{
+		"inputs":                  inputs,
+		"failover_window_seconds": window.Seconds(),
+	}
+	if endpoint := h.broadcastManager.SRTEndpoint(stream); endpoint != nil {
+		response["srt"] = endpoint
+	}
+	if orch := stream.GetOrchestrator(); orch != nil && orch.IsRunning() {
+		if live := orch.Inputs(); live != nil {
+			response["live"] = live
+		}
+	}
+	return response
+}
+
+// streamFailoverWindow returns the failover window of stream
+func (h *BroadcastHandler) streamFailoverWindow(stream *broadcast.Stream) time.Duration {
+	if _, window := stream.Inputs(); window > 0 {
+		return window
+	}
+	if h.failoverWindow > 0 {
+		return h.failoverWindow
+	}
+	return orchestrator.DefaultFailoverWindow
+}
+
+// startPullPipeline starts the pipeline of a started stream with an RTMP,
+// SRT or RTSP input, or an SRT listener, that is not running yet
+func (h *BroadcastHandler) startPullPipeline(stream *broadcast.Stream) {
+	if !orchestrator.HasPullInput(stream.PipelineInputs()) {
+		return
+	}
+	if orch := stream.GetOrchestrator(); orch != nil && orch.IsRunning() {
+		return
+	}
+	go func() {
+		if err := h.startStreamOrchestrator(stream, nil); err != nil {
+			log.Printf("[Orchestrator] Error: Failed to start pipeline of stream %s: %v", stream.ID, err)
+			stream.Fail(err)
+		}
+	}()
+}
+
+// AudioInputsRequest sets the extra audio inputs of a stream, e.g.
+// {"audio_inputs": [{"id": "es", "url": "srt://translator:9001", "mode": "alternate", "language": "es", "label": "Español"}]}
+type AudioInputsRequest struct {
+	AudioInputs []config.AudioInput `json:"audio_inputs"`
+}
+
+// SetAudioInputs sets the audio sources mixed into a stream's program audio
+// or offered as alternate audio renditions
+func (h *BroadcastHandler) SetAudioInputs(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req AudioInputsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	inputs, err := config.ValidateAudioInputs(c.Request.Context(), req.AudioInputs, h.sourcePolicy)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	stream.SetAudioInputs(inputs)
+	log.Printf("[Broadcast] Audio inputs of stream %s set to %d inputs", streamID, len(inputs))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"audio_inputs": h.audioInputsResponse(stream),
+	})
+}
+
+// GetAudioInputs returns a stream's extra audio inputs and those its running
+// pipeline uses
+func (h *BroadcastHandler) GetAudioInputs(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"audio_inputs": h.audioInputsResponse(stream),
+	})
+}
+
+// audioInputsResponse lists the configured audio inputs and, while the
+// pipeline runs, the ones it was started with. They differ until the
+// pipeline restarts.
+func (h *BroadcastHandler) audioInputsResponse(stream *broadcast.Stream) gin.H {
+	configured := stream.AudioInputs()
+	response := gin.H{"configured": configured}
+	if orch := stream.GetOrchestrator(); orch != nil && orch.IsRunning() {
+		running := orch.AudioInputs()
+		response["running"] = running
+		response["pending_restart"] = !reflect.DeepEqual(configured, running)
+	}
+	return response
+}
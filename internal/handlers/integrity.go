@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"live-video/internal/api"
+	"live-video/pkg/integrity"
+	"live-video/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IntegrityHandler lets distributors verify signed playlists and segments
+type IntegrityHandler struct {
+	signer *integrity.Signer
+}
+
+// NewIntegrityHandler creates an integrity handler. A nil signer means
+// playlists aren't signed.
+func NewIntegrityHandler(signer *integrity.Signer) *IntegrityHandler {
+	return &IntegrityHandler{signer: signer}
+}
+
+// VerifyRequest is a signature to verify and, optionally, the playlist and
+// segment digests a distributor serves
+type VerifyRequest struct {
+	Manifest *integrity.Manifest `json:"manifest" binding:"required"`
+	Playlist *string             `json:"playlist"` // playlist as served
+	Segments map[string]string   `json:"segments"` // hex SHA-256 of segments as served, by URI
+}
+
+// GetKey returns the public key playlist signatures are verified with
+func (h *IntegrityHandler) GetKey(c *gin.Context) {
+	if h.signer == nil {
+		api.Fail(c, http.StatusNotFound, "Playlist signing is not enabled")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"algorithm":  "ed25519",
+		"key_id":     h.signer.KeyID(),
+		"public_key": base64.StdEncoding.EncodeToString(h.signer.PublicKey()),
+	})
+}
+
+// Verify checks a playlist signature and compares the playlist and segment
+// digests served downstream with the signed ones
+func (h *IntegrityHandler) Verify(c *gin.Context) {
+	if h.signer == nil {
+		api.Fail(c, http.StatusNotFound, "Playlist signing is not enabled")
+		return
+	}
+
+	var req VerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	response := gin.H{
+		"success":  true,
+		"playlist": req.Manifest.Playlist,
+	}
+	valid := true
+	if err := req.Manifest.Verify(h.signer.PublicKey()); err != nil {
+		valid = false
+		response["signature_error"] = err.Error()
+	}
+	if req.Playlist != nil {
+		matches := integrity.Digest([]byte(*req.Playlist)) == req.Manifest.SHA256
+		valid = valid && matches
+		response["playlist_matches"] = matches
+	}
+	if len(req.Segments) > 0 {
+		mismatched := req.Manifest.Mismatches(req.Segments)
+		valid = valid && len(mismatched) == 0
+		response["mismatched_segments"] = mismatched
+	}
+	response["valid"] = valid
+	c.JSON(http.StatusOK, response)
+}
+
+// publishSignature signs a playlist uploaded to gcsPath from localPath and
+// uploads the signature next to it. data is the playlist as uploaded, read
+// from localPath when nil.
+func publishSignature(ctx context.Context, gcsService *storage.GCSService, signer *integrity.Signer, localPath, gcsPath string, data []byte) error {
+	if signer == nil {
+		return nil
+	}
+	if data == nil {
+		var err error
+		if data, err = os.ReadFile(localPath); err != nil {
+			return fmt.Errorf("failed to read playlist: %w", err)
+		}
+	}
+	manifest, err := signer.Sign(gcsPath, data, filepath.Dir(localPath))
+	if err != nil {
+		return fmt.Errorf("failed to sign playlist: %w", err)
+	}
+	signature, _ := json.Marshal(manifest)
+	return gcsService.UploadBytes(ctx, signature, gcsPath+integrity.SignatureSuffix, "application/json")
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/interactions"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAuthorLength is how long the display name of an anonymous author may be
+const maxAuthorLength = 64
+
+// InteractionHandler records the chat, reactions, polls and cue points of
+// live streams and replays them alongside their recordings
+type InteractionHandler struct {
+	recorder         *interactions.Recorder
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+	embedSigner      *auth.EmbedSigner
+}
+
+// NewInteractionHandler creates a new interaction handler
+func NewInteractionHandler(recorder *interactions.Recorder, broadcastManager *broadcast.BroadcastManager, authService *auth.Service, embedSigner *auth.EmbedSigner) *InteractionHandler {
+	return &InteractionHandler{
+		recorder:         recorder,
+		broadcastManager: broadcastManager,
+		authService:      authService,
+		embedSigner:      embedSigner,
+	}
+}
+
+// InteractionRequest is an interaction with a live stream, e.g.
+// {"kind": "chat", "author": "Ann", "data": {"text": "Hello"}}. Signed in
+// users are the author themselves.
+type InteractionRequest struct {
+	Kind   string         `json:"kind" binding:"required"`
+	Author string         `json:"author"`
+	Data   map[string]any `json:"data"`
+}
+
+// PostInteraction records an interaction with a live stream and relays it
+// to its watchers. Viewers chat, react and vote; cue points and opening or
+// closing polls take the stream key or manage permission.
+func (h *InteractionHandler) PostInteraction(c *gin.Context) {
+	streamID := c.Param("id")
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+
+	var req InteractionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if interactions.Privileged(req.Kind, req.Data) {
+		key := c.GetHeader("X-Stream-Key")
+		if (key == "" || !stream.ValidStreamKey(key)) && !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+			return
+		}
+	}
+
+	// Votes count once per account, or per address without one
+	author, voter := strings.TrimSpace(req.Author), c.ClientIP()
+	if user := currentUser(c); user != nil {
+		author, voter = user.Name, user.ID
+	}
+	if utf8.RuneCountInString(author) > maxAuthorLength {
+		api.Fail(c, http.StatusBadRequest, "author is longer than "+strconv.Itoa(maxAuthorLength)+" characters")
+		return
+	}
+
+	entry, err := h.recorder.Record(streamID, interactions.Entry{Kind: req.Kind, Author: author, Data: req.Data}, voter)
+	switch {
+	case errors.Is(err, interactions.ErrNotLive):
+		api.Fail(c, http.StatusConflict, "Stream is not live")
+		return
+	case errors.Is(err, interactions.ErrInvalid):
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		api.Fail(c, http.StatusConflict, err.Error())
+		return
+	}
+
+	msg, _ := json.Marshal(gin.H{"type": "interaction", "interaction": entry})
+	stream.Broadcast(msg)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":     true,
+		"interaction": entry,
+	})
+}
+
+// ListInteractionSessions lists the recorded sessions of a stream, one per
+// time it went live
+func (h *InteractionHandler) ListInteractionSessions(c *gin.Context) {
+	streamID := c.Param("id")
+	if !h.requireReplay(c, streamID) {
+		return
+	}
+	sessions, err := h.recorder.Sessions(c.Request.Context(), streamID)
+	if err != nil {
+		log.Printf("[Interactions] Failed to list sessions of %s: %v", streamID, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to list sessions")
+		return
+	}
+	if sessions == nil {
+		sessions = []interactions.SessionInfo{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"sessions": sessions,
+	})
+}
+
+// ReplayInteractions returns the interactions of a session in order, with
+// their offset into the session's recording. ?from= and ?to= (seconds)
+// return those of a window, for players fetching as they play or seek.
+func (h *InteractionHandler) ReplayInteractions(c *gin.Context) {
+	streamID := c.Param("id")
+	if !h.requireReplay(c, streamID) {
+		return
+	}
+	from, to := 0.0, -1.0
+	for name, value := range map[string]*float64{"from": &from, "to": &to} {
+		if raw := c.Query(name); raw != "" {
+			parsed, err := strconv.ParseFloat(raw, 64)
+			if err != nil || parsed < 0 {
+				api.Fail(c, http.StatusBadRequest, name+" must be a number of seconds")
+				return
+			}
+			*value = parsed
+		}
+	}
+
+	session, err := h.recorder.Session(c.Request.Context(), streamID, c.Param("session"))
+	if errors.Is(err, os.ErrNotExist) {
+		api.Fail(c, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		log.Printf("[Interactions] Failed to read session %s of %s: %v", c.Param("session"), streamID, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to read session")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"session_id":   session.ID,
+		"stream_id":    session.StreamID,
+		"started_at":   session.StartedAt,
+		"ended_at":     session.EndedAt,
+		"live":         session.EndedAt == nil,
+		"interactions": session.Window(from, to),
+	})
+}
+
+// requireReplay lets through those who may play a stream. Sessions outlive
+// their stream, and then take read permission on it.
+func (h *InteractionHandler) requireReplay(c *gin.Context, streamID string) bool {
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		return requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionRead)
+	}
+	return requirePlayback(c, h.authService, h.embedSigner, stream)
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"live-video/internal/api"
+	"live-video/pkg/broadcast"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Classes of request bodies, each with its own size limit
+const (
+	BodyDefault = "default" // JSON and form requests
+	BodyUpload  = "upload"  // multipart video uploads
+	BodyChunk   = "chunk"   // stream chunk uploads
+)
+
+// BodyLimits are the largest request bodies accepted, in bytes, by class
+type BodyLimits map[string]int64
+
+// DefaultBodyLimits returns the limits used unless configured
+func DefaultBodyLimits() BodyLimits {
+	return BodyLimits{
+		BodyDefault: 1 << 20,
+		BodyUpload:  500 << 20,
+		BodyChunk:   broadcast.MaxChunkSize,
+	}
+}
+
+// ParseBodyLimits parses a comma separated list of class=size entries, e.g.
+// "default=256KB,upload=2GB", over the default limits. Sizes are bytes or
+// take a KB, MB or GB suffix (powers of 1024).
+func ParseBodyLimits(spec string) (BodyLimits, error) {
+	limits := DefaultBodyLimits()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		class, value, ok := strings.Cut(entry, "=")
+		if _, known := limits[class]; !ok || !known {
+			return nil, fmt.Errorf("invalid body limit %q (class=size, class one of default, upload, chunk)", entry)
+		}
+		size, err := parseByteSize(value)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid size in %q", entry)
+		}
+		if class == BodyChunk && size > broadcast.MaxChunkSize {
+			return nil, fmt.Errorf("chunk limit can't exceed %s", formatByteSize(broadcast.MaxChunkSize))
+		}
+		limits[class] = size
+	}
+	return limits, nil
+}
+
+// LimitBodies caps request bodies at the limit of their route's class in
+// routes, keyed by method and route pattern ("POST /api/v1/videos/upload"),
+// or the default class. Bodies declared larger are refused with 413 before
+// they are read; default class bodies of unknown length are read up to the
+// limit first, so handlers binding them never see a cut off body.
+func LimitBodies(limits BodyLimits, routes map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		class, ok := routes[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			class = BodyDefault
+		}
+		limit := limits[class]
+		if limit <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			c.Request.Close = true
+			bodyTooLarge(c, limit)
+			return
+		}
+		if c.Request.ContentLength < 0 && class == BodyDefault {
+			data, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+			if int64(len(data)) > limit {
+				c.Request.Close = true
+				bodyTooLarge(c, limit)
+				return
+			}
+			if err != nil {
+				api.Fail(c, http.StatusBadRequest, "Failed to read request body")
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// tooLarge reports whether err comes from reading past a body limit, and
+// the limit
+func tooLarge(err error) (int64, bool) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return maxErr.Limit, true
+	}
+	return 0, false
+}
+
+// bodyTooLarge answers 413 for a body over limit
+func bodyTooLarge(c *gin.Context, limit int64) {
+	api.FailWith(c, http.StatusRequestEntityTooLarge, "Request body too large. Max size: "+formatByteSize(limit), gin.H{
+		"max_bytes": limit,
+	})
+}
+
+// byteUnits are the size suffixes parseByteSize takes, largest first
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses a size like "512KB", "500MB" or "1048576"
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if number, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, unit = strings.TrimSpace(number), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > (1<<62)/unit {
+		return 0, fmt.Errorf("size too large")
+	}
+	return n * unit, nil
+}
+
+// formatByteSize formats a size in the largest unit that divides it
+func formatByteSize(n int64) string {
+	for _, u := range byteUnits {
+		if n >= u.size && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
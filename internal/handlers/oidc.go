@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcStateCookie = "lv_oidc_state"
+	oidcNonceCookie = "lv_oidc_nonce"
+)
+
+// OIDCHandler handles SSO login through OpenID Connect providers
+type OIDCHandler struct {
+	authService *auth.Service
+	providers   map[string]*auth.OIDCProvider
+	sessionTTL  time.Duration
+}
+
+// NewOIDCHandler creates a new OIDC login handler
+func NewOIDCHandler(authService *auth.Service, providers []*auth.OIDCProvider, sessionTTL time.Duration) *OIDCHandler {
+	byName := make(map[string]*auth.OIDCProvider, len(providers))
+	for _, p := range providers {
+		byName[p.Name()] = p
+	}
+	return &OIDCHandler{
+		authService: authService,
+		providers:   byName,
+		sessionTTL:  sessionTTL,
+	}
+}
+
+// TokenExchangeRequest exchanges a provider ID token for an API token
+type TokenExchangeRequest struct {
+	Provider string `json:"provider" binding:"required"`
+	IDToken  string `json:"id_token" binding:"required"`
+}
+
+// ListProviders returns the configured identity providers
+func (h *OIDCHandler) ListProviders(c *gin.Context) {
+	providers := make([]gin.H, 0, len(h.providers))
+	for name := range h.providers {
+		providers = append(providers, gin.H{
+			"name":      name,
+			"login_url": "/auth/login/" + name,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"providers": providers,
+	})
+}
+
+// Login redirects the browser to the identity provider
+func (h *OIDCHandler) Login(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		api.Fail(c, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	state := randomToken()
+	nonce := randomToken()
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, 600, "/auth", "", c.Request.TLS != nil, true)
+	c.SetCookie(oidcNonceCookie, nonce, 600, "/auth", "", c.Request.TLS != nil, true)
+
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, nonce))
+}
+
+// Callback completes the authorization code flow, creates or updates the
+// account and starts a web session
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		api.Fail(c, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	state, err := c.Cookie(oidcStateCookie)
+	if err != nil || state == "" || state != c.Query("state") {
+		api.Fail(c, http.StatusBadRequest, "Invalid login state")
+		return
+	}
+	nonce, _ := c.Cookie(oidcNonceCookie)
+
+	if errParam := c.Query("error"); errParam != "" {
+		api.Fail(c, http.StatusUnauthorized, "Login failed: "+errParam)
+		return
+	}
+
+	claims, err := provider.Exchange(c.Request.Context(), c.Query("code"), nonce)
+	if err != nil {
+		log.Printf("[OIDC] %s login failed: %v", provider.Name(), err)
+		api.Fail(c, http.StatusUnauthorized, "Login failed")
+		return
+	}
+
+	user, token, expiresAt, ok := h.startSession(c, provider, claims)
+	if !ok {
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookieName, token, int(time.Until(expiresAt).Seconds()), "/", "", c.Request.TLS != nil, true)
+	log.Printf("[OIDC] %s logged in via %s (role: %s)", user.ID, provider.Name(), user.Role)
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// ExchangeToken verifies an ID token obtained by a client directly from the
+// identity provider and returns an API token
+func (h *OIDCHandler) ExchangeToken(c *gin.Context) {
+	var req TokenExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	provider, ok := h.providers[req.Provider]
+	if !ok {
+		api.Fail(c, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(c.Request.Context(), req.IDToken)
+	if err != nil {
+		log.Printf("[OIDC] Token exchange failed for %s: %v", provider.Name(), err)
+		api.Fail(c, http.StatusUnauthorized, "Invalid ID token")
+		return
+	}
+
+	user, token, expiresAt, ok := h.startSession(c, provider, claims)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_at": expiresAt.UTC(),
+		"user":       user,
+	})
+}
+
+// Logout ends the web session
+func (h *OIDCHandler) Logout(c *gin.Context) {
+	if token, err := c.Cookie(sessionCookieName); err == nil {
+		h.authService.RevokeSessionToken(token)
+	}
+	c.SetCookie(sessionCookieName, "", -1, "/", "", c.Request.TLS != nil, true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Logged out",
+	})
+}
+
+func (h *OIDCHandler) startSession(c *gin.Context, provider *auth.OIDCProvider, claims *auth.IDTokenClaims) (*auth.User, string, time.Time, bool) {
+	mapped, err := provider.MapUser(claims)
+	if err != nil {
+		api.Fail(c, http.StatusForbidden, err.Error())
+		return nil, "", time.Time{}, false
+	}
+
+	user := h.authService.UpsertUser(mapped)
+	token, expiresAt, err := h.authService.IssueSessionToken(user.ID, h.sessionTTL)
+	if err != nil {
+		api.Fail(c, http.StatusInternalServerError, "Failed to start session")
+		return nil, "", time.Time{}, false
+	}
+
+	return user, token, expiresAt, true
+}
+
+func randomToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// originalFileNameKey is the object metadata key holding the uploaded file name
+const originalFileNameKey = "original-filename"
+
+// SetPreserveOriginals keeps the source of every converted video in its
+// folder, stored with storageClass (empty for the bucket default)
+func (h *VideoHandler) SetPreserveOriginals(preserve bool, storageClass string) {
+	h.preserveOriginals = preserve
+	h.originalsClass = storageClass
+}
+
+// originalPath returns where the source of a video is kept
+func (h *VideoHandler) originalPath(videoID, fileName string) string {
+	return filepath.Join(h.videoFolder, videoID, "source"+strings.ToLower(filepath.Ext(fileName)))
+}
+
+// preserveLocalOriginal uploads a local source next to the video's HLS
+// output. Failures are logged; the video itself is already published.
+func (h *VideoHandler) preserveLocalOriginal(videoID, localPath, fileName, contentType string) {
+	if !h.preserveOriginals {
+		return
+	}
+	gcsPath := h.originalPath(videoID, fileName)
+	metadata := map[string]string{originalFileNameKey: fileName}
+	if err := h.gcsService.UploadFileAs(context.Background(), localPath, gcsPath, contentType, h.originalsClass, metadata); err != nil {
+		log.Printf("Failed to preserve original of %s: %v", videoID, err)
+	}
+}
+
+// preserveJobOriginal keeps the source object of a finished transcode job.
+// Directly uploaded sources already sit in the video's folder and only get
+// their storage class; other sources are copied there.
+func (h *VideoHandler) preserveJobOriginal(job *jobs.Job, localSource string) {
+	if !h.preserveOriginals {
+		return
+	}
+	metadata := map[string]string{originalFileNameKey: job.FileName}
+	switch {
+	case job.Origin == jobs.OriginDirectUpload:
+		if _, err := h.gcsService.CopyObject(context.Background(), job.SourcePath, job.SourcePath, h.originalsClass, metadata); err != nil {
+			log.Printf("[Job %s] Failed to preserve original: %v", job.ID, err)
+		}
+	case job.Origin == jobs.OriginGCSNotification:
+		if _, err := h.gcsService.CopyObject(context.Background(), job.SourcePath, h.originalPath(job.VideoID, job.SourcePath), h.originalsClass, metadata); err != nil {
+			log.Printf("[Job %s] Failed to preserve original: %v", job.ID, err)
+		}
+	case localSource != "":
+		h.preserveLocalOriginal(job.VideoID, localSource, job.FileName, job.ContentType)
+	}
+}
+
+// findOriginal returns the GCS path of the preserved source of a video
+func (h *VideoHandler) findOriginal(ctx context.Context, videoID string) (string, error) {
+	objects, err := h.gcsService.ListObjects(ctx, filepath.Join(h.videoFolder, videoID, "source."))
+	if err != nil {
+		return "", err
+	}
+	for _, attrs := range objects {
+		if path.Dir(attrs.Name) == path.Join(h.videoFolder, videoID) {
+			return attrs.Name, nil
+		}
+	}
+	return "", errors.New("no original")
+}
+
+// GetOriginal returns a signed download URL for the original upload of a
+// video. Pass ?redirect=true to be redirected to it.
+func (h *VideoHandler) GetOriginal(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionRead) {
+		return
+	}
+
+	gcsPath, err := h.findOriginal(c.Request.Context(), videoID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Original not available for this video")
+		return
+	}
+	attrs, err := h.gcsService.GetObjectAttrs(c.Request.Context(), gcsPath)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Original not available for this video")
+		return
+	}
+
+	fileName := attrs.Metadata[originalFileNameKey]
+	if fileName == "" {
+		fileName = videoID + path.Ext(attrs.Name)
+	}
+	expiration := 1 * time.Hour
+	url, err := h.gcsService.GetSignedDownloadURL(attrs.Name, fileName, expiration)
+	if err != nil {
+		log.Printf("Signed download URL error: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to generate download URL")
+		return
+	}
+
+	if c.Query("redirect") == "true" {
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":       true,
+		"signed_url":    url,
+		"file_name":     fileName,
+		"size":          attrs.Size,
+		"content_type":  attrs.ContentType,
+		"storage_class": attrs.StorageClass,
+		"expires_in":    expiration.String(),
+	})
+}
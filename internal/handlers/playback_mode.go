@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Playback modes a viewer session can pick, from most stable to lowest
+// latency
+const (
+	PlaybackStandard   = "standard"    // HLS from the viewer's DVR window, started a few segments behind the edge
+	PlaybackLowLatency = "low_latency" // HLS trimmed to the live edge, started one segment behind it
+	PlaybackRealtime   = "realtime"    // the broadcaster's chunks relayed over SSE as they arrive
+)
+
+var playbackModes = []string{PlaybackStandard, PlaybackLowLatency, PlaybackRealtime}
+
+func isPlaybackMode(mode string) bool {
+	for _, known := range playbackModes {
+		if mode == known {
+			return true
+		}
+	}
+	return false
+}
+
+// playbackMode returns the mode a request plays stream in: the mode pinned
+// by its embed token, else ?mode=, else standard
+func playbackMode(c *gin.Context, signer *auth.EmbedSigner, stream *broadcast.Stream) (string, error) {
+	if claims, err := embedClaims(c, signer, stream); err == nil && claims.Mode != "" {
+		return claims.Mode, nil
+	}
+	mode := c.Query("mode")
+	if mode == "" {
+		return PlaybackStandard, nil
+	}
+	if !isPlaybackMode(mode) {
+		return "", fmt.Errorf("mode must be one of %s", strings.Join(playbackModes, ", "))
+	}
+	return mode, nil
+}
+
+// lowLatencyStart is how far behind the live edge low-latency sessions start
+func lowLatencyStart() time.Duration {
+	return time.Duration(config.DefaultFFmpegConfig().SegmentDuration) * time.Second
+}
+
+// playbackModeOptions describes the modes stream can be played in now, with
+// the URL each is played from and the latency to expect
+func playbackModeOptions(stream *broadcast.Stream, live bool, playlistURL string) []gin.H {
+	segment := float64(config.DefaultFFmpegConfig().SegmentDuration)
+	realtime := stream.CurrentStatus().Active() && stream.ChunkStats().Received > 0
+	return []gin.H{
+		{
+			"mode":                     PlaybackStandard,
+			"transport":                "hls",
+			"available":                true,
+			"url":                      playlistURL,
+			"expected_latency_seconds": segment * float64(vod.MinLiveSegments+1),
+		},
+		{
+			"mode":                     PlaybackLowLatency,
+			"transport":                "hls",
+			"available":                live,
+			"url":                      fmt.Sprintf("/api/v1/streams/%s/master.m3u8?mode=%s", stream.ID, PlaybackLowLatency),
+			"expected_latency_seconds": 2 * segment,
+		},
+		{
+			"mode":                     PlaybackRealtime,
+			"transport":                "sse",
+			"available":                realtime,
+			"url":                      fmt.Sprintf("/api/v1/streams/%s/watch", stream.ID),
+			"expected_latency_seconds": 1,
+		},
+	}
+}
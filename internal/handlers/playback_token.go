@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/m3u8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hlsProxyPrefix is the path the HLS files of videos are served under
+const hlsProxyPrefix = "/api/v1/hls/"
+
+// playbackTokenTTL is the length of the windows playback tokens are issued
+// in. A URL handed out twice in one window carries the same token, so
+// players polling for it don't reload the video.
+const playbackTokenTTL = 12 * time.Hour
+
+// playbackURL appends a playback token to an /api/v1/hls URL, so players
+// that can't send the session cookie or an API key can load the video. Other
+// URLs are returned as they are.
+func playbackURL(signer *auth.EmbedSigner, rawURL string) string {
+	rest, ok := strings.CutPrefix(rawURL, hlsProxyPrefix)
+	if !ok || signer == nil {
+		return rawURL
+	}
+	videoID, _, _ := strings.Cut(rest, "/")
+	expiresAt := time.Now().Truncate(playbackTokenTTL).Add(2 * playbackTokenTTL)
+	return withQuery(rawURL, "playback_token="+url.QueryEscape(signer.IssuePlayback(videoID, expiresAt)))
+}
+
+// playbackSnapshot returns a copy of a stream snapshot whose video URLs
+// carry playback tokens
+func playbackSnapshot(signer *auth.EmbedSigner, snap *broadcast.StreamSnapshot) *broadcast.StreamSnapshot {
+	played := *snap
+	played.VideoURL = playbackURL(signer, snap.VideoURL)
+	played.HLSPlaylistURL = playbackURL(signer, snap.HLSPlaylistURL)
+	return &played
+}
+
+// requireVideoPlayback aborts with 401/403 unless the request carries a
+// valid playback token for videoID (?playback_token=) or its caller may read
+// the video
+func requireVideoPlayback(c *gin.Context, authService *auth.Service, signer *auth.EmbedSigner, videoID string) bool {
+	if token := c.Query("playback_token"); token != "" && signer != nil {
+		if _, err := signer.VerifyPlayback(token, videoID); err == nil {
+			return true
+		}
+	}
+	return requirePermission(c, authService, auth.ResourceVideo, videoID, auth.PermissionRead)
+}
+
+// tokenizePlaylist appends the tokens of the request named by params to the
+// relative URIs of an HLS playlist, so players carry them to the renditions
+// and segments
+func tokenizePlaylist(c *gin.Context, data []byte, params ...string) []byte {
+	tokens := url.Values{}
+	for _, name := range params {
+		if token := c.Query(name); token != "" {
+			tokens.Set(name, token)
+		}
+	}
+	if len(tokens) == 0 {
+		return data
+	}
+	query := tokens.Encode()
+	tokenize := func(uri string) string {
+		if uri == "" || strings.HasPrefix(uri, "/") || strings.Contains(uri, "://") {
+			return uri
+		}
+		return withQuery(uri, query)
+	}
+	if m3u8.IsMaster(data) {
+		playlist, err := m3u8.ParseMaster(data)
+		if err != nil {
+			return data
+		}
+		playlist.MapURIs(tokenize)
+		return playlist.Encode()
+	}
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return data
+	}
+	playlist.MapURIs(tokenize)
+	return playlist.Encode()
+}
+
+// withQuery appends query to a URL that may already have one
+func withQuery(rawURL, query string) string {
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + query
+	}
+	return rawURL + "?" + query
+}
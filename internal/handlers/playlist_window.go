@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlaylistWindowsRequest sets how many seconds behind the live edge each
+// viewer class can seek, e.g. {"anonymous": 0, "authenticated": 3600}
+type PlaylistWindowsRequest struct {
+	Windows map[string]float64 `json:"windows"`
+}
+
+// SetPlaylistWindows sets the DVR window of each viewer class of a stream
+func (h *BroadcastHandler) SetPlaylistWindows(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req PlaylistWindowsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	windows := make(map[string]time.Duration, len(req.Windows))
+	for class, seconds := range req.Windows {
+		windows[class] = time.Duration(seconds * float64(time.Second))
+	}
+	if err := stream.SetPlaylistWindows(windows); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("[Broadcast] Playlist windows of stream %s set to %v", streamID, windows)
+
+	c.JSON(http.StatusOK, api.NewStreamResponse(stream.Snapshot(), ""))
+}
+
+// MediaPlaylist serves a live media playlist of a stream trimmed to the
+// window of the viewer's class, with segment URIs pointing at the bucket,
+// signed for the CDN when CDN URLs are signed.
+// ?source= picks the source of a redundant stream, by default the active one.
+func (h *BroadcastHandler) MediaPlaylist(c *gin.Context) {
+	stream, err := h.broadcastManager.GetStream(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+
+	rendition := c.Param("rendition")
+	if !isRendition(rendition) {
+		api.Fail(c, http.StatusNotFound, "Rendition not found")
+		return
+	}
+	session, ok := h.watermarkSession(c, stream)
+	if !ok {
+		return
+	}
+	source, ok := h.playbackSource(stream, c.DefaultQuery("source", stream.ActiveStreamID()))
+	if !ok {
+		api.Fail(c, http.StatusNotFound, "Source not found")
+		return
+	}
+
+	// Prefer the HLS output of this node, else what was uploaded
+	localPath := filepath.Join(source.WorkDir().StreamHLS(source.ID), rendition, vod.PlaylistName)
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		data, err = h.gcsService.ReadFile(c.Request.Context(), h.gcsService.Layout().LivePath(source.ID, rendition, vod.PlaylistName))
+	}
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Playlist not available")
+		return
+	}
+
+	window, ok := stream.PlaylistWindow(viewerClass(c, h.authService, h.embedSigner, stream))
+	if !ok {
+		window = -1
+	}
+	lowLatency := false
+	if mode, err := playbackMode(c, h.embedSigner, stream); err == nil && mode == PlaybackLowLatency {
+		window, lowLatency = 0, true
+	}
+	orch := source.GetOrchestrator()
+	if session != nil && (orch == nil || orch.Watermarked()) {
+		data = h.mixWatermark(c, session, source, rendition, data)
+	}
+	if orch != nil {
+		data = vod.MarkDiscontinuities(data, orch.Discontinuities())
+	}
+	base := h.cdnPrefix(source.ID) + rendition + "/"
+	playlist := vod.TrimPlaylist(data, window, base)
+	if lowLatency {
+		playlist = vod.StartBehindEdge(playlist, lowLatencyStart())
+	}
+	if h.cdnSigner != nil {
+		// One signature for the source's folder covers every segment
+		prefix := h.cdnPrefix(source.ID)
+		query := h.cdnSigner.PrefixQuery(prefix)
+		playlist = vod.MapURIs(playlist, func(uri string) string {
+			if !strings.HasPrefix(uri, prefix) {
+				return uri
+			}
+			if strings.Contains(uri, "?") {
+				return uri + "&" + query
+			}
+			return uri + "?" + query
+		})
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Vary", "Authorization, X-Stream-Key, X-Embed-Token, X-Consent-Token, Cookie")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", playlist)
+}
+
+// cdnPrefix returns the CDN URL of a stream's live folder, with a trailing
+// slash
+func (h *BroadcastHandler) cdnPrefix(streamID string) string {
+	return strings.TrimSuffix(h.gcsService.GetHLSMasterPlaylistURL(streamID), vod.PlaylistName)
+}
+
+// variantURL returns how a master playlist of stream links to its media
+// playlists: straight to the bucket, or through MediaPlaylist when the stream
+// has playlist windows, CDN URLs are signed, the session plays in low
+// latency or is watermarked as watermarkID. The embed and consent tokens and
+// low-latency mode of the request are passed on.
+func (h *BroadcastHandler) variantURL(c *gin.Context, stream *broadcast.Stream, watermarkID string) func(streamID, rendition string) string {
+	mode, _ := playbackMode(c, h.embedSigner, stream)
+	if !stream.HasPlaylistWindows() && h.cdnSigner == nil && mode != PlaybackLowLatency && watermarkID == "" {
+		return func(streamID, rendition string) string {
+			return h.cdnPrefix(streamID) + rendition + "/" + vod.PlaylistName
+		}
+	}
+
+	query := url.Values{}
+	for _, name := range []string{"embed_token", "consent_token"} {
+		if token := c.Query(name); token != "" {
+			query.Set(name, token)
+		}
+	}
+	if mode == PlaybackLowLatency {
+		query.Set("mode", mode)
+	}
+	if watermarkID != "" {
+		query.Set("wm", watermarkID)
+	}
+	return func(streamID, rendition string) string {
+		query.Set("source", streamID)
+		return fmt.Sprintf("/api/v1/streams/%s/live/%s/%s?%s", stream.ID, rendition, vod.PlaylistName, query.Encode())
+	}
+}
+
+// playbackSource returns the stream sourceID when it is a playback source of
+// stream
+func (h *BroadcastHandler) playbackSource(stream *broadcast.Stream, sourceID string) (*broadcast.Stream, bool) {
+	sources, err := h.broadcastManager.PlaybackSources(stream.ID)
+	if err != nil {
+		return nil, false
+	}
+	for _, source := range sources {
+		if source.StreamID == sourceID {
+			s, err := h.broadcastManager.GetStream(sourceID)
+			return s, err == nil
+		}
+	}
+	return nil, false
+}
+
+// isRendition reports whether name is a rendition of the live ladder, an
+// alternate audio rendition or a caption track
+func isRendition(name string) bool {
+	for _, profile := range config.DefaultFFmpegConfig().Profiles {
+		if profile.Name == name {
+			return true
+		}
+	}
+	return config.IsAudioRendition(name) || config.IsCaptionRendition(name)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/webrtc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PreflightHandler handles broadcaster preflight checks
+type PreflightHandler struct {
+	preflight   *webrtc.PreflightService
+	authService *auth.Service
+}
+
+// NewPreflightHandler creates a new preflight handler
+func NewPreflightHandler(preflight *webrtc.PreflightService, authService *auth.Service) *PreflightHandler {
+	return &PreflightHandler{
+		preflight:   preflight,
+		authService: authService,
+	}
+}
+
+// PreflightOfferRequest carries the browser's SDP offer with a "probe" data channel
+type PreflightOfferRequest struct {
+	SDP string `json:"sdp" binding:"required"`
+}
+
+// GetConfig returns the ICE servers and probe duration the browser should use
+func (h *PreflightHandler) GetConfig(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":           true,
+		"ice_servers":       h.preflight.ICEServers(),
+		"probe_duration_ms": h.preflight.ProbeDuration().Milliseconds(),
+	})
+}
+
+// StartPreflight answers the browser's offer and starts the checks
+func (h *PreflightHandler) StartPreflight(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	var req PreflightOfferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	id, answer, err := h.preflight.Start(req.SDP)
+	switch {
+	case errors.Is(err, webrtc.ErrInvalidOffer):
+		api.Fail(c, http.StatusBadRequest, "Invalid offer")
+		return
+	case errors.Is(err, webrtc.ErrTooManyPreflights):
+		c.Header("Retry-After", "10")
+		api.Fail(c, http.StatusServiceUnavailable, "Too many preflight runs, try again shortly")
+		return
+	case err != nil:
+		log.Printf("[Preflight] Failed to start: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to start preflight")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":           true,
+		"id":                id,
+		"sdp":               answer,
+		"probe_duration_ms": h.preflight.ProbeDuration().Milliseconds(),
+	})
+}
+
+// GetPreflight returns the readiness report of a preflight run
+func (h *PreflightHandler) GetPreflight(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	report, err := h.preflight.GetReport(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Preflight not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"report":  report,
+	})
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/m3u8"
+	"live-video/pkg/preview"
+	"live-video/pkg/storage"
+	"live-video/pkg/vod"
+	"live-video/pkg/workdir"
+
+	"github.com/gin-gonic/gin"
+)
+
+// previewURLExpiration is how long the URL of a finished preview is valid
+const previewURLExpiration = 24 * time.Hour
+
+// PreviewHandler generates short looping preview clips of videos and streams
+type PreviewHandler struct {
+	tracker          *preview.Tracker
+	gcsService       *storage.GCSService
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+	embedSigner      *auth.EmbedSigner
+	videoFolder      string
+	workDir          *workdir.WorkDir
+}
+
+// NewPreviewHandler creates a new preview handler
+func NewPreviewHandler(tracker *preview.Tracker, gcsService *storage.GCSService, broadcastManager *broadcast.BroadcastManager, authService *auth.Service, embedSigner *auth.EmbedSigner, videoFolder string, workDir *workdir.WorkDir) *PreviewHandler {
+	return &PreviewHandler{
+		tracker:          tracker,
+		gcsService:       gcsService,
+		broadcastManager: broadcastManager,
+		authService:      authService,
+		embedSigner:      embedSigner,
+		videoFolder:      videoFolder,
+		workDir:          workDir,
+	}
+}
+
+// PreviewRequest describes a preview clip. T is where it starts in seconds;
+// for streams it counts from the start of the live window and defaults to
+// the last duration seconds.
+type PreviewRequest struct {
+	T        *float64 `json:"t"`
+	Duration float64  `json:"duration"` // seconds, default 3, max 10
+	Width    int      `json:"width"`    // pixels, default 480, max 1280
+	FPS      int      `json:"fps"`      // default 12, max 30
+	Format   string   `json:"format"`   // gif (default) or webm
+}
+
+// previewSource reads the HLS files a preview is cut from
+type previewSource struct {
+	read  func(name string) ([]byte, error)
+	fetch func(name, local string) error
+}
+
+// CreateVideoPreview queues a preview clip of a video. The clip is stored in
+// the video's folder as previews/{id}.{format}.
+func (h *PreviewHandler) CreateVideoPreview(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionRead) {
+		return
+	}
+	spec, _, ok := bindPreviewSpec(c)
+	if !ok {
+		return
+	}
+
+	folder := filepath.Join(h.videoFolder, videoID)
+	if _, err := h.gcsService.GetObjectAttrs(c.Request.Context(), filepath.Join(folder, vod.PlaylistName)); err != nil {
+		api.Fail(c, http.StatusNotFound, "Video not found")
+		return
+	}
+
+	source := previewSource{
+		read: func(name string) ([]byte, error) {
+			return h.gcsService.ReadFile(context.Background(), path.Join(folder, name))
+		},
+		fetch: func(name, local string) error {
+			return h.gcsService.DownloadFile(context.Background(), path.Join(folder, name), local)
+		},
+	}
+	h.queue(c, "video", videoID, spec, false, source, path.Join(folder, "previews"))
+}
+
+// CreateStreamPreview queues a preview clip of a live stream, cut from its
+// current live window. The clip is stored under the stream's thumbnails as
+// previews/{id}.{format}.
+func (h *PreviewHandler) CreateStreamPreview(c *gin.Context) {
+	streamID := c.Param("id")
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+	spec, fromEnd, ok := bindPreviewSpec(c)
+	if !ok {
+		return
+	}
+
+	h.queue(c, "stream", streamID, spec, fromEnd, liveSource(context.Background(), h.gcsService, stream), h.gcsService.Layout().ThumbnailPath(streamID, "previews"))
+}
+
+// liveSource reads the live HLS output of a stream: the output of this node,
+// else what was uploaded within ctx
+func liveSource(ctx context.Context, gcsService *storage.GCSService, stream *broadcast.Stream) previewSource {
+	localDir := stream.WorkDir().StreamHLS(stream.ID)
+	if _, err := os.Stat(filepath.Join(localDir, vod.PlaylistName)); err == nil {
+		return previewSource{
+			read: func(name string) ([]byte, error) {
+				return os.ReadFile(filepath.Join(localDir, filepath.FromSlash(name)))
+			},
+			fetch: func(name, local string) error {
+				return linkOrCopy(filepath.Join(localDir, filepath.FromSlash(name)), local)
+			},
+		}
+	}
+	layout := gcsService.Layout()
+	return previewSource{
+		read: func(name string) ([]byte, error) {
+			return gcsService.ReadFile(ctx, layout.LivePath(stream.ID, name))
+		},
+		fetch: func(name, local string) error {
+			return gcsService.DownloadFile(ctx, layout.LivePath(stream.ID, name), local)
+		},
+	}
+}
+
+// GetPreview returns the status of a preview and, once it is ready, a URL
+func (h *PreviewHandler) GetPreview(c *gin.Context) {
+	p, err := h.tracker.Get(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Preview not found")
+		return
+	}
+	if p.Kind == "video" {
+		if !requirePermission(c, h.authService, auth.ResourceVideo, p.AssetID, auth.PermissionRead) {
+			return
+		}
+	} else if stream, err := h.broadcastManager.GetStream(p.AssetID); err == nil && !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+
+	response := gin.H{
+		"success": true,
+		"preview": p,
+	}
+	if p.Status == preview.StatusCompleted {
+		url, err := h.gcsService.GetSignedURL(p.GCSPath, previewURLExpiration)
+		if err == nil {
+			response["url"] = url
+			response["expires_in"] = int(previewURLExpiration.Seconds())
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// queue registers a preview, renders it in the background and answers 202
+func (h *PreviewHandler) queue(c *gin.Context, kind, assetID string, spec preview.Spec, fromEnd bool, source previewSource, folder string) {
+	p := h.tracker.Create(kind, assetID, spec)
+	go h.tracker.Run(p.ID, func(p preview.Preview) (string, int64, error) {
+		return h.render(p, fromEnd, source, folder)
+	})
+	log.Printf("[Preview %s] Queued %s preview of %s %s at %.1fs (%gs)", p.ID, spec.Format, kind, assetID, spec.Start, spec.Duration)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":     true,
+		"preview_id":  p.ID,
+		"preview_url": fmt.Sprintf("/api/v1/previews/%s", p.ID),
+	})
+}
+
+// render cuts the clip out of the best rendition and stores it in folder
+func (h *PreviewHandler) render(p preview.Preview, fromEnd bool, source previewSource, folder string) (string, int64, error) {
+	name, data, err := mediaPlaylist(source.read, vod.PlaylistName)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	segments, initURI := vod.Segments(data)
+	if len(segments) == 0 {
+		return "", 0, fmt.Errorf("no segments available")
+	}
+
+	spec := p.Spec
+	last := segments[len(segments)-1]
+	end := last.Start + last.Duration
+	if fromEnd {
+		spec.Start = end - spec.Duration
+		if spec.Start < 0 {
+			spec.Start = 0
+		}
+	}
+	if spec.Start >= end {
+		return "", 0, fmt.Errorf("start %.1fs is beyond the end (%.1fs)", spec.Start, end)
+	}
+
+	var clip []vod.Segment
+	for _, segment := range segments {
+		if segment.Start+segment.Duration > spec.Start && segment.Start < spec.Start+spec.Duration {
+			clip = append(clip, segment)
+		}
+	}
+
+	dir, err := os.MkdirTemp(h.workDir.Uploads(), "preview-"+p.ID+"-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	input, err := clipPlaylist(dir, path.Dir(name), clip, initURI, source.fetch)
+	if err != nil {
+		return "", 0, err
+	}
+	output := filepath.Join(dir, "preview."+spec.Format)
+	if err := preview.Render(context.Background(), input, spec.Start-clip[0].Start, spec, output); err != nil {
+		return "", 0, err
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		return "", 0, err
+	}
+
+	gcsPath := path.Join(folder, p.ID+"."+spec.Format)
+	if err := h.gcsService.UploadFileAs(context.Background(), output, gcsPath, preview.ContentTypes[spec.Format], "", nil); err != nil {
+		return "", 0, err
+	}
+	log.Printf("[Preview %s] Stored %s (%d bytes)", p.ID, gcsPath, info.Size())
+	return gcsPath, info.Size(), nil
+}
+
+// bindPreviewSpec parses and validates a preview request. fromEnd is set
+// when the request has no start.
+func bindPreviewSpec(c *gin.Context) (spec preview.Spec, fromEnd bool, ok bool) {
+	var req PreviewRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			api.Fail(c, http.StatusBadRequest, err.Error())
+			return preview.Spec{}, false, false
+		}
+	}
+
+	spec = preview.Spec{
+		Duration: req.Duration,
+		Width:    req.Width,
+		FPS:      req.FPS,
+		Format:   strings.ToLower(req.Format),
+	}
+	if req.T != nil {
+		spec.Start = *req.T
+	}
+	if err := spec.Normalize(); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return preview.Spec{}, false, false
+	}
+	return spec, req.T == nil, true
+}
+
+// clipPlaylist fetches segments, relative to the media playlist directory
+// base, into dir and writes a media playlist of just them
+func clipPlaylist(dir, base string, segments []vod.Segment, initURI string, fetch func(name, local string) error) (string, error) {
+	playlist := &m3u8.MediaPlaylist{Ended: true}
+	for _, segment := range segments {
+		playlist.Segments = append(playlist.Segments, m3u8.Segment{URI: segment.URI, Duration: segment.Duration})
+	}
+	if initURI != "" && len(playlist.Segments) > 0 {
+		playlist.Segments[0].Tags = []m3u8.Tag{m3u8.NewTag(m3u8.TagMap, m3u8.Attributes{{Key: "URI", Value: m3u8.Quote(initURI)}})}
+	}
+	playlist.Header = []m3u8.Tag{
+		{Name: m3u8.TagVersion, Value: "7"},
+		{Name: m3u8.TagPlaylistType, Value: m3u8.TypeVOD},
+		{Name: m3u8.TagTargetDuration, Value: strconv.Itoa(m3u8.TargetDuration(playlist.Segments))},
+	}
+
+	uris := playlist.URIs()
+	for _, uri := range uris {
+		if strings.Contains(uri, "://") || strings.HasPrefix(path.Clean(uri), "..") || path.IsAbs(uri) {
+			return "", fmt.Errorf("unsupported segment URI %s", uri)
+		}
+		local := filepath.Join(dir, filepath.FromSlash(uri))
+		os.MkdirAll(filepath.Dir(local), 0o755)
+		if err := fetch(path.Join(base, uri), local); err != nil {
+			return "", fmt.Errorf("failed to fetch segment %s: %w", uri, err)
+		}
+	}
+
+	local := filepath.Join(dir, "clip.m3u8")
+	if err := os.WriteFile(local, playlist.Encode(), 0o644); err != nil {
+		return "", err
+	}
+	return local, nil
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/qoe"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetPreviews flags the playback sessions of operators previewing streams,
+// so their playback leaves viewer counts and analytics alone
+func (h *BroadcastHandler) SetPreviews(previews *qoe.Previews) {
+	h.previews = previews
+}
+
+// StartPreviewSession starts a preview session of a stream for accounts
+// managing it, e.g. operators and moderation tools. Playing with the returned
+// session doesn't count as a viewer or in audiences, watch histories,
+// experiments and QoE beacons.
+func (h *BroadcastHandler) StartPreviewSession(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+	if h.previews == nil {
+		api.Fail(c, http.StatusServiceUnavailable, "Previews are not available")
+		return
+	}
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	sessionID := h.previews.Begin(stream.ID)
+	log.Printf("[Broadcast] Preview session %s of stream %s started", sessionID, streamID)
+	c.JSON(http.StatusOK, gin.H{
+		"success":             true,
+		"stream_id":           streamID,
+		"session_id":          sessionID,
+		"master_playlist_url": fmt.Sprintf("/api/v1/streams/%s/master.m3u8?session_id=%s", streamID, sessionID),
+		"watch_url":           fmt.Sprintf("/api/v1/streams/%s/preview-session/watch?session_id=%s", streamID, sessionID),
+		"status":              stream.Snapshot().Status,
+	})
+}
+
+// WatchPreviewSession streams a stream over SSE to a preview session, like
+// WatchStream but without joining as a viewer: previewers skip the viewer
+// limit and the waiting room and don't show in the viewer count
+func (h *BroadcastHandler) WatchPreviewSession(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+	sessionID := c.Query("session_id")
+	if !h.previews.Of(sessionID, streamID) {
+		api.Fail(c, http.StatusForbidden, "Preview session required, start one with POST /api/v1/streams/:id/preview-session")
+		return
+	}
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	viewer := stream.JoinPreview()
+	defer stream.LeavePreview(viewer)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	fmt.Fprintf(c.Writer, "data: {\"type\":\"connected\",\"stream_id\":\"%s\",\"viewer_id\":\"%s\",\"preview\":true}\n\n", streamID, viewer.ID)
+	c.Writer.(http.Flusher).Flush()
+
+	clientClosed := c.Request.Context().Done()
+	ticker := time.NewTicker(30 * time.Second) // Heartbeat
+	defer ticker.Stop()
+	for {
+		select {
+		case data, ok := <-viewer.DataChan:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+			c.Writer.(http.Flusher).Flush()
+
+		case <-ticker.C:
+			fmt.Fprintf(c.Writer, ": heartbeat\n\n")
+			c.Writer.(http.Flusher).Flush()
+			h.previews.Of(sessionID, streamID) // keeps the session flagged
+
+		case <-clientClosed:
+			return
+		}
+	}
+}
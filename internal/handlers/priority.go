@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/orchestrator"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PriorityRequest sets the priority class of a stream, e.g.
+// {"priority": "best_effort"}
+type PriorityRequest struct {
+	Priority string `json:"priority"`
+}
+
+// SetStreamPriority sets which streams the load governor degrades first.
+// Only admins can make a stream critical, which exempts it.
+func (h *BroadcastHandler) SetStreamPriority(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req PriorityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	class, err := orchestrator.ValidatePriority(req.Priority)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if class == orchestrator.PriorityCritical && !requireAdmin(c, h.authService) {
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	stream.SetPriority(class)
+	log.Printf("[Broadcast] Priority of stream %s set to %s", streamID, class)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"priority": class,
+		"stream":   api.NewStreamResource(stream.Snapshot()),
+	})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/jobs"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// progressKeepAlive is how often an idle progress stream sends a comment,
+	// so proxies don't close it during long conversions
+	progressKeepAlive = 15 * time.Second
+	// progressByteStep is how many received bytes make a progress update
+	progressByteStep = 1 << 20
+)
+
+// SetProgress reports the progress of proxied uploads and transcode jobs to
+// tracker, streamed to clients by WatchJobProgress
+func (h *VideoHandler) SetProgress(tracker *jobs.ProgressTracker) {
+	h.progress = tracker
+}
+
+// WatchJobProgress streams the progress of an upload or transcode job as
+// SSE: one event with the current progress, then one per change until the
+// job completes or fails
+func (h *VideoHandler) WatchJobProgress(c *gin.Context) {
+	current, updates, unsubscribe, ok := h.progress.Subscribe(c.Param("id"))
+	if !ok {
+		api.Fail(c, http.StatusNotFound, "No progress for this job")
+		return
+	}
+	defer unsubscribe()
+	if !requirePermission(c, h.authService, auth.ResourceVideo, current.VideoID, auth.PermissionRead) {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	send := func(p jobs.Progress) bool {
+		data, _ := json.Marshal(p)
+		fmt.Fprintf(c.Writer, "event: progress\ndata: %s\n\n", data)
+		c.Writer.Flush()
+		return !p.Finished()
+	}
+	if !send(current) {
+		return
+	}
+
+	clientClosed := c.Request.Context().Done()
+	keepAlive := time.NewTicker(progressKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case p := <-updates:
+			if !send(p) {
+				return
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+		case <-clientClosed:
+			return
+		}
+	}
+}
+
+// startConverting moves a job to the conversion stage, with the duration of
+// its source to measure the conversion against
+func (h *VideoHandler) startConverting(jobID, sourcePath string) {
+	if h.progress == nil {
+		return
+	}
+	duration, _ := h.hlsConverter.GetVideoDuration(sourcePath)
+	h.progress.Update(jobID, func(p *jobs.Progress) {
+		p.Stage = jobs.ProgressConverting
+		p.DurationSeconds = duration
+	})
+}
+
+// trackConversion adds reporting the progress of a job to conversion
+// options: seconds converted and segments uploaded as FFmpeg runs
+func (h *VideoHandler) trackConversion(jobID string, opts vod.Options) vod.Options {
+	if h.progress == nil {
+		return opts
+	}
+	onSegment := opts.OnSegment
+	opts.OnSegment = func(name string) {
+		if onSegment != nil {
+			onSegment(name)
+		}
+		h.progress.Update(jobID, func(p *jobs.Progress) { p.SegmentsUploaded++ })
+	}
+	opts.OnProgress = func(converted float64) {
+		h.progress.Update(jobID, func(p *jobs.Progress) { p.ConvertedSeconds = converted })
+	}
+	return opts
+}
+
+// startUploading moves a job to uploading the segments in dir that the
+// conversion did not publish
+func (h *VideoHandler) startUploading(jobID, dir string, uploaded map[string]bool) {
+	if h.progress == nil {
+		return
+	}
+	segments, _, _ := vod.Files(dir)
+	done := 0
+	for _, segment := range segments {
+		if uploaded[segment] {
+			done++
+		}
+	}
+	h.progress.Update(jobID, func(p *jobs.Progress) {
+		p.Stage = jobs.ProgressUploading
+		p.SegmentsTotal = len(segments)
+		p.SegmentsUploaded = done
+	})
+}
+
+// progressReader counts the bytes read through it towards a job's progress
+type progressReader struct {
+	io.ReadCloser
+	progress *jobs.ProgressTracker
+	jobID    string
+	read     int64
+	reported int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	if r.read-r.reported >= progressByteStep || (err == io.EOF && r.read > r.reported) {
+		r.reported = r.read
+		r.progress.Update(r.jobID, func(p *jobs.Progress) { p.BytesReceived = r.reported })
+	}
+	return n, err
+}
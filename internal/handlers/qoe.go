@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/geoip"
+	"live-video/pkg/qoe"
+	"live-video/pkg/viewers"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// QoEHandler handles playback sessions, QoE beacons and A/B experiments
+type QoEHandler struct {
+	experiments      *qoe.Experiments
+	collector        *qoe.Collector
+	broadcastManager *broadcast.BroadcastManager
+	authService      *auth.Service
+	embedSigner      *auth.EmbedSigner
+	audience         *geoip.Audience
+	bandwidth        *qoe.Bandwidth
+	history          *viewers.History
+	previews         *qoe.Previews
+}
+
+// NewQoEHandler creates a new QoE handler
+func NewQoEHandler(experiments *qoe.Experiments, collector *qoe.Collector, broadcastManager *broadcast.BroadcastManager, authService *auth.Service, embedSigner *auth.EmbedSigner, audience *geoip.Audience) *QoEHandler {
+	return &QoEHandler{
+		experiments:      experiments,
+		collector:        collector,
+		broadcastManager: broadcastManager,
+		authService:      authService,
+		embedSigner:      embedSigner,
+		audience:         audience,
+	}
+}
+
+// SetBandwidth makes sessions report their estimated bandwidth
+func (h *QoEHandler) SetBandwidth(bandwidth *qoe.Bandwidth) {
+	h.bandwidth = bandwidth
+}
+
+// SetHistory links the playback sessions of signed in viewers to them and
+// records their beacons in their watch history
+func (h *QoEHandler) SetHistory(history *viewers.History) {
+	h.history = history
+}
+
+// SetPreviews leaves the sessions of operators previewing streams out of
+// audiences, watch histories, experiments and beacons
+func (h *QoEHandler) SetPreviews(previews *qoe.Previews) {
+	h.previews = previews
+}
+
+// CreateExperimentRequest defines an experiment and its cohorts
+type CreateExperimentRequest struct {
+	Name     string        `json:"name" binding:"required"`
+	StreamID string        `json:"stream_id"`
+	Variants []qoe.Variant `json:"variants" binding:"required"`
+}
+
+// StartSession assigns a playback session to experiment cohorts and returns
+// the player settings for it. Pass ?session_id= to resume an existing session.
+func (h *QoEHandler) StartSession(c *gin.Context) {
+	streamID := c.Param("id")
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		sessionID = uuid.New().String()
+	}
+
+	// Preview sessions play the default player settings and leave no trace
+	var assignments []qoe.Assignment
+	if !h.previews.Of(sessionID, streamID) {
+		h.audience.Seen(c.Request, c.ClientIP(), streamID, sessionID)
+		h.history.Record(viewerOf(c, h.history, sessionID), viewers.Play{
+			Kind:      viewers.KindStream,
+			ID:        streamID,
+			SessionID: sessionID,
+			Position:  -1,
+		})
+		assignments = h.experiments.Assign(sessionID, streamID)
+	}
+	cohorts := make(map[string]string, len(assignments))
+	for _, a := range assignments {
+		cohorts[a.ExperimentID] = a.Variant.Name
+	}
+	config := qoe.PlayerConfigFor(assignments)
+
+	response := gin.H{
+		"success":       true,
+		"session_id":    sessionID,
+		"cohorts":       cohorts,
+		"player_config": config,
+		"beacon_url":    "/api/v1/qoe/beacons",
+	}
+	if len(config.Ladder) > 0 {
+		response["playlist_url"] = fmt.Sprintf("/api/v1/streams/%s/master.m3u8?ladder=%s", streamID, strings.Join(config.Ladder, ","))
+	}
+	addBandwidth(response, h.bandwidth, sessionID, config.Ladder)
+	c.JSON(http.StatusOK, response)
+}
+
+// PostBeacon records a QoE beacon from a viewer. The session's cohorts are
+// derived on the server so results can't be skewed by the client. Beacons of
+// signed in viewers' sessions add to their watch history; those of preview
+// sessions are dropped.
+func (h *QoEHandler) PostBeacon(c *gin.Context) {
+	var beacon qoe.Beacon
+	if err := c.ShouldBindJSON(&beacon); err != nil || beacon.SessionID == "" || beacon.StreamID == "" {
+		api.Fail(c, http.StatusBadRequest, "Invalid beacon: session_id and stream_id are required")
+		return
+	}
+	if h.previews.Flagged(beacon.SessionID) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	h.collector.Record(beacon, h.experiments.Assign(beacon.SessionID, beacon.StreamID))
+	kind := viewers.KindVideo
+	if _, err := h.broadcastManager.GetStream(beacon.StreamID); err == nil {
+		kind = viewers.KindStream
+		h.audience.Seen(c.Request, c.ClientIP(), beacon.StreamID, beacon.SessionID)
+	}
+
+	play := viewers.Play{
+		Kind:      kind,
+		ID:        beacon.StreamID,
+		SessionID: beacon.SessionID,
+		Watched:   time.Duration(beacon.WatchMs) * time.Millisecond,
+		Position:  -1,
+		Duration:  float64(beacon.DurationMs) / 1000,
+	}
+	if beacon.PositionMs > 0 {
+		play.Position = float64(beacon.PositionMs) / 1000
+	}
+	h.history.Record(viewerOf(c, h.history, beacon.SessionID), play)
+	c.Status(http.StatusNoContent)
+}
+
+// ListBeacons returns the most recent beacons (admin)
+func (h *QoEHandler) ListBeacons(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	beacons := h.collector.Recent(c.Query("stream_id"), limit)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"count":   len(beacons),
+		"beacons": beacons,
+	})
+}
+
+// CreateExperiment starts an experiment (admin)
+func (h *QoEHandler) CreateExperiment(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	var req CreateExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	experiment, err := h.experiments.Create(req.Name, req.StreamID, req.Variants)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":    true,
+		"experiment": experiment,
+	})
+}
+
+// ListExperiments returns all experiments (admin)
+func (h *QoEHandler) ListExperiments(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	experiments := h.experiments.List()
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"count":       len(experiments),
+		"experiments": experiments,
+	})
+}
+
+// GetExperiment returns an experiment with its per-cohort QoE results (admin)
+func (h *QoEHandler) GetExperiment(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	experiment, err := h.experiments.Get(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Experiment not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"experiment": experiment,
+		"results":    h.collector.Results(experiment),
+	})
+}
+
+// EndExperiment stops assigning new sessions to an experiment (admin)
+func (h *QoEHandler) EndExperiment(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	experiment, err := h.experiments.End(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Experiment not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"experiment": experiment,
+		"results":    h.collector.Results(experiment),
+	})
+}
+
+// DeleteExperiment removes an experiment and its results (admin)
+func (h *QoEHandler) DeleteExperiment(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	experimentID := c.Param("id")
+	if err := h.experiments.Delete(experimentID); err != nil {
+		api.Fail(c, http.StatusNotFound, "Experiment not found")
+		return
+	}
+	h.collector.Forget(experimentID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Experiment deleted",
+	})
+}
+
+// addBandwidth adds the bandwidth estimate of a playback session and the
+// rendition it can sustain to a response, once the session has downloaded
+// enough to estimate. ladder limits the renditions recommended.
+func addBandwidth(response gin.H, bandwidth *qoe.Bandwidth, sessionID string, ladder []string) {
+	estimate, ok := bandwidth.Estimate(sessionID)
+	if !ok {
+		return
+	}
+	response["bandwidth"] = estimate
+	if rendition := recommendRendition(estimate.SustainableKbps, ladder); rendition != "" {
+		response["recommended_rendition"] = rendition
+	}
+}
+
+// recommendRendition returns the highest rendition of the live ladder whose
+// bitrate fits in kbps, else the lowest one
+func recommendRendition(kbps int, ladder []string) string {
+	var best, lowest *config.TranscodeProfile
+	profiles := config.DefaultFFmpegConfig().Profiles
+	for i := range profiles {
+		profile := &profiles[i]
+		if len(ladder) > 0 && !slices.Contains(ladder, profile.Name) {
+			continue
+		}
+		bitrate := profile.VideoBitrate + profile.AudioBitrate
+		if lowest == nil || bitrate < lowest.VideoBitrate+lowest.AudioBitrate {
+			lowest = profile
+		}
+		if bitrate <= kbps && (best == nil || bitrate > best.VideoBitrate+best.AudioBitrate) {
+			best = profile
+		}
+	}
+	if best == nil {
+		best = lowest
+	}
+	if best == nil {
+		return ""
+	}
+	return best.Name
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"live-video/pkg/upstream"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadinessHandler reports whether the node can serve traffic, along with
+// the health of the upstreams it depends on
+type ReadinessHandler struct {
+	upstreams map[string]*upstream.Client
+}
+
+// NewReadinessHandler creates a readiness handler without upstreams
+func NewReadinessHandler() *ReadinessHandler {
+	return &ReadinessHandler{upstreams: make(map[string]*upstream.Client)}
+}
+
+// AddUpstream reports the health of an upstream under name
+func (h *ReadinessHandler) AddUpstream(name string, client *upstream.Client) {
+	h.upstreams[name] = client
+}
+
+// Ready reports the node ready along with the health of its upstreams. An
+// open breaker marks the node degraded but keeps it ready: the upstream is
+// shared by every node, so taking this one out of rotation wouldn't help,
+// and requests through it already fail fast.
+func (h *ReadinessHandler) Ready(c *gin.Context) {
+	names := make([]string, 0, len(h.upstreams))
+	for name := range h.upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	status := "ready"
+	upstreams := make(map[string]upstream.Health, len(names))
+	var unhealthy []string
+	for _, name := range names {
+		health := h.upstreams[name].Health()
+		upstreams[name] = health
+		if !health.Healthy() {
+			status = "degraded"
+			unhealthy = append(unhealthy, name)
+		}
+	}
+
+	response := gin.H{
+		"status":    status,
+		"upstreams": upstreams,
+		"timestamp": time.Now().UTC(),
+	}
+	if len(unhealthy) > 0 {
+		response["unhealthy"] = unhealthy
+	}
+	c.JSON(http.StatusOK, response)
+}
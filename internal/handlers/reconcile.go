@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/integrity"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReconciliationReport summarizes how the stream registry and the live
+// output in the bucket were brought in line at startup
+type ReconciliationReport struct {
+	At            time.Time `json:"at"`
+	Streams       int       `json:"streams"`        // restored from their records
+	Interrupted   []string  `json:"interrupted"`    // live when the server stopped, now stopped
+	MissingOutput []string  `json:"missing_output"` // interrupted without playlists in the bucket
+	Orphaned      []string  `json:"orphaned"`       // live output of streams without a record
+	Finalized     []string  `json:"finalized"`      // media playlists ended with EXT-X-ENDLIST
+	Unsigned      []string  `json:"unsigned,omitempty"`
+	Failed        []string  `json:"failed,omitempty"`
+}
+
+// ReconcileStreams restores the recorded streams and ends the live playlists
+// they left behind. Streams that were live when the server stopped come back
+// stopped. The playlists of restored streams are ended since none of them can
+// be live yet; those of streams without a record only once they haven't
+// changed for orphanAge, as another node may still be publishing them, and
+// never when orphanAge is 0.
+func (h *BroadcastHandler) ReconcileStreams(ctx context.Context, orphanAge time.Duration) (*ReconciliationReport, error) {
+	report := &ReconciliationReport{
+		At:            time.Now().UTC(),
+		Interrupted:   []string{},
+		MissingOutput: []string{},
+		Orphaned:      []string{},
+		Finalized:     []string{},
+	}
+	loaded, interrupted, err := h.broadcastManager.LoadRecords()
+	if err != nil {
+		return nil, err
+	}
+	report.Streams = len(loaded)
+
+	playlists, lastUpdated, err := h.liveOutput(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stream := range interrupted {
+		report.Interrupted = append(report.Interrupted, stream.ID)
+		if len(playlists[stream.ID]) == 0 {
+			report.MissingOutput = append(report.MissingOutput, stream.ID)
+		}
+	}
+
+	for _, streamID := range sortedKeys(playlists) {
+		if _, err := h.broadcastManager.GetStream(streamID); err != nil {
+			report.Orphaned = append(report.Orphaned, streamID)
+			if orphanAge <= 0 || time.Since(lastUpdated[streamID]) < orphanAge {
+				continue
+			}
+		}
+		h.endPlaylists(ctx, playlists[streamID], report)
+	}
+
+	h.reconciliation = report
+	log.Printf("[Broadcast] Reconciled %d recorded streams: %d interrupted (%d without output), %d orphaned, %d playlists ended, %d failed",
+		report.Streams, len(report.Interrupted), len(report.MissingOutput), len(report.Orphaned), len(report.Finalized), len(report.Failed))
+	return report, nil
+}
+
+// EndOrphanedPlaylists ends the live playlists of streams without a record
+// whose output hasn't changed for orphanAge, left behind by replicas that
+// are gone. It runs periodically on one replica of the fleet.
+func (h *BroadcastHandler) EndOrphanedPlaylists(ctx context.Context, orphanAge time.Duration) (*ReconciliationReport, error) {
+	report := &ReconciliationReport{
+		At:            time.Now().UTC(),
+		Interrupted:   []string{},
+		MissingOutput: []string{},
+		Orphaned:      []string{},
+		Finalized:     []string{},
+	}
+	playlists, lastUpdated, err := h.liveOutput(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, streamID := range sortedKeys(playlists) {
+		if _, err := h.broadcastManager.GetStream(streamID); err == nil || time.Since(lastUpdated[streamID]) < orphanAge {
+			continue
+		}
+		report.Orphaned = append(report.Orphaned, streamID)
+		h.endPlaylists(ctx, playlists[streamID], report)
+	}
+	if len(report.Finalized) > 0 || len(report.Failed) > 0 {
+		log.Printf("[Broadcast] Ended %d playlists of %d orphaned streams, %d failed", len(report.Finalized), len(report.Orphaned), len(report.Failed))
+	}
+	return report, nil
+}
+
+// liveOutput lists the playlists under the live prefix by stream, and when
+// each stream's output last changed
+func (h *BroadcastHandler) liveOutput(ctx context.Context) (map[string][]string, map[string]time.Time, error) {
+	livePrefix := h.gcsService.Layout().Live + "/"
+	objects, err := h.gcsService.ListObjects(ctx, livePrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	playlists := make(map[string][]string)
+	lastUpdated := make(map[string]time.Time)
+	for _, attrs := range objects {
+		streamID, _, _ := strings.Cut(strings.TrimPrefix(attrs.Name, livePrefix), "/")
+		if path.Ext(attrs.Name) == ".m3u8" {
+			playlists[streamID] = append(playlists[streamID], attrs.Name)
+		}
+		if attrs.Updated.After(lastUpdated[streamID]) {
+			lastUpdated[streamID] = attrs.Updated
+		}
+	}
+	return playlists, lastUpdated, nil
+}
+
+// endPlaylists ends the media playlists in names and renews their
+// signatures, recording the outcome in report
+func (h *BroadcastHandler) endPlaylists(ctx context.Context, names []string, report *ReconciliationReport) {
+	for _, name := range names {
+		ended, err := h.endPlaylist(ctx, name)
+		switch {
+		case err != nil:
+			log.Printf("[Broadcast] Failed to end playlist %s: %v", name, err)
+			report.Failed = append(report.Failed, name)
+		case ended:
+			report.Finalized = append(report.Finalized, name)
+			if err := h.resignPlaylist(ctx, name); err != nil {
+				log.Printf("[Broadcast] Failed to renew signature of %s: %v", name, err)
+				report.Unsigned = append(report.Unsigned, name)
+			}
+		}
+	}
+}
+
+// sortedKeys returns the stream IDs of m in order
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetReconciliation returns the report of the startup reconciliation (admin)
+func (h *BroadcastHandler) GetReconciliation(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+	if h.reconciliation == nil {
+		api.Fail(c, http.StatusNotFound, "Streams were not reconciled at startup")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":        true,
+		"reconciliation": h.reconciliation,
+	})
+}
+
+// endPlaylist ends a media playlist that lacks EXT-X-ENDLIST and reports
+// whether it did. Master playlists are left alone.
+func (h *BroadcastHandler) endPlaylist(ctx context.Context, gcsPath string) (bool, error) {
+	data, err := h.gcsService.ReadFile(ctx, gcsPath)
+	if err != nil {
+		return false, err
+	}
+	data, ended := vod.EndPlaylist(data)
+	if !ended {
+		return false, nil
+	}
+	if err := h.gcsService.UploadBytes(ctx, data, gcsPath, "application/vnd.apple.mpegurl"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// resignPlaylist renews the signature of an ended playlist from the one it
+// had while live. Live signatures can trail their playlist by a segment, in
+// which case the playlist is left without a valid signature.
+func (h *BroadcastHandler) resignPlaylist(ctx context.Context, gcsPath string) error {
+	if h.signer == nil {
+		return nil
+	}
+	data, err := h.gcsService.ReadFile(ctx, gcsPath+integrity.SignatureSuffix)
+	if err != nil {
+		return fmt.Errorf("no signature to renew: %w", err)
+	}
+	var previous integrity.Manifest
+	if err := json.Unmarshal(data, &previous); err != nil {
+		return fmt.Errorf("unreadable signature: %w", err)
+	}
+	playlist, err := h.gcsService.ReadFile(ctx, gcsPath)
+	if err != nil {
+		return err
+	}
+	segments, _ := vod.Segments(playlist)
+	for _, segment := range segments {
+		if _, ok := previous.Segments[segment.URI]; !ok {
+			return fmt.Errorf("signature does not cover segment %s", segment.URI)
+		}
+	}
+	manifest, err := h.signer.Resign(&previous, playlist)
+	if err != nil {
+		return err
+	}
+	signature, _ := json.Marshal(manifest)
+	return h.gcsService.UploadBytes(ctx, signature, gcsPath+integrity.SignatureSuffix, "application/json")
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/orchestrator"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AddRenditionRequest adds a rung of the default ladder to a live stream,
+// e.g. {"name": "1080p"}
+type AddRenditionRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// GetRenditions lists the renditions a live stream encodes
+func (h *BroadcastHandler) GetRenditions(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionRead) {
+		return
+	}
+	orch, ok := h.runningPipeline(c, streamID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"renditions": orch.Renditions(),
+	})
+}
+
+// AddRendition starts encoding a rung of the default ladder on a live
+// stream without restarting its transcoder
+func (h *BroadcastHandler) AddRendition(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req AddRenditionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	profile, ok := ladderProfile(req.Name)
+	if !ok {
+		api.Fail(c, http.StatusBadRequest, "Unknown rendition "+req.Name)
+		return
+	}
+	orch, ok := h.runningPipeline(c, streamID)
+	if !ok {
+		return
+	}
+	if err := orch.AddRendition(profile); err != nil {
+		api.Fail(c, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("[Broadcast] Rendition %s added to stream %s", req.Name, streamID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"renditions": orch.Renditions(),
+	})
+}
+
+// DropRendition takes a rendition off the master playlist of a live stream
+func (h *BroadcastHandler) DropRendition(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+	orch, ok := h.runningPipeline(c, streamID)
+	if !ok {
+		return
+	}
+	rendition := c.Param("rendition")
+	if err := orch.DropRendition(rendition); err != nil {
+		api.Fail(c, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("[Broadcast] Rendition %s dropped from stream %s", rendition, streamID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"renditions": orch.Renditions(),
+	})
+}
+
+// runningPipeline returns the running pipeline of a stream, answering 404 or
+// 409 when there is none
+func (h *BroadcastHandler) runningPipeline(c *gin.Context, streamID string) (*orchestrator.StreamOrchestrator, bool) {
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return nil, false
+	}
+	orch := stream.GetOrchestrator()
+	if orch == nil || !orch.IsRunning() || !stream.CurrentStatus().Active() {
+		api.Fail(c, http.StatusConflict, "Stream is not live")
+		return nil, false
+	}
+	return orch, true
+}
+
+// ladderProfile returns the profile of the default ladder named name
+func ladderProfile(name string) (config.TranscodeProfile, bool) {
+	for _, profile := range config.DefaultFFmpegConfig().Profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return config.TranscodeProfile{}, false
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/viewers"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PositionRequest is a player heartbeat reporting where playback is
+type PositionRequest struct {
+	SessionID       string   `json:"session_id"`
+	PositionSeconds *float64 `json:"position_seconds" binding:"required"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	WatchedSeconds  float64  `json:"watched_seconds"` // since the previous heartbeat
+}
+
+// GetVideoPlayback returns the playback descriptor of a video: the playlist
+// to load and, for signed in viewers, where to resume it
+func (h *VideoHandler) GetVideoPlayback(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionRead) {
+		return
+	}
+	playlist := filepath.Join(h.videoFolder, videoID, vod.PlaylistName)
+	if _, err := h.gcsService.GetObjectAttrs(c.Request.Context(), playlist); err != nil {
+		api.Fail(c, http.StatusNotFound, "Video not found")
+		return
+	}
+
+	sessionID := c.Query("session_id")
+	response := gin.H{
+		"success":      true,
+		"video_id":     videoID,
+		"playlist_url": playbackURL(h.embedSigner, hlsProxyPrefix+videoID+"/"+vod.PlaylistName),
+	}
+	addResume(response, h.history, viewerOf(c, h.history, sessionID), videoID)
+	addBandwidth(response, h.bandwidth, sessionID, nil)
+	c.JSON(http.StatusOK, response)
+}
+
+// ReportVideoPosition records where a signed in viewer is in a video
+func (h *VideoHandler) ReportVideoPosition(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionRead) {
+		return
+	}
+	recordPosition(c, h.history, viewers.KindVideo, videoID)
+}
+
+// ReportStreamPosition records where a signed in viewer is in the replay of
+// a stream
+func (h *BroadcastHandler) ReportStreamPosition(c *gin.Context) {
+	stream, err := h.broadcastManager.GetStream(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return
+	}
+	recordPosition(c, h.history, viewers.KindStream, stream.ID)
+}
+
+// recordPosition adds a player heartbeat to the watch history of the viewer
+// it counts for. Heartbeats of anonymous viewers are accepted and dropped.
+func recordPosition(c *gin.Context, history *viewers.History, kind, assetID string) {
+	var req PositionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: position_seconds is required")
+		return
+	}
+	position := *req.PositionSeconds
+	for _, value := range []float64{position, req.DurationSeconds, req.WatchedSeconds} {
+		if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			api.Fail(c, http.StatusBadRequest, "positions and durations must be positive numbers of seconds")
+			return
+		}
+	}
+
+	userID := viewerOf(c, history, req.SessionID)
+	history.Record(userID, viewers.Play{
+		Kind:      kind,
+		ID:        assetID,
+		SessionID: req.SessionID,
+		Watched:   time.Duration(req.WatchedSeconds * float64(time.Second)),
+		Position:  position,
+		Duration:  req.DurationSeconds,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"recorded": userID != "",
+	})
+}
+
+// addResume adds where userID left off in an asset to a playback
+// descriptor, unless they never played it or watched it to the end
+func addResume(response gin.H, history *viewers.History, userID, assetID string) {
+	if history == nil || userID == "" {
+		return
+	}
+	entry, ok := history.Entry(userID, assetID)
+	if !ok || !entry.Resumable() {
+		return
+	}
+	response["resume"] = gin.H{
+		"position_seconds": entry.PositionSeconds,
+		"duration_seconds": entry.DurationSeconds,
+		"updated_at":       entry.LastWatchedAt,
+	}
+}
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/jobs"
+	"live-video/pkg/staging"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// retranscodeGrace is how long the HLS files replaced by a re-transcode stay
+// in the bucket after the swap, so players that loaded the old playlists
+// can finish
+const retranscodeGrace = time.Hour
+
+// RetranscodeRequest selects the ladder and codec of a re-transcode
+type RetranscodeRequest struct {
+	Ladder []string `json:"ladder"` // profile names, e.g. ["1080p", "720p"]; empty for a single rendition
+	Codec  string   `json:"codec"`  // h264 (default) or hevc
+	HDR    string   `json:"hdr"`    // HDR sources: tonemap (default) or passthrough, hevc only
+
+	// Segment naming instead of the server's; hevc is always fmp4
+	Segments *config.SegmentNaming `json:"segments"`
+}
+
+// RetranscodeVideo converts a published video again with a new ladder or
+// codec. The new output is published next to the old one and the playlist
+// is swapped once it is complete, so the video stays playable throughout.
+func (h *VideoHandler) RetranscodeVideo(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionManage) {
+		return
+	}
+
+	var req RetranscodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		api.Fail(c, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return
+	}
+	if req.Codec == "" {
+		req.Codec = vod.CodecH264
+	}
+	if req.Codec != vod.CodecH264 && req.Codec != vod.CodecHEVC {
+		api.Fail(c, http.StatusBadRequest, fmt.Sprintf("Unsupported codec %q, use h264 or hevc", req.Codec))
+		return
+	}
+	if req.HDR == "" {
+		req.HDR = vod.HDRToneMap
+	}
+	if req.HDR != vod.HDRToneMap && (req.HDR != vod.HDRPassthrough || req.Codec != vod.CodecHEVC) {
+		api.Fail(c, http.StatusBadRequest, fmt.Sprintf("Unsupported hdr %q, use tonemap, or passthrough with hevc", req.HDR))
+		return
+	}
+	if _, err := ladderProfiles(req.Ladder); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Segments != nil {
+		if err := req.Segments.Validate(); err != nil {
+			api.Fail(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		segments := req.Segments.WithDefaults()
+		req.Segments = &segments
+	}
+
+	if _, err := h.gcsService.GetObjectAttrs(c.Request.Context(), filepath.Join(h.videoFolder, videoID, vod.PlaylistName)); err != nil {
+		api.Fail(c, http.StatusNotFound, "Video not found")
+		return
+	}
+
+	var prev *jobs.Job
+	if latest, err := h.jobManager.FindByVideoID(videoID); err == nil {
+		if latest.Status == jobs.StatusQueued || latest.Status == jobs.StatusProcessing {
+			api.FailWith(c, http.StatusConflict, "Video is already being transcoded", gin.H{
+				"job_id": latest.ID,
+			})
+			return
+		}
+		prev = latest
+	}
+
+	var sourcePath, fileName, contentType string
+	var size int64
+	if prev != nil {
+		sourcePath, fileName, contentType, size = prev.SourcePath, prev.FileName, prev.ContentType, prev.Size
+	}
+	job := h.jobManager.Create(jobs.OriginRetranscode, videoID, sourcePath, fileName, contentType, jobs.StatusQueued)
+	h.jobManager.Update(job.ID, func(j *jobs.Job) {
+		j.Ladder = req.Ladder
+		j.Codec = req.Codec
+		j.HDR = req.HDR
+		j.Segments = req.Segments
+		j.Size = size
+	})
+	go h.runRetranscodeJob(job.ID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Re-transcode job queued",
+		"job_id":  job.ID,
+		"job_url": fmt.Sprintf("/api/v1/jobs/%s", job.ID),
+	})
+}
+
+// ladderProfiles resolves rendition names to the configured transcode
+// profiles, highest first as given
+func ladderProfiles(names []string) ([]config.TranscodeProfile, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	profiles := make(map[string]config.TranscodeProfile)
+	var known []string
+	for _, p := range config.DefaultFFmpegConfig().Profiles {
+		profiles[p.Name] = p
+		known = append(known, p.Name)
+	}
+
+	seen := make(map[string]bool)
+	ladder := make([]config.TranscodeProfile, 0, len(names))
+	for _, name := range names {
+		p, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown rendition %q, available: %s", name, strings.Join(known, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate rendition %q", name)
+		}
+		seen[name] = true
+		ladder = append(ladder, p)
+	}
+	return ladder, nil
+}
+
+// runRetranscodeJob fetches the best available source of a video, converts
+// it with the job's ladder and codec and swaps the video's playlist. The new
+// files carry a generation prefix so they never overwrite the files the
+// current playlist refers to; those are deleted after a grace period.
+func (h *VideoHandler) runRetranscodeJob(jobID string) {
+	job, err := h.jobManager.Get(jobID)
+	if err != nil {
+		return
+	}
+
+	h.jobManager.SetStatus(jobID, jobs.StatusProcessing, nil)
+	log.Printf("[Job %s] Re-transcoding %s (codec %s, ladder %v, hdr %s)", jobID, job.VideoID, job.Codec, job.Ladder, job.HDR)
+
+	fail := func(err error) {
+		log.Printf("[Job %s] Re-transcode failed: %v", jobID, err)
+		h.jobManager.SetStatus(jobID, jobs.StatusFailed, err)
+	}
+
+	ladder, err := ladderProfiles(job.Ladder)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	ctx := context.Background()
+	workDir := filepath.Join(h.workDir.Uploads(), "retranscode-"+jobID)
+	defer os.RemoveAll(workDir)
+	sourcePath, err := h.fetchRetranscodeSource(ctx, job, workDir)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	// Everything the new playlists refer to, so the rest can go afterwards
+	generation := "r" + strconv.FormatInt(time.Now().Unix(), 36) + "_"
+	current := map[string]bool{vod.PlaylistName: true}
+	opts := vod.Options{
+		Renditions:    ladder,
+		Codec:         job.Codec,
+		HDR:           job.HDR,
+		Prefix:        generation,
+		HoldPlaylists: true,
+		OnSegment:     func(name string) { current[name] = true },
+		OnDeinterlace: h.recordDeinterlacing(jobID),
+		OnSource:      h.recordSource(jobID),
+	}
+	if job.Segments != nil {
+		opts.Segments = *job.Segments
+	}
+	for _, r := range ladder {
+		current[generation+"playlist_"+r.Name+".m3u8"] = true
+	}
+
+	playlistPath, duration, err := h.convertHLS(sourcePath, job.VideoID, opts, nil)
+	defer h.cleanupHLS(playlistPath)
+	if err != nil {
+		fail(err)
+		return
+	}
+	metadata := h.hlsMetadata(job.VideoID, job.Size, job.ContentType, duration)
+
+	stale, err := h.staleHLSObjects(ctx, job.VideoID, current)
+	if err != nil {
+		log.Printf("[Job %s] Failed to list replaced HLS files: %v", jobID, err)
+	} else if len(stale) > 0 {
+		log.Printf("[Job %s] Deleting %d replaced HLS files in %s", jobID, len(stale), retranscodeGrace)
+		time.AfterFunc(retranscodeGrace, func() {
+			for _, name := range stale {
+				if err := h.gcsService.DeleteVideo(ctx, name); err != nil {
+					log.Printf("[Job %s] Failed to delete replaced file %s: %v", jobID, name, err)
+				}
+			}
+		})
+	}
+
+	// Jobs don't know the source of videos uploaded before jobs were kept
+	if known, ok := h.cataloged(job.VideoID); ok && job.Size == 0 {
+		metadata.Size, metadata.ContentType = known.Size, known.ContentType
+	}
+	h.catalogVideo(ctx, metadata)
+
+	h.jobManager.Update(jobID, func(j *jobs.Job) {
+		j.Status = jobs.StatusCompleted
+		j.Video = metadata
+		j.Playable = true
+	})
+	log.Printf("[Job %s] Re-transcode completed: %s", jobID, metadata.HLSPlaylistURL)
+}
+
+// fetchRetranscodeSource copies the best source of a video into dir: a
+// staged copy of the original, the preserved or uploaded original in the
+// bucket or, when the original is gone, the highest rendition currently
+// published
+func (h *VideoHandler) fetchRetranscodeSource(ctx context.Context, job *jobs.Job, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+
+	for _, entry := range h.staging.List("") {
+		if entry.VideoID != job.VideoID || entry.State == staging.StateQuarantined || !fileExists(entry.SourcePath) {
+			continue
+		}
+		local := filepath.Join(dir, "source"+filepath.Ext(entry.SourcePath))
+		if err := linkOrCopy(entry.SourcePath, local); err == nil {
+			log.Printf("[Job %s] Using staged source %s", job.ID, entry.ID)
+			return local, nil
+		}
+	}
+
+	sourcePath := job.SourcePath
+	if original, err := h.findOriginal(ctx, job.VideoID); err == nil {
+		sourcePath = original
+	}
+	if sourcePath != "" {
+		if _, err := h.gcsService.GetObjectAttrs(ctx, sourcePath); err == nil {
+			local := filepath.Join(dir, "source"+filepath.Ext(sourcePath))
+			if err := h.gcsService.DownloadFile(ctx, sourcePath, local); err != nil {
+				return "", fmt.Errorf("failed to download source")
+			}
+			log.Printf("[Job %s] Using original %s", job.ID, sourcePath)
+			return local, nil
+		}
+	}
+
+	local, rendition, err := h.fetchBestRendition(ctx, job.VideoID, dir)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[Job %s] Original not available, using rendition %s", job.ID, rendition)
+	return local, nil
+}
+
+// fetchBestRendition downloads the highest bandwidth media playlist of a
+// video and its segments into dir, returning the local playlist as FFmpeg
+// input and the name of the rendition
+func (h *VideoHandler) fetchBestRendition(ctx context.Context, videoID, dir string) (string, string, error) {
+	folder := filepath.Join(h.videoFolder, videoID)
+	name := vod.PlaylistName
+	data, err := h.gcsService.ReadFile(ctx, filepath.Join(folder, name))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read playlist")
+	}
+	if variant := vod.BestVariant(data); variant != "" {
+		name = variant
+		if data, err = h.gcsService.ReadFile(ctx, filepath.Join(folder, name)); err != nil {
+			return "", "", fmt.Errorf("failed to read variant playlist %s", name)
+		}
+	}
+
+	base := path.Dir(name)
+	for _, segment := range vod.SegmentNames(data) {
+		rel := path.Join(base, segment)
+		if strings.Contains(segment, "://") || strings.HasPrefix(rel, "..") {
+			return "", "", fmt.Errorf("unsupported segment URI %s", segment)
+		}
+		local := filepath.Join(dir, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(local), 0o755)
+		if err := h.gcsService.DownloadFile(ctx, path.Join(folder, rel), local); err != nil {
+			return "", "", fmt.Errorf("failed to download segment %s", segment)
+		}
+	}
+
+	local := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.WriteFile(local, data, 0o644); err != nil {
+		return "", "", fmt.Errorf("failed to write playlist: %w", err)
+	}
+	return local, name, nil
+}
+
+// staleHLSObjects lists the HLS files in a video's folder that are not in
+// current. Sources, thumbnails and other files are left alone.
+func (h *VideoHandler) staleHLSObjects(ctx context.Context, videoID string, current map[string]bool) ([]string, error) {
+	folder := filepath.Join(h.videoFolder, videoID) + "/"
+	objects, err := h.gcsService.ListObjects(ctx, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for _, attrs := range objects {
+		name := strings.TrimPrefix(attrs.Name, folder)
+		if current[name] {
+			continue
+		}
+		switch ext := path.Ext(name); {
+		case ext == ".ts", ext == ".m4s", ext == ".m3u8":
+		case ext == ".mp4" && strings.Contains(path.Base(name), "init"):
+		default:
+			continue
+		}
+		stale = append(stale, attrs.Name)
+	}
+	return stale, nil
+}
+
+// linkOrCopy hard links src to dst, copying it when they are on different
+// file systems
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
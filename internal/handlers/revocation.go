@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/revocation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RevocationHandler revokes embed tokens before they expire
+type RevocationHandler struct {
+	list        *revocation.List
+	signer      *auth.EmbedSigner
+	authService *auth.Service
+}
+
+// NewRevocationHandler creates a new revocation handler
+func NewRevocationHandler(list *revocation.List, signer *auth.EmbedSigner, authService *auth.Service) *RevocationHandler {
+	return &RevocationHandler{
+		list:        list,
+		signer:      signer,
+		authService: authService,
+	}
+}
+
+// RevocationRequest names what to revoke: a token, by itself or its ID, or
+// every token issued to a viewer so far, for one stream or, for admins, all
+type RevocationRequest struct {
+	Token    string `json:"token"`
+	TokenID  string `json:"token_id"`
+	Viewer   string `json:"viewer"`
+	StreamID string `json:"stream_id"`
+	Reason   string `json:"reason"`
+}
+
+// CreateRevocation revokes embed tokens on every replica. Revoking for a
+// stream takes manage permission on it; revoking for all streams takes an
+// admin.
+func (h *RevocationHandler) CreateRevocation(c *gin.Context) {
+	var req RevocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	r := revocation.Revocation{TokenID: req.TokenID, Viewer: req.Viewer, StreamID: req.StreamID, Reason: req.Reason}
+	if req.Token != "" {
+		claims, err := h.signer.Inspect(req.Token)
+		if err != nil {
+			api.Fail(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.StreamID != "" && r.StreamID != claims.StreamID {
+			api.Fail(c, http.StatusBadRequest, "token is for another stream")
+			return
+		}
+		r.TokenID = auth.TokenID(req.Token)
+		r.StreamID = claims.StreamID
+		r.ExpiresAt = time.Unix(claims.ExpiresAt, 0).UTC()
+	}
+	if (r.TokenID == "") == (r.Viewer == "") {
+		api.Fail(c, http.StatusBadRequest, "Revoke one of token, token_id or viewer")
+		return
+	}
+
+	if r.StreamID != "" {
+		if !requirePermission(c, h.authService, auth.ResourceStream, r.StreamID, auth.PermissionManage) {
+			return
+		}
+	} else if !requireAdmin(c, h.authService) {
+		return
+	}
+	if user := currentUser(c); user != nil {
+		r.RevokedBy = user.ID
+	}
+
+	revoked, err := h.list.Revoke(c.Request.Context(), r)
+	if err != nil {
+		log.Printf("[Revocation] Failed to revoke: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to revoke")
+		return
+	}
+	log.Printf("[Revocation] Revoked %s (token %q, viewer %q, stream %q)", revoked.ID, revoked.TokenID, revoked.Viewer, revoked.StreamID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":    true,
+		"revocation": revoked,
+	})
+}
+
+// ListRevocations lists the revocations in force: those covering a stream
+// (?stream_id=) for its managers, all of them for admins
+func (h *RevocationHandler) ListRevocations(c *gin.Context) {
+	streamID := c.Query("stream_id")
+	if streamID != "" {
+		if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+			return
+		}
+	} else if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	revocations := h.list.List(streamID)
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"count":       len(revocations),
+		"revocations": revocations,
+	})
+}
+
+// LiftRevocation lifts a revocation, e.g. one made by mistake. The tokens
+// it covered work again until they expire.
+func (h *RevocationHandler) LiftRevocation(c *gin.Context) {
+	r, err := h.list.Get(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Revocation not found")
+		return
+	}
+	if r.StreamID != "" {
+		if !requirePermission(c, h.authService, auth.ResourceStream, r.StreamID, auth.PermissionManage) {
+			return
+		}
+	} else if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	if err := h.list.Lift(c.Request.Context(), r.ID); err != nil {
+		if errors.Is(err, revocation.ErrNotFound) {
+			api.Fail(c, http.StatusNotFound, "Revocation not found")
+			return
+		}
+		log.Printf("[Revocation] Failed to lift %s: %v", r.ID, err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to lift revocation")
+		return
+	}
+	log.Printf("[Revocation] Lifted %s", r.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Revocation lifted",
+	})
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScheduleRequest sets when a stream is announced to start; null clears it
+type ScheduleRequest struct {
+	ScheduledAt *time.Time `json:"scheduled_at"`
+}
+
+// SetSchedule sets when a stream is announced to start. Until the
+// broadcaster connects, its watch URL plays a slate.
+func (h *BroadcastHandler) SetSchedule(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+
+	var req ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+
+	stream.SetSchedule(req.ScheduledAt)
+	if req.ScheduledAt != nil {
+		log.Printf("[Broadcast] Stream %s scheduled for %s", streamID, req.ScheduledAt.UTC().Format(time.RFC3339))
+	} else {
+		log.Printf("[Broadcast] Schedule of stream %s cleared", streamID)
+	}
+
+	c.JSON(http.StatusOK, api.NewStreamResponse(stream.Snapshot(), ""))
+}
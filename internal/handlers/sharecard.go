@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"live-video/internal/api"
+	"live-video/pkg/broadcast"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Share card sizes
+const (
+	cardImageWidth   = 1200
+	cardPlayerWidth  = 1280
+	cardPlayerHeight = 720
+)
+
+// ShareCard is the Open Graph and Twitter Card metadata of a stream page, so
+// shared links render as rich previews
+type ShareCard struct {
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	URL          string `json:"url"`
+	Image        string `json:"image,omitempty"` // latest live frame, while the stream is live
+	ImageWidth   int    `json:"image_width,omitempty"`
+	PlayerURL    string `json:"player_url,omitempty"`
+	PlayerWidth  int    `json:"player_width,omitempty"`
+	PlayerHeight int    `json:"player_height,omitempty"`
+	TwitterCard  string `json:"twitter_card"` // "player" with an image, else "summary"
+}
+
+// SetPublicBaseURL sets the external base URL of the service used in share
+// cards, e.g. https://video.example.com. When empty it is derived from each
+// request.
+func (h *EmbedHandler) SetPublicBaseURL(baseURL string) {
+	h.publicBaseURL = strings.TrimRight(baseURL, "/")
+}
+
+// WatchPage serves the watch page of a stream with its share card
+func (h *EmbedHandler) WatchPage(c *gin.Context) {
+	streamID := c.Param("streamId")
+	c.HTML(http.StatusOK, "watch.html", gin.H{
+		"title":    "Stream Viewer",
+		"streamId": streamID,
+		"card":     h.shareCard(c, streamID, "/watch/"+streamID),
+		"gate":     h.pageGate(c, streamID, nil),
+	})
+}
+
+// PlayerPage serves the minimal player of a stream with its share card
+func (h *EmbedHandler) PlayerPage(c *gin.Context) {
+	streamID := c.Param("streamId")
+	c.HTML(http.StatusOK, "player.html", gin.H{
+		"title":    "Video Player",
+		"streamId": streamID,
+		"card":     h.shareCard(c, streamID, "/player/"+streamID),
+		"gate":     h.pageGate(c, streamID, nil),
+	})
+}
+
+// GetShareCard returns the share card metadata of a stream
+func (h *EmbedHandler) GetShareCard(c *gin.Context) {
+	streamID := c.Param("id")
+	card := h.shareCard(c, streamID, "/watch/"+streamID)
+	if card == nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"card":    card,
+	})
+}
+
+// shareCard builds the card of a stream page at pagePath, or nil for unknown
+// streams. Embed-only and gated streams only get their title: their frames
+// and player are not public.
+func (h *EmbedHandler) shareCard(c *gin.Context, streamID, pagePath string) *ShareCard {
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		return nil
+	}
+
+	base := h.baseURL(c)
+	name, description := stream.Metadata()
+	card := &ShareCard{
+		Title:       name,
+		Description: description,
+		URL:         base + pagePath,
+		TwitterCard: "summary",
+	}
+	if card.Title == "" {
+		card.Title = "Live stream"
+	}
+	if card.Description == "" {
+		card.Description = "Watch " + card.Title
+	}
+	if stream.IsEmbedOnly() || stream.ContentGate().Gated() {
+		return card
+	}
+
+	if stream.CurrentStatus() == broadcast.StatusStreaming {
+		card.Image = fmt.Sprintf("%s/api/v1/streams/%s/screenshot?width=%d", base, streamID, cardImageWidth)
+		card.ImageWidth = cardImageWidth
+		card.PlayerURL = fmt.Sprintf("%s/player/%s", base, streamID)
+		card.PlayerWidth = cardPlayerWidth
+		card.PlayerHeight = cardPlayerHeight
+		card.TwitterCard = "player"
+	}
+	return card
+}
+
+// baseURL returns the external base URL of the service
+func (h *EmbedHandler) baseURL(c *gin.Context) string {
+	if h.publicBaseURL != "" {
+		return h.publicBaseURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxSignedURLs is how many URLs one batch signs
+	maxSignedURLs = 1000
+	// maxSignedURLExpiration is the longest V4 signed URLs are valid
+	maxSignedURLExpiration = 7 * 24 * time.Hour
+)
+
+// SignedURLsRequest names the objects to sign: paths, every object under a
+// prefix, or both
+type SignedURLsRequest struct {
+	Paths      []string `json:"paths"`
+	Prefix     string   `json:"prefix"`
+	Expiration string   `json:"expiration"` // e.g. "30m"; 1h by default
+}
+
+// GetSignedURLs signs many objects in one call, such as every segment and
+// thumbnail of a video. The URLs share one expiry. It takes read permission
+// on the video or stream of every object.
+func (h *VideoHandler) GetSignedURLs(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+	var req SignedURLsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Paths) == 0 && req.Prefix == "" {
+		api.Fail(c, http.StatusBadRequest, "paths or prefix is required")
+		return
+	}
+	if len(req.Paths) > maxSignedURLs {
+		api.Fail(c, http.StatusBadRequest, fmt.Sprintf("at most %d paths can be signed at once", maxSignedURLs))
+		return
+	}
+
+	expiration, ok := signedURLExpiration(req.Expiration)
+	if !ok {
+		api.Fail(c, http.StatusBadRequest, "expiration must be a duration up to 168h")
+		return
+	}
+
+	// Listing a prefix wider than an asset's folder would reveal the objects
+	// of others
+	if req.Prefix != "" && !isAdmin(c, h.authService) {
+		if _, _, ok := h.gcsService.Layout().AssetOf(req.Prefix); !ok {
+			api.Fail(c, http.StatusBadRequest, "prefix must be inside the folder of a video or stream, e.g. vod/{id}/")
+			return
+		}
+	}
+
+	paths := slices.Clone(req.Paths)
+	if req.Prefix != "" {
+		objects, err := h.gcsService.ListObjects(c.Request.Context(), req.Prefix)
+		if err != nil {
+			log.Printf("Signed URLs error: %v", err)
+			api.Fail(c, http.StatusInternalServerError, "Failed to list objects")
+			return
+		}
+		for _, attrs := range objects {
+			paths = append(paths, attrs.Name)
+		}
+	}
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+	if len(paths) > maxSignedURLs {
+		api.Fail(c, http.StatusBadRequest, fmt.Sprintf("%d objects match, at most %d can be signed at once; narrow the prefix", len(paths), maxSignedURLs))
+		return
+	}
+
+	// Each asset is checked once, whatever the number of its objects
+	checked := make(map[string]bool)
+	for _, gcsPath := range paths {
+		if !validObjectPath(gcsPath) {
+			api.Fail(c, http.StatusBadRequest, "Invalid path: "+gcsPath)
+			return
+		}
+		kind, id, ok := h.gcsService.Layout().AssetOf(gcsPath)
+		if checked[kind+"/"+id] {
+			continue
+		}
+		if !h.canSign(c, kind, id, ok) {
+			api.Fail(c, http.StatusForbidden, "You do not have access to "+gcsPath)
+			return
+		}
+		checked[kind+"/"+id] = true
+	}
+
+	expiresAt := time.Now().Add(expiration).UTC().Truncate(time.Second)
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"urls":       h.gcsService.GetSignedURLs(paths, expiresAt),
+		"count":      len(paths),
+		"expires_in": expiration.String(),
+		"expires_at": expiresAt,
+	})
+}
+
+// canSign reports whether the caller may read the objects of an asset
+// folder: a video's or a stream's by their kind, and either for folders
+// shared by both. Objects outside asset folders take an admin.
+func (h *VideoHandler) canSign(c *gin.Context, kind, id string, inFolder bool) bool {
+	user := currentUser(c)
+	canRead := func(resource string) bool {
+		return h.authService.Can(user, resource, id, auth.PermissionRead)
+	}
+	switch {
+	case !inFolder:
+		return isAdmin(c, h.authService)
+	case kind == "vod":
+		return canRead(auth.ResourceVideo)
+	case kind == "live" || kind == "recordings":
+		return canRead(auth.ResourceStream)
+	}
+	return canRead(auth.ResourceVideo) || canRead(auth.ResourceStream)
+}
+
+// signedURLExpiration parses how long signed URLs are valid, 1h when value
+// is empty and at most as long as V4 signatures allow
+func signedURLExpiration(value string) (time.Duration, bool) {
+	if value == "" {
+		return time.Hour, true
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 || duration > maxSignedURLExpiration {
+		return 0, false
+	}
+	return duration, true
+}
+
+// validObjectPath reports whether gcsPath is a clean, relative object path
+func validObjectPath(gcsPath string) bool {
+	return gcsPath != "" && !strings.HasPrefix(gcsPath, "/") && path.Clean(gcsPath) == gcsPath
+}
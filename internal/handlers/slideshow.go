@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/jobs"
+	"live-video/pkg/vod"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSlideshowImages is how many images one slideshow takes
+const maxSlideshowImages = 500
+
+// slideshowImageExts and slideshowAudioExts list the accepted slideshow files
+var (
+	slideshowImageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true}
+	slideshowAudioExts = map[string]bool{".mp3": true, ".m4a": true, ".aac": true, ".wav": true, ".ogg": true, ".opus": true, ".flac": true}
+)
+
+// slideshowManifest names the slideshow a job renders, in its input directory
+const slideshowManifest = "slideshow.json"
+
+// CreateSlideshow renders a video from images and an audio track, or from
+// audio alone with a poster, and broadcasts it as a stream. The form takes
+// "image" files in order, an "audio" file and "image_seconds", how long each
+// image shows (spread over the audio when left out). Rendering runs as a job.
+func (h *VideoHandler) CreateSlideshow(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, "Expected a multipart/form-data body")
+		return
+	}
+
+	videoID := fmt.Sprintf("%d", time.Now().UnixNano())
+	dir := h.slideshowDir(videoID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("[Slideshow] Failed to create input directory: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to receive slideshow")
+		return
+	}
+	created := false
+	defer func() {
+		if !created {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	var show vod.Slideshow
+	var size int64
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			ext := strings.ToLower(filepath.Ext(part.FileName()))
+			switch part.FormName() {
+			case "image_seconds":
+				value, _ := io.ReadAll(io.LimitReader(part, 16))
+				show.ImageSeconds, err = strconv.ParseFloat(strings.TrimSpace(string(value)), 64)
+				if err != nil || show.ImageSeconds < 0 {
+					err = errors.New("image_seconds must be a number of seconds")
+				}
+			case "image":
+				switch {
+				case !slideshowImageExts[ext]:
+					err = errors.New("invalid image type. Allowed: jpg, png, webp")
+				case len(show.Images) == maxSlideshowImages:
+					err = fmt.Errorf("a slideshow takes at most %d images", maxSlideshowImages)
+				default:
+					path := filepath.Join(dir, fmt.Sprintf("image_%03d%s", len(show.Images), ext))
+					var n int64
+					n, err = receiveFile(path, part)
+					size += n
+					show.Images = append(show.Images, path)
+				}
+			case "audio":
+				switch {
+				case !slideshowAudioExts[ext]:
+					err = errors.New("invalid audio type. Allowed: mp3, m4a, aac, wav, ogg, opus, flac")
+				case show.Audio != "":
+					err = errors.New("only one audio file per slideshow")
+				default:
+					show.Audio = filepath.Join(dir, "audio"+ext)
+					var n int64
+					n, err = receiveFile(show.Audio, part)
+					size += n
+				}
+			}
+			part.Close()
+		}
+		if err != nil {
+			if limit, ok := tooLarge(err); ok {
+				c.Request.Close = true
+				api.FailWith(c, http.StatusRequestEntityTooLarge, "Slideshow too large. Max size: "+formatByteSize(limit), gin.H{
+					"max_bytes": limit,
+				})
+				return
+			}
+			api.Fail(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if err := show.Validate(); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, err := json.Marshal(show)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, slideshowManifest), data, 0o644)
+	}
+	if err != nil {
+		log.Printf("[Slideshow] Failed to write manifest: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to receive slideshow")
+		return
+	}
+
+	h.authService.SetOwner(auth.ResourceVideo, videoID, currentUser(c))
+	job := h.jobManager.Create(jobs.OriginSlideshow, videoID, "", "slideshow.mp4", "video/mp4", jobs.StatusQueued)
+	h.jobManager.Update(job.ID, func(j *jobs.Job) {
+		j.Size = size
+		j.AutoBroadcast = true
+	})
+	created = true
+	go h.runSlideshowJob(job.ID)
+	log.Printf("[Slideshow] Rendering %s: %d images, audio %t", videoID, len(show.Images), show.Audio != "")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success":  true,
+		"message":  "Slideshow job queued",
+		"video_id": videoID,
+		"job_id":   job.ID,
+		"job_url":  fmt.Sprintf("/api/v1/jobs/%s", job.ID),
+	})
+}
+
+// receiveFile writes a form part to path
+func receiveFile(path string, r io.Reader) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// slideshowDir returns the directory holding the inputs of a video's
+// slideshow until it is rendered
+func (h *VideoHandler) slideshowDir(videoID string) string {
+	return filepath.Join(h.workDir.Uploads(), "slideshow-"+videoID)
+}
+
+// runSlideshowJob renders a slideshow to an MP4 file and then runs it
+// through the transcode job, which converts it, publishes it and creates its
+// stream. A job interrupted after rendering resumes from the MP4 file, which
+// is removed once the job finished.
+func (h *VideoHandler) runSlideshowJob(jobID string) {
+	job, err := h.jobManager.Get(jobID)
+	if err != nil {
+		return
+	}
+	output := filepath.Join(h.workDir.Uploads(), "slideshow-"+job.VideoID+".mp4")
+	if cp := h.usableCheckpoint(job); cp != nil {
+		h.runTranscodeJob(jobID)
+		os.Remove(output)
+		return
+	}
+
+	h.jobManager.SetStatus(jobID, jobs.StatusProcessing, nil)
+	fail := func(err error) {
+		log.Printf("[Job %s] Slideshow failed: %v", jobID, err)
+		h.jobManager.SetStatus(jobID, jobs.StatusFailed, err)
+	}
+
+	dir := h.slideshowDir(job.VideoID)
+	defer os.RemoveAll(dir)
+	data, err := os.ReadFile(filepath.Join(dir, slideshowManifest))
+	if err != nil {
+		fail(errors.New("slideshow inputs are gone"))
+		return
+	}
+	var show vod.Slideshow
+	if err := json.Unmarshal(data, &show); err != nil {
+		fail(errors.New("slideshow inputs are unreadable"))
+		return
+	}
+
+	if err := vod.RenderSlideshow(context.Background(), show, output); err != nil {
+		log.Printf("[Job %s] Failed to render slideshow: %v", jobID, err)
+		fail(errors.New("failed to render slideshow, check that the images and audio are readable"))
+		return
+	}
+
+	if info, err := os.Stat(output); err == nil {
+		h.jobManager.Update(jobID, func(j *jobs.Job) { j.Size = info.Size() })
+	}
+	h.saveCheckpoint(jobID, &jobs.Checkpoint{Stage: jobs.StageDownloaded, LocalSource: output})
+	log.Printf("[Job %s] Slideshow rendered, converting", jobID)
+	h.runTranscodeJob(jobID)
+	os.Remove(output)
+}
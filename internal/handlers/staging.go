@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/jobs"
+	"live-video/pkg/staging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListStaging returns staged sources, optionally filtered by ?state= (admin)
+func (h *VideoHandler) ListStaging(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	entries := h.staging.List(staging.State(c.Query("state")))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"count":   len(entries),
+		"entries": entries,
+	})
+}
+
+// GetStaging returns a staged source (admin)
+func (h *VideoHandler) GetStaging(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	entry, err := h.staging.Get(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Staging entry not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"entry":   entry,
+	})
+}
+
+// RetryStaging queues a transcode job that converts a failed source again
+// from its staged copy (admin)
+func (h *VideoHandler) RetryStaging(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	entry, err := h.staging.Get(c.Param("id"))
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Staging entry not found")
+		return
+	}
+	if entry.State != staging.StateFailed {
+		api.Fail(c, http.StatusConflict, fmt.Sprintf("Only failed sources can be retried, this one is %s", entry.State))
+		return
+	}
+
+	checkpoint := &jobs.Checkpoint{Stage: jobs.StageDownloaded, LocalSource: entry.SourcePath}
+	jobID := entry.JobID
+	if jobID != "" {
+		if _, err := h.jobManager.Get(jobID); err != nil {
+			jobID = ""
+		}
+	}
+
+	if jobID != "" {
+		h.jobManager.Update(jobID, func(j *jobs.Job) {
+			j.Status = jobs.StatusQueued
+			j.Error = ""
+			j.Attempts = 0
+			j.Checkpoint = checkpoint
+		})
+	} else {
+		job := h.jobManager.Create(jobs.OriginUpload, entry.VideoID, "", entry.FileName, entry.ContentType, jobs.StatusQueued)
+		jobID = job.ID
+		h.jobManager.Update(jobID, func(j *jobs.Job) {
+			j.Size = entry.Size
+			j.StagingID = entry.ID
+			j.Checkpoint = checkpoint
+		})
+		h.staging.SetJob(entry.ID, jobID)
+	}
+	log.Printf("[Staging] Retrying %s (%s) as job %s", entry.ID, entry.FileName, jobID)
+	go h.runTranscodeJob(jobID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Transcode job queued",
+		"job_id":  jobID,
+		"job_url": fmt.Sprintf("/api/v1/jobs/%s", jobID),
+	})
+}
+
+// DeleteStaging removes a staged or quarantined source (admin)
+func (h *VideoHandler) DeleteStaging(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := h.staging.Get(id); err != nil {
+		api.Fail(c, http.StatusNotFound, "Staging entry not found")
+		return
+	}
+	if err := h.staging.Remove(id); err != nil {
+		api.Fail(c, http.StatusConflict, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Staging entry deleted",
+	})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StorageHandler exposes the bucket layout, applies the bucket settings and
+// migrates objects out of the legacy shared prefix
+type StorageHandler struct {
+	gcsService  *storage.GCSService
+	settings    storage.BucketSettings
+	authService *auth.Service
+}
+
+// NewStorageHandler creates a new storage handler
+func NewStorageHandler(gcsService *storage.GCSService, settings storage.BucketSettings, authService *auth.Service) *StorageHandler {
+	return &StorageHandler{
+		gcsService:  gcsService,
+		settings:    settings,
+		authService: authService,
+	}
+}
+
+// GetLayout returns the bucket prefixes, their lifecycle rules and the other
+// managed bucket settings (admin)
+func (h *StorageHandler) GetLayout(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	lifecycle := h.settings.Lifecycle
+	if lifecycle == nil {
+		lifecycle = []storage.PrefixLifecycle{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"layout":          h.gcsService.Layout(),
+		"lifecycle":       lifecycle,
+		"bucket_settings": h.settings,
+		"legacy_prefix":   storage.LegacyPrefix,
+		"signed_as":       h.gcsService.ServiceAccount(),
+	})
+}
+
+// Bootstrap applies the configured bucket settings, e.g. to a recreated
+// bucket. ?dry_run=true only reports what would change (admin).
+func (h *StorageHandler) Bootstrap(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+	if h.settings.Empty() {
+		api.Fail(c, http.StatusBadRequest, "No bucket settings are configured")
+		return
+	}
+
+	report, err := h.gcsService.Bootstrap(c.Request.Context(), h.settings, c.Query("dry_run") == "true")
+	if err != nil {
+		api.Fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"bootstrap": report,
+	})
+}
+
+// MigrateLegacy moves objects from the legacy prefix (or ?from=) into the
+// layout. ?dry_run=true only reports where each object would go (admin).
+func (h *StorageHandler) MigrateLegacy(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	from := c.DefaultQuery("from", storage.LegacyPrefix)
+	if strings.Trim(from, "/") == "" || h.gcsService.Layout().Overlaps(from) {
+		api.Fail(c, http.StatusBadRequest, "Source prefix overlaps the storage layout")
+		return
+	}
+
+	report, err := h.gcsService.MigrateLegacy(c.Request.Context(), from, c.Query("dry_run") == "true")
+	if err != nil {
+		api.Fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   len(report.Failed) == 0,
+		"migration": report,
+	})
+}
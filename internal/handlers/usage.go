@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/storage"
+	"live-video/pkg/usage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UsageHandler reports bucket storage used per video, stream and tenant
+type UsageHandler struct {
+	ledger      *usage.Ledger
+	gcsService  *storage.GCSService
+	authService *auth.Service
+}
+
+// NewUsageHandler creates a new usage handler
+func NewUsageHandler(ledger *usage.Ledger, gcsService *storage.GCSService, authService *auth.Service) *UsageHandler {
+	return &UsageHandler{
+		ledger:      ledger,
+		gcsService:  gcsService,
+		authService: authService,
+	}
+}
+
+// GetVideoUsage returns the storage used by a video
+func (h *UsageHandler) GetVideoUsage(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionRead) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"usage":   h.ledger.Asset(videoID),
+	})
+}
+
+// GetStreamUsage returns the storage used by a stream's segments,
+// recordings and thumbnails
+func (h *UsageHandler) GetStreamUsage(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionRead) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"usage":   h.ledger.Asset(streamID),
+	})
+}
+
+// GetUsage returns the caller's storage usage and assets. Admins can pass
+// ?scope=all for the usage of every tenant.
+func (h *UsageHandler) GetUsage(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	if listScopeAll(c, h.authService) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"tenants": h.ledger.Tenants(h.tenantOf),
+		})
+		return
+	}
+
+	user := currentUser(c)
+	tenant := &usage.TenantUsage{Tenant: user.ID}
+	assets := []*usage.AssetUsage{}
+	for _, asset := range h.ledger.Assets() {
+		if h.tenantOf(asset.ID) != user.ID {
+			continue
+		}
+		tenant.Bytes += asset.Bytes
+		tenant.Objects += asset.Objects
+		tenant.Assets++
+		assets = append(assets, asset)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"tenant":  tenant,
+		"assets":  assets,
+	})
+}
+
+// RebuildUsage recomputes the totals from a listing of the bucket (admin)
+func (h *UsageHandler) RebuildUsage(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+
+	count, err := h.ledger.Rebuild(c.Request.Context(), h.gcsService)
+	if err != nil {
+		log.Printf("[Usage] Rebuild failed: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to list bucket objects")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"objects": count,
+		"tenants": h.ledger.Tenants(h.tenantOf),
+	})
+}
+
+// tenantOf returns the owner of a video or stream
+func (h *UsageHandler) tenantOf(assetID string) string {
+	for _, kind := range []string{auth.ResourceVideo, auth.ResourceStream} {
+		if own, ok := h.authService.GetOwnership(kind, assetID); ok {
+			return own.OwnerID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The /api/v2 handlers share the work of their v1 counterparts and differ in
+// what they answer: the resource itself rather than an envelope, pages of
+// listings, and the v2 error shape, which api.Fail picks from the route.
+
+// ListStreamsV2 returns a page of the caller's streams, or of all streams for
+// admins with scope=all
+func (h *BroadcastHandler) ListStreamsV2(c *gin.Context) {
+	page, err := api.ParsePage(c)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, api.NewV2StreamPage(h.listStreams(c), page))
+}
+
+// CreateStreamV2 creates a stream and returns it
+func (h *BroadcastHandler) CreateStreamV2(c *gin.Context) {
+	stream, ok := h.createStream(c)
+	if !ok {
+		return
+	}
+	resource := api.NewV2StreamResource(stream.Snapshot())
+	c.Header("Location", resource.Links.Self)
+	c.JSON(http.StatusCreated, resource)
+}
+
+// GetStreamV2 returns a stream the caller may read
+func (h *BroadcastHandler) GetStreamV2(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionRead) {
+		return
+	}
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	c.JSON(http.StatusOK, api.NewV2StreamResource(stream.Snapshot()))
+}
+
+// StartStreamV2 starts broadcasting a stream and returns it
+func (h *BroadcastHandler) StartStreamV2(c *gin.Context) {
+	stream, ok := h.startStream(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, api.NewV2StreamResource(stream.Snapshot()))
+}
+
+// StopStreamV2 stops broadcasting a stream and returns it, with what was
+// cleaned up in its last_cleanup
+func (h *BroadcastHandler) StopStreamV2(c *gin.Context) {
+	stream, ok := h.stopStream(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, api.NewV2StreamResource(stream.Snapshot()))
+}
+
+// DeleteStreamV2 deletes a stream and returns what was cleaned up
+func (h *BroadcastHandler) DeleteStreamV2(c *gin.Context) {
+	report, ok := h.deleteStream(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ListVideosV2 returns a page of the caller's videos, or of all videos for
+// admins with scope=all
+func (h *VideoHandler) ListVideosV2(c *gin.Context) {
+	page, err := api.ParsePage(c)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	videos, ok := h.listVideos(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, api.NewV2VideoPage(videos, h.videoID, page))
+}
+
+// GetVideoV2 returns a video the caller may read
+func (h *VideoHandler) GetVideoV2(c *gin.Context) {
+	videoID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceVideo, videoID, auth.PermissionRead) {
+		return
+	}
+	if h.catalog != nil {
+		video, ok := h.catalog.Get(videoID)
+		if !ok {
+			api.Fail(c, http.StatusNotFound, "Video not found")
+			return
+		}
+		c.JSON(http.StatusOK, api.NewV2VideoResource(video, videoID))
+		return
+	}
+	videos, err := h.gcsService.ListVideos(c.Request.Context(), h.videoFolder)
+	if err != nil {
+		log.Printf("List videos error: %v", err)
+		api.Fail(c, http.StatusInternalServerError, "Failed to list videos")
+		return
+	}
+	for _, video := range videos {
+		if h.videoID(video) == videoID {
+			c.JSON(http.StatusOK, api.NewV2VideoResource(video, videoID))
+			return
+		}
+	}
+	api.Fail(c, http.StatusNotFound, "Video not found")
+}
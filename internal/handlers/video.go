@@ -1,134 +1,385 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
 	"live-video/pkg/broadcast"
+	"live-video/pkg/catalog"
 	"live-video/pkg/hls"
+	"live-video/pkg/integrity"
+	"live-video/pkg/jobs"
+	"live-video/pkg/prefetch"
+	"live-video/pkg/qoe"
+	"live-video/pkg/staging"
 	"live-video/pkg/storage"
+	"live-video/pkg/viewers"
+	"live-video/pkg/vod"
+	"live-video/pkg/workdir"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 // VideoHandler handles video-related HTTP requests
 type VideoHandler struct {
 	gcsService       *storage.GCSService
 	broadcastManager *broadcast.BroadcastManager
+	jobManager       *jobs.Manager
+	authService      *auth.Service
 	videoFolder      string
+	workDir          *workdir.WorkDir
+	staging          *staging.Area
 	hlsConverter     *hls.Converter
+
+	preserveOriginals bool   // keep sources next to their HLS output
+	originalsClass    string // storage class of kept sources
+	deinterlacer      string // filter for interlaced sources, vod.DeinterlaceBwdif by default
+	downmix           string // stereo mix of surround sources, vod.DownmixITU by default
+	surround          bool   // add a surround audio rendition to ladders
+	segments          config.SegmentNaming
+	signer            *integrity.Signer
+	embedSigner       *auth.EmbedSigner // issues and verifies playback tokens
+	segmentCache      *prefetch.Cache
+	bandwidth         *qoe.Bandwidth
+	history           *viewers.History
+	progress          *jobs.ProgressTracker
+	catalog           *catalog.Catalog
+	frameSlots        chan struct{} // frame extractions running, see maxFrameExtractions
 }
 
 // NewVideoHandler creates a new video handler
-func NewVideoHandler(gcsService *storage.GCSService, broadcastManager *broadcast.BroadcastManager, videoFolder string) *VideoHandler {
+func NewVideoHandler(gcsService *storage.GCSService, broadcastManager *broadcast.BroadcastManager, jobManager *jobs.Manager, authService *auth.Service, videoFolder string, workDir *workdir.WorkDir, stagingArea *staging.Area) *VideoHandler {
 	return &VideoHandler{
 		gcsService:       gcsService,
 		broadcastManager: broadcastManager,
+		jobManager:       jobManager,
+		authService:      authService,
 		videoFolder:      videoFolder,
-		hlsConverter:     hls.NewConverter("/tmp/hls"),
+		workDir:          workDir,
+		staging:          stagingArea,
+		hlsConverter:     hls.NewConverter(workDir.VODHLS()),
+		frameSlots:       make(chan struct{}, maxFrameExtractions),
 	}
 }
 
+// allowedVideoExts lists the accepted source video file extensions
+var allowedVideoExts = map[string]bool{
+	".mp4":  true,
+	".mov":  true,
+	".avi":  true,
+	".mkv":  true,
+	".webm": true,
+}
+
 // UploadVideoRequest represents the upload request
 type UploadVideoRequest struct {
 	AutoBroadcast bool `form:"auto_broadcast"`
 }
 
-// UploadVideoResponse represents the upload response
-type UploadVideoResponse struct {
-	Success   bool                   `json:"success"`
-	Message   string                 `json:"message"`
-	Video     *storage.VideoMetadata `json:"video"`
-	StreamID  string                 `json:"stream_id,omitempty"`
-	StreamURL string                 `json:"stream_url,omitempty"`
-}
-
 // UploadVideo handles video upload to GCS
 func (h *VideoHandler) UploadVideo(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
+
+	// auto_broadcast may come as a query parameter or a form field
 	var req UploadVideoRequest
-	if err := c.ShouldBind(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid request parameters",
-		})
+	if err := c.ShouldBindQuery(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request parameters")
 		return
 	}
 
-	// Get uploaded file
-	file, err := c.FormFile("video")
+	// The progress of the upload is reported under its job ID, which clients
+	// pick to watch it while the request is still being sent
+	jobID := c.Query("job_id")
+	if jobID == "" {
+		jobID = uuid.New().String()
+	} else if _, err := uuid.Parse(jobID); err != nil {
+		api.Fail(c, http.StatusBadRequest, "job_id must be a UUID")
+		return
+	}
+	videoID := fmt.Sprintf("%d", time.Now().UnixNano())
+	if !h.progress.Start(jobID, videoID, jobs.ProgressReceiving) {
+		api.Fail(c, http.StatusConflict, "job_id is already in use")
+		return
+	}
+	defer func() {
+		if status := c.Writer.Status(); status >= http.StatusBadRequest {
+			h.progress.Fail(jobID, fmt.Errorf("upload failed: %s", http.StatusText(status)))
+		}
+	}()
+	h.authService.SetOwner(auth.ResourceVideo, videoID, currentUser(c))
+	if h.progress != nil {
+		h.progress.Update(jobID, func(p *jobs.Progress) { p.BytesTotal = max(c.Request.ContentLength, 0) })
+		c.Request.Body = &progressReader{ReadCloser: c.Request.Body, progress: h.progress, jobID: jobID}
+	}
+
+	// The form is read part by part and the video streamed straight into
+	// staging, so uploads are never held in memory or copied through a
+	// temporary file first
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "No video file provided",
-		})
+		api.Fail(c, http.StatusBadRequest, "Expected a multipart/form-data body")
 		return
 	}
 
-	// Validate file type
-	ext := filepath.Ext(file.Filename)
-	allowedExts := map[string]bool{
-		".mp4":  true,
-		".mov":  true,
-		".avi":  true,
-		".mkv":  true,
-		".webm": true,
+	var (
+		entry       *staging.Entry
+		fileName    string
+		contentType string
+		size        int64
+	)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			switch part.FormName() {
+			case "auto_broadcast":
+				value, _ := io.ReadAll(io.LimitReader(part, 16))
+				req.AutoBroadcast, _ = strconv.ParseBool(strings.TrimSpace(string(value)))
+			case "video":
+				if entry != nil {
+					err = errors.New("only one video file per upload")
+					break
+				}
+				fileName = part.FileName()
+
+				// Validate file type before anything is read
+				if !allowedVideoExts[filepath.Ext(fileName)] {
+					part.Close()
+					c.Request.Close = true
+					api.Fail(c, http.StatusBadRequest, "Invalid file type. Allowed: mp4, mov, avi, mkv, webm")
+					return
+				}
+				log.Printf("Uploading video: %s", fileName)
+
+				// Stage the upload; the staging area keeps it until it is
+				// published or its cleanup policy expires, so a failed
+				// conversion can be retried
+				contentType = part.Header.Get("Content-Type")
+				entry, err = h.staging.Receive(videoID, fileName, contentType, func(path string) error {
+					f, err := os.Create(path)
+					if err != nil {
+						return err
+					}
+					size, err = io.Copy(f, part)
+					if cerr := f.Close(); err == nil {
+						err = cerr
+					}
+					return err
+				})
+			}
+			part.Close()
+		}
+		if err != nil {
+			if entry != nil {
+				h.staging.Remove(entry.ID)
+			}
+			if limit, ok := tooLarge(err); ok {
+				c.Request.Close = true
+				api.FailWith(c, http.StatusRequestEntityTooLarge, "File too large. Max size: "+formatByteSize(limit), gin.H{
+					"max_bytes": limit,
+				})
+				return
+			}
+			log.Printf("Failed to stage upload: %v", err)
+			api.Fail(c, http.StatusBadRequest, "Failed to read upload: "+err.Error())
+			return
+		}
 	}
-	if !allowedExts[ext] {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("Invalid file type. Allowed: mp4, mov, avi, mkv, webm"),
-		})
+	if entry == nil {
+		api.Fail(c, http.StatusBadRequest, "No video file provided")
 		return
 	}
+	log.Printf("Uploaded video: %s (%.2f MB)", fileName, float64(size)/(1024*1024))
 
-	// Validate file size (max 500MB)
-	maxSize := int64(500 * 1024 * 1024)
-	if file.Size > maxSize {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   fmt.Sprintf("File too large. Max size: 500MB"),
+	h.progress.Stage(jobID, jobs.ProgressValidating)
+	if err := h.validateStaged(entry); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.stage(entry.ID, staging.StateConverting, nil)
+	h.startConverting(jobID, entry.SourcePath)
+	metadata, err := h.publishHLS(entry.SourcePath, videoID, size, contentType, h.trackConversion(jobID, vod.Options{}))
+	if err != nil {
+		h.stage(entry.ID, staging.StateFailed, err)
+		var unsupported *vod.UnsupportedError
+		if errors.As(err, &unsupported) {
+			api.FailWith(c, http.StatusUnprocessableEntity, err.Error(), gin.H{
+				"staging_id": entry.ID,
+				"suggestion": unsupported.Suggestion(),
+			})
+			return
+		}
+		api.FailWith(c, http.StatusInternalServerError, err.Error(), gin.H{
+			"staging_id": entry.ID,
 		})
 		return
 	}
+	h.preserveLocalOriginal(videoID, entry.SourcePath, fileName, contentType)
+	h.catalogVideo(context.WithoutCancel(c.Request.Context()), metadata)
+	h.stage(entry.ID, staging.StateUploaded, nil)
+	h.progress.Update(jobID, func(p *jobs.Progress) {
+		p.Stage = jobs.ProgressCompleted
+		p.SegmentsTotal = p.SegmentsUploaded
+		p.ConvertedSeconds = max(p.ConvertedSeconds, metadata.Duration)
+	})
 
-	log.Printf("Uploading video: %s (%.2f MB)", file.Filename, float64(file.Size)/(1024*1024))
+	response := api.UploadVideoResponse{
+		Success: true,
+		Message: "Video uploaded successfully",
+		JobID:   jobID,
+		Video:   api.NewVideoResource(metadata, videoID),
+	}
 
-	// Generate UUID for this video
-	videoID := fmt.Sprintf("%d", time.Now().UnixNano())
+	// Auto-create broadcast stream if requested
+	if req.AutoBroadcast {
+		stream := h.createBroadcastForVideo(metadata, currentUser(c))
+		response.StreamID = stream.ID
+		response.StreamURL = api.NewStreamLinks(stream.ID).Self
+	}
 
-	// Save uploaded file temporarily for HLS conversion
-	tempDir := "/tmp/video-uploads"
-	os.MkdirAll(tempDir, 0o755)
-	tempFilePath := filepath.Join(tempDir, file.Filename)
+	c.JSON(http.StatusOK, response)
+}
 
-	if err := c.SaveUploadedFile(file, tempFilePath); err != nil {
-		log.Printf("Failed to save temp file: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to process video",
-		})
+// validateStaged probes a staged source and quarantines it if it isn't a
+// playable video. The returned error message is safe to show to clients.
+func (h *VideoHandler) validateStaged(entry *staging.Entry) error {
+	reason := ""
+	if !allowedVideoExts[strings.ToLower(filepath.Ext(entry.FileName))] {
+		reason = "unsupported file type"
+	} else if entry.Size == 0 {
+		reason = "empty file"
+	} else if duration, err := h.hlsConverter.GetVideoDuration(entry.SourcePath); err != nil || duration <= 0 {
+		reason = "no playable video stream"
+	}
+
+	if reason != "" {
+		if _, err := h.staging.Quarantine(entry.ID, reason); err != nil {
+			log.Printf("Failed to quarantine %s: %v", entry.ID, err)
+		}
+		return fmt.Errorf("Invalid video file: %s", reason)
+	}
+
+	h.stage(entry.ID, staging.StateValidated, nil)
+	return nil
+}
+
+// stage moves a staging entry to a new state. Sources not taken from the
+// staging area have no entry.
+func (h *VideoHandler) stage(id string, state staging.State, err error) {
+	if id == "" {
 		return
 	}
-	defer os.Remove(tempFilePath)
+	if _, terr := h.staging.Transition(id, state, err); terr != nil {
+		log.Printf("[Staging] %v", terr)
+	}
+}
 
-	// Convert to HLS format first
-	playlistPath, segmentPath, err := h.hlsConverter.ConvertToHLSSimple(tempFilePath, videoID)
+// publishHLS converts a local source file to HLS and uploads the playlist and
+// segments to GCS under the video's folder. The returned error message is safe
+// to show to clients.
+func (h *VideoHandler) publishHLS(sourcePath, videoID string, size int64, contentType string, opts vod.Options) (*storage.VideoMetadata, error) {
+	playlistPath, videoDuration, err := h.convertHLS(sourcePath, videoID, opts, nil)
 	if err != nil {
-		log.Printf("HLS conversion error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to convert video to HLS format",
+		return nil, err
+	}
+	defer h.cleanupHLS(playlistPath)
+
+	return h.hlsMetadata(videoID, size, contentType, videoDuration), nil
+}
+
+// recordDeinterlacing returns a callback recording how a job's conversion
+// treated interlacing on the job
+func (h *VideoHandler) recordDeinterlacing(jobID string) func(vod.Deinterlacing) {
+	return func(d vod.Deinterlacing) {
+		h.jobManager.Update(jobID, func(j *jobs.Job) {
+			j.FieldOrder = d.FieldOrder
+			j.Deinterlacer = d.Filter
+		})
+	}
+}
+
+// recordSource returns a callback recording the video coding of a job's
+// source on the job
+func (h *VideoHandler) recordSource(jobID string) func(vod.SourceFormat) {
+	return func(f vod.SourceFormat) {
+		h.jobManager.Update(jobID, func(j *jobs.Job) {
+			j.SourceCodec = f.String()
+			j.PixelFormat = f.PixelFormat
 		})
-		return
 	}
-	defer h.hlsConverter.Cleanup(playlistPath, segmentPath)
+}
+
+// SetDeinterlacer sets the filter interlaced sources are deinterlaced with:
+// bwdif, yadif or off
+func (h *VideoHandler) SetDeinterlacer(deinterlacer string) {
+	h.deinterlacer = deinterlacer
+}
+
+// SetAudioMix sets how surround sources are mixed down to stereo, itu or
+// dialog, and whether ladders keep a surround rendition next to the stereo one
+func (h *VideoHandler) SetAudioMix(downmix string, surround bool) {
+	h.downmix = downmix
+	h.surround = surround
+}
+
+// SetSegmentNaming sets how the segments of converted videos are named
+func (h *VideoHandler) SetSegmentNaming(segments config.SegmentNaming) {
+	h.segments = segments
+}
+
+// SetSigner makes published playlists carry a detached signature
+func (h *VideoHandler) SetSigner(signer *integrity.Signer) {
+	h.signer = signer
+}
+
+// SetEmbedSigner lets the HLS proxy accept playback tokens in place of the
+// session cookie or an API key
+func (h *VideoHandler) SetEmbedSigner(signer *auth.EmbedSigner) {
+	h.embedSigner = signer
+}
+
+// SetSegmentCache makes the HLS proxy serve segments from memory and
+// prefetch the ones players will ask for next
+func (h *VideoHandler) SetSegmentCache(cache *prefetch.Cache) {
+	h.segmentCache = cache
+}
+
+// SetBandwidth makes HLS proxy downloads count towards the bandwidth estimate
+// of the playback session given as ?session_id=
+func (h *VideoHandler) SetBandwidth(bandwidth *qoe.Bandwidth) {
+	h.bandwidth = bandwidth
+}
+
+// SetHistory records HLS proxy playback of signed in viewers, and of
+// sessions linked to them, in their watch history
+func (h *VideoHandler) SetHistory(history *viewers.History) {
+	h.history = history
+}
 
+// convertHLS converts a local source file to HLS, publishing each segment and
+// a growing playlist to the video's folder while FFmpeg is still running.
+// onPlayable is called once the first playlist is published.
+func (h *VideoHandler) convertHLS(sourcePath, videoID string, opts vod.Options, onPlayable func(duration float64)) (string, float64, error) {
 	// Get video duration using ffprobe
-	videoDuration, err := h.hlsConverter.GetVideoDuration(tempFilePath)
+	videoDuration, err := h.hlsConverter.GetVideoDuration(sourcePath)
 	if err != nil {
 		log.Printf("Failed to get video duration: %v", err)
 		videoDuration = 0 // Continue without duration
@@ -136,128 +387,250 @@ func (h *VideoHandler) UploadVideo(c *gin.Context) {
 		log.Printf("Video duration: %.2f seconds", videoDuration)
 	}
 
-	// Upload HLS files to GCS in UUID folder
-	// First upload the playlist
-	playlistGCSPath := filepath.Join(h.videoFolder, videoID, "playlist.m3u8")
-	if err := h.gcsService.UploadFile(playlistPath, playlistGCSPath, "application/vnd.apple.mpegurl"); err != nil {
-		log.Printf("Failed to upload playlist: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to upload HLS playlist",
-		})
+	ctx := context.Background()
+	publisher := &hlsPublisher{ctx: ctx, h: h, videoID: videoID}
+	if onPlayable != nil {
+		publisher.onPlayable = func() { onPlayable(videoDuration) }
+	}
+	if opts.Deinterlace == "" {
+		opts.Deinterlace = h.deinterlacer
+	}
+	if opts.Downmix == "" {
+		opts.Downmix = h.downmix
+	}
+	opts.Surround = opts.Surround || h.surround
+	if opts.Segments == (config.SegmentNaming{}) {
+		opts.Segments = h.segments
+	}
+	pipeline := vod.NewPipeline(publisher, opts)
+	playlistPath, err := pipeline.Run(ctx, sourcePath, filepath.Join(h.workDir.VODHLS(), videoID))
+	if err != nil {
+		log.Printf("HLS conversion error: %v", err)
+		var unsupported *vod.UnsupportedError
+		if errors.As(err, &unsupported) {
+			return "", 0, unsupported
+		}
+		return "", 0, errors.New("Failed to convert video to HLS format")
+	}
+
+	log.Printf("Published HLS to folder: %s (%d segments)", filepath.Join(h.videoFolder, videoID), pipeline.Published())
+	return playlistPath, videoDuration, nil
+}
+
+// hlsPublisher uploads pipelined HLS output to a video's folder
+type hlsPublisher struct {
+	ctx        context.Context
+	h          *VideoHandler
+	videoID    string
+	onPlayable func()
+	playable   bool
+}
+
+// PublishSegment uploads a finished segment
+func (p *hlsPublisher) PublishSegment(localPath, name string) error {
+	return p.h.gcsService.UploadFile(p.ctx, localPath, filepath.Join(p.h.videoFolder, p.videoID, name), segmentContentType(name))
+}
+
+// segmentContentType returns the content type of an HLS segment or
+// initialization section
+func segmentContentType(name string) string {
+	switch filepath.Ext(name) {
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mp4":
+		return "video/mp4"
+	}
+	return "video/mp2t"
+}
+
+// PublishPlaylist uploads a growing playlist uncached and a final one like
+// any other HLS file
+func (p *hlsPublisher) PublishPlaylist(localPath, name string, data []byte, final bool) error {
+	gcsPath := filepath.Join(p.h.videoFolder, p.videoID, name)
+	var err error
+	if final {
+		err = p.h.gcsService.UploadFile(p.ctx, localPath, gcsPath, "application/vnd.apple.mpegurl")
+	} else {
+		err = p.h.gcsService.UploadBytes(p.ctx, data, gcsPath, "application/vnd.apple.mpegurl")
+	}
+	if err == nil {
+		if final {
+			data = nil
+		}
+		err = publishSignature(p.ctx, p.h.gcsService, p.h.signer, localPath, gcsPath, data)
+	}
+	if err == nil && !p.playable && name == vod.PlaylistName {
+		p.playable = true
+		if p.onPlayable != nil {
+			p.onPlayable()
+		}
+	}
+	return err
+}
+
+// cleanupHLS removes the local conversion output of a video
+func (h *VideoHandler) cleanupHLS(playlistPath string) {
+	if playlistPath == "" {
 		return
 	}
+	dir := filepath.Dir(playlistPath)
+	if rel, err := filepath.Rel(h.workDir.VODHLS(), dir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		os.RemoveAll(dir)
+	}
+}
 
-	// Find and upload all segment files (playlist0.ts, playlist1.ts, etc.)
+// uploadHLS uploads the segments, the media playlists of a ladder and then
+// the playlist to GCS in the video's folder, so the playlist only appears
+// once everything it references exists. Files are taken from the playlists.
+// Files named in skip are already uploaded; onUploaded is called after each
+// segment so callers can checkpoint progress.
+func (h *VideoHandler) uploadHLS(ctx context.Context, playlistPath, videoID string, skip map[string]bool, onUploaded func(name string)) error {
 	hlsDir := filepath.Dir(playlistPath)
-	segmentFiles, err := filepath.Glob(filepath.Join(hlsDir, "playlist*.ts"))
+	segments, playlists, err := vod.Files(hlsDir)
 	if err != nil {
 		log.Printf("Failed to find segment files: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to find HLS segments",
-		})
-		return
+		return errors.New("Failed to find HLS segments")
 	}
 
-	for _, segFile := range segmentFiles {
-		segmentName := filepath.Base(segFile)
+	for _, segmentName := range segments {
+		if skip[segmentName] {
+			continue
+		}
 		segmentGCSPath := filepath.Join(h.videoFolder, videoID, segmentName)
-		if err := h.gcsService.UploadFile(segFile, segmentGCSPath, "video/mp2t"); err != nil {
+		if err := h.gcsService.UploadFile(ctx, filepath.Join(hlsDir, segmentName), segmentGCSPath, segmentContentType(segmentName)); err != nil {
 			log.Printf("Failed to upload segment %s: %v", segmentName, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"success": false,
-				"error":   fmt.Sprintf("Failed to upload HLS segment: %s", segmentName),
-			})
-			return
+			return fmt.Errorf("Failed to upload HLS segment: %s", segmentName)
+		}
+		if onUploaded != nil {
+			onUploaded(segmentName)
 		}
 	}
 
-	log.Printf("Uploaded HLS files to folder: %s (%d segments)", filepath.Join(h.videoFolder, videoID), len(segmentFiles))
+	// Then the playlists, the master playlist last
+	for _, name := range append(playlists, vod.PlaylistName) {
+		localPath := filepath.Join(hlsDir, name)
+		gcsPath := filepath.Join(h.videoFolder, videoID, name)
+		if err := h.gcsService.UploadFile(ctx, localPath, gcsPath, "application/vnd.apple.mpegurl"); err != nil {
+			log.Printf("Failed to upload playlist %s: %v", name, err)
+			return errors.New("Failed to upload HLS playlist")
+		}
+		if err := publishSignature(ctx, h.gcsService, h.signer, localPath, gcsPath, nil); err != nil {
+			log.Printf("Failed to publish playlist signature: %v", err)
+			return errors.New("Failed to sign HLS playlist")
+		}
+	}
 
-	// Create metadata
+	log.Printf("Uploaded HLS files to folder: %s (%d segments)", filepath.Join(h.videoFolder, videoID), len(segments))
+	return nil
+}
+
+// hlsMetadata describes a published HLS video
+func (h *VideoHandler) hlsMetadata(videoID string, size int64, contentType string, videoDuration float64) *storage.VideoMetadata {
 	// Create proxy URL for HLS playlist
 	// Format: /api/v1/hls/{videoID}/playlist.m3u8
+	playlistGCSPath := filepath.Join(h.videoFolder, videoID, "playlist.m3u8")
 	hlsProxyURL := fmt.Sprintf("/api/v1/hls/%s/playlist.m3u8", videoID)
 
-	metadata := &storage.VideoMetadata{
+	return &storage.VideoMetadata{
 		VideoID:        videoID,
 		FileName:       "playlist.m3u8",
 		GCSPath:        playlistGCSPath,
 		GCSFolder:      filepath.Join(h.videoFolder, videoID),
 		PublicURL:      h.gcsService.GetPublicURL(playlistGCSPath),
 		HLSPlaylistURL: hlsProxyURL,
-		Size:           file.Size,
-		ContentType:    file.Header.Get("Content-Type"),
+		Size:           size,
+		ContentType:    contentType,
 		UploadedAt:     time.Now(),
 		Duration:       videoDuration,
 	}
+}
 
-	response := &UploadVideoResponse{
-		Success: true,
-		Message: "Video uploaded successfully",
-		Video:   metadata,
-	}
+// createBroadcastForVideo creates a broadcast stream for an uploaded video
+func (h *VideoHandler) createBroadcastForVideo(metadata *storage.VideoMetadata, owner *auth.User) *broadcast.Stream {
+	// Always use HLS playlist for streaming
+	stream := h.broadcastManager.CreateStreamWithHLS(metadata.HLSPlaylistURL, metadata.HLSPlaylistURL, metadata.GCSPath)
+	h.authService.SetOwner(auth.ResourceStream, stream.ID, owner)
+	// Set video duration on stream for synchronized playback
+	stream.SetVideoDuration(metadata.Duration)
+	log.Printf("Stream created with HLS playlist: %s (duration: %.2fs)", metadata.HLSPlaylistURL, metadata.Duration)
+	return stream
+}
 
-	// Auto-create broadcast stream if requested
-	if req.AutoBroadcast {
-		// Always use HLS playlist for streaming
-		stream := h.broadcastManager.CreateStreamWithHLS(metadata.HLSPlaylistURL, metadata.HLSPlaylistURL, metadata.GCSPath)
-		// Set video duration on stream for synchronized playback
-		stream.SetVideoDuration(videoDuration)
-		log.Printf("Stream created with HLS playlist: %s (duration: %.2fs)", metadata.HLSPlaylistURL, videoDuration)
-		response.StreamID = stream.ID
-		response.StreamURL = fmt.Sprintf("/api/v1/streams/%s", stream.ID)
+// ListVideos returns the caller's videos, or all videos for admins with scope=all
+func (h *VideoHandler) ListVideos(c *gin.Context) {
+	videos, ok := h.listVideos(c)
+	if !ok {
+		return
 	}
 
+	response := api.VideoListResponse{Success: true, Videos: make([]api.VideoResource, 0, len(videos))}
+	for _, video := range videos {
+		response.Videos = append(response.Videos, api.NewVideoResource(video, h.videoIDFromPath(video.GCSPath)))
+	}
+	response.Count = len(response.Videos)
 	c.JSON(http.StatusOK, response)
 }
 
-// ListVideos returns all uploaded videos
-func (h *VideoHandler) ListVideos(c *gin.Context) {
-	videos, err := h.gcsService.ListVideos(h.videoFolder)
+// listVideos returns the videos the request lists: the caller's, or all of
+// them for admins with scope=all
+func (h *VideoHandler) listVideos(c *gin.Context) ([]*storage.VideoMetadata, bool) {
+	all := listScopeAll(c, h.authService)
+	user := currentUser(c)
+	if h.catalog != nil {
+		return h.catalog.List(func(video *storage.VideoMetadata) bool {
+			return all || h.authService.IsMine(user, auth.ResourceVideo, video.VideoID)
+		}), true
+	}
+
+	videos, err := h.gcsService.ListVideos(c.Request.Context(), h.videoFolder)
 	if err != nil {
 		log.Printf("List videos error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to list videos",
-		})
-		return
+		api.Fail(c, http.StatusInternalServerError, "Failed to list videos")
+		return nil, false
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"count":   len(videos),
-		"videos":  videos,
-	})
+	if !all {
+		mine := make([]*storage.VideoMetadata, 0, len(videos))
+		for _, video := range videos {
+			if h.authService.IsMine(user, auth.ResourceVideo, h.videoIDFromPath(video.GCSPath)) {
+				mine = append(mine, video)
+			}
+		}
+		videos = mine
+	}
+	return videos, true
 }
 
-// GetSignedURL generates a signed URL for a video
+// GetSignedURL generates a signed URL for a video. Like GetSignedURLs, it
+// takes read permission on the video or stream of the object.
 func (h *VideoHandler) GetSignedURL(c *gin.Context) {
+	if !requireAccount(c, h.authService) {
+		return
+	}
 	gcsPath := c.Query("path")
 	if gcsPath == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "GCS path is required",
-		})
+		api.Fail(c, http.StatusBadRequest, "GCS path is required")
 		return
 	}
-
-	// Default expiration: 1 hour
-	expiration := 1 * time.Hour
-	if exp := c.Query("expiration"); exp != "" {
-		if duration, err := time.ParseDuration(exp); err == nil {
-			expiration = duration
-		}
+	if !validObjectPath(gcsPath) {
+		api.Fail(c, http.StatusBadRequest, "Invalid path: "+gcsPath)
+		return
+	}
+	expiration, ok := signedURLExpiration(c.Query("expiration"))
+	if !ok {
+		api.Fail(c, http.StatusBadRequest, "expiration must be a duration up to 168h")
+		return
+	}
+	kind, id, inFolder := h.gcsService.Layout().AssetOf(gcsPath)
+	if !h.canSign(c, kind, id, inFolder) {
+		api.Fail(c, http.StatusForbidden, "You do not have access to "+gcsPath)
+		return
 	}
 
 	signedURL, err := h.gcsService.GetSignedURL(gcsPath, expiration)
 	if err != nil {
 		log.Printf("Signed URL error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to generate signed URL",
-		})
+		api.Fail(c, http.StatusInternalServerError, "Failed to generate signed URL")
 		return
 	}
 
@@ -272,31 +645,43 @@ func (h *VideoHandler) GetSignedURL(c *gin.Context) {
 func (h *VideoHandler) DeleteVideo(c *gin.Context) {
 	gcsPath := c.Query("path")
 	if gcsPath == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "GCS path is required",
-		})
+		api.Fail(c, http.StatusBadRequest, "GCS path is required")
+		return
+	}
+
+	if !requirePermission(c, h.authService, auth.ResourceVideo, h.videoIDFromPath(gcsPath), auth.PermissionManage) {
 		return
 	}
 
-	if err := h.gcsService.DeleteVideo(gcsPath); err != nil {
+	if err := h.gcsService.DeleteVideo(c.Request.Context(), gcsPath); err != nil {
 		log.Printf("Delete video error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to delete video",
-		})
+		api.Fail(c, http.StatusInternalServerError, "Failed to delete video")
 		return
 	}
+	// Without its master playlist the video is gone
+	if videoID := h.videoIDFromPath(gcsPath); gcsPath == filepath.Join(h.videoFolder, videoID, vod.PlaylistName) {
+		h.uncatalogVideo(c.Request.Context(), videoID)
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Video deleted successfully",
-	})
+	c.JSON(http.StatusOK, api.Message("Video deleted successfully"))
+}
+
+// videoID returns the ID a video's objects are stored under
+func (h *VideoHandler) videoID(video *storage.VideoMetadata) string {
+	return h.videoIDFromPath(video.GCSPath)
+}
+
+// videoIDFromPath extracts the video ID from an object path under the video folder
+func (h *VideoHandler) videoIDFromPath(gcsPath string) string {
+	rel := strings.TrimPrefix(gcsPath, strings.TrimSuffix(h.videoFolder, "/")+"/")
+	return strings.SplitN(rel, "/", 2)[0]
 }
 
 // ProxyHLSFile serves HLS files from GCS through the API server
 // This allows private bucket access without making objects public
 // Format: /api/v1/hls/{videoID}/{filename}
+// Callers need read access to the video or a playback token for it, which
+// playlists served with one pass on to the files they list
 func (h *VideoHandler) ProxyHLSFile(c *gin.Context) {
 	videoID := c.Param("videoID")
 	filename := c.Param("filename")
@@ -307,12 +692,36 @@ func (h *VideoHandler) ProxyHLSFile(c *gin.Context) {
 		})
 		return
 	}
+	if !requireVideoPlayback(c, h.authService, h.embedSigner, videoID) {
+		return
+	}
+
+	// Loading the master playlist is playing the video
+	if filename == vod.PlaylistName {
+		defer func() {
+			if c.Writer.Status() >= http.StatusBadRequest {
+				return
+			}
+			sessionID := c.Query("session_id")
+			h.history.Record(viewerOf(c, h.history, sessionID), viewers.Play{
+				Kind:      viewers.KindVideo,
+				ID:        videoID,
+				SessionID: sessionID,
+				Position:  -1,
+			})
+		}()
+	}
 
 	// Construct GCS path: videos/{videoID}/{filename}
 	gcsPath := filepath.Join(h.videoFolder, videoID, filename)
 
+	if h.segmentCache != nil {
+		h.serveCachedHLSFile(c, gcsPath, filename)
+		return
+	}
+
 	// Read file from GCS
-	reader, err := h.gcsService.GetFileReader(gcsPath)
+	reader, err := h.gcsService.GetFileReader(c.Request.Context(), gcsPath)
 	if err != nil {
 		log.Printf("Failed to read file from GCS %s: %v", gcsPath, err)
 		c.JSON(http.StatusNotFound, gin.H{
@@ -322,20 +731,86 @@ func (h *VideoHandler) ProxyHLSFile(c *gin.Context) {
 	}
 	defer reader.Close()
 
+	contentType := setHLSProxyHeaders(c, filename)
+	if filepath.Ext(filename) == ".m3u8" && c.Query("playback_token") != "" {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			log.Printf("Failed to read file from GCS %s: %v", gcsPath, err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Failed to read file",
+			})
+			return
+		}
+		c.Data(http.StatusOK, contentType, tokenizePlaylist(c, data, "playback_token"))
+		return
+	}
+
+	// Stream the file
+	start := time.Now()
+	c.DataFromReader(http.StatusOK, -1, contentType, reader, nil)
+	h.bandwidth.Observe(c.Query("session_id"), int64(c.Writer.Size()), time.Since(start))
+}
+
+// serveCachedHLSFile serves an HLS file through the segment cache: playlists
+// are read from GCS and tell the cache what comes next, segments come from
+// memory and prefetch the ones after them
+func (h *VideoHandler) serveCachedHLSFile(c *gin.Context, gcsPath, filename string) {
+	var data []byte
+	var err error
+	if filepath.Ext(filename) == ".m3u8" {
+		data, err = h.gcsService.ReadFile(c.Request.Context(), gcsPath)
+		if err == nil {
+			h.segmentCache.ObservePlaylist(gcsPath, data)
+			data = tokenizePlaylist(c, data, "playback_token")
+		}
+	} else {
+		var hit bool
+		data, hit, err = h.segmentCache.Get(gcsPath)
+		if hit {
+			c.Header("X-Cache", "HIT")
+		} else {
+			c.Header("X-Cache", "MISS")
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to read file from GCS %s: %v", gcsPath, err)
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "File not found",
+		})
+		return
+	}
+
+	contentType := setHLSProxyHeaders(c, filename)
+	start := time.Now()
+	c.Data(http.StatusOK, contentType, data)
+	h.bandwidth.Observe(c.Query("session_id"), int64(len(data)), time.Since(start))
+}
+
+// setHLSProxyHeaders sets the CORS and caching headers of a proxied HLS file
+// and returns its content type
+func setHLSProxyHeaders(c *gin.Context, filename string) string {
 	// Set appropriate content type based on file extension
 	contentType := "application/octet-stream"
-	if filepath.Ext(filename) == ".m3u8" {
+	switch filepath.Ext(filename) {
+	case ".m3u8":
 		contentType = "application/vnd.apple.mpegurl"
-	} else if filepath.Ext(filename) == ".ts" {
+	case ".ts":
 		contentType = "video/MP2T"
+	case ".m4s":
+		contentType = "video/iso.segment"
+	case ".mp4":
+		contentType = "video/mp4"
 	}
 
 	// Set CORS headers
 	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("Access-Control-Expose-Headers", "Content-Length, Content-Type")
+	c.Header("Access-Control-Expose-Headers", "Content-Length, Content-Type, X-Cache")
 	c.Header("Content-Type", contentType)
-	c.Header("Cache-Control", "public, max-age=3600")
-
-	// Stream the file
-	c.DataFromReader(http.StatusOK, -1, contentType, reader, nil)
+	if filepath.Ext(filename) == ".m3u8" {
+		// Playlists of videos still being converted grow
+		c.Header("Cache-Control", "public, max-age=2")
+	} else {
+		c.Header("Cache-Control", "public, max-age=3600")
+	}
+	return contentType
 }
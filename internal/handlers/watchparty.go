@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/storage"
+	"live-video/pkg/vod"
+	"live-video/pkg/watchparty"
+
+	"github.com/gin-gonic/gin"
+)
+
+// partyResyncInterval is how often members get the room state to correct
+// drift between players
+const partyResyncInterval = 15 * time.Second
+
+// WatchPartyHandler handles watch-party rooms, where viewers of a video or
+// stream follow the play, pause and seek commands of a host
+type WatchPartyHandler struct {
+	parties          *watchparty.Manager
+	broadcastManager *broadcast.BroadcastManager
+	gcsService       *storage.GCSService
+	authService      *auth.Service
+	embedSigner      *auth.EmbedSigner
+}
+
+// NewWatchPartyHandler creates a new watch-party handler
+func NewWatchPartyHandler(parties *watchparty.Manager, broadcastManager *broadcast.BroadcastManager, gcsService *storage.GCSService, authService *auth.Service, embedSigner *auth.EmbedSigner) *WatchPartyHandler {
+	return &WatchPartyHandler{
+		parties:          parties,
+		broadcastManager: broadcastManager,
+		gcsService:       gcsService,
+		authService:      authService,
+		embedSigner:      embedSigner,
+	}
+}
+
+// CreatePartyRequest opens a room for a video or a stream
+type CreatePartyRequest struct {
+	VideoID  string `json:"video_id"`
+	StreamID string `json:"stream_id"`
+	Name     string `json:"name"` // display name of the host
+}
+
+// JoinPartyRequest joins a room
+type JoinPartyRequest struct {
+	Name string `json:"name"`
+}
+
+// PartyControlRequest is a host command
+type PartyControlRequest struct {
+	Token    string  `json:"token" binding:"required"`
+	Action   string  `json:"action" binding:"required"` // play, pause, seek or transfer
+	Position float64 `json:"position"`                  // seek target in seconds
+	MemberID string  `json:"member_id"`                 // new host for transfer
+}
+
+// PartyLeaveRequest leaves a room
+type PartyLeaveRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// CreateParty opens a room and makes the caller its host. The caller must be
+// able to watch the video or stream. Stream rooms start at the stream's
+// synchronized position.
+func (h *WatchPartyHandler) CreateParty(c *gin.Context) {
+	var req CreatePartyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if (req.VideoID == "") == (req.StreamID == "") {
+		api.Fail(c, http.StatusBadRequest, "Exactly one of video_id and stream_id is required")
+		return
+	}
+
+	kind, targetID, position, playing := watchparty.KindVideo, req.VideoID, 0.0, false
+	if req.StreamID != "" {
+		stream, ok := h.playableStream(c, req.StreamID)
+		if !ok {
+			return
+		}
+		kind, targetID = watchparty.KindStream, stream.ID
+		position, playing = stream.GetCurrentPosition(), stream.CurrentStatus() == broadcast.StatusStreaming
+	} else if !requirePermission(c, h.authService, auth.ResourceVideo, req.VideoID, auth.PermissionRead) {
+		return
+	} else if _, err := h.gcsService.GetObjectAttrs(c.Request.Context(), h.gcsService.Layout().VODPath(req.VideoID, vod.PlaylistName)); err != nil {
+		api.Fail(c, http.StatusNotFound, "Video not found")
+		return
+	}
+
+	room, host := h.parties.Create(kind, targetID, req.Name, position, playing)
+	log.Printf("Watch party %s opened for %s %s", room.ID, kind, targetID)
+	c.JSON(http.StatusCreated, h.memberResponse(room, host))
+}
+
+// JoinParty adds the caller to a room if they can watch its video or stream
+func (h *WatchPartyHandler) JoinParty(c *gin.Context) {
+	var req JoinPartyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			api.Fail(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	room, err := h.parties.Get(c.Param("id"))
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	switch room.Kind {
+	case watchparty.KindStream:
+		if _, ok := h.playableStream(c, room.TargetID); !ok {
+			return
+		}
+	case watchparty.KindVideo:
+		if !requirePermission(c, h.authService, auth.ResourceVideo, room.TargetID, auth.PermissionRead) {
+			return
+		}
+	}
+
+	room, member, err := h.parties.Join(room.ID, req.Name)
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, h.memberResponse(room, member))
+}
+
+// GetParty returns the room state and membership
+func (h *WatchPartyHandler) GetParty(c *gin.Context) {
+	room, err := h.parties.Get(c.Param("id"))
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	state, hostID, members := room.Snapshot()
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"id":         room.ID,
+		"kind":       room.Kind,
+		"target_id":  room.TargetID,
+		"created_at": room.CreatedAt,
+		"host_id":    hostID,
+		"state":      state,
+		"members":    members,
+	})
+}
+
+// PartyEvents streams room events to a member as SSE: "state" on connect,
+// after every host command and every partyResyncInterval, and "members"
+// whenever the membership or host changes
+func (h *WatchPartyHandler) PartyEvents(c *gin.Context) {
+	room, member, events, err := h.parties.Connect(c.Param("id"), c.Query("token"))
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	defer h.parties.Disconnect(room, member, events)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Writer.Flush()
+
+	clientClosed := c.Request.Context().Done()
+	ticker := time.NewTicker(partyResyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data, ok := <-events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+			c.Writer.Flush()
+
+		case <-ticker.C:
+			fmt.Fprintf(c.Writer, "data: %s\n\n", room.StateEvent())
+			c.Writer.Flush()
+
+		case <-clientClosed:
+			return
+		}
+	}
+}
+
+// ControlParty applies a host command: play, pause, seek to position or
+// transfer the host role to member_id
+func (h *WatchPartyHandler) ControlParty(c *gin.Context) {
+	var req PartyControlRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	state, err := h.parties.Control(c.Param("id"), req.Token, req.Action, req.Position, req.MemberID)
+	if err != nil {
+		partyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"state":   state,
+	})
+}
+
+// LeaveParty removes a member from a room
+func (h *WatchPartyHandler) LeaveParty(c *gin.Context) {
+	var req PartyLeaveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.parties.Leave(c.Param("id"), req.Token); err != nil {
+		partyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Left watch party",
+	})
+}
+
+// playableStream looks up a stream the caller may play
+func (h *WatchPartyHandler) playableStream(c *gin.Context, streamID string) (*broadcast.Stream, bool) {
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return nil, false
+	}
+	if !requirePlayback(c, h.authService, h.embedSigner, stream) {
+		return nil, false
+	}
+	return stream, true
+}
+
+// memberResponse describes a room to one of its members, with the token
+// that member uses for its events connection and commands
+func (h *WatchPartyHandler) memberResponse(room *watchparty.Room, member *watchparty.Member) gin.H {
+	state, hostID, _ := room.Snapshot()
+	return gin.H{
+		"success":    true,
+		"party_id":   room.ID,
+		"kind":       room.Kind,
+		"target_id":  room.TargetID,
+		"member_id":  member.ID,
+		"token":      member.Token,
+		"host":       member.ID == hostID,
+		"state":      state,
+		"events_url": fmt.Sprintf("/api/v1/parties/%s/events?token=%s", room.ID, member.Token),
+	}
+}
+
+// partyError maps watch-party errors to responses
+func partyError(c *gin.Context, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, watchparty.ErrRoomNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, watchparty.ErrMemberNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, watchparty.ErrNotHost):
+		status = http.StatusForbidden
+	}
+	api.Fail(c, status, err.Error())
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/vod"
+	"live-video/pkg/watermark"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetWatermarks journals the playback sessions of watermarked streams to
+// ledger. Without a ledger streams play unwatermarked.
+func (h *BroadcastHandler) SetWatermarks(ledger *watermark.Ledger) {
+	h.watermarks = ledger
+}
+
+// SetStreamWatermark turns forensic watermarking of a stream on or off, e.g.
+// {"enabled": true}. It applies from the next pipeline start on.
+func (h *BroadcastHandler) SetStreamWatermark(c *gin.Context) {
+	streamID := c.Param("id")
+	if !requirePermission(c, h.authService, auth.ResourceStream, streamID, auth.PermissionManage) {
+		return
+	}
+	if h.watermarks == nil {
+		api.Fail(c, http.StatusServiceUnavailable, "Watermarking is not available")
+		return
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	stream, err := h.broadcastManager.GetStream(streamID)
+	if err != nil {
+		api.Fail(c, http.StatusNotFound, "Stream not found")
+		return
+	}
+	stream.SetWatermark(req.Enabled)
+	log.Printf("[Watermark] Watermarking of stream %s set to %v", streamID, req.Enabled)
+
+	running := false
+	if orch := stream.GetOrchestrator(); orch != nil {
+		running = orch.Watermarked()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"enabled": req.Enabled,
+		// whether the running pipeline encodes marked variants yet
+		"running": running,
+	})
+}
+
+// TraceWatermark finds the playback sessions of a stream that played the
+// segment variants read from a leaked recording, e.g.
+// {"first_sequence": 1200, "variants": "0110?1..."}
+func (h *BroadcastHandler) TraceWatermark(c *gin.Context) {
+	if !requireAdmin(c, h.authService) {
+		return
+	}
+	if h.watermarks == nil {
+		api.Fail(c, http.StatusServiceUnavailable, "Watermarking is not available")
+		return
+	}
+
+	var req struct {
+		FirstSequence int    `json:"first_sequence"`
+		Variants      string `json:"variants" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		api.Fail(c, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	streamID := c.Param("id")
+	matches, err := h.watermarks.Trace(streamID, req.FirstSequence, req.Variants)
+	if err != nil {
+		api.Fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("[Watermark] Traced %d variants of stream %s to %d sessions", len(req.Variants), streamID, len(matches))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"matches": matches,
+	})
+}
+
+// beginWatermark starts the watermarked playback session of a master
+// playlist request
+func (h *BroadcastHandler) beginWatermark(c *gin.Context, stream *broadcast.Stream, sessionID string) (*watermark.Session, error) {
+	session := watermark.Session{
+		StreamID:        stream.ID,
+		PlayerSessionID: sessionID,
+		ClientIP:        c.ClientIP(),
+		UserAgent:       c.Request.UserAgent(),
+	}
+	if user := currentUser(c); user != nil {
+		session.UserID = user.ID
+	}
+	return h.watermarks.Begin(session)
+}
+
+// watermarkSession returns the watermarked session a media playlist request
+// of stream belongs to, nil when the stream isn't watermarked. It answers
+// with 403 when a watermarked stream is played without a session, which
+// would leave the recording untraceable.
+func (h *BroadcastHandler) watermarkSession(c *gin.Context, stream *broadcast.Stream) (*watermark.Session, bool) {
+	if !stream.Watermark() || h.watermarks == nil {
+		return nil, true
+	}
+	session, ok := h.watermarks.Get(stream.ID, c.Query("wm"))
+	if !ok {
+		api.Fail(c, http.StatusForbidden, "Playback session required, start playback from the master playlist")
+		return nil, false
+	}
+	return session, true
+}
+
+// mixWatermark swaps the segments of media playlist data of a source's
+// rendition the session plays marked for those of the marked variant. Without
+// a marked variant yet data is returned as is; audio is never marked.
+func (h *BroadcastHandler) mixWatermark(c *gin.Context, session *watermark.Session, source *broadcast.Stream, rendition string, data []byte) []byte {
+	if config.IsAudioRendition(rendition) {
+		return data
+	}
+	variant := config.WatermarkVariant(rendition)
+	marked, err := os.ReadFile(filepath.Join(source.WorkDir().StreamHLS(source.ID), variant, vod.PlaylistName))
+	if err != nil {
+		marked, err = h.gcsService.ReadFile(c.Request.Context(), h.gcsService.Layout().LivePath(source.ID, variant, vod.PlaylistName))
+	}
+	if err != nil {
+		return data
+	}
+	return session.Mix(data, marked, h.cdnPrefix(source.ID)+variant+"/")
+}
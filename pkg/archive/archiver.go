@@ -0,0 +1,250 @@
+package archive
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"live-video/pkg/storage"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// ManifestFileName is the object name of the archive manifest inside an asset folder
+const ManifestFileName = "archive-manifest.json"
+
+// Object kinds recorded in the manifest
+const (
+	KindPlaylist  = "playlist"
+	KindSegment   = "segment"
+	KindRecording = "recording"
+	KindCaption   = "caption"
+	KindThumbnail = "thumbnail"
+	KindOther     = "other"
+)
+
+// ManifestObject describes a single archived object
+type ManifestObject struct {
+	Path        string `json:"path"`
+	Kind        string `json:"kind"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	MD5         string `json:"md5,omitempty"`
+	CRC32C      uint32 `json:"crc32c"`
+}
+
+// StreamRecord keeps enough of a stream's registration to re-register it on restore
+type StreamRecord struct {
+	VideoURL       string  `json:"video_url"`
+	HLSPlaylistURL string  `json:"hls_playlist_url,omitempty"`
+	GCSPath        string  `json:"gcs_path,omitempty"`
+	VideoDuration  float64 `json:"video_duration,omitempty"`
+}
+
+// Manifest lists everything that belongs to an archived asset
+type Manifest struct {
+	AssetID      string           `json:"asset_id"`
+	Folder       string           `json:"folder"`            // where the manifest is kept: the asset's live or vod folder
+	Folders      []string         `json:"folders,omitempty"` // every folder the objects were collected from`
+	StorageClass string           `json:"storage_class"`
+	ArchivedAt   time.Time        `json:"archived_at"`
+	RestoredAt   *time.Time       `json:"restored_at,omitempty"`
+	TotalBytes   int64            `json:"total_bytes"`
+	Objects      []ManifestObject `json:"objects"`
+	Stream       *StreamRecord    `json:"stream,omitempty"`
+}
+
+// RestoreReport summarizes a restore operation
+type RestoreReport struct {
+	AssetID          string    `json:"asset_id"`
+	RestoredObjects  int       `json:"restored_objects"`
+	RestoredBytes    int64     `json:"restored_bytes"`
+	MissingObjects   []string  `json:"missing_objects,omitempty"`
+	ChecksumMismatch []string  `json:"checksum_mismatch,omitempty"`
+	RestoredAt       time.Time `json:"restored_at"`
+}
+
+// Bucket is where an archiver finds and moves objects. *storage.GCSService
+// implements it.
+type Bucket interface {
+	ListObjects(ctx context.Context, prefix string) ([]*gcs.ObjectAttrs, error)
+	SetStorageClass(ctx context.Context, gcsPath, storageClass string) (*gcs.ObjectAttrs, error)
+	ReadFile(ctx context.Context, gcsPath string) ([]byte, error)
+	UploadBytes(ctx context.Context, data []byte, gcsPath, contentType string) error
+}
+
+// Archiver moves assets between hot and cold storage classes
+type Archiver struct {
+	storage      Bucket
+	layout       storage.Layout
+	storageClass string
+}
+
+// NewArchiver creates a new archiver for assets stored in layout
+func NewArchiver(bucket Bucket, layout storage.Layout, storageClass string) *Archiver {
+	if storageClass == "" {
+		storageClass = "ARCHIVE"
+	}
+	return &Archiver{
+		storage:      bucket,
+		layout:       layout,
+		storageClass: storageClass,
+	}
+}
+
+// Archive writes a manifest for the asset and moves its objects, under every
+// layout prefix, to the cold storage class. The manifest itself stays in
+// standard storage so it can be read cheaply. It is kept in the live folder
+// of streams (stream is set) and the vod folder of videos.
+func (a *Archiver) Archive(ctx context.Context, assetID string, stream *StreamRecord) (*Manifest, error) {
+	folder := a.layout.VODPath(assetID)
+	if stream != nil {
+		folder = a.layout.LivePath(assetID)
+	}
+
+	manifest := &Manifest{
+		AssetID:      assetID,
+		Folder:       folder,
+		StorageClass: a.storageClass,
+		ArchivedAt:   time.Now().UTC(),
+		Stream:       stream,
+	}
+
+	for _, prefix := range a.layout.AssetFolders(assetID) {
+		objects, err := a.storage.ListObjects(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, attrs := range objects {
+			if path.Base(attrs.Name) == ManifestFileName {
+				continue
+			}
+			found = true
+			manifest.Objects = append(manifest.Objects, ManifestObject{
+				Path:        attrs.Name,
+				Kind:        a.classify(attrs.Name),
+				Size:        attrs.Size,
+				ContentType: attrs.ContentType,
+				MD5:         hex.EncodeToString(attrs.MD5),
+				CRC32C:      attrs.CRC32C,
+			})
+			manifest.TotalBytes += attrs.Size
+		}
+		if found {
+			manifest.Folders = append(manifest.Folders, strings.TrimSuffix(prefix, "/"))
+		}
+	}
+
+	if len(manifest.Objects) == 0 {
+		return nil, fmt.Errorf("no objects found for asset: %s", assetID)
+	}
+
+	// Write the manifest before moving data so an interrupted archive can be
+	// restored from whatever was already moved
+	if err := a.writeManifest(ctx, manifest); err != nil {
+		return nil, err
+	}
+
+	for _, obj := range manifest.Objects {
+		if _, err := a.storage.SetStorageClass(ctx, obj.Path, a.storageClass); err != nil {
+			return nil, fmt.Errorf("failed to archive %s: %w", obj.Path, err)
+		}
+	}
+
+	log.Printf("[Archive] Archived %s: %d objects, %d bytes to %s", assetID, len(manifest.Objects), manifest.TotalBytes, a.storageClass)
+	return manifest, nil
+}
+
+// Restore re-hydrates an archived asset to standard storage and verifies
+// every object against the checksums recorded in the manifest
+func (a *Archiver) Restore(ctx context.Context, assetID string) (*Manifest, *RestoreReport, error) {
+	manifest, err := a.GetManifest(ctx, assetID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report := &RestoreReport{AssetID: assetID}
+
+	for _, obj := range manifest.Objects {
+		attrs, err := a.storage.SetStorageClass(ctx, obj.Path, "STANDARD")
+		if err != nil {
+			log.Printf("[Archive] Failed to restore %s: %v", obj.Path, err)
+			report.MissingObjects = append(report.MissingObjects, obj.Path)
+			continue
+		}
+		if attrs.CRC32C != obj.CRC32C {
+			report.ChecksumMismatch = append(report.ChecksumMismatch, obj.Path)
+			continue
+		}
+		report.RestoredObjects++
+		report.RestoredBytes += attrs.Size
+	}
+
+	now := time.Now().UTC()
+	report.RestoredAt = now
+	manifest.RestoredAt = &now
+	if err := a.writeManifest(ctx, manifest); err != nil {
+		log.Printf("[Archive] Failed to update manifest for %s: %v", assetID, err)
+	}
+
+	log.Printf("[Archive] Restored %s: %d/%d objects", assetID, report.RestoredObjects, len(manifest.Objects))
+	return manifest, report, nil
+}
+
+// GetManifest reads the archive manifest of an asset
+func (a *Archiver) GetManifest(ctx context.Context, assetID string) (*Manifest, error) {
+	data, err := a.storage.ReadFile(ctx, a.layout.VODPath(assetID, ManifestFileName))
+	if err != nil {
+		data, err = a.storage.ReadFile(ctx, a.layout.LivePath(assetID, ManifestFileName))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("archive manifest not found: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid archive manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+func (a *Archiver) writeManifest(ctx context.Context, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return a.storage.UploadBytes(ctx, data, path.Join(manifest.Folder, ManifestFileName), "application/json")
+}
+
+// classify derives the manifest kind from an object name
+func (a *Archiver) classify(name string) string {
+	switch kind, _, _ := a.layout.AssetOf(name); kind {
+	case "recordings":
+		if path.Ext(name) != ".m3u8" {
+			return KindRecording
+		}
+	case "thumbnails":
+		return KindThumbnail
+	}
+	if strings.Contains(name, "/recording/") {
+		return KindRecording
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".m3u8":
+		return KindPlaylist
+	case ".ts", ".m4s", ".mp4":
+		return KindSegment
+	case ".vtt", ".srt":
+		return KindCaption
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return KindThumbnail
+	default:
+		return KindOther
+	}
+}
@@ -0,0 +1,114 @@
+package archive
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"live-video/pkg/storage"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// fakeBucket keeps objects and their storage classes in memory
+type fakeBucket struct {
+	objects map[string]*gcs.ObjectAttrs
+	data    map[string][]byte
+}
+
+func newFakeBucket(names ...string) *fakeBucket {
+	b := &fakeBucket{objects: make(map[string]*gcs.ObjectAttrs), data: make(map[string][]byte)}
+	for _, name := range names {
+		b.objects[name] = &gcs.ObjectAttrs{Name: name, Size: 10, StorageClass: "STANDARD"}
+	}
+	return b
+}
+
+func (b *fakeBucket) ListObjects(_ context.Context, prefix string) ([]*gcs.ObjectAttrs, error) {
+	var listed []*gcs.ObjectAttrs
+	for name, attrs := range b.objects {
+		if strings.HasPrefix(name, prefix) {
+			listed = append(listed, attrs)
+		}
+	}
+	return listed, nil
+}
+
+func (b *fakeBucket) SetStorageClass(_ context.Context, gcsPath, storageClass string) (*gcs.ObjectAttrs, error) {
+	attrs, ok := b.objects[gcsPath]
+	if !ok {
+		return nil, gcs.ErrObjectNotExist
+	}
+	attrs.StorageClass = storageClass
+	return attrs, nil
+}
+
+func (b *fakeBucket) ReadFile(_ context.Context, gcsPath string) ([]byte, error) {
+	data, ok := b.data[gcsPath]
+	if !ok {
+		return nil, gcs.ErrObjectNotExist
+	}
+	return data, nil
+}
+
+func (b *fakeBucket) UploadBytes(_ context.Context, data []byte, gcsPath, _ string) error {
+	b.data[gcsPath] = data
+	b.objects[gcsPath] = &gcs.ObjectAttrs{Name: gcsPath, Size: int64(len(data)), StorageClass: "STANDARD"}
+	return nil
+}
+
+func TestArchiveStreamMovesEveryFolder(t *testing.T) {
+	bucket := newFakeBucket(
+		"live/s1/playlist.m3u8",
+		"live/s1/720p/segment_000.ts",
+		"recordings/s1/recording.mp4",
+		"thumbnails/s1/poster.jpg",
+		"live/s10/playlist.m3u8", // another stream sharing the ID's prefix
+	)
+	archiver := NewArchiver(bucket, storage.DefaultLayout(), "ARCHIVE")
+
+	manifest, err := archiver.Archive(context.Background(), "s1", &StreamRecord{VideoURL: "rtmp://example/s1"})
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	if manifest.Folder != "live/s1" {
+		t.Errorf("manifest folder = %q, want live/s1", manifest.Folder)
+	}
+	if len(manifest.Objects) != 4 {
+		t.Fatalf("archived %d objects, want 4: %+v", len(manifest.Objects), manifest.Objects)
+	}
+
+	kinds := make(map[string]string)
+	for _, obj := range manifest.Objects {
+		kinds[obj.Path] = obj.Kind
+	}
+	if kinds["recordings/s1/recording.mp4"] != KindRecording {
+		t.Errorf("recording kind = %q", kinds["recordings/s1/recording.mp4"])
+	}
+	if kinds["thumbnails/s1/poster.jpg"] != KindThumbnail {
+		t.Errorf("thumbnail kind = %q", kinds["thumbnails/s1/poster.jpg"])
+	}
+
+	for _, name := range []string{"live/s1/playlist.m3u8", "live/s1/720p/segment_000.ts", "recordings/s1/recording.mp4", "thumbnails/s1/poster.jpg"} {
+		if class := bucket.objects[name].StorageClass; class != "ARCHIVE" {
+			t.Errorf("%s is in %s, want ARCHIVE", name, class)
+		}
+	}
+	if class := bucket.objects["live/s10/playlist.m3u8"].StorageClass; class != "STANDARD" {
+		t.Errorf("object of another stream moved to %s", class)
+	}
+	if class := bucket.objects["live/s1/"+ManifestFileName].StorageClass; class != "STANDARD" {
+		t.Errorf("manifest moved to %s", class)
+	}
+
+	restored, report, err := archiver.Restore(context.Background(), "s1")
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if restored.Stream == nil || report.RestoredObjects != 4 {
+		t.Errorf("restored %d objects, stream %v", report.RestoredObjects, restored.Stream)
+	}
+	if class := bucket.objects["recordings/s1/recording.mp4"].StorageClass; class != "STANDARD" {
+		t.Errorf("recording restored to %s", class)
+	}
+}
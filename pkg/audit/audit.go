@@ -0,0 +1,268 @@
+// Package audit checks a sample of the stored assets for silent storage
+// corruption and partial deletes: that the playlists of videos and
+// recordings list segments that exist with the size and checksums they were
+// stored with, or signed with, and that recordings still open with ffprobe
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math/rand/v2"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"live-video/pkg/integrity"
+	"live-video/pkg/m3u8"
+	"live-video/pkg/vod"
+)
+
+// Kinds of findings
+const (
+	KindMissing            = "missing_object"       // listed but not stored
+	KindSizeMismatch       = "size_mismatch"        // read back shorter or longer than stored
+	KindChecksumMismatch   = "checksum_mismatch"    // content differs from its CRC32C or signed digest
+	KindSignatureInvalid   = "signature_invalid"    // signature unreadable or not matching
+	KindPlaylistUnreadable = "playlist_unreadable"  // can't be read or parsed
+	KindRecordingBroken    = "recording_unreadable" // doesn't open with ffprobe
+)
+
+// Statuses of an audit
+const (
+	StatusRunning = "running"
+	StatusPassed  = "passed"
+	StatusFailed  = "failed" // it has findings
+	StatusError   = "error"  // it could not run
+)
+
+// maxFindings caps the findings a report lists; further ones are counted
+const maxFindings = 500
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Options tune what an audit samples
+type Options struct {
+	Playlists  int `json:"playlists"`  // videos and recordings whose playlists are checked
+	Segments   int `json:"segments"`   // segments of each media playlist read back and checksummed
+	Recordings int `json:"recordings"` // recording files opened with ffprobe
+}
+
+// DefaultOptions returns the sample sizes used unless configured
+func DefaultOptions() Options {
+	return Options{Playlists: 20, Segments: 3, Recordings: 5}
+}
+
+// Finding is an object an audit found damaged or missing
+type Finding struct {
+	Kind    string `json:"kind"`
+	Asset   string `json:"asset"`  // ID of the video or stream
+	Object  string `json:"object"` // object path
+	Message string `json:"message"`
+}
+
+// Report is the outcome of an audit
+type Report struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Trigger    string     `json:"trigger"` // scheduled or manual
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Options    Options    `json:"options"`
+
+	Assets     int       `json:"assets"`            // videos and streams sampled
+	Playlists  int       `json:"playlists"`         // playlists read
+	Segments   int       `json:"segments"`          // segments checked to exist
+	Verified   int       `json:"segments_verified"` // segments read back and checksummed
+	Recordings int       `json:"recordings_probed"` // recording files opened
+	Failures   int       `json:"failures"`          // findings, including those not listed
+	Findings   []Finding `json:"findings"`
+}
+
+// Object is what the bucket listing says of an object
+type Object struct {
+	Size   int64
+	CRC32C uint32 // 0 when unknown
+}
+
+// Source reads an object
+type Source func(ctx context.Context, name string) ([]byte, error)
+
+// check audits the playlists of one run
+type check struct {
+	read    Source
+	objects map[string]Object // the listing of the audited prefixes
+	key     ed25519.PublicKey // verifies playlist signatures; nil skips them
+	opts    Options
+	report  *Report
+}
+
+func (c *check) add(kind, asset, object, format string, args ...any) {
+	c.report.Failures++
+	if len(c.report.Findings) < maxFindings {
+		c.report.Findings = append(c.report.Findings, Finding{Kind: kind, Asset: asset, Object: object, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// masterPlaylists returns the master playlists of the asset folders under
+// prefix, by asset ID
+func masterPlaylists(objects map[string]Object, prefix string) map[string]string {
+	masters := make(map[string]string)
+	for name := range objects {
+		rest, ok := strings.CutPrefix(name, prefix+"/")
+		if !ok {
+			continue
+		}
+		if id, file, ok := strings.Cut(rest, "/"); ok && file == vod.PlaylistName {
+			masters[id] = name
+		}
+	}
+	return masters
+}
+
+// sample returns up to n of items, at random
+func sample[T any](items []T, n int) []T {
+	if len(items) <= n {
+		return items
+	}
+	picked := slices.Clone(items)
+	rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	return picked[:n]
+}
+
+// checkAsset checks the master playlist of an asset and the media playlists
+// it lists
+func (c *check) checkAsset(ctx context.Context, asset, master string) {
+	c.report.Assets++
+	data, ok := c.readPlaylist(ctx, asset, master)
+	if !ok {
+		return
+	}
+	if !m3u8.IsMaster(data) {
+		c.checkMedia(ctx, asset, master, data)
+		return
+	}
+	playlist, err := m3u8.ParseMaster(data)
+	if err != nil {
+		c.add(KindPlaylistUnreadable, asset, master, "master playlist can't be parsed: %v", err)
+		return
+	}
+	c.verifySignature(ctx, asset, master, data)
+	for _, uri := range playlist.URIs() {
+		if !local(uri) {
+			continue
+		}
+		name := path.Join(path.Dir(master), uri)
+		if media, ok := c.readPlaylist(ctx, asset, name); ok {
+			c.checkMedia(ctx, asset, name, media)
+		}
+	}
+}
+
+// readPlaylist reads a playlist, adding a finding when it can't
+func (c *check) readPlaylist(ctx context.Context, asset, name string) ([]byte, bool) {
+	if _, ok := c.objects[name]; !ok {
+		c.add(KindMissing, asset, name, "playlist is listed but not stored")
+		return nil, false
+	}
+	data, err := c.read(ctx, name)
+	if err != nil {
+		c.add(KindPlaylistUnreadable, asset, name, "playlist can't be read: %v", err)
+		return nil, false
+	}
+	c.report.Playlists++
+	c.verifyObject(asset, name, data, "")
+	return data, true
+}
+
+// checkMedia checks that the segments of a media playlist exist and reads
+// back a sample of them
+func (c *check) checkMedia(ctx context.Context, asset, name string, data []byte) {
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		c.add(KindPlaylistUnreadable, asset, name, "media playlist can't be parsed: %v", err)
+		return
+	}
+	manifest := c.verifySignature(ctx, asset, name, data)
+
+	var stored []string
+	seen := make(map[string]bool)
+	for _, uri := range playlist.URIs() {
+		if seen[uri] || !local(uri) {
+			continue
+		}
+		seen[uri] = true
+		c.report.Segments++
+		segment := path.Join(path.Dir(name), uri)
+		if _, ok := c.objects[segment]; !ok {
+			c.add(KindMissing, asset, segment, "segment is listed in %s but not stored", path.Base(name))
+			continue
+		}
+		stored = append(stored, uri)
+	}
+
+	for _, uri := range sample(stored, c.opts.Segments) {
+		segment := path.Join(path.Dir(name), uri)
+		content, err := c.read(ctx, segment)
+		if err != nil {
+			c.add(KindMissing, asset, segment, "segment can't be read: %v", err)
+			continue
+		}
+		c.report.Verified++
+		var signed string
+		if manifest != nil {
+			signed = manifest.Segments[uri]
+		}
+		c.verifyObject(asset, segment, content, signed)
+	}
+}
+
+// verifyObject compares an object read back with its listing and with the
+// digest it was signed with, "" for none
+func (c *check) verifyObject(asset, name string, data []byte, signed string) {
+	object := c.objects[name]
+	switch {
+	case int64(len(data)) != object.Size:
+		c.add(KindSizeMismatch, asset, name, "read %d bytes of %d stored", len(data), object.Size)
+	case object.CRC32C != 0 && crc32.Checksum(data, castagnoli) != object.CRC32C:
+		c.add(KindChecksumMismatch, asset, name, "content does not match its stored CRC32C")
+	case signed != "" && !strings.EqualFold(integrity.Digest(data), signed):
+		c.add(KindChecksumMismatch, asset, name, "content does not match the digest it was signed with")
+	}
+}
+
+// verifySignature checks the signature of a playlist, if it has one, and
+// returns it when valid
+func (c *check) verifySignature(ctx context.Context, asset, name string, data []byte) *integrity.Manifest {
+	sigName := name + integrity.SignatureSuffix
+	if _, ok := c.objects[sigName]; !ok || c.key == nil {
+		return nil
+	}
+	raw, err := c.read(ctx, sigName)
+	if err != nil {
+		c.add(KindSignatureInvalid, asset, sigName, "signature can't be read: %v", err)
+		return nil
+	}
+	var manifest integrity.Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		c.add(KindSignatureInvalid, asset, sigName, "signature can't be parsed: %v", err)
+		return nil
+	}
+	if err := manifest.Verify(c.key); err != nil {
+		c.add(KindSignatureInvalid, asset, sigName, "%v", err)
+		return nil
+	}
+	if !strings.EqualFold(integrity.Digest(data), manifest.SHA256) {
+		c.add(KindChecksumMismatch, asset, name, "playlist does not match the digest it was signed with")
+	}
+	return &manifest
+}
+
+// local reports whether a playlist URI names an object next to the playlist
+func local(uri string) bool {
+	return uri != "" && !strings.Contains(uri, "://") && !path.IsAbs(uri) && !strings.HasPrefix(path.Clean(uri), "..")
+}
@@ -0,0 +1,401 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/events"
+	"live-video/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// ReportPrefix is where audit reports are kept in the bucket, outside the
+// layout prefixes so no lifecycle rule removes them
+const ReportPrefix = "audits"
+
+// maxRecordingProbe is the largest recording downloaded to be opened;
+// larger ones are not sampled
+const maxRecordingProbe = 2 << 30
+
+// maxEventFindings is how many findings an audit.failed event carries
+const maxEventFindings = 20
+
+// recordingExts lists the file types of live stream recordings
+var recordingExts = map[string]bool{
+	".mp4":  true,
+	".mkv":  true,
+	".flv":  true,
+	".webm": true,
+	".ts":   true,
+}
+
+// ErrRunning is returned when an audit is started while one runs
+var ErrRunning = errors.New("an audit is already running")
+
+// Prober opens a media file, failing when it can't be read as one
+type Prober func(ctx context.Context, file string) error
+
+// Auditor runs audits of the bucket, one at a time, and keeps their reports
+// in the bucket
+type Auditor struct {
+	storage *storage.GCSService
+	key     ed25519.PublicKey
+	opts    Options
+	probe   Prober
+
+	mu      sync.Mutex
+	running *Report // as it started
+	events  *events.Bus
+}
+
+// NewAuditor creates an auditor of the bucket of gcsService. key verifies
+// playlist signatures; nil skips them.
+func NewAuditor(gcsService *storage.GCSService, key ed25519.PublicKey, opts Options) *Auditor {
+	return &Auditor{storage: gcsService, key: key, opts: opts, probe: FFprobe}
+}
+
+// SetEvents publishes finished audits on bus as events.AuditCompleted, and
+// those with findings or errors also as events.AuditFailed
+func (a *Auditor) SetEvents(bus *events.Bus) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = bus
+}
+
+// Start starts an audit in the background and returns its report as it
+// starts
+func (a *Auditor) Start(trigger string) (*Report, error) {
+	report, err := a.begin(trigger)
+	if err != nil {
+		return nil, err
+	}
+	started := *report
+	go a.run(context.Background(), report)
+	return &started, nil
+}
+
+// Run runs an audit and returns its report
+func (a *Auditor) Run(ctx context.Context, trigger string) (*Report, error) {
+	report, err := a.begin(trigger)
+	if err != nil {
+		return nil, err
+	}
+	a.run(ctx, report)
+	return report, nil
+}
+
+func (a *Auditor) begin(trigger string) (*Report, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running != nil {
+		return nil, ErrRunning
+	}
+	report := &Report{
+		ID:        uuid.New().String(),
+		Status:    StatusRunning,
+		Trigger:   trigger,
+		StartedAt: time.Now().UTC(),
+		Options:   a.opts,
+		Findings:  []Finding{},
+	}
+	started := *report
+	a.running = &started
+	return report, nil
+}
+
+// run audits into report, records it and publishes the outcome
+func (a *Auditor) run(ctx context.Context, report *Report) {
+	err := a.audit(ctx, report)
+	finished := time.Now().UTC()
+	report.FinishedAt = &finished
+	switch {
+	case err != nil:
+		report.Status = StatusError
+		report.Error = err.Error()
+	case report.Failures > 0:
+		report.Status = StatusFailed
+	default:
+		report.Status = StatusPassed
+	}
+
+	// Recorded even when ctx ended the audit
+	if err := a.record(context.WithoutCancel(ctx), report); err != nil {
+		log.Printf("[Audit] Failed to record report of audit %s: %v", report.ID, err)
+	}
+	a.mu.Lock()
+	a.running = nil
+	bus := a.events
+	a.mu.Unlock()
+	log.Printf("[Audit] Audit %s %s: %d assets, %d segments (%d read back), %d recordings, %d findings",
+		report.ID, report.Status, report.Assets, report.Segments, report.Verified, report.Recordings, report.Failures)
+
+	data := map[string]any{
+		"audit_id":   report.ID,
+		"status":     report.Status,
+		"assets":     report.Assets,
+		"playlists":  report.Playlists,
+		"segments":   report.Segments,
+		"recordings": report.Recordings,
+		"failures":   report.Failures,
+	}
+	bus.Publish(events.AuditCompleted, data)
+	if report.Status != StatusPassed {
+		failed := make(map[string]any, len(data)+2)
+		for k, v := range data {
+			failed[k] = v
+		}
+		failed["error"] = report.Error
+		failed["findings"] = report.Findings[:min(len(report.Findings), maxEventFindings)]
+		bus.Publish(events.AuditFailed, failed)
+	}
+}
+
+// audit checks the playlists of a sample of the videos and recordings and
+// opens a sample of the recording files
+func (a *Auditor) audit(ctx context.Context, report *Report) error {
+	layout := a.storage.Layout()
+	objects := make(map[string]Object)
+	var recordings []string
+	for _, prefix := range []string{layout.VOD, layout.Recordings} {
+		listed, err := a.storage.ListObjects(ctx, prefix+"/")
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		for _, attrs := range listed {
+			objects[attrs.Name] = Object{Size: attrs.Size, CRC32C: attrs.CRC32C}
+			if prefix == layout.Recordings && recordingExts[strings.ToLower(path.Ext(attrs.Name))] && attrs.Size <= maxRecordingProbe {
+				recordings = append(recordings, attrs.Name)
+			}
+		}
+	}
+
+	c := &check{
+		read:    a.storage.ReadFile,
+		objects: objects,
+		key:     a.key,
+		opts:    a.opts,
+		report:  report,
+	}
+	type asset struct{ id, master string }
+	var assets []asset
+	for _, prefix := range []string{layout.VOD, layout.Recordings} {
+		for id, master := range masterPlaylists(objects, prefix) {
+			assets = append(assets, asset{id, master})
+		}
+	}
+	for _, picked := range sample(assets, a.opts.Playlists) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.checkAsset(ctx, picked.id, picked.master)
+	}
+
+	for _, name := range sample(recordings, a.opts.Recordings) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		_, id, _ := layout.AssetOf(name)
+		err := a.probeRecording(ctx, name)
+		if errors.Is(err, exec.ErrNotFound) {
+			return fmt.Errorf("recordings can't be opened: %w", err)
+		}
+		if err != nil {
+			c.add(KindRecordingBroken, id, name, "%v", err)
+		}
+		report.Recordings++
+	}
+	return nil
+}
+
+// probeRecording downloads a recording and opens it
+func (a *Auditor) probeRecording(ctx context.Context, name string) error {
+	file, err := os.CreateTemp("", "audit-*"+path.Ext(name))
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := a.storage.DownloadFile(ctx, name, file.Name()); err != nil {
+		return fmt.Errorf("recording can't be read: %w", err)
+	}
+	return a.probe(ctx, file.Name())
+}
+
+// record keeps a report in the bucket
+func (a *Auditor) record(ctx context.Context, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return a.storage.UploadBytes(ctx, data, reportPath(report.ID), "application/json")
+}
+
+// Get returns the report of an audit, the running one included. It returns
+// os.ErrNotExist for audits that don't exist.
+func (a *Auditor) Get(ctx context.Context, id string) (*Report, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, os.ErrNotExist
+	}
+	a.mu.Lock()
+	if a.running != nil && a.running.ID == id {
+		running := *a.running
+		a.mu.Unlock()
+		return &running, nil
+	}
+	a.mu.Unlock()
+
+	data, err := a.storage.ReadFile(ctx, reportPath(id))
+	if storage.IsNotExist(err) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("unreadable audit report %s: %w", id, err)
+	}
+	return &report, nil
+}
+
+// List returns the reports of past audits, newest first, without their
+// findings
+func (a *Auditor) List(ctx context.Context) ([]*Report, error) {
+	objects, err := a.storage.ListObjects(ctx, ReportPrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*Report, 0, len(objects))
+	for _, attrs := range objects {
+		data, err := a.storage.ReadFile(ctx, attrs.Name)
+		if err != nil {
+			return nil, err
+		}
+		var report Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("unreadable audit report %s: %w", attrs.Name, err)
+		}
+		report.Findings = nil
+		reports = append(reports, &report)
+	}
+	slices.SortFunc(reports, func(x, y *Report) int { return y.StartedAt.Compare(x.StartedAt) })
+	return reports, nil
+}
+
+// Running returns the ID of the running audit, if one runs
+func (a *Auditor) Running() (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running == nil {
+		return "", false
+	}
+	return a.running.ID, true
+}
+
+// ForgetAsset removes the findings about a video or stream from the
+// recorded reports and returns how many it removed
+func (a *Auditor) ForgetAsset(ctx context.Context, assetID string) (int, error) {
+	objects, err := a.storage.ListObjects(ctx, ReportPrefix+"/")
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var errs []error
+	for _, attrs := range objects {
+		data, err := a.storage.ReadFile(ctx, attrs.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var report Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			errs = append(errs, fmt.Errorf("unreadable audit report %s: %w", attrs.Name, err))
+			continue
+		}
+		listed := len(report.Findings)
+		report.Findings = slices.DeleteFunc(report.Findings, func(f Finding) bool { return f.Asset == assetID })
+		n := listed - len(report.Findings)
+		if n == 0 {
+			continue
+		}
+		report.Failures -= n
+		if err := a.record(ctx, &report); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed += n
+	}
+	return removed, errors.Join(errs...)
+}
+
+// AssetFindings counts the findings about a video or stream in the recorded
+// reports
+func (a *Auditor) AssetFindings(ctx context.Context, assetID string) (int, error) {
+	objects, err := a.storage.ListObjects(ctx, ReportPrefix+"/")
+	if err != nil {
+		return 0, err
+	}
+	found := 0
+	for _, attrs := range objects {
+		data, err := a.storage.ReadFile(ctx, attrs.Name)
+		if err != nil {
+			return found, err
+		}
+		var report Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			return found, fmt.Errorf("unreadable audit report %s: %w", attrs.Name, err)
+		}
+		for _, finding := range report.Findings {
+			if finding.Asset == assetID {
+				found++
+			}
+		}
+	}
+	return found, nil
+}
+
+func reportPath(id string) string {
+	return path.Join(ReportPrefix, id+".json")
+}
+
+// FFprobe opens a media file with ffprobe, failing unless it has streams
+// and a duration
+func FFprobe(ctx context.Context, file string) error {
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error",
+		"-show_entries", "format=duration:stream=codec_type", "-of", "json", file).Output()
+	if err != nil {
+		return fmt.Errorf("ffprobe failed: %w", err)
+	}
+	var result struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return fmt.Errorf("unreadable ffprobe output: %w", err)
+	}
+	if len(result.Streams) == 0 {
+		return fmt.Errorf("no streams")
+	}
+	if duration, _ := strconv.ParseFloat(result.Format.Duration, 64); duration <= 0 {
+		return fmt.Errorf("no duration")
+	}
+	return nil
+}
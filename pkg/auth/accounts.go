@@ -0,0 +1,494 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+type Permission string
+
+const (
+	PermissionRead   Permission = "read"
+	PermissionManage Permission = "manage"
+)
+
+// Resource kinds that can be owned
+const (
+	ResourceStream     = "stream"
+	ResourceVideo      = "video"
+	ResourceEvent      = "event"
+	ResourceCollection = "collection"
+)
+
+// User is an account that can own streams and videos
+type User struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Email  string `json:"email,omitempty"`
+	Role   Role   `json:"role"`
+	TeamID string `json:"team_id,omitempty"`
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// Team groups users; members can read each other's assets
+type Team struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Ownership records who owns a resource and who it is shared with
+type Ownership struct {
+	OwnerID string                `json:"owner_id"`
+	TeamID  string                `json:"team_id,omitempty"`
+	Grants  map[string]Permission `json:"grants,omitempty"`
+}
+
+// AccountsFile is the on-disk format of the accounts configuration
+type AccountsFile struct {
+	Teams []Team `json:"teams"`
+	Users []User `json:"users"`
+}
+
+// session is an API token issued after an SSO login
+type session struct {
+	userID    string
+	expiresAt time.Time
+}
+
+// Service manages accounts and resource ownership
+type Service struct {
+	mu         sync.RWMutex
+	users      map[string]*User
+	teams      map[string]*Team
+	ownership  map[string]*Ownership
+	sessions   map[string]*session
+	ssoEnabled bool
+	store      OwnershipStore
+}
+
+// OwnershipStore keeps ownership records across restarts and replicas
+type OwnershipStore interface {
+	// SaveOwnership stores the ownership record of a resource
+	SaveOwnership(kind, resourceID string, own *Ownership)
+	// DeleteOwnership removes the ownership record of a deleted resource
+	DeleteOwnership(kind, resourceID string)
+}
+
+// NewService creates an empty account service. With no users configured,
+// authentication is disabled and every caller is treated as an admin.
+func NewService() *Service {
+	return &Service{
+		users:     make(map[string]*User),
+		teams:     make(map[string]*Team),
+		ownership: make(map[string]*Ownership),
+		sessions:  make(map[string]*session),
+	}
+}
+
+// SetOwnershipStore makes ownership changes go to store. Set it before the
+// service is used.
+func (s *Service) SetOwnershipStore(store OwnershipStore) {
+	s.store = store
+}
+
+// LoadOwnership sets the ownership record of a resource as read from the
+// store, without storing it again
+func (s *Service) LoadOwnership(kind, resourceID string, own *Ownership) {
+	c := own.copy()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ownership[resourceKey(kind, resourceID)] = c
+}
+
+// ForgetOwnership drops the ownership record of a resource removed from the
+// store, without removing it again
+func (s *Service) ForgetOwnership(kind, resourceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ownership, resourceKey(kind, resourceID))
+}
+
+// LoadFile loads teams and users from a JSON accounts file
+func (s *Service) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read accounts file: %w", err)
+	}
+
+	var file AccountsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse accounts file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range file.Teams {
+		team := file.Teams[i]
+		s.teams[team.ID] = &team
+	}
+	for i := range file.Users {
+		user := file.Users[i]
+		if user.ID == "" || user.APIKey == "" {
+			return fmt.Errorf("user entries require id and api_key")
+		}
+		if user.Role == "" {
+			user.Role = RoleMember
+		}
+		s.users[user.ID] = &user
+	}
+
+	return nil
+}
+
+// Enabled reports whether any accounts are configured or SSO login is on
+func (s *Service) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users) > 0 || s.ssoEnabled
+}
+
+// EnableSSO turns on account enforcement for SSO-only deployments
+func (s *Service) EnableSSO() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ssoEnabled = true
+}
+
+// UpsertUser creates or updates an account from an external identity.
+// Existing API keys are preserved.
+func (s *Service) UpsertUser(user *User) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, exists := s.users[user.ID]; exists {
+		user.APIKey = existing.APIKey
+	}
+	c := *user
+	s.users[user.ID] = &c
+	return c.public()
+}
+
+// IssueSessionToken creates an API token for a user that expires after ttl
+func (s *Service) IssueSessionToken(userID string, ttl time.Duration) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	expiresAt := time.Now().Add(ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired sessions while we hold the lock
+	now := time.Now()
+	for t, sess := range s.sessions {
+		if now.After(sess.expiresAt) {
+			delete(s.sessions, t)
+		}
+	}
+
+	s.sessions[token] = &session{userID: userID, expiresAt: expiresAt}
+	return token, expiresAt, nil
+}
+
+// RevokeSessionToken invalidates a session token
+func (s *Service) RevokeSessionToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// AuthenticateToken resolves either a static API key or an SSO session token
+func (s *Service) AuthenticateToken(token string) (*User, error) {
+	if user, err := s.AuthenticateAPIKey(token); err == nil {
+		return user, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sess, exists := s.sessions[token]
+	if !exists || time.Now().After(sess.expiresAt) {
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+
+	user, exists := s.users[sess.userID]
+	if !exists {
+		return nil, fmt.Errorf("user not found: %s", sess.userID)
+	}
+	return user.public(), nil
+}
+
+// AuthenticateAPIKey resolves an API key to a user
+func (s *Service) AuthenticateAPIKey(apiKey string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.APIKey != "" && subtle.ConstantTimeCompare([]byte(user.APIKey), []byte(apiKey)) == 1 {
+			return user.public(), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid API key")
+}
+
+// GetUser returns a user by ID
+func (s *Service) GetUser(userID string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return nil, fmt.Errorf("user not found: %s", userID)
+	}
+	return user.public(), nil
+}
+
+// ListUsers returns all users without their API keys
+func (s *Service) ListUsers() []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user.public())
+	}
+	return users
+}
+
+// DeleteUser removes a user, their sessions and what was shared with them,
+// and reports how many of those there were. The resources they own keep
+// their ownership records; callers delete them first. Users of the accounts
+// file come back on restart unless removed from it.
+func (s *Service) DeleteUser(userID string) int {
+	s.mu.Lock()
+	removed := 0
+	if _, exists := s.users[userID]; exists {
+		delete(s.users, userID)
+		removed++
+	}
+	for token, sess := range s.sessions {
+		if sess.userID == userID {
+			delete(s.sessions, token)
+			removed++
+		}
+	}
+	var unshared []string
+	for key, own := range s.ownership {
+		if _, shared := own.Grants[userID]; shared {
+			delete(own.Grants, userID)
+			unshared = append(unshared, key)
+			removed++
+		}
+	}
+	s.mu.Unlock()
+
+	for _, key := range unshared {
+		kind, resourceID, _ := strings.Cut(key, ":")
+		s.saveOwnership(kind, resourceID)
+	}
+	return removed
+}
+
+// Owned returns the IDs of the resources a user owns, by kind
+func (s *Service) Owned(userID string) map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	owned := make(map[string][]string)
+	for key, own := range s.ownership {
+		if own.OwnerID != userID {
+			continue
+		}
+		kind, resourceID, _ := strings.Cut(key, ":")
+		owned[kind] = append(owned[kind], resourceID)
+	}
+	return owned
+}
+
+// ListTeams returns all teams
+func (s *Service) ListTeams() []*Team {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	teams := make([]*Team, 0, len(s.teams))
+	for _, team := range s.teams {
+		teams = append(teams, team)
+	}
+	return teams
+}
+
+// SetOwner records the owner of a resource. A nil user leaves the resource unowned.
+func (s *Service) SetOwner(kind, resourceID string, owner *User) {
+	if owner == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.ownership[resourceKey(kind, resourceID)] = &Ownership{
+		OwnerID: owner.ID,
+		TeamID:  owner.TeamID,
+		Grants:  make(map[string]Permission),
+	}
+	s.mu.Unlock()
+	s.saveOwnership(kind, resourceID)
+}
+
+// GetOwnership returns a copy of a resource's ownership record
+func (s *Service) GetOwnership(kind, resourceID string) (*Ownership, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	own, exists := s.ownership[resourceKey(kind, resourceID)]
+	if !exists {
+		return nil, false
+	}
+
+	return own.copy(), true
+}
+
+// RemoveResource forgets the ownership record of a deleted resource
+func (s *Service) RemoveResource(kind, resourceID string) {
+	s.mu.Lock()
+	_, exists := s.ownership[resourceKey(kind, resourceID)]
+	delete(s.ownership, resourceKey(kind, resourceID))
+	s.mu.Unlock()
+	if exists && s.store != nil {
+		s.store.DeleteOwnership(kind, resourceID)
+	}
+}
+
+// saveOwnership hands the ownership record of a resource to the store
+func (s *Service) saveOwnership(kind, resourceID string) {
+	if s.store == nil {
+		return
+	}
+	if own, exists := s.GetOwnership(kind, resourceID); exists {
+		s.store.SaveOwnership(kind, resourceID, own)
+	}
+}
+
+// Share grants a user read or manage rights on a resource
+func (s *Service) Share(kind, resourceID, userID string, perm Permission) error {
+	if perm != PermissionRead && perm != PermissionManage {
+		return fmt.Errorf("invalid permission: %s", perm)
+	}
+
+	s.mu.Lock()
+	if _, exists := s.users[userID]; !exists {
+		s.mu.Unlock()
+		return fmt.Errorf("user not found: %s", userID)
+	}
+
+	own, exists := s.ownership[resourceKey(kind, resourceID)]
+	if !exists {
+		s.mu.Unlock()
+		return fmt.Errorf("%s has no owner: %s", kind, resourceID)
+	}
+
+	own.Grants[userID] = perm
+	s.mu.Unlock()
+	s.saveOwnership(kind, resourceID)
+	return nil
+}
+
+// Unshare revokes a user's grant on a resource
+func (s *Service) Unshare(kind, resourceID, userID string) {
+	s.mu.Lock()
+	own, exists := s.ownership[resourceKey(kind, resourceID)]
+	if exists {
+		delete(own.Grants, userID)
+	}
+	s.mu.Unlock()
+	if exists {
+		s.saveOwnership(kind, resourceID)
+	}
+}
+
+// Can reports whether user holds perm on a resource. Admins can do
+// everything, owners manage their resources, team members can read them,
+// and explicit grants apply on top. With authentication disabled every
+// request is allowed.
+func (s *Service) Can(user *User, kind, resourceID string, perm Permission) bool {
+	if !s.Enabled() {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+	if user.Role == RoleAdmin {
+		return true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	own, exists := s.ownership[resourceKey(kind, resourceID)]
+	if !exists {
+		return false
+	}
+	if own.OwnerID == user.ID {
+		return true
+	}
+	if granted, ok := own.Grants[user.ID]; ok {
+		if granted == PermissionManage || perm == PermissionRead {
+			return true
+		}
+	}
+	return perm == PermissionRead && own.TeamID != "" && own.TeamID == user.TeamID
+}
+
+// IsMine reports whether a resource belongs to the user's own asset list:
+// owned by them or shared with them
+func (s *Service) IsMine(user *User, kind, resourceID string) bool {
+	if user == nil {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	own, exists := s.ownership[resourceKey(kind, resourceID)]
+	if !exists {
+		return false
+	}
+	if own.OwnerID == user.ID {
+		return true
+	}
+	_, shared := own.Grants[user.ID]
+	return shared
+}
+
+func (o *Ownership) copy() *Ownership {
+	c := &Ownership{OwnerID: o.OwnerID, TeamID: o.TeamID, Grants: make(map[string]Permission, len(o.Grants))}
+	for userID, perm := range o.Grants {
+		c.Grants[userID] = perm
+	}
+	return c
+}
+
+func (u *User) public() *User {
+	c := *u
+	c.APIKey = ""
+	return &c
+}
+
+func resourceKey(kind, resourceID string) string {
+	return kind + ":" + resourceID
+}
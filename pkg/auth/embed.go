@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EmbedClaims are the contents of an embed token
+type EmbedClaims struct {
+	StreamID  string `json:"sid"`
+	Domain    string `json:"dom"`
+	ExpiresAt int64  `json:"exp"`
+	Class     string `json:"cls,omitempty"`  // viewer class, for the playlist window
+	Mode      string `json:"mode,omitempty"` // playback mode the holder is served
+	// Consent is set when the embedding site confirms its viewers passed
+	// the stream's content gate, which the player then skips
+	Consent bool `json:"cst,omitempty"`
+	// Viewer is the embedding site's ID of the token's viewer, so all of
+	// the viewer's tokens can be revoked at once
+	Viewer   string `json:"sub,omitempty"`
+	IssuedAt int64  `json:"iat,omitempty"`
+}
+
+// ConsentClaims are the contents of a consent token: a viewer confirmed the
+// content gate of a stream
+type ConsentClaims struct {
+	StreamID  string `json:"sid"`
+	MinAge    int    `json:"age,omitempty"` // the age the viewer confirmed being at least
+	ExpiresAt int64  `json:"exp"`
+}
+
+// PlaybackClaims are the contents of a playback token: its holder may load
+// the HLS files of a video
+type PlaybackClaims struct {
+	VideoID   string `json:"vid"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// MaxEmbedTokenTTL is the longest lifetime of an embed token
+const MaxEmbedTokenTTL = 30 * 24 * time.Hour
+
+// consentDomain separates the signatures of consent tokens from those of
+// embed tokens, so neither passes for the other
+const consentDomain = "consent."
+
+// playbackDomain separates the signatures of playback tokens from those of
+// embed and consent tokens
+const playbackDomain = "playback."
+
+// EmbedSigner issues and verifies embed tokens: HMAC-signed claims that let
+// a player embedded on one domain play one stream until the token expires.
+// It signs consent and playback tokens too.
+type EmbedSigner struct {
+	secret  []byte
+	revoker Revoker
+}
+
+// Revoker tells revoked embed tokens from valid ones
+type Revoker interface {
+	// Revoked returns why the token with tokenID and claims was revoked,
+	// false when it was not
+	Revoked(tokenID string, claims *EmbedClaims) (reason string, revoked bool)
+}
+
+// SetRevoker makes Verify reject the tokens revoker revoked. Set it before
+// the signer is used.
+func (s *EmbedSigner) SetRevoker(revoker Revoker) {
+	s.revoker = revoker
+}
+
+// NewEmbedSigner creates a signer. With an empty secret a random one is
+// generated, so tokens don't survive a restart.
+func NewEmbedSigner(secret string) (*EmbedSigner, error) {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate embed secret: %w", err)
+		}
+	}
+	return &EmbedSigner{secret: key}, nil
+}
+
+// Issue returns a token for streamID bound to domain until expiresAt. class
+// names the viewer class of its holders; empty means anonymous. mode pins
+// the playback mode of its holders; empty lets them pick.
+func (s *EmbedSigner) Issue(streamID, domain, class, mode string, expiresAt time.Time) string {
+	return s.IssueClaims(EmbedClaims{
+		StreamID:  streamID,
+		Domain:    domain,
+		ExpiresAt: expiresAt.Unix(),
+		Class:     class,
+		Mode:      mode,
+	})
+}
+
+// IssueClaims returns a token of claims, with their domain normalized and
+// stamped with the time of issue
+func (s *EmbedSigner) IssueClaims(claims EmbedClaims) string {
+	claims.Domain = NormalizeDomain(claims.Domain)
+	claims.IssuedAt = time.Now().Unix()
+	return s.seal("", claims)
+}
+
+// Verify checks a token's signature and expiry and that it was issued for
+// streamID
+func (s *EmbedSigner) Verify(token, streamID string) (*EmbedClaims, error) {
+	var claims EmbedClaims
+	if !s.open("", token, &claims) {
+		return nil, fmt.Errorf("invalid embed token")
+	}
+	if claims.StreamID != streamID {
+		return nil, fmt.Errorf("embed token is for another stream")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("embed token expired")
+	}
+	if s.revoker != nil {
+		if reason, revoked := s.revoker.Revoked(TokenID(token), &claims); revoked {
+			if reason == "" {
+				return nil, fmt.Errorf("embed token was revoked")
+			}
+			return nil, fmt.Errorf("embed token was revoked: %s", reason)
+		}
+	}
+	return &claims, nil
+}
+
+// Inspect returns the claims of a token signed by s, expired or revoked or
+// not, e.g. to revoke it
+func (s *EmbedSigner) Inspect(token string) (*EmbedClaims, error) {
+	var claims EmbedClaims
+	if !s.open("", token, &claims) {
+		return nil, fmt.Errorf("invalid embed token")
+	}
+	return &claims, nil
+}
+
+// TokenID returns the ID a token is revoked by, a digest of its signature.
+// The token can't be rebuilt from it, so IDs are safe to list.
+func TokenID(token string) string {
+	_, signature, _ := strings.Cut(token, ".")
+	sum := sha256.Sum256([]byte(signature))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// IssueConsent returns a token recording that a viewer confirmed the content
+// gate of streamID, and being at least minAge, until expiresAt
+func (s *EmbedSigner) IssueConsent(streamID string, minAge int, expiresAt time.Time) string {
+	return s.seal(consentDomain, ConsentClaims{StreamID: streamID, MinAge: minAge, ExpiresAt: expiresAt.Unix()})
+}
+
+// VerifyConsent checks a consent token's signature and expiry and that it
+// was issued for streamID
+func (s *EmbedSigner) VerifyConsent(token, streamID string) (*ConsentClaims, error) {
+	var claims ConsentClaims
+	if !s.open(consentDomain, token, &claims) {
+		return nil, fmt.Errorf("invalid consent token")
+	}
+	if claims.StreamID != streamID {
+		return nil, fmt.Errorf("consent token is for another stream")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("consent token expired")
+	}
+	return &claims, nil
+}
+
+// IssuePlayback returns a token that lets its holder load the HLS files of
+// videoID until expiresAt
+func (s *EmbedSigner) IssuePlayback(videoID string, expiresAt time.Time) string {
+	return s.seal(playbackDomain, PlaybackClaims{VideoID: videoID, ExpiresAt: expiresAt.Unix()})
+}
+
+// VerifyPlayback checks a playback token's signature and expiry and that it
+// was issued for videoID
+func (s *EmbedSigner) VerifyPlayback(token, videoID string) (*PlaybackClaims, error) {
+	var claims PlaybackClaims
+	if !s.open(playbackDomain, token, &claims) {
+		return nil, fmt.Errorf("invalid playback token")
+	}
+	if claims.VideoID != videoID {
+		return nil, fmt.Errorf("playback token is for another video")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("playback token expired")
+	}
+	return &claims, nil
+}
+
+// seal encodes claims and signs them in a signature domain
+func (s *EmbedSigner) seal(domain string, claims any) string {
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(domain+encoded)
+}
+
+// open checks the signature of a token in a signature domain and decodes its
+// claims
+func (s *EmbedSigner) open(domain, token string, claims any) bool {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(domain+encoded))) {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	return err == nil && json.Unmarshal(payload, claims) == nil
+}
+
+// AllowsHost reports whether a page on host may use the token: the bound
+// domain itself or one of its subdomains
+func (c *EmbedClaims) AllowsHost(host string) bool {
+	host = NormalizeDomain(host)
+	return host == c.Domain || strings.HasSuffix(host, "."+c.Domain)
+}
+
+// NormalizeDomain lowercases a domain and strips a scheme, path and port
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if i := strings.Index(domain, "://"); i >= 0 {
+		domain = domain[i+3:]
+	}
+	if i := strings.IndexAny(domain, "/?#"); i >= 0 {
+		domain = domain[:i]
+	}
+	if i := strings.LastIndex(domain, ":"); i >= 0 && !strings.Contains(domain[i:], "]") {
+		domain = domain[:i]
+	}
+	return strings.TrimSuffix(domain, ".")
+}
+
+func (s *EmbedSigner) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"golang.org/x/oauth2"
+)
+
+// OIDCProviderConfig configures a single OpenID Connect identity provider
+// (Google, Microsoft Entra ID, Okta, ...)
+type OIDCProviderConfig struct {
+	Name         string   `json:"name"`
+	IssuerURL    string   `json:"issuer_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+
+	// AllowedDomains restricts logins to these e-mail domains when set
+	AllowedDomains []string `json:"allowed_domains"`
+
+	// Claim mapping: a claim (e.g. "groups", "roles") whose values decide the
+	// role, and a claim (e.g. "hd", "tid", "org") mapped to a team
+	RoleClaim   string            `json:"role_claim"`
+	AdminValues []string          `json:"admin_values"`
+	TeamClaim   string            `json:"team_claim"`
+	TeamMapping map[string]string `json:"team_mapping"`
+	DefaultTeam string            `json:"default_team"`
+}
+
+// IDTokenClaims holds the standard claims read from a verified ID token plus
+// the raw claim set used for role and team mapping
+type IDTokenClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Raw           map[string]interface{}
+}
+
+// OIDCProvider performs the authorization code flow and ID token verification
+// for one identity provider
+type OIDCProvider struct {
+	config      OIDCProviderConfig
+	oauthConfig *oauth2.Config
+	jwksURL     string
+	httpClient  *http.Client
+
+	mu        sync.Mutex
+	keys      *jose.JSONWebKeySet
+	keysFetch time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// LoadOIDCProviders reads provider configurations from a JSON file
+func LoadOIDCProviders(path string) ([]OIDCProviderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OIDC config: %w", err)
+	}
+
+	var providers []OIDCProviderConfig
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("failed to parse OIDC config: %w", err)
+	}
+	return providers, nil
+}
+
+// NewOIDCProvider discovers the provider's endpoints from its issuer URL
+func NewOIDCProvider(ctx context.Context, cfg OIDCProviderConfig) (*OIDCProvider, error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	wellKnown := strings.TrimSuffix(cfg.IssuerURL, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery returned %s", resp.Status)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("invalid OIDC discovery document: %w", err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &OIDCProvider{
+		config: cfg,
+		oauthConfig: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+		},
+		jwksURL:    discovery.JWKSURI,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Name returns the provider name used in login URLs
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the URL to redirect the browser to for login
+func (p *OIDCProvider) AuthCodeURL(state, nonce string) string {
+	return p.oauthConfig.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce))
+}
+
+// Exchange trades an authorization code for a verified ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce string) (*IDTokenClaims, error) {
+	token, err := p.oauthConfig.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("code exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	claims, err := p.VerifyIDToken(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce != "" && claims.Raw["nonce"] != nonce {
+		return nil, fmt.Errorf("ID token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's JWKS
+// and validates issuer, audience and expiry
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken string) (*IDTokenClaims, error) {
+	tok, err := jwt.ParseSigned(rawIDToken, []jose.SignatureAlgorithm{jose.RS256, jose.ES256})
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token: %w", err)
+	}
+	if len(tok.Headers) == 0 {
+		return nil, fmt.Errorf("ID token has no header")
+	}
+
+	key, err := p.signingKey(ctx, tok.Headers[0].KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var std jwt.Claims
+	raw := make(map[string]interface{})
+	if err := tok.Claims(key, &std, &raw); err != nil {
+		return nil, fmt.Errorf("invalid ID token signature: %w", err)
+	}
+
+	expected := jwt.Expected{
+		Issuer:      p.config.IssuerURL,
+		AnyAudience: jwt.Audience{p.config.ClientID},
+	}
+	if err := std.Validate(expected); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	claims := &IDTokenClaims{
+		Subject: std.Subject,
+		Raw:     raw,
+	}
+	claims.Email, _ = raw["email"].(string)
+	claims.EmailVerified, _ = raw["email_verified"].(bool)
+	claims.Name, _ = raw["name"].(string)
+
+	return claims, nil
+}
+
+// MapUser turns verified claims into an account, applying the domain
+// allowlist and the role/team claim mapping
+func (p *OIDCProvider) MapUser(claims *IDTokenClaims) (*User, error) {
+	if len(p.config.AllowedDomains) > 0 {
+		domain := claims.Email[strings.LastIndex(claims.Email, "@")+1:]
+		allowed := false
+		for _, d := range p.config.AllowedDomains {
+			if strings.EqualFold(d, domain) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed || !claims.EmailVerified {
+			return nil, fmt.Errorf("e-mail domain not allowed: %s", domain)
+		}
+	}
+
+	user := &User{
+		ID:     p.config.Name + ":" + claims.Subject,
+		Name:   claims.Name,
+		Email:  claims.Email,
+		Role:   RoleMember,
+		TeamID: p.config.DefaultTeam,
+	}
+
+	if p.config.RoleClaim != "" {
+		for _, value := range claimValues(claims.Raw[p.config.RoleClaim]) {
+			for _, admin := range p.config.AdminValues {
+				if value == admin {
+					user.Role = RoleAdmin
+				}
+			}
+		}
+	}
+
+	if p.config.TeamClaim != "" {
+		for _, value := range claimValues(claims.Raw[p.config.TeamClaim]) {
+			if team, ok := p.config.TeamMapping[value]; ok {
+				user.TeamID = team
+				break
+			}
+		}
+	}
+
+	return user, nil
+}
+
+// signingKey returns the JWKS key for kid, refreshing the key set when the
+// key is unknown (providers rotate keys) but at most once a minute
+func (p *OIDCProvider) signingKey(ctx context.Context, kid string) (*jose.JSONWebKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil {
+		if keys := p.keys.Key(kid); len(keys) > 0 {
+			return &keys[0], nil
+		}
+		if time.Since(p.keysFetch) < time.Minute {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var keySet jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	p.keys = &keySet
+	p.keysFetch = time.Now()
+	log.Printf("[OIDC] Refreshed %d signing keys for %s", len(keySet.Keys), p.config.Name)
+
+	if keys := keySet.Key(kid); len(keys) > 0 {
+		return &keys[0], nil
+	}
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+// claimValues normalizes a string or string-array claim
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
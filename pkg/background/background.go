@@ -0,0 +1,64 @@
+// Package background runs the goroutines of long-lived components, such as
+// flushers, sweepers and sync loops, until their owner stops them. Stopping
+// waits for them, so the clients they use can be closed after.
+package background
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Group is a set of goroutines sharing a context
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewGroup creates a group whose context ends with parent or Stop
+func NewGroup(parent context.Context) *Group {
+	ctx, cancel := context.WithCancel(parent)
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Context returns the context of the group's goroutines
+func (g *Group) Context() context.Context {
+	return g.ctx
+}
+
+// Go runs fn in a goroutine of the group. fn must return once ctx ends.
+func (g *Group) Go(fn func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn(g.ctx)
+	}()
+}
+
+// Every runs fn every interval until the group stops, first after one
+// interval. Runs don't overlap.
+func (g *Group) Every(interval time.Duration, fn func(ctx context.Context)) {
+	g.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// A tick may be ready alongside the end of the group
+				if ctx.Err() != nil {
+					return
+				}
+				fn(ctx)
+			}
+		}
+	})
+}
+
+// Stop ends the group's context and waits for its goroutines to return
+func (g *Group) Stop() {
+	g.cancel()
+	g.wg.Wait()
+}
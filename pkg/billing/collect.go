@@ -0,0 +1,144 @@
+package billing
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"live-video/pkg/background"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/events"
+	"live-video/pkg/jobs"
+	"live-video/pkg/lease"
+	"live-video/pkg/storage"
+	"live-video/pkg/usage"
+)
+
+// sampleInterval is how often live encoding is sampled
+const sampleInterval = time.Minute
+
+// storageInterval is how often the leader lists the bucket for the bytes
+// each tenant stores
+const storageInterval = 15 * time.Minute
+
+// exportTimeout bounds one write to the sink
+const exportTimeout = 2 * time.Minute
+
+// Sources are what a meter is fed from. Each replica meters the streams it
+// encodes, the jobs it runs and the beacons it receives; the leader alone
+// meters the objects in the bucket, shared by all replicas.
+type Sources struct {
+	Bus     *events.Bus
+	Streams *broadcast.BroadcastManager
+	Bucket  *storage.GCSService
+	Elector *lease.Elector
+	Jobs    *jobs.Manager
+}
+
+// Start feeds the meter from sources and writes the periods it closes every
+// interval to sink, in group. Periods the sink refuses are written with the
+// next one.
+func (m *Meter) Start(group *background.Group, sources Sources, sink Sink, interval time.Duration) {
+	sources.Bus.SubscribeLocal(events.QoEBeacon, func(event events.Event) {
+		streamID, _ := event.Data["stream_id"].(string)
+		watched := time.Duration(number(event.Data["watch_ms"])) * time.Millisecond
+		if streamID != "" && watched > 0 {
+			m.Watched(streamID, watched, int(number(event.Data["bitrate_kbps"])))
+		}
+	})
+	sources.Bus.SubscribeLocal(events.JobCompleted, func(event events.Event) {
+		jobID, _ := event.Data["job_id"].(string)
+		job, err := sources.Jobs.Get(jobID)
+		if err != nil || job.Video == nil || job.Video.Duration <= 0 {
+			return
+		}
+		// Uploads convert a single rendition, re-transcodes their ladder
+		renditions := max(len(job.Ladder), 1)
+		m.Encoded(job.VideoID, job.Video.Duration/60*float64(renditions))
+	})
+	sources.Elector.Every("billing-storage", storageInterval, func(ctx context.Context) {
+		if err := m.sampleStorage(ctx, sources.Bucket, storageInterval); err != nil {
+			log.Printf("[Billing] Failed to sample storage: %v", err)
+		}
+	})
+
+	group.Go(func(ctx context.Context) {
+		sample := time.NewTicker(sampleInterval)
+		defer sample.Stop()
+		export := time.NewTicker(interval)
+		defer export.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-sample.C:
+				m.sample(sources, now.Sub(last))
+				last = now
+				if err := m.Flush(); err != nil {
+					log.Printf("[Billing] %v", err)
+				}
+			case now := <-export.C:
+				m.export(sink, now.UTC())
+			}
+		}
+	})
+}
+
+// sample adds the live renditions encoded over elapsed
+func (m *Meter) sample(sources Sources, elapsed time.Duration) {
+	for _, stream := range sources.Streams.ListStreams() {
+		if orch := stream.GetOrchestrator(); orch != nil && orch.IsRunning() {
+			m.Encoded(stream.ID, elapsed.Minutes()*float64(len(orch.Ladder())))
+		}
+	}
+}
+
+// sampleStorage adds the bytes each tenant has in the bucket, listed now,
+// as stored over the last interval
+func (m *Meter) sampleStorage(ctx context.Context, bucket *storage.GCSService, interval time.Duration) error {
+	listing, _ := usage.NewLedger(bucket.Layout(), "") // in memory, can't fail
+	if _, err := listing.Rebuild(ctx, bucket); err != nil {
+		return err
+	}
+	for _, tenant := range listing.Tenants(m.tenantOf) {
+		m.Stored(tenant.Tenant, tenant.Bytes, interval)
+	}
+	return nil
+}
+
+// export closes the current period and writes every pending one to sink
+func (m *Meter) export(sink Sink, now time.Time) {
+	records := m.Close(now)
+	defer func() {
+		if err := m.Flush(); err != nil {
+			log.Printf("[Billing] %v", err)
+		}
+	}()
+	if len(records) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	if err := sink.Write(ctx, records); err != nil {
+		log.Printf("[Billing] Failed to export %d records to %s, retrying with the next period: %v", len(records), sink, err)
+		return
+	}
+	m.Exported(len(records))
+	log.Printf("[Billing] Exported %d records to %s", len(records), sink)
+}
+
+// number returns a numeric event value, an int in process or a float64
+// once relayed as JSON
+func number(value any) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
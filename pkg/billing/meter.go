@@ -0,0 +1,197 @@
+// Package billing meters what each tenant uses of the service (encoding,
+// storage, egress and viewing) and exports it per period to a billing sink,
+// so internal teams can be invoiced
+package billing
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bytesPerGB is the decimal gigabyte usage is reported in
+const bytesPerGB = 1e9
+
+// maxPending is how many records are kept for a sink that keeps failing;
+// the oldest are dropped beyond it
+const maxPending = 100000
+
+// Record is the usage of one tenant over one period on one replica
+type Record struct {
+	Tenant      string    `json:"tenant"` // empty for assets without an owner
+	Replica     string    `json:"replica"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	// EncodeMinutes are rendition minutes encoded: every live rendition
+	// and every VOD rendition converted counts its own minutes
+	EncodeMinutes  float64 `json:"encode_minutes"`
+	StorageGBHours float64 `json:"storage_gb_hours"`
+	EgressGB       float64 `json:"egress_gb"` // delivered to players, from their reported bitrate
+	ViewerMinutes  float64 `json:"viewer_minutes"`
+}
+
+// empty reports whether nothing was used
+func (r *Record) empty() bool {
+	return r.EncodeMinutes == 0 && r.StorageGBHours == 0 && r.EgressGB == 0 && r.ViewerMinutes == 0
+}
+
+// Meter adds up the usage of every tenant over the current period. Closed
+// periods stay pending until they are exported; both are saved to a file so
+// a restart loses at most the last minute.
+type Meter struct {
+	replica  string
+	file     string
+	tenantOf func(assetID string) string
+
+	mu      sync.Mutex
+	start   time.Time
+	current map[string]*Record // by tenant
+	pending []Record           // closed periods not exported yet
+}
+
+// meterFile is the on-disk form of a meter
+type meterFile struct {
+	Start   time.Time          `json:"start"`
+	Current map[string]*Record `json:"current"`
+	Pending []Record           `json:"pending"`
+}
+
+// NewMeter creates the meter of a replica, loading what was saved in file.
+// tenantOf returns the owner of a video or stream.
+func NewMeter(replica, file string, tenantOf func(assetID string) string) (*Meter, error) {
+	m := &Meter{
+		replica:  replica,
+		file:     file,
+		tenantOf: tenantOf,
+		start:    time.Now().UTC(),
+		current:  make(map[string]*Record),
+	}
+	if file == "" {
+		return m, nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read billing meter: %w", err)
+	}
+	var saved meterFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse billing meter: %w", err)
+	}
+	if !saved.Start.IsZero() {
+		m.start = saved.Start
+	}
+	if saved.Current != nil {
+		m.current = saved.Current
+	}
+	m.pending = saved.Pending
+	return m, nil
+}
+
+// record returns the record of a tenant in the current period. Callers hold
+// m.mu.
+func (m *Meter) record(tenant string) *Record {
+	r, ok := m.current[tenant]
+	if !ok {
+		r = &Record{Tenant: tenant}
+		m.current[tenant] = r
+	}
+	return r
+}
+
+// Encoded adds rendition minutes encoded for a video or stream
+func (m *Meter) Encoded(assetID string, minutes float64) {
+	tenant := m.tenantOf(assetID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record(tenant).EncodeMinutes += minutes
+}
+
+// Stored adds bytes a tenant kept in the bucket for a while
+func (m *Meter) Stored(tenant string, bytes int64, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record(tenant).StorageGBHours += float64(bytes) / bytesPerGB * d.Hours()
+}
+
+// Watched adds time a viewer watched a video or stream at a bitrate
+func (m *Meter) Watched(assetID string, watched time.Duration, bitrateKbps int) {
+	tenant := m.tenantOf(assetID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.record(tenant)
+	r.ViewerMinutes += watched.Minutes()
+	r.EgressGB += float64(bitrateKbps) * 1000 / 8 * watched.Seconds() / bytesPerGB
+}
+
+// Current returns the usage of the current period so far, by tenant
+func (m *Meter) Current() []Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.records(time.Now().UTC())
+}
+
+// records returns the current period's records ending at end, sorted by
+// tenant. Callers hold m.mu.
+func (m *Meter) records(end time.Time) []Record {
+	records := make([]Record, 0, len(m.current))
+	for _, r := range m.current {
+		if r.empty() {
+			continue
+		}
+		record := *r
+		record.Replica, record.PeriodStart, record.PeriodEnd = m.replica, m.start, end
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Tenant < records[j].Tenant })
+	return records
+}
+
+// Close ends the current period at now and returns every period not
+// exported yet, oldest first
+func (m *Meter) Close(now time.Time) []Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, m.records(now)...)
+	m.start, m.current = now, make(map[string]*Record)
+	if dropped := len(m.pending) - maxPending; dropped > 0 {
+		log.Printf("[Billing] Dropped %d records the sink didn't take", dropped)
+		m.pending = m.pending[dropped:]
+	}
+	return append([]Record(nil), m.pending...)
+}
+
+// Exported drops the first n pending records, which reached the sink
+func (m *Meter) Exported(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = m.pending[min(n, len(m.pending)):]
+}
+
+// Flush saves the meter to its file
+func (m *Meter) Flush() error {
+	if m.file == "" {
+		return nil
+	}
+	m.mu.Lock()
+	data, err := json.Marshal(meterFile{Start: m.start, Current: m.current, Pending: m.pending})
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode billing meter: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.file), 0o755); err != nil {
+		return fmt.Errorf("failed to create billing meter directory: %w", err)
+	}
+	tmp := m.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write billing meter: %w", err)
+	}
+	return os.Rename(tmp, m.file)
+}
@@ -0,0 +1,144 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-video/pkg/storage"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+// Sink receives the usage records of closed periods
+type Sink interface {
+	// Write stores records; on error all of them are written again later
+	Write(ctx context.Context, records []Record) error
+	String() string
+}
+
+// csvHeader is the header row of CSV exports and the BigQuery columns
+var csvHeader = []string{"tenant", "replica", "period_start", "period_end", "encode_minutes", "storage_gb_hours", "egress_gb", "viewer_minutes"}
+
+// GCSSink writes every export as a CSV object under a bucket prefix,
+// <prefix>/<yyyy>/<mm>/<dd>/<period end>_<replica>.csv
+type GCSSink struct {
+	gcsService *storage.GCSService
+	prefix     string
+}
+
+// NewGCSSink creates a sink writing CSV objects under prefix
+func NewGCSSink(gcsService *storage.GCSService, prefix string) *GCSSink {
+	return &GCSSink{gcsService: gcsService, prefix: strings.Trim(prefix, "/")}
+}
+
+// Write uploads records as one CSV object
+func (s *GCSSink) Write(ctx context.Context, records []Record) error {
+	last := records[len(records)-1]
+	name := path.Join(s.prefix, last.PeriodEnd.Format("2006/01/02"), fmt.Sprintf("%s_%s.csv", last.PeriodEnd.Format("20060102T150405Z"), last.Replica))
+	data, err := CSV(records)
+	if err != nil {
+		return err
+	}
+	return s.gcsService.UploadBytes(ctx, data, name, "text/csv")
+}
+
+func (s *GCSSink) String() string {
+	return "CSV objects under " + s.prefix + "/"
+}
+
+// CSV encodes records with a header row
+func CSV(records []Record) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(csvHeader)
+	for _, r := range records {
+		w.Write([]string{
+			r.Tenant,
+			r.Replica,
+			r.PeriodStart.Format(time.RFC3339),
+			r.PeriodEnd.Format(time.RFC3339),
+			formatFloat(r.EncodeMinutes),
+			formatFloat(r.StorageGBHours),
+			formatFloat(r.EgressGB),
+			formatFloat(r.ViewerMinutes),
+		})
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 6, 64)
+}
+
+// BigQuerySink streams records into a BigQuery table with the columns of
+// the CSV export: tenant, replica STRING; period_start, period_end
+// TIMESTAMP; the usage columns FLOAT64
+type BigQuerySink struct {
+	service                 *bigquery.Service
+	project, dataset, table string
+}
+
+// NewBigQuerySink creates a sink inserting into table, given as
+// project.dataset.table. credentialsFile may be empty for application
+// default credentials.
+func NewBigQuerySink(ctx context.Context, table, credentialsFile string) (*BigQuerySink, error) {
+	parts := strings.Split(table, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid BigQuery table %q, use project.dataset.table", table)
+	}
+	var opts []option.ClientOption
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+	service, err := bigquery.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %w", err)
+	}
+	return &BigQuerySink{service: service, project: parts[0], dataset: parts[1], table: parts[2]}, nil
+}
+
+// Write inserts records. Insert IDs let BigQuery drop rows written again
+// after a failure that did reach it.
+func (s *BigQuerySink) Write(ctx context.Context, records []Record) error {
+	req := &bigquery.TableDataInsertAllRequest{}
+	for _, r := range records {
+		req.Rows = append(req.Rows, &bigquery.TableDataInsertAllRequestRows{
+			InsertId: fmt.Sprintf("%s|%s|%d", r.Replica, r.Tenant, r.PeriodEnd.Unix()),
+			Json: map[string]bigquery.JsonValue{
+				"tenant":           r.Tenant,
+				"replica":          r.Replica,
+				"period_start":     r.PeriodStart.Format(time.RFC3339),
+				"period_end":       r.PeriodEnd.Format(time.RFC3339),
+				"encode_minutes":   r.EncodeMinutes,
+				"storage_gb_hours": r.StorageGBHours,
+				"egress_gb":        r.EgressGB,
+				"viewer_minutes":   r.ViewerMinutes,
+			},
+		})
+	}
+	resp, err := s.service.Tabledata.InsertAll(s.project, s.dataset, s.table, req).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to insert rows: %w", err)
+	}
+	if len(resp.InsertErrors) > 0 {
+		first := resp.InsertErrors[0]
+		message := "unknown error"
+		if len(first.Errors) > 0 {
+			message = first.Errors[0].Message
+		}
+		return fmt.Errorf("%d rows were rejected, row %d: %s", len(resp.InsertErrors), first.Index, message)
+	}
+	return nil
+}
+
+func (s *BigQuerySink) String() string {
+	return fmt.Sprintf("BigQuery table %s.%s.%s", s.project, s.dataset, s.table)
+}
@@ -0,0 +1,69 @@
+package broadcast
+
+import (
+	"live-video/config"
+	"live-video/pkg/m3u8"
+)
+
+// SetAudioInputs sets the extra audio inputs of the stream, validated with
+// config.ValidateAudioInputs. They apply when the stream's pipeline next
+// starts.
+func (s *Stream) SetAudioInputs(inputs []config.AudioInput) {
+	s.mu.Lock()
+	if len(inputs) == 0 {
+		inputs = nil
+	}
+	s.audioInputs = inputs
+	s.mu.Unlock()
+	s.changed(s)
+}
+
+// AudioInputs returns the stream's extra audio inputs
+func (s *Stream) AudioInputs() []config.AudioInput {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.audioInputs
+}
+
+// alternateAudio returns the alternate audio inputs the running pipeline
+// produces renditions for
+func (s *Stream) alternateAudio() []config.AudioInput {
+	orch := s.GetOrchestrator()
+	if orch == nil || !orch.IsRunning() {
+		return nil
+	}
+	var alternates []config.AudioInput
+	for _, input := range orch.AudioInputs() {
+		if input.Mode == config.AudioModeAlternate {
+			alternates = append(alternates, input)
+		}
+	}
+	return alternates
+}
+
+// audioGroup returns the audio group of a source with alternate audio: the
+// program audio carried in its variants, then each alternate rendition
+func audioGroup(group string, source PlaybackSource, variantURL func(streamID, rendition string) string) []m3u8.Tag {
+	tags := []m3u8.Tag{m3u8.NewTag(m3u8.TagMedia, m3u8.Attributes{
+		{Key: "TYPE", Value: "AUDIO"},
+		{Key: "GROUP-ID", Value: m3u8.Quote(group)},
+		{Key: "NAME", Value: m3u8.Quote("Main")},
+		{Key: "DEFAULT", Value: "YES"},
+		{Key: "AUTOSELECT", Value: "YES"},
+	})}
+	for _, input := range source.AlternateAudio {
+		attrs := m3u8.Attributes{
+			{Key: "TYPE", Value: "AUDIO"},
+			{Key: "GROUP-ID", Value: m3u8.Quote(group)},
+			{Key: "NAME", Value: m3u8.Quote(input.Label)},
+		}
+		if input.Language != "" {
+			attrs.Set("LANGUAGE", m3u8.Quote(input.Language))
+		}
+		attrs.Set("DEFAULT", "NO")
+		attrs.Set("AUTOSELECT", "YES")
+		attrs.Set("URI", m3u8.Quote(variantURL(source.StreamID, input.Rendition())))
+		tags = append(tags, m3u8.NewTag(m3u8.TagMedia, attrs))
+	}
+	return tags
+}
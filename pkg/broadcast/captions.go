@@ -0,0 +1,65 @@
+package broadcast
+
+import (
+	"slices"
+
+	"live-video/config"
+	"live-video/pkg/captions"
+	"live-video/pkg/m3u8"
+)
+
+// SetCaptions sets the live caption settings of the stream, validated with
+// config.ValidateCaptions. They apply when the stream's pipeline next
+// starts.
+func (s *Stream) SetCaptions(settings config.CaptionSettings) {
+	s.mu.Lock()
+	s.captions = settings
+	s.mu.Unlock()
+	s.changed(s)
+}
+
+// Captions returns the stream's live caption settings
+func (s *Stream) Captions() config.CaptionSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.captions
+}
+
+// captionsSnapshot returns the caption settings of a stream with captions
+// on. Callers hold s.mu.
+func (s *Stream) captionsSnapshot() *config.CaptionSettings {
+	if !s.captions.Enabled {
+		return nil
+	}
+	settings := s.captions
+	settings.Languages = slices.Clone(settings.Languages)
+	return &settings
+}
+
+// captionTracks returns the caption tracks the running pipeline publishes
+func (s *Stream) captionTracks() []captions.Track {
+	orch := s.GetOrchestrator()
+	if orch == nil || !orch.IsRunning() {
+		return nil
+	}
+	return orch.CaptionTracks()
+}
+
+// subtitleGroup returns the subtitle group of a source with caption tracks,
+// none of them selected by default
+func subtitleGroup(group string, source PlaybackSource, variantURL func(streamID, rendition string) string) []m3u8.Tag {
+	tags := make([]m3u8.Tag, 0, len(source.Captions))
+	for _, track := range source.Captions {
+		tags = append(tags, m3u8.NewTag(m3u8.TagMedia, m3u8.Attributes{
+			{Key: "TYPE", Value: "SUBTITLES"},
+			{Key: "GROUP-ID", Value: m3u8.Quote(group)},
+			{Key: "NAME", Value: m3u8.Quote(track.Name())},
+			{Key: "LANGUAGE", Value: m3u8.Quote(track.Language)},
+			{Key: "DEFAULT", Value: "NO"},
+			{Key: "AUTOSELECT", Value: "YES"},
+			{Key: "FORCED", Value: "NO"},
+			{Key: "URI", Value: m3u8.Quote(variantURL(source.StreamID, track.Rendition()))},
+		}))
+	}
+	return tags
+}
@@ -0,0 +1,164 @@
+package broadcast
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+)
+
+// Limits of chunk uploads
+const (
+	MaxChunkSize     = 4 << 20 // bytes
+	ChunkContentType = "video/webm"
+	maxChunksAhead   = 8  // out-of-order chunks held until the gap before them is filled
+	maxChunkSession  = 64 // length of a session nonce
+	retiredSessions  = 32 // ended sessions remembered so their chunks can't be replayed
+)
+
+// ebmlMagic starts every WebM file, so the first chunk of a session
+var ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// Outcomes of an accepted chunk
+const (
+	ChunkDelivered = "delivered" // sent to viewers, with any chunks it unblocked
+	ChunkBuffered  = "buffered"  // held until the chunks before it arrive
+	ChunkDuplicate = "duplicate" // already received, dropped
+)
+
+// Errors of rejected chunks
+var (
+	ErrChunkInvalid = errors.New("invalid chunk")
+	ErrChunkSession = errors.New("unknown chunk session")
+	ErrChunkGap     = errors.New("chunk too far ahead")
+)
+
+// ChunkResult is what happened to an uploaded chunk
+type ChunkResult struct {
+	Status       string `json:"status"`
+	NextSequence uint64 `json:"next_sequence"` // next chunk viewers are waiting for
+}
+
+// chunkSequencer orders the chunks of the current publisher session. A
+// session is a nonce the publisher picks when it starts uploading at
+// sequence 0; a new session replaces the current one, and chunks of ended
+// sessions are rejected.
+type chunkSequencer struct {
+	session string
+	next    uint64
+	pending map[uint64][]byte
+	retired []string
+	stats   ChunkStats
+}
+
+// ChunkStats counts the chunk uploads of a stream
+type ChunkStats struct {
+	Received   uint64 `json:"received"`
+	Delivered  uint64 `json:"delivered"`
+	Duplicates uint64 `json:"duplicates"`
+	OutOfOrder uint64 `json:"out_of_order"`
+	Rejected   uint64 `json:"rejected"`
+	Bytes      uint64 `json:"bytes"`
+	Sessions   uint64 `json:"sessions"`
+}
+
+// AcceptChunk validates an uploaded chunk and delivers it to viewers in
+// sequence order. Chunks ahead of a missing one are held, up to
+// maxChunksAhead of them.
+func (s *Stream) AcceptChunk(session string, sequence uint64, contentType string, data []byte) (ChunkResult, error) {
+	s.chunkMu.Lock()
+	defer s.chunkMu.Unlock()
+
+	q := &s.chunks
+	q.stats.Received++
+	result, err := q.accept(session, sequence, contentType, data)
+	if err != nil {
+		q.stats.Rejected++
+		return ChunkResult{NextSequence: q.next}, err
+	}
+	if result == ChunkBuffered {
+		q.stats.OutOfOrder++
+	}
+	if result == ChunkDuplicate {
+		q.stats.Duplicates++
+		return ChunkResult{Status: result, NextSequence: q.next}, nil
+	}
+	q.stats.Bytes += uint64(len(data))
+
+	// Deliver everything that is now in order
+	for {
+		chunk, ok := q.pending[q.next]
+		if !ok {
+			break
+		}
+		delete(q.pending, q.next)
+		q.next++
+		q.stats.Delivered++
+		s.Broadcast(chunkMessage(chunk))
+	}
+	return ChunkResult{Status: result, NextSequence: q.next}, nil
+}
+
+// accept checks a chunk and queues it for delivery
+func (q *chunkSequencer) accept(session string, sequence uint64, contentType string, data []byte) (string, error) {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != ChunkContentType {
+		return "", fmt.Errorf("%w: content type must be %s", ErrChunkInvalid, ChunkContentType)
+	}
+	if len(data) == 0 || len(data) > MaxChunkSize {
+		return "", fmt.Errorf("%w: size must be between 1 and %d bytes", ErrChunkInvalid, MaxChunkSize)
+	}
+	if session == "" || len(session) > maxChunkSession {
+		return "", fmt.Errorf("%w: session must be 1 to %d characters", ErrChunkInvalid, maxChunkSession)
+	}
+
+	if session != q.session {
+		for _, retired := range q.retired {
+			if retired == session {
+				return "", fmt.Errorf("%w: session has ended", ErrChunkSession)
+			}
+		}
+		if sequence != 0 {
+			return "", fmt.Errorf("%w: a new session starts at sequence 0", ErrChunkSession)
+		}
+		if !bytes.HasPrefix(data, ebmlMagic) {
+			return "", fmt.Errorf("%w: the first chunk must start a WebM stream", ErrChunkInvalid)
+		}
+		if q.session != "" {
+			q.retired = append(q.retired, q.session)
+			if len(q.retired) > retiredSessions {
+				q.retired = q.retired[1:]
+			}
+		}
+		q.session, q.next, q.pending = session, 0, nil
+		q.stats.Sessions++
+	}
+
+	_, held := q.pending[sequence]
+	switch {
+	case sequence < q.next || held:
+		return ChunkDuplicate, nil
+	case sequence-q.next > maxChunksAhead:
+		return "", fmt.Errorf("%w: waiting for chunk %d", ErrChunkGap, q.next)
+	}
+	if q.pending == nil {
+		q.pending = make(map[uint64][]byte)
+	}
+	q.pending[sequence] = data
+	if sequence > q.next {
+		return ChunkBuffered, nil
+	}
+	return ChunkDelivered, nil
+}
+
+// chunkMessage is the viewer event carrying a chunk
+func chunkMessage(data []byte) []byte {
+	return []byte(fmt.Sprintf(`{"type":"chunk","data":"%s"}`, base64.StdEncoding.EncodeToString(data)))
+}
+
+// ChunkStats returns the chunk upload counters of the stream
+func (s *Stream) ChunkStats() ChunkStats {
+	s.chunkMu.Lock()
+	defer s.chunkMu.Unlock()
+	return s.chunks.stats
+}
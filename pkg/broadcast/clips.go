@@ -0,0 +1,55 @@
+package broadcast
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaxClipDuration caps the length of a clip exported from the DVR window
+const MaxClipDuration = 5 * time.Minute
+
+// SetClipPolicy sets which viewer classes can export clips of the stream and
+// how long their clips can be, by class name. Classes without an entry can't
+// clip; managers of the stream always can. An empty map turns viewer clips
+// off.
+func (s *Stream) SetClipPolicy(limits map[string]time.Duration) error {
+	copied := make(map[string]time.Duration, len(limits))
+	for class, limit := range limits {
+		if class == "" {
+			return fmt.Errorf("viewer class must not be empty")
+		}
+		if limit <= 0 || limit > MaxClipDuration {
+			return fmt.Errorf("clip length of %s must be between 0 and %s", class, MaxClipDuration)
+		}
+		copied[class] = limit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(copied) == 0 {
+		copied = nil
+	}
+	s.clipLimits = copied
+	return nil
+}
+
+// ClipLimit returns the longest clip a viewer class can export, and false
+// when the class can't clip
+func (s *Stream) ClipLimit(class string) (time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	limit, ok := s.clipLimits[class]
+	return limit, ok
+}
+
+// clipPolicyStats describes the clip limits in seconds. Callers hold s.mu.
+func (s *Stream) clipPolicyStats() map[string]float64 {
+	if len(s.clipLimits) == 0 {
+		return nil
+	}
+	stats := make(map[string]float64, len(s.clipLimits))
+	for class, limit := range s.clipLimits {
+		stats[class] = limit.Seconds()
+	}
+	return stats
+}
@@ -0,0 +1,22 @@
+package broadcast
+
+import "live-video/config"
+
+// SetContentType sets what the stream shows, validated with
+// config.ValidateContentType. It applies from the next pipeline start on.
+func (s *Stream) SetContentType(contentType string) {
+	s.mu.Lock()
+	s.contentType = contentType
+	s.mu.Unlock()
+	s.changed(s)
+}
+
+// ContentType returns what the stream shows, camera or screen
+func (s *Stream) ContentType() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.contentType == "" {
+		return config.ContentCamera
+	}
+	return s.contentType
+}
@@ -0,0 +1,121 @@
+package broadcast
+
+import (
+	"fmt"
+	"time"
+)
+
+// Encoder telemetry older than encoderStatsTTL no longer affects health
+const encoderStatsTTL = 30 * time.Second
+
+// degradedDropRate is the share of frames an encoder may drop before its
+// stream is degraded
+const degradedDropRate = 0.02
+
+// hotEncoderC is the temperature above which an encoder degrades its stream,
+// as hardware encoders start throttling or dropping frames
+const hotEncoderC = 80.0
+
+// maxEncoderCustomMetrics caps the custom metrics of one push
+const maxEncoderCustomMetrics = 32
+
+// EncoderStats is the telemetry a hardware encoder pushes. Frame counters
+// count since the encoder started; they may reset when it restarts.
+type EncoderStats struct {
+	Encoder       string             `json:"encoder,omitempty"` // model and firmware
+	BitrateKbps   int                `json:"bitrate_kbps,omitempty"`
+	FPS           float64            `json:"fps,omitempty"`
+	DroppedFrames int64              `json:"dropped_frames"`
+	TotalFrames   int64              `json:"total_frames,omitempty"`
+	TemperatureC  *float64           `json:"temperature_c,omitempty"`
+	Custom        map[string]float64 `json:"custom,omitempty"`
+}
+
+// Validate checks the telemetry an encoder pushed
+func (e *EncoderStats) Validate() error {
+	if e.BitrateKbps < 0 || e.FPS < 0 || e.DroppedFrames < 0 || e.TotalFrames < 0 {
+		return fmt.Errorf("encoder stats must not be negative")
+	}
+	if len(e.Custom) > maxEncoderCustomMetrics {
+		return fmt.Errorf("at most %d custom metrics", maxEncoderCustomMetrics)
+	}
+	return nil
+}
+
+// EncoderTelemetry is the latest telemetry of a stream's encoder
+type EncoderTelemetry struct {
+	EncoderStats
+	ReceivedAt time.Time `json:"received_at"`
+	// DropRate is the share of frames dropped since the previous push
+	DropRate float64 `json:"drop_rate"`
+}
+
+// ReportEncoderStats records the telemetry the stream's encoder pushed and
+// returns it with the drop rate since its previous push
+func (s *Stream) ReportEncoderStats(stats EncoderStats) EncoderTelemetry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	telemetry := EncoderTelemetry{EncoderStats: stats, ReceivedAt: now}
+	if prev := s.encoder; prev != nil {
+		dropped := stats.DroppedFrames - prev.DroppedFrames
+		if dropped < 0 {
+			dropped = stats.DroppedFrames // the encoder restarted
+		}
+		var frames float64
+		switch {
+		case stats.TotalFrames > prev.TotalFrames:
+			frames = float64(stats.TotalFrames - prev.TotalFrames)
+		case stats.TotalFrames > 0 && stats.TotalFrames < prev.TotalFrames:
+			frames = float64(stats.TotalFrames)
+		case stats.FPS > 0:
+			frames = stats.FPS * now.Sub(prev.ReceivedAt).Seconds()
+		}
+		if frames > 0 {
+			telemetry.DropRate = min(float64(dropped)/frames, 1)
+		}
+	}
+	s.encoder = &telemetry
+	return telemetry
+}
+
+// EncoderTelemetry returns the latest telemetry of the stream's encoder, nil
+// if it pushed none
+func (s *Stream) EncoderTelemetry() *EncoderTelemetry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.encoder.copy()
+}
+
+func (t *EncoderTelemetry) copy() *EncoderTelemetry {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+// encoderHealth degrades health by what the encoder reported lately.
+// Callers hold s.mu.
+func (s *Stream) encoderHealth(health *StreamHealth) {
+	t := s.encoder
+	if t == nil || time.Since(t.ReceivedAt) > encoderStatsTTL {
+		return
+	}
+	if health.IngestKbps == 0 {
+		health.IngestKbps = t.BitrateKbps
+	}
+	health.EncoderDropRate = t.DropRate
+	if health.Health != HealthHealthy {
+		return
+	}
+	switch {
+	case t.DropRate >= degradedDropRate:
+		health.Health = HealthDegraded
+		health.Reason = fmt.Sprintf("encoder dropping %.0f%% of frames", t.DropRate*100)
+	case t.TemperatureC != nil && *t.TemperatureC >= hotEncoderC:
+		health.Health = HealthDegraded
+		health.Reason = fmt.Sprintf("encoder at %.0f°C", *t.TemperatureC)
+	}
+}
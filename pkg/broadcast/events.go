@@ -0,0 +1,474 @@
+package broadcast
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxProvisionStreams caps how many streams a single provisioning call creates
+const MaxProvisionStreams = 200
+
+// StreamPreset is a template for provisioning streams in bulk
+type StreamPreset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	NamePrefix  string `json:"name_prefix"`
+	VideoURL    string `json:"video_url"`
+}
+
+// streamPresets are the built-in provisioning templates
+var streamPresets = map[string]StreamPreset{
+	"breakout": {
+		Name:        "breakout",
+		Description: "Browser (WebRTC) rooms for breakout sessions",
+		NamePrefix:  "Room",
+		VideoURL:    "webrtc://live",
+	},
+	"stage": {
+		Name:        "stage",
+		Description: "Main stage feeds broadcast from the browser",
+		NamePrefix:  "Stage",
+		VideoURL:    "webrtc://live",
+	},
+	"vod-loop": {
+		Name:        "vod-loop",
+		Description: "Looping playback of an uploaded video (requires video_url/hls_playlist_url)",
+		NamePrefix:  "Channel",
+	},
+}
+
+// Presets returns the built-in stream presets sorted by name
+func Presets() []StreamPreset {
+	presets := make([]StreamPreset, 0, len(streamPresets))
+	for _, preset := range streamPresets {
+		presets = append(presets, preset)
+	}
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+	return presets
+}
+
+// GetPreset returns a built-in stream preset by name
+func GetPreset(name string) (StreamPreset, bool) {
+	preset, ok := streamPresets[name]
+	return preset, ok
+}
+
+// Event groups the streams of one occasion, e.g. the breakout rooms of a conference
+type Event struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Preset    string    `json:"preset,omitempty"`
+	StreamIDs []string  `json:"stream_ids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ProvisionRequest describes a bulk provisioning of streams for an event
+type ProvisionRequest struct {
+	EventName      string
+	Preset         StreamPreset
+	Count          int
+	NamePrefix     string
+	VideoURL       string
+	HLSPlaylistURL string
+	GCSPath        string
+	VideoDuration  float64
+}
+
+// ProvisionEvent creates an event and Count streams from a preset in one step
+func (bm *BroadcastManager) ProvisionEvent(req ProvisionRequest) (*Event, []*Stream, error) {
+	if req.Count < 1 || req.Count > MaxProvisionStreams {
+		return nil, nil, fmt.Errorf("count must be between 1 and %d", MaxProvisionStreams)
+	}
+
+	videoURL := req.Preset.VideoURL
+	if req.VideoURL != "" {
+		videoURL = req.VideoURL
+	}
+	if videoURL == "" && req.HLSPlaylistURL == "" {
+		return nil, nil, fmt.Errorf("preset %s requires video_url or hls_playlist_url", req.Preset.Name)
+	}
+	if videoURL == "" {
+		videoURL = req.HLSPlaylistURL
+	}
+
+	prefix := req.Preset.NamePrefix
+	if req.NamePrefix != "" {
+		prefix = req.NamePrefix
+	}
+
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	event := &Event{
+		ID:        uuid.New().String(),
+		Name:      req.EventName,
+		Preset:    req.Preset.Name,
+		StreamIDs: make([]string, 0, req.Count),
+		CreatedAt: time.Now(),
+	}
+
+	streams := make([]*Stream, 0, req.Count)
+	for i := 1; i <= req.Count; i++ {
+		stream := bm.newStream(uuid.New().String(), videoURL, req.HLSPlaylistURL, req.GCSPath)
+		stream.Name = fmt.Sprintf("%s %d", prefix, i)
+		stream.EventID = event.ID
+		stream.VideoDuration = req.VideoDuration
+
+		bm.streams[stream.ID] = stream
+		event.StreamIDs = append(event.StreamIDs, stream.ID)
+		streams = append(streams, stream)
+	}
+
+	bm.events[event.ID] = event
+	return event.copy(), streams, nil
+}
+
+// CreateEvent creates an event grouping existing streams. Streams that
+// belong to another event are moved.
+func (bm *BroadcastManager) CreateEvent(name string, streamIDs []string) (*Event, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	for _, streamID := range streamIDs {
+		if _, exists := bm.streams[streamID]; !exists {
+			return nil, fmt.Errorf("stream not found: %s", streamID)
+		}
+	}
+
+	event := &Event{
+		ID:        uuid.New().String(),
+		Name:      name,
+		StreamIDs: []string{},
+		CreatedAt: time.Now(),
+	}
+	bm.events[event.ID] = event
+	for _, streamID := range streamIDs {
+		bm.attachStream(event, bm.streams[streamID])
+	}
+	return event.copy(), nil
+}
+
+// AddEventStreams adds existing streams to an event
+func (bm *BroadcastManager) AddEventStreams(eventID string, streamIDs []string) (*Event, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	event, exists := bm.events[eventID]
+	if !exists {
+		return nil, fmt.Errorf("event not found: %s", eventID)
+	}
+	for _, streamID := range streamIDs {
+		if _, exists := bm.streams[streamID]; !exists {
+			return nil, fmt.Errorf("stream not found: %s", streamID)
+		}
+	}
+	for _, streamID := range streamIDs {
+		bm.attachStream(event, bm.streams[streamID])
+	}
+	return event.copy(), nil
+}
+
+// RemoveEventStream takes a stream out of an event without deleting it
+func (bm *BroadcastManager) RemoveEventStream(eventID, streamID string) (*Event, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	event, exists := bm.events[eventID]
+	if !exists {
+		return nil, fmt.Errorf("event not found: %s", eventID)
+	}
+	if !event.removeStream(streamID) {
+		return nil, fmt.Errorf("stream %s is not part of event %s", streamID, eventID)
+	}
+	if stream, ok := bm.streams[streamID]; ok {
+		stream.setEventID("")
+	}
+	return event.copy(), nil
+}
+
+// DeleteEvent removes an event. Its member streams are kept.
+func (bm *BroadcastManager) DeleteEvent(eventID string) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	event, exists := bm.events[eventID]
+	if !exists {
+		return fmt.Errorf("event not found: %s", eventID)
+	}
+	for _, streamID := range event.StreamIDs {
+		if stream, ok := bm.streams[streamID]; ok {
+			stream.setEventID("")
+		}
+	}
+	delete(bm.events, eventID)
+	return nil
+}
+
+// attachStream moves a stream into event. Callers hold bm.mu.
+func (bm *BroadcastManager) attachStream(event *Event, stream *Stream) {
+	stream.mu.RLock()
+	previous := stream.EventID
+	stream.mu.RUnlock()
+
+	if previous == event.ID {
+		return
+	}
+	if old, ok := bm.events[previous]; ok {
+		old.removeStream(stream.ID)
+	}
+	stream.setEventID(event.ID)
+	event.StreamIDs = append(event.StreamIDs, stream.ID)
+}
+
+// GetEvent returns a snapshot of an event
+func (bm *BroadcastManager) GetEvent(eventID string) (*Event, error) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	event, exists := bm.events[eventID]
+	if !exists {
+		return nil, fmt.Errorf("event not found: %s", eventID)
+	}
+	return event.copy(), nil
+}
+
+// ListEvents returns snapshots of all events
+func (bm *BroadcastManager) ListEvents() []*Event {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	events := make([]*Event, 0, len(bm.events))
+	for _, event := range bm.events {
+		events = append(events, event.copy())
+	}
+	return events
+}
+
+// EventStreams returns the streams of an event that still exist
+func (bm *BroadcastManager) EventStreams(eventID string) ([]*Stream, error) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	event, exists := bm.events[eventID]
+	if !exists {
+		return nil, fmt.Errorf("event not found: %s", eventID)
+	}
+
+	streams := make([]*Stream, 0, len(event.StreamIDs))
+	for _, streamID := range event.StreamIDs {
+		if stream, ok := bm.streams[streamID]; ok {
+			streams = append(streams, stream)
+		}
+	}
+	return streams, nil
+}
+
+// Per-room health states reported on event dashboards
+const (
+	HealthOffline  = "offline"  // not streaming
+	HealthHealthy  = "healthy"  // streaming and receiving media
+	HealthDegraded = "degraded" // streaming with heavy packet loss, or a struggling encoder
+	HealthStalled  = "stalled"  // streaming but no media or pipeline stopped
+)
+
+// ingestStallTimeout is how long a live WebRTC stream may go without media
+// before it is reported stalled
+const ingestStallTimeout = 5 * time.Second
+
+// degradedLossRate is the packet loss above which a room is degraded
+const degradedLossRate = 0.1
+
+// StreamHealth summarizes whether a stream is delivering media
+type StreamHealth struct {
+	StreamID    string       `json:"stream_id"`
+	Name        string       `json:"name,omitempty"`
+	Status      StreamStatus `json:"status"`
+	Health      string       `json:"health"`
+	Reason      string       `json:"reason,omitempty"`
+	ViewerCount int          `json:"viewer_count"`
+	IngestKbps  int          `json:"ingest_kbps,omitempty"`
+	LossRate    float64      `json:"loss_rate,omitempty"`
+
+	EncoderDropRate float64 `json:"encoder_drop_rate,omitempty"` // from encoder telemetry
+}
+
+// Health reports the stream's delivery health
+func (s *Stream) Health() StreamHealth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	health := StreamHealth{
+		StreamID:    s.ID,
+		Name:        s.Name,
+		Status:      s.Status,
+		Health:      HealthHealthy,
+		ViewerCount: s.ViewerCount,
+	}
+
+	if s.Status != StatusStreaming {
+		health.Health = HealthOffline
+		return health
+	}
+
+	if s.orchestrator != nil && !s.orchestrator.IsRunning() {
+		health.Health = HealthStalled
+		health.Reason = "transcoding pipeline stopped"
+		return health
+	}
+
+	// Browser broadcasts must keep sending media
+	if strings.HasPrefix(s.VideoURL, "webrtc://") {
+		if s.webrtcIngest == nil {
+			health.Health = HealthStalled
+			health.Reason = "no broadcaster connected"
+			return health
+		}
+		bitrate := s.webrtcIngest.GetBitrateStats()
+		health.IngestKbps = bitrate.ReceiveKbps
+		health.LossRate = bitrate.LossRate
+		switch {
+		case bitrate.UpdatedAt.IsZero() || time.Since(bitrate.UpdatedAt) > ingestStallTimeout:
+			health.Health = HealthStalled
+			health.Reason = "no media received"
+		case bitrate.LossRate >= degradedLossRate:
+			health.Health = HealthDegraded
+			health.Reason = fmt.Sprintf("%.0f%% packet loss", bitrate.LossRate*100)
+		}
+	}
+
+	// Hardware encoders report trouble the server can't see
+	s.encoderHealth(&health)
+	return health
+}
+
+// EventStats aggregates the member streams of an event: total concurrent
+// viewers across rooms and a health entry per room
+func (bm *BroadcastManager) EventStats(eventID string) (map[string]interface{}, error) {
+	streams, err := bm.EventStreams(eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	totalViewers := 0
+	streaming := 0
+	healthCounts := map[string]int{
+		HealthHealthy:  0,
+		HealthDegraded: 0,
+		HealthStalled:  0,
+		HealthOffline:  0,
+	}
+	rooms := make([]StreamHealth, 0, len(streams))
+	for _, stream := range streams {
+		health := stream.Health()
+		totalViewers += health.ViewerCount
+		if health.Status == StatusStreaming {
+			streaming++
+		}
+		healthCounts[health.Health]++
+		rooms = append(rooms, health)
+	}
+
+	return map[string]interface{}{
+		"stream_count":    len(streams),
+		"streaming_count": streaming,
+		"total_viewers":   totalViewers,
+		"health":          healthCounts,
+		"rooms":           rooms,
+	}, nil
+}
+
+// EventActionResult is the outcome of a cascaded start/stop for one stream
+type EventActionResult struct {
+	StreamID string `json:"stream_id"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+}
+
+// StartEvent starts every member stream that is not already started, as
+// far as the live stream limits allow
+func (bm *BroadcastManager) StartEvent(eventID string) ([]EventActionResult, error) {
+	return bm.cascade(eventID, func(stream *Stream) error {
+		if stream.isActive() {
+			return nil
+		}
+		return bm.StartStream(stream)
+	})
+}
+
+// StopEvent stops every member stream that is streaming or paused
+func (bm *BroadcastManager) StopEvent(eventID string) ([]EventActionResult, error) {
+	return bm.cascade(eventID, func(stream *Stream) error {
+		if !stream.isActive() {
+			return nil
+		}
+		return stream.Stop()
+	})
+}
+
+func (bm *BroadcastManager) cascade(eventID string, action func(stream *Stream) error) ([]EventActionResult, error) {
+	streams, err := bm.EventStreams(eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]EventActionResult, 0, len(streams))
+	for _, stream := range streams {
+		result := EventActionResult{StreamID: stream.ID, Success: true}
+		if err := action(stream); err != nil {
+			result.Success = false
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// isActive reports whether the stream is started, streaming or paused
+func (s *Stream) isActive() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Status.Active()
+}
+
+func (s *Stream) setEventID(eventID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.EventID = eventID
+}
+
+// removeStream drops a stream from the event's member list
+func (e *Event) removeStream(streamID string) bool {
+	for i, id := range e.StreamIDs {
+		if id == streamID {
+			e.StreamIDs = append(e.StreamIDs[:i], e.StreamIDs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Event) copy() *Event {
+	c := *e
+	c.StreamIDs = append([]string(nil), e.StreamIDs...)
+	return &c
+}
+
+// ValidStreamKey reports whether key is the stream's ingest key
+func (s *Stream) ValidStreamKey(key string) bool {
+	return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.StreamKey)) == 1
+}
+
+// newStreamKey returns a random secret that lets a broadcaster push to one
+// stream without an account
+func newStreamKey() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
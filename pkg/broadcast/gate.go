@@ -0,0 +1,54 @@
+package broadcast
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Content gate limits
+const (
+	MaxGateRatingLength = 64
+	MaxGateAge          = 21
+)
+
+// ContentGate asks viewers to confirm before they play a stream: that they
+// are old enough, or that they accept its content
+type ContentGate struct {
+	Rating  string `json:"rating,omitempty"`  // shown to viewers, e.g. "18+" or "Graphic content"
+	MinAge  int    `json:"min_age,omitempty"` // viewers confirm they are at least this old
+	Consent bool   `json:"consent,omitempty"` // viewers acknowledge the rating, at any age
+}
+
+// Gated reports whether viewers must confirm before they play
+func (g ContentGate) Gated() bool {
+	return g.MinAge > 0 || g.Consent
+}
+
+// Validate cleans the rating and checks the gate's limits
+func (g *ContentGate) Validate() error {
+	g.Rating = strings.TrimSpace(g.Rating)
+	if utf8.RuneCountInString(g.Rating) > MaxGateRatingLength {
+		return fmt.Errorf("rating is longer than %d characters", MaxGateRatingLength)
+	}
+	if g.MinAge < 0 || g.MinAge > MaxGateAge {
+		return fmt.Errorf("min_age must be between 0 and %d", MaxGateAge)
+	}
+	return nil
+}
+
+// SetContentGate sets the gate viewers pass before they play the stream,
+// validated with ContentGate.Validate. The zero gate removes it.
+func (s *Stream) SetContentGate(gate ContentGate) {
+	s.mu.Lock()
+	s.gate = gate
+	s.mu.Unlock()
+	s.changed(s)
+}
+
+// ContentGate returns the stream's content gate
+func (s *Stream) ContentGate() ContentGate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gate
+}
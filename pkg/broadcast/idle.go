@@ -0,0 +1,120 @@
+package broadcast
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"live-video/pkg/background"
+	"live-video/pkg/events"
+)
+
+// Reasons a stream was stopped automatically
+const (
+	StopReasonIdle    = "idle"     // no input and no viewers
+	StopReasonNoInput = "no_input" // the ingest pipeline ran without input
+)
+
+// IdlePolicy decides when abandoned streams are stopped. A zero timeout
+// disables that check.
+type IdlePolicy struct {
+	IdleTimeout  time.Duration // no input and no viewers for this long
+	InputTimeout time.Duration // transcoding pipeline running without input for this long, viewers or not
+}
+
+// StartIdleMonitor checks in group, periodically, for streams that are live
+// but abandoned under policy and stops them: the stream is stopped, which shuts down its transcoding
+// pipeline and WebRTC ingest and removes its local files. Every stream stopped
+// this way is published as an events.StreamAutoStopped event.
+func (bm *BroadcastManager) StartIdleMonitor(group *background.Group, policy IdlePolicy) {
+	interval := policy.IdleTimeout
+	if interval == 0 || (policy.InputTimeout > 0 && policy.InputTimeout < interval) {
+		interval = policy.InputTimeout
+	}
+	if interval <= 0 {
+		return
+	}
+	interval /= 4
+
+	group.Every(interval, func(context.Context) {
+		for _, stream := range bm.ListStreams() {
+			reason := stream.idleReason(policy)
+			if reason == "" {
+				continue
+			}
+			log.Printf("[Broadcast] Stopping abandoned stream %s (%s)", stream.ID, reason)
+			stream.stop(reason)
+			bm.publish(events.StreamAutoStopped, map[string]any{
+				"stream_id":     stream.ID,
+				"reason":        reason,
+				"last_input_at": stream.LastInputAt(),
+			})
+		}
+	})
+}
+
+// Heartbeat records broadcaster activity without media, so a broadcaster
+// that is connected but not sending (e.g. paused) keeps its stream alive
+func (s *Stream) Heartbeat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastHeartbeat = time.Now()
+}
+
+// LastInputAt returns when the stream last received media or a heartbeat,
+// or when it was started if it never did
+func (s *Stream) LastInputAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastInputAt()
+}
+
+// lastInputAt is LastInputAt for callers holding s.mu
+func (s *Stream) lastInputAt() time.Time {
+	last := s.lastHeartbeat
+	if s.StartedAt != nil && s.StartedAt.After(last) {
+		last = *s.StartedAt
+	}
+	if s.webrtcIngest != nil {
+		if packet := s.webrtcIngest.LastPacketAt(); packet.After(last) {
+			last = packet
+		}
+	}
+	// Pulled RTMP, SRT and RTSP inputs deliver through the pipeline
+	if s.orchestrator != nil {
+		if frame := s.orchestrator.LastFrameAt(); frame.After(last) {
+			last = frame
+		}
+	}
+	return last
+}
+
+// idleReason returns why the stream should be stopped under policy, or ""
+func (s *Stream) idleReason(policy IdlePolicy) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Status.Active() {
+		return ""
+	}
+	now := time.Now()
+	if len(s.viewers) > 0 || len(s.waiting) > 0 {
+		s.lastWatched = now
+	}
+	lastInput := s.lastInputAt()
+
+	// A paused broadcaster sends nothing on purpose
+	if policy.InputTimeout > 0 && s.Status != StatusPaused && s.orchestrator != nil && s.orchestrator.IsRunning() && now.Sub(lastInput) >= policy.InputTimeout {
+		return StopReasonNoInput
+	}
+	if policy.IdleTimeout > 0 {
+		active := lastInput
+		if s.lastWatched.After(active) {
+			active = s.lastWatched
+		}
+		if now.Sub(active) >= policy.IdleTimeout {
+			return StopReasonIdle
+		}
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package broadcast
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maxIngestClients is how many client identities a stream can list
+const maxIngestClients = 32
+
+// fingerprintPrefix marks a client identity that pins a certificate by the
+// SHA-256 of its DER encoding
+const fingerprintPrefix = "sha256:"
+
+// IngestClients is who may push to a stream with a TLS client certificate
+// instead of its stream key. The certificate must chain to the server's
+// client CA; Identities then name the certificates accepted for the stream.
+type IngestClients struct {
+	Identities []string `json:"identities,omitempty"`
+	// RequireCertificate refuses the stream key, so only the listed clients
+	// and accounts managing the stream can push
+	RequireCertificate bool `json:"require_certificate,omitempty"`
+}
+
+// ValidateIngestClients checks client identities and returns them trimmed,
+// without duplicates and with fingerprints in lower case without colons.
+// An identity is "sha256:<fingerprint>", or the common name, a DNS name, a
+// URI or an email address of the certificate.
+func ValidateIngestClients(clients IngestClients) (IngestClients, error) {
+	if len(clients.Identities) > maxIngestClients {
+		return IngestClients{}, fmt.Errorf("a stream can list at most %d ingest clients", maxIngestClients)
+	}
+	var identities []string
+	for _, identity := range clients.Identities {
+		identity = strings.TrimSpace(identity)
+		if identity == "" || len(identity) > 512 {
+			return IngestClients{}, fmt.Errorf("invalid ingest client %q", identity)
+		}
+		if fingerprint, ok := strings.CutPrefix(strings.ToLower(identity), fingerprintPrefix); ok {
+			fingerprint = strings.ReplaceAll(fingerprint, ":", "")
+			if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+				return IngestClients{}, fmt.Errorf("invalid certificate fingerprint %q", identity)
+			}
+			identity = fingerprintPrefix + fingerprint
+		}
+		if !slices.Contains(identities, identity) {
+			identities = append(identities, identity)
+		}
+	}
+	if clients.RequireCertificate && len(identities) == 0 {
+		return IngestClients{}, fmt.Errorf("require_certificate needs at least one ingest client")
+	}
+	return IngestClients{Identities: identities, RequireCertificate: clients.RequireCertificate}, nil
+}
+
+// ClientIdentities returns the identities a client certificate can be listed
+// by: its fingerprint, common name, DNS names, URIs and email addresses
+func ClientIdentities(cert *x509.Certificate) []string {
+	digest := sha256.Sum256(cert.Raw)
+	identities := []string{fingerprintPrefix + hex.EncodeToString(digest[:])}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return append(identities, cert.EmailAddresses...)
+}
+
+// SetIngestClients sets the clients that may push to the stream with a
+// certificate, validated with ValidateIngestClients
+func (s *Stream) SetIngestClients(clients IngestClients) {
+	s.mu.Lock()
+	s.ingestClients = clients
+	s.mu.Unlock()
+	s.changed(s)
+}
+
+// IngestClients returns the clients that may push to the stream with a
+// certificate
+func (s *Stream) IngestClients() IngestClients {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ingestClients
+}
+
+// ValidIngestClient reports whether a verified client certificate may push
+// to the stream
+func (s *Stream) ValidIngestClient(cert *x509.Certificate) bool {
+	clients := s.IngestClients()
+	for _, identity := range ClientIdentities(cert) {
+		if slices.Contains(clients.Identities, identity) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package broadcast
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"live-video/pkg/orchestrator"
+)
+
+// maxFailoverWindow caps how long an input may stall before failing over
+const maxFailoverWindow = time.Minute
+
+// SetInputs sets the inputs the stream's pipeline plays by priority and the
+// window after which it fails over from a stalled one, 0 for the server's
+// default. inputs must have been validated. A pipeline failing over already
+// takes them at once; otherwise they apply on its next start.
+func (s *Stream) SetInputs(inputs []orchestrator.Input, window time.Duration) error {
+	if window < 0 || window > maxFailoverWindow {
+		return fmt.Errorf("failover window must be between 0 and %s", maxFailoverWindow)
+	}
+
+	s.mu.Lock()
+	if len(inputs) == 0 {
+		inputs = nil
+	}
+	s.inputs = inputs
+	s.failoverWindow = window
+	s.mu.Unlock()
+	s.changed(s)
+	s.applyInputs()
+	return nil
+}
+
+// applyInputs hands the pipeline inputs to a pipeline failing over already
+func (s *Stream) applyInputs() {
+	s.mu.RLock()
+	orch := s.orchestrator
+	inputs, window := s.pipelineInputs(), s.failoverWindow
+	s.mu.RUnlock()
+	if orch != nil && orch.Inputs() != nil {
+		orch.SetInputs(inputs, window)
+	}
+}
+
+// Inputs returns the stream's inputs and failover window
+func (s *Stream) Inputs() ([]orchestrator.Input, time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inputs, s.failoverWindow
+}
+
+// PipelineInputs returns the inputs the stream's pipeline plays: its inputs
+// followed by its SRT listener, if any. A stream with only an SRT listener
+// plays WebRTC first, as it does without inputs.
+func (s *Stream) PipelineInputs() []orchestrator.Input {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pipelineInputs()
+}
+
+// pipelineInputs returns what PipelineInputs does. Callers hold s.mu.
+func (s *Stream) pipelineInputs() []orchestrator.Input {
+	if s.srt == nil {
+		return s.inputs
+	}
+	inputs := slices.Clone(s.inputs)
+	if len(inputs) == 0 {
+		inputs = []orchestrator.Input{{Kind: orchestrator.InputWebRTC}}
+	}
+	listener := s.srt.input()
+	listener.Priority = inputs[len(inputs)-1].Priority + 1
+	return append(inputs, listener)
+}
+
+// InputsSnapshot is the inputs a stream's pipeline fails over between
+type InputsSnapshot struct {
+	Inputs                []orchestrator.Input `json:"inputs"`
+	FailoverWindowSeconds float64              `json:"failover_window_seconds"` // 0 = server default
+}
+
+// inputsSnapshot describes the configured inputs, without the credentials
+// in their URLs. Callers hold s.mu.
+func (s *Stream) inputsSnapshot() *InputsSnapshot {
+	if len(s.inputs) == 0 {
+		return nil
+	}
+	return &InputsSnapshot{
+		Inputs:                orchestrator.RedactInputs(s.inputs),
+		FailoverWindowSeconds: s.failoverWindow.Seconds(),
+	}
+}
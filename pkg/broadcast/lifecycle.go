@@ -0,0 +1,281 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"live-video/pkg/events"
+)
+
+// StopReasonError is the stop reason of a stream whose pipeline failed
+const StopReasonError = "error"
+
+// transitions lists the statuses a stream may move to from each status.
+// Starting lasts while a run is set up, stopping while its ingest, pipeline
+// and work files are released.
+var transitions = map[StreamStatus][]StreamStatus{
+	StatusIdle:      {StatusStarting},
+	StatusStarting:  {StatusStreaming, StatusErrored},
+	StatusStreaming: {StatusPaused, StatusStopping, StatusErrored},
+	StatusPaused:    {StatusStreaming, StatusStopping, StatusErrored},
+	StatusStopping:  {StatusStopped},
+	StatusStopped:   {StatusStarting},
+	StatusErrored:   {StatusStarting},
+}
+
+// CanTransition reports whether a stream may go from status to to
+func (status StreamStatus) CanTransition(to StreamStatus) bool {
+	for _, next := range transitions[status] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether a stream in status is started: starting, streaming
+// or paused
+func (status StreamStatus) Active() bool {
+	return status == StatusStarting || status == StatusStreaming || status == StatusPaused
+}
+
+// TransitionError is returned for a status change the lifecycle forbids,
+// e.g. stopping a stream that is not started
+type TransitionError struct {
+	StreamID string
+	From, To StreamStatus
+}
+
+func (e *TransitionError) Error() string {
+	switch {
+	case e.To == StatusStarting && e.From.Active():
+		return "stream already started"
+	case e.To == StatusStopping:
+		return fmt.Sprintf("stream not streaming (%s)", e.From)
+	}
+	return fmt.Sprintf("stream %s cannot go from %s to %s", e.StreamID, e.From, e.To)
+}
+
+// Transition is a status change of a stream
+type Transition struct {
+	StreamID string       `json:"stream_id"`
+	From     StreamStatus `json:"from"`
+	To       StreamStatus `json:"to"`
+	Reason   string       `json:"reason,omitempty"`
+	At       time.Time    `json:"at"`
+}
+
+// SetEvents publishes every status change of the manager's streams as an
+// events.StreamStatusChanged event, in order, and streams stopped as
+// abandoned as events.StreamAutoStopped
+func (bm *BroadcastManager) SetEvents(bus *events.Bus) {
+	bm.bus.Store(bus)
+}
+
+// publish publishes an event on the manager's bus, if any
+func (bm *BroadcastManager) publish(topic string, data map[string]any) {
+	bm.bus.Load().Publish(topic, data)
+}
+
+func (bm *BroadcastManager) notifyTransition(stream *Stream, t Transition) {
+	log.Printf("[Broadcast] Stream %s: %s -> %s", t.StreamID, t.From, t.To)
+	data := map[string]any{
+		"stream_id": t.StreamID,
+		"from":      t.From,
+		"to":        t.To,
+		"at":        t.At,
+	}
+	if t.Reason != "" {
+		data["reason"] = t.Reason
+	}
+	bm.publish(events.StreamStatusChanged, data)
+}
+
+// transition moves the stream to status to. Callers hold s.mu and pass the
+// change to emit once they released it.
+func (s *Stream) transition(to StreamStatus, reason string) (Transition, error) {
+	from := s.Status
+	if !from.CanTransition(to) {
+		return Transition{}, &TransitionError{StreamID: s.ID, From: from, To: to}
+	}
+	s.Status = to
+	return Transition{StreamID: s.ID, From: from, To: to, Reason: reason, At: time.Now()}, nil
+}
+
+// emit reports status changes made under s.mu
+func (s *Stream) emit(changes []Transition) {
+	if s.notify == nil {
+		return
+	}
+	for _, t := range changes {
+		s.notify(s, t)
+	}
+}
+
+// Start starts broadcasting the stream. Stopped and errored streams can be
+// started again.
+func (s *Stream) Start() error {
+	defer s.changed(s)
+	s.mu.Lock()
+	starting, err := s.transition(StatusStarting, "")
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	// Every run gets its own stop channel, closed once when it stops
+	s.stopChan = make(chan struct{})
+	now := time.Now()
+	s.StartedAt = &now
+	s.StoppedAt = nil
+	s.StopReason = ""
+	s.Error = ""
+	stop := s.stopChan
+	s.mu.Unlock()
+	s.emit([]Transition{starting})
+
+	s.ownWorkDir()
+	go s.broadcastLoop(stop)
+
+	// The run may have failed while it was set up
+	s.mu.Lock()
+	if s.Status != StatusStarting {
+		err := &TransitionError{StreamID: s.ID, From: s.Status, To: StatusStreaming}
+		s.mu.Unlock()
+		return err
+	}
+	live, _ := s.transition(StatusStreaming, "")
+	s.mu.Unlock()
+	s.emit([]Transition{live})
+	return nil
+}
+
+// Stop stops broadcasting a streaming or paused stream, releases its ingest,
+// pipeline and work files, and ends its viewer sessions
+func (s *Stream) Stop() error {
+	return s.stop("")
+}
+
+// stop is Stop recording why the stream was stopped, if not on request
+func (s *Stream) stop(reason string) error {
+	defer s.changed(s)
+	s.mu.Lock()
+	stopping, err := s.transition(StatusStopping, reason)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	now := time.Now()
+	s.StoppedAt = &now
+	s.StopReason = reason
+	s.halt()
+	s.mu.Unlock()
+	s.emit([]Transition{stopping})
+
+	s.Teardown(context.Background(), TeardownStop)
+	s.finishStopping(reason)
+	s.endViewers(reason)
+	return nil
+}
+
+// finishStopping moves a stream whose teardown ended from stopping to
+// stopped
+func (s *Stream) finishStopping(reason string) {
+	s.mu.Lock()
+	stopped, err := s.transition(StatusStopped, reason)
+	s.mu.Unlock()
+	if err == nil {
+		s.emit([]Transition{stopped})
+	}
+}
+
+// Pause marks a streaming stream as paused by its broadcaster. Viewers stay
+// connected and the stream is not stopped for lack of input.
+func (s *Stream) Pause() error {
+	return s.setStatus(StatusPaused)
+}
+
+// Resume returns a paused stream to streaming
+func (s *Stream) Resume() error {
+	return s.setStatus(StatusStreaming)
+}
+
+// Fail moves a started stream to errored after its pipeline failed. It is
+// torn down like on Stop and can be started again.
+func (s *Stream) Fail(cause error) error {
+	defer s.changed(s)
+	s.mu.Lock()
+	errored, err := s.transition(StatusErrored, cause.Error())
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	now := time.Now()
+	s.StoppedAt = &now
+	s.StopReason = StopReasonError
+	s.Error = cause.Error()
+	s.halt()
+	s.mu.Unlock()
+
+	log.Printf("[Broadcast] Stream %s failed: %v", s.ID, cause)
+	s.emit([]Transition{errored})
+	s.Teardown(context.Background(), TeardownFailure)
+	s.endViewers(StopReasonError)
+	return nil
+}
+
+// CurrentStatus returns the status of the stream, read under its lock
+func (s *Stream) CurrentStatus() StreamStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Status
+}
+
+func (s *Stream) setStatus(to StreamStatus) error {
+	defer s.changed(s)
+	s.mu.Lock()
+	change, err := s.transition(to, "")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.emit([]Transition{change})
+	return nil
+}
+
+// halt ends the broadcast loop of the current run. Callers hold s.mu.
+func (s *Stream) halt() {
+	if s.stopChan != nil {
+		close(s.stopChan)
+		s.stopChan = nil
+	}
+}
+
+// endViewers sends viewers and waiting viewers an ended event and closes
+// their sessions. It runs after the teardown, so players reloading on the
+// event find the playlists already ended.
+func (s *Stream) endViewers(reason string) {
+	if reason == "" {
+		reason = "stopped"
+	}
+	ended := map[string]interface{}{"type": "ended", "stream_id": s.ID, "reason": reason}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, viewer := range s.viewers {
+		viewer.send(ended)
+		viewer.close()
+	}
+	for _, viewer := range s.waiting {
+		viewer.send(ended)
+		viewer.close()
+	}
+	s.waiting = nil
+	for id, viewer := range s.previews {
+		viewer.send(ended)
+		viewer.close()
+		delete(s.previews, id)
+	}
+}
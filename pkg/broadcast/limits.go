@@ -0,0 +1,205 @@
+package broadcast
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Admission scopes reported when a stream is refused
+const (
+	ScopeNode   = "node"
+	ScopeTenant = "tenant"
+)
+
+// admissionRetryAfter is the retry interval suggested to refused broadcasters
+const admissionRetryAfter = 10 * time.Second
+
+// admissionQueueTTL is how long a refused stream keeps its queue position
+// without retrying
+const admissionQueueTTL = 3 * admissionRetryAfter
+
+// Limits caps the number of live streams. Zero means unlimited.
+type Limits struct {
+	MaxLive          int // per node
+	MaxLivePerTenant int // per stream owner
+}
+
+// AdmissionError is returned when starting a stream would exceed a limit.
+// Refused streams wait in a queue and get the next free slots in order, as
+// long as they retry within admissionQueueTTL.
+type AdmissionError struct {
+	Scope         string
+	Limit         int
+	QueuePosition int
+	RetryAfter    time.Duration
+}
+
+func (e *AdmissionError) Error() string {
+	return fmt.Sprintf("%s live stream limit of %d reached (queue position %d)", e.Scope, e.Limit, e.QueuePosition)
+}
+
+// admissionControl enforces Limits. Its mutex is held while a stream is
+// counted and started so two broadcasters can't take the last slot.
+type admissionControl struct {
+	mu       sync.Mutex
+	limits   Limits
+	tenantOf func(streamID string) string
+	waiting  []waiter
+}
+
+type waiter struct {
+	streamID string
+	tenant   string
+	lastSeen time.Time
+}
+
+// SetLimits configures live stream limits. tenantOf returns the tenant a
+// stream counts against, e.g. its owner; nil disables tenant limits.
+func (bm *BroadcastManager) SetLimits(limits Limits, tenantOf func(streamID string) string) {
+	bm.admission.mu.Lock()
+	defer bm.admission.mu.Unlock()
+	bm.admission.limits = limits
+	bm.admission.tenantOf = tenantOf
+}
+
+// StartStream starts a stream if the node and the stream's tenant have a
+// free live slot. Otherwise it returns an *AdmissionError with the stream's
+// position in the admission queue.
+func (bm *BroadcastManager) StartStream(stream *Stream) error {
+	a := &bm.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if stream.isActive() {
+		return stream.Start() // reports "already started"
+	}
+	if err := bm.admit(stream.ID, a.tenant(stream.ID)); err != nil {
+		return err
+	}
+	if err := stream.Start(); err != nil {
+		return err
+	}
+	a.dequeue(stream.ID)
+	return nil
+}
+
+// CheckCapacity reports whether a new stream for tenant could go live now,
+// without queueing it
+func (bm *BroadcastManager) CheckCapacity(tenant string) error {
+	a := &bm.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return bm.admit("", tenant)
+}
+
+// Capacity describes live stream usage against the node limit
+func (bm *BroadcastManager) Capacity() map[string]interface{} {
+	a := &bm.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.prune()
+	live, _ := bm.liveCounts()
+	return map[string]interface{}{
+		"live_streams":        live,
+		"max_live":            a.limits.MaxLive,
+		"max_live_per_tenant": a.limits.MaxLivePerTenant,
+		"waiting":             len(a.waiting),
+	}
+}
+
+// admit checks the limits for streamID, queueing it when refused. An empty
+// streamID only checks. Callers hold admission.mu.
+func (bm *BroadcastManager) admit(streamID, tenant string) error {
+	a := &bm.admission
+	if a.limits.MaxLive <= 0 && a.limits.MaxLivePerTenant <= 0 {
+		return nil
+	}
+	a.prune()
+
+	live, perTenant := bm.liveCounts()
+
+	// Waiters ahead of this stream get free node slots first, unless their
+	// own tenant is full
+	ahead := 0
+	for _, w := range a.waiting {
+		if w.streamID == streamID {
+			break
+		}
+		if a.limits.MaxLivePerTenant <= 0 || perTenant[w.tenant] < a.limits.MaxLivePerTenant {
+			ahead++
+		}
+	}
+
+	var refused *AdmissionError
+	switch {
+	case a.limits.MaxLivePerTenant > 0 && tenant != "" && perTenant[tenant] >= a.limits.MaxLivePerTenant:
+		refused = &AdmissionError{Scope: ScopeTenant, Limit: a.limits.MaxLivePerTenant}
+	case a.limits.MaxLive > 0 && live+ahead >= a.limits.MaxLive:
+		refused = &AdmissionError{Scope: ScopeNode, Limit: a.limits.MaxLive}
+	default:
+		return nil
+	}
+
+	refused.QueuePosition = ahead + 1
+	refused.RetryAfter = admissionRetryAfter
+	if streamID != "" {
+		a.enqueue(streamID, tenant)
+	}
+	return refused
+}
+
+// liveCounts returns the number of live streams on the node and per tenant.
+// Callers hold admission.mu.
+func (bm *BroadcastManager) liveCounts() (int, map[string]int) {
+	live := 0
+	perTenant := make(map[string]int)
+	for _, stream := range bm.ListStreams() {
+		if !stream.isActive() {
+			continue
+		}
+		live++
+		if tenant := bm.admission.tenant(stream.ID); tenant != "" {
+			perTenant[tenant]++
+		}
+	}
+	return live, perTenant
+}
+
+func (a *admissionControl) tenant(streamID string) string {
+	if a.tenantOf == nil {
+		return ""
+	}
+	return a.tenantOf(streamID)
+}
+
+func (a *admissionControl) enqueue(streamID, tenant string) {
+	for i := range a.waiting {
+		if a.waiting[i].streamID == streamID {
+			a.waiting[i].lastSeen = time.Now()
+			return
+		}
+	}
+	a.waiting = append(a.waiting, waiter{streamID: streamID, tenant: tenant, lastSeen: time.Now()})
+}
+
+func (a *admissionControl) dequeue(streamID string) {
+	for i, w := range a.waiting {
+		if w.streamID == streamID {
+			a.waiting = append(a.waiting[:i], a.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// prune drops waiters that stopped retrying
+func (a *admissionControl) prune() {
+	kept := a.waiting[:0]
+	for _, w := range a.waiting {
+		if time.Since(w.lastSeen) < admissionQueueTTL {
+			kept = append(kept, w)
+		}
+	}
+	a.waiting = kept
+}
@@ -1,60 +1,135 @@
 package broadcast
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"live-video/config"
+	"live-video/pkg/events"
 	"live-video/pkg/orchestrator"
 	"live-video/pkg/webrtc"
+	"live-video/pkg/workdir"
 )
 
 type StreamStatus string
 
+// Stream statuses. Which changes are allowed is in transitions.
 const (
 	StatusIdle      StreamStatus = "idle"
-	StatusStreaming StreamStatus = "streaming"
+	StatusStarting  StreamStatus = "starting"
+	StatusStreaming StreamStatus = "streaming" // live
 	StatusPaused    StreamStatus = "paused"
+	StatusStopping  StreamStatus = "stopping"
 	StatusStopped   StreamStatus = "stopped"
+	StatusErrored   StreamStatus = "errored"
 )
 
 type Viewer struct {
 	ID          string
+	Token       string // resumes the viewer session after a disconnect
 	ConnectedAt time.Time
+	Reconnects  int // times the session was resumed
 	DataChan    chan []byte
 	closed      bool
 	mu          sync.Mutex
+
+	disconnectedAt time.Time   // set while the session waits to be resumed
+	expiry         *time.Timer // ends the session if it is not resumed
 }
 
 type Stream struct {
 	ID              string
+	Name            string
+	Description     string // shown in share cards of the watch and player pages
+	EventID         string
+	StreamKey       string // secret that authorizes ingest without an account
+	PrimaryID       string // set on the backup of a redundant pair
+	BackupID        string // set on the primary of a redundant pair
 	VideoURL        string
 	HLSPlaylistURL  string
 	GCSPath         string
 	Status          StreamStatus
 	CreatedAt       time.Time
+	ScheduledAt     *time.Time // announced start, primed with a slate until then
 	StartedAt       *time.Time
+	StoppedAt       *time.Time
+	StopReason      string // set when the stream was stopped automatically
+	Error           string // why the stream errored
 	ViewerCount     int
+	MaxViewers      int     // 0 = unlimited
+	WaitingRoom     bool    // queue viewers over MaxViewers instead of refusing them
+	EmbedOnly       bool    // playback requires an embed token or read access
 	CurrentPosition float64 // Current playback position in seconds
 	VideoDuration   float64 // Total video duration in seconds
 
 	mu           sync.RWMutex
 	viewers      map[string]*Viewer
+	waiting      []*Viewer
+	previews     map[string]*Viewer // operators watching without counting as viewers
 	broadcast    chan []byte
-	stopChan     chan bool
+	stopChan     chan struct{} // closed when the current run stops
 	webrtcIngest *webrtc.IngestService
 	orchestrator *orchestrator.StreamOrchestrator
+	workDir      *workdir.WorkDir
+	redundancy   *redundancyState
+
+	sessionTimeout time.Duration // how long disconnected viewers can resume
+
+	playlistWindows map[string]time.Duration // DVR window by viewer class
+	clipLimits      map[string]time.Duration // longest DVR clip by viewer class
+
+	inputs         []orchestrator.Input // sources the pipeline fails over between
+	failoverWindow time.Duration        // 0 = server default
+	srt            *SRTIngest           // listener for an SRT encoder, nil for none
+	audioInputs    []config.AudioInput  // extra audio mixed in or offered as alternates
+	captions       config.CaptionSettings
+	priority       string            // priority class under load, "" = standard
+	contentType    string            // what the encoder is tuned for, "" = camera
+	gate           ContentGate       // what viewers confirm before playing
+	ingestClients  IngestClients     // certificates that may push instead of the stream key
+	watermark      bool              // serve every session its own A/B segment sequence
+	playbackCheck  *PlaybackCheck    // synthetic viewer of the current run
+	encoder        *EncoderTelemetry // latest telemetry pushed by a hardware encoder
+
+	chunkMu sync.Mutex // guards chunks, held while they are delivered in order
+	chunks  chunkSequencer
+
+	lastHeartbeat time.Time // last broadcaster heartbeat
+	lastWatched   time.Time // last time the idle monitor saw viewers
+
+	changed func(s *Stream)               // persists the stream's record
+	notify  func(s *Stream, t Transition) // reports status changes
+
+	resources resourceSet // what was created for the stream, released on teardown
 }
 
 type BroadcastManager struct {
-	mu      sync.RWMutex
-	streams map[string]*Stream
+	mu        sync.RWMutex
+	streams   map[string]*Stream
+	events    map[string]*Event
+	workDir   *workdir.WorkDir
+	admission admissionControl
+	srt       SRTConfig // SRT ingest, disabled without ports
+
+	sessionTimeout time.Duration
+	bus            atomic.Pointer[events.Bus]
+	outputCleaner  func(ctx context.Context, streamID string) error // deletes a stream's output in the bucket
+
+	recordMu   sync.Mutex
+	recordDir  string            // where stream records are kept, if anywhere
+	recorded   map[string][]byte // last written record by stream ID
+	unrecorded map[string]bool   // deleted streams whose records must not come back
 }
 
-func NewBroadcastManager() *BroadcastManager {
+func NewBroadcastManager(workDir *workdir.WorkDir) *BroadcastManager {
 	return &BroadcastManager{
 		streams: make(map[string]*Stream),
+		events:  make(map[string]*Event),
+		workDir: workDir,
 	}
 }
 
@@ -62,19 +137,8 @@ func (bm *BroadcastManager) CreateStream(videoURL, gcsPath string) *Stream {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	streamID := uuid.New().String()
-	stream := &Stream{
-		ID:        streamID,
-		VideoURL:  videoURL,
-		GCSPath:   gcsPath,
-		Status:    StatusIdle,
-		CreatedAt: time.Now(),
-		viewers:   make(map[string]*Viewer),
-		broadcast: make(chan []byte, 100),
-		stopChan:  make(chan bool),
-	}
-
-	bm.streams[streamID] = stream
+	stream := bm.newStream(uuid.New().String(), videoURL, "", gcsPath)
+	bm.streams[stream.ID] = stream
 	return stream
 }
 
@@ -83,20 +147,44 @@ func (bm *BroadcastManager) CreateStreamWithHLS(videoURL, hlsPlaylistURL, gcsPat
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	streamID := uuid.New().String()
+	stream := bm.newStream(uuid.New().String(), videoURL, hlsPlaylistURL, gcsPath)
+	bm.streams[stream.ID] = stream
+	return stream
+}
+
+// RegisterStream registers a stream under a known ID, e.g. when an archived
+// stream is restored
+func (bm *BroadcastManager) RegisterStream(streamID, videoURL, hlsPlaylistURL, gcsPath string) (*Stream, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if _, exists := bm.streams[streamID]; exists {
+		return nil, fmt.Errorf("stream already exists: %s", streamID)
+	}
+
+	stream := bm.newStream(streamID, videoURL, hlsPlaylistURL, gcsPath)
+	bm.streams[streamID] = stream
+	return stream, nil
+}
+
+func (bm *BroadcastManager) newStream(streamID, videoURL, hlsPlaylistURL, gcsPath string) *Stream {
 	stream := &Stream{
 		ID:             streamID,
+		StreamKey:      newStreamKey(),
 		VideoURL:       videoURL,
 		HLSPlaylistURL: hlsPlaylistURL,
 		GCSPath:        gcsPath,
 		Status:         StatusIdle,
 		CreatedAt:      time.Now(),
 		viewers:        make(map[string]*Viewer),
+		previews:       make(map[string]*Viewer),
 		broadcast:      make(chan []byte, 100),
-		stopChan:       make(chan bool),
+		workDir:        bm.workDir,
+		sessionTimeout: bm.sessionTimeout,
+		changed:        bm.saveRecord,
+		notify:         bm.notifyTransition,
 	}
-
-	bm.streams[streamID] = stream
+	bm.ownOutput(stream)
 	return stream
 }
 
@@ -124,94 +212,92 @@ func (bm *BroadcastManager) ListStreams() []*Stream {
 	return streams
 }
 
-func (bm *BroadcastManager) DeleteStream(streamID string) error {
+// DeleteStream stops and forgets a stream and releases everything created
+// for it, including its output in the bucket unless keepOutput is set. The
+// backup of a redundant pair is deleted with its primary.
+func (bm *BroadcastManager) DeleteStream(streamID string, keepOutput bool) (*CleanupReport, error) {
 	bm.mu.Lock()
-	defer bm.mu.Unlock()
-
 	stream, exists := bm.streams[streamID]
 	if !exists {
-		return fmt.Errorf("stream not found: %s", streamID)
+		bm.mu.Unlock()
+		return nil, fmt.Errorf("stream not found: %s", streamID)
 	}
 
-	if stream.Status == StatusStreaming {
-		stream.Stop()
-	}
+	bm.removeStream(stream)
 
-	delete(bm.streams, streamID)
-	return nil
-}
-
-func (s *Stream) Start() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.Status == StatusStreaming {
-		return fmt.Errorf("stream already started")
+	// The backup of a redundant pair goes with its primary; deleting only
+	// the backup leaves the primary without failover
+	backup, hasBackup := bm.streams[stream.BackupID]
+	if hasBackup {
+		bm.removeStream(backup)
 	}
-
-	s.Status = StatusStreaming
-	now := time.Now()
-	s.StartedAt = &now
-
-	go s.broadcastLoop()
-
-	return nil
-}
-
-func (s *Stream) Stop() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.Status != StatusStreaming {
-		return fmt.Errorf("stream not streaming")
+	if primary, ok := bm.streams[stream.PrimaryID]; ok {
+		primary.mu.Lock()
+		primary.BackupID = ""
+		primary.redundancy = nil
+		primary.mu.Unlock()
 	}
+	bm.mu.Unlock()
 
-	s.Status = StatusStopped
-	close(s.stopChan)
-
-	for _, viewer := range s.viewers {
-		viewer.mu.Lock()
-		if !viewer.closed {
-			close(viewer.DataChan)
-			viewer.closed = true
-		}
-		viewer.mu.Unlock()
+	// Released outside of bm.mu: stopping pipelines and deleting output takes
+	// a while
+	report := stream.delete(keepOutput)
+	if hasBackup {
+		backup.delete(keepOutput)
 	}
+	return report, nil
+}
 
-	return nil
+// removeStream forgets a stream. Callers hold bm.mu and delete the stream
+// afterwards.
+func (bm *BroadcastManager) removeStream(stream *Stream) {
+	delete(bm.streams, stream.ID)
+	bm.deleteRecord(stream.ID)
+	if event, ok := bm.events[stream.EventID]; ok {
+		event.removeStream(stream.ID)
+	}
 }
 
-func (s *Stream) AddViewer() *Viewer {
+// delete stops a removed stream and tears it down
+func (s *Stream) delete(keepOutput bool) *CleanupReport {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	viewerID := uuid.New().String()
-	viewer := &Viewer{
-		ID:          viewerID,
-		ConnectedAt: time.Now(),
-		DataChan:    make(chan []byte, 10),
+	var changes []Transition
+	if s.Status.Active() {
+		stopping, _ := s.transition(StatusStopping, TeardownDelete)
+		now := time.Now()
+		s.StoppedAt = &now
+		s.halt()
+		changes = []Transition{stopping}
 	}
-
-	s.viewers[viewerID] = viewer
-	s.ViewerCount = len(s.viewers)
-
-	return viewer
+	s.mu.Unlock()
+	s.emit(changes)
+
+	// Files may be left over from runs of the stream before a restart
+	s.ownWorkDir()
+	report := s.teardown(context.Background(), TeardownDelete, !keepOutput)
+	if len(changes) > 0 {
+		s.finishStopping(TeardownDelete)
+	}
+	s.endViewers(TeardownDelete)
+	return report
 }
 
 func (s *Stream) RemoveViewer(viewerID string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.removeViewer(viewerID)
+}
 
+// removeViewer ends a viewer session. Callers hold s.mu.
+func (s *Stream) removeViewer(viewerID string) {
 	if viewer, exists := s.viewers[viewerID]; exists {
-		viewer.mu.Lock()
-		if !viewer.closed {
-			close(viewer.DataChan)
-			viewer.closed = true
-		}
-		viewer.mu.Unlock()
+		viewer.close()
 		delete(s.viewers, viewerID)
 		s.ViewerCount = len(s.viewers)
+		s.admitWaiting()
+		return
 	}
+	s.removeWaiting(viewerID)
 }
 
 func (s *Stream) Broadcast(data []byte) {
@@ -221,12 +307,21 @@ func (s *Stream) Broadcast(data []byte) {
 	}
 }
 
-func (s *Stream) broadcastLoop() {
+func (s *Stream) broadcastLoop(stop <-chan struct{}) {
 	for {
 		select {
 		case data := <-s.broadcast:
 			s.mu.RLock()
 			for _, viewer := range s.viewers {
+				if !viewer.disconnectedAt.IsZero() {
+					continue
+				}
+				select {
+				case viewer.DataChan <- data:
+				default:
+				}
+			}
+			for _, viewer := range s.previews {
 				select {
 				case viewer.DataChan <- data:
 				default:
@@ -234,56 +329,39 @@ func (s *Stream) broadcastLoop() {
 			}
 			s.mu.RUnlock()
 
-		case <-s.stopChan:
+		case <-stop:
 			return
 		}
 	}
 }
 
-func (s *Stream) GetStats() map[string]interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	// Prefer HLS playlist URL for streaming
-	videoURL := s.VideoURL
-	if s.HLSPlaylistURL != "" {
-		videoURL = s.HLSPlaylistURL
-	}
-
-	stats := map[string]interface{}{
-		"id":           s.ID,
-		"status":       s.Status,
-		"viewer_count": s.ViewerCount,
-		"created_at":   s.CreatedAt,
-		"video_url":    videoURL,
-		"gcs_path":     s.GCSPath,
-	}
-
-	if s.HLSPlaylistURL != "" {
-		stats["hls_playlist_url"] = s.HLSPlaylistURL
-		stats["original_video_url"] = s.VideoURL
-	}
+// SetEmbedOnly restricts playback to embedded players holding a valid token
+func (s *Stream) SetEmbedOnly(embedOnly bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.EmbedOnly = embedOnly
+}
 
-	// Include orchestrator info if available
-	if s.orchestrator != nil {
-		stats["orchestrator"] = s.orchestrator.GetStats()
-	}
+// SetMetadata sets the name and description viewers see
+func (s *Stream) SetMetadata(name, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Name = name
+	s.Description = description
+}
 
-	if s.StartedAt != nil {
-		stats["started_at"] = s.StartedAt
-		uptimeSeconds := time.Since(*s.StartedAt).Seconds()
-		stats["uptime_seconds"] = uptimeSeconds
-
-		// Calculate current position in video (looping if needed)
-		if s.VideoDuration > 0 {
-			// Use modulo to loop the video
-			currentPosition := float64(int(uptimeSeconds) % int(s.VideoDuration))
-			stats["current_position"] = currentPosition
-			stats["video_duration"] = s.VideoDuration
-		}
-	}
+// Metadata returns the name and description of the stream
+func (s *Stream) Metadata() (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Name, s.Description
+}
 
-	return stats
+// IsEmbedOnly reports whether playback requires an embed token
+func (s *Stream) IsEmbedOnly() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.EmbedOnly
 }
 
 // GetCurrentPosition calculates the current playback position based on stream uptime
@@ -307,27 +385,51 @@ func (s *Stream) SetVideoDuration(duration float64) {
 	s.VideoDuration = duration
 }
 
+// WorkDir returns the work directory layout used for this stream's files
+func (s *Stream) WorkDir() *workdir.WorkDir {
+	return s.workDir
+}
+
 // GetWebRTCIngest gets or creates a WebRTC ingestion service for this stream
 func (s *Stream) GetWebRTCIngest() *webrtc.IngestService {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.webrtcIngest == nil {
-		ingest, err := webrtc.NewIngestService(s.ID)
+		ingest, err := webrtc.NewIngestService(s.ID, s.workDir.StreamIngest(s.ID))
 		if err != nil {
 			return nil
 		}
 		s.webrtcIngest = ingest
+		s.ownWorkDir()
+		s.Own(ResourceIngest, "webrtc", func(ctx context.Context) error {
+			s.mu.Lock()
+			if s.webrtcIngest == ingest {
+				s.webrtcIngest = nil
+			}
+			s.mu.Unlock()
+			return ingest.CloseConnection()
+		})
 	}
 
 	return s.webrtcIngest
 }
 
-// SetOrchestrator sets the stream orchestrator for this stream
+// SetOrchestrator sets the stream orchestrator for this stream. It is
+// stopped when the stream is torn down.
 func (s *Stream) SetOrchestrator(orch *orchestrator.StreamOrchestrator) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.orchestrator = orch
+	s.ownWorkDir()
+	s.Own(ResourcePipeline, "orchestrator", func(ctx context.Context) error {
+		s.mu.Lock()
+		if s.orchestrator == orch {
+			s.orchestrator = nil
+		}
+		s.mu.Unlock()
+		return orch.Stop()
+	})
 }
 
 // GetOrchestrator gets the stream orchestrator for this stream
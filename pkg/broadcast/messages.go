@@ -0,0 +1,186 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"live-video/pkg/background"
+	"live-video/pkg/events"
+	"live-video/pkg/webrtc"
+)
+
+// broadcasterUpdateInterval is how often broadcasters' viewer counts and
+// health are checked for changes
+const broadcasterUpdateInterval = 5 * time.Second
+
+// broadcasterRefresh is how often the viewer count is sent to a broadcaster
+// even if it did not change, for pages that connected meanwhile
+const broadcasterRefresh = 30 * time.Second
+
+// MessageBroadcaster pushes a message to the WebRTC broadcaster of a stream,
+// on whichever replica it is connected to
+func (bm *BroadcastManager) MessageBroadcaster(streamID string, msg webrtc.Message) {
+	data := map[string]any{
+		"stream_id": streamID,
+		"type":      msg.Type,
+	}
+	if msg.Level != "" {
+		data["level"] = msg.Level
+	}
+	if msg.Text != "" {
+		data["text"] = msg.Text
+	}
+	if msg.Data != nil {
+		data["data"] = msg.Data
+	}
+	bm.publish(events.BroadcasterMessage, data)
+}
+
+// SendToBroadcaster pushes a message to the stream's WebRTC broadcaster if
+// it is connected to this replica. It reports whether it was sent.
+func (s *Stream) SendToBroadcaster(msg webrtc.Message) bool {
+	s.mu.RLock()
+	ingest := s.webrtcIngest
+	s.mu.RUnlock()
+	return ingest != nil && ingest.Send(msg)
+}
+
+// StartBroadcasterMessages keeps WebRTC broadcasters informed on their
+// messages data channel: of their viewer count and their stream's health as
+// they change, of what their pipeline does, and of the messages sent with
+// MessageBroadcaster from any replica. Updates are sent from group.
+func (bm *BroadcastManager) StartBroadcasterMessages(group *background.Group) {
+	bus := bm.bus.Load()
+	bus.Subscribe(events.BroadcasterMessage, func(event events.Event) {
+		if stream, ok := bm.eventStream(event); ok {
+			msg := webrtc.Message{Type: stringOf(event.Data["type"]), Level: stringOf(event.Data["level"]), Text: stringOf(event.Data["text"])}
+			if data, ok := event.Data["data"].(map[string]any); ok {
+				msg.Data = data
+			}
+			stream.SendToBroadcaster(msg)
+		}
+	})
+	bus.Subscribe("pipeline.*", func(event events.Event) {
+		if stream, ok := bm.eventStream(event); ok {
+			if msg, ok := pipelineMessage(event); ok {
+				stream.SendToBroadcaster(msg)
+			}
+		}
+	})
+
+	group.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(broadcasterUpdateInterval)
+		defer ticker.Stop()
+
+		type sent struct {
+			viewers int
+			at      time.Time
+			health  string
+		}
+		last := make(map[*webrtc.IngestService]*sent)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			connected := make(map[*webrtc.IngestService]*sent)
+			for _, stream := range bm.ListStreams() {
+				stream.mu.RLock()
+				ingest := stream.webrtcIngest
+				stream.mu.RUnlock()
+				if ingest == nil {
+					continue
+				}
+				state := last[ingest]
+				if state == nil {
+					state = &sent{viewers: -1, health: HealthHealthy}
+				}
+				connected[ingest] = state
+
+				health := stream.Health()
+				if health.ViewerCount != state.viewers || time.Since(state.at) >= broadcasterRefresh {
+					if ingest.Send(webrtc.Message{Type: webrtc.MessageViewers, Data: map[string]any{"viewer_count": health.ViewerCount}}) {
+						state.viewers, state.at = health.ViewerCount, time.Now()
+					}
+				}
+				// Offline streams are the broadcaster's own doing
+				if health.Health != state.health && health.Health != HealthOffline {
+					if ingest.Send(healthMessage(health)) {
+						state.health = health.Health
+					}
+				}
+			}
+			last = connected
+		}
+	})
+}
+
+// eventStream returns the stream an event is about
+func (bm *BroadcastManager) eventStream(event events.Event) (*Stream, bool) {
+	streamID, _ := event.Data["stream_id"].(string)
+	stream, err := bm.GetStream(streamID)
+	return stream, err == nil
+}
+
+// healthMessage tells the broadcaster about the stream's health
+func healthMessage(health StreamHealth) webrtc.Message {
+	msg := webrtc.Message{
+		Type:  webrtc.MessageHealth,
+		Level: webrtc.LevelInfo,
+		Text:  "Your stream is healthy again",
+		Data:  map[string]any{"health": health.Health},
+	}
+	switch health.Health {
+	case HealthDegraded:
+		msg.Level, msg.Text = webrtc.LevelWarning, "Your stream is degraded: "+health.Reason
+	case HealthStalled:
+		msg.Level, msg.Text = webrtc.LevelCritical, "Your stream stalled: "+health.Reason
+	}
+	if health.IngestKbps > 0 {
+		msg.Data["ingest_kbps"] = health.IngestKbps
+		msg.Data["loss_rate"] = health.LossRate
+	}
+	return msg
+}
+
+// pipelineMessage tells the broadcaster what its pipeline did, if it is
+// worth telling
+func pipelineMessage(event events.Event) (webrtc.Message, bool) {
+	msg := webrtc.Message{Type: webrtc.MessageHealth}
+	switch event.Type {
+	case events.PipelineDegraded:
+		// Level 0 restores full encoding; relayed levels are float64
+		var level float64
+		switch v := event.Data["level"].(type) {
+		case int:
+			level = float64(v)
+		case float64:
+			level = v
+		}
+		if level == 0 {
+			msg.Level, msg.Text = webrtc.LevelInfo, "Encoding is back to full quality"
+			break
+		}
+		msg.Level = webrtc.LevelWarning
+		msg.Text = fmt.Sprintf("Server is under load, encoding reduced: %s (%s)", stringOf(event.Data["action"]), stringOf(event.Data["reason"]))
+	case events.PipelineRestarted:
+		msg.Level = webrtc.LevelWarning
+		msg.Text = "Transcoding restarted, viewers may have seen a short gap"
+	case events.PipelineFailed:
+		msg.Level = webrtc.LevelCritical
+		msg.Text = "Transcoding failed: " + stringOf(event.Data["error"])
+	case events.PipelineInputSwitched:
+		msg.Level = webrtc.LevelInfo
+		msg.Text = fmt.Sprintf("Viewers now see the %s input (%s)", stringOf(event.Data["to"]), stringOf(event.Data["reason"]))
+	default:
+		return msg, false
+	}
+	return msg, true
+}
+
+func stringOf(v any) string {
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,63 @@
+package broadcast
+
+import "time"
+
+// Outcomes of a playback check
+const (
+	PlaybackCheckPending  = "pending"
+	PlaybackCheckPlayable = "playable"
+	PlaybackCheckFailed   = "failed"
+)
+
+// PlaybackCheck is what a synthetic viewer found playing the stream after
+// it started: the master playlist, and the newest segment of every rendition
+// decoded
+type PlaybackCheck struct {
+	Status     string           `json:"status"`
+	StartedAt  time.Time        `json:"started_at"`
+	CheckedAt  *time.Time       `json:"checked_at,omitempty"`
+	Renditions []RenditionCheck `json:"renditions,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// RenditionCheck is the segment of a rendition a playback check decoded
+type RenditionCheck struct {
+	Name    string `json:"name"`
+	Segment string `json:"segment,omitempty"`
+	Codec   string `json:"codec,omitempty"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Frames  int    `json:"frames,omitempty"` // video frames decoded
+	Error   string `json:"error,omitempty"`
+}
+
+// Playable reports whether the check verified the stream playable
+func (c *PlaybackCheck) Playable() bool {
+	return c != nil && c.Status == PlaybackCheckPlayable
+}
+
+// SetPlaybackCheck sets the playback check of the stream's current run
+func (s *Stream) SetPlaybackCheck(check *PlaybackCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playbackCheck = check
+}
+
+// PlaybackCheck returns a copy of the playback check of the stream's current
+// run, nil before the first
+func (s *Stream) PlaybackCheck() *PlaybackCheck {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.playbackCheck.copy()
+}
+
+// copy returns a deep copy of the check
+func (c *PlaybackCheck) copy() *PlaybackCheck {
+	if c == nil {
+		return nil
+	}
+	check := *c
+	check.CheckedAt = copyTime(c.CheckedAt)
+	check.Renditions = append([]RenditionCheck(nil), c.Renditions...)
+	return &check
+}
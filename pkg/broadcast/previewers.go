@@ -0,0 +1,34 @@
+package broadcast
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JoinPreview connects an operator previewing the stream. Previewers get
+// what viewers get, but are not counted as viewers: they skip the viewer
+// limit and the waiting room, don't show in the viewer count and don't keep
+// an idle stream running.
+func (s *Stream) JoinPreview() *Viewer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	viewer := &Viewer{
+		ID:          uuid.New().String(),
+		ConnectedAt: time.Now(),
+		DataChan:    make(chan []byte, 10),
+	}
+	s.previews[viewer.ID] = viewer
+	return viewer
+}
+
+// LeavePreview disconnects a previewer
+func (s *Stream) LeavePreview(viewer *Viewer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.previews[viewer.ID] == viewer {
+		viewer.close()
+		delete(s.previews, viewer.ID)
+	}
+}
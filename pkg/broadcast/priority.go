@@ -0,0 +1,25 @@
+package broadcast
+
+import "live-video/pkg/orchestrator"
+
+// SetPriority sets the priority class of the stream, validated with
+// orchestrator.ValidatePriority. A running pipeline takes it at once.
+func (s *Stream) SetPriority(class string) {
+	s.mu.Lock()
+	s.priority = class
+	s.mu.Unlock()
+	if orch := s.GetOrchestrator(); orch != nil && orch.IsRunning() {
+		orch.SetPriority(class)
+	}
+	s.changed(s)
+}
+
+// Priority returns the priority class of the stream
+func (s *Stream) Priority() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.priority == "" {
+		return orchestrator.PriorityStandard
+	}
+	return s.priority
+}
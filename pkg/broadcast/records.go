@@ -0,0 +1,238 @@
+package broadcast
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/orchestrator"
+)
+
+// StopReasonRestart is the stop reason of streams that were live when the
+// server stopped
+const StopReasonRestart = "restart"
+
+// recordFlushInterval is how often changed stream records are written
+const recordFlushInterval = 5 * time.Second
+
+// StreamRecord is what is kept of a stream across restarts. Events and
+// redundant pairs are not kept, so restored streams stand alone.
+type StreamRecord struct {
+	ID             string       `json:"id"`
+	Name           string       `json:"name,omitempty"`
+	Description    string       `json:"description,omitempty"`
+	StreamKey      string       `json:"stream_key"`
+	VideoURL       string       `json:"video_url,omitempty"`
+	HLSPlaylistURL string       `json:"hls_playlist_url,omitempty"`
+	GCSPath        string       `json:"gcs_path,omitempty"`
+	Status         StreamStatus `json:"status"`
+	CreatedAt      time.Time    `json:"created_at"`
+	ScheduledAt    *time.Time   `json:"scheduled_at,omitempty"`
+	StartedAt      *time.Time   `json:"started_at,omitempty"`
+	StoppedAt      *time.Time   `json:"stopped_at,omitempty"`
+	StopReason     string       `json:"stop_reason,omitempty"`
+	Error          string       `json:"error,omitempty"`
+	MaxViewers     int          `json:"max_viewers,omitempty"`
+	WaitingRoom    bool         `json:"waiting_room,omitempty"`
+	EmbedOnly      bool         `json:"embed_only,omitempty"`
+	VideoDuration  float64      `json:"video_duration,omitempty"`
+
+	Inputs         []orchestrator.Input   `json:"inputs,omitempty"`
+	FailoverWindow time.Duration          `json:"failover_window,omitempty"`
+	SRT            *SRTIngest             `json:"srt,omitempty"`
+	AudioInputs    []config.AudioInput    `json:"audio_inputs,omitempty"`
+	Captions       config.CaptionSettings `json:"captions,omitempty"`
+	Priority       string                 `json:"priority,omitempty"`
+	ContentType    string                 `json:"content_type,omitempty"`
+	Gate           ContentGate            `json:"content_gate,omitzero"`
+	IngestClients  IngestClients          `json:"ingest_clients,omitzero"`
+	Watermark      bool                   `json:"watermark,omitempty"`
+}
+
+// SetRecordDir makes the manager keep a record of every stream in dir so
+// streams survive restarts. Records are written when a stream starts or stops
+// and every recordFlushInterval otherwise.
+func (bm *BroadcastManager) SetRecordDir(dir string) {
+	bm.recordMu.Lock()
+	bm.recordDir = dir
+	bm.recorded = make(map[string][]byte)
+	bm.unrecorded = make(map[string]bool)
+	bm.recordMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(recordFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, stream := range bm.ListStreams() {
+				bm.saveRecord(stream)
+			}
+		}
+	}()
+}
+
+// LoadRecords registers the streams recorded in the record directory.
+// Streams that were streaming when the server stopped come back stopped
+// with StopReasonRestart, the local files of their last run are removed, and
+// they are returned as interrupted.
+func (bm *BroadcastManager) LoadRecords() (loaded []*Stream, interrupted []*Stream, err error) {
+	bm.recordMu.Lock()
+	dir := bm.recordDir
+	bm.recordMu.Unlock()
+	if dir == "" {
+		return nil, nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read stream records: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var record StreamRecord
+		if err := json.Unmarshal(data, &record); err != nil || record.ID == "" {
+			log.Printf("[Broadcast] Skipping unreadable stream record %s", entry.Name())
+			continue
+		}
+
+		stream, err := bm.RegisterStream(record.ID, record.VideoURL, record.HLSPlaylistURL, record.GCSPath)
+		if err != nil {
+			continue
+		}
+		stream.restore(record)
+		loaded = append(loaded, stream)
+		if record.Status.Active() {
+			stream.ownWorkDir()
+			stream.Teardown(context.Background(), TeardownRestart)
+			interrupted = append(interrupted, stream)
+		}
+	}
+	return loaded, interrupted, nil
+}
+
+// restore sets the state of a registered stream from its record
+func (s *Stream) restore(record StreamRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Name = record.Name
+	s.Description = record.Description
+	s.StreamKey = record.StreamKey
+	s.CreatedAt = record.CreatedAt
+	s.ScheduledAt = record.ScheduledAt
+	s.StartedAt = record.StartedAt
+	s.StoppedAt = record.StoppedAt
+	s.StopReason = record.StopReason
+	s.MaxViewers = record.MaxViewers
+	s.WaitingRoom = record.WaitingRoom
+	s.EmbedOnly = record.EmbedOnly
+	s.VideoDuration = record.VideoDuration
+	s.inputs = record.Inputs
+	s.failoverWindow = record.FailoverWindow
+	s.srt = record.SRT
+	s.audioInputs = record.AudioInputs
+	s.captions = record.Captions
+	s.priority = record.Priority
+	s.contentType = record.ContentType
+	s.gate = record.Gate
+	s.ingestClients = record.IngestClients
+	s.watermark = record.Watermark
+
+	s.Error = record.Error
+
+	s.Status = record.Status
+	if record.Status.Active() || record.Status == StatusStopping {
+		s.Status = StatusStopped
+		s.StopReason = StopReasonRestart
+		now := time.Now()
+		s.StoppedAt = &now
+	}
+}
+
+// record returns the persisted state of the stream
+func (s *Stream) record() StreamRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return StreamRecord{
+		ID:             s.ID,
+		Name:           s.Name,
+		Description:    s.Description,
+		StreamKey:      s.StreamKey,
+		VideoURL:       s.VideoURL,
+		HLSPlaylistURL: s.HLSPlaylistURL,
+		GCSPath:        s.GCSPath,
+		Status:         s.Status,
+		CreatedAt:      s.CreatedAt,
+		ScheduledAt:    s.ScheduledAt,
+		StartedAt:      s.StartedAt,
+		StoppedAt:      s.StoppedAt,
+		StopReason:     s.StopReason,
+		Error:          s.Error,
+		MaxViewers:     s.MaxViewers,
+		WaitingRoom:    s.WaitingRoom,
+		EmbedOnly:      s.EmbedOnly,
+		VideoDuration:  s.VideoDuration,
+		Inputs:         s.inputs,
+		FailoverWindow: s.failoverWindow,
+		SRT:            s.srt,
+		AudioInputs:    s.audioInputs,
+		Captions:       s.captions,
+		Priority:       s.priority,
+		ContentType:    s.contentType,
+		Gate:           s.gate,
+		IngestClients:  s.ingestClients,
+		Watermark:      s.watermark,
+	}
+}
+
+// saveRecord writes the record of a stream when it changed since it was last
+// written. It can be called with bm.mu held.
+func (bm *BroadcastManager) saveRecord(stream *Stream) {
+	data, err := json.MarshalIndent(stream.record(), "", "  ")
+	if err != nil {
+		return
+	}
+
+	bm.recordMu.Lock()
+	defer bm.recordMu.Unlock()
+	if bm.recordDir == "" || bm.unrecorded[stream.ID] || bytes.Equal(bm.recorded[stream.ID], data) {
+		return
+	}
+
+	path := filepath.Join(bm.recordDir, stream.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		log.Printf("[Broadcast] Failed to write record of stream %s: %v", stream.ID, err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("[Broadcast] Failed to write record of stream %s: %v", stream.ID, err)
+		return
+	}
+	bm.recorded[stream.ID] = data
+}
+
+// deleteRecord removes the record of a deleted stream. Saves of the stream
+// still in flight are dropped.
+func (bm *BroadcastManager) deleteRecord(streamID string) {
+	bm.recordMu.Lock()
+	defer bm.recordMu.Unlock()
+	if bm.recordDir == "" {
+		return
+	}
+	delete(bm.recorded, streamID)
+	bm.unrecorded[streamID] = true
+	if err := os.Remove(filepath.Join(bm.recordDir, streamID+".json")); err != nil && !os.IsNotExist(err) {
+		log.Printf("[Broadcast] Failed to remove record of stream %s: %v", streamID, err)
+	}
+}
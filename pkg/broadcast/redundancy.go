@@ -0,0 +1,361 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"live-video/config"
+	"live-video/pkg/background"
+	"live-video/pkg/captions"
+	"live-video/pkg/m3u8"
+)
+
+// Roles of the streams in a redundant pair
+const (
+	RolePrimary = "primary"
+	RoleBackup  = "backup"
+)
+
+// failbackDelay is how long the primary must produce segments again before
+// viewers are moved back to it
+const failbackDelay = 30 * time.Second
+
+// redundancyState tracks which stream of a redundant pair viewers are sent
+// to. It lives on the primary and is guarded by the primary's mutex.
+type redundancyState struct {
+	activeID      string
+	failovers     int
+	lastFailover  *time.Time
+	recoveredFrom time.Time // when the primary resumed while viewers were on the backup
+}
+
+// PlaybackSource is one ingest of a stream that viewers can be sent to
+type PlaybackSource struct {
+	Role          string     `json:"role"`
+	StreamID      string     `json:"stream_id"`
+	Live          bool       `json:"live"`
+	LastSegmentAt *time.Time `json:"last_segment_at,omitempty"`
+	Portrait      bool       `json:"portrait,omitempty"` // transcoded to a portrait ladder
+
+	AlternateAudio []config.AudioInput `json:"alternate_audio,omitempty"` // alternate audio renditions
+	Captions       []captions.Track    `json:"captions,omitempty"`        // live caption tracks
+	Ladder         []string            `json:"ladder,omitempty"`          // renditions of a running pipeline, else the default ladder's
+}
+
+// CreateRedundantPair creates a primary stream and a backup stream fed by a
+// second ingest. Viewers watch the primary and are failed over to the backup
+// when the primary stops producing segments.
+func (bm *BroadcastManager) CreateRedundantPair(videoURL, hlsPlaylistURL, gcsPath string) (*Stream, *Stream) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	primary := bm.newStream(uuid.New().String(), videoURL, hlsPlaylistURL, gcsPath)
+	backup := bm.newStream(uuid.New().String(), videoURL, hlsPlaylistURL, gcsPath)
+
+	primary.BackupID = backup.ID
+	primary.redundancy = &redundancyState{activeID: primary.ID}
+	backup.PrimaryID = primary.ID
+
+	bm.streams[primary.ID] = primary
+	bm.streams[backup.ID] = backup
+	return primary, backup
+}
+
+// ViewedStream returns the stream viewers of sourceID watch: the primary of
+// a backup ingest, else the stream itself
+func (bm *BroadcastManager) ViewedStream(sourceID string) (*Stream, error) {
+	stream, err := bm.GetStream(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if primaryID := stream.primaryID(); primaryID != "" {
+		if primary, err := bm.GetStream(primaryID); err == nil {
+			return primary, nil
+		}
+	}
+	return stream, nil
+}
+
+// PlaybackSources returns the ingests viewers of a stream can be sent to,
+// the active one first
+func (bm *BroadcastManager) PlaybackSources(streamID string) ([]PlaybackSource, error) {
+	stream, err := bm.GetStream(streamID)
+	if err != nil {
+		return nil, err
+	}
+	if primaryID := stream.primaryID(); primaryID != "" {
+		if primary, err := bm.GetStream(primaryID); err == nil {
+			stream = primary
+		}
+	}
+
+	sources := []PlaybackSource{stream.playbackSource(RolePrimary)}
+	if backupID := stream.backupID(); backupID != "" {
+		if backup, err := bm.GetStream(backupID); err == nil {
+			sources = append(sources, backup.playbackSource(RoleBackup))
+			if stream.ActiveStreamID() == backup.ID {
+				sources[0], sources[1] = sources[1], sources[0]
+			}
+		}
+	}
+	return sources, nil
+}
+
+// ActiveStreamID returns the stream whose output viewers of this stream
+// should watch: the stream itself unless it is a primary that failed over
+func (s *Stream) ActiveStreamID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.redundancy != nil && s.BackupID != "" {
+		return s.redundancy.activeID
+	}
+	return s.ID
+}
+
+// Backup returns the ID of the backup of a primary, if it has one
+func (s *Stream) Backup() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.BackupID
+}
+
+// StartFailoverMonitor periodically checks, in group, the segment
+// production of redundant pairs and moves viewers to the backup when the
+// primary has not produced a segment for stallTimeout
+func (bm *BroadcastManager) StartFailoverMonitor(group *background.Group, stallTimeout time.Duration) {
+	group.Every(stallTimeout/4, func(context.Context) {
+		for _, stream := range bm.ListStreams() {
+			backupID := stream.backupID()
+			if backupID == "" {
+				continue
+			}
+			if backup, err := bm.GetStream(backupID); err == nil {
+				stream.checkFailover(backup, stallTimeout)
+			}
+		}
+	})
+}
+
+// checkFailover switches viewers between a primary and its backup based on
+// which of them is still producing segments
+func (s *Stream) checkFailover(backup *Stream, stallTimeout time.Duration) {
+	primaryLive := s.producingSegments(stallTimeout)
+	backupLive := backup.producingSegments(stallTimeout)
+
+	s.mu.Lock()
+	state := s.redundancy
+	if state == nil {
+		s.mu.Unlock()
+		return
+	}
+
+	var switchTo, reason string
+	switch {
+	case state.activeID == s.ID && !primaryLive && backupLive:
+		switchTo, reason = backup.ID, "primary stalled"
+	case state.activeID == backup.ID && primaryLive && !backupLive:
+		switchTo, reason = s.ID, "backup stalled"
+	case state.activeID == backup.ID && primaryLive:
+		// Fail back only once the primary has been stable for a while
+		if state.recoveredFrom.IsZero() {
+			state.recoveredFrom = time.Now()
+		} else if time.Since(state.recoveredFrom) >= failbackDelay {
+			switchTo, reason = s.ID, "primary recovered"
+		}
+	case state.activeID == backup.ID:
+		state.recoveredFrom = time.Time{}
+	}
+
+	if switchTo == "" {
+		s.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	state.activeID = switchTo
+	state.recoveredFrom = time.Time{}
+	if switchTo == backup.ID {
+		state.failovers++
+		state.lastFailover = &now
+	}
+	s.mu.Unlock()
+
+	log.Printf("[Broadcast] Stream %s: %s, viewers moved to %s", s.ID, reason, switchTo)
+
+	// Tell connected viewers to reload the playback descriptor
+	msg, _ := json.Marshal(map[string]interface{}{
+		"type":             "failover",
+		"active_stream_id": switchTo,
+		"reason":           reason,
+		"playback_url":     fmt.Sprintf("/api/v1/streams/%s/playback", s.ID),
+	})
+	s.Broadcast(msg)
+}
+
+// producingSegments reports whether the stream is live and wrote an HLS
+// segment within stallTimeout
+func (s *Stream) producingSegments(stallTimeout time.Duration) bool {
+	if !s.isActive() {
+		return false
+	}
+	last := s.lastSegmentTime()
+	return last != nil && time.Since(*last) < stallTimeout
+}
+
+// lastSegmentTime returns when the stream last wrote a live HLS segment
+func (s *Stream) lastSegmentTime() *time.Time {
+	if s.workDir == nil {
+		return nil
+	}
+
+	var latest time.Time
+	filepath.WalkDir(s.workDir.StreamHLS(s.ID), func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || (!strings.HasSuffix(path, ".ts") && !strings.HasSuffix(path, ".m4s")) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+
+	if latest.IsZero() {
+		return nil
+	}
+	return &latest
+}
+
+func (s *Stream) playbackSource(role string) PlaybackSource {
+	last := s.lastSegmentTime()
+	orch := s.GetOrchestrator()
+	return PlaybackSource{
+		Role:          role,
+		StreamID:      s.ID,
+		Live:          s.isActive(),
+		LastSegmentAt: last,
+		Portrait:      orch != nil && orch.Portrait(),
+
+		AlternateAudio: s.alternateAudio(),
+		Captions:       s.captionTracks(),
+		Ladder:         s.ladder(),
+	}
+}
+
+// ladder returns the renditions the running pipeline offers, nil without one
+func (s *Stream) ladder() []string {
+	orch := s.GetOrchestrator()
+	if orch == nil || !orch.IsRunning() {
+		return nil
+	}
+	return orch.Ladder()
+}
+
+func (s *Stream) primaryID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.PrimaryID
+}
+
+func (s *Stream) backupID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.BackupID
+}
+
+// RedundancySnapshot is the redundant pair a stream belongs to. The active
+// stream and failovers are known to the primary only.
+type RedundancySnapshot struct {
+	Role            string     `json:"role"`
+	PrimaryStreamID string     `json:"primary_stream_id"`
+	BackupStreamID  string     `json:"backup_stream_id"`
+	ActiveStreamID  string     `json:"active_stream_id,omitempty"`
+	Failovers       int        `json:"failovers,omitempty"`
+	LastFailoverAt  *time.Time `json:"last_failover_at,omitempty"`
+}
+
+// redundancySnapshot describes the stream's redundant pair. Callers hold
+// s.mu.
+func (s *Stream) redundancySnapshot() *RedundancySnapshot {
+	switch {
+	case s.BackupID != "" && s.redundancy != nil:
+		return &RedundancySnapshot{
+			Role:            RolePrimary,
+			PrimaryStreamID: s.ID,
+			BackupStreamID:  s.BackupID,
+			ActiveStreamID:  s.redundancy.activeID,
+			Failovers:       s.redundancy.failovers,
+			LastFailoverAt:  copyTime(s.redundancy.lastFailover),
+		}
+	case s.PrimaryID != "":
+		return &RedundancySnapshot{
+			Role:            RoleBackup,
+			PrimaryStreamID: s.PrimaryID,
+			BackupStreamID:  s.ID,
+		}
+	}
+	return nil
+}
+
+// MasterPlaylist builds an HLS master playlist that lists every rendition
+// once per source, the active source first, so players that support
+// redundant streams can fall back on their own. variantURL returns the media
+// playlist URL of a rendition of a stream's live output. A non-empty ladder
+// limits the playlist to those rendition names. Sources with a Ladder list
+// only its renditions.
+func MasterPlaylist(sources []PlaybackSource, variantURL func(streamID, rendition string) string, ladder []string) string {
+	offered := make(map[string]bool, len(ladder))
+	for _, name := range ladder {
+		offered[name] = true
+	}
+
+	playlist := &m3u8.MasterPlaylist{Tags: []m3u8.Tag{
+		{Name: m3u8.TagVersion, Value: "3"},
+		{Name: m3u8.TagIndependentSegments},
+	}}
+
+	// Sources with alternate audio get an audio group each, and sources
+	// with caption tracks a subtitle group
+	groups := make([]string, len(sources))
+	subtitles := make([]string, len(sources))
+	for i, source := range sources {
+		if len(source.AlternateAudio) > 0 {
+			groups[i] = fmt.Sprintf("audio-%d", i)
+			playlist.Tags = append(playlist.Tags, audioGroup(groups[i], source, variantURL)...)
+		}
+		if len(source.Captions) > 0 {
+			subtitles[i] = fmt.Sprintf("subs-%d", i)
+			playlist.Tags = append(playlist.Tags, subtitleGroup(subtitles[i], source, variantURL)...)
+		}
+	}
+
+	cfg := config.DefaultFFmpegConfig()
+	for _, profile := range cfg.Profiles {
+		if len(offered) > 0 && !offered[profile.Name] {
+			continue
+		}
+		for i, source := range sources {
+			if source.Ladder != nil && !slices.Contains(source.Ladder, profile.Name) {
+				continue
+			}
+			cfg.Portrait = source.Portrait
+			attrs := m3u8.ParseAttributes(cfg.StreamInf(profile))
+			if groups[i] != "" {
+				attrs.Set("AUDIO", m3u8.Quote(groups[i]))
+			}
+			if subtitles[i] != "" {
+				attrs.Set("SUBTITLES", m3u8.Quote(subtitles[i]))
+			}
+			playlist.Variants = append(playlist.Variants, m3u8.Variant{Attributes: attrs, URI: variantURL(source.StreamID, profile.Name)})
+		}
+	}
+	return string(playlist.Encode())
+}
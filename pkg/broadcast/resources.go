@@ -0,0 +1,209 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Resource kinds, in teardown order: input first, so nothing new reaches the
+// pipeline, then the pipeline, which flushes its last segments, then files
+const (
+	ResourceIngest   = "ingest"   // WebRTC peer connection
+	ResourcePipeline = "pipeline" // transcoder and HLS uploader
+	ResourceWorkDir  = "work_dir" // local ingest and HLS files
+	ResourceOutput   = "output"   // live output in the bucket, released on delete only
+)
+
+var resourceOrder = map[string]int{
+	ResourceIngest:   0,
+	ResourcePipeline: 1,
+	ResourceWorkDir:  2,
+	ResourceOutput:   3,
+}
+
+// What started a teardown
+const (
+	TeardownStop    = "stop"
+	TeardownFailure = "failure"
+	TeardownDelete  = "delete"
+	TeardownRestart = "restart" // leftovers of a stream live when the server went down
+)
+
+// resourceTimeout bounds the release of a single resource
+const resourceTimeout = 30 * time.Second
+
+// CleanupStep is the release of one resource
+type CleanupStep struct {
+	Resource   string `json:"resource"`
+	Name       string `json:"name,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// CleanupReport lists what a teardown released, in order
+type CleanupReport struct {
+	StreamID string        `json:"stream_id"`
+	Trigger  string        `json:"trigger"`
+	At       time.Time     `json:"at"`
+	Steps    []CleanupStep `json:"steps"`
+	Failed   int           `json:"failed"`
+}
+
+// resource is something created for a stream that must be released
+type resource struct {
+	kind, name string
+	release    func(ctx context.Context) error
+	onDelete   bool // kept until the stream is deleted
+}
+
+// resourceSet tracks the resources a stream owns
+type resourceSet struct {
+	mu         sync.Mutex
+	items      []resource
+	lastReport *CleanupReport
+	tearing    sync.Mutex // serializes teardowns
+}
+
+// Own registers a resource of the stream, released when the stream stops,
+// fails or is deleted. A resource of the same kind and name replaces the
+// registered one.
+func (s *Stream) Own(kind, name string, release func(ctx context.Context) error) {
+	s.resources.add(resource{kind: kind, name: name, release: release})
+}
+
+// OwnUntilDelete registers a resource released only when the stream is
+// deleted, e.g. its output in the bucket
+func (s *Stream) OwnUntilDelete(kind, name string, release func(ctx context.Context) error) {
+	s.resources.add(resource{kind: kind, name: name, release: release, onDelete: true})
+}
+
+// Owned lists the kinds and names of the resources the stream holds
+func (s *Stream) Owned() []string {
+	s.resources.mu.Lock()
+	defer s.resources.mu.Unlock()
+	owned := make([]string, 0, len(s.resources.items))
+	for _, r := range s.resources.items {
+		owned = append(owned, r.kind+":"+r.name)
+	}
+	return owned
+}
+
+// LastCleanup returns the report of the stream's last teardown, if any
+func (s *Stream) LastCleanup() *CleanupReport {
+	s.resources.mu.Lock()
+	defer s.resources.mu.Unlock()
+	return s.resources.lastReport
+}
+
+func (rs *resourceSet) add(r resource) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for i, existing := range rs.items {
+		if existing.kind == r.kind && existing.name == r.name {
+			rs.items[i] = r
+			return
+		}
+	}
+	rs.items = append(rs.items, r)
+}
+
+// SetOutputCleaner makes deleting a stream delete its output in the bucket
+// with clean
+func (bm *BroadcastManager) SetOutputCleaner(clean func(ctx context.Context, streamID string) error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.outputCleaner = clean
+	for _, stream := range bm.streams {
+		bm.ownOutput(stream)
+	}
+}
+
+// ownOutput registers the bucket output of stream. Callers hold bm.mu.
+func (bm *BroadcastManager) ownOutput(stream *Stream) {
+	if clean := bm.outputCleaner; clean != nil {
+		stream.OwnUntilDelete(ResourceOutput, "bucket", func(ctx context.Context) error {
+			return clean(ctx, stream.ID)
+		})
+	}
+}
+
+// ownWorkDir registers the stream's local work directories
+func (s *Stream) ownWorkDir() {
+	if s.workDir == nil {
+		return
+	}
+	s.Own(ResourceWorkDir, "local", func(ctx context.Context) error {
+		return s.workDir.CleanupStream(s.ID)
+	})
+}
+
+// Teardown releases the stream's resources for trigger: ingest, pipeline,
+// work files, and on delete its output, the latest registered first within
+// a kind. Every resource is released once even if others fail.
+func (s *Stream) Teardown(ctx context.Context, trigger string) *CleanupReport {
+	return s.teardown(ctx, trigger, trigger == TeardownDelete)
+}
+
+func (s *Stream) teardown(ctx context.Context, trigger string, withOutput bool) *CleanupReport {
+	rs := &s.resources
+	rs.tearing.Lock()
+	defer rs.tearing.Unlock()
+
+	rs.mu.Lock()
+	var release, keep []resource
+	for _, r := range rs.items {
+		if r.onDelete && !withOutput {
+			keep = append(keep, r)
+		} else {
+			release = append(release, r)
+		}
+	}
+	rs.items = keep
+	rs.mu.Unlock()
+
+	// Latest first, then stably by kind
+	for i, j := 0, len(release)-1; i < j; i, j = i+1, j-1 {
+		release[i], release[j] = release[j], release[i]
+	}
+	sort.SliceStable(release, func(i, j int) bool {
+		return resourceOrder[release[i].kind] < resourceOrder[release[j].kind]
+	})
+
+	report := &CleanupReport{StreamID: s.ID, Trigger: trigger, At: time.Now(), Steps: []CleanupStep{}}
+	for _, r := range release {
+		step := CleanupStep{Resource: r.kind, Name: r.name}
+		start := time.Now()
+		if err := releaseResource(ctx, r); err != nil {
+			step.Error = err.Error()
+			report.Failed++
+			log.Printf("[Broadcast] Failed to release %s %s of stream %s: %v", r.kind, r.name, s.ID, err)
+		}
+		step.DurationMs = time.Since(start).Milliseconds()
+		report.Steps = append(report.Steps, step)
+	}
+
+	rs.mu.Lock()
+	rs.lastReport = report
+	rs.mu.Unlock()
+	if len(report.Steps) > 0 {
+		log.Printf("[Broadcast] Released %d resources of stream %s on %s (%d failed)", len(report.Steps), s.ID, trigger, report.Failed)
+	}
+	return report
+}
+
+// releaseResource releases r within resourceTimeout, turning a panic into an
+// error so the remaining resources are still released
+func releaseResource(ctx context.Context, r resource) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, resourceTimeout)
+	defer cancel()
+	return r.release(ctx)
+}
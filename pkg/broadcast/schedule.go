@@ -0,0 +1,30 @@
+package broadcast
+
+import "time"
+
+// SetSchedule sets when the stream is announced to start; nil clears it.
+// Scheduled streams that have not started are primed with a slate.
+func (s *Stream) SetSchedule(at *time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at != nil {
+		utc := at.UTC()
+		at = &utc
+	}
+	s.ScheduledAt = at
+}
+
+// Schedule returns when the stream is announced to start, or nil
+func (s *Stream) Schedule() *time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ScheduledAt
+}
+
+// AwaitingStart reports whether the stream is scheduled and has neither
+// started nor had its ingest pipeline started yet
+func (s *Stream) AwaitingStart() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ScheduledAt != nil && s.Status == StatusIdle && s.orchestrator == nil
+}
@@ -0,0 +1,143 @@
+package broadcast
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrSessionExpired is returned when a viewer resumes with a token whose
+// session has ended or never existed
+var ErrSessionExpired = errors.New("viewer session expired")
+
+// SetViewerSessionTimeout sets how long a disconnected viewer keeps its
+// session, so a reconnect within the timeout resumes it. Zero ends sessions
+// on disconnect.
+func (bm *BroadcastManager) SetViewerSessionTimeout(timeout time.Duration) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	bm.sessionTimeout = timeout
+	for _, stream := range bm.streams {
+		stream.mu.Lock()
+		stream.sessionTimeout = timeout
+		stream.mu.Unlock()
+	}
+}
+
+// SessionTimeout returns how long disconnected viewers keep their session
+func (s *Stream) SessionTimeout() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sessionTimeout
+}
+
+// ResumeViewer reconnects the viewer session holding token. The viewer keeps
+// its ID, connection time and slot, or its place in the waiting room, and
+// gets a new DataChan. A connection still open for the session is closed.
+// The returned position is as for JoinViewer.
+func (s *Stream) ResumeViewer(token string) (*Viewer, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token == "" {
+		return nil, 0, ErrSessionExpired
+	}
+	for _, old := range s.viewers {
+		if old.Token == token {
+			viewer := s.reconnect(old)
+			s.viewers[viewer.ID] = viewer
+			return viewer, 0, nil
+		}
+	}
+	for i, old := range s.waiting {
+		if old.Token == token {
+			viewer := s.reconnect(old)
+			s.waiting[i] = viewer
+			viewer.send(map[string]interface{}{"type": "waiting", "position": i + 1})
+			return viewer, i + 1, nil
+		}
+	}
+	return nil, 0, ErrSessionExpired
+}
+
+// reconnect replaces a viewer with a new connection of the same session.
+// Callers hold s.mu.
+func (s *Stream) reconnect(old *Viewer) *Viewer {
+	if old.expiry != nil {
+		old.expiry.Stop()
+	}
+	old.close()
+	return &Viewer{
+		ID:          old.ID,
+		Token:       old.Token,
+		ConnectedAt: old.ConnectedAt,
+		Reconnects:  old.Reconnects + 1,
+		DataChan:    make(chan []byte, 10),
+	}
+}
+
+// DisconnectViewer ends a viewer connection. The viewer keeps its session,
+// slot and waiting room place for the session timeout while the stream is
+// live, and is removed when it does not resume in time. Nothing happens when
+// the session was already resumed by a newer connection. It reports whether
+// the session ended.
+func (s *Stream) DisconnectViewer(viewer *Viewer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isCurrent(viewer) {
+		return false
+	}
+	if s.sessionTimeout <= 0 || !s.Status.Active() {
+		s.removeViewer(viewer.ID)
+		return true
+	}
+
+	viewer.close()
+	viewer.disconnectedAt = time.Now()
+	viewer.expiry = time.AfterFunc(s.sessionTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.isCurrent(viewer) {
+			s.removeViewer(viewer.ID)
+		}
+	})
+	return false
+}
+
+// isCurrent reports whether viewer is the latest connection of its session.
+// Callers hold s.mu.
+func (s *Stream) isCurrent(viewer *Viewer) bool {
+	if s.viewers[viewer.ID] == viewer {
+		return true
+	}
+	for _, waiting := range s.waiting {
+		if waiting == viewer {
+			return true
+		}
+	}
+	return false
+}
+
+// disconnectedViewers counts viewers waiting to resume. Callers hold s.mu.
+func (s *Stream) disconnectedViewers() int {
+	count := 0
+	for _, viewer := range s.viewers {
+		if !viewer.disconnectedAt.IsZero() {
+			count++
+		}
+	}
+	for _, viewer := range s.waiting {
+		if !viewer.disconnectedAt.IsZero() {
+			count++
+		}
+	}
+	return count
+}
+
+// newSessionToken returns the secret a viewer resumes its session with
+func newSessionToken() string {
+	return uuid.New().String()
+}
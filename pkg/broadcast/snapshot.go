@@ -0,0 +1,156 @@
+package broadcast
+
+import (
+	"slices"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/orchestrator"
+	"live-video/pkg/webrtc"
+)
+
+// SnapshotVersion is the version of the StreamSnapshot format, raised when
+// a field is removed or changes meaning. Added fields keep the version.
+const SnapshotVersion = 1
+
+// StreamSnapshot is a copy of a stream's state taken under its lock, with its
+// pipeline's. It shares nothing with the stream, so it can be kept and
+// serialized while the stream changes.
+type StreamSnapshot struct {
+	Version     int          `json:"snapshot_version"`
+	ID          string       `json:"id"`
+	Status      StreamStatus `json:"status"`
+	ViewerCount int          `json:"viewer_count"`
+	CreatedAt   time.Time    `json:"created_at"`
+	VideoURL    string       `json:"video_url"` // the HLS playlist when there is one
+	GCSPath     string       `json:"gcs_path"`
+
+	Name        string       `json:"name,omitempty"`
+	Description string       `json:"description,omitempty"`
+	EventID     string       `json:"event_id,omitempty"`
+	ScheduledAt *time.Time   `json:"scheduled_at,omitempty"`
+	EmbedOnly   bool         `json:"embed_only,omitempty"`
+	ContentGate *ContentGate `json:"content_gate,omitempty"`
+
+	ViewerLimit         *ViewerLimitSnapshot    `json:"viewer_limit,omitempty"`
+	ReconnectingViewers int                     `json:"reconnecting_viewers,omitempty"`
+	Previewers          int                     `json:"previewers,omitempty"` // not in viewer_count
+	PlaybackCheck       *PlaybackCheck          `json:"playback_check,omitempty"`
+	Redundancy          *RedundancySnapshot     `json:"redundancy,omitempty"`
+	PlaylistWindows     map[string]float64      `json:"playlist_windows,omitempty"` // seconds by viewer class
+	ClipPolicy          map[string]float64      `json:"clip_policy,omitempty"`      // seconds by viewer class
+	Inputs              *InputsSnapshot         `json:"inputs,omitempty"`
+	AudioInputs         []config.AudioInput     `json:"audio_inputs,omitempty"`
+	Captions            *config.CaptionSettings `json:"captions,omitempty"`
+	Priority            string                  `json:"priority,omitempty"`
+	ContentType         string                  `json:"content_type,omitempty"`
+	ChunkIngest         *ChunkStats             `json:"chunk_ingest,omitempty"`
+
+	HLSPlaylistURL   string `json:"hls_playlist_url,omitempty"`
+	OriginalVideoURL string `json:"original_video_url,omitempty"`
+
+	Orchestrator  *orchestrator.Snapshot `json:"orchestrator,omitempty"`
+	IngestBitrate *webrtc.BitrateStats   `json:"ingest_bitrate,omitempty"`
+	Encoder       *EncoderTelemetry      `json:"encoder,omitempty"`
+
+	StoppedAt   *time.Time     `json:"stopped_at,omitempty"`
+	StopReason  string         `json:"stop_reason,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Resources   []string       `json:"resources,omitempty"`
+	LastCleanup *CleanupReport `json:"last_cleanup,omitempty"`
+	LastInputAt *time.Time     `json:"last_input_at,omitempty"` // while streaming
+
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	UptimeSeconds   float64    `json:"uptime_seconds,omitempty"`
+	CurrentPosition *float64   `json:"current_position,omitempty"` // in the looped source video
+	VideoDuration   float64    `json:"video_duration,omitempty"`
+}
+
+// Snapshot returns a copy of the stream's state for stats and listings
+func (s *Stream) Snapshot() *StreamSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap := &StreamSnapshot{
+		Version:             SnapshotVersion,
+		ID:                  s.ID,
+		Status:              s.Status,
+		ViewerCount:         s.ViewerCount,
+		CreatedAt:           s.CreatedAt,
+		VideoURL:            s.VideoURL,
+		GCSPath:             s.GCSPath,
+		Name:                s.Name,
+		Description:         s.Description,
+		EventID:             s.EventID,
+		ScheduledAt:         copyTime(s.ScheduledAt),
+		EmbedOnly:           s.EmbedOnly,
+		ViewerLimit:         s.viewerLimitSnapshot(),
+		ReconnectingViewers: s.disconnectedViewers(),
+		Previewers:          len(s.previews),
+		PlaybackCheck:       s.playbackCheck.copy(),
+		Redundancy:          s.redundancySnapshot(),
+		PlaylistWindows:     s.playlistWindowStats(),
+		ClipPolicy:          s.clipPolicyStats(),
+		Inputs:              s.inputsSnapshot(),
+		AudioInputs:         slices.Clone(s.audioInputs),
+		Captions:            s.captionsSnapshot(),
+		Priority:            s.priority,
+		ContentType:         s.contentType,
+		StoppedAt:           copyTime(s.StoppedAt),
+		StopReason:          s.StopReason,
+		Error:               s.Error,
+		Resources:           s.Owned(),
+		StartedAt:           copyTime(s.StartedAt),
+	}
+	if s.gate != (ContentGate{}) {
+		gate := s.gate
+		snap.ContentGate = &gate
+	}
+
+	// Prefer HLS playlist URL for streaming
+	if s.HLSPlaylistURL != "" {
+		snap.VideoURL = s.HLSPlaylistURL
+		snap.HLSPlaylistURL = s.HLSPlaylistURL
+		snap.OriginalVideoURL = s.VideoURL
+	}
+	if chunks := s.ChunkStats(); chunks.Received > 0 {
+		snap.ChunkIngest = &chunks
+	}
+	if s.orchestrator != nil {
+		snap.Orchestrator = s.orchestrator.Snapshot()
+	}
+	if s.webrtcIngest != nil {
+		bitrate := s.webrtcIngest.GetBitrateStats()
+		snap.IngestBitrate = &bitrate
+	}
+	snap.Encoder = s.encoder.copy()
+	if cleanup := s.LastCleanup(); cleanup != nil {
+		report := *cleanup
+		report.Steps = slices.Clone(cleanup.Steps)
+		snap.LastCleanup = &report
+	}
+	if s.Status == StatusStreaming {
+		last := s.lastInputAt()
+		snap.LastInputAt = &last
+	}
+	if s.StartedAt != nil {
+		snap.UptimeSeconds = time.Since(*s.StartedAt).Seconds()
+
+		// Current position in the video, looping
+		if s.VideoDuration > 0 {
+			position := float64(int(snap.UptimeSeconds) % int(s.VideoDuration))
+			snap.CurrentPosition = &position
+		}
+	}
+	snap.VideoDuration = s.VideoDuration
+	return snap
+}
+
+// copyTime returns a copy of t that doesn't alias it
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
@@ -0,0 +1,166 @@
+package broadcast
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"live-video/pkg/orchestrator"
+)
+
+// DefaultSRTLatency is the receive buffer SRT retransmits lost packets
+// within, unless configured. Encoders on lossy links want about four times
+// their round trip time.
+const DefaultSRTLatency = 120 * time.Millisecond
+
+// ErrSRTDisabled is returned when SRT ingest is asked for but not configured
+var ErrSRTDisabled = errors.New("SRT ingest is not enabled on this server")
+
+// SRTConfig configures SRT ingest. Every stream with SRT listens on a port
+// of its own from the range, so the range must be open to encoders.
+type SRTConfig struct {
+	Host      string // host name or address encoders connect to
+	FirstPort int
+	LastPort  int
+	Latency   time.Duration // 0 = DefaultSRTLatency
+}
+
+// SRTIngest is the SRT listener of a stream
+type SRTIngest struct {
+	Port       int           `json:"port"`
+	Passphrase string        `json:"passphrase"`
+	Latency    time.Duration `json:"latency"`
+}
+
+// SRTEndpoint is where an encoder sends a stream over SRT, in caller mode
+type SRTEndpoint struct {
+	URL        string `json:"url"`
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	Passphrase string `json:"passphrase"`
+	LatencyMS  int64  `json:"latency_ms"`
+}
+
+// input is the pipeline input listening for the encoder. FFmpeg takes the
+// latency in microseconds.
+func (i *SRTIngest) input() orchestrator.Input {
+	query := url.Values{}
+	query.Set("mode", "listener")
+	query.Set("passphrase", i.Passphrase)
+	query.Set("latency", strconv.FormatInt(i.Latency.Microseconds(), 10))
+	return orchestrator.Input{
+		Kind: orchestrator.InputSRT,
+		URL:  fmt.Sprintf("srt://0.0.0.0:%d?%s", i.Port, query.Encode()),
+	}
+}
+
+// SetSRT enables SRT ingest for streams that ask for it
+func (bm *BroadcastManager) SetSRT(config SRTConfig) {
+	if config.Latency <= 0 {
+		config.Latency = DefaultSRTLatency
+	}
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.srt = config
+}
+
+// SRTEnabled reports whether streams can take SRT ingest
+func (bm *BroadcastManager) SRTEnabled() bool {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	return bm.srt.FirstPort > 0
+}
+
+// EnableSRT gives a stream an SRT listener on a free port of the range with
+// a new passphrase, or keeps the one it has. The listener runs while the
+// stream's pipeline does, after the stream's other inputs or WebRTC.
+func (bm *BroadcastManager) EnableSRT(stream *Stream) (*SRTEndpoint, error) {
+	bm.mu.Lock()
+	if bm.srt.FirstPort <= 0 {
+		bm.mu.Unlock()
+		return nil, ErrSRTDisabled
+	}
+	if stream.SRT() != nil {
+		bm.mu.Unlock()
+		return bm.SRTEndpoint(stream), nil
+	}
+
+	used := make(map[int]bool)
+	for _, s := range bm.streams {
+		if ingest := s.SRT(); ingest != nil {
+			used[ingest.Port] = true
+		}
+	}
+	port := 0
+	for p := bm.srt.FirstPort; p <= bm.srt.LastPort; p++ {
+		if !used[p] {
+			port = p
+			break
+		}
+	}
+	if port == 0 {
+		bm.mu.Unlock()
+		return nil, fmt.Errorf("all %d SRT ports are in use", bm.srt.LastPort-bm.srt.FirstPort+1)
+	}
+	passphrase, err := srtPassphrase()
+	if err != nil {
+		bm.mu.Unlock()
+		return nil, err
+	}
+	// Set under bm.mu so no other stream takes the port
+	stream.setSRT(&SRTIngest{Port: port, Passphrase: passphrase, Latency: bm.srt.Latency})
+	bm.mu.Unlock()
+
+	stream.changed(stream)
+	stream.applyInputs()
+	return bm.SRTEndpoint(stream), nil
+}
+
+// SRTEndpoint returns where encoders send a stream over SRT, nil if it has
+// no SRT listener
+func (bm *BroadcastManager) SRTEndpoint(stream *Stream) *SRTEndpoint {
+	ingest := stream.SRT()
+	if ingest == nil {
+		return nil
+	}
+	bm.mu.RLock()
+	host := bm.srt.Host
+	bm.mu.RUnlock()
+	return &SRTEndpoint{
+		URL:        fmt.Sprintf("srt://%s:%d?passphrase=%s", host, ingest.Port, url.QueryEscape(ingest.Passphrase)),
+		Host:       host,
+		Port:       ingest.Port,
+		Passphrase: ingest.Passphrase,
+		LatencyMS:  ingest.Latency.Milliseconds(),
+	}
+}
+
+// srtPassphrase returns a random passphrase; SRT takes 10 to 79 characters
+func srtPassphrase() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate SRT passphrase: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SRT returns the stream's SRT listener, nil for none
+func (s *Stream) SRT() *SRTIngest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.srt == nil {
+		return nil
+	}
+	ingest := *s.srt
+	return &ingest
+}
+
+func (s *Stream) setSRT(ingest *SRTIngest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.srt = ingest
+}
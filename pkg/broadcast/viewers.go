@@ -0,0 +1,157 @@
+package broadcast
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrStreamFull is returned when a stream is at its viewer limit and has no
+// waiting room
+var ErrStreamFull = errors.New("stream is at its viewer limit")
+
+// SetViewerLimit caps the number of connected viewers. Zero removes the
+// limit. With waitingRoom set, viewers over the limit are queued and admitted
+// in order as slots free; otherwise they are refused.
+func (s *Stream) SetViewerLimit(maxViewers int, waitingRoom bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.MaxViewers = maxViewers
+	s.WaitingRoom = waitingRoom
+	if !waitingRoom {
+		// Nobody can wait anymore; let in who fits and turn away the rest
+		s.admitWaiting()
+		for _, viewer := range s.waiting {
+			viewer.send(map[string]interface{}{"type": "rejected", "reason": ErrStreamFull.Error()})
+			viewer.close()
+		}
+		s.waiting = nil
+		return
+	}
+	s.admitWaiting()
+}
+
+// JoinViewer connects a viewer, placing it in the waiting room when the
+// stream is full. The returned position is 0 for an admitted viewer and the
+// 1-based queue position otherwise. Waiting viewers get "waiting" messages
+// with their position and an "admitted" message on their DataChan.
+func (s *Stream) JoinViewer() (*Viewer, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	viewer := &Viewer{
+		ID:          uuid.New().String(),
+		Token:       newSessionToken(),
+		ConnectedAt: time.Now(),
+		DataChan:    make(chan []byte, 10),
+	}
+
+	if !s.full() && len(s.waiting) == 0 {
+		s.viewers[viewer.ID] = viewer
+		s.ViewerCount = len(s.viewers)
+		return viewer, 0, nil
+	}
+	if !s.WaitingRoom {
+		return nil, 0, ErrStreamFull
+	}
+
+	s.waiting = append(s.waiting, viewer)
+	position := len(s.waiting)
+	viewer.send(map[string]interface{}{"type": "waiting", "position": position})
+	return viewer, position, nil
+}
+
+// full reports whether the viewer limit is reached. Callers hold s.mu.
+func (s *Stream) full() bool {
+	return s.MaxViewers > 0 && len(s.viewers) >= s.MaxViewers
+}
+
+// admitWaiting moves waiting viewers into free slots and tells the rest
+// their new position. Callers hold s.mu.
+func (s *Stream) admitWaiting() {
+	if len(s.waiting) == 0 {
+		return
+	}
+
+	admitted := 0
+	for admitted < len(s.waiting) && !s.full() {
+		viewer := s.waiting[admitted]
+		s.viewers[viewer.ID] = viewer
+		viewer.send(map[string]interface{}{"type": "admitted"})
+		admitted++
+	}
+	s.ViewerCount = len(s.viewers)
+	if admitted == 0 {
+		return
+	}
+
+	s.waiting = append(s.waiting[:0], s.waiting[admitted:]...)
+	for i, viewer := range s.waiting {
+		viewer.send(map[string]interface{}{"type": "waiting", "position": i + 1})
+	}
+}
+
+// removeWaiting drops a viewer from the waiting room. Callers hold s.mu.
+func (s *Stream) removeWaiting(viewerID string) bool {
+	for i, viewer := range s.waiting {
+		if viewer.ID != viewerID {
+			continue
+		}
+		viewer.close()
+		s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+		for j := i; j < len(s.waiting); j++ {
+			s.waiting[j].send(map[string]interface{}{"type": "waiting", "position": j + 1})
+		}
+		return true
+	}
+	return false
+}
+
+// ViewerLimitSnapshot is the viewer limit of a stream
+type ViewerLimitSnapshot struct {
+	MaxViewers     int  `json:"max_viewers"`
+	WaitingRoom    bool `json:"waiting_room"`
+	WaitingViewers int  `json:"waiting_viewers"`
+}
+
+// viewerLimitSnapshot describes the viewer limit. Callers hold s.mu.
+func (s *Stream) viewerLimitSnapshot() *ViewerLimitSnapshot {
+	if s.MaxViewers <= 0 && len(s.waiting) == 0 {
+		return nil
+	}
+	return &ViewerLimitSnapshot{
+		MaxViewers:     s.MaxViewers,
+		WaitingRoom:    s.WaitingRoom,
+		WaitingViewers: len(s.waiting),
+	}
+}
+
+// send queues a control message for the viewer without blocking
+func (v *Viewer) send(msg map[string]interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.closed {
+		return
+	}
+	select {
+	case v.DataChan <- data:
+	default:
+	}
+}
+
+func (v *Viewer) close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.closed {
+		close(v.DataChan)
+		v.closed = true
+	}
+}
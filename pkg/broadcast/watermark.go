@@ -0,0 +1,18 @@
+package broadcast
+
+// SetWatermark turns forensic watermarking of the stream on or off. It
+// applies to the renditions from the next pipeline start on.
+func (s *Stream) SetWatermark(enabled bool) {
+	s.mu.Lock()
+	s.watermark = enabled
+	s.mu.Unlock()
+	s.changed(s)
+}
+
+// Watermark reports whether the stream serves every playback session its
+// own sequence of marked and unmarked segments
+func (s *Stream) Watermark() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.watermark
+}
@@ -0,0 +1,83 @@
+package broadcast
+
+import (
+	"fmt"
+	"time"
+)
+
+// Built-in viewer classes. Embed tokens can name other classes.
+const (
+	ViewerClassAnonymous     = "anonymous"
+	ViewerClassAuthenticated = "authenticated"
+)
+
+// maxPlaylistWindow caps the DVR window of a class
+const maxPlaylistWindow = 6 * time.Hour
+
+// SetPlaylistWindows sets how far behind the live edge each viewer class can
+// seek, by class name. 0 serves only the live edge; classes without a window
+// get the whole live playlist. An empty map serves everyone the whole
+// playlist.
+func (s *Stream) SetPlaylistWindows(windows map[string]time.Duration) error {
+	copied := make(map[string]time.Duration, len(windows))
+	for class, window := range windows {
+		if class == "" {
+			return fmt.Errorf("viewer class must not be empty")
+		}
+		if window < 0 || window > maxPlaylistWindow {
+			return fmt.Errorf("window of %s must be between 0 and %s", class, maxPlaylistWindow)
+		}
+		copied[class] = window
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(copied) == 0 {
+		copied = nil
+	}
+	s.playlistWindows = copied
+	return nil
+}
+
+// PlaylistWindow returns the window of a viewer class, and false when the
+// class is served the whole playlist
+func (s *Stream) PlaylistWindow(class string) (time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	window, ok := s.playlistWindows[class]
+	return window, ok
+}
+
+// HasPlaylistWindows reports whether any viewer class has a window, so
+// playlists must go through the rewriter
+func (s *Stream) HasPlaylistWindows() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.playlistWindows) > 0
+}
+
+// MaxPlaylistWindow returns the longest window of any class, which the live
+// playlist must hold
+func (s *Stream) MaxPlaylistWindow() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var longest time.Duration
+	for _, window := range s.playlistWindows {
+		if window > longest {
+			longest = window
+		}
+	}
+	return longest
+}
+
+// playlistWindowStats describes the windows in seconds. Callers hold s.mu.
+func (s *Stream) playlistWindowStats() map[string]float64 {
+	if len(s.playlistWindows) == 0 {
+		return nil
+	}
+	stats := make(map[string]float64, len(s.playlistWindows))
+	for class, window := range s.playlistWindows {
+		stats[class] = window.Seconds()
+	}
+	return stats
+}
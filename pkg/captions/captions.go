@@ -0,0 +1,330 @@
+// Package captions transcribes the speech of live streams with a speech
+// recognition service and publishes WebVTT caption tracks next to the
+// stream's renditions: one per language detected in the speech, and one per
+// language the captions are translated to
+package captions
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/m3u8"
+	"live-video/pkg/vod"
+)
+
+// maxTracks bounds the caption tracks of a stream, so speech misdetected as
+// ever more languages doesn't add tracks without end
+const maxTracks = 8
+
+// Publish stores a file of a caption track under the stream's live folder,
+// name being relative to it
+type Publish func(ctx context.Context, name string, data []byte, contentType string) error
+
+// Options configure the captions of one run of a stream's pipeline
+type Options struct {
+	Recognizer Recognizer
+	Translator Translator // nil to only caption the languages spoken
+	Languages  []string   // translated to, normalized by config.ValidateCaptions
+	Publish    Publish
+
+	TargetDuration int // seconds, of the stream's segments
+	PlaylistSize   int // segments kept in each caption playlist
+}
+
+// Track is a caption track of a stream
+type Track struct {
+	Language   string `json:"language"`
+	Translated bool   `json:"translated"` // made by translation rather than heard
+}
+
+// Rendition returns the rendition name of the track
+func (t Track) Rendition() string {
+	return config.CaptionRendition(t.Language)
+}
+
+// Name returns how players label the track
+func (t Track) Name() string {
+	if t.Translated {
+		return t.Language + " (translated)"
+	}
+	return t.Language
+}
+
+// track is a caption track and the segments of its playlist window
+type track struct {
+	Track
+	segments []segment
+}
+
+type segment struct {
+	sequence int
+	duration float64
+}
+
+// Captioner captions one run of a stream's pipeline
+type Captioner struct {
+	opts Options
+
+	mu     sync.Mutex
+	tracks []*track // in the order they began
+}
+
+// New creates the captioner of a pipeline run. Tracks of the languages
+// translated to begin with the first segment.
+func New(opts Options) *Captioner {
+	c := &Captioner{opts: opts}
+	if opts.Translator != nil {
+		for _, language := range opts.Languages {
+			c.tracks = append(c.tracks, &track{Track: Track{Language: language, Translated: true}})
+		}
+	}
+	return c
+}
+
+// Tracks returns the caption tracks published so far
+func (c *Captioner) Tracks() []Track {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tracks := make([]Track, 0, len(c.tracks))
+	for _, t := range c.tracks {
+		if len(t.segments) > 0 {
+			tracks = append(tracks, t.Track)
+		}
+	}
+	return tracks
+}
+
+// Run captions the segments added to the media playlist in dir, a rendition
+// folder of the pipeline's output, until ctx is done. Captioning starts at
+// the newest segment when Run starts.
+func (c *Captioner) Run(ctx context.Context, dir string) {
+	ticker := time.NewTicker(time.Duration(max(c.opts.TargetDuration, 1)) * time.Second / 2)
+	defer ticker.Stop()
+
+	next := -1 // media sequence of the next segment to caption
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		data, err := os.ReadFile(filepath.Join(dir, vod.PlaylistName))
+		if err != nil {
+			continue
+		}
+		first := vod.MediaSequence(data)
+		segments, initURI := vod.Segments(data)
+		initPath := ""
+		if initURI != "" {
+			initPath = filepath.Join(dir, filepath.FromSlash(initURI))
+		}
+		if next < 0 && len(segments) > 0 {
+			next = first + len(segments) - 1
+		}
+		for i, seg := range segments {
+			sequence := first + i
+			if sequence < next {
+				continue
+			}
+			err := c.caption(ctx, sequence, seg.Duration, filepath.Join(dir, filepath.FromSlash(seg.URI)), initPath)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil && !failing:
+				log.Printf("[Captions] Failed to caption %s, segments stay without captions until it recovers: %v", dir, err)
+				failing = true
+			case err == nil && failing:
+				log.Printf("[Captions] Captioning %s again", dir)
+				failing = false
+			}
+			next = sequence + 1
+		}
+	}
+}
+
+// caption transcribes a segment and publishes it to every track. Tracks get
+// a segment without cues when the speech can't be transcribed or
+// translated, so their playlists keep in step with the stream's.
+func (c *Captioner) caption(ctx context.Context, sequence int, duration float64, segmentPath, initPath string) error {
+	wav, start, err := extractAudio(ctx, segmentPath, initPath)
+	var transcript *Transcript
+	if err == nil {
+		transcript, err = c.opts.Recognizer.Recognize(ctx, wav)
+	}
+	spoken := ""
+	if transcript != nil {
+		if language, ok := config.NormalizeLanguage(transcript.Language); ok {
+			spoken = language
+		}
+	}
+
+	c.mu.Lock()
+	if spoken != "" && c.find(spoken) == nil && len(c.tracks) < maxTracks {
+		c.tracks = append(c.tracks, &track{Track: Track{Language: spoken}})
+	}
+	tracks := append([]*track(nil), c.tracks...)
+	c.mu.Unlock()
+
+	for _, t := range tracks {
+		var cues []Cue
+		switch {
+		case transcript == nil || len(transcript.Cues) == 0:
+		case t.Language == spoken:
+			cues = transcript.Cues
+		case c.opts.Translator != nil && spoken != "":
+			texts := make([]string, len(transcript.Cues))
+			for i, cue := range transcript.Cues {
+				texts[i] = cue.Text
+			}
+			translated, terr := c.opts.Translator.Translate(ctx, spoken, t.Language, texts)
+			if terr != nil {
+				err = terr
+				break
+			}
+			cues = make([]Cue, len(transcript.Cues))
+			for i, cue := range transcript.Cues {
+				cues[i] = Cue{Start: cue.Start, End: cue.End, Text: translated[i]}
+			}
+		}
+		if perr := c.publish(ctx, t, sequence, duration, WebVTT(cues, start)); perr != nil {
+			return perr
+		}
+	}
+	return err
+}
+
+// find returns the track of a language. Callers hold c.mu.
+func (c *Captioner) find(language string) *track {
+	for _, t := range c.tracks {
+		if t.Language == language {
+			return t
+		}
+	}
+	return nil
+}
+
+// publish stores a segment of a track and the track's playlist
+func (c *Captioner) publish(ctx context.Context, t *track, sequence int, duration float64, vtt []byte) error {
+	name := path.Join(t.Rendition(), segmentName(sequence))
+	if err := c.opts.Publish(ctx, name, vtt, "text/vtt"); err != nil {
+		return fmt.Errorf("failed to publish %s: %w", name, err)
+	}
+
+	c.mu.Lock()
+	t.segments = append(t.segments, segment{sequence: sequence, duration: duration})
+	if excess := len(t.segments) - max(c.opts.PlaylistSize, 1); excess > 0 {
+		t.segments = t.segments[excess:]
+	}
+	playlist := c.playlist(t)
+	c.mu.Unlock()
+
+	name = path.Join(t.Rendition(), vod.PlaylistName)
+	if err := c.opts.Publish(ctx, name, playlist, "application/vnd.apple.mpegurl"); err != nil {
+		return fmt.Errorf("failed to publish %s: %w", name, err)
+	}
+	return nil
+}
+
+// playlist returns the media playlist of a track's window. Callers hold
+// c.mu.
+func (c *Captioner) playlist(t *track) []byte {
+	playlist := &m3u8.MediaPlaylist{MediaSequence: t.segments[0].sequence}
+	for _, s := range t.segments {
+		playlist.Segments = append(playlist.Segments, m3u8.Segment{URI: segmentName(s.sequence), Duration: s.duration})
+	}
+	playlist.Header = []m3u8.Tag{
+		{Name: m3u8.TagVersion, Value: "3"},
+		{Name: m3u8.TagTargetDuration, Value: strconv.Itoa(max(c.opts.TargetDuration, m3u8.TargetDuration(playlist.Segments)))},
+	}
+	return playlist.Encode()
+}
+
+func segmentName(sequence int) string {
+	return fmt.Sprintf("segment_%05d.vtt", sequence)
+}
+
+// WebVTT returns a caption segment of cues, timed from the start of a media
+// segment whose first presentation timestamp is start seconds
+func WebVTT(cues []Cue, start float64) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n")
+	// Cue times are local to the segment, mapped onto its MPEG-TS clock
+	fmt.Fprintf(&b, "X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n", int64(start*90000)%(1<<33))
+	for _, cue := range cues {
+		text := cueText(cue.Text)
+		if text == "" || cue.End <= cue.Start {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s --> %s\n%s\n", timestamp(cue.Start), timestamp(cue.End), text)
+	}
+	return b.Bytes()
+}
+
+// cueText escapes the markup of a cue's text and drops blank lines, which
+// would end the cue
+var cueEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "-->", "--&gt;")
+
+func cueText(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, cueEscaper.Replace(line))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// timestamp formats seconds as hh:mm:ss.ttt
+func timestamp(seconds float64) string {
+	millis := int64(max(seconds, 0)*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
+
+// extractAudio returns the audio of a segment as 16 kHz mono WAV, and the
+// presentation timestamp the segment starts at. fMP4 segments are read after
+// their initialization segment, initPath.
+func extractAudio(ctx context.Context, segmentPath, initPath string) ([]byte, float64, error) {
+	input := segmentPath
+	var media []byte
+	if initPath != "" {
+		for _, part := range []string{initPath, segmentPath} {
+			data, err := os.ReadFile(part)
+			if err != nil {
+				return nil, 0, err
+			}
+			media = append(media, data...)
+		}
+		input = "pipe:0"
+	}
+
+	probe := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries", "format=start_time",
+		"-of", "default=noprint_wrappers=1:nokey=1", input)
+	probe.Stdin = bytes.NewReader(media)
+	out, err := probe.Output()
+	if err != nil {
+		return nil, 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	start, _ := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+
+	var wav, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-loglevel", "error", "-i", input,
+		"-vn", "-ac", "1", "-ar", "16000", "-f", "wav", "pipe:1")
+	cmd.Stdin = bytes.NewReader(media)
+	cmd.Stdout, cmd.Stderr = &wav, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, 0, fmt.Errorf("audio extraction failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return wav.Bytes(), start, nil
+}
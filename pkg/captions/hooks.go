@@ -0,0 +1,119 @@
+package captions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxResponse bounds what is read of a hook's response
+const maxResponse = 1 << 20
+
+// Cue is text spoken from Start to End seconds into the audio transcribed
+type Cue struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// Transcript is what a recognizer heard in a stretch of audio, and the
+// language it was spoken in
+type Transcript struct {
+	Language string `json:"language"`
+	Cues     []Cue  `json:"cues"`
+}
+
+// Recognizer transcribes speech and detects its language
+type Recognizer interface {
+	Recognize(ctx context.Context, wav []byte) (*Transcript, error)
+}
+
+// Translator translates caption texts between languages
+type Translator interface {
+	Translate(ctx context.Context, from, to string, texts []string) ([]string, error)
+}
+
+// hook posts to an HTTP endpoint of a speech or translation service
+type hook struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newHook(url, token string) hook {
+	return hook{url: url, token: token, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// post sends body and decodes the JSON response into out
+func (h hook) post(ctx context.Context, body []byte, contentType string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", h.url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponse)).Decode(out)
+}
+
+// HTTPRecognizer sends 16 kHz mono WAV audio to a speech recognition service
+// and reads back {"language": "en", "cues": [{"start": 0.4, "end": 2.1,
+// "text": "..."}]}, cue times in seconds from the start of the audio
+type HTTPRecognizer struct {
+	hook hook
+}
+
+// NewHTTPRecognizer creates a recognizer posting to url, with token as a
+// bearer token when set
+func NewHTTPRecognizer(url, token string) *HTTPRecognizer {
+	return &HTTPRecognizer{hook: newHook(url, token)}
+}
+
+// Recognize implements Recognizer
+func (r *HTTPRecognizer) Recognize(ctx context.Context, wav []byte) (*Transcript, error) {
+	var transcript Transcript
+	if err := r.hook.post(ctx, wav, "audio/wav", &transcript); err != nil {
+		return nil, fmt.Errorf("speech recognition failed: %w", err)
+	}
+	return &transcript, nil
+}
+
+// HTTPTranslator posts {"source": "en", "target": "es", "texts": [...]} to a
+// translation service and reads back {"texts": [...]}, in the same order
+type HTTPTranslator struct {
+	hook hook
+}
+
+// NewHTTPTranslator creates a translator posting to url, with token as a
+// bearer token when set
+func NewHTTPTranslator(url, token string) *HTTPTranslator {
+	return &HTTPTranslator{hook: newHook(url, token)}
+}
+
+// Translate implements Translator
+func (t *HTTPTranslator) Translate(ctx context.Context, from, to string, texts []string) ([]string, error) {
+	body, _ := json.Marshal(map[string]any{"source": from, "target": to, "texts": texts})
+	var translated struct {
+		Texts []string `json:"texts"`
+	}
+	if err := t.hook.post(ctx, body, "application/json", &translated); err != nil {
+		return nil, fmt.Errorf("translation failed: %w", err)
+	}
+	if len(translated.Texts) != len(texts) {
+		return nil, fmt.Errorf("translation returned %d texts for %d", len(translated.Texts), len(texts))
+	}
+	return translated.Texts, nil
+}
@@ -0,0 +1,326 @@
+// Package catalog keeps the list of published videos with what is known of
+// them, such as their duration, so listing videos doesn't scan the bucket.
+// Each video is a row kept in the bucket, shared by all replicas, and
+// announced on the event bus when it changes. Rows are written when videos
+// are published or deleted and reconciled with the bucket periodically, for
+// videos published or deleted without going through the service.
+package catalog
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/background"
+	"live-video/pkg/events"
+	"live-video/pkg/storage"
+	"live-video/pkg/vod"
+)
+
+// Prefix is where the catalog is kept in the bucket, outside the layout
+// prefixes so no lifecycle rule removes it
+const Prefix = "catalog"
+
+// syncTimeout bounds one sync with the bucket
+const syncTimeout = time.Minute
+
+// Describer describes a video found in the bucket without a row, from the
+// bytes stored in its folder and when its master playlist was published
+type Describer func(ctx context.Context, videoID string, size int64, publishedAt time.Time) (*storage.VideoMetadata, error)
+
+// row is a video of the catalog
+type row struct {
+	video      *storage.VideoMetadata
+	generation int64     // of its object, 0 until listed
+	updated    time.Time // when it was last written or read
+}
+
+// Catalog is the videos of all replicas
+type Catalog struct {
+	gcsService *storage.GCSService
+	bus        *events.Bus
+
+	mu   sync.RWMutex
+	rows map[string]*row // by video ID
+}
+
+// NewCatalog creates the catalog kept in the bucket of gcsService
+func NewCatalog(gcsService *storage.GCSService, bus *events.Bus) *Catalog {
+	return &Catalog{
+		gcsService: gcsService,
+		bus:        bus,
+		rows:       make(map[string]*row),
+	}
+}
+
+// Put stores the row of a published video, replacing what was known of it
+// but when it was first uploaded, and announces it to the other replicas
+func (c *Catalog) Put(ctx context.Context, video *storage.VideoMetadata) error {
+	if video.VideoID == "" {
+		return fmt.Errorf("video without ID")
+	}
+	stored := *video
+	c.mu.RLock()
+	if existing, ok := c.rows[video.VideoID]; ok && existing.video.UploadedAt.Before(stored.UploadedAt) {
+		stored.UploadedAt = existing.video.UploadedAt
+	}
+	c.mu.RUnlock()
+
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	if err := c.gcsService.UploadBytes(ctx, data, objectPath(stored.VideoID), "application/json"); err != nil {
+		return fmt.Errorf("failed to store catalog row of %s: %w", stored.VideoID, err)
+	}
+	c.add(&stored, 0)
+	c.bus.Publish(events.VideoCataloged, videoData(&stored))
+	return nil
+}
+
+// Remove deletes the row of a video from the bucket, whether or not this
+// replica has it, and announces it to the other replicas. It reports whether
+// the video had one.
+func (c *Catalog) Remove(ctx context.Context, videoID string) (bool, error) {
+	c.mu.RLock()
+	_, ok := c.rows[videoID]
+	c.mu.RUnlock()
+	err := c.gcsService.DeleteVideo(ctx, objectPath(videoID))
+	switch {
+	case storage.IsNotExist(err):
+	case err != nil:
+		return false, fmt.Errorf("failed to delete catalog row of %s: %w", videoID, err)
+	default:
+		ok = true
+	}
+	if !ok {
+		return false, nil
+	}
+	c.remove(videoID)
+	c.bus.Publish(events.VideoRemoved, map[string]any{"video_id": videoID})
+	return true, nil
+}
+
+// Stored reports whether the bucket has a row of a video
+func (c *Catalog) Stored(ctx context.Context, videoID string) (bool, error) {
+	_, err := c.gcsService.GetObjectAttrs(ctx, objectPath(videoID))
+	if storage.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get returns a video of the catalog
+func (c *Catalog) Get(videoID string) (*storage.VideoMetadata, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	r, ok := c.rows[videoID]
+	if !ok {
+		return nil, false
+	}
+	video := *r.video
+	return &video, true
+}
+
+// List returns the videos keep accepts, nil for all of them, oldest first
+func (c *Catalog) List(keep func(video *storage.VideoMetadata) bool) []*storage.VideoMetadata {
+	c.mu.RLock()
+	videos := make([]*storage.VideoMetadata, 0, len(c.rows))
+	for _, r := range c.rows {
+		if keep == nil || keep(r.video) {
+			video := *r.video
+			videos = append(videos, &video)
+		}
+	}
+	c.mu.RUnlock()
+	slices.SortFunc(videos, func(a, b *storage.VideoMetadata) int {
+		return cmp.Or(a.UploadedAt.Compare(b.UploadedAt), strings.Compare(a.VideoID, b.VideoID))
+	})
+	return videos
+}
+
+// Len returns the number of videos in the catalog
+func (c *Catalog) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.rows)
+}
+
+// Start loads the catalog in the bucket, follows the changes announced by
+// other replicas and syncs with the bucket in group every interval, should
+// an announcement be lost
+func (c *Catalog) Start(group *background.Group, interval time.Duration) {
+	c.bus.Subscribe(events.VideoCataloged, func(event events.Event) {
+		if video, err := videoOf(event.Data); err == nil {
+			c.add(video, 0)
+		}
+	})
+	c.bus.Subscribe(events.VideoRemoved, func(event events.Event) {
+		if id, ok := event.Data["video_id"].(string); ok {
+			c.remove(id)
+		}
+	})
+
+	sync := func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+		defer cancel()
+		if err := c.Sync(ctx); err != nil {
+			log.Printf("[Catalog] Failed to sync the catalog: %v", err)
+		}
+	}
+	sync(group.Context())
+	group.Every(interval, sync)
+}
+
+// Sync makes the catalog what the bucket holds, reading only the rows that
+// changed since they were last read
+func (c *Catalog) Sync(ctx context.Context) error {
+	listedAt := time.Now()
+	objects, err := c.gcsService.ListObjects(ctx, Prefix+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list catalog: %w", err)
+	}
+
+	stored := make(map[string]bool, len(objects))
+	for _, attrs := range objects {
+		id := strings.TrimSuffix(path.Base(attrs.Name), ".json")
+		stored[id] = true
+
+		c.mu.RLock()
+		r, known := c.rows[id]
+		c.mu.RUnlock()
+		if known && r.generation == attrs.Generation {
+			continue
+		}
+		data, err := c.gcsService.ReadFile(ctx, attrs.Name)
+		if storage.IsNotExist(err) {
+			continue // removed meanwhile
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read catalog row %s: %w", id, err)
+		}
+		var video storage.VideoMetadata
+		if err := json.Unmarshal(data, &video); err != nil || video.VideoID != id {
+			log.Printf("[Catalog] Skipping unreadable catalog row %s", attrs.Name)
+			continue
+		}
+		c.add(&video, attrs.Generation)
+	}
+
+	// Rows written since the listing are kept for the next sync
+	c.mu.Lock()
+	for id, r := range c.rows {
+		if !stored[id] && r.updated.Before(listedAt) {
+			delete(c.rows, id)
+		}
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// Reconcile compares the catalog with the videos in the bucket: videos with
+// a master playlist but no row get one, described by describe, and rows of
+// videos whose master playlist is gone are removed. It returns how many
+// rows it added and removed.
+func (c *Catalog) Reconcile(ctx context.Context, describe Describer) (added, removed int, err error) {
+	listedAt := time.Now()
+	folder := c.gcsService.Layout().VOD
+	objects, err := c.gcsService.ListObjects(ctx, folder+"/")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list videos: %w", err)
+	}
+	sizes := make(map[string]int64)
+	published := make(map[string]time.Time) // video ID -> master playlist created
+	for _, attrs := range objects {
+		id, file, ok := strings.Cut(strings.TrimPrefix(attrs.Name, folder+"/"), "/")
+		if !ok {
+			continue
+		}
+		sizes[id] += attrs.Size
+		if file == vod.PlaylistName {
+			published[id] = attrs.Created
+		}
+	}
+
+	for id, publishedAt := range published {
+		if _, ok := c.Get(id); ok {
+			continue
+		}
+		video, err := describe(ctx, id, sizes[id], publishedAt)
+		if err != nil {
+			log.Printf("[Catalog] Failed to describe video %s: %v", id, err)
+			continue
+		}
+		if err := c.Put(ctx, video); err != nil {
+			return added, removed, err
+		}
+		added++
+	}
+
+	c.mu.RLock()
+	var gone []string
+	for id, r := range c.rows {
+		if _, ok := published[id]; !ok && r.updated.Before(listedAt) {
+			gone = append(gone, id)
+		}
+	}
+	c.mu.RUnlock()
+	for _, id := range gone {
+		ok, err := c.Remove(ctx, id)
+		if err != nil {
+			return added, removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return added, removed, nil
+}
+
+// add stores a row read with generation, 0 when not read from the bucket
+func (c *Catalog) add(video *storage.VideoMetadata, generation int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rows[video.VideoID] = &row{video: video, generation: generation, updated: time.Now()}
+}
+
+func (c *Catalog) remove(videoID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rows, videoID)
+}
+
+// videoData returns a video as the data of its event
+func videoData(video *storage.VideoMetadata) map[string]any {
+	data, _ := json.Marshal(video)
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// videoOf decodes the video of an event, relayed through JSON or not
+func videoOf(fields map[string]any) (*storage.VideoMetadata, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var video storage.VideoMetadata
+	if err := json.Unmarshal(data, &video); err != nil {
+		return nil, err
+	}
+	if video.VideoID == "" {
+		return nil, fmt.Errorf("video without ID")
+	}
+	return &video, nil
+}
+
+func objectPath(videoID string) string {
+	return path.Join(Prefix, videoID+".json")
+}
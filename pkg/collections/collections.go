@@ -0,0 +1,236 @@
+// Package collections groups videos into ordered playlists, such as the
+// episodes of a channel or the lessons of a course, with their own metadata
+// and next-up order. Collections are kept in the bucket, shared by all
+// replicas.
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"live-video/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// Prefix is where collections are kept in the bucket, outside the layout
+// prefixes so no lifecycle rule removes them
+const Prefix = "collections"
+
+// Collection limits
+const (
+	MaxTitleLength       = 200
+	MaxDescriptionLength = 5000
+	MaxItems             = 1000
+	MaxMetadataEntries   = 32
+)
+
+// ErrInvalid is returned for collections that break a limit
+var ErrInvalid = errors.New("invalid collection")
+
+// Item is a video in a collection
+type Item struct {
+	VideoID string `json:"video_id"`
+	Title   string `json:"title,omitempty"` // e.g. "Lesson 3: Lighting"; defaults to the video's
+}
+
+// Collection is an ordered playlist of videos
+type Collection struct {
+	ID          string            `json:"id"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"` // free-form, e.g. {"level": "beginner"}
+	Items       []Item            `json:"items"`
+	Loop        bool              `json:"loop,omitempty"` // the last item is followed by the first
+	OwnerID     string            `json:"owner_id,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// Validate cleans a collection's fields and checks its limits
+func (c *Collection) Validate() error {
+	c.Title = strings.TrimSpace(c.Title)
+	c.Description = strings.TrimSpace(c.Description)
+	switch {
+	case c.Title == "":
+		return fmt.Errorf("%w: title is required", ErrInvalid)
+	case utf8.RuneCountInString(c.Title) > MaxTitleLength:
+		return fmt.Errorf("%w: title is longer than %d characters", ErrInvalid, MaxTitleLength)
+	case utf8.RuneCountInString(c.Description) > MaxDescriptionLength:
+		return fmt.Errorf("%w: description is longer than %d characters", ErrInvalid, MaxDescriptionLength)
+	case len(c.Metadata) > MaxMetadataEntries:
+		return fmt.Errorf("%w: more than %d metadata entries", ErrInvalid, MaxMetadataEntries)
+	case len(c.Items) > MaxItems:
+		return fmt.Errorf("%w: more than %d items", ErrInvalid, MaxItems)
+	}
+
+	seen := make(map[string]bool, len(c.Items))
+	for i := range c.Items {
+		item := &c.Items[i]
+		item.VideoID = strings.TrimSpace(item.VideoID)
+		item.Title = strings.TrimSpace(item.Title)
+		if item.VideoID == "" {
+			return fmt.Errorf("%w: item %d has no video_id", ErrInvalid, i)
+		}
+		if seen[item.VideoID] {
+			return fmt.Errorf("%w: video %s is in the collection twice", ErrInvalid, item.VideoID)
+		}
+		seen[item.VideoID] = true
+		if utf8.RuneCountInString(item.Title) > MaxTitleLength {
+			return fmt.Errorf("%w: title of item %d is longer than %d characters", ErrInvalid, i, MaxTitleLength)
+		}
+	}
+	if c.Items == nil {
+		c.Items = []Item{}
+	}
+	return nil
+}
+
+// Index returns the position of a video in the collection, or -1
+func (c *Collection) Index(videoID string) int {
+	return slices.IndexFunc(c.Items, func(item Item) bool { return item.VideoID == videoID })
+}
+
+// Next returns the position of the item that follows the one at i: the next
+// one, the first after the last in looping collections, or -1 at the end
+func (c *Collection) Next(i int) int {
+	switch {
+	case i+1 < len(c.Items):
+		return i + 1
+	case c.Loop && len(c.Items) > 0:
+		return 0
+	}
+	return -1
+}
+
+// Store keeps collections in the bucket
+type Store struct {
+	storage *storage.GCSService
+}
+
+// NewStore creates a collection store in the bucket of gcsService
+func NewStore(gcsService *storage.GCSService) *Store {
+	return &Store{storage: gcsService}
+}
+
+// Create validates a new collection, assigns its ID and saves it
+func (s *Store) Create(ctx context.Context, c *Collection) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c.ID = uuid.New().String()
+	c.CreatedAt = time.Now().UTC()
+	c.UpdatedAt = c.CreatedAt
+	return s.save(ctx, c)
+}
+
+// Update validates and saves a changed collection
+func (s *Store) Update(ctx context.Context, c *Collection) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	c.UpdatedAt = time.Now().UTC()
+	return s.save(ctx, c)
+}
+
+// Get returns a collection. It returns os.ErrNotExist for collections that
+// don't exist.
+func (s *Store) Get(ctx context.Context, id string) (*Collection, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, os.ErrNotExist
+	}
+	data, err := s.storage.ReadFile(ctx, objectPath(id))
+	if storage.IsNotExist(err) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Collection
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("unreadable collection %s: %w", id, err)
+	}
+	return &c, nil
+}
+
+// List returns every collection, most recently updated first
+func (s *Store) List(ctx context.Context) ([]*Collection, error) {
+	objects, err := s.storage.ListObjects(ctx, Prefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Collection, 0, len(objects))
+	for _, attrs := range objects {
+		data, err := s.storage.ReadFile(ctx, attrs.Name)
+		if storage.IsNotExist(err) {
+			continue // deleted since the listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c Collection
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("unreadable collection %s: %w", attrs.Name, err)
+		}
+		list = append(list, &c)
+	}
+	slices.SortFunc(list, func(a, b *Collection) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return list, nil
+}
+
+// Delete removes a collection. The videos in it are kept.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	return s.storage.DeleteVideo(ctx, objectPath(id))
+}
+
+// Containing returns the collections a video is in
+func (s *Store) Containing(ctx context.Context, videoID string) ([]*Collection, error) {
+	list, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(list, func(c *Collection) bool { return c.Index(videoID) < 0 }), nil
+}
+
+// RemoveVideo takes a video out of every collection it is in and returns
+// how many collections it changed
+func (s *Store) RemoveVideo(ctx context.Context, videoID string) (int, error) {
+	containing, err := s.Containing(ctx, videoID)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	var errs []error
+	for _, c := range containing {
+		c.Items = slices.Delete(c.Items, c.Index(videoID), c.Index(videoID)+1)
+		if err := s.Update(ctx, c); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		changed++
+	}
+	return changed, errors.Join(errs...)
+}
+
+func (s *Store) save(ctx context.Context, c *Collection) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.storage.UploadBytes(ctx, data, objectPath(c.ID), "application/json")
+}
+
+func objectPath(id string) string {
+	return path.Join(Prefix, id+".json")
+}
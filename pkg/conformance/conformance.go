@@ -0,0 +1,484 @@
+// Package conformance checks HLS output against Apple's HLS authoring rules:
+// segment durations, declared against measured bandwidth, codec strings and
+// I-frame playlists, so misconfigured encodings are caught before viewers
+// notice them
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"live-video/pkg/m3u8"
+)
+
+// Severities of a finding
+const (
+	SeverityError   = "error"   // breaks a MUST of the rules
+	SeverityWarning = "warning" // breaks a SHOULD
+)
+
+// Rules a finding can be about
+const (
+	RuleVersion             = "version"
+	RuleIndependentSegments = "independent_segments"
+	RuleBandwidth           = "bandwidth"
+	RuleAverageBandwidth    = "average_bandwidth"
+	RuleMeasuredBandwidth   = "measured_bandwidth"
+	RuleCodecs              = "codecs"
+	RuleResolution          = "resolution"
+	RuleFrameRate           = "frame_rate"
+	RuleTargetDuration      = "target_duration"
+	RuleSegmentDuration     = "segment_duration"
+	RulePlaylistType        = "playlist_type"
+	RuleIFramePlaylists     = "iframe_playlists"
+	RuleDecodable           = "decodable"
+	RulePlaylistUnreadable  = "playlist_unreadable"
+)
+
+const (
+	// recommendedTarget is the target duration the rules recommend, in
+	// seconds
+	recommendedTarget = 6
+
+	// bandwidthTolerance is how far measured bit rates may be from the
+	// declared ones
+	bandwidthTolerance = 0.10
+
+	// maxSampledSegments is how many segments of each playlist are
+	// downloaded to measure its bit rate
+	maxSampledSegments = 20
+)
+
+// avcCodec and the other patterns are the codec strings players understand
+var (
+	avcCodec   = regexp.MustCompile(`^avc1\.[0-9A-Fa-f]{6}$`)
+	hevcCodec  = regexp.MustCompile(`^(hvc1|hev1)\.[0-9A-Za-z.]+$`)
+	aacCodec   = regexp.MustCompile(`^mp4a\.40\.(2|5|29)$`)
+	otherCodec = regexp.MustCompile(`^(ac-3|ec-3|mp4a\.a[56]|av01\.[0-9A-Za-z.]+|vp09\.[0-9.]+|fLaC|Opus|wvtt|stpp\.ttml\.im1t)$`)
+)
+
+// Finding is a rule a playlist breaks
+type Finding struct {
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Playlist string `json:"playlist,omitempty"` // relative to the master playlist, "" for the master itself
+	Message  string `json:"message"`
+}
+
+// Report is the outcome of a check
+type Report struct {
+	Conformant bool      `json:"conformant"` // no errors
+	Errors     int       `json:"errors"`
+	Warnings   int       `json:"warnings"`
+	Playlists  int       `json:"playlists"`
+	Segments   int       `json:"segments_sampled"`
+	Findings   []Finding `json:"findings"`
+}
+
+// Source reads the files of an HLS output by their path relative to its
+// folder
+type Source func(ctx context.Context, name string) ([]byte, error)
+
+// Prober decodes a segment, with its initialization section prepended, and
+// describes its streams
+type Prober func(ctx context.Context, data []byte, ext string) ([]StreamInfo, error)
+
+// StreamInfo is a stream of a decoded segment
+type StreamInfo struct {
+	Type    string // video or audio
+	Codec   string // ffprobe's codec name, e.g. h264, hevc or aac
+	Profile string // e.g. High or LC
+	Level   int    // e.g. 31 for H.264 level 3.1
+	Width   int
+	Height  int
+	Frames  int // video frames decoded
+}
+
+// checker collects the findings of one check
+type checker struct {
+	read   Source
+	probe  Prober
+	report Report
+}
+
+// Check checks the HLS output whose master playlist source reads as master.
+// probe decodes one segment of every playlist; nil uses ffprobe.
+func Check(ctx context.Context, read Source, master string, probe Prober) (*Report, error) {
+	if probe == nil {
+		probe = FFprobe
+	}
+	c := &checker{read: read, probe: probe}
+	data, err := read(ctx, master)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master playlist: %w", err)
+	}
+	if !m3u8.IsMaster(data) {
+		// A single media playlist is checked on its own
+		c.checkMedia(ctx, path.Base(master), master, data, nil)
+		return c.finish(), nil
+	}
+	playlist, err := m3u8.ParseMaster(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse master playlist: %w", err)
+	}
+	c.checkMaster(ctx, path.Dir(master), playlist)
+	return c.finish(), nil
+}
+
+func (c *checker) finish() *Report {
+	report := c.report
+	report.Conformant = report.Errors == 0
+	if report.Findings == nil {
+		report.Findings = []Finding{}
+	}
+	return &report
+}
+
+func (c *checker) add(severity, rule, playlist, format string, args ...any) {
+	c.report.Findings = append(c.report.Findings, Finding{Severity: severity, Rule: rule, Playlist: playlist, Message: fmt.Sprintf(format, args...)})
+	if severity == SeverityError {
+		c.report.Errors++
+	} else {
+		c.report.Warnings++
+	}
+}
+
+// checkMaster checks the master playlist and every playlist it lists
+func (c *checker) checkMaster(ctx context.Context, dir string, master *m3u8.MasterPlaylist) {
+	if !hasTag(master.Tags, m3u8.TagVersion) {
+		c.add(SeverityWarning, RuleVersion, "", "master playlist has no EXT-X-VERSION")
+	}
+	if !hasTag(master.Tags, m3u8.TagIndependentSegments) {
+		c.add(SeverityWarning, RuleIndependentSegments, "", "master playlist should carry EXT-X-INDEPENDENT-SEGMENTS when every segment starts with a key frame")
+	}
+
+	// Alternate renditions are checked as media playlists of their own
+	for _, rendition := range master.Renditions() {
+		if uri, _ := rendition.Get("URI"); uri != "" {
+			c.readMedia(ctx, dir, uri, nil)
+		}
+	}
+
+	video := false
+	for i := range master.Variants {
+		variant := &master.Variants[i]
+		c.checkVariantAttributes(variant)
+		if _, ok := variant.Attributes.Get("RESOLUTION"); ok {
+			video = true
+		}
+		c.readMedia(ctx, dir, variant.URI, variant)
+	}
+
+	iframes := 0
+	for _, tag := range master.Tags {
+		if tag.Name != m3u8.TagIFrameStreamInf {
+			continue
+		}
+		iframes++
+		attrs := tag.Attributes()
+		uri := tag.URI()
+		if _, ok := attrs.Get("BANDWIDTH"); !ok {
+			c.add(SeverityError, RuleBandwidth, uri, "EXT-X-I-FRAME-STREAM-INF has no BANDWIDTH")
+		}
+		if _, ok := attrs.Get("CODECS"); !ok {
+			c.add(SeverityError, RuleCodecs, uri, "EXT-X-I-FRAME-STREAM-INF has no CODECS")
+		}
+		if uri == "" {
+			c.add(SeverityError, RuleIFramePlaylists, "", "EXT-X-I-FRAME-STREAM-INF has no URI")
+			continue
+		}
+		data, err := c.read(ctx, path.Join(dir, uri))
+		if err != nil {
+			c.add(SeverityError, RulePlaylistUnreadable, uri, "I-frame playlist can't be read: %v", err)
+			continue
+		}
+		c.report.Playlists++
+		playlist, err := m3u8.ParseMedia(data)
+		if err != nil {
+			c.add(SeverityError, RulePlaylistUnreadable, uri, "I-frame playlist can't be parsed: %v", err)
+			continue
+		}
+		if _, ok := playlist.HeaderValue(m3u8.TagIFramesOnly); !ok {
+			c.add(SeverityError, RuleIFramePlaylists, uri, "I-frame playlist has no EXT-X-I-FRAMES-ONLY")
+		}
+		if len(playlist.Segments) == 0 {
+			c.add(SeverityError, RuleIFramePlaylists, uri, "I-frame playlist lists no I-frames")
+		}
+	}
+	if video && iframes == 0 {
+		c.add(SeverityWarning, RuleIFramePlaylists, "", "no I-frame playlists (EXT-X-I-FRAME-STREAM-INF) for fast forward, rewind and scrubbing")
+	}
+}
+
+// checkVariantAttributes checks what a variant declares
+func (c *checker) checkVariantAttributes(variant *m3u8.Variant) {
+	attrs := variant.Attributes
+	if variant.Bandwidth() <= 0 {
+		c.add(SeverityError, RuleBandwidth, variant.URI, "variant has no BANDWIDTH")
+	}
+	if _, ok := attrs.Get("AVERAGE-BANDWIDTH"); !ok {
+		c.add(SeverityWarning, RuleAverageBandwidth, variant.URI, "variant has no AVERAGE-BANDWIDTH")
+	}
+	codecs, ok := attrs.Get("CODECS")
+	if !ok {
+		c.add(SeverityError, RuleCodecs, variant.URI, "variant has no CODECS")
+	}
+	for _, codec := range splitCodecs(codecs) {
+		if !avcCodec.MatchString(codec) && !hevcCodec.MatchString(codec) && !aacCodec.MatchString(codec) && !otherCodec.MatchString(codec) {
+			c.add(SeverityError, RuleCodecs, variant.URI, "codec string %q is not a valid RFC 6381 codec", codec)
+		}
+		if strings.HasPrefix(codec, "hev1.") {
+			c.add(SeverityWarning, RuleCodecs, variant.URI, "HEVC should be signaled as hvc1, not %s", codec)
+		}
+	}
+	if hasVideoCodec(codecs) || !ok {
+		if _, ok := attrs.Get("RESOLUTION"); !ok {
+			c.add(SeverityWarning, RuleResolution, variant.URI, "video variant has no RESOLUTION")
+		}
+		if _, ok := attrs.Get("FRAME-RATE"); !ok {
+			c.add(SeverityWarning, RuleFrameRate, variant.URI, "video variant has no FRAME-RATE")
+		}
+	}
+}
+
+// readMedia reads and checks a media playlist listed at uri, relative to dir
+func (c *checker) readMedia(ctx context.Context, dir, uri string, variant *m3u8.Variant) {
+	if strings.Contains(uri, "://") {
+		c.add(SeverityWarning, RulePlaylistUnreadable, uri, "absolute playlist URIs are not checked")
+		return
+	}
+	name := path.Join(dir, uri)
+	data, err := c.read(ctx, name)
+	if err != nil {
+		c.add(SeverityError, RulePlaylistUnreadable, uri, "playlist can't be read: %v", err)
+		return
+	}
+	c.checkMedia(ctx, uri, name, data, variant)
+}
+
+// checkMedia checks a media playlist and, for a variant, its bit rate and
+// codecs against what it declares
+func (c *checker) checkMedia(ctx context.Context, uri, name string, data []byte, variant *m3u8.Variant) {
+	c.report.Playlists++
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		c.add(SeverityError, RulePlaylistUnreadable, uri, "playlist can't be parsed: %v", err)
+		return
+	}
+	if _, ok := playlist.HeaderValue(m3u8.TagVersion); !ok {
+		c.add(SeverityWarning, RuleVersion, uri, "playlist has no EXT-X-VERSION")
+	}
+
+	target := 0
+	if value, ok := playlist.HeaderValue(m3u8.TagTargetDuration); ok {
+		target, _ = strconv.Atoi(value)
+	}
+	switch {
+	case target <= 0:
+		c.add(SeverityError, RuleTargetDuration, uri, "playlist has no valid EXT-X-TARGETDURATION")
+	case target != recommendedTarget:
+		c.add(SeverityWarning, RuleTargetDuration, uri, "target duration is %ds, %ds is recommended", target, recommendedTarget)
+	}
+	if len(playlist.Segments) == 0 {
+		c.add(SeverityError, RuleSegmentDuration, uri, "playlist lists no segments")
+		return
+	}
+
+	longest, short := 0.0, 0
+	for i, segment := range playlist.Segments {
+		longest = max(longest, segment.Duration)
+		// The last segment of a playlist may be cut short
+		if i < len(playlist.Segments)-1 && target > 0 && segment.Duration < float64(target)/2 {
+			short++
+		}
+	}
+	if target > 0 && int(math.Round(longest)) > target {
+		c.add(SeverityError, RuleSegmentDuration, uri, "a segment lasts %.3fs, longer than the %ds target duration", longest, target)
+	}
+	if short > 0 {
+		c.add(SeverityWarning, RuleSegmentDuration, uri, "%d segments last less than half the target duration; key frames are likely not aligned with segment boundaries", short)
+	}
+	if playlist.Ended {
+		if value, _ := playlist.HeaderValue(m3u8.TagPlaylistType); value != "VOD" {
+			c.add(SeverityWarning, RulePlaylistType, uri, "ended playlist should be EXT-X-PLAYLIST-TYPE:VOD")
+		}
+	}
+
+	c.checkSegments(ctx, uri, name, playlist, variant)
+}
+
+// checkSegments measures the bit rate of a sample of a playlist's segments
+// and decodes the last one
+func (c *checker) checkSegments(ctx context.Context, uri, name string, playlist *m3u8.MediaPlaylist, variant *m3u8.Variant) {
+	dir := path.Dir(name)
+	var init []byte
+	if initURI := playlist.InitURI(); initURI != "" {
+		data, err := c.read(ctx, path.Join(dir, initURI))
+		if err != nil {
+			c.add(SeverityError, RuleDecodable, uri, "initialization section %s can't be read: %v", initURI, err)
+			return
+		}
+		init = data
+	}
+
+	var bits, seconds, peak float64
+	var last []byte
+	var lastURI string
+	for _, i := range sample(len(playlist.Segments), maxSampledSegments) {
+		segment := playlist.Segments[i]
+		if segment.Has(m3u8.TagByteRange) || strings.Contains(segment.URI, "://") {
+			return // bit rates of byte ranges and remote segments are not measured
+		}
+		data, err := c.read(ctx, path.Join(dir, segment.URI))
+		if err != nil {
+			c.add(SeverityError, RuleDecodable, uri, "segment %s can't be read: %v", segment.URI, err)
+			return
+		}
+		c.report.Segments++
+		if segment.Duration > 0 {
+			bits += float64(len(data)) * 8
+			seconds += segment.Duration
+			peak = max(peak, float64(len(data))*8/segment.Duration)
+		}
+		last, lastURI = data, segment.URI
+	}
+
+	streams, err := c.probe(ctx, append(init, last...), path.Ext(lastURI))
+	if err != nil {
+		c.add(SeverityError, RuleDecodable, uri, "segment %s doesn't decode: %v", lastURI, err)
+	}
+	if variant == nil {
+		return
+	}
+	if err == nil {
+		c.checkCodecs(uri, variant, streams)
+	}
+
+	// Segments only hold the variant's own media; with an audio group the
+	// declared bandwidth includes audio from other playlists
+	_, grouped := variant.Attributes.Get("AUDIO")
+	if declared := float64(variant.Bandwidth()); declared > 0 && peak > 0 {
+		switch {
+		case peak > declared*(1+bandwidthTolerance):
+			c.add(SeverityError, RuleMeasuredBandwidth, uri, "measured peak bit rate %d exceeds BANDWIDTH %d by more than 10%%", int(peak), int(declared))
+		case !grouped && peak < declared*(1-bandwidthTolerance):
+			c.add(SeverityWarning, RuleMeasuredBandwidth, uri, "measured peak bit rate %d is more than 10%% below BANDWIDTH %d", int(peak), int(declared))
+		}
+	}
+	if declared := float64(variant.Attributes.Int("AVERAGE-BANDWIDTH")); declared > 0 && seconds > 0 {
+		average := bits / seconds
+		if math.Abs(average-declared) > declared*bandwidthTolerance && (!grouped || average > declared) {
+			c.add(SeverityWarning, RuleMeasuredBandwidth, uri, "measured average bit rate %d is more than 10%% off AVERAGE-BANDWIDTH %d", int(average), int(declared))
+		}
+	}
+}
+
+// checkCodecs compares what a variant declares with what its segment holds
+func (c *checker) checkCodecs(uri string, variant *m3u8.Variant, streams []StreamInfo) {
+	codecs, _ := variant.Attributes.Get("CODECS")
+	declared := splitCodecs(codecs)
+	for _, stream := range streams {
+		expected := codecString(stream)
+		if expected == "" || len(declared) == 0 {
+			continue
+		}
+		family, _, _ := strings.Cut(expected, ".")
+		match := slices.IndexFunc(declared, func(codec string) bool {
+			return strings.HasPrefix(codec, family) || family == "hvc1" && strings.HasPrefix(codec, "hev1")
+		})
+		if match < 0 {
+			c.add(SeverityError, RuleCodecs, uri, "segment holds %s %s, which CODECS %q doesn't list", stream.Type, stream.Codec, codecs)
+			continue
+		}
+		if family == "avc1" && !sameAVCProfile(declared[match], expected) {
+			c.add(SeverityError, RuleCodecs, uri, "CODECS declares %s but the segment is %s (profile %s, level %d)", declared[match], expected, stream.Profile, stream.Level)
+		}
+		if stream.Type == "video" {
+			if resolution, ok := variant.Attributes.Get("RESOLUTION"); ok && resolution != fmt.Sprintf("%dx%d", stream.Width, stream.Height) {
+				c.add(SeverityError, RuleResolution, uri, "RESOLUTION is %s but the segment is %dx%d", resolution, stream.Width, stream.Height)
+			}
+		}
+	}
+}
+
+// codecString returns the RFC 6381 codec string of a decoded stream, with
+// the profile and level for H.264, or just its family for other codecs
+func codecString(stream StreamInfo) string {
+	switch stream.Codec {
+	case "h264":
+		profiles := map[string]int{"Baseline": 0x42, "Constrained Baseline": 0x42, "Main": 0x4d, "High": 0x64}
+		profile, ok := profiles[stream.Profile]
+		if !ok || stream.Level <= 0 {
+			return "avc1"
+		}
+		return fmt.Sprintf("avc1.%02x00%02x", profile, stream.Level)
+	case "hevc":
+		return "hvc1"
+	case "aac":
+		return "mp4a"
+	case "ac3":
+		return "ac-3"
+	case "eac3":
+		return "ec-3"
+	}
+	return ""
+}
+
+// sameAVCProfile reports whether two avc1 codec strings have the same
+// profile and level. The constraint flags between them are not compared.
+func sameAVCProfile(a, b string) bool {
+	if !avcCodec.MatchString(a) || !avcCodec.MatchString(b) {
+		return true // the profile or level wasn't probed
+	}
+	return strings.EqualFold(a[5:7], b[5:7]) && strings.EqualFold(a[9:11], b[9:11])
+}
+
+// splitCodecs splits a CODECS attribute into its codec strings
+func splitCodecs(codecs string) []string {
+	var list []string
+	for _, codec := range strings.Split(codecs, ",") {
+		if codec = strings.TrimSpace(codec); codec != "" {
+			list = append(list, codec)
+		}
+	}
+	return list
+}
+
+// hasVideoCodec reports whether a CODECS attribute lists a video codec
+func hasVideoCodec(codecs string) bool {
+	for _, codec := range splitCodecs(codecs) {
+		for _, prefix := range []string{"avc1", "avc3", "hvc1", "hev1", "av01", "vp09", "dvh1", "dvhe"} {
+			if strings.HasPrefix(codec, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasTag(tags []m3u8.Tag, name string) bool {
+	return slices.ContainsFunc(tags, func(tag m3u8.Tag) bool { return tag.Name == name })
+}
+
+// sample returns up to n indexes spread evenly over 0..count-1, always
+// including the last
+func sample(count, n int) []int {
+	if count <= n {
+		indexes := make([]int, count)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes
+	}
+	indexes := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		indexes = append(indexes, i*(count-1)/(n-1))
+	}
+	return indexes
+}
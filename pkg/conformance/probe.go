@@ -0,0 +1,65 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// FFprobe decodes a segment with ffprobe, counting its video frames
+func FFprobe(ctx context.Context, data []byte, ext string) ([]StreamInfo, error) {
+	file, err := os.CreateTemp("", "conformance-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-count_frames",
+		"-show_entries", "stream=codec_type,codec_name,profile,level,width,height,nb_read_frames", "-of", "json", file.Name()).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	var result struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			Profile   string `json:"profile"`
+			Level     int    `json:"level"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+			Frames    string `json:"nb_read_frames"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("unreadable ffprobe output: %w", err)
+	}
+	if len(result.Streams) == 0 {
+		return nil, fmt.Errorf("no streams")
+	}
+
+	var streams []StreamInfo
+	for _, s := range result.Streams {
+		frames, _ := strconv.Atoi(s.Frames)
+		if s.CodecType == "video" && frames == 0 {
+			return nil, fmt.Errorf("no video frames decoded")
+		}
+		streams = append(streams, StreamInfo{
+			Type:    s.CodecType,
+			Codec:   s.CodecName,
+			Profile: s.Profile,
+			Level:   s.Level,
+			Width:   s.Width,
+			Height:  s.Height,
+			Frames:  frames,
+		})
+	}
+	return streams, nil
+}
@@ -0,0 +1,121 @@
+package conformance
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Status of a check
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed" // the output couldn't be checked
+)
+
+// retention is how long finished checks are tracked
+const retention = 24 * time.Hour
+
+// Run is a check of the HLS output of a video or stream
+type Run struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"` // "video" or "stream"
+	AssetID     string     `json:"asset_id"`
+	Status      Status     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Report      *Report    `json:"report,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Tracker keeps checks in memory and limits how many run at once
+type Tracker struct {
+	mu    sync.RWMutex
+	runs  map[string]*Run
+	slots chan struct{}
+}
+
+// NewTracker creates a tracker running at most concurrency checks at a time
+func NewTracker(concurrency int) *Tracker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Tracker{
+		runs:  make(map[string]*Run),
+		slots: make(chan struct{}, concurrency),
+	}
+}
+
+// Create registers a queued check
+func (t *Tracker) Create(kind, assetID string) *Run {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune()
+	r := &Run{
+		ID:        uuid.New().String(),
+		Kind:      kind,
+		AssetID:   assetID,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+	}
+	t.runs[r.ID] = r
+	snapshot := *r
+	return &snapshot
+}
+
+// Get returns a snapshot of a check
+func (t *Tracker) Get(id string) (*Run, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	r, ok := t.runs[id]
+	if !ok {
+		return nil, fmt.Errorf("conformance check not found: %s", id)
+	}
+	snapshot := *r
+	return &snapshot, nil
+}
+
+// Run waits for a free slot, marks the check running and runs check
+func (t *Tracker) Run(id string, check func() (*Report, error)) {
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+
+	t.update(id, func(r *Run) { r.Status = StatusRunning })
+	report, err := check()
+	t.update(id, func(r *Run) {
+		now := time.Now()
+		r.CompletedAt = &now
+		if err != nil {
+			r.Status = StatusFailed
+			r.Error = err.Error()
+			return
+		}
+		r.Status = StatusCompleted
+		r.Report = report
+	})
+}
+
+func (t *Tracker) update(id string, fn func(r *Run)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if r, ok := t.runs[id]; ok {
+		fn(r)
+	}
+}
+
+// prune forgets checks that finished more than retention ago. Callers hold
+// t.mu.
+func (t *Tracker) prune() {
+	for id, r := range t.runs {
+		if r.CompletedAt != nil && time.Since(*r.CompletedAt) > retention {
+			delete(t.runs, id)
+		}
+	}
+}
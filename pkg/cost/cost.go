@@ -0,0 +1,205 @@
+// Package cost estimates what streaming a ladder costs: CPU time to
+// transcode it, bucket storage for its segments and CDN egress to its
+// viewers, at configurable unit prices
+package cost
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"live-video/config"
+)
+
+// Video codecs an estimate can be made for
+const (
+	CodecH264 = "h264"
+	CodecHEVC = "hevc"
+)
+
+const (
+	// h264CoreSeconds is the CPU time libx264 at the veryfast preset takes
+	// per megapixel encoded, e.g. about 1.9 cores for 1080p60
+	h264CoreSeconds = 0.015
+
+	// hevcFactor is how much more CPU time libx265 takes than libx264
+	hevcFactor = 4
+
+	// decodeCoreSeconds is the CPU time decoding the source takes per
+	// megapixel, paid once for the whole ladder
+	decodeCoreSeconds = 0.002
+
+	// daysPerMonth converts retention to the months storage is billed by
+	daysPerMonth = 30
+
+	// bytesPerGB is the decimal gigabyte prices are quoted in
+	bytesPerGB = 1e9
+)
+
+// Prices are the unit prices of an estimate, in one currency
+type Prices struct {
+	Currency       string  `json:"currency"`
+	CPUHour        float64 `json:"cpu_hour"`         // per core hour
+	StorageGBMonth float64 `json:"storage_gb_month"` // per GB stored for 30 days
+	EgressGB       float64 `json:"egress_gb"`        // per GB delivered
+}
+
+// DefaultPrices returns typical public cloud list prices in USD
+func DefaultPrices() Prices {
+	return Prices{Currency: "USD", CPUHour: 0.04, StorageGBMonth: 0.02, EgressGB: 0.08}
+}
+
+// Validate checks that no price is negative
+func (p Prices) Validate() error {
+	if p.CPUHour < 0 || p.StorageGBMonth < 0 || p.EgressGB < 0 {
+		return fmt.Errorf("prices can't be negative")
+	}
+	return nil
+}
+
+// Plan is what an estimate is made for
+type Plan struct {
+	Ladder        []config.TranscodeProfile // highest first
+	Codec         string                    // CodecH264 or CodecHEVC
+	FrameRate     config.Rate               // of the source
+	Duration      time.Duration
+	Viewers       int
+	WatchFraction float64 // share of the duration the average viewer watches
+	RetentionDays int     // how long the segments are kept
+	ViewRendition string  // rendition viewers watch, the highest when empty
+}
+
+// Estimate is the estimated cost of a plan
+type Estimate struct {
+	Currency   string              `json:"currency"`
+	Transcode  TranscodeEstimate   `json:"transcode"`
+	Storage    StorageEstimate     `json:"storage"`
+	Egress     EgressEstimate      `json:"egress"`
+	Total      float64             `json:"total"`
+	Renditions []RenditionEstimate `json:"renditions"`
+	Prices     Prices              `json:"prices"`
+}
+
+// TranscodeEstimate is the CPU time the ladder takes to encode
+type TranscodeEstimate struct {
+	Cores    float64 `json:"cores"` // busy while encoding in real time
+	CPUHours float64 `json:"cpu_hours"`
+	Cost     float64 `json:"cost"`
+}
+
+// StorageEstimate is the bucket storage of the ladder's segments
+type StorageEstimate struct {
+	GB       float64 `json:"gb"`
+	GBMonths float64 `json:"gb_months"`
+	Cost     float64 `json:"cost"`
+}
+
+// EgressEstimate is the data delivered to viewers
+type EgressEstimate struct {
+	Rendition string  `json:"rendition"`
+	GB        float64 `json:"gb"`
+	Cost      float64 `json:"cost"`
+}
+
+// RenditionEstimate is the share of one rendition, to compare ladder
+// choices by
+type RenditionEstimate struct {
+	Name      string  `json:"name"`
+	Cores     float64 `json:"cores"`
+	CPUHours  float64 `json:"cpu_hours"`
+	StorageGB float64 `json:"storage_gb"`
+	Cost      float64 `json:"cost"` // transcode and storage
+}
+
+// Make estimates the cost of a plan at prices
+func Make(plan Plan, prices Prices) (*Estimate, error) {
+	if len(plan.Ladder) == 0 {
+		return nil, fmt.Errorf("the ladder needs at least one rendition")
+	}
+	if plan.Duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+	if plan.Viewers < 0 || plan.RetentionDays < 0 || plan.WatchFraction < 0 || plan.WatchFraction > 1 {
+		return nil, fmt.Errorf("viewers and retention can't be negative and the watch fraction is between 0 and 1")
+	}
+	factor := 1.0
+	switch plan.Codec {
+	case "", CodecH264:
+	case CodecHEVC:
+		factor = hevcFactor
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", plan.Codec)
+	}
+	rate := plan.FrameRate
+	if !rate.Known() {
+		rate = config.Rate{Num: 30, Den: 1}
+	}
+
+	seconds := plan.Duration.Seconds()
+	hours := plan.Duration.Hours()
+	months := float64(plan.RetentionDays) / daysPerMonth
+	estimate := &Estimate{Currency: prices.Currency, Prices: prices}
+
+	// The source is decoded once at the size of the highest rendition
+	top := plan.Ladder[0]
+	decode := megapixels(top.Width, top.Height) * rate.Float() * decodeCoreSeconds
+	estimate.Transcode.Cores = decode
+
+	view := plan.Ladder[0]
+	for _, profile := range plan.Ladder {
+		if profile.Name == plan.ViewRendition {
+			view = profile
+		}
+		cores := megapixels(profile.Width, profile.Height) * profile.OutputRate(rate).Float() * h264CoreSeconds * factor
+		_, average := profile.Bandwidth()
+		storageGB := float64(average) / 8 * seconds / bytesPerGB
+
+		rendition := RenditionEstimate{
+			Name:      profile.Name,
+			Cores:     round(cores),
+			CPUHours:  round(cores * hours),
+			StorageGB: round(storageGB),
+			Cost:      round(cores*hours*prices.CPUHour + storageGB*months*prices.StorageGBMonth),
+		}
+		estimate.Renditions = append(estimate.Renditions, rendition)
+		estimate.Transcode.Cores += cores
+		estimate.Storage.GB += storageGB
+	}
+	if plan.ViewRendition != "" && view.Name != plan.ViewRendition {
+		return nil, fmt.Errorf("rendition %q is not in the ladder", plan.ViewRendition)
+	}
+
+	cpuHours := estimate.Transcode.Cores * hours
+	estimate.Transcode = TranscodeEstimate{
+		Cores:    round(estimate.Transcode.Cores),
+		CPUHours: round(cpuHours),
+		Cost:     round(cpuHours * prices.CPUHour),
+	}
+
+	gbMonths := estimate.Storage.GB * months
+	estimate.Storage = StorageEstimate{
+		GB:       round(estimate.Storage.GB),
+		GBMonths: round(gbMonths),
+		Cost:     round(gbMonths * prices.StorageGBMonth),
+	}
+
+	_, average := view.Bandwidth()
+	egressGB := float64(average) / 8 * seconds * plan.WatchFraction * float64(plan.Viewers) / bytesPerGB
+	estimate.Egress = EgressEstimate{
+		Rendition: view.Name,
+		GB:        round(egressGB),
+		Cost:      round(egressGB * prices.EgressGB),
+	}
+
+	estimate.Total = round(estimate.Transcode.Cost + estimate.Storage.Cost + estimate.Egress.Cost)
+	return estimate, nil
+}
+
+func megapixels(width, height int) float64 {
+	return float64(width*height) / 1e6
+}
+
+// round rounds to cents, or to the hundredth of a unit
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
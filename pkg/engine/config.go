@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"time"
+
+	"live-video/config"
+	"live-video/internal/handlers"
+	"live-video/pkg/audit"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/cost"
+	"live-video/pkg/prefetch"
+	"live-video/pkg/staging"
+	"live-video/pkg/storage"
+	"live-video/pkg/upstream"
+	"live-video/pkg/vod"
+	"live-video/pkg/webrtc"
+)
+
+// Config is everything an Engine is built from. Start from DefaultConfig:
+// zero values turn features off rather than pick defaults.
+type Config struct {
+	// Bucket
+	GCSBucket          string
+	GCSCredentialsFile string // application default credentials when empty
+	GCSServiceAccount  string // signs URLs; discovered from the credentials when empty
+	StorageLayout      storage.Layout
+	BucketSettings     storage.BucketSettings // applied at start when not empty
+	StoragePolicies    map[string]storage.OperationPolicy
+
+	// VOD uploads and transcoding
+	IngestWatchPrefix     string // bucket prefix whose finalized objects are ingested
+	PubSubPushToken       string
+	ArchiveStorageClass   string
+	PreserveOriginals     bool
+	OriginalsStorageClass string
+	Deinterlacer          string // vod.DeinterlaceBwdif, DeinterlaceYadif or DeinterlaceOff
+	Downmix               string // vod.DownmixITU or DownmixDialog
+	Surround              bool
+	Staging               staging.Policy
+	Segments              config.SegmentNaming // of live streams and VOD
+
+	// HTTP
+	BodyLimits       map[string]int64 // by class, over the defaults of the HTTP layer
+	TrustedProxies   []string         // addresses or CIDRs of proxies setting X-Forwarded-For, none by default
+	V1DeprecatedAt   time.Time        // announced on v1 routes with a v2 successor
+	V1Sunset         time.Time
+	SegmentCacheSize int64 // bytes of HLS segments cached by the proxies, 0 for none
+	PrefetchSegments int
+	CDNBaseURL       string
+	CDNUpstream      upstream.Options
+	CDNProxyMaxBytes int64
+	CDN              config.GCSConfig // CDN signing settings
+	PublicBaseURL    string
+	StaticDir        string // web UI assets; the web UI is only served
+	TemplatesDir     string // with both this and its templates set
+
+	// Accounts
+	AccountsFile      string // accounts are disabled without one
+	OIDCProvidersFile string
+	SessionTTL        time.Duration
+	OwnershipSync     time.Duration // how often each replica re-reads ownership records
+	EmbedTokenSecret  string        // random per process when empty
+	RevocationSync    time.Duration
+	IntegrityKey      string
+
+	// Viewers
+	GeoIPDatabase      string
+	GeoIPCountryHeader string
+	IngestRegionsFile  string
+
+	// Broadcasters
+	ICEServers             []webrtc.ICEServer
+	PreflightMinUplinkKbps int
+	PreflightMaxSessions   int // runs kept at once, running or reported
+
+	// Local storage
+	WorkDir             string
+	WorkDirFast         string
+	WorkDirMinFreeBytes uint64
+	CaptureDir          string // under WorkDir when empty
+	CaptureMaxBytes     int64
+	CaptureTotalBytes   int64
+	CaptureRetention    time.Duration
+
+	// Live streams
+	Limits                broadcast.Limits
+	FailoverStallTimeout  time.Duration
+	InputFailoverWindow   time.Duration
+	SourcePolicy          upstream.Policy     // hosts stream inputs may be pulled from
+	SRT                   broadcast.SRTConfig // SRT ingest, off without ports
+	Idle                  broadcast.IdlePolicy
+	ViewerSessionTimeout  time.Duration
+	WarmPoolSize          int
+	EncoderCPUHigh        int // percent, 0 turns load degradation off
+	StreamPrimeLead       time.Duration
+	SlateImage            string
+	ReconcileOrphansAfter time.Duration
+	ReconcileInterval     time.Duration
+	LiveSegmentRetention  time.Duration
+	CatalogSync           time.Duration // how often each replica re-reads the video catalog
+	CatalogReconcile      time.Duration // how often the leader reconciles the catalog with the bucket, 0 for never
+	UsageRebuild          time.Duration // how often each replica rebuilds its usage ledger from the bucket, 0 for never
+	AuditInterval         time.Duration // how often the leader audits stored assets, 0 for never
+	Audit                 audit.Options
+	PlaybackCheckTimeout  time.Duration // how long the synthetic viewer of a started stream waits for segments, 0 for no check
+
+	// Live captions, off without an ASR URL
+	ASRURL                string
+	ASRToken              string
+	CaptionTranslateURL   string // captions aren't translated without one
+	CaptionTranslateToken string
+
+	// Unit prices of cost estimates
+	CostPrices cost.Prices
+
+	// Billing export of per-tenant usage records
+	BillingExport         string // "gcs", "bigquery", or empty for none
+	BillingExportInterval time.Duration
+	BillingCSVPrefix      string // bucket prefix of "gcs" exports
+	BillingBigQueryTable  string // project.dataset.table of "bigquery" exports
+
+	// Singleton tasks
+	LeaderElection       string // "kubernetes", or empty for every replica to run them
+	LeaderLeaseName      string
+	LeaderLeaseNamespace string
+	LeaderLeaseDuration  time.Duration
+
+	// Events
+	EventBusURL   string // redis://, rediss:// or nats:// relay between replicas; in process only when empty
+	WebhookURL    string
+	WebhookSecret string
+	WebhookEvents []string // topic patterns posted to WebhookURL
+}
+
+// DefaultConfig returns the configuration cmd/server runs with when nothing
+// is set
+func DefaultConfig() Config {
+	cdn := config.DefaultFFmpegConfig().GCS
+	cdn.CDNSignedTTL = int(time.Hour.Seconds())
+	return Config{
+		GCSBucket: "your-gcs-bucket-name",
+		StorageLayout: storage.Layout{
+			Live:       "live",
+			VOD:        "vod",
+			Recordings: "recordings",
+			Thumbnails: "thumbnails",
+		},
+		BucketSettings:         storage.BucketSettings{CORSMaxAge: time.Hour},
+		StoragePolicies:        storage.DefaultOperationPolicies(),
+		ArchiveStorageClass:    "ARCHIVE",
+		OriginalsStorageClass:  "NEARLINE",
+		Deinterlacer:           vod.DeinterlaceBwdif,
+		Downmix:                vod.DownmixITU,
+		Staging:                staging.Policy{FailedRetention: 24 * time.Hour, QuarantineRetention: 168 * time.Hour},
+		Segments:               config.DefaultSegmentNaming(),
+		SegmentCacheSize:       256 << 20,
+		PrefetchSegments:       prefetch.DefaultAhead,
+		CDNBaseURL:             "https://cdn.example.com",
+		CDNUpstream:            upstream.DefaultOptions(),
+		CDNProxyMaxBytes:       handlers.DefaultProxyMaxBytes,
+		CDN:                    cdn,
+		StaticDir:              "./static",
+		TemplatesDir:           "templates",
+		SessionTTL:             12 * time.Hour,
+		OwnershipSync:          time.Minute,
+		RevocationSync:         30 * time.Second,
+		ICEServers:             []webrtc.ICEServer{{URLs: []string{"stun:stun.l.google.com:19302"}}},
+		PreflightMinUplinkKbps: 1500,
+		PreflightMaxSessions:   16,
+		WorkDir:                "/tmp",
+		WorkDirMinFreeBytes:    1024 << 20,
+		CaptureMaxBytes:        200 << 20,
+		CaptureTotalBytes:      2048 << 20,
+		CaptureRetention:       24 * time.Hour,
+		FailoverStallTimeout:   12 * time.Second,
+		InputFailoverWindow:    5 * time.Second,
+		Idle:                   broadcast.IdlePolicy{IdleTimeout: 10 * time.Minute, InputTimeout: 2 * time.Minute},
+		ViewerSessionTimeout:   30 * time.Second,
+		EncoderCPUHigh:         90,
+		StreamPrimeLead:        15 * time.Minute,
+		ReconcileOrphansAfter:  10 * time.Minute,
+		ReconcileInterval:      5 * time.Minute,
+		CatalogSync:            time.Minute,
+		CatalogReconcile:       time.Hour,
+		UsageRebuild:           time.Hour,
+		AuditInterval:          24 * time.Hour,
+		Audit:                  audit.DefaultOptions(),
+		PlaybackCheckTimeout:   2 * time.Minute,
+		CostPrices:             cost.DefaultPrices(),
+		BillingExportInterval:  time.Hour,
+		BillingCSVPrefix:       "billing",
+		LeaderLeaseName:        "live-video-janitor",
+		LeaderLeaseDuration:    15 * time.Second,
+		WebhookEvents:          []string{"stream.*"},
+	}
+}
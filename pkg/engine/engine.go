@@ -0,0 +1,708 @@
+// Package engine assembles the streaming service, its storage, broadcast,
+// transcoding and account services and their HTTP API, into one value. It
+// is what cmd/server runs; other Go programs can embed it and mount its
+// Handler into their own mux without using Gin themselves.
+package engine
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"live-video/config"
+	"live-video/internal/api"
+	"live-video/internal/handlers"
+	"live-video/pkg/archive"
+	"live-video/pkg/audit"
+	"live-video/pkg/auth"
+	"live-video/pkg/background"
+	"live-video/pkg/billing"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/captions"
+	"live-video/pkg/catalog"
+	"live-video/pkg/collections"
+	"live-video/pkg/conformance"
+	"live-video/pkg/erasure"
+	"live-video/pkg/events"
+	"live-video/pkg/geoip"
+	"live-video/pkg/integrity"
+	"live-video/pkg/interactions"
+	"live-video/pkg/jobs"
+	"live-video/pkg/lease"
+	"live-video/pkg/orchestrator"
+	"live-video/pkg/ownership"
+	"live-video/pkg/prefetch"
+	"live-video/pkg/preview"
+	"live-video/pkg/qoe"
+	"live-video/pkg/region"
+	"live-video/pkg/revocation"
+	"live-video/pkg/slate"
+	"live-video/pkg/staging"
+	"live-video/pkg/storage"
+	"live-video/pkg/synthetic"
+	"live-video/pkg/upstream"
+	"live-video/pkg/usage"
+	"live-video/pkg/viewers"
+	"live-video/pkg/vod"
+	"live-video/pkg/watchparty"
+	"live-video/pkg/watermark"
+	"live-video/pkg/webhook"
+	"live-video/pkg/webrtc"
+	"live-video/pkg/workdir"
+)
+
+// Engine is a running streaming service. Its background work (monitors,
+// sweepers, the leader's janitors) starts with New and runs until Close or
+// until the context given to New ends.
+type Engine struct {
+	handler    http.Handler
+	background *background.Group
+	broadcasts *broadcast.BroadcastManager
+	storage    *storage.GCSService
+	auth       *auth.Service
+	usage      *usage.Ledger
+	history    *viewers.History
+	events     *events.Bus
+}
+
+// New builds the service of cfg: it connects to the bucket, restores the
+// streams and jobs the last shutdown left and starts background work, which
+// stops with Close or when ctx ends
+func New(ctx context.Context, cfg Config) (*Engine, error) {
+	if err := cfg.StorageLayout.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid storage layout: %w", err)
+	}
+	if err := cfg.CostPrices.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid cost prices: %w", err)
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+	}
+	// The service's own outputs must never be ingested again
+	if cfg.IngestWatchPrefix != "" {
+		if strings.Trim(cfg.IngestWatchPrefix, "/") == "" || cfg.StorageLayout.Overlaps(cfg.IngestWatchPrefix) {
+			return nil, fmt.Errorf("invalid ingest watch prefix %q: it overlaps the storage layout", cfg.IngestWatchPrefix)
+		}
+		if cfg.PubSubPushToken == "" {
+			return nil, fmt.Errorf("ingest watch prefix set without a Pub/Sub push token")
+		}
+	}
+	videoFolder := cfg.StorageLayout.VOD
+	bodyLimits := handlers.DefaultBodyLimits()
+	for class, limit := range cfg.BodyLimits {
+		bodyLimits[class] = limit
+	}
+
+	// Packages publish what happens to them on the event bus, relayed to
+	// the other replicas when a backend is set
+	var backend events.Backend
+	if cfg.EventBusURL != "" {
+		var err error
+		if backend, err = events.OpenBackend(cfg.EventBusURL); err != nil {
+			return nil, fmt.Errorf("invalid event bus: %w", err)
+		}
+	}
+
+	// Validate local work directories
+	workDir, err := workdir.New(cfg.WorkDir, cfg.WorkDirFast, cfg.WorkDirMinFreeBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid work directory: %w", err)
+	}
+	log.Printf("✓ Work directory: %s (live: %s)", workDir.Root(), workDir.Fast())
+
+	// Initialize GCS service
+	gcsService, err := storage.NewGCSService(ctx, cfg.GCSBucket, cfg.GCSCredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize GCS service: %w", err)
+	}
+	gcsService.SetLayout(cfg.StorageLayout)
+	gcsService.SetOperationPolicies(cfg.StoragePolicies)
+	log.Println("✓ GCS service initialized")
+
+	// URLs are signed as the configured service account, or the one the
+	// service runs as
+	serviceAccount, source := cfg.GCSServiceAccount, storage.IdentityConfigured
+	if serviceAccount == "" {
+		serviceAccount, source, err = storage.DiscoverServiceAccount(ctx, cfg.GCSCredentialsFile)
+	}
+	if serviceAccount != "" {
+		gcsService.SetServiceAccount(serviceAccount)
+		log.Printf("✓ URLs signed as %s (%s)", serviceAccount, source)
+	} else {
+		log.Printf("⚠ No service account to sign URLs as (%v), set GCS_SERVICE_ACCOUNT", err)
+	}
+	for _, class := range []string{storage.OpMetadata, storage.OpRead, storage.OpWrite} {
+		policy := cfg.StoragePolicies[class]
+		log.Printf("  GCS %s operations: timeout %s, %d attempts", class, policy.Timeout, policy.MaxAttempts)
+	}
+	// Background work runs in group, so it ends before the bucket client
+	// closes. Past this point a failure leaves both to stop.
+	group := background.NewGroup(ctx)
+	fail := func(err error) (*Engine, error) {
+		group.Stop()
+		gcsService.Close()
+		return nil, err
+	}
+
+	bus := events.NewBus(lease.Identity())
+	if backend != nil {
+		bus.Connect(backend)
+		log.Printf("✓ Events relayed through %s as %s", backend, bus.Node())
+	}
+
+	// Singleton tasks run on the replica holding the lease. Without leader
+	// election every replica runs them.
+	var leaseLock lease.Lock
+	switch cfg.LeaderElection {
+	case "":
+	case "kubernetes":
+		lock, err := lease.NewKubernetesLock(cfg.LeaderLeaseNamespace, cfg.LeaderLeaseName)
+		if err != nil {
+			return fail(fmt.Errorf("failed to set up leader election: %w", err))
+		}
+		leaseLock = lock
+	default:
+		return fail(fmt.Errorf("invalid leader election %q (kubernetes or empty)", cfg.LeaderElection))
+	}
+	elector := lease.NewElector(leaseLock, lease.Identity(), cfg.LeaderLeaseDuration)
+	elector.Start(group)
+	if leaseLock != nil {
+		log.Printf("✓ Leader election on %s as %s (leader: %t)", leaseLock, lease.Identity(), elector.IsLeader())
+	}
+
+	// Bucket settings are only written when configured, since they need
+	// bucket admin rights
+	if !cfg.BucketSettings.Empty() && elector.IsLeader() {
+		if report, err := gcsService.Bootstrap(ctx, cfg.BucketSettings, false); err != nil {
+			log.Printf("⚠ Failed to apply bucket settings: %v", err)
+		} else if len(report.Changed) > 0 {
+			log.Printf("✓ Bucket settings applied (changed: %s)", strings.Join(report.Changed, ", "))
+		} else {
+			log.Println("✓ Bucket settings up to date")
+		}
+	}
+
+	// Track bucket storage per asset as objects are written and deleted
+	usageLedger, err := usage.NewLedger(cfg.StorageLayout, filepath.Join(workDir.Usage(), "ledger.json"))
+	if err != nil {
+		return fail(fmt.Errorf("failed to load storage usage ledger: %w", err))
+	}
+	usageLedger.StartFlusher(group, 30*time.Second)
+	gcsService.SetUsageRecorder(usageLedger)
+	log.Printf("✓ Storage usage ledger loaded (%d assets)", len(usageLedger.Assets()))
+	if cfg.UsageRebuild > 0 {
+		usageLedger.StartRebuilder(group, gcsService, cfg.UsageRebuild)
+		log.Printf("✓ Storage usage ledger rebuilt from the bucket every %s", cfg.UsageRebuild)
+	}
+
+	// Watch history of signed in viewers, for "continue watching"
+	history, err := viewers.NewHistory(filepath.Join(workDir.Viewers(), "history.json"))
+	if err != nil {
+		return fail(fmt.Errorf("failed to load watch history: %w", err))
+	}
+	history.StartFlusher(group, 30*time.Second)
+
+	// Initialize broadcast manager
+	broadcastManager := broadcast.NewBroadcastManager(workDir)
+	broadcastManager.StartFailoverMonitor(group, cfg.FailoverStallTimeout)
+	broadcastManager.SetViewerSessionTimeout(cfg.ViewerSessionTimeout)
+	broadcastManager.SetRecordDir(workDir.StreamRecords())
+	broadcastManager.SetEvents(bus)
+	broadcastManager.StartBroadcasterMessages(group)
+	// Deleting a stream deletes its live output in the bucket too
+	broadcastManager.SetOutputCleaner(func(ctx context.Context, streamID string) error {
+		_, err := gcsService.DeleteLiveOutput(ctx, streamID)
+		return err
+	})
+	log.Println("✓ Broadcast manager initialized")
+
+	// Webhook notifications
+	notifier := webhook.NewNotifier(cfg.WebhookURL, cfg.WebhookSecret)
+	if notifier != nil {
+		notifier.Forward(bus, cfg.WebhookEvents...)
+		log.Printf("✓ Webhook notifications enabled: %s (%s)", cfg.WebhookURL, strings.Join(cfg.WebhookEvents, ", "))
+	}
+
+	// Stop streams that were started but are not fed or watched anymore
+	broadcastManager.StartIdleMonitor(group, cfg.Idle)
+	if cfg.Idle.IdleTimeout > 0 || cfg.Idle.InputTimeout > 0 {
+		log.Printf("✓ Idle stream monitor: idle %s, no input %s (0 = off)", cfg.Idle.IdleTimeout, cfg.Idle.InputTimeout)
+	}
+
+	// Transcoders kept running on a placeholder for instant stream starts
+	var warmPool *orchestrator.WarmPool
+	if cfg.WarmPoolSize > 0 {
+		warmPool = orchestrator.NewWarmPool(cfg.WarmPoolSize, workDir.WarmHLS(), cfg.Segments)
+		log.Printf("✓ Encoder warm pool: %d transcoders", cfg.WarmPoolSize)
+	}
+
+	// Pipelines encode less while they fall behind or the node is busy
+	var loadGovernor *orchestrator.LoadGovernor
+	if cfg.EncoderCPUHigh > 0 {
+		loadGovernor = orchestrator.NewLoadGovernor(float64(cfg.EncoderCPUHigh) / 100)
+		// Uploads taking half a segment leave no room for bursts
+		segmentDuration := time.Duration(config.DefaultFFmpegConfig().SegmentDuration) * time.Second
+		loadGovernor.SetUploadTime(gcsService.SegmentUploadTime, segmentDuration/2)
+		log.Printf("✓ Encoder load degradation: node CPU above %d%%", cfg.EncoderCPUHigh)
+	}
+
+	// Initialize accounts
+	authService := auth.NewService()
+	if cfg.AccountsFile != "" {
+		if err := authService.LoadFile(cfg.AccountsFile); err != nil {
+			return fail(fmt.Errorf("failed to load accounts: %w", err))
+		}
+		log.Printf("✓ Accounts loaded (%d users)", len(authService.ListUsers()))
+	} else {
+		log.Println("⚠ No accounts file set, multi-user accounts disabled")
+	}
+	// Who owns what is kept in the bucket, shared by every replica
+	ownershipStore := ownership.NewStore(gcsService, bus, authService)
+	ownershipStore.Start(group, cfg.OwnershipSync)
+	authService.SetOwnershipStore(ownershipStore)
+	log.Printf("✓ Ownership loaded (%d records)", ownershipStore.Len())
+
+	// Live stream limits count each stream against its owner
+	broadcastManager.SetLimits(cfg.Limits, func(streamID string) string {
+		if own, ok := authService.GetOwnership(auth.ResourceStream, streamID); ok {
+			return own.OwnerID
+		}
+		return ""
+	})
+	if cfg.Limits.MaxLive > 0 || cfg.Limits.MaxLivePerTenant > 0 {
+		log.Printf("✓ Live stream limits: %d per node, %d per tenant (0 = unlimited)", cfg.Limits.MaxLive, cfg.Limits.MaxLivePerTenant)
+	}
+	if cfg.SRT.FirstPort > 0 {
+		broadcastManager.SetSRT(cfg.SRT)
+		log.Printf("✓ SRT ingest on %s ports %d-%d", cfg.SRT.Host, cfg.SRT.FirstPort, cfg.SRT.LastPort)
+	}
+
+	// Initialize SSO providers
+	var oidcProviders []*auth.OIDCProvider
+	if cfg.OIDCProvidersFile != "" {
+		configs, err := auth.LoadOIDCProviders(cfg.OIDCProvidersFile)
+		if err != nil {
+			return fail(fmt.Errorf("failed to load OIDC providers: %w", err))
+		}
+		for _, providerConfig := range configs {
+			provider, err := auth.NewOIDCProvider(ctx, providerConfig)
+			if err != nil {
+				return fail(fmt.Errorf("failed to initialize OIDC provider %s: %w", providerConfig.Name, err))
+			}
+			oidcProviders = append(oidcProviders, provider)
+		}
+		authService.EnableSSO()
+		log.Printf("✓ SSO enabled (%d providers)", len(oidcProviders))
+	}
+
+	// Initialize transcode job manager
+	jobManager := jobs.NewManager(workDir.Jobs())
+	jobManager.SetEvents(bus)
+	log.Println("✓ Job manager initialized")
+
+	// Initialize the VOD source staging area
+	stagingArea, err := staging.New(workDir.Staging(), workDir.Quarantine(), cfg.Staging)
+	if err != nil {
+		return fail(fmt.Errorf("failed to initialize staging area: %w", err))
+	}
+	stagingArea.StartSweeper(group, 10*time.Minute)
+	log.Printf("✓ Staging area initialized (%d staged sources)", len(stagingArea.List("")))
+
+	// Initialize broadcaster preflight checks
+	preflightService := webrtc.NewPreflightService(webrtc.PreflightConfig{
+		ICEServers:    cfg.ICEServers,
+		ProbeDuration: 5 * time.Second,
+		MinUplinkKbps: cfg.PreflightMinUplinkKbps,
+		MaxSessions:   cfg.PreflightMaxSessions,
+		ReportTTL:     10 * time.Minute,
+	})
+
+	// Initialize raw RTP debug capture storage
+	captureDir := cfg.CaptureDir
+	if captureDir == "" {
+		captureDir = workDir.Captures()
+	}
+	captureStore, err := webrtc.NewCaptureStore(captureDir, cfg.CaptureMaxBytes, cfg.CaptureTotalBytes, cfg.CaptureRetention)
+	if err != nil {
+		return fail(fmt.Errorf("failed to initialize debug capture store: %w", err))
+	}
+
+	// Embed tokens are signed with a configured secret so they survive
+	// restarts and work across instances
+	embedSigner, err := auth.NewEmbedSigner(cfg.EmbedTokenSecret)
+	if err != nil {
+		return fail(fmt.Errorf("failed to initialize embed tokens: %w", err))
+	}
+	if cfg.EmbedTokenSecret == "" {
+		log.Println("⚠ No embed token secret set, embed tokens are invalidated on restart")
+	}
+	// Revoked embed tokens are rejected on every replica
+	revocations := revocation.NewList(gcsService, bus, auth.MaxEmbedTokenTTL)
+	revocations.Start(group, cfg.RevocationSync)
+	embedSigner.SetRevoker(revocations)
+	log.Printf("✓ Embed token revocations synced every %s", cfg.RevocationSync)
+
+	// Playlists are signed for tamper detection when a key is set
+	var signer *integrity.Signer
+	if cfg.IntegrityKey != "" {
+		signer, err = integrity.NewSigner(cfg.IntegrityKey)
+		if err != nil {
+			return fail(fmt.Errorf("invalid integrity signing key: %w", err))
+		}
+		log.Printf("✓ Playlists signed with key %s", signer.KeyID())
+	}
+
+	// CDN URLs are signed when the CDN backend only serves signed requests
+	cdnSigner, err := storage.NewCDNSigner(cfg.CDN)
+	if err != nil {
+		return fail(fmt.Errorf("invalid CDN signing settings: %w", err))
+	}
+	if cdnSigner != nil {
+		log.Printf("✓ CDN URLs and cookies signed with key %s for %s", cdnSigner.KeyName(), cdnSigner.TTL())
+	}
+
+	// Initialize viewer geolocation
+	var geoDB *geoip.DB
+	if cfg.GeoIPDatabase != "" {
+		geoDB, err = geoip.Open(cfg.GeoIPDatabase)
+		if err != nil {
+			return fail(fmt.Errorf("failed to load GeoIP database: %w", err))
+		}
+		log.Printf("✓ GeoIP database loaded (%d networks)", geoDB.Len())
+	}
+	locator := geoip.NewLocator(geoDB, cfg.GeoIPCountryHeader)
+	audience := geoip.NewAudience(locator)
+
+	// Initialize ingest regions
+	var ingestRegions *region.Selector
+	if cfg.IngestRegionsFile != "" {
+		regions, err := region.Load(cfg.IngestRegionsFile)
+		if err != nil {
+			return fail(err)
+		}
+		if ingestRegions, err = region.NewSelector(regions, locator); err != nil {
+			return fail(fmt.Errorf("invalid ingest regions: %w", err))
+		}
+		log.Printf("✓ Ingest regions: %d", len(regions))
+	}
+
+	// Download rates of playback sessions, observed by the proxies
+	bandwidth := qoe.NewBandwidth()
+
+	// Catalog of published videos, which video listings are served from
+	videoCatalog := catalog.NewCatalog(gcsService, bus)
+	videoCatalog.Start(group, cfg.CatalogSync)
+	log.Printf("✓ Video catalog loaded (%d videos)", videoCatalog.Len())
+
+	// Initialize handlers
+	videoHandler := handlers.NewVideoHandler(gcsService, broadcastManager, jobManager, authService, videoFolder, workDir, stagingArea)
+	videoHandler.SetPreserveOriginals(cfg.PreserveOriginals, cfg.OriginalsStorageClass)
+	if cfg.PreserveOriginals {
+		log.Printf("✓ Original uploads preserved (%s)", cfg.OriginalsStorageClass)
+	}
+	videoHandler.SetDeinterlacer(cfg.Deinterlacer)
+	videoHandler.SetAudioMix(cfg.Downmix, cfg.Surround)
+	videoHandler.SetSigner(signer)
+	videoHandler.SetEmbedSigner(embedSigner)
+	videoHandler.SetBandwidth(bandwidth)
+	videoHandler.SetHistory(history)
+	videoHandler.SetProgress(jobs.NewProgressTracker())
+	videoHandler.SetSegmentNaming(cfg.Segments)
+	videoHandler.SetCatalog(videoCatalog)
+	broadcastHandler := handlers.NewBroadcastHandler(broadcastManager, gcsService, authService, embedSigner, audience)
+	broadcastHandler.SetWarmPool(warmPool)
+	broadcastHandler.SetLoadGovernor(loadGovernor)
+	broadcastHandler.SetSigner(signer)
+	broadcastHandler.SetSegmentNaming(cfg.Segments)
+	broadcastHandler.SetBandwidth(bandwidth)
+	broadcastHandler.SetHistory(history)
+	broadcastHandler.SetCDNSigner(cdnSigner)
+	watermarks := watermark.NewLedger(workDir.Watermarks())
+	broadcastHandler.SetWatermarks(watermarks)
+	previews := qoe.NewPreviews()
+	broadcastHandler.SetPreviews(previews)
+	broadcastHandler.SetFailoverWindow(cfg.InputFailoverWindow)
+	if cfg.ASRURL != "" {
+		var translator captions.Translator
+		if cfg.CaptionTranslateURL != "" {
+			translator = captions.NewHTTPTranslator(cfg.CaptionTranslateURL, cfg.CaptionTranslateToken)
+		}
+		broadcastHandler.SetCaptioning(captions.NewHTTPRecognizer(cfg.ASRURL, cfg.ASRToken), translator)
+		log.Printf("✓ Live captions transcribed by %s (translation: %v)", cfg.ASRURL, translator != nil)
+	}
+	broadcastHandler.SetSourcePolicy(cfg.SourcePolicy)
+	broadcastHandler.SetEvents(bus)
+	if ingestRegions != nil {
+		broadcastHandler.SetIngestRegions(ingestRegions)
+	}
+	if cfg.SegmentCacheSize > 0 {
+		segmentCache := prefetch.NewCache(gcsService.ReadFileParallel, cfg.SegmentCacheSize, cfg.PrefetchSegments)
+		videoHandler.SetSegmentCache(segmentCache)
+		broadcastHandler.SetSegmentCache(segmentCache)
+		log.Printf("✓ HLS proxy caching %d MB of segments, prefetching %d ahead", cfg.SegmentCacheSize>>20, cfg.PrefetchSegments)
+	}
+	if cfg.StreamPrimeLead > 0 {
+		primer := slate.NewPrimer(gcsService, workDir.Slates(), cfg.SlateImage)
+		primer.Start(group, broadcastManager, cfg.StreamPrimeLead)
+		broadcastHandler.SetPrimer(primer)
+		log.Printf("✓ Scheduled streams primed with a slate %s before start", cfg.StreamPrimeLead)
+	}
+	hlsProxyHandler := handlers.NewHLSProxyHandler(upstream.New(cfg.CDNBaseURL, cfg.CDNUpstream))
+	hlsProxyHandler.SetMaxBytes(cfg.CDNProxyMaxBytes)
+	hlsProxyHandler.SetBandwidth(bandwidth)
+	hlsProxyHandler.SetCDNSigner(cdnSigner)
+	hlsProxyHandler.SetPlayback(broadcastManager, authService, embedSigner)
+	readinessHandler := handlers.NewReadinessHandler()
+	readinessHandler.AddUpstream("cdn", hlsProxyHandler.Upstream())
+	embedHandler := handlers.NewEmbedHandler(broadcastManager, embedSigner, authService)
+	embedHandler.SetPublicBaseURL(cfg.PublicBaseURL)
+	collector := qoe.NewCollector()
+	collector.SetEvents(bus)
+	qoeHandler := handlers.NewQoEHandler(qoe.NewExperiments(), collector, broadcastManager, authService, embedSigner, audience)
+	qoeHandler.SetBandwidth(bandwidth)
+	qoeHandler.SetHistory(history)
+	qoeHandler.SetPreviews(previews)
+	recorder := interactions.NewRecorder(workDir.Interactions(), gcsService)
+	recorder.Follow(bus)
+
+	// A synthetic viewer plays every stream once it starts
+	if cfg.PlaybackCheckTimeout > 0 {
+		player := synthetic.NewPlayer(broadcastManager, gcsService.GetHLSMasterPlaylistURL, cfg.PlaybackCheckTimeout)
+		if cdnSigner != nil {
+			player.SetSigner(func(streamID, uri string) string {
+				prefix := strings.TrimSuffix(gcsService.GetHLSMasterPlaylistURL(streamID), vod.PlaylistName)
+				if !strings.HasPrefix(uri, prefix) {
+					return uri
+				}
+				return uri + "?" + cdnSigner.PrefixQuery(prefix)
+			})
+		}
+		player.Follow(bus)
+		log.Printf("✓ Synthetic playback check of started streams (waits up to %s)", cfg.PlaybackCheckTimeout)
+	}
+	collectionStore := collections.NewStore(gcsService)
+	var verifyKey ed25519.PublicKey
+	if signer != nil {
+		verifyKey = signer.PublicKey()
+	}
+	auditor := audit.NewAuditor(gcsService, verifyKey, cfg.Audit)
+	auditor.SetEvents(bus)
+	tombstones := erasure.NewTombstones(gcsService)
+	gcsIngestHandler := handlers.NewGCSIngestHandler(videoHandler, jobManager, cfg.IngestWatchPrefix, cfg.PubSubPushToken)
+	eraser := newEraser(tombstones, erasureStores{
+		gcs:        gcsService,
+		broadcasts: broadcastManager,
+		auth:       authService,
+		jobs:       jobManager,
+		staging:    stagingArea,
+		usage:      usageLedger,
+		recorder:   recorder,
+		qoe:        collector,
+		audience:   audience,
+		captures:   captureStore,
+		history:    history,
+		collection: collectionStore,
+		catalog:    videoCatalog,
+		watermarks: watermarks,
+		audits:     auditor,
+		ingest:     gcsIngestHandler,
+	})
+	eraser.SetEvents(bus)
+	eraser.Follow(bus)
+
+	// Billing export of what each tenant used on this replica
+	var meter *billing.Meter
+	if cfg.BillingExport != "" {
+		var sink billing.Sink
+		switch cfg.BillingExport {
+		case "gcs":
+			sink = billing.NewGCSSink(gcsService, cfg.BillingCSVPrefix)
+		case "bigquery":
+			if sink, err = billing.NewBigQuerySink(ctx, cfg.BillingBigQueryTable, cfg.GCSCredentialsFile); err != nil {
+				return fail(fmt.Errorf("failed to initialize billing export: %w", err))
+			}
+		default:
+			return fail(fmt.Errorf("unknown billing export: %s", cfg.BillingExport))
+		}
+		meter, err = billing.NewMeter(lease.Identity(), filepath.Join(workDir.Usage(), "billing.json"), func(assetID string) string {
+			for _, kind := range []string{auth.ResourceVideo, auth.ResourceStream} {
+				if own, ok := authService.GetOwnership(kind, assetID); ok {
+					return own.OwnerID
+				}
+			}
+			return ""
+		})
+		if err != nil {
+			return fail(fmt.Errorf("failed to initialize billing meter: %w", err))
+		}
+		meter.Start(group, billing.Sources{Bus: bus, Streams: broadcastManager, Bucket: gcsService, Elector: elector, Jobs: jobManager}, sink, cfg.BillingExportInterval)
+		log.Printf("✓ Billing export to %s every %s", sink, cfg.BillingExportInterval)
+	}
+	routes := &routeHandlers{
+		video:     videoHandler,
+		broadcast: broadcastHandler,
+		hlsProxy:  hlsProxyHandler,
+		ready:     readinessHandler,
+		limits:    bodyLimits,
+		v1Sunset:  api.Deprecation{Since: cfg.V1DeprecatedAt, Sunset: cfg.V1Sunset},
+		proxies:   cfg.TrustedProxies,
+		gcsIngest: gcsIngestHandler,
+		archive:   handlers.NewArchiveHandler(archive.NewArchiver(gcsService, gcsService.Layout(), cfg.ArchiveStorageClass), broadcastManager, authService),
+		account:   handlers.NewAccountHandler(authService),
+		revoke:    handlers.NewRevocationHandler(revocations, embedSigner, authService),
+		oidc:      handlers.NewOIDCHandler(authService, oidcProviders, cfg.SessionTTL),
+		preflight: handlers.NewPreflightHandler(preflightService, authService),
+		debug:     handlers.NewDebugHandler(captureStore, broadcastManager, authService),
+		event:     handlers.NewEventHandler(broadcastManager, authService),
+		qoe:       qoeHandler,
+		embed:     embedHandler,
+		geo:       handlers.NewGeoHandler(audience, broadcastManager, authService),
+		storage:   handlers.NewStorageHandler(gcsService, cfg.BucketSettings, authService),
+		usage:     handlers.NewUsageHandler(usageLedger, gcsService, authService),
+		cost:      handlers.NewCostHandler(cfg.CostPrices, authService),
+		billing:   handlers.NewBillingHandler(meter, authService),
+		party:     handlers.NewWatchPartyHandler(watchparty.NewManager(), broadcastManager, gcsService, authService, embedSigner),
+		preview:   handlers.NewPreviewHandler(preview.NewTracker(2), gcsService, broadcastManager, authService, embedSigner, videoFolder, workDir),
+		conform:   handlers.NewConformanceHandler(conformance.NewTracker(2), gcsService, broadcastManager, authService, videoFolder),
+		clip:      handlers.NewClipHandler(jobManager, gcsService, broadcastManager, authService, embedSigner, workDir, 2),
+		interact:  handlers.NewInteractionHandler(recorder, broadcastManager, authService, embedSigner),
+		erasure:   handlers.NewErasureHandler(eraser, tombstones, authService),
+		audit:     handlers.NewAuditHandler(auditor, authService),
+		history:   handlers.NewHistoryHandler(history, authService),
+		collect:   handlers.NewCollectionHandler(collectionStore, gcsService, history, authService, embedSigner, videoFolder),
+		integrity: handlers.NewIntegrityHandler(signer),
+		auth:      authService,
+		staticDir: cfg.StaticDir,
+		templates: cfg.TemplatesDir,
+	}
+	log.Println("✓ Handlers initialized")
+
+	// Resume transcode jobs interrupted by the last shutdown
+	if err := videoHandler.RecoverJobs(); err != nil {
+		log.Printf("⚠ Failed to recover transcode jobs: %v", err)
+	}
+
+	// Save the interactions of broadcasts the last shutdown cut short,
+	// before restored streams begin new sessions
+	if saved, err := recorder.Recover(ctx); err != nil {
+		log.Printf("⚠ Failed to save interrupted interaction sessions: %v", err)
+	} else if saved > 0 {
+		log.Printf("✓ Interaction sessions saved: %d interrupted", saved)
+	}
+
+	// Restore streams and end the live playlists the last shutdown left open.
+	// Only the leader ends those of streams without a record.
+	orphanAge := cfg.ReconcileOrphansAfter
+	if !elector.IsLeader() {
+		orphanAge = 0
+	}
+	if report, err := broadcastHandler.ReconcileStreams(ctx, orphanAge); err != nil {
+		log.Printf("⚠ Failed to reconcile streams: %v", err)
+	} else {
+		log.Printf("✓ Streams reconciled: %d restored, %d interrupted, %d playlists ended", report.Streams, len(report.Interrupted), len(report.Finalized))
+	}
+
+	// Bucket janitors, run by the leader only
+	if cfg.ReconcileInterval > 0 && cfg.ReconcileOrphansAfter > 0 {
+		elector.Every("reconcile", cfg.ReconcileInterval, func(ctx context.Context) {
+			if _, err := broadcastHandler.EndOrphanedPlaylists(ctx, cfg.ReconcileOrphansAfter); err != nil {
+				log.Printf("[Broadcast] Failed to end orphaned playlists: %v", err)
+			}
+		})
+		log.Printf("✓ Orphaned live playlists ended every %s", cfg.ReconcileInterval)
+	}
+	if cfg.LiveSegmentRetention > 0 {
+		elector.Every("segment-cleanup", time.Hour, func(ctx context.Context) {
+			deleted, err := gcsService.DeleteOldLiveSegments(ctx, cfg.LiveSegmentRetention)
+			if err != nil {
+				log.Printf("[Storage] Failed to delete old live segments: %v", err)
+			}
+			if deleted > 0 {
+				log.Printf("[Storage] Deleted %d live segments older than %s", deleted, cfg.LiveSegmentRetention)
+			}
+		})
+		log.Printf("✓ Live segments deleted after %s", cfg.LiveSegmentRetention)
+	}
+	if cfg.CatalogReconcile > 0 {
+		elector.Every("catalog-reconcile", cfg.CatalogReconcile, func(ctx context.Context) {
+			added, removed, err := videoCatalog.Reconcile(ctx, videoHandler.DescribeVideo)
+			if err != nil {
+				log.Printf("[Catalog] Failed to reconcile the catalog: %v", err)
+			}
+			if added > 0 || removed > 0 {
+				log.Printf("[Catalog] Reconciled the catalog: %d videos added, %d removed", added, removed)
+			}
+		})
+		log.Printf("✓ Video catalog reconciled with the bucket every %s", cfg.CatalogReconcile)
+	}
+	if cfg.AuditInterval > 0 {
+		elector.Every("integrity-audit", cfg.AuditInterval, func(ctx context.Context) {
+			if _, err := auditor.Run(ctx, "scheduled"); err != nil {
+				log.Printf("[Audit] Skipped scheduled audit: %v", err)
+			}
+		})
+		log.Printf("✓ Stored assets audited every %s", cfg.AuditInterval)
+	}
+
+	router, err := newRouter(routes)
+	if err != nil {
+		return fail(err)
+	}
+
+	return &Engine{
+		handler:    router,
+		background: group,
+		broadcasts: broadcastManager,
+		storage:    gcsService,
+		auth:       authService,
+		usage:      usageLedger,
+		history:    history,
+		events:     bus,
+	}, nil
+}
+
+// Handler serves the service's HTTP API and web UI. Routes keep their paths
+// (/api/v1/..., /api/v2/..., /watch/...), so mount it where requests reach
+// it unchanged, e.g. mux.Handle("/api/", e.Handler()), rather than under
+// http.StripPrefix: responses link to those paths.
+func (e *Engine) Handler() http.Handler {
+	return e.handler
+}
+
+// Broadcasts returns the manager of the service's live streams
+func (e *Engine) Broadcasts() *broadcast.BroadcastManager {
+	return e.broadcasts
+}
+
+// Storage returns the service's bucket client
+func (e *Engine) Storage() *storage.GCSService {
+	return e.storage
+}
+
+// Auth returns the service's accounts
+func (e *Engine) Auth() *auth.Service {
+	return e.auth
+}
+
+// Events returns the service's event bus. Subscribers get what streams,
+// pipelines and jobs do; see the topics of package events.
+func (e *Engine) Events() *events.Bus {
+	return e.events
+}
+
+// Close stops the background work and waits for it, then saves the storage
+// usage ledger and watch history, stops relaying events and closes the
+// bucket client. Streams and requests in flight are left to the caller.
+func (e *Engine) Close() error {
+	e.background.Stop()
+	return errors.Join(e.usage.Flush(), e.history.Flush(), e.events.Close(), e.storage.Close())
+}
@@ -0,0 +1,438 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"live-video/internal/handlers"
+	"live-video/pkg/audit"
+	"live-video/pkg/auth"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/catalog"
+	"live-video/pkg/collections"
+	"live-video/pkg/erasure"
+	"live-video/pkg/geoip"
+	"live-video/pkg/interactions"
+	"live-video/pkg/jobs"
+	"live-video/pkg/qoe"
+	"live-video/pkg/staging"
+	"live-video/pkg/storage"
+	"live-video/pkg/usage"
+	"live-video/pkg/viewers"
+	"live-video/pkg/watermark"
+	"live-video/pkg/webrtc"
+)
+
+// erasureStores are the places the service keeps data of videos, streams
+// and users
+type erasureStores struct {
+	gcs        *storage.GCSService
+	broadcasts *broadcast.BroadcastManager
+	auth       *auth.Service
+	jobs       *jobs.Manager
+	staging    *staging.Area
+	usage      *usage.Ledger
+	recorder   *interactions.Recorder
+	qoe        *qoe.Collector
+	audience   *geoip.Audience
+	captures   *webrtc.CaptureStore
+	history    *viewers.History
+	collection *collections.Store
+	catalog    *catalog.Catalog
+	watermarks *watermark.Ledger
+	audits     *audit.Auditor
+	ingest     *handlers.GCSIngestHandler
+}
+
+// newEraser registers every store with an eraser. Stores that write what
+// later ones erase come first: jobs and streams upload to the bucket.
+func newEraser(tombstones *erasure.Tombstones, s erasureStores) *erasure.Eraser {
+	eraser := erasure.NewEraser(tombstones)
+	assets := []string{erasure.KindVideo, erasure.KindStream}
+
+	// A user goes with what they own, a primary stream with its backup
+	eraser.SetRelated(func(subject erasure.Subject) []erasure.Subject {
+		var related []erasure.Subject
+		switch subject.Kind {
+		case erasure.KindUser:
+			for kind, ids := range s.auth.Owned(subject.ID) {
+				for _, id := range ids {
+					related = append(related, erasure.Subject{Kind: kind, ID: id})
+				}
+			}
+		case erasure.KindStream:
+			if stream, err := s.broadcasts.GetStream(subject.ID); err == nil && stream.Backup() != "" {
+				related = append(related, erasure.Subject{Kind: erasure.KindStream, ID: stream.Backup()})
+			}
+		}
+		return related
+	})
+
+	eraser.AddStore(erasure.Store{
+		Name:  "jobs",
+		Kinds: assets,
+		Local: true,
+		InUse: func(subject erasure.Subject) error {
+			for _, job := range s.jobs.ForAsset(subject.ID) {
+				if job.Status == jobs.StatusQueued || job.Status == jobs.StatusProcessing {
+					return fmt.Errorf("job %s is %s", job.ID, job.Status)
+				}
+			}
+			return nil
+		},
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			return s.jobs.Forget(subject.ID), nil
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			return len(s.jobs.ForAsset(subject.ID)), nil
+		},
+	})
+
+	stagedSources := func(videoID string) []*staging.Entry {
+		var entries []*staging.Entry
+		for _, entry := range s.staging.List("") {
+			if entry.VideoID == videoID {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+	eraser.AddStore(erasure.Store{
+		Name:  "staging",
+		Kinds: []string{erasure.KindVideo},
+		Local: true,
+		InUse: func(subject erasure.Subject) error {
+			for _, entry := range stagedSources(subject.ID) {
+				if entry.State == staging.StateConverting {
+					return fmt.Errorf("staged source %s is being converted", entry.ID)
+				}
+			}
+			return nil
+		},
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			removed := 0
+			var errs []error
+			for _, entry := range stagedSources(subject.ID) {
+				if err := s.staging.Remove(entry.ID); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				removed++
+			}
+			return removed, errors.Join(errs...)
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			return len(stagedSources(subject.ID)), nil
+		},
+	})
+
+	eraser.AddStore(erasure.Store{
+		Name:  "streams",
+		Kinds: []string{erasure.KindStream},
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if _, err := s.broadcasts.DeleteStream(subject.ID, false); err != nil {
+				return 0, nil // not on this replica
+			}
+			return 1, nil
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if _, err := s.broadcasts.GetStream(subject.ID); err == nil {
+				return 1, nil
+			}
+			return 0, nil
+		},
+	})
+
+	eraser.AddStore(erasure.Store{
+		Name:  "events",
+		Kinds: []string{erasure.KindEvent},
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if err := s.broadcasts.DeleteEvent(subject.ID); err != nil {
+				return 0, nil
+			}
+			return 1, nil
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if _, err := s.broadcasts.GetEvent(subject.ID); err == nil {
+				return 1, nil
+			}
+			return 0, nil
+		},
+	})
+
+	eraser.AddStore(erasure.Store{
+		Name:  "interactions",
+		Kinds: []string{erasure.KindStream},
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			return s.recorder.Erase(subject.ID), nil
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			return s.recorder.Journals(subject.ID), nil
+		},
+	})
+
+	// Segments, recordings, clips, saved interactions, thumbnails, frames,
+	// previews and sources
+	eraser.AddStore(erasure.Store{
+		Name:  "bucket",
+		Kinds: assets,
+		Erase: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			deleted := 0
+			var errs []error
+			for _, folder := range s.gcs.Layout().AssetFolders(subject.ID) {
+				n, err := s.gcs.DeletePrefix(ctx, folder)
+				deleted += n
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return deleted, errors.Join(errs...)
+		},
+		Count: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			remaining := 0
+			for _, folder := range s.gcs.Layout().AssetFolders(subject.ID) {
+				objects, err := s.gcs.ListObjects(ctx, folder)
+				if err != nil {
+					return remaining, err
+				}
+				remaining += len(objects)
+			}
+			return remaining, nil
+		},
+	})
+
+	eraser.AddStore(erasure.Store{
+		Name:  "usage",
+		Kinds: assets,
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			return s.usage.Forget(subject.ID), nil
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if asset := s.usage.Asset(subject.ID); asset != nil {
+				return asset.Objects, nil
+			}
+			return 0, nil
+		},
+	})
+
+	// Playback beacons and viewer locations
+	eraser.AddStore(erasure.Store{
+		Name:  "analytics",
+		Kinds: assets,
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			return s.qoe.ForgetStream(subject.ID) + s.audience.ForgetStream(subject.ID), nil
+		},
+	})
+
+	streamCaptures := func(streamID string) ([]webrtc.CaptureInfo, error) {
+		captures, err := s.captures.List()
+		if err != nil {
+			return nil, err
+		}
+		var matching []webrtc.CaptureInfo
+		for _, capture := range captures {
+			if capture.StreamID == streamID {
+				matching = append(matching, capture)
+			}
+		}
+		return matching, nil
+	}
+	eraser.AddStore(erasure.Store{
+		Name:  "captures",
+		Kinds: []string{erasure.KindStream},
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			captures, err := streamCaptures(subject.ID)
+			if err != nil {
+				return 0, err
+			}
+			deleted := 0
+			var errs []error
+			for _, capture := range captures {
+				if err := s.captures.Delete(capture.Name); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				deleted++
+			}
+			return deleted, errors.Join(errs...)
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			captures, err := streamCaptures(subject.ID)
+			return len(captures), err
+		},
+	})
+
+	// Findings of storage audits about the erased assets
+	eraser.AddStore(erasure.Store{
+		Name:  "audits",
+		Kinds: assets,
+		InUse: func(erasure.Subject) error {
+			if id, running := s.audits.Running(); running {
+				return fmt.Errorf("audit %s is running", id)
+			}
+			return nil
+		},
+		Erase: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			return s.audits.ForgetAsset(ctx, subject.ID)
+		},
+		Count: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			return s.audits.AssetFindings(ctx, subject.ID)
+		},
+	})
+
+	// Claims of the objects ingested as a video keep the objects claimed but
+	// stop naming it
+	eraser.AddStore(erasure.Store{
+		Name:  "ingest-claims",
+		Kinds: []string{erasure.KindVideo},
+		Erase: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			claims, err := s.ingest.VideoClaims(ctx, subject.ID)
+			if err != nil {
+				return 0, err
+			}
+			redacted := 0
+			var errs []error
+			for _, claim := range claims {
+				if err := s.ingest.RedactClaim(ctx, claim); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				redacted++
+			}
+			return redacted, errors.Join(errs...)
+		},
+		Count: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			claims, err := s.ingest.VideoClaims(ctx, subject.ID)
+			return len(claims), err
+		},
+	})
+
+	eraser.AddStore(erasure.Store{
+		Name:  "catalog",
+		Kinds: []string{erasure.KindVideo},
+		Erase: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			removed, err := s.catalog.Remove(ctx, subject.ID)
+			if removed {
+				return 1, err
+			}
+			return 0, err
+		},
+		Count: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			if _, ok := s.catalog.Get(subject.ID); ok {
+				return 1, nil
+			}
+			if stored, err := s.catalog.Stored(ctx, subject.ID); stored || err != nil {
+				return 1, err
+			}
+			return 0, nil
+		},
+	})
+
+	// Collections, and the erased videos in the collections of others
+	eraser.AddStore(erasure.Store{
+		Name:  "collections",
+		Kinds: []string{erasure.KindVideo, erasure.KindCollection},
+		Erase: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			if subject.Kind == erasure.KindVideo {
+				return s.collection.RemoveVideo(ctx, subject.ID)
+			}
+			if err := s.collection.Delete(ctx, subject.ID); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return 0, nil
+				}
+				return 0, err
+			}
+			return 1, nil
+		},
+		Count: func(ctx context.Context, subject erasure.Subject) (int, error) {
+			if subject.Kind == erasure.KindVideo {
+				containing, err := s.collection.Containing(ctx, subject.ID)
+				return len(containing), err
+			}
+			if _, err := s.collection.Get(ctx, subject.ID); err == nil {
+				return 1, nil
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return 0, err
+			}
+			return 0, nil
+		},
+	})
+
+	// What users watched, kept per user and per asset
+	eraser.AddStore(erasure.Store{
+		Name:  "history",
+		Kinds: []string{erasure.KindVideo, erasure.KindStream, erasure.KindUser},
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if subject.Kind == erasure.KindUser {
+				return s.history.Forget(subject.ID), nil
+			}
+			return s.history.ForgetAsset(subject.ID), nil
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if subject.Kind == erasure.KindUser {
+				return len(s.history.History(subject.ID)), nil
+			}
+			return s.history.Watchers(subject.ID), nil
+		},
+	})
+
+	eraser.AddStore(erasure.Store{
+		Name:  "watermarks",
+		Kinds: []string{erasure.KindStream, erasure.KindUser},
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if subject.Kind == erasure.KindUser {
+				return s.watermarks.EraseUser(subject.ID)
+			}
+			return s.watermarks.EraseStream(subject.ID), nil
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			return s.watermarks.Count(subject.ID, subject.Kind == erasure.KindUser), nil
+		},
+	})
+
+	eraser.AddStore(erasure.Store{
+		Name:  "ownership",
+		Kinds: []string{erasure.KindVideo, erasure.KindStream, erasure.KindEvent, erasure.KindCollection},
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if _, owned := s.auth.GetOwnership(subject.Kind, subject.ID); !owned {
+				return 0, nil
+			}
+			s.auth.RemoveResource(subject.Kind, subject.ID)
+			return 1, nil
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if _, owned := s.auth.GetOwnership(subject.Kind, subject.ID); owned {
+				return 1, nil
+			}
+			return 0, nil
+		},
+	})
+
+	// Last, once everything the user owned is gone
+	eraser.AddStore(erasure.Store{
+		Name:  "account",
+		Kinds: []string{erasure.KindUser},
+		Local: true,
+		Erase: func(_ context.Context, subject erasure.Subject) (int, error) {
+			return s.auth.DeleteUser(subject.ID), nil
+		},
+		Count: func(_ context.Context, subject erasure.Subject) (int, error) {
+			if _, err := s.auth.GetUser(subject.ID); err == nil {
+				return 1, nil
+			}
+			return 0, nil
+		},
+	})
+	return eraser
+}
@@ -0,0 +1,398 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"live-video/internal/api"
+	"live-video/internal/handlers"
+	"live-video/pkg/auth"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// routeHandlers groups the HTTP handlers mounted by newRouter
+type routeHandlers struct {
+	video     *handlers.VideoHandler
+	broadcast *handlers.BroadcastHandler
+	hlsProxy  *handlers.HLSProxyHandler
+	ready     *handlers.ReadinessHandler
+	gcsIngest *handlers.GCSIngestHandler
+	archive   *handlers.ArchiveHandler
+	account   *handlers.AccountHandler
+	oidc      *handlers.OIDCHandler
+	preflight *handlers.PreflightHandler
+	debug     *handlers.DebugHandler
+	event     *handlers.EventHandler
+	qoe       *handlers.QoEHandler
+	embed     *handlers.EmbedHandler
+	revoke    *handlers.RevocationHandler
+	geo       *handlers.GeoHandler
+	storage   *handlers.StorageHandler
+	usage     *handlers.UsageHandler
+	cost      *handlers.CostHandler
+	billing   *handlers.BillingHandler
+	party     *handlers.WatchPartyHandler
+	preview   *handlers.PreviewHandler
+	conform   *handlers.ConformanceHandler
+	clip      *handlers.ClipHandler
+	interact  *handlers.InteractionHandler
+	erasure   *handlers.ErasureHandler
+	history   *handlers.HistoryHandler
+	collect   *handlers.CollectionHandler
+	integrity *handlers.IntegrityHandler
+	audit     *handlers.AuditHandler
+	auth      *auth.Service
+	limits    handlers.BodyLimits
+	v1Sunset  api.Deprecation
+	proxies   []string // addresses or CIDRs whose X-Forwarded-For is believed
+	staticDir string   // web UI, left out unless both are set
+	templates string
+}
+
+// newRouter mounts the handlers on a router
+func newRouter(h *routeHandlers) (*gin.Engine, error) {
+	router := gin.Default()
+
+	// Client addresses come from X-Forwarded-For only behind trusted proxies,
+	// so clients can't pick the address votes and rate limits count
+	if err := router.SetTrustedProxies(h.proxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	// CORS configuration
+	router.Use(cors.New(cors.Config{
+		AllowOrigins:     []string{"*"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Stream-Key", "X-Embed-Token", "X-Consent-Token"},
+		ExposeHeaders:    []string{"Content-Length", "Location", "Link", "Deprecation", "Sunset", api.VersionHeader},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}))
+
+	// Request body limits, by route for those taking more than JSON
+	router.Use(handlers.LimitBodies(h.limits, map[string]string{
+		"POST /api/v1/videos/upload":     handlers.BodyUpload,
+		"POST /api/v1/slideshows":        handlers.BodyUpload,
+		"POST /api/v1/streams/:id/chunk": handlers.BodyChunk,
+	}))
+
+	// v1 routes replaced in v2, announced as deprecated on their responses
+	v1Successors := map[string]string{
+		"GET /api/v1/streams":            "/api/v2/streams",
+		"POST /api/v1/streams":           "/api/v2/streams",
+		"GET /api/v1/streams/:id":        "/api/v2/streams/:id",
+		"GET /api/v1/streams/:id/stats":  "/api/v2/streams/:id",
+		"POST /api/v1/streams/:id/start": "/api/v2/streams/:id/start",
+		"POST /api/v1/streams/:id/stop":  "/api/v2/streams/:id/stop",
+		"DELETE /api/v1/streams/:id":     "/api/v2/streams/:id",
+		"GET /api/v1/videos":             "/api/v2/videos",
+	}
+
+	// Health check
+	router.GET("/health", h.broadcast.HealthCheck)
+	router.GET("/ready", h.ready.Ready)
+
+	// HLS Proxy for CDN (avoid CORS issues in local development)
+	router.GET("/hls-proxy/*path", h.hlsProxy.ProxyCDN)
+
+	// SSO login for the web UI
+	router.GET("/auth/login/:provider", h.oidc.Login)
+	router.GET("/auth/callback/:provider", h.oidc.Callback)
+	router.POST("/auth/logout", h.oidc.Logout)
+
+	// API v1 routes
+	v1 := router.Group("/api/v1")
+	v1.Use(api.Versioned(api.V1), api.Deprecated(h.v1Sunset, v1Successors), handlers.AuthMiddleware(h.auth))
+	{
+		// Account routes
+		v1.GET("/me", h.account.GetMe)
+		v1.GET("/me/history", h.history.GetHistory)
+		v1.DELETE("/me/history", h.history.ClearHistory)
+		v1.GET("/users", h.account.ListUsers)
+		v1.GET("/auth/providers", h.oidc.ListProviders)
+		v1.POST("/auth/token", h.oidc.ExchangeToken)
+
+		// Video routes
+		videos := v1.Group("/videos")
+		{
+			videos.POST("/upload", h.video.UploadVideo)
+			videos.POST("/upload-url", h.video.CreateUploadURL)
+			videos.POST("/:id/complete", h.video.CompleteUpload)
+			videos.GET("", h.video.ListVideos)
+			videos.GET("/signed-url", h.video.GetSignedURL)
+			videos.POST("/signed-urls", h.video.GetSignedURLs)
+			videos.DELETE("", h.video.DeleteVideo)
+			videos.POST("/:id/archive", h.archive.ArchiveVideo)
+			videos.POST("/:id/retranscode", h.video.RetranscodeVideo)
+			videos.GET("/:id/original", h.video.GetOriginal)
+			videos.GET("/:id/download", h.video.DownloadVideo)
+			videos.GET("/:id/frame", h.video.GetFrame)
+			videos.GET("/:id/playback", h.video.GetVideoPlayback)
+			videos.POST("/:id/position", h.video.ReportVideoPosition)
+			videos.POST("/:id/previews", h.preview.CreateVideoPreview)
+			videos.POST("/:id/conformance", h.conform.CheckVideo)
+			videos.GET("/:id/usage", h.usage.GetVideoUsage)
+			videos.GET("/:id/access", h.account.GetVideoAccess)
+			videos.POST("/:id/share", h.account.ShareVideo)
+			videos.DELETE("/:id/share/:userId", h.account.UnshareVideo)
+		}
+
+		// Videos rendered from images and audio, broadcast as streams
+		v1.POST("/slideshows", h.video.CreateSlideshow)
+
+		// Collections of videos played in order
+		collections := v1.Group("/collections")
+		{
+			collections.POST("", h.collect.CreateCollection)
+			collections.GET("", h.collect.ListCollections)
+			collections.GET("/:id", h.collect.GetCollection)
+			collections.PUT("/:id", h.collect.UpdateCollection)
+			collections.DELETE("/:id", h.collect.DeleteCollection)
+			collections.GET("/:id/playback", h.collect.GetCollectionPlayback)
+			collections.GET("/:id/access", h.account.GetCollectionAccess)
+			collections.POST("/:id/share", h.account.ShareCollection)
+			collections.DELETE("/:id/share/:userId", h.account.UnshareCollection)
+		}
+
+		// Transcode job status
+		v1.GET("/jobs/:id", h.video.GetJob)
+		v1.GET("/jobs/:id/progress", h.video.WatchJobProgress)
+
+		// Staged VOD sources and quarantine
+		v1.GET("/staging", h.video.ListStaging)
+		v1.GET("/staging/:id", h.video.GetStaging)
+		v1.POST("/staging/:id/retry", h.video.RetryStaging)
+		v1.DELETE("/staging/:id", h.video.DeleteStaging)
+
+		// Bucket layout and migration out of the legacy prefix (admin)
+		v1.GET("/storage/layout", h.storage.GetLayout)
+		v1.POST("/storage/migrate", h.storage.MigrateLegacy)
+		v1.POST("/storage/bootstrap", h.storage.Bootstrap)
+
+		// Integrity audits of stored videos and recordings (admin)
+		v1.POST("/storage/audits", h.audit.StartAudit)
+		v1.GET("/storage/audits", h.audit.ListAudits)
+		v1.GET("/storage/audits/:id", h.audit.GetAudit)
+
+		// Storage usage per tenant, for quotas and cost reporting
+		v1.GET("/usage", h.usage.GetUsage)
+		v1.POST("/usage/rebuild", h.usage.RebuildUsage)
+
+		// Transcode, storage and egress cost of a planned ladder
+		v1.POST("/cost-estimates", h.cost.EstimateCost)
+		v1.GET("/billing/current", h.billing.GetBillingUsage)
+
+		// Erasure of everything kept about a video, stream or user, with
+		// tombstones of past erasures (admin)
+		v1.POST("/erasures", h.erasure.CreateErasure)
+		v1.GET("/erasures", h.erasure.ListErasures)
+		v1.GET("/erasures/:id", h.erasure.GetErasure)
+
+		// Embed tokens revoked before they expire, on every replica
+		v1.POST("/token-revocations", h.revoke.CreateRevocation)
+		v1.GET("/token-revocations", h.revoke.ListRevocations)
+		v1.DELETE("/token-revocations/:id", h.revoke.LiftRevocation)
+
+		// Archive manifests and cold restore
+		v1.GET("/archives/:id", h.archive.GetManifest)
+		v1.POST("/archives/:id/restore", h.archive.RestoreArchive)
+
+		// Pub/Sub push endpoint for GCS object-finalize notifications
+		v1.POST("/ingest/gcs-notifications", h.gcsIngest.HandleNotification)
+
+		// HLS proxy route for serving HLS files from private bucket
+		// Format: /api/v1/hls/{videoID}/{filename}
+		v1.GET("/hls/:videoID/:filename", h.video.ProxyHLSFile)
+
+		// Broadcast stream routes
+		streams := v1.Group("/streams")
+		{
+			streams.POST("", h.broadcast.CreateStream)
+			streams.POST("/redundant", h.broadcast.CreateRedundantStream)
+			streams.GET("/reconciliation", h.broadcast.GetReconciliation)
+			streams.GET("", h.broadcast.ListStreams)
+			streams.GET("/:id", h.broadcast.GetStream)
+			streams.POST("/:id/start", h.broadcast.StartStream)
+			streams.POST("/:id/stop", h.broadcast.StopStream)
+			streams.POST("/:id/pause", h.broadcast.PauseStream)
+			streams.POST("/:id/resume", h.broadcast.ResumeStream)
+			streams.POST("/:id/heartbeat", h.broadcast.Heartbeat)
+			streams.GET("/:id/watch", h.broadcast.WatchStream)
+			streams.PUT("/:id/viewer-limit", h.broadcast.SetViewerLimit)
+			streams.GET("/:id/geo", h.geo.GetStreamGeo)
+			streams.POST("/:id/embed-tokens", h.embed.CreateEmbedToken)
+			streams.PUT("/:id/embed-policy", h.embed.SetEmbedPolicy)
+			streams.PUT("/:id/content-gate", h.embed.SetContentGate)
+			streams.POST("/:id/consent", h.embed.GrantConsent)
+			streams.PUT("/:id/metadata", h.broadcast.SetStreamMetadata)
+			streams.GET("/:id/card", h.embed.GetShareCard)
+			streams.GET("/:id/video", h.broadcast.ProxyVideo)
+			streams.GET("/:id/playback", h.broadcast.GetPlayback)
+			streams.POST("/:id/position", h.broadcast.ReportStreamPosition)
+			streams.GET("/:id/master.m3u8", h.broadcast.MasterPlaylist)
+			streams.GET("/:id/live/:rendition/playlist.m3u8", h.broadcast.MediaPlaylist)
+			streams.PUT("/:id/playlist-windows", h.broadcast.SetPlaylistWindows)
+			streams.PUT("/:id/inputs", h.broadcast.SetStreamInputs)
+			streams.GET("/:id/inputs", h.broadcast.GetStreamInputs)
+			streams.PUT("/:id/audio-inputs", h.broadcast.SetAudioInputs)
+			streams.GET("/:id/audio-inputs", h.broadcast.GetAudioInputs)
+			streams.PUT("/:id/captions", h.broadcast.SetCaptions)
+			streams.GET("/:id/captions", h.broadcast.GetCaptions)
+			streams.PUT("/:id/ingest-clients", h.broadcast.SetIngestClients)
+			streams.GET("/:id/ingest-clients", h.broadcast.GetIngestClients)
+			streams.PUT("/:id/watermark", h.broadcast.SetStreamWatermark)
+			streams.POST("/:id/watermark/trace", h.broadcast.TraceWatermark)
+			streams.POST("/:id/preview-session", h.broadcast.StartPreviewSession)
+			streams.GET("/:id/preview-session/watch", h.broadcast.WatchPreviewSession)
+			streams.GET("/:id/renditions", h.broadcast.GetRenditions)
+			streams.PUT("/:id/priority", h.broadcast.SetStreamPriority)
+			streams.PUT("/:id/content-type", h.broadcast.SetStreamContentType)
+			streams.POST("/:id/renditions", h.broadcast.AddRendition)
+			streams.DELETE("/:id/renditions/:rendition", h.broadcast.DropRendition)
+			streams.PUT("/:id/schedule", h.broadcast.SetSchedule)
+			streams.PUT("/:id/clip-policy", h.clip.SetClipPolicy)
+			streams.POST("/:id/clips", h.clip.CreateClip)
+			streams.POST("/:id/interactions", h.interact.PostInteraction)
+			streams.GET("/:id/interactions", h.interact.ListInteractionSessions)
+			streams.GET("/:id/interactions/:session", h.interact.ReplayInteractions)
+			streams.GET("/:id/session", h.qoe.StartSession)
+			streams.GET("/:id/stats", h.broadcast.GetStreamStats)
+			streams.GET("/:id/screenshot", h.broadcast.GetScreenshot)
+			streams.POST("/:id/previews", h.preview.CreateStreamPreview)
+			streams.POST("/:id/conformance", h.conform.CheckStream)
+			streams.POST("/:id/chunk", h.broadcast.UploadStreamChunk)
+			streams.DELETE("/:id", h.broadcast.DeleteStream)
+			streams.POST("/:id/archive", h.archive.ArchiveStream)
+			streams.GET("/:id/usage", h.usage.GetStreamUsage)
+			streams.GET("/:id/access", h.account.GetStreamAccess)
+			streams.POST("/:id/share", h.account.ShareStream)
+			streams.DELETE("/:id/share/:userId", h.account.UnshareStream)
+
+			// WebRTC routes for live streaming
+			streams.POST("/:id/webrtc/offer", h.broadcast.WebRTCOffer)
+			streams.POST("/:id/webrtc/answer", h.broadcast.WebRTCAnswer)
+			streams.GET("/:id/ingest-endpoint", h.broadcast.GetIngestEndpoint)
+			streams.POST("/:id/encoder-stats", h.broadcast.PushEncoderStats)
+			streams.POST("/:id/broadcaster-messages", h.broadcast.SendBroadcasterMessage)
+
+			// Raw RTP capture of the ingest for debugging (admin)
+			streams.POST("/:id/debug/capture", h.debug.SetStreamCapture)
+		}
+
+		// Events: streams provisioned in bulk from a preset
+		v1.GET("/stream-presets", h.event.ListPresets)
+		v1.POST("/events/provision", h.event.ProvisionEvent)
+		v1.GET("/events", h.event.ListEvents)
+		v1.POST("/events", h.event.CreateEvent)
+		v1.GET("/events/:id", h.event.GetEvent)
+		v1.DELETE("/events/:id", h.event.DeleteEvent)
+		v1.GET("/events/:id/stats", h.event.GetEventStats)
+		v1.GET("/events/:id/geo", h.geo.GetEventGeo)
+		v1.POST("/events/:id/start", h.event.StartEvent)
+		v1.POST("/events/:id/stop", h.event.StopEvent)
+		v1.POST("/events/:id/streams", h.event.AddStreams)
+		v1.DELETE("/events/:id/streams/:streamId", h.event.RemoveStream)
+
+		// Preview clip status
+		v1.GET("/previews/:id", h.preview.GetPreview)
+		v1.GET("/conformance/:id", h.conform.GetCheck)
+		v1.GET("/clips/:id", h.clip.GetClip)
+
+		// Playlist signatures
+		v1.GET("/integrity/key", h.integrity.GetKey)
+		v1.POST("/integrity/verify", h.integrity.Verify)
+
+		// Watch parties: rooms that follow a host's play/pause/seek
+		v1.POST("/parties", h.party.CreateParty)
+		v1.GET("/parties/:id", h.party.GetParty)
+		v1.POST("/parties/:id/join", h.party.JoinParty)
+		v1.GET("/parties/:id/events", h.party.PartyEvents)
+		v1.POST("/parties/:id/control", h.party.ControlParty)
+		v1.POST("/parties/:id/leave", h.party.LeaveParty)
+
+		// Playback QoE beacons and A/B experiments
+		v1.POST("/qoe/beacons", h.qoe.PostBeacon)
+		v1.GET("/qoe/beacons", h.qoe.ListBeacons)
+		v1.POST("/experiments", h.qoe.CreateExperiment)
+		v1.GET("/experiments", h.qoe.ListExperiments)
+		v1.GET("/experiments/:id", h.qoe.GetExperiment)
+		v1.POST("/experiments/:id/end", h.qoe.EndExperiment)
+		v1.DELETE("/experiments/:id", h.qoe.DeleteExperiment)
+
+		// Debug capture downloads (admin)
+		v1.GET("/debug/captures", h.debug.ListCaptures)
+		v1.GET("/debug/captures/:name", h.debug.DownloadCapture)
+		v1.DELETE("/debug/captures/:name", h.debug.DeleteCapture)
+
+		// Broadcaster preflight (ICE, uplink bandwidth, codecs)
+		v1.GET("/preflight/config", h.preflight.GetConfig)
+		v1.POST("/preflight", h.preflight.StartPreflight)
+		v1.GET("/preflight/:id", h.preflight.GetPreflight)
+	}
+
+	// API v2 routes: bare typed resources, paged listings, v2 errors and
+	// bearer tokens only
+	v2 := router.Group("/api/v2")
+	v2.Use(api.Versioned(api.V2), handlers.BearerAuth(h.auth))
+	{
+		v2.GET("/streams", h.broadcast.ListStreamsV2)
+		v2.POST("/streams", h.broadcast.CreateStreamV2)
+		v2.GET("/streams/:id", h.broadcast.GetStreamV2)
+		v2.DELETE("/streams/:id", h.broadcast.DeleteStreamV2)
+		v2.POST("/streams/:id/start", h.broadcast.StartStreamV2)
+		v2.POST("/streams/:id/stop", h.broadcast.StopStreamV2)
+
+		v2.GET("/videos", h.video.ListVideosV2)
+		v2.GET("/videos/:id", h.video.GetVideoV2)
+	}
+
+	// Web UI
+	if h.staticDir == "" || h.templates == "" {
+		return router, nil
+	}
+	router.Static("/static", h.staticDir)
+	router.LoadHTMLGlob(filepath.Join(h.templates, "*"))
+
+	// Landing page
+	router.GET("/", func(c *gin.Context) {
+		c.HTML(200, "index.html", gin.H{
+			"title": "Video Broadcast Service",
+		})
+	})
+
+	// Watch page
+	router.GET("/watch", func(c *gin.Context) {
+		c.HTML(200, "watch.html", gin.H{
+			"title": "Stream Viewer",
+		})
+	})
+
+	// Watch page with stream ID parameter, with the stream's share card
+	router.GET("/watch/:streamId", h.embed.WatchPage)
+
+	// Player page with stream ID parameter (minimal UI)
+	router.GET("/player/:streamId", h.embed.PlayerPage)
+
+	// Embeddable player, only served with a valid embed token
+	router.GET("/embed/:streamId", h.embed.EmbedPage)
+
+	// Event dashboard: aggregate viewers and per-room health
+	router.GET("/events/:eventId", func(c *gin.Context) {
+		c.HTML(200, "event.html", gin.H{
+			"title":   "Event Dashboard",
+			"eventId": c.Param("eventId"),
+		})
+	})
+
+	// Live camera broadcast page
+	router.GET("/live", func(c *gin.Context) {
+		c.HTML(200, "live.html", gin.H{
+			"title": "Live Camera Broadcast",
+		})
+	})
+
+	return router, nil
+}
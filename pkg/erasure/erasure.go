@@ -0,0 +1,269 @@
+// Package erasure purges everything the service keeps about a video, a
+// stream or a user, for data protection requests such as the GDPR right to
+// erasure. Each place data is kept registers as a Store. An erasure runs
+// every store, counts again what remains to verify it, and keeps its report
+// as a tombstone.
+package erasure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"live-video/pkg/auth"
+	"live-video/pkg/events"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of subjects. Events and collections are erased with the user owning
+// them.
+const (
+	KindVideo      = auth.ResourceVideo
+	KindStream     = auth.ResourceStream
+	KindEvent      = auth.ResourceEvent
+	KindCollection = auth.ResourceCollection
+	KindUser       = "user"
+)
+
+var (
+	// ErrInvalid is returned for subjects of an unknown kind or without ID
+	ErrInvalid = errors.New("invalid erasure subject")
+	// ErrInUse is returned when a store still uses data of a subject, which
+	// it would write again after the erasure
+	ErrInUse = errors.New("subject in use")
+)
+
+// Subject is what an erasure is about
+type Subject struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+}
+
+func (s Subject) String() string {
+	return s.Kind + " " + s.ID
+}
+
+// Store is a place the service keeps data of subjects
+type Store struct {
+	Name  string
+	Kinds []string // of the subjects it keeps data of
+	// Local stores keep data on each replica. They are erased on every
+	// replica that follows the bus.
+	Local bool
+	// Erase erases the data of a subject and returns how many items it erased
+	Erase func(ctx context.Context, s Subject) (int, error)
+	// Count returns how many items of a subject remain. Stores without one
+	// are trusted to erase everything.
+	Count func(ctx context.Context, s Subject) (int, error)
+	// InUse fails while the store uses data of a subject it would write
+	// again, such as a running transcode. Optional.
+	InUse func(s Subject) error
+}
+
+// Item is what one store erased of one subject
+type Item struct {
+	Store     string  `json:"store"`
+	Subject   Subject `json:"subject"`
+	Erased    int     `json:"erased"`
+	Remaining int     `json:"remaining"`
+	Verified  bool    `json:"verified"` // counted again after erasing
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the record of an erasure. It is kept as the erasure's tombstone
+// and holds IDs and counts only.
+type Report struct {
+	ID          string    `json:"id"`
+	Subject     Subject   `json:"subject"`
+	RequestedBy string    `json:"requested_by,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Subjects    []Subject `json:"subjects"` // the subject and those erased with it
+	Items       []Item    `json:"items"`
+	Erased      int       `json:"erased"`
+	Complete    bool      `json:"complete"` // nothing remains and every store succeeded
+}
+
+// Eraser runs erasures over the registered stores, one at a time
+type Eraser struct {
+	mu         sync.Mutex
+	stores     []Store
+	related    func(Subject) []Subject
+	tombstones *Tombstones
+	events     *events.Bus
+}
+
+// NewEraser creates an eraser keeping tombstones in tombstones. A nil
+// tombstones keeps none.
+func NewEraser(tombstones *Tombstones) *Eraser {
+	return &Eraser{tombstones: tombstones}
+}
+
+// AddStore registers a store. Stores are erased in the order they are added.
+func (e *Eraser) AddStore(store Store) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stores = append(e.stores, store)
+}
+
+// SetRelated sets what is erased along with a subject, such as the videos
+// and streams a user owns. Related subjects are erased first.
+func (e *Eraser) SetRelated(related func(Subject) []Subject) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.related = related
+}
+
+// SetEvents publishes completed erasures on bus as events.ErasureCompleted
+func (e *Eraser) SetEvents(bus *events.Bus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = bus
+}
+
+// Follow erases the data local stores keep of the subjects of erasures run
+// on other replicas
+func (e *Eraser) Follow(bus *events.Bus) {
+	bus.Subscribe(events.ErasureCompleted, func(event events.Event) {
+		if event.Origin == bus.Node() {
+			return
+		}
+		// Subjects are Go values in process and JSON objects from replicas
+		var subjects []Subject
+		raw, _ := json.Marshal(event.Data["subjects"])
+		if err := json.Unmarshal(raw, &subjects); err != nil {
+			log.Printf("[Erasure] Skipping unreadable erasure %v from %s: %v", event.Data["erasure_id"], event.Origin, err)
+			return
+		}
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		erased := 0
+		for _, s := range subjects {
+			for _, store := range e.stores {
+				if !store.Local || !slices.Contains(store.Kinds, s.Kind) {
+					continue
+				}
+				n, err := store.Erase(context.Background(), s)
+				if err != nil {
+					log.Printf("[Erasure] Failed to erase %s from %s: %v", s, store.Name, err)
+				}
+				erased += n
+			}
+		}
+		log.Printf("[Erasure] Erased %d local items of erasure %v from %s", erased, event.Data["erasure_id"], event.Origin)
+	})
+}
+
+// Erase erases a subject and the subjects related to it from every store,
+// verifies what remains and records the report as a tombstone. Nothing is
+// erased while a store still uses the subject.
+func (e *Eraser) Erase(ctx context.Context, subject Subject, requestedBy string) (*Report, error) {
+	switch subject.Kind {
+	case KindVideo, KindStream, KindEvent, KindCollection, KindUser:
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %q (video, stream or user)", ErrInvalid, subject.Kind)
+	}
+	if subject.ID == "" {
+		return nil, fmt.Errorf("%w: id is required", ErrInvalid)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	subjects := e.expand(subject, map[Subject]bool{})
+	for _, s := range subjects {
+		for _, store := range e.stores {
+			if store.InUse == nil || !slices.Contains(store.Kinds, s.Kind) {
+				continue
+			}
+			if err := store.InUse(s); err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInUse, s, err)
+			}
+		}
+	}
+
+	report := &Report{
+		ID:          uuid.New().String(),
+		Subject:     subject,
+		RequestedBy: requestedBy,
+		StartedAt:   time.Now().UTC(),
+		Subjects:    subjects,
+		Items:       []Item{},
+	}
+	var counts []func(ctx context.Context, s Subject) (int, error)
+	for _, s := range subjects {
+		for _, store := range e.stores {
+			if !slices.Contains(store.Kinds, s.Kind) {
+				continue
+			}
+			item := Item{Store: store.Name, Subject: s}
+			n, err := store.Erase(ctx, s)
+			item.Erased = n
+			if err != nil {
+				item.Error = err.Error()
+			}
+			report.Items = append(report.Items, item)
+			report.Erased += n
+			counts = append(counts, store.Count)
+		}
+	}
+
+	// Verify once everything is erased, so no store counts what a later one
+	// was yet to remove
+	report.Complete = true
+	for i := range report.Items {
+		item := &report.Items[i]
+		if counts[i] != nil {
+			n, err := counts[i](ctx, item.Subject)
+			if err != nil && item.Error == "" {
+				item.Error = "verification failed: " + err.Error()
+			}
+			item.Remaining, item.Verified = n, err == nil
+		}
+		if item.Error != "" || item.Remaining > 0 {
+			report.Complete = false
+		}
+	}
+	report.CompletedAt = time.Now().UTC()
+
+	if e.tombstones != nil {
+		if err := e.tombstones.Record(ctx, report); err != nil {
+			log.Printf("[Erasure] Failed to record tombstone of erasure %s: %v", report.ID, err)
+			report.Items = append(report.Items, Item{Store: "tombstones", Subject: subject, Error: err.Error()})
+			report.Complete = false
+		}
+	}
+
+	log.Printf("[Erasure] Erased %s and %d related subjects: %d items (complete: %t, erasure %s)",
+		subject, len(subjects)-1, report.Erased, report.Complete, report.ID)
+	e.events.Publish(events.ErasureCompleted, map[string]any{
+		"erasure_id": report.ID,
+		"kind":       subject.Kind,
+		"id":         subject.ID,
+		"subjects":   subjects,
+		"verified":   report.Complete,
+	})
+	return report, nil
+}
+
+// expand returns the subjects related to s, recursively, followed by s
+func (e *Eraser) expand(s Subject, seen map[Subject]bool) []Subject {
+	if seen[s] {
+		return nil
+	}
+	seen[s] = true
+	var subjects []Subject
+	if e.related != nil {
+		for _, related := range e.related(s) {
+			subjects = append(subjects, e.expand(related, seen)...)
+		}
+	}
+	return append(subjects, s)
+}
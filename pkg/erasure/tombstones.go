@@ -0,0 +1,87 @@
+package erasure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+
+	"live-video/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// TombstonePrefix is where tombstones are kept in the bucket, outside the
+// layout prefixes so no lifecycle rule removes them
+const TombstonePrefix = "tombstones"
+
+// Tombstones keeps the reports of erasures in the bucket, shared by all
+// replicas
+type Tombstones struct {
+	storage *storage.GCSService
+}
+
+// NewTombstones creates a tombstone store in the bucket of gcsService
+func NewTombstones(gcsService *storage.GCSService) *Tombstones {
+	return &Tombstones{storage: gcsService}
+}
+
+// Record keeps the report of an erasure
+func (t *Tombstones) Record(ctx context.Context, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return t.storage.UploadBytes(ctx, data, objectPath(report.ID), "application/json")
+}
+
+// Get returns the report of an erasure. It returns os.ErrNotExist for
+// erasures that don't exist.
+func (t *Tombstones) Get(ctx context.Context, id string) (*Report, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, os.ErrNotExist
+	}
+	data, err := t.storage.ReadFile(ctx, objectPath(id))
+	if storage.IsNotExist(err) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("unreadable tombstone %s: %w", id, err)
+	}
+	return &report, nil
+}
+
+// List returns the reports of all erasures, oldest first, or with a
+// subject's ID only those that erased it
+func (t *Tombstones) List(ctx context.Context, subjectID string) ([]*Report, error) {
+	objects, err := t.storage.ListObjects(ctx, TombstonePrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*Report, 0, len(objects))
+	for _, attrs := range objects {
+		data, err := t.storage.ReadFile(ctx, attrs.Name)
+		if err != nil {
+			return nil, err
+		}
+		var report Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("unreadable tombstone %s: %w", attrs.Name, err)
+		}
+		if subjectID == "" || slices.ContainsFunc(report.Subjects, func(s Subject) bool { return s.ID == subjectID }) {
+			reports = append(reports, &report)
+		}
+	}
+	slices.SortFunc(reports, func(a, b *Report) int { return a.StartedAt.Compare(b.StartedAt) })
+	return reports, nil
+}
+
+func objectPath(id string) string {
+	return path.Join(TombstonePrefix, id+".json")
+}
@@ -0,0 +1,134 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// DefaultChannel is the Redis channel or NATS subject events are relayed on
+// unless the backend URL sets ?channel=
+const DefaultChannel = "live-video.events"
+
+// Backend relays encoded events between replicas
+type Backend interface {
+	// Publish sends an encoded event to every replica subscribed
+	Publish(ctx context.Context, payload []byte) error
+	// Subscribe calls deliver with every encoded event published, including
+	// this replica's own, until ctx is done. It reconnects on failures.
+	Subscribe(ctx context.Context, deliver func(payload []byte)) error
+	Close() error
+	String() string
+}
+
+// OpenBackend returns the backend of rawURL: redis://[user:password@]host:port,
+// rediss:// for Redis over TLS, nats://[user:password@|token@]host:port, or
+// tls:// for NATS over TLS. Connections are made when events are first
+// relayed.
+func OpenBackend(rawURL string) (Backend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in %q", rawURL)
+	}
+	channel := u.Query().Get("channel")
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return newRedisBackend(u, channel), nil
+	case "nats", "tls":
+		return newNATSBackend(u, channel), nil
+	}
+	return nil, fmt.Errorf("unsupported event bus scheme %q (redis, rediss, nats or tls)", u.Scheme)
+}
+
+// Limits on what is read from a backend, so a broken or hostile server can't
+// make a replica allocate without bound
+const (
+	maxPayload = 1 << 20  // bytes of one relayed event
+	maxLine    = 32 << 10 // bytes of one protocol line, the size of the reader
+)
+
+// errPayloadTooLarge is returned for events over maxPayload
+var errPayloadTooLarge = errors.New("event over the size limit")
+
+// readLine reads one protocol line of at most maxLine bytes from a reader
+// made with bufio.NewReaderSize(conn, maxLine)
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("line over %d bytes", maxLine)
+	}
+	return string(line), err
+}
+
+// tlsConfig is the TLS configuration of a backend at host, checked against
+// the system's roots
+func tlsConfig(host string) *tls.Config {
+	return &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+}
+
+// Reconnect delays of backend subscriptions
+const (
+	firstReconnectDelay = time.Second
+	maxReconnectDelay   = 30 * time.Second
+)
+
+// resubscribe runs session until ctx is done, again after every failure with
+// a growing delay. Sessions call subscribed once the server confirmed the
+// subscription, which resets the delay.
+func resubscribe(ctx context.Context, backend Backend, session func(ctx context.Context, subscribed func()) error) error {
+	delay := firstReconnectDelay
+	for {
+		confirmed := false
+		err := session(ctx, func() { confirmed = true })
+		if ctx.Err() != nil {
+			return nil
+		}
+		if confirmed {
+			delay = firstReconnectDelay
+		}
+		log.Printf("[Events] Subscription to %s lost, reconnecting in %s: %v", backend, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// backoff spaces out a publisher's attempts to reconnect with the delays of
+// subscriptions, so events aren't held up by a backend that is down
+type backoff struct {
+	delay time.Duration
+	until time.Time
+}
+
+// ready returns an error until the publisher may reconnect
+func (b *backoff) ready() error {
+	if wait := time.Until(b.until); wait > 0 {
+		return fmt.Errorf("not connected, reconnecting in %s", wait.Round(time.Second))
+	}
+	return nil
+}
+
+// failed doubles the delay after a failed attempt
+func (b *backoff) failed() {
+	b.delay = max(firstReconnectDelay, min(2*b.delay, maxReconnectDelay))
+	b.until = time.Now().Add(b.delay)
+}
+
+// reset lets the publisher reconnect right away after a success
+func (b *backoff) reset() {
+	b.delay, b.until = 0, time.Time{}
+}
@@ -0,0 +1,312 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer accepts connections on a local port and serves each with serve
+func fakeServer(t *testing.T, serve func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// selfSigned returns a certificate for 127.0.0.1 and a pool trusting it
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// receive subscribes through backend until the test ends and returns the
+// payloads it delivers
+func receive(t *testing.T, backend Backend) <-chan []byte {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	payloads := make(chan []byte, 8)
+	go backend.Subscribe(ctx, func(payload []byte) { payloads <- payload })
+	return payloads
+}
+
+// publishUntilReceived publishes payload until it is received, as the
+// subscription may not be set up yet
+func publishUntilReceived(t *testing.T, backend Backend, payloads <-chan []byte, payload string) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		if err := backend.Publish(context.Background(), []byte(payload)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		select {
+		case got := <-payloads:
+			if string(got) != payload {
+				t.Fatalf("received %q, want %q", got, payload)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("event never received")
+		}
+	}
+}
+
+func TestRedisRelaysOverTLSWithAuth(t *testing.T) {
+	cert, pool := selfSigned(t)
+	var mu sync.Mutex
+	var subscribers []net.Conn
+	addr := fakeServer(t, func(conn net.Conn) {
+		conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		r := bufio.NewReaderSize(conn, maxLine)
+		authed := false
+		for {
+			reply, err := readRESP(r)
+			if err != nil {
+				return
+			}
+			args, _ := reply.([]any)
+			if len(args) == 0 {
+				return
+			}
+			switch string(args[0].([]byte)) {
+			case "AUTH":
+				authed = len(args) == 3 && string(args[1].([]byte)) == "relay" && string(args[2].([]byte)) == "secret"
+				if !authed {
+					conn.Write([]byte("-WRONGPASS invalid username-password pair\r\n"))
+					continue
+				}
+				conn.Write([]byte("+OK\r\n"))
+			case "SUBSCRIBE":
+				if !authed {
+					return
+				}
+				mu.Lock()
+				subscribers = append(subscribers, conn)
+				mu.Unlock()
+				channel := args[1].([]byte)
+				fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(channel), channel)
+			case "PUBLISH":
+				if !authed {
+					conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+					continue
+				}
+				channel, payload := args[1].([]byte), args[2].([]byte)
+				mu.Lock()
+				for _, sub := range subscribers {
+					fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(channel), channel, len(payload), payload)
+				}
+				fmt.Fprintf(conn, ":%d\r\n", len(subscribers))
+				mu.Unlock()
+			}
+		}
+	})
+
+	backend, err := OpenBackend("rediss://relay:secret@" + addr)
+	if err != nil {
+		t.Fatalf("OpenBackend: %v", err)
+	}
+	defer backend.Close()
+	backend.(*redisBackend).tlsConfig.RootCAs = pool
+
+	publishUntilReceived(t, backend, receive(t, backend), `{"type":"stream.started"}`)
+}
+
+func TestRedisRefusesOversizedReplies(t *testing.T) {
+	for name, reply := range map[string]string{
+		"bulk string":  fmt.Sprintf("$%d\r\n", maxPayload+1),
+		"array":        fmt.Sprintf("*%d\r\n", maxArray+1),
+		"nested array": "*1\r\n*1\r\n:1\r\n",
+		"line":         "+" + strings.Repeat("x", maxLine) + "\r\n",
+	} {
+		if _, err := readRESP(bufio.NewReaderSize(strings.NewReader(reply), maxLine)); err == nil {
+			t.Errorf("%s: read without an error", name)
+		}
+	}
+}
+
+func TestPublishBacksOffAfterFailures(t *testing.T) {
+	var mu sync.Mutex
+	accepted := 0
+	addr := fakeServer(t, func(conn net.Conn) {
+		mu.Lock()
+		accepted++
+		mu.Unlock()
+		// Hang up without answering
+	})
+
+	for _, url := range []string{"redis://" + addr, "nats://" + addr} {
+		mu.Lock()
+		accepted = 0
+		mu.Unlock()
+		backend, err := OpenBackend(url)
+		if err != nil {
+			t.Fatalf("OpenBackend: %v", err)
+		}
+		for range 3 {
+			if err := backend.Publish(context.Background(), []byte("{}")); err == nil {
+				t.Fatalf("%s: Publish succeeded without a server", url)
+			}
+		}
+		mu.Lock()
+		if accepted != 1 {
+			t.Errorf("%s: connected %d times, want 1 before the delay passes", url, accepted)
+		}
+		mu.Unlock()
+		backend.Close()
+	}
+}
+
+func TestPublishRefusesOversizedEvents(t *testing.T) {
+	for _, url := range []string{"redis://127.0.0.1:1", "nats://127.0.0.1:1"} {
+		backend, _ := OpenBackend(url)
+		if err := backend.Publish(context.Background(), make([]byte, maxPayload+1)); err != errPayloadTooLarge {
+			t.Errorf("%s: Publish = %v, want errPayloadTooLarge", url, err)
+		}
+	}
+}
+
+// natsServe speaks the server side of the NATS protocol on conn, upgraded
+// to TLS when cert is set, answering PINGs and handing other lines to handle
+// until it returns false
+func natsServe(conn net.Conn, cert *tls.Certificate, handle func(conn net.Conn, r *bufio.Reader, line string) bool) {
+	if cert != nil {
+		conn.Write([]byte(`INFO {"tls_required":true}` + "\r\n"))
+		conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}})
+	} else {
+		conn.Write([]byte("INFO {}\r\n"))
+	}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "PING" {
+			conn.Write([]byte("PONG\r\n"))
+			continue
+		}
+		if !handle(conn, r, line) {
+			return
+		}
+	}
+}
+
+func TestNATSRelaysOverTLSWithAuth(t *testing.T) {
+	cert, pool := selfSigned(t)
+	var mu sync.Mutex
+	var subscribers []net.Conn
+	addr := fakeServer(t, func(conn net.Conn) {
+		natsServe(conn, &cert, func(conn net.Conn, r *bufio.Reader, line string) bool {
+			switch {
+			case strings.HasPrefix(line, "CONNECT "):
+				if !strings.Contains(line, `"user":"relay"`) || !strings.Contains(line, `"pass":"secret"`) {
+					conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+					return false
+				}
+			case strings.HasPrefix(line, "SUB "):
+				mu.Lock()
+				subscribers = append(subscribers, conn)
+				mu.Unlock()
+			case strings.HasPrefix(line, "PUB "):
+				var subject string
+				var n int
+				fmt.Sscanf(line, "PUB %s %d", &subject, &n)
+				data := make([]byte, n+2)
+				if _, err := io.ReadFull(r, data); err != nil {
+					return false
+				}
+				mu.Lock()
+				for _, sub := range subscribers {
+					fmt.Fprintf(sub, "MSG %s 1 %d\r\n%s\r\n", subject, n, data[:n])
+				}
+				mu.Unlock()
+			}
+			return true
+		})
+	})
+
+	backend, err := OpenBackend("tls://relay:secret@" + addr)
+	if err != nil {
+		t.Fatalf("OpenBackend: %v", err)
+	}
+	defer backend.Close()
+	backend.(*natsBackend).tlsConfig.RootCAs = pool
+
+	publishUntilReceived(t, backend, receive(t, backend), `{"type":"stream.started"}`)
+}
+
+func TestNATSDropsOversizedMessages(t *testing.T) {
+	dropped := make(chan bool, 1)
+	addr := fakeServer(t, func(conn net.Conn) {
+		natsServe(conn, nil, func(conn net.Conn, r *bufio.Reader, line string) bool {
+			if strings.HasPrefix(line, "SUB ") {
+				fmt.Fprintf(conn, "MSG live-video.events 1 %d\r\n", maxPayload+1)
+				conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+				_, err := io.ReadAll(r)
+				dropped <- err == nil // EOF: the client hung up
+				return false
+			}
+			return true
+		})
+	})
+
+	backend, _ := OpenBackend("nats://" + addr)
+	defer backend.Close()
+	receive(t, backend)
+	select {
+	case ok := <-dropped:
+		if !ok {
+			t.Error("client kept the connection after an oversized message")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no subscription")
+	}
+}
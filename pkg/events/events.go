@@ -0,0 +1,276 @@
+// Package events is the service's internal event bus. Packages publish what
+// happened to them (a stream changed status, a pipeline degraded, a job
+// finished) under a topic, and any number of subscribers act on it, so new
+// consumers need no change to the publishers. Events are delivered in
+// process and, with a Backend, relayed to the other replicas.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Topics
+const (
+	StreamStatusChanged = "stream.status_changed" // data: stream_id, from, to, reason, at
+	StreamAutoStopped   = "stream.auto_stopped"   // data: stream_id, reason, last_input_at
+
+	StreamPlaybackVerified = "stream.playback_verified" // data: stream_id, renditions
+	StreamPlaybackFailed   = "stream.playback_failed"   // data: stream_id, error, renditions (the failed ones)
+
+	PipelineDegraded      = "pipeline.degraded"       // data: stream_id, level, action, reason
+	PipelineRestarted     = "pipeline.restarted"      // data: stream_id, sequence, restarts
+	PipelineFailed        = "pipeline.failed"         // data: stream_id, error
+	PipelineInputSwitched = "pipeline.input_switched" // data: stream_id, from, to, reason
+
+	JobCreated   = "job.created"   // data: job_id, video_id, origin, status
+	JobCompleted = "job.completed" // data: job_id, video_id, origin
+	JobFailed    = "job.failed"    // data: job_id, video_id, origin, error
+
+	QoEBeacon = "qoe.beacon" // data: the beacon, as posted and enriched
+
+	ErasureCompleted = "erasure.completed" // data: erasure_id, kind, id, subjects ([{kind, id}]), verified
+
+	TokenRevoked          = "token.revoked"           // data: revocation, as listed
+	TokenRevocationLifted = "token.revocation_lifted" // data: id
+
+	BroadcasterMessage = "broadcaster.message" // data: stream_id, type, level, text, data
+
+	VideoCataloged = "video.cataloged" // data: the video, as listed
+	VideoRemoved   = "video.removed"   // data: video_id
+
+	OwnershipChanged = "ownership.changed" // data: kind, resource_id, owner_id, team_id, grants
+	OwnershipRemoved = "ownership.removed" // data: kind, resource_id
+
+	AuditCompleted = "audit.completed" // data: audit_id, status, assets, playlists, segments, recordings, failures
+	AuditFailed    = "audit.failed"    // data: as audit.completed, with error and findings (the first 20)
+)
+
+// subscriberQueue is how many events a subscriber may fall behind by before
+// its events are dropped
+const subscriberQueue = 256
+
+// relayQueue is how many events may wait for the backend
+const relayQueue = 1024
+
+// Event is something that happened on a replica. Data of events relayed from
+// other replicas went through JSON: numbers are float64 and times strings.
+type Event struct {
+	ID     string         `json:"id"`
+	Type   string         `json:"type"`
+	Origin string         `json:"origin"` // node that published it
+	At     time.Time      `json:"at"`
+	Data   map[string]any `json:"data"`
+}
+
+// Handler acts on an event
+type Handler func(event Event)
+
+// Bus delivers published events to the subscribers of their topic. Each
+// subscriber has its own queue and goroutine, so a slow one never blocks
+// publishers or other subscribers; it loses events instead. A nil Bus drops
+// all events.
+type Bus struct {
+	node string
+
+	mu   sync.RWMutex
+	subs map[*subscription]struct{}
+
+	backend Backend
+	relay   chan Event
+	cancel  context.CancelFunc
+}
+
+type subscription struct {
+	pattern string
+	local   bool
+	queue   chan Event
+	once    sync.Once
+}
+
+// NewBus creates a bus for the replica node, which must be unique among the
+// replicas sharing a backend
+func NewBus(node string) *Bus {
+	return &Bus{node: node, subs: make(map[*subscription]struct{})}
+}
+
+// Node returns the replica the bus publishes from
+func (b *Bus) Node() string {
+	return b.node
+}
+
+// Connect relays the bus's events to the other replicas through backend and
+// delivers theirs. It is called once, before events are published.
+func (b *Bus) Connect(backend Backend) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.mu.Lock()
+	b.backend, b.relay, b.cancel = backend, make(chan Event, relayQueue), cancel
+	b.mu.Unlock()
+
+	go b.forward(ctx)
+	go func() {
+		err := backend.Subscribe(ctx, func(payload []byte) {
+			var event Event
+			if err := json.Unmarshal(payload, &event); err != nil {
+				log.Printf("[Events] Skipping unreadable event from %s: %v", backend, err)
+				return
+			}
+			if event.Origin == b.node {
+				return
+			}
+			b.dispatch(event)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[Events] Stopped receiving from %s: %v", backend, err)
+		}
+	}()
+}
+
+// Publish delivers an event of topic to the subscribers on this replica and
+// queues it for the others. It never blocks.
+func (b *Bus) Publish(topic string, data map[string]any) {
+	if b == nil {
+		return
+	}
+	event := Event{
+		ID:     uuid.New().String(),
+		Type:   topic,
+		Origin: b.node,
+		At:     time.Now().UTC(),
+		Data:   data,
+	}
+	b.dispatch(event)
+
+	b.mu.RLock()
+	relay := b.relay
+	b.mu.RUnlock()
+	if relay == nil {
+		return
+	}
+	select {
+	case relay <- event:
+	default:
+		log.Printf("[Events] Relay queue full, %s event %s stays on this node", event.Type, event.ID)
+	}
+}
+
+// Subscribe calls handler, in order, with the events of every replica whose
+// topic matches pattern: a topic, a prefix ending in ".*" ("stream.*") or
+// "*" for all. It returns a function ending the subscription.
+func (b *Bus) Subscribe(pattern string, handler Handler) func() {
+	return b.subscribe(pattern, false, handler)
+}
+
+// SubscribeLocal is Subscribe for the events published on this replica
+// only. Subscribers with side effects outside the service, such as webhooks,
+// use it so each event is acted on once however many replicas run.
+func (b *Bus) SubscribeLocal(pattern string, handler Handler) func() {
+	return b.subscribe(pattern, true, handler)
+}
+
+func (b *Bus) subscribe(pattern string, local bool, handler Handler) func() {
+	if b == nil {
+		return func() {}
+	}
+	sub := &subscription{pattern: pattern, local: local, queue: make(chan Event, subscriberQueue)}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		for event := range sub.queue {
+			handler(event)
+		}
+	}()
+	return func() {
+		b.mu.Lock()
+		delete(b.subs, sub)
+		b.mu.Unlock()
+		sub.once.Do(func() { close(sub.queue) })
+	}
+}
+
+// Close stops relaying events and closes the backend. Subscribers on this
+// replica keep receiving its events.
+func (b *Bus) Close() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	backend, cancel := b.backend, b.cancel
+	b.backend, b.relay, b.cancel = nil, nil, nil
+	b.mu.Unlock()
+	if backend == nil {
+		return nil
+	}
+	cancel()
+	return backend.Close()
+}
+
+// dispatch queues an event for each matching subscriber
+func (b *Bus) dispatch(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if sub.local && event.Origin != b.node {
+			continue
+		}
+		if !Match(sub.pattern, event.Type) {
+			continue
+		}
+		select {
+		case sub.queue <- event:
+		default:
+			log.Printf("[Events] Subscriber to %s is behind, dropping %s event %s", sub.pattern, event.Type, event.ID)
+		}
+	}
+}
+
+// forward publishes queued events to the backend until ctx is done. Failures
+// are logged when they start and end rather than for every event.
+func (b *Bus) forward(ctx context.Context) {
+	b.mu.RLock()
+	backend, relay := b.backend, b.relay
+	b.mu.RUnlock()
+
+	failing := false
+	for {
+		var event Event
+		select {
+		case <-ctx.Done():
+			return
+		case event = <-relay:
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("[Events] Failed to encode %s event: %v", event.Type, err)
+			continue
+		}
+		publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = backend.Publish(publishCtx, payload)
+		cancel()
+		switch {
+		case err != nil && !failing:
+			log.Printf("[Events] Failed to relay events to %s, they stay on this node: %v", backend, err)
+			failing = true
+		case err == nil && failing:
+			log.Printf("[Events] Relaying events to %s again", backend)
+			failing = false
+		}
+	}
+}
+
+// Match reports whether topic matches a subscription pattern
+func Match(pattern, topic string) bool {
+	if pattern == "*" || pattern == topic {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	return ok && strings.HasSuffix(prefix, ".") && strings.HasPrefix(topic, prefix)
+}
@@ -0,0 +1,232 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsBackend relays events on a NATS subject, speaking just enough of the
+// client protocol for PUB and SUB. One connection publishes, another
+// subscribes.
+type natsBackend struct {
+	addr      string
+	connect   []byte // CONNECT line with the credentials
+	subject   string
+	tls       bool        // required by the URL; servers may require it too
+	tlsConfig *tls.Config // used whenever TLS is
+
+	mu    sync.Mutex // serializes publishing on conn
+	conn  net.Conn
+	r     *bufio.Reader
+	retry backoff
+}
+
+// natsInfo is what the server's INFO tells of TLS
+type natsInfo struct {
+	TLSRequired  bool `json:"tls_required"`
+	TLSAvailable bool `json:"tls_available"`
+}
+
+func newNATSBackend(u *url.URL, subject string) *natsBackend {
+	b := &natsBackend{addr: u.Host, subject: subject, tls: u.Scheme == "tls", tlsConfig: tlsConfig(u.Hostname())}
+	if _, _, err := net.SplitHostPort(b.addr); err != nil {
+		b.addr = net.JoinHostPort(b.addr, "4222")
+	}
+	options := map[string]any{"verbose": false, "pedantic": false, "tls_required": b.tls, "name": "live-video", "lang": "go"}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			options["user"], options["pass"] = u.User.Username(), password
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+	b.connect = append(append([]byte("CONNECT "), connect...), "\r\n"...)
+	return b
+}
+
+func (b *natsBackend) String() string {
+	return "nats " + b.addr + "/" + b.subject
+}
+
+// dial connects, upgrades to TLS when the URL or the server asks for it,
+// sends CONNECT and waits for the server to accept it
+func (b *natsBackend) dial(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 15 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", b.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReaderSize(conn, maxLine)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	err = func() error {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		greeting, ok := strings.CutPrefix(line, "INFO ")
+		if !ok {
+			return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+		}
+		var info natsInfo
+		if err := json.Unmarshal([]byte(greeting), &info); err != nil {
+			return fmt.Errorf("malformed INFO: %w", err)
+		}
+		if b.tls && !info.TLSRequired && !info.TLSAvailable {
+			return fmt.Errorf("server doesn't offer TLS")
+		}
+		if b.tls || info.TLSRequired {
+			tlsConn := tls.Client(conn, b.tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				return fmt.Errorf("TLS handshake: %w", err)
+			}
+			conn, r = tlsConn, bufio.NewReaderSize(tlsConn, maxLine)
+		}
+		if _, err := conn.Write(append(b.connect, "PING\r\n"...)); err != nil {
+			return err
+		}
+		return natsAwaitPong(conn, r)
+	}()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, r, nil
+}
+
+func (b *natsBackend) Publish(ctx context.Context, payload []byte) error {
+	if len(payload) > maxPayload {
+		return errPayloadTooLarge
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		if err := b.retry.ready(); err != nil {
+			return err
+		}
+		conn, r, err := b.dial(ctx)
+		if err != nil {
+			b.retry.failed()
+			return err
+		}
+		b.conn, b.r = conn, r
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	b.conn.SetDeadline(deadline)
+	// The PING confirms the server took the message and answers its own
+	// PINGs, which an idle publishing connection would otherwise miss
+	msg := make([]byte, 0, len(payload)+len(b.subject)+32)
+	msg = append(msg, "PUB "+b.subject+" "...)
+	msg = strconv.AppendInt(msg, int64(len(payload)), 10)
+	msg = append(msg, "\r\n"...)
+	msg = append(msg, payload...)
+	msg = append(msg, "\r\nPING\r\n"...)
+	_, err := b.conn.Write(msg)
+	if err == nil {
+		err = natsAwaitPong(b.conn, b.r)
+	}
+	if err != nil {
+		b.conn.Close()
+		b.conn, b.r = nil, nil
+		b.retry.failed()
+		return err
+	}
+	b.retry.reset()
+	return nil
+}
+
+func (b *natsBackend) Subscribe(ctx context.Context, deliver func(payload []byte)) error {
+	return resubscribe(ctx, b, func(ctx context.Context, subscribed func()) error {
+		conn, r, err := b.dial(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		// The PONG confirms the server took the subscription
+		if _, err := conn.Write([]byte("SUB " + b.subject + " 1\r\nPING\r\n")); err != nil {
+			return err
+		}
+		for {
+			line, err := readLine(r)
+			if err != nil {
+				return err
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case strings.HasPrefix(line, "MSG "):
+				// MSG <subject> <sid> [reply-to] <#bytes>
+				fields := strings.Fields(line)
+				n, err := strconv.Atoi(fields[len(fields)-1])
+				if err != nil || n < 0 {
+					return fmt.Errorf("malformed message %q", line)
+				}
+				if n > maxPayload {
+					return fmt.Errorf("message of %d bytes over the limit", n)
+				}
+				data := make([]byte, n+2)
+				if _, err := io.ReadFull(r, data); err != nil {
+					return err
+				}
+				deliver(data[:n])
+			case line == "PING":
+				if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+					return err
+				}
+			case line == "PONG":
+				subscribed()
+			case strings.HasPrefix(line, "-ERR"):
+				return fmt.Errorf("server error: %s", strings.TrimSpace(line[4:]))
+			}
+		}
+	})
+}
+
+func (b *natsBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn, b.r = nil, nil
+	return err
+}
+
+// natsAwaitPong reads until the PONG of a PING, answering the server's PINGs
+// and failing on its errors
+func natsAwaitPong(conn net.Conn, r *bufio.Reader) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(line[4:]))
+		}
+	}
+}
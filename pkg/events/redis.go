@@ -0,0 +1,252 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisBackend relays events with Redis PUBLISH and SUBSCRIBE, speaking
+// just enough RESP for those. One connection publishes, another subscribes.
+type redisBackend struct {
+	addr      string
+	username  string
+	password  string
+	tlsConfig *tls.Config // nil without TLS
+	channel   string
+
+	mu    sync.Mutex // serializes publishing on conn
+	conn  net.Conn
+	r     *bufio.Reader
+	retry backoff
+}
+
+func newRedisBackend(u *url.URL, channel string) *redisBackend {
+	b := &redisBackend{addr: u.Host, channel: channel}
+	if _, _, err := net.SplitHostPort(b.addr); err != nil {
+		b.addr = net.JoinHostPort(b.addr, "6379")
+	}
+	if u.Scheme == "rediss" {
+		b.tlsConfig = tlsConfig(u.Hostname())
+	}
+	if u.User != nil {
+		b.username = u.User.Username()
+		b.password, _ = u.User.Password()
+	}
+	return b
+}
+
+func (b *redisBackend) String() string {
+	return "redis " + b.addr + "/" + b.channel
+}
+
+// dial connects and authenticates
+func (b *redisBackend) dial(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 15 * time.Second}
+	var conn net.Conn
+	var err error
+	if b.tlsConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: b.tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", b.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", b.addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReaderSize(conn, maxLine)
+	if b.password != "" {
+		args := []string{"AUTH", b.password}
+		if b.username != "" {
+			args = []string{"AUTH", b.username, b.password}
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		err := writeRESP(conn, args...)
+		if err == nil {
+			_, err = readRESP(r)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("auth: %w", err)
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, r, nil
+}
+
+func (b *redisBackend) Publish(ctx context.Context, payload []byte) error {
+	if len(payload) > maxPayload {
+		return errPayloadTooLarge
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		if err := b.retry.ready(); err != nil {
+			return err
+		}
+		conn, r, err := b.dial(ctx)
+		if err != nil {
+			b.retry.failed()
+			return err
+		}
+		b.conn, b.r = conn, r
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	b.conn.SetDeadline(deadline)
+	err := writeRESP(b.conn, "PUBLISH", b.channel, string(payload))
+	if err == nil {
+		_, err = readRESP(b.r)
+	}
+	var replyErr redisError
+	switch {
+	case err == nil:
+		b.retry.reset()
+	case !errors.As(err, &replyErr):
+		// The connection is in an unknown state
+		b.conn.Close()
+		b.conn, b.r = nil, nil
+		b.retry.failed()
+	}
+	return err
+}
+
+func (b *redisBackend) Subscribe(ctx context.Context, deliver func(payload []byte)) error {
+	return resubscribe(ctx, b, func(ctx context.Context, subscribed func()) error {
+		conn, r, err := b.dial(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		if err := writeRESP(conn, "SUBSCRIBE", b.channel); err != nil {
+			return err
+		}
+		for {
+			reply, err := readRESP(r)
+			if err != nil {
+				return err
+			}
+			message, ok := reply.([]any)
+			if !ok || len(message) != 3 {
+				continue
+			}
+			kind, _ := message[0].([]byte)
+			switch string(kind) {
+			case "subscribe":
+				subscribed()
+			case "message":
+				if payload, ok := message[2].([]byte); ok {
+					deliver(payload)
+				}
+			}
+		}
+	})
+}
+
+func (b *redisBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn, b.r = nil, nil
+	return err
+}
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// writeRESP sends a command as an array of bulk strings
+func writeRESP(w io.Writer, args ...string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// maxArray is the most items read in an array reply; Pub/Sub replies have
+// three
+const maxArray = 16
+
+// readRESP reads a reply: a string for simple strings, an int64 for
+// integers, []byte for bulk strings, []any for arrays and nil for null. An
+// error reply is returned as a redisError. Bulk strings over maxPayload,
+// arrays over maxArray and nested arrays are refused.
+func readRESP(r *bufio.Reader) (any, error) {
+	return readReply(r, false)
+}
+
+func readReply(r *bufio.Reader, nested bool) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if n > maxPayload {
+			return nil, fmt.Errorf("bulk string of %d bytes over the limit", n)
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if n > maxArray || nested {
+			return nil, fmt.Errorf("unexpected array of %d items", n)
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r, true); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", kind)
+}
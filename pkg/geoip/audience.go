@@ -0,0 +1,177 @@
+package geoip
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ActiveWindow is how long a viewer counts as watching after it was last
+// seen. Players report every 30 seconds.
+const ActiveWindow = 75 * time.Second
+
+// UnknownCountry groups viewers whose location could not be resolved
+const UnknownCountry = "unknown"
+
+// CountryCount is the number of active viewers in one country
+type CountryCount struct {
+	Country string        `json:"country"`
+	Viewers int           `json:"viewers"`
+	Share   float64       `json:"share"`
+	Regions []RegionCount `json:"regions,omitempty"`
+}
+
+// RegionCount is the number of active viewers in one region
+type RegionCount struct {
+	Region  string `json:"region"`
+	Viewers int    `json:"viewers"`
+}
+
+// Breakdown is the geographic distribution of a stream's active viewers
+type Breakdown struct {
+	Viewers   int            `json:"viewers"`
+	Located   int            `json:"located"`
+	Countries []CountryCount `json:"countries"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// Audience tracks where the active viewers of each stream are
+type Audience struct {
+	mu        sync.Mutex
+	locator   *Locator
+	streams   map[string]map[string]*sighting // stream ID -> viewer/session ID
+	lastPrune time.Time
+}
+
+type sighting struct {
+	loc      Location
+	lastSeen time.Time
+}
+
+// NewAudience creates an audience tracker
+func NewAudience(locator *Locator) *Audience {
+	return &Audience{
+		locator: locator,
+		streams: make(map[string]map[string]*sighting),
+	}
+}
+
+// Seen records that a viewer of a stream is active. The client is located
+// on its first sighting only.
+func (a *Audience) Seen(r *http.Request, clientIP, streamID, viewerID string) {
+	if viewerID == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	viewers, ok := a.streams[streamID]
+	if !ok {
+		viewers = make(map[string]*sighting)
+		a.streams[streamID] = viewers
+	}
+	if s, ok := viewers[viewerID]; ok {
+		s.lastSeen = time.Now()
+		return
+	}
+	viewers[viewerID] = &sighting{loc: a.locator.Locate(r, clientIP), lastSeen: time.Now()}
+
+	// Viewers of streams nobody looks at are only dropped here
+	if time.Since(a.lastPrune) > ActiveWindow {
+		a.prune()
+	}
+}
+
+// prune drops viewers that are no longer active. Callers hold a.mu.
+func (a *Audience) prune() {
+	for streamID, viewers := range a.streams {
+		for id, s := range viewers {
+			if time.Since(s.lastSeen) > ActiveWindow {
+				delete(viewers, id)
+			}
+		}
+		if len(viewers) == 0 {
+			delete(a.streams, streamID)
+		}
+	}
+	a.lastPrune = time.Now()
+}
+
+// Forget removes a viewer that disconnected
+func (a *Audience) Forget(streamID, viewerID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if viewers, ok := a.streams[streamID]; ok {
+		delete(viewers, viewerID)
+		if len(viewers) == 0 {
+			delete(a.streams, streamID)
+		}
+	}
+}
+
+// ForgetStream removes all viewers of a stream and returns how many there were
+func (a *Audience) ForgetStream(streamID string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	viewers := len(a.streams[streamID])
+	delete(a.streams, streamID)
+	return viewers
+}
+
+// Breakdown returns the active viewers of the given streams by country and
+// region, largest first
+func (a *Audience) Breakdown(streamIDs ...string) *Breakdown {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	countries := make(map[string]map[string]int)
+	total, located := 0, 0
+	for _, streamID := range streamIDs {
+		viewers := a.streams[streamID]
+		for id, s := range viewers {
+			if time.Since(s.lastSeen) > ActiveWindow {
+				delete(viewers, id)
+				continue
+			}
+			country := s.loc.Country
+			if country == "" {
+				country = UnknownCountry
+			} else {
+				located++
+			}
+			if countries[country] == nil {
+				countries[country] = make(map[string]int)
+			}
+			countries[country][s.loc.Region]++
+			total++
+		}
+		if viewers != nil && len(viewers) == 0 {
+			delete(a.streams, streamID)
+		}
+	}
+
+	breakdown := &Breakdown{Viewers: total, Located: located, Countries: []CountryCount{}, UpdatedAt: time.Now().UTC()}
+	for country, regions := range countries {
+		count := CountryCount{Country: country}
+		for region, n := range regions {
+			count.Viewers += n
+			if region != "" {
+				count.Regions = append(count.Regions, RegionCount{Region: region, Viewers: n})
+			}
+		}
+		count.Share = float64(count.Viewers) / float64(total)
+		sort.Slice(count.Regions, func(i, j int) bool { return count.Regions[i].Viewers > count.Regions[j].Viewers })
+		breakdown.Countries = append(breakdown.Countries, count)
+	}
+	sort.Slice(breakdown.Countries, func(i, j int) bool {
+		if breakdown.Countries[i].Viewers != breakdown.Countries[j].Viewers {
+			return breakdown.Countries[i].Viewers > breakdown.Countries[j].Viewers
+		}
+		return breakdown.Countries[i].Country < breakdown.Countries[j].Country
+	})
+	return breakdown
+}
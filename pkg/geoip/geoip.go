@@ -0,0 +1,144 @@
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Location is where a viewer is watching from
+type Location struct {
+	Country string `json:"country"` // ISO 3166-1 alpha-2, empty when unknown
+	Region  string `json:"region,omitempty"`
+}
+
+// DB maps IP ranges to locations. It is loaded from a CSV file with one
+// network per line:
+//
+//	network,country[,region]
+//	81.2.69.0/24,GB,England
+//	2001:db8::/32,DE,Bavaria
+//
+// which can be exported from GeoLite2 or IP2Location CSV databases. Lines
+// starting with # and a "network" header line are skipped.
+type DB struct {
+	ranges []ipRange // sorted by start, non-overlapping
+}
+
+type ipRange struct {
+	start netip.Addr
+	end   netip.Addr
+	loc   Location
+}
+
+// Open loads a GeoIP CSV database
+func Open(path string) (*DB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	defer file.Close()
+
+	db := &DB{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, ",")
+		for i := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+		}
+		if line == 1 && fields[0] == "network" {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("GeoIP database line %d: expected network,country[,region]", line)
+		}
+
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("GeoIP database line %d: %w", line, err)
+		}
+		loc := Location{Country: strings.ToUpper(fields[1])}
+		if len(fields) > 2 {
+			loc.Region = fields[2]
+		}
+		prefix = prefix.Masked()
+		db.ranges = append(db.ranges, ipRange{start: prefix.Addr(), end: lastAddr(prefix), loc: loc})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].start.Less(db.ranges[j].start) })
+	return db, nil
+}
+
+// Len returns the number of networks in the database
+func (db *DB) Len() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.ranges)
+}
+
+// Lookup returns the location of an IP address
+func (db *DB) Lookup(ip netip.Addr) (Location, bool) {
+	if db == nil || !ip.IsValid() {
+		return Location{}, false
+	}
+	ip = ip.Unmap()
+
+	// The last range starting at or before ip is the only candidate
+	i := sort.Search(len(db.ranges), func(i int) bool { return ip.Less(db.ranges[i].start) }) - 1
+	if i < 0 || db.ranges[i].end.Less(ip) {
+		return Location{}, false
+	}
+	return db.ranges[i].loc, true
+}
+
+// Locator resolves the location of an HTTP client, preferring a country
+// header set by a trusted CDN or load balancer over the database
+type Locator struct {
+	db            *DB
+	countryHeader string
+}
+
+// NewLocator creates a locator. db and countryHeader may each be empty.
+func NewLocator(db *DB, countryHeader string) *Locator {
+	return &Locator{db: db, countryHeader: countryHeader}
+}
+
+// Locate returns the location of the client with the given IP
+func (l *Locator) Locate(r *http.Request, clientIP string) Location {
+	if l.countryHeader != "" {
+		if country := strings.ToUpper(strings.TrimSpace(r.Header.Get(l.countryHeader))); len(country) == 2 && country != "XX" {
+			return Location{Country: country}
+		}
+	}
+	if ip, err := netip.ParseAddr(clientIP); err == nil {
+		if loc, ok := l.db.Lookup(ip); ok {
+			return loc
+		}
+	}
+	return Location{}
+}
+
+// lastAddr returns the highest address of a masked prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
@@ -0,0 +1,185 @@
+package integrity
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"live-video/pkg/m3u8"
+)
+
+// SignatureSuffix is appended to the path of a playlist to get the path of
+// its detached signature
+const SignatureSuffix = ".sig"
+
+// Manifest is the detached signature of a playlist: its digest and the
+// digests of the segments it lists, signed with Ed25519. Master playlists
+// only cover themselves; their media playlists have signatures of their own.
+type Manifest struct {
+	Playlist  string            `json:"playlist"`           // object path of the playlist
+	SHA256    string            `json:"sha256"`             // hex digest of the playlist
+	Segments  map[string]string `json:"segments,omitempty"` // hex digests by URI as listed in the playlist
+	SignedAt  time.Time         `json:"signed_at"`
+	KeyID     string            `json:"key_id"`
+	Signature string            `json:"signature,omitempty"` // base64 signature of the manifest without it
+}
+
+// Signer signs playlists with an Ed25519 key
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// NewSigner creates a signer from a base64 encoded 32-byte Ed25519 seed
+func NewSigner(seed string) (*Signer, error) {
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not base64: %w", err)
+	}
+	if len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be a %d-byte Ed25519 seed", ed25519.SeedSize)
+	}
+	key := ed25519.NewKeyFromSeed(raw)
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey))}, nil
+}
+
+// KeyID identifies a public key: the first 8 bytes of its SHA-256, in hex
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// PublicKey returns the key signatures are verified with
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// KeyID identifies the signer's key
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign signs a playlist published as name. The segments it lists are read
+// from dir, the local directory of the playlist.
+func (s *Signer) Sign(name string, playlist []byte, dir string) (*Manifest, error) {
+	m := &Manifest{
+		Playlist: name,
+		SHA256:   Digest(playlist),
+		SignedAt: time.Now().UTC(),
+		KeyID:    s.keyID,
+	}
+	if !m3u8.IsMaster(playlist) {
+		media, err := m3u8.ParseMedia(playlist)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse playlist: %w", err)
+		}
+		uris := media.URIs()
+		m.Segments = make(map[string]string, len(uris))
+		for _, uri := range uris {
+			if strings.Contains(uri, "://") || path.IsAbs(uri) || strings.HasPrefix(path.Clean(uri), "..") {
+				return nil, fmt.Errorf("unsupported segment URI %s", uri)
+			}
+			digest, err := fileDigest(filepath.Join(dir, filepath.FromSlash(uri)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash segment %s: %w", uri, err)
+			}
+			m.Segments[uri] = digest
+		}
+	}
+
+	return s.seal(m)
+}
+
+// Resign signs a new version of a playlist that lists the same segments as
+// the one previous was signed for, e.g. once it is ended, keeping the segment
+// digests of previous. previous must carry a valid signature of the signer.
+func (s *Signer) Resign(previous *Manifest, playlist []byte) (*Manifest, error) {
+	if err := previous.Verify(s.PublicKey()); err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		Playlist: previous.Playlist,
+		SHA256:   Digest(playlist),
+		Segments: previous.Segments,
+		SignedAt: time.Now().UTC(),
+		KeyID:    s.keyID,
+	}
+	return s.seal(m)
+}
+
+// seal signs a manifest
+func (s *Signer) seal(m *Manifest) (*Manifest, error) {
+	message, err := m.message()
+	if err != nil {
+		return nil, err
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, message))
+	return m, nil
+}
+
+// Verify checks the signature of a manifest
+func (m *Manifest) Verify(pub ed25519.PublicKey) error {
+	if m.KeyID != KeyID(pub) {
+		return fmt.Errorf("signed with unknown key %s", m.KeyID)
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("signature is not base64")
+	}
+	message, err := m.message()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, message, signature) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// Mismatches returns the URIs of segments whose digest differs from the
+// manifest's or that it doesn't list, by URI
+func (m *Manifest) Mismatches(segments map[string]string) []string {
+	var mismatched []string
+	for uri, digest := range segments {
+		if expected, ok := m.Segments[uri]; !ok || !strings.EqualFold(expected, digest) {
+			mismatched = append(mismatched, uri)
+		}
+	}
+	return mismatched
+}
+
+// message is what is signed: the manifest without its signature as JSON,
+// whose object keys encoding/json sorts
+func (m *Manifest) message() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = ""
+	return json.Marshal(unsigned)
+}
+
+// Digest returns the hex SHA-256 of data
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,216 @@
+// Package interactions records what viewers and broadcasters do during a
+// broadcast, chat messages, reactions, polls and cue points, with their time
+// into the broadcast, so they can be replayed alongside its recording.
+package interactions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of interactions
+const (
+	KindChat     = "chat"     // data: text
+	KindReaction = "reaction" // data: reaction, e.g. an emoji
+	KindPoll     = "poll"     // data: action and the fields of the action
+	KindCue      = "cue"      // data: name and any fields of the broadcaster's
+)
+
+// Poll actions
+const (
+	PollOpen  = "open"  // data: question, options; poll_id is assigned
+	PollVote  = "vote"  // data: poll_id, option
+	PollClose = "close" // data: poll_id; results are filled in
+)
+
+// Limits of interactions
+const (
+	MaxChatLength     = 500
+	MaxReactionLength = 32
+	MaxPollOptions    = 10
+	MaxSessionEntries = 100000
+)
+
+var (
+	// ErrNotLive is returned for interactions with a stream that is not live
+	ErrNotLive = errors.New("stream is not live")
+	// ErrInvalid is returned for interactions that are malformed
+	ErrInvalid = errors.New("invalid interaction")
+	// ErrConflict is returned for interactions the state of the session
+	// refuses, such as a second vote or a vote on a closed poll
+	ErrConflict = errors.New("interaction refused")
+)
+
+// Entry is an interaction recorded during a broadcast
+type Entry struct {
+	ID     string         `json:"id"`
+	Kind   string         `json:"kind"`
+	Offset float64        `json:"offset"` // seconds since the session started
+	At     time.Time      `json:"at"`
+	Author string         `json:"author,omitempty"`
+	Data   map[string]any `json:"data,omitempty"`
+}
+
+// Session is what happened during one broadcast of a stream, from the
+// moment it went live until it stopped. Offsets count from StartedAt, the
+// start of the stream's recording; players aligning on
+// EXT-X-PROGRAM-DATE-TIME use the entries' At instead.
+type Session struct {
+	ID        string     `json:"id"`
+	StreamID  string     `json:"stream_id"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Entries   []Entry    `json:"entries"`
+}
+
+// SessionInfo describes a recorded session without its entries
+type SessionInfo struct {
+	ID        string     `json:"id"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Live      bool       `json:"live"`
+}
+
+// Window returns the entries of the session with an offset in [from, to).
+// A negative to means no end.
+func (s *Session) Window(from, to float64) []Entry {
+	entries := make([]Entry, 0, len(s.Entries))
+	for _, e := range s.Entries {
+		if e.Offset >= from && (to < 0 || e.Offset < to) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// sessionIDLayout formats the start of a session as its ID
+const sessionIDLayout = "20060102T150405Z"
+
+// sessionID returns the ID of the session started at
+func sessionID(startedAt time.Time) string {
+	return startedAt.UTC().Format(sessionIDLayout)
+}
+
+// Privileged reports whether an interaction is the broadcaster's to make:
+// cue points and opening or closing polls
+func Privileged(kind string, data map[string]any) bool {
+	if kind == KindCue {
+		return true
+	}
+	if kind == KindPoll {
+		action, _ := data["action"].(string)
+		return action != PollVote
+	}
+	return false
+}
+
+// poll is the state of a poll of a live session
+type poll struct {
+	options []string
+	votes   map[string]int // by option
+	voters  map[string]bool
+	closed  bool
+}
+
+// validate checks an entry of a live session with polls, filling in what
+// the server decides: poll IDs and results. voter identifies the caller for
+// one vote per poll; empty skips the check.
+func validate(e *Entry, polls map[string]*poll, voter string) error {
+	text := func(key string, max int) (string, error) {
+		value, _ := e.Data[key].(string)
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return "", fmt.Errorf("%w: %s %s is required", ErrInvalid, e.Kind, key)
+		}
+		if utf8.RuneCountInString(value) > max {
+			return "", fmt.Errorf("%w: %s %s is longer than %d characters", ErrInvalid, e.Kind, key, max)
+		}
+		e.Data[key] = value
+		return value, nil
+	}
+	if e.Data == nil {
+		e.Data = map[string]any{}
+	}
+
+	switch e.Kind {
+	case KindChat:
+		_, err := text("text", MaxChatLength)
+		return err
+	case KindReaction:
+		_, err := text("reaction", MaxReactionLength)
+		return err
+	case KindCue:
+		_, err := text("name", 64)
+		return err
+	case KindPoll:
+	default:
+		return fmt.Errorf("%w: unknown kind %q (chat, reaction, poll or cue)", ErrInvalid, e.Kind)
+	}
+
+	action, _ := e.Data["action"].(string)
+	if action == PollOpen {
+		if _, err := text("question", 200); err != nil {
+			return err
+		}
+		raw, _ := e.Data["options"].([]any)
+		if len(raw) < 2 || len(raw) > MaxPollOptions {
+			return fmt.Errorf("%w: a poll has 2 to %d options", ErrInvalid, MaxPollOptions)
+		}
+		p := &poll{votes: map[string]int{}, voters: map[string]bool{}}
+		for _, option := range raw {
+			name, _ := option.(string)
+			name = strings.TrimSpace(name)
+			if _, seen := p.votes[name]; seen || name == "" || utf8.RuneCountInString(name) > 100 {
+				return fmt.Errorf("%w: poll options are distinct texts of up to 100 characters", ErrInvalid)
+			}
+			p.votes[name] = 0
+			p.options = append(p.options, name)
+		}
+		id := uuid.New().String()[:8]
+		e.Data = map[string]any{"action": PollOpen, "poll_id": id, "question": e.Data["question"], "options": p.options}
+		polls[id] = p
+		return nil
+	}
+
+	id, _ := e.Data["poll_id"].(string)
+	p, ok := polls[id]
+	if !ok {
+		return fmt.Errorf("%w: unknown poll %q", ErrInvalid, id)
+	}
+	switch action {
+	case PollVote:
+		option, _ := e.Data["option"].(string)
+		if _, ok := p.votes[option]; !ok {
+			return fmt.Errorf("%w: %q is not an option of poll %s", ErrInvalid, option, id)
+		}
+		if p.closed {
+			return fmt.Errorf("%w: poll %s is closed", ErrConflict, id)
+		}
+		if voter != "" && p.voters[voter] {
+			return fmt.Errorf("%w: already voted in poll %s", ErrConflict, id)
+		}
+		if voter != "" {
+			p.voters[voter] = true
+		}
+		p.votes[option]++
+		e.Data = map[string]any{"action": PollVote, "poll_id": id, "option": option}
+	case PollClose:
+		if p.closed {
+			return fmt.Errorf("%w: poll %s is closed", ErrConflict, id)
+		}
+		p.closed = true
+		results := make(map[string]int, len(p.votes))
+		for option, votes := range p.votes {
+			results[option] = votes
+		}
+		e.Data = map[string]any{"action": PollClose, "poll_id": id, "results": results}
+	default:
+		return fmt.Errorf("%w: unknown poll action %q (open, vote or close)", ErrInvalid, action)
+	}
+	return nil
+}
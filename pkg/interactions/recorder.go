@@ -0,0 +1,314 @@
+package interactions
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/broadcast"
+	"live-video/pkg/events"
+	"live-video/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// Recorder keeps the session of every live stream, journaled to a local
+// file as interactions come in, and uploads it next to the stream's
+// recordings when the stream stops
+type Recorder struct {
+	dir     string
+	storage *storage.GCSService
+
+	mu   sync.Mutex
+	live map[string]*liveSession // by stream ID
+}
+
+type liveSession struct {
+	session Session
+	journal *os.File
+	polls   map[string]*poll
+}
+
+// NewRecorder creates a recorder journaling live sessions in dir
+func NewRecorder(dir string, gcsService *storage.GCSService) *Recorder {
+	return &Recorder{dir: dir, storage: gcsService, live: make(map[string]*liveSession)}
+}
+
+// Follow begins a session whenever a stream of this replica goes live and
+// ends it when the stream stops
+func (r *Recorder) Follow(bus *events.Bus) {
+	bus.SubscribeLocal(events.StreamStatusChanged, func(e events.Event) {
+		streamID, _ := e.Data["stream_id"].(string)
+		switch fmt.Sprint(e.Data["to"]) {
+		case string(broadcast.StatusStreaming):
+			startedAt, ok := e.Data["at"].(time.Time)
+			if !ok {
+				startedAt = e.At
+			}
+			r.Begin(streamID, startedAt)
+		case string(broadcast.StatusStopped), string(broadcast.StatusErrored):
+			if err := r.End(context.Background(), streamID); err != nil {
+				log.Printf("[Interactions] Failed to save session of %s, kept for the next start: %v", streamID, err)
+			}
+		}
+	})
+}
+
+// Begin starts the session of a stream that went live at startedAt, unless
+// one is running. A stream resumed after a pause continues its session.
+func (r *Recorder) Begin(streamID string, startedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.live[streamID]; ok {
+		return
+	}
+	session := Session{ID: sessionID(startedAt), StreamID: streamID, StartedAt: startedAt.UTC(), Entries: []Entry{}}
+	live := &liveSession{session: session, polls: make(map[string]*poll)}
+
+	// Without a journal the session is only lost if the process dies
+	journal, err := os.Create(r.journalPath(streamID, session.ID))
+	if err == nil {
+		header, _ := json.Marshal(Session{ID: session.ID, StreamID: streamID, StartedAt: session.StartedAt})
+		_, err = journal.Write(append(header, '\n'))
+	}
+	if err != nil {
+		log.Printf("[Interactions] Failed to journal session of %s: %v", streamID, err)
+	} else {
+		live.journal = journal
+	}
+	r.live[streamID] = live
+	log.Printf("[Interactions] Recording session %s of %s", session.ID, streamID)
+}
+
+// Record adds an interaction to the live session of a stream, stamped with
+// its time into the session, and returns it as recorded. voter identifies
+// the caller for one vote per poll.
+func (r *Recorder) Record(streamID string, entry Entry, voter string) (Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	live, ok := r.live[streamID]
+	if !ok {
+		return Entry{}, ErrNotLive
+	}
+	if len(live.session.Entries) >= MaxSessionEntries {
+		return Entry{}, fmt.Errorf("%w: the session has %d interactions, the most it records", ErrConflict, MaxSessionEntries)
+	}
+	if err := validate(&entry, live.polls, voter); err != nil {
+		return Entry{}, err
+	}
+
+	now := time.Now().UTC()
+	entry.ID = uuid.New().String()
+	entry.At = now
+	entry.Offset = math.Round(now.Sub(live.session.StartedAt).Seconds()*1000) / 1000
+	live.session.Entries = append(live.session.Entries, entry)
+	if live.journal != nil {
+		line, _ := json.Marshal(entry)
+		if _, err := live.journal.Write(append(line, '\n')); err != nil {
+			log.Printf("[Interactions] Failed to journal interaction of %s: %v", streamID, err)
+		}
+	}
+	return entry, nil
+}
+
+// End ends the live session of a stream and uploads it. A session that
+// fails to upload stays journaled for Recover.
+func (r *Recorder) End(ctx context.Context, streamID string) error {
+	r.mu.Lock()
+	live, ok := r.live[streamID]
+	delete(r.live, streamID)
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if live.journal != nil {
+		live.journal.Close()
+	}
+	now := time.Now().UTC()
+	live.session.EndedAt = &now
+	if err := r.upload(ctx, &live.session); err != nil {
+		return err
+	}
+	os.Remove(r.journalPath(streamID, live.session.ID))
+	log.Printf("[Interactions] Saved session %s of %s (%d interactions)", live.session.ID, streamID, len(live.session.Entries))
+	return nil
+}
+
+// Recover uploads the sessions journaled by a previous run, which ended
+// with it, and returns how many it saved
+func (r *Recorder) Recover(ctx context.Context) (int, error) {
+	journals, err := filepath.Glob(filepath.Join(r.dir, "*.jsonl"))
+	if err != nil {
+		return 0, err
+	}
+	saved := 0
+	var errs []error
+	for _, journal := range journals {
+		session, err := readJournal(journal)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(journal), err))
+			continue
+		}
+		if err := r.upload(ctx, session); err != nil {
+			errs = append(errs, fmt.Errorf("session %s of %s: %w", session.ID, session.StreamID, err))
+			continue
+		}
+		os.Remove(journal)
+		saved++
+	}
+	return saved, errors.Join(errs...)
+}
+
+// Erase drops the live session and the journals of a stream without saving
+// them, and returns how many sessions it dropped. Saved sessions are objects
+// of the stream's recordings folder.
+func (r *Recorder) Erase(streamID string) int {
+	r.mu.Lock()
+	live, ok := r.live[streamID]
+	delete(r.live, streamID)
+	r.mu.Unlock()
+	if ok && live.journal != nil {
+		live.journal.Close()
+	}
+
+	journals, _ := filepath.Glob(filepath.Join(r.dir, streamID+"-*.jsonl"))
+	for _, journal := range journals {
+		os.Remove(journal)
+	}
+	dropped := len(journals)
+	if ok && live.journal == nil {
+		dropped++ // a live session without a journal
+	}
+	return dropped
+}
+
+// Journals returns how many sessions of a stream are journaled
+func (r *Recorder) Journals(streamID string) int {
+	journals, _ := filepath.Glob(filepath.Join(r.dir, streamID+"-*.jsonl"))
+	return len(journals)
+}
+
+// Sessions lists the sessions of a stream, oldest first
+func (r *Recorder) Sessions(ctx context.Context, streamID string) ([]SessionInfo, error) {
+	objects, err := r.storage.ListObjects(ctx, r.objectPath(streamID, "")+"/")
+	if err != nil {
+		return nil, err
+	}
+	var sessions []SessionInfo
+	for _, attrs := range objects {
+		id, ok := strings.CutSuffix(path.Base(attrs.Name), ".json")
+		if !ok {
+			continue
+		}
+		startedAt, err := time.Parse(sessionIDLayout, id)
+		if err != nil {
+			continue
+		}
+		// Sessions are uploaded when they end
+		endedAt := attrs.Updated.UTC()
+		sessions = append(sessions, SessionInfo{ID: id, StartedAt: startedAt, EndedAt: &endedAt})
+	}
+
+	r.mu.Lock()
+	if live, ok := r.live[streamID]; ok {
+		sessions = append(sessions, SessionInfo{ID: live.session.ID, StartedAt: live.session.StartedAt, Live: true})
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(sessions, func(a, b SessionInfo) int { return a.StartedAt.Compare(b.StartedAt) })
+	return sessions, nil
+}
+
+// Session returns a session of a stream, live or saved. It returns
+// os.ErrNotExist for sessions that don't exist.
+func (r *Recorder) Session(ctx context.Context, streamID, id string) (*Session, error) {
+	r.mu.Lock()
+	if live, ok := r.live[streamID]; ok && live.session.ID == id {
+		session := live.session
+		session.Entries = slices.Clone(live.session.Entries)
+		r.mu.Unlock()
+		return &session, nil
+	}
+	r.mu.Unlock()
+
+	if _, err := time.Parse(sessionIDLayout, id); err != nil {
+		return nil, os.ErrNotExist
+	}
+	data, err := r.storage.ReadFile(ctx, r.objectPath(streamID, id))
+	if storage.IsNotExist(err) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("unreadable session %s of %s: %w", id, streamID, err)
+	}
+	return &session, nil
+}
+
+// upload writes a session next to the recordings of its stream
+func (r *Recorder) upload(ctx context.Context, session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return r.storage.UploadBytes(ctx, data, r.objectPath(session.StreamID, session.ID), "application/json")
+}
+
+// objectPath returns the bucket path of a saved session, or of the folder
+// of a stream's sessions when id is empty
+func (r *Recorder) objectPath(streamID, id string) string {
+	if id == "" {
+		return r.storage.Layout().RecordingPath(streamID, "interactions")
+	}
+	return r.storage.Layout().RecordingPath(streamID, "interactions", id+".json")
+}
+
+func (r *Recorder) journalPath(streamID, id string) string {
+	return filepath.Join(r.dir, streamID+"-"+id+".jsonl")
+}
+
+// readJournal reads a session journal. The session ends with its last
+// interaction; a line cut short by a crash is skipped.
+func readJournal(path string) (*Session, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	var session Session
+	if !scanner.Scan() {
+		return nil, errors.New("empty journal")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &session); err != nil || session.StreamID == "" {
+		return nil, errors.New("unreadable journal header")
+	}
+	session.Entries = []Entry{}
+	endedAt := session.StartedAt
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		session.Entries = append(session.Entries, entry)
+		endedAt = entry.At
+	}
+	session.EndedAt = &endedAt
+	return &session, scanner.Err()
+}
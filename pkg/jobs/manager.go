@@ -0,0 +1,439 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"live-video/config"
+	"live-video/pkg/events"
+	"live-video/pkg/storage"
+)
+
+type JobStatus string
+
+const (
+	StatusPendingUpload JobStatus = "pending_upload"
+	StatusQueued        JobStatus = "queued"
+	StatusProcessing    JobStatus = "processing"
+	StatusCompleted     JobStatus = "completed"
+	StatusFailed        JobStatus = "failed"
+)
+
+// Checkpoint stages of a transcode job
+const (
+	StageDownloaded = "downloaded" // source copied to local disk
+	StageConverted  = "converted"  // local HLS output complete, upload in progress
+)
+
+// MaxAttempts is how many times an interrupted job is retried before it fails
+const MaxAttempts = 3
+
+// Job origins
+const (
+	OriginDirectUpload    = "direct_upload"
+	OriginGCSNotification = "gcs_notification"
+	OriginUpload          = "upload"      // retry of a multipart upload from its staged source
+	OriginRetranscode     = "retranscode" // new ladder or codec for a published video
+	OriginDVRClip         = "dvr_clip"    // clip exported from the DVR window of a live stream
+	OriginSlideshow       = "slideshow"   // video rendered from images and an audio track
+)
+
+// Job tracks an asynchronous VOD transcode job
+type Job struct {
+	ID          string                 `json:"id"`
+	VideoID     string                 `json:"video_id"`
+	Status      JobStatus              `json:"status"`
+	Origin      string                 `json:"origin"`
+	SourcePath  string                 `json:"source_path"` // GCS path of the uploaded source
+	FileName    string                 `json:"file_name"`
+	ContentType string                 `json:"content_type"`
+	Error       string                 `json:"error,omitempty"`
+	Suggestion  string                 `json:"suggestion,omitempty"` // how to fix the source of a failed job
+	Video       *storage.VideoMetadata `json:"video,omitempty"`
+	Playable    bool                   `json:"playable,omitempty"` // video can be watched while it is still converting
+	StreamID    string                 `json:"stream_id,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+
+	// Inputs and progress needed to resume the job after a restart
+	Size          int64                 `json:"size,omitempty"`
+	AutoBroadcast bool                  `json:"auto_broadcast,omitempty"`
+	Attempts      int                   `json:"attempts,omitempty"`
+	StagingID     string                `json:"staging_id,omitempty"`   // staged local copy of the source
+	Ladder        []string              `json:"ladder,omitempty"`       // rendition names of a re-transcode
+	Codec         string                `json:"codec,omitempty"`        // video codec of a re-transcode
+	HDR           string                `json:"hdr,omitempty"`          // HDR handling of a re-transcode
+	Segments      *config.SegmentNaming `json:"segments,omitempty"`     // segment naming of a re-transcode
+	FieldOrder    string                `json:"field_order,omitempty"`  // of the source video, e.g. progressive or tt
+	Deinterlacer  string                `json:"deinterlacer,omitempty"` // filter used on an interlaced source
+	SourceCodec   string                `json:"source_codec,omitempty"` // of the source video, e.g. prores (422 HQ)
+	PixelFormat   string                `json:"pixel_format,omitempty"` // of the source video, e.g. yuv422p10le
+	Clip          *Clip                 `json:"clip,omitempty"`         // range and output of a DVR clip
+	Checkpoint    *Checkpoint           `json:"-"`                      // local paths, persisted but not shown to clients
+}
+
+// jobRecord is the on-disk form of a job, including its checkpoint
+type jobRecord struct {
+	*Job
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// Checkpoint records how far a transcode got and which local files hold its
+// partial output, so an interrupted job can resume instead of starting over
+type Checkpoint struct {
+	Stage        string   `json:"stage"`
+	LocalSource  string   `json:"local_source,omitempty"`
+	PlaylistPath string   `json:"playlist_path,omitempty"`
+	SegmentPath  string   `json:"segment_path,omitempty"`
+	Duration     float64  `json:"duration,omitempty"`
+	Uploaded     []string `json:"uploaded,omitempty"` // HLS files already in GCS
+}
+
+// Clip is the range of a live stream a DVR clip job exports. It is pinned to
+// a segment by media sequence number, so the clip doesn't drift as the live
+// window moves on.
+type Clip struct {
+	SourceID      string  `json:"source_id"`          // stream whose live output is cut
+	Playlist      string  `json:"playlist"`           // media playlist, relative to the live folder
+	FirstSequence int     `json:"first_sequence"`     // media sequence number of the first segment
+	Offset        float64 `json:"offset"`             // seconds into the first segment
+	Duration      float64 `json:"duration"`           // seconds
+	GCSPath       string  `json:"gcs_path,omitempty"` // exported MP4
+}
+
+// Manager keeps track of transcode jobs in memory. With a checkpoint
+// directory every change is also written to disk so jobs survive restarts.
+type Manager struct {
+	mu            sync.RWMutex
+	jobs          map[string]*Job
+	checkpointDir string
+	events        *events.Bus
+}
+
+// NewManager creates a new job manager. An empty checkpointDir keeps jobs in
+// memory only.
+func NewManager(checkpointDir string) *Manager {
+	if checkpointDir != "" {
+		if err := os.MkdirAll(checkpointDir, 0o755); err != nil {
+			log.Printf("[Jobs] Failed to create checkpoint directory, jobs will not survive restarts: %v", err)
+			checkpointDir = ""
+		}
+	}
+	return &Manager{
+		jobs:          make(map[string]*Job),
+		checkpointDir: checkpointDir,
+	}
+}
+
+// SetEvents publishes created jobs and jobs that completed or failed on bus
+func (m *Manager) SetEvents(bus *events.Bus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = bus
+}
+
+// Recover loads persisted jobs and returns those that were queued or
+// processing when the server stopped. They are re-queued with their attempt
+// counter raised; jobs that ran out of attempts are marked failed instead.
+func (m *Manager) Recover() ([]*Job, error) {
+	if m.checkpointDir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(m.checkpointDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job checkpoints: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var interrupted []*Job
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(m.checkpointDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var job Job
+		record := jobRecord{Job: &job}
+		if err := json.Unmarshal(data, &record); err != nil || job.ID == "" {
+			log.Printf("[Jobs] Skipping unreadable checkpoint %s", entry.Name())
+			continue
+		}
+
+		// Finished jobs are kept for a week so their status stays queryable
+		finished := job.Status == StatusCompleted || job.Status == StatusFailed
+		if finished && time.Since(job.UpdatedAt) > 7*24*time.Hour {
+			os.Remove(filepath.Join(m.checkpointDir, entry.Name()))
+			continue
+		}
+
+		job.Checkpoint = record.Checkpoint
+		m.jobs[job.ID] = &job
+		if job.Status != StatusQueued && job.Status != StatusProcessing {
+			continue
+		}
+
+		job.Attempts++
+		job.UpdatedAt = time.Now()
+		if job.Attempts > MaxAttempts {
+			job.Status = StatusFailed
+			job.Error = "job interrupted too many times"
+			m.publishFinished(&job)
+		} else {
+			job.Status = StatusQueued
+			interrupted = append(interrupted, job.copy())
+		}
+		m.persist(&job)
+	}
+
+	return interrupted, nil
+}
+
+// Active returns the jobs that are queued or processing
+func (m *Manager) Active() []*Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var active []*Job
+	for _, job := range m.jobs {
+		if job.Status == StatusQueued || job.Status == StatusProcessing {
+			active = append(active, job.copy())
+		}
+	}
+	return active
+}
+
+// Create registers a new job for a video
+func (m *Manager) Create(origin, videoID, sourcePath, fileName, contentType string, status JobStatus) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	job := &Job{
+		ID:          uuid.New().String(),
+		VideoID:     videoID,
+		Origin:      origin,
+		Status:      status,
+		SourcePath:  sourcePath,
+		FileName:    fileName,
+		ContentType: contentType,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	m.jobs[job.ID] = job
+	m.persist(job)
+	m.events.Publish(events.JobCreated, map[string]any{
+		"job_id":   job.ID,
+		"video_id": job.VideoID,
+		"origin":   job.Origin,
+		"status":   job.Status,
+	})
+	return job.copy()
+}
+
+// Get returns a snapshot of the job with the given ID
+func (m *Manager) Get(jobID string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, exists := m.jobs[jobID]
+	if !exists {
+		return nil, fmt.Errorf("job not found: %s", jobID)
+	}
+
+	return job.copy(), nil
+}
+
+// FindByVideoID returns the most recent job for a video
+func (m *Manager) FindByVideoID(videoID string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var latest *Job
+	for _, job := range m.jobs {
+		if job.VideoID != videoID {
+			continue
+		}
+		if latest == nil || job.CreatedAt.After(latest.CreatedAt) {
+			latest = job
+		}
+	}
+
+	if latest == nil {
+		return nil, fmt.Errorf("no job found for video: %s", videoID)
+	}
+
+	return latest.copy(), nil
+}
+
+// ForAsset returns the jobs of a video, or of a stream they clip
+func (m *Manager) ForAsset(assetID string) []*Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var jobs []*Job
+	for _, job := range m.jobs {
+		if job.forAsset(assetID) {
+			jobs = append(jobs, job.copy())
+		}
+	}
+	return jobs
+}
+
+// Forget removes the jobs of a video or stream, with their checkpoints and
+// local sources, and returns how many it removed. Queued and processing
+// jobs would write their output again and are kept.
+func (m *Manager) Forget(assetID string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for id, job := range m.jobs {
+		if !job.forAsset(assetID) || job.Status == StatusQueued || job.Status == StatusProcessing {
+			continue
+		}
+		if job.Checkpoint != nil && job.Checkpoint.LocalSource != "" {
+			os.Remove(job.Checkpoint.LocalSource)
+		}
+		if m.checkpointDir != "" {
+			os.Remove(filepath.Join(m.checkpointDir, id+".json"))
+		}
+		delete(m.jobs, id)
+		removed++
+	}
+	return removed
+}
+
+// Update applies fn to the job under lock
+func (m *Manager) Update(jobID string, fn func(job *Job)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, exists := m.jobs[jobID]
+	if !exists {
+		return fmt.Errorf("job not found: %s", jobID)
+	}
+
+	status := job.Status
+	fn(job)
+	job.UpdatedAt = time.Now()
+	m.persist(job)
+	if job.Status != status {
+		m.publishFinished(job)
+	}
+	return nil
+}
+
+// Transition moves a job from status from to status to, and reports whether
+// it did: false when the job was no longer in from, e.g. because a
+// concurrent request moved it first
+func (m *Manager) Transition(jobID string, from, to JobStatus) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, exists := m.jobs[jobID]
+	if !exists {
+		return false, fmt.Errorf("job not found: %s", jobID)
+	}
+	if job.Status != from {
+		return false, nil
+	}
+	job.Status = to
+	job.UpdatedAt = time.Now()
+	m.persist(job)
+	m.publishFinished(job)
+	return true, nil
+}
+
+// SetStatus updates the job status and error message. Errors with a
+// Suggestion method, such as unsupported sources, also set the suggestion.
+func (m *Manager) SetStatus(jobID string, status JobStatus, jobErr error) error {
+	return m.Update(jobID, func(job *Job) {
+		job.Status = status
+		job.Error, job.Suggestion = "", ""
+		if jobErr != nil {
+			job.Error = jobErr.Error()
+		}
+		var fixable interface{ Suggestion() string }
+		if errors.As(jobErr, &fixable) {
+			job.Suggestion = fixable.Suggestion()
+		}
+	})
+}
+
+// publishFinished publishes a job that just completed or failed. Callers
+// hold m.mu.
+func (m *Manager) publishFinished(job *Job) {
+	data := map[string]any{
+		"job_id":   job.ID,
+		"video_id": job.VideoID,
+		"origin":   job.Origin,
+	}
+	switch job.Status {
+	case StatusCompleted:
+		m.events.Publish(events.JobCompleted, data)
+	case StatusFailed:
+		data["error"] = job.Error
+		m.events.Publish(events.JobFailed, data)
+	}
+}
+
+// persist writes the job to the checkpoint directory. Callers hold m.mu.
+func (m *Manager) persist(job *Job) {
+	if m.checkpointDir == "" {
+		return
+	}
+
+	data, err := json.Marshal(jobRecord{Job: job, Checkpoint: job.Checkpoint})
+	if err != nil {
+		return
+	}
+
+	// Write and rename so a crash never leaves a truncated checkpoint
+	path := filepath.Join(m.checkpointDir, job.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		log.Printf("[Jobs] Failed to checkpoint job %s: %v", job.ID, err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("[Jobs] Failed to checkpoint job %s: %v", job.ID, err)
+	}
+}
+
+// forAsset reports whether a job belongs to a video or stream
+func (j *Job) forAsset(assetID string) bool {
+	return j.VideoID == assetID || j.StreamID == assetID || (j.Clip != nil && j.Clip.SourceID == assetID)
+}
+
+func (j *Job) copy() *Job {
+	c := *j
+	c.Ladder = append([]string(nil), j.Ladder...)
+	if j.Segments != nil {
+		segments := *j.Segments
+		c.Segments = &segments
+	}
+	if j.Clip != nil {
+		clip := *j.Clip
+		c.Clip = &clip
+	}
+	if j.Checkpoint != nil {
+		cp := *j.Checkpoint
+		cp.Uploaded = append([]string(nil), j.Checkpoint.Uploaded...)
+		c.Checkpoint = &cp
+	}
+	return &c
+}
@@ -0,0 +1,191 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+)
+
+// Stages of an upload or transcode job, as reported by its progress
+const (
+	ProgressReceiving   = "receiving"   // request body of a proxied upload
+	ProgressDownloading = "downloading" // source copied from the bucket
+	ProgressValidating  = "validating"
+	ProgressConverting  = "converting" // segments are uploaded as they are converted
+	ProgressUploading   = "uploading"  // segments left after the conversion
+	ProgressCompleted   = "completed"
+	ProgressFailed      = "failed"
+)
+
+// progressRetention is how long the progress of a finished job is kept, for
+// clients that connect late
+const progressRetention = 10 * time.Minute
+
+// Progress is how far an upload or transcode job got. It changes too often
+// to be checkpointed and is kept in memory only.
+type Progress struct {
+	JobID            string    `json:"job_id"`
+	VideoID          string    `json:"video_id"`
+	Stage            string    `json:"stage"`
+	BytesReceived    int64     `json:"bytes_received"`
+	BytesTotal       int64     `json:"bytes_total,omitempty"` // 0 when the client sent no length
+	ConvertedSeconds float64   `json:"converted_seconds"`
+	DurationSeconds  float64   `json:"duration_seconds,omitempty"`
+	SegmentsUploaded int       `json:"segments_uploaded"`
+	SegmentsTotal    int       `json:"segments_total,omitempty"` // known once the conversion is done
+	Percent          float64   `json:"percent"`                  // of the current stage
+	Error            string    `json:"error,omitempty"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Finished reports whether the job completed or failed
+func (p *Progress) Finished() bool {
+	return p.Stage == ProgressCompleted || p.Stage == ProgressFailed
+}
+
+// percent computes the progress of the current stage
+func (p *Progress) percent() float64 {
+	fraction := 0.0
+	switch p.Stage {
+	case ProgressReceiving, ProgressDownloading:
+		if p.BytesTotal > 0 {
+			fraction = float64(p.BytesReceived) / float64(p.BytesTotal)
+		}
+	case ProgressConverting:
+		if p.DurationSeconds > 0 {
+			fraction = p.ConvertedSeconds / p.DurationSeconds
+		}
+	case ProgressUploading:
+		if p.SegmentsTotal > 0 {
+			fraction = float64(p.SegmentsUploaded) / float64(p.SegmentsTotal)
+		}
+	case ProgressCompleted:
+		fraction = 1
+	}
+	return min(100, float64(int(fraction*1000))/10)
+}
+
+// ProgressTracker keeps the progress of running jobs and tells subscribers
+// about every change
+type ProgressTracker struct {
+	mu          sync.Mutex
+	progress    map[string]*Progress
+	subscribers map[string]map[chan Progress]bool
+}
+
+// NewProgressTracker creates an empty progress tracker
+func NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{
+		progress:    make(map[string]*Progress),
+		subscribers: make(map[string]map[chan Progress]bool),
+	}
+}
+
+// Start begins tracking a job, or a retry of a finished one. It returns
+// false while a job with the ID is running. A nil tracker tracks nothing.
+func (t *ProgressTracker) Start(jobID, videoID, stage string) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+	if p, exists := t.progress[jobID]; exists && !p.Finished() {
+		return false
+	}
+	t.progress[jobID] = &Progress{JobID: jobID, VideoID: videoID, Stage: stage, UpdatedAt: time.Now()}
+	return true
+}
+
+// Update applies fn to the progress of a tracked job and sends the result to
+// its subscribers
+func (t *ProgressTracker) Update(jobID string, fn func(p *Progress)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.progress[jobID]
+	if !ok || p.Finished() {
+		return
+	}
+	fn(p)
+	p.Percent = p.percent()
+	p.UpdatedAt = time.Now()
+	for ch := range t.subscribers[jobID] {
+		// Subscribers only need the latest progress: a stale one waiting in
+		// the channel is replaced
+		select {
+		case ch <- *p:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- *p
+		}
+	}
+}
+
+// Stage moves a tracked job to another stage
+func (t *ProgressTracker) Stage(jobID, stage string) {
+	t.Update(jobID, func(p *Progress) { p.Stage = stage })
+}
+
+// Fail ends a tracked job with an error
+func (t *ProgressTracker) Fail(jobID string, err error) {
+	t.Update(jobID, func(p *Progress) {
+		p.Stage = ProgressFailed
+		p.Error = err.Error()
+	})
+}
+
+// Get returns the progress of a job
+func (t *ProgressTracker) Get(jobID string) (Progress, bool) {
+	if t == nil {
+		return Progress{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.progress[jobID]
+	if !ok {
+		return Progress{}, false
+	}
+	return *p, true
+}
+
+// Subscribe returns the progress of a job and a channel receiving every
+// change. Call the returned function to unsubscribe.
+func (t *ProgressTracker) Subscribe(jobID string) (Progress, <-chan Progress, func(), bool) {
+	if t == nil {
+		return Progress{}, nil, nil, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.progress[jobID]
+	if !ok {
+		return Progress{}, nil, nil, false
+	}
+	ch := make(chan Progress, 1)
+	if t.subscribers[jobID] == nil {
+		t.subscribers[jobID] = make(map[chan Progress]bool)
+	}
+	t.subscribers[jobID][ch] = true
+	unsubscribe := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.subscribers[jobID], ch)
+		if len(t.subscribers[jobID]) == 0 {
+			delete(t.subscribers, jobID)
+		}
+	}
+	return *p, ch, unsubscribe, true
+}
+
+// expire drops finished jobs past their retention. Callers hold t.mu.
+func (t *ProgressTracker) expire() {
+	for jobID, p := range t.progress {
+		if p.Finished() && time.Since(p.UpdatedAt) > progressRetention {
+			delete(t.progress, jobID)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package lease
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTime is the timestamp format of Lease objects
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// KubernetesLock is a coordination.k8s.io/v1 Lease, taken and renewed with
+// the pod's service account. Concurrent updates are refused by the API
+// server through the lease's resource version.
+type KubernetesLock struct {
+	client    *http.Client
+	server    string
+	namespace string
+	name      string
+}
+
+// kubernetesLease is the part of a Lease object the lock uses
+type kubernetesLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+// NewKubernetesLock returns the Lease name in namespace, accessed with the
+// in-cluster configuration. An empty namespace is the pod's own.
+func NewKubernetesLock(namespace, name string) (*KubernetesLock, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes pod")
+	}
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid cluster CA")
+	}
+
+	return &KubernetesLock{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		name:      name,
+	}, nil
+}
+
+// String describes the lease
+func (l *KubernetesLock) String() string {
+	return fmt.Sprintf("lease %s/%s", l.namespace, l.name)
+}
+
+// TryAcquire creates the lease, renews it when identity holds it, or takes
+// it over once its holder stopped renewing it
+func (l *KubernetesLock) TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	var lease kubernetesLease
+	status, err := l.do(ctx, http.MethodGet, l.name, nil, &lease)
+	if err != nil {
+		return false, err
+	}
+
+	if status == http.StatusNotFound {
+		lease.APIVersion = "coordination.k8s.io/v1"
+		lease.Kind = "Lease"
+		lease.Metadata.Name = l.name
+		lease.Metadata.Namespace = l.namespace
+		lease.Spec.HolderIdentity = identity
+		lease.Spec.LeaseDurationSeconds = int(ttl.Seconds())
+		lease.Spec.AcquireTime = now.UTC().Format(microTime)
+		lease.Spec.RenewTime = lease.Spec.AcquireTime
+		status, err = l.do(ctx, http.MethodPost, "", &lease, nil)
+		if err != nil || status == http.StatusConflict {
+			return false, err
+		}
+		return l.check(status)
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %d reading %s", status, l)
+	}
+
+	if lease.Spec.HolderIdentity != identity {
+		renewed, _ := time.Parse(microTime, lease.Spec.RenewTime)
+		expiry := renewed.Add(time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second)
+		if lease.Spec.HolderIdentity != "" && now.Before(expiry) {
+			return false, nil
+		}
+		lease.Spec.HolderIdentity = identity
+		lease.Spec.AcquireTime = now.UTC().Format(microTime)
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(ttl.Seconds())
+	lease.Spec.RenewTime = now.UTC().Format(microTime)
+
+	// The resource version makes the update fail if another replica got there
+	// first
+	status, err = l.do(ctx, http.MethodPut, l.name, &lease, nil)
+	if err != nil || status == http.StatusConflict {
+		return false, err
+	}
+	return l.check(status)
+}
+
+// check turns the status of a write into its outcome
+func (l *KubernetesLock) check(status int) (bool, error) {
+	if status == http.StatusOK || status == http.StatusCreated {
+		return true, nil
+	}
+	return false, fmt.Errorf("unexpected status %d writing %s", status, l)
+}
+
+// do sends a request for the lease collection, or the lease name, and
+// decodes the response into out on success
+func (l *KubernetesLock) do(ctx context.Context, method, name string, in, out interface{}) (int, error) {
+	url := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.server, l.namespace)
+	if name != "" {
+		url += "/" + name
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return 0, err
+	}
+	// Projected service account tokens rotate, so the token is read each time
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return 0, fmt.Errorf("invalid lease: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
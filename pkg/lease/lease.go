@@ -0,0 +1,126 @@
+package lease
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"live-video/pkg/background"
+)
+
+// Lock is a lease on a lock shared by the fleet, held by one identity at a
+// time
+type Lock interface {
+	// TryAcquire takes or renews the lease for identity for ttl and reports
+	// whether identity holds it
+	TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error)
+}
+
+// Elector keeps trying to hold a lock and runs tasks while it does. Without
+// a lock the replica is alone and always the leader.
+type Elector struct {
+	lock     Lock
+	identity string
+	ttl      time.Duration
+	group    *background.Group // runs the renewals and tasks
+
+	mu          sync.Mutex
+	leaderUntil time.Time
+	term        context.Context // ends when leadership is lost
+	endTerm     context.CancelFunc
+}
+
+// NewElector creates an elector for identity. ttl is how long the lease
+// lasts without renewal, so how long the fleet can be without a leader.
+func NewElector(lock Lock, identity string, ttl time.Duration) *Elector {
+	return &Elector{lock: lock, identity: identity, ttl: ttl}
+}
+
+// Identity returns the name the replica holds the lease under: POD_NAME when
+// set, else the host name
+func Identity() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// Start tries to take the lease once, so IsLeader is known when it returns,
+// and then renews it in group every third of the TTL. Tasks run in group
+// too, so start the elector before adding any.
+func (e *Elector) Start(group *background.Group) {
+	e.group = group
+	if e.lock == nil {
+		return
+	}
+	e.renew()
+	group.Every(e.ttl/3, func(context.Context) {
+		e.renew()
+	})
+}
+
+// IsLeader reports whether the replica holds the lease
+func (e *Elector) IsLeader() bool {
+	_, ok := e.Term()
+	return ok
+}
+
+// Term returns a context that ends when the replica loses the lease, if it
+// holds it
+func (e *Elector) Term() (context.Context, bool) {
+	if e.lock == nil {
+		return context.Background(), true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.term == nil || !time.Now().Before(e.leaderUntil) {
+		return nil, false
+	}
+	return e.term, true
+}
+
+// Every runs task every interval on the leader, first after one interval.
+// Its context ends when leadership is lost or the elector's group stops.
+func (e *Elector) Every(name string, interval time.Duration, task func(ctx context.Context)) {
+	e.group.Every(interval, func(groupCtx context.Context) {
+		term, ok := e.Term()
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithCancel(term)
+		defer cancel()
+		defer context.AfterFunc(groupCtx, cancel)()
+		task(ctx)
+	})
+}
+
+// renew takes or renews the lease. Leadership is given up at two thirds of
+// the TTL after the last renewal, before another replica may take over.
+func (e *Elector) renew() {
+	attempt := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl/3)
+	held, err := e.lock.TryAcquire(ctx, e.identity, e.ttl)
+	cancel()
+	if err != nil {
+		log.Printf("[Lease] Failed to renew lease: %v", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if held {
+		if e.term == nil {
+			e.term, e.endTerm = context.WithCancel(context.Background())
+			log.Printf("[Lease] %s is now the leader", e.identity)
+		}
+		e.leaderUntil = attempt.Add(e.ttl * 2 / 3)
+		return
+	}
+	if e.term != nil && (err == nil || !time.Now().Before(e.leaderUntil)) {
+		e.endTerm()
+		e.term, e.endTerm = nil, nil
+		log.Printf("[Lease] %s is no longer the leader", e.identity)
+	}
+}
@@ -0,0 +1,233 @@
+// Package m3u8 parses and writes HLS playlists: master playlists listing
+// variants and renditions, and media playlists listing segments. Tags it
+// doesn't know are kept where they were, so a playlist read and written
+// again only changes where it was edited.
+package m3u8
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tag names used by the service
+const (
+	TagVersion               = "EXT-X-VERSION"
+	TagTargetDuration        = "EXT-X-TARGETDURATION"
+	TagMediaSequence         = "EXT-X-MEDIA-SEQUENCE"
+	TagDiscontinuitySequence = "EXT-X-DISCONTINUITY-SEQUENCE"
+	TagPlaylistType          = "EXT-X-PLAYLIST-TYPE"
+	TagIndependentSegments   = "EXT-X-INDEPENDENT-SEGMENTS"
+	TagStart                 = "EXT-X-START"
+	TagEndList               = "EXT-X-ENDLIST"
+	TagInf                   = "EXTINF"
+	TagDiscontinuity         = "EXT-X-DISCONTINUITY"
+	TagMap                   = "EXT-X-MAP"
+	TagKey                   = "EXT-X-KEY"
+	TagProgramDateTime       = "EXT-X-PROGRAM-DATE-TIME"
+	TagMedia                 = "EXT-X-MEDIA"
+	TagStreamInf             = "EXT-X-STREAM-INF"
+	TagIFrameStreamInf       = "EXT-X-I-FRAME-STREAM-INF"
+	TagIFramesOnly           = "EXT-X-I-FRAMES-ONLY"
+	TagByteRange             = "EXT-X-BYTERANGE"
+)
+
+// Playlist types
+const (
+	TypeVOD   = "VOD"
+	TypeEvent = "EVENT"
+)
+
+// ErrNotPlaylist is returned for data that doesn't start with #EXTM3U
+var ErrNotPlaylist = errors.New("not an m3u8 playlist")
+
+// headerTags are the tags of a media playlist as a whole, next to the
+// sequence numbers and EXT-X-ENDLIST. Any other tag belongs to the segment
+// after it.
+var headerTags = map[string]bool{
+	TagVersion:             true,
+	TagTargetDuration:      true,
+	TagPlaylistType:        true,
+	TagIndependentSegments: true,
+	TagStart:               true,
+	TagIFramesOnly:         true,
+	"EXT-X-ALLOW-CACHE":    true,
+	"EXT-X-SERVER-CONTROL": true,
+	"EXT-X-PART-INF":       true,
+	"EXT-X-DEFINE":         true,
+}
+
+// Tag is a tag line: #NAME or #NAME:VALUE. Comments are tags whose name is
+// the comment text.
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// ParseTag parses a line starting with #
+func ParseTag(line string) Tag {
+	name, value, _ := strings.Cut(strings.TrimPrefix(line, "#"), ":")
+	return Tag{Name: name, Value: value}
+}
+
+// NewTag creates a tag whose value is an attribute list
+func NewTag(name string, attrs Attributes) Tag {
+	return Tag{Name: name, Value: attrs.String()}
+}
+
+// String returns the tag line
+func (t Tag) String() string {
+	if t.Value == "" {
+		return "#" + t.Name
+	}
+	return "#" + t.Name + ":" + t.Value
+}
+
+// Attributes parses the value of the tag as an attribute list
+func (t Tag) Attributes() Attributes {
+	return ParseAttributes(t.Value)
+}
+
+// URI returns the URI attribute of the tag, e.g. of EXT-X-MAP or EXT-X-MEDIA
+func (t Tag) URI() string {
+	uri, _ := t.Attributes().Get("URI")
+	return uri
+}
+
+// withURI returns the tag with its URI attribute rewritten by fn
+func (t Tag) withURI(fn func(uri string) string) Tag {
+	attrs := t.Attributes()
+	if uri, ok := attrs.Get("URI"); ok {
+		attrs.Set("URI", Quote(fn(uri)))
+		t.Value = attrs.String()
+	}
+	return t
+}
+
+// Attribute is a KEY=VALUE entry of an attribute list. Quoted string values
+// keep their quotes.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Attributes is an attribute list, in order
+type Attributes []Attribute
+
+// ParseAttributes parses an attribute list such as
+// BANDWIDTH=800000,CODECS="avc1.64001f,mp4a.40.2". A quoted string missing
+// its closing quote runs to the end of the list, and parsing stops at an
+// entry without =.
+func ParseAttributes(s string) Attributes {
+	var attrs Attributes
+	for s != "" {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			if end := strings.Index(rest[1:], `"`); end >= 0 {
+				value, rest = rest[:end+2], strings.TrimPrefix(rest[end+2:], ",")
+			} else {
+				value, rest = rest, ""
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs = append(attrs, Attribute{Key: strings.TrimSpace(key), Value: value})
+		s = rest
+	}
+	return attrs
+}
+
+// Get returns the value of an attribute without quotes
+func (a Attributes) Get(key string) (string, bool) {
+	for _, attr := range a {
+		if attr.Key == key {
+			return strings.Trim(attr.Value, `"`), true
+		}
+	}
+	return "", false
+}
+
+// Int returns the value of a decimal integer attribute, or -1
+func (a Attributes) Int(key string) int {
+	value, ok := a.Get(key)
+	if !ok {
+		return -1
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// Set sets an attribute to value as written, replacing it or adding it last
+func (a *Attributes) Set(key, value string) {
+	for i, attr := range *a {
+		if attr.Key == key {
+			(*a)[i].Value = value
+			return
+		}
+	}
+	*a = append(*a, Attribute{Key: key, Value: value})
+}
+
+// SetDefault sets an attribute the list doesn't have yet
+func (a *Attributes) SetDefault(key, value string) {
+	if _, ok := a.Get(key); !ok {
+		*a = append(*a, Attribute{Key: key, Value: value})
+	}
+}
+
+// String returns the attribute list as written in a tag
+func (a Attributes) String() string {
+	parts := make([]string, len(a))
+	for i, attr := range a {
+		parts[i] = attr.Key + "=" + attr.Value
+	}
+	return strings.Join(parts, ",")
+}
+
+// Quote quotes a string attribute value
+func Quote(s string) string {
+	return `"` + s + `"`
+}
+
+// IsMaster reports whether a playlist is a master playlist
+func IsMaster(data []byte) bool {
+	return bytes.Contains(data, []byte("#"+TagStreamInf+":"))
+}
+
+// lines returns the non-empty lines of a playlist after #EXTM3U
+func lines(data []byte) ([]string, error) {
+	var result []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	header := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case !header:
+			if !strings.HasPrefix(line, "#EXTM3U") {
+				return nil, ErrNotPlaylist
+			}
+			header = true
+		default:
+			result = append(result, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	if !header {
+		return nil, ErrNotPlaylist
+	}
+	return result, nil
+}
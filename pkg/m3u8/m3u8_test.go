@@ -0,0 +1,52 @@
+package m3u8
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAttributes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want Attributes
+	}{
+		{"empty", "", nil},
+		{"plain", "BANDWIDTH=800000,RESOLUTION=1280x720", Attributes{{"BANDWIDTH", "800000"}, {"RESOLUTION", "1280x720"}}},
+		{"quoted comma", `CODECS="avc1.64001f,mp4a.40.2",BANDWIDTH=1`, Attributes{{"CODECS", `"avc1.64001f,mp4a.40.2"`}, {"BANDWIDTH", "1"}}},
+		{"unterminated quote", `BANDWIDTH=1,CODECS="avc1`, Attributes{{"BANDWIDTH", "1"}, {"CODECS", `"avc1`}}},
+		{"lone quote", `URI="`, Attributes{{"URI", `"`}}},
+		{"empty quoted", `URI="",TYPE=AUDIO`, Attributes{{"URI", `""`}, {"TYPE", "AUDIO"}}},
+		{"empty value", "BANDWIDTH=,TYPE=AUDIO", Attributes{{"BANDWIDTH", ""}, {"TYPE", "AUDIO"}}},
+		{"trailing comma", "BANDWIDTH=1,", Attributes{{"BANDWIDTH", "1"}}},
+		{"missing equals", "BANDWIDTH=1,garbage", Attributes{{"BANDWIDTH", "1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseAttributes(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAttributes(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMasterUnterminatedQuote(t *testing.T) {
+	playlist, err := ParseMaster([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1,CODECS=\"avc1\nv.m3u8\n"))
+	if err != nil {
+		t.Fatalf("ParseMaster: %v", err)
+	}
+	if len(playlist.Variants) != 1 || playlist.Variants[0].URI != "v.m3u8" {
+		t.Fatalf("variants = %+v, want v.m3u8", playlist.Variants)
+	}
+	if bandwidth := playlist.Variants[0].Bandwidth(); bandwidth != 1 {
+		t.Errorf("bandwidth = %d, want 1", bandwidth)
+	}
+}
+
+func TestParseAttributesNeverPanics(t *testing.T) {
+	list := `URI="a,b",BANDWIDTH=1,CODECS="avc1",NAME=""`
+	for i := range len(list) + 1 {
+		ParseAttributes(list[:i])
+		ParseAttributes(list[i:])
+	}
+}
@@ -0,0 +1,114 @@
+package m3u8
+
+import (
+	"strings"
+)
+
+// Variant is an EXT-X-STREAM-INF entry of a master playlist
+type Variant struct {
+	Attributes Attributes
+	URI        string
+}
+
+// Bandwidth returns the BANDWIDTH of the variant, or -1
+func (v *Variant) Bandwidth() int {
+	return v.Attributes.Int("BANDWIDTH")
+}
+
+// MasterPlaylist is a master playlist
+type MasterPlaylist struct {
+	Tags     []Tag // every tag but EXT-X-STREAM-INF, e.g. EXT-X-MEDIA renditions
+	Variants []Variant
+}
+
+// ParseMaster parses a master playlist
+func ParseMaster(data []byte) (*MasterPlaylist, error) {
+	lines, err := lines(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &MasterPlaylist{}
+	var variant *Variant
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			if variant != nil {
+				variant.URI = line
+				p.Variants = append(p.Variants, *variant)
+				variant = nil
+			}
+			continue
+		}
+		tag := ParseTag(line)
+		if tag.Name == TagStreamInf {
+			variant = &Variant{Attributes: tag.Attributes()}
+			continue
+		}
+		p.Tags = append(p.Tags, tag)
+	}
+	return p, nil
+}
+
+// Renditions returns the attributes of the EXT-X-MEDIA renditions
+func (p *MasterPlaylist) Renditions() []Attributes {
+	var renditions []Attributes
+	for _, tag := range p.Tags {
+		if tag.Name == TagMedia {
+			renditions = append(renditions, tag.Attributes())
+		}
+	}
+	return renditions
+}
+
+// URIs returns the URIs of the playlists the master playlist lists:
+// renditions, I-frame playlists and variants
+func (p *MasterPlaylist) URIs() []string {
+	var uris []string
+	for _, tag := range p.Tags {
+		if tag.Name == TagMedia || tag.Name == TagIFrameStreamInf {
+			if uri := tag.URI(); uri != "" {
+				uris = append(uris, uri)
+			}
+		}
+	}
+	for _, variant := range p.Variants {
+		uris = append(uris, variant.URI)
+	}
+	return uris
+}
+
+// MapURIs rewrites the URIs of the playlists the master playlist lists with fn
+func (p *MasterPlaylist) MapURIs(fn func(uri string) string) {
+	for i, tag := range p.Tags {
+		if tag.Name == TagMedia || tag.Name == TagIFrameStreamInf {
+			p.Tags[i] = tag.withURI(fn)
+		}
+	}
+	for i := range p.Variants {
+		p.Variants[i].URI = fn(p.Variants[i].URI)
+	}
+}
+
+// BestVariant returns the variant with the highest bandwidth, or nil
+func (p *MasterPlaylist) BestVariant() *Variant {
+	var best *Variant
+	for i := range p.Variants {
+		if best == nil || p.Variants[i].Bandwidth() > best.Bandwidth() {
+			best = &p.Variants[i]
+		}
+	}
+	return best
+}
+
+// Encode writes the playlist
+func (p *MasterPlaylist) Encode() []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, tag := range p.Tags {
+		b.WriteString(tag.String() + "\n")
+	}
+	for _, variant := range p.Variants {
+		b.WriteString(NewTag(TagStreamInf, variant.Attributes).String() + "\n")
+		b.WriteString(variant.URI + "\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,208 @@
+package m3u8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Segment is a media segment with the tags that apply to it
+type Segment struct {
+	URI      string
+	Duration float64 // seconds, from EXTINF
+	Title    string  // from EXTINF
+	Tags     []Tag   // other tags before the segment, e.g. EXT-X-DISCONTINUITY or EXT-X-MAP
+	Start    float64 // seconds from the start of the playlist, set by ParseMedia
+}
+
+// Has reports whether a tag named name applies to the segment
+func (s *Segment) Has(name string) bool {
+	return s.Tag(name) != nil
+}
+
+// Tag returns the last tag named name before the segment, or nil
+func (s *Segment) Tag(name string) *Tag {
+	for i := len(s.Tags) - 1; i >= 0; i-- {
+		if s.Tags[i].Name == name {
+			return &s.Tags[i]
+		}
+	}
+	return nil
+}
+
+// MediaPlaylist is a media playlist
+type MediaPlaylist struct {
+	Header                []Tag // tags of the playlist as a whole, e.g. EXT-X-VERSION
+	MediaSequence         int
+	DiscontinuitySequence int
+	Segments              []Segment
+	Trailer               []Tag // tags after the last segment
+	Ended                 bool  // EXT-X-ENDLIST
+}
+
+// ParseMedia parses a media playlist
+func ParseMedia(data []byte) (*MediaPlaylist, error) {
+	lines, err := lines(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &MediaPlaylist{}
+	var segment Segment
+	start := 0.0
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			segment.URI = line
+			segment.Start = start
+			start += segment.Duration
+			p.Segments = append(p.Segments, segment)
+			segment = Segment{}
+			continue
+		}
+		tag := ParseTag(line)
+		switch {
+		case tag.Name == TagMediaSequence:
+			p.MediaSequence, _ = strconv.Atoi(tag.Value)
+		case tag.Name == TagDiscontinuitySequence:
+			p.DiscontinuitySequence, _ = strconv.Atoi(tag.Value)
+		case tag.Name == TagEndList:
+			p.Ended = true
+		case headerTags[tag.Name]:
+			p.Header = append(p.Header, tag)
+		case tag.Name == TagInf:
+			duration, title, _ := strings.Cut(tag.Value, ",")
+			segment.Duration, _ = strconv.ParseFloat(strings.TrimSpace(duration), 64)
+			segment.Title = title
+		default:
+			segment.Tags = append(segment.Tags, tag)
+		}
+	}
+	p.Trailer = segment.Tags
+	return p, nil
+}
+
+// HeaderValue returns the value of a playlist tag and whether the playlist has it
+func (p *MediaPlaylist) HeaderValue(name string) (string, bool) {
+	for _, tag := range p.Header {
+		if tag.Name == name {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetHeader sets a playlist tag, replacing it or adding it last
+func (p *MediaPlaylist) SetHeader(name, value string) {
+	for i, tag := range p.Header {
+		if tag.Name == name {
+			p.Header[i].Value = value
+			return
+		}
+	}
+	p.Header = append(p.Header, Tag{Name: name, Value: value})
+}
+
+// RemoveHeader removes a playlist tag
+func (p *MediaPlaylist) RemoveHeader(name string) {
+	header := p.Header[:0]
+	for _, tag := range p.Header {
+		if tag.Name != name {
+			header = append(header, tag)
+		}
+	}
+	p.Header = header
+}
+
+// Duration returns the seconds of media the playlist lists
+func (p *MediaPlaylist) Duration() float64 {
+	if len(p.Segments) == 0 {
+		return 0
+	}
+	last := p.Segments[len(p.Segments)-1]
+	return last.Start + last.Duration
+}
+
+// InitURI returns the URI of the first fMP4 initialization section, or ""
+func (p *MediaPlaylist) InitURI() string {
+	for _, segment := range p.Segments {
+		if tag := segment.Tag(TagMap); tag != nil {
+			return tag.URI()
+		}
+	}
+	return ""
+}
+
+// URIs returns the URIs of the segments and of the initialization sections
+// they use, in playlist order
+func (p *MediaPlaylist) URIs() []string {
+	var uris []string
+	for _, segment := range p.Segments {
+		for _, tag := range segment.Tags {
+			if tag.Name == TagMap {
+				if uri := tag.URI(); uri != "" {
+					uris = append(uris, uri)
+				}
+			}
+		}
+		uris = append(uris, segment.URI)
+	}
+	return uris
+}
+
+// MapURIs rewrites the segment URIs and the URI attributes of EXT-X-MAP and
+// EXT-X-KEY tags with fn
+func (p *MediaPlaylist) MapURIs(fn func(uri string) string) {
+	mapTags := func(tags []Tag) {
+		for i, tag := range tags {
+			if tag.Name == TagMap || tag.Name == TagKey {
+				tags[i] = tag.withURI(fn)
+			}
+		}
+	}
+	for i := range p.Segments {
+		mapTags(p.Segments[i].Tags)
+		p.Segments[i].URI = fn(p.Segments[i].URI)
+	}
+	mapTags(p.Trailer)
+}
+
+// Encode writes the playlist. EXT-X-DISCONTINUITY-SEQUENCE is only written
+// when it isn't 0.
+func (p *MediaPlaylist) Encode() []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, tag := range p.Header {
+		b.WriteString(tag.String() + "\n")
+	}
+	fmt.Fprintf(&b, "#%s:%d\n", TagMediaSequence, p.MediaSequence)
+	if p.DiscontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#%s:%d\n", TagDiscontinuitySequence, p.DiscontinuitySequence)
+	}
+	for _, segment := range p.Segments {
+		for _, tag := range segment.Tags {
+			b.WriteString(tag.String() + "\n")
+		}
+		fmt.Fprintf(&b, "#%s:%s,%s\n%s\n", TagInf, FormatDuration(segment.Duration), segment.Title, segment.URI)
+	}
+	for _, tag := range p.Trailer {
+		b.WriteString(tag.String() + "\n")
+	}
+	if p.Ended {
+		b.WriteString("#" + TagEndList + "\n")
+	}
+	return []byte(b.String())
+}
+
+// FormatDuration formats a segment duration for EXTINF
+func FormatDuration(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// TargetDuration returns the EXT-X-TARGETDURATION of segments: the longest
+// rounded up to a whole second
+func TargetDuration(segments []Segment) int {
+	target := 0
+	for _, segment := range segments {
+		target = max(target, int(segment.Duration+0.999))
+	}
+	return max(target, 1)
+}
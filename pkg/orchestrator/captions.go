@@ -0,0 +1,57 @@
+package orchestrator
+
+import (
+	"context"
+	"path/filepath"
+
+	"live-video/config"
+	"live-video/pkg/captions"
+)
+
+// SetCaptions makes Start transcribe the stream's speech with recognizer and
+// publish a caption track per language heard, plus one per language of
+// languages when translator is set. A nil recognizer turns captions off. It
+// applies on the next Start.
+func (o *StreamOrchestrator) SetCaptions(recognizer captions.Recognizer, translator captions.Translator, languages []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.recognizer = recognizer
+	o.translator = translator
+	o.captionLanguages = languages
+}
+
+// Captions returns the caption settings of the pipeline
+func (o *StreamOrchestrator) Captions() config.CaptionSettings {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return config.CaptionSettings{Enabled: o.recognizer != nil, Languages: o.captionLanguages}
+}
+
+// CaptionTracks returns the caption tracks published by the running
+// pipeline
+func (o *StreamOrchestrator) CaptionTracks() []captions.Track {
+	o.mu.Lock()
+	captioner := o.captioner
+	o.mu.Unlock()
+	if captioner == nil {
+		return nil
+	}
+	return captioner.Tracks()
+}
+
+// startCaptions captions the smallest rendition in dir, whose audio is the
+// same program audio as the others'. Callers hold o.mu.
+func (o *StreamOrchestrator) startCaptions(ctx context.Context, dir string) {
+	o.captioner = captions.New(captions.Options{
+		Recognizer: o.recognizer,
+		Translator: o.translator,
+		Languages:  o.captionLanguages,
+		Publish: func(ctx context.Context, name string, data []byte, contentType string) error {
+			return o.storage.UploadBytes(ctx, data, o.storage.Layout().LivePath(o.streamID, name), contentType)
+		},
+		TargetDuration: o.config.SegmentDuration,
+		PlaylistSize:   o.config.PlaylistSize,
+	})
+	profiles := o.config.Profiles
+	go o.captioner.Run(ctx, filepath.Join(dir, profiles[len(profiles)-1].Name))
+}
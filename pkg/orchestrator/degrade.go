@@ -0,0 +1,188 @@
+package orchestrator
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/events"
+	"live-video/pkg/transcoder"
+)
+
+// Degradation levels, each including the ones before
+const (
+	degradeFasterPreset  = 1 // every rung encodes one preset faster
+	degradeDropTop       = 2 // the top rung is dropped
+	degradeFastestPreset = 3 // every rung encodes at ultrafast
+	degradePaused        = 4 // the transcoder is stopped until load subsides
+)
+
+// DegradeAction is a change the pipeline made to its encoding under load
+type DegradeAction struct {
+	At     time.Time `json:"at"`
+	Level  int       `json:"level"`
+	Action string    `json:"action"`
+	Reason string    `json:"reason"`
+}
+
+// maxDegradeActions bounds the actions kept for stats
+const maxDegradeActions = 10
+
+// SetLoadGovernor makes the pipeline degrade its encoding under load as g
+// decides. It applies on the next Start.
+func (o *StreamOrchestrator) SetLoadGovernor(g *LoadGovernor) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.governor = g
+}
+
+// loadState is what the load governor decides on for a pipeline
+type loadState struct {
+	priority string
+	level    int
+	speed    float64 // 0 while unknown or paused
+	canStep  bool    // degradable now: restartable and past degradeHold
+}
+
+// loadState returns the pipeline's state for the load governor
+func (o *StreamOrchestrator) loadState() loadState {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	state := loadState{
+		priority: o.priority,
+		level:    o.degradeLevel,
+		canStep:  o.running && o.restart != nil && (o.lastDegradeAt == nil || time.Since(*o.lastDegradeAt) >= degradeHold),
+	}
+	if state.priority == "" {
+		state.priority = PriorityStandard
+	}
+	if speed, at := o.transcoder.Speed(); !o.paused && time.Since(at) < 2*governInterval {
+		state.speed = speed
+	}
+	return state
+}
+
+// degrade moves the pipeline step levels up or down and restarts its
+// transcoder with the ladder of the new level; the restart continues the
+// media sequence after a discontinuity, like after a crash. The paused level
+// stops the transcoder until the pipeline is restored. force skips
+// degradeHold. It reports whether the pipeline moved.
+func (o *StreamOrchestrator) degrade(step int, reason string, force bool) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	level := o.degradeLevel + step
+	if !o.running || o.restart == nil || level < 0 || level > degradePaused || step == 0 {
+		return false
+	}
+	if !force && o.lastDegradeAt != nil && time.Since(*o.lastDegradeAt) < degradeHold {
+		return false
+	}
+
+	ladder := o.ladderConfig()
+	if o.degradeLevel == 0 {
+		o.baseLadder = append([]config.TranscodeProfile(nil), ladder.Profiles...)
+	}
+	ladder.Profiles = degradedLadder(o.baseLadder, level)
+	previous := o.degradeLevel
+	o.degradeLevel = level
+	if level == 0 {
+		o.baseLadder = nil
+	}
+
+	now := time.Now()
+	o.lastDegradeAt = &now
+	action := DegradeAction{At: now, Level: level, Action: degradeActions[level], Reason: reason}
+	o.degradeActions = append(o.degradeActions, action)
+	if len(o.degradeActions) > maxDegradeActions {
+		o.degradeActions = o.degradeActions[1:]
+	}
+	o.governor.report(o.streamID, level)
+	log.Printf("[Orchestrator] Encoding of %s moved to level %d: %s (%s)", o.streamID, level, action.Action, reason)
+	o.events.Publish(events.PipelineDegraded, map[string]any{
+		"stream_id": o.streamID,
+		"level":     level,
+		"action":    action.Action,
+		"reason":    reason,
+	})
+
+	switch {
+	case level == degradePaused:
+		// The supervisor waits for unpause instead of restarting
+		o.paused = true
+		o.unpause = make(chan struct{})
+		if err := o.transcoder.Stop(); err != nil {
+			log.Printf("[Orchestrator] Error stopping transcoder: %v", err)
+		}
+	case previous == degradePaused:
+		o.paused = false
+		o.reconfiguring = true
+		close(o.unpause)
+	default:
+		// The supervisor starts the transcoder again at once
+		o.reconfiguring = true
+		if err := o.transcoder.Stop(); err != nil {
+			log.Printf("[Orchestrator] Error stopping transcoder: %v", err)
+		}
+	}
+	return true
+}
+
+// degradeActions describes what moving to each level does
+var degradeActions = []string{
+	0:                    "ladder restored",
+	degradeFasterPreset:  "presets one step faster",
+	degradeDropTop:       "top rung dropped",
+	degradeFastestPreset: "presets at ultrafast",
+	degradePaused:        "encoding paused",
+}
+
+// degradedLadder returns the profiles of base at a degradation level
+func degradedLadder(base []config.TranscodeProfile, level int) []config.TranscodeProfile {
+	profiles := append([]config.TranscodeProfile(nil), base...)
+	sortProfiles(profiles)
+	if level >= degradeDropTop && len(profiles) > 1 {
+		profiles = profiles[1:]
+	}
+	steps := 0
+	switch {
+	case level >= degradeFastestPreset:
+		steps = -1
+	case level >= degradeFasterPreset:
+		steps = 1
+	}
+	if steps != 0 {
+		for i := range profiles {
+			profiles[i].Preset = transcoder.FasterPreset(profiles[i].Preset, steps)
+		}
+	}
+	return profiles
+}
+
+// EncodingSnapshot is the load degradation state of a pipeline
+type EncodingSnapshot struct {
+	Priority string          `json:"priority"`
+	Level    int             `json:"level"`
+	Paused   bool            `json:"paused"`
+	Speed    float64         `json:"speed,omitempty"` // transcoder speed, x real time
+	Actions  []DegradeAction `json:"actions,omitempty"`
+}
+
+// encodingSnapshot summarizes load degradation for the pipeline's snapshot.
+// Callers hold o.mu.
+func (o *StreamOrchestrator) encodingSnapshot() *EncodingSnapshot {
+	snap := &EncodingSnapshot{
+		Priority: o.priority,
+		Level:    o.degradeLevel,
+		Paused:   o.paused,
+		Actions:  slices.Clone(o.degradeActions),
+	}
+	if snap.Priority == "" {
+		snap.Priority = PriorityStandard
+	}
+	if speed, at := o.transcoder.Speed(); !at.IsZero() && !o.paused {
+		snap.Speed = speed
+	}
+	return snap
+}
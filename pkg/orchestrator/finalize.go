@@ -0,0 +1,77 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/integrity"
+	"live-video/pkg/vod"
+)
+
+// finalizeTimeout bounds ending the playlists of a stopped pipeline
+const finalizeTimeout = 20 * time.Second
+
+// finalizePlaylists ends the media playlists in dir with EXT-X-ENDLIST once
+// the pipeline stopped, locally and in the bucket, and signs them again, so
+// players play out the last segments and stop instead of polling a playlist
+// that no longer changes. Callers hold o.mu.
+func (o *StreamOrchestrator) finalizePlaylists(dir string) {
+	if o.storage == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), finalizeTimeout)
+	defer cancel()
+
+	var names []string
+	for _, rendition := range o.renditionNames() {
+		names = append(names, path.Join(rendition, vod.PlaylistName))
+	}
+	for _, input := range o.config.AudioInputs {
+		if input.Mode == config.AudioModeAlternate {
+			names = append(names, path.Join(input.Rendition(), vod.PlaylistName))
+		}
+	}
+
+	ended := 0
+	for _, name := range names {
+		local := filepath.Join(dir, filepath.FromSlash(name))
+		data, err := os.ReadFile(local)
+		if err != nil {
+			continue
+		}
+		data, ok := vod.EndPlaylist(vod.MarkDiscontinuities(data, o.discontinuities))
+		if !ok {
+			continue
+		}
+		if err := os.WriteFile(local, data, 0o644); err != nil {
+			log.Printf("[Orchestrator] Failed to end %s of %s: %v", name, o.streamID, err)
+		}
+
+		gcsPath := o.storage.Layout().LivePath(o.streamID, name)
+		if err := o.storage.UploadBytes(ctx, data, gcsPath, "application/vnd.apple.mpegurl"); err != nil {
+			log.Printf("[Orchestrator] Failed to upload ended %s of %s: %v", name, o.streamID, err)
+			continue
+		}
+		ended++
+		if o.signer == nil {
+			continue
+		}
+		manifest, err := o.signer.Sign(gcsPath, data, filepath.Dir(local))
+		if err == nil {
+			signature, _ := json.Marshal(manifest)
+			err = o.storage.UploadBytes(ctx, signature, gcsPath+integrity.SignatureSuffix, "application/json")
+		}
+		if err != nil {
+			log.Printf("[Orchestrator] Failed to sign ended %s of %s: %v", name, o.streamID, err)
+		}
+	}
+	if ended > 0 {
+		log.Printf("[Orchestrator] Ended %d playlists of %s", ended, o.streamID)
+	}
+}
@@ -0,0 +1,548 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/events"
+	"live-video/pkg/transcoder"
+	"live-video/pkg/upstream"
+)
+
+// Input kinds
+const (
+	InputWebRTC = "webrtc"
+	InputRTMP   = "rtmp"
+	InputSRT    = "srt"
+	InputRTSP   = "rtsp"
+	InputRemote = "remote" // an HLS playlist or MP4 file over HTTP(S)
+)
+
+// Input is a source a stream can be fed from. The pipeline plays the input
+// of lowest priority that delivers video and fails over to the next one.
+type Input struct {
+	Kind     string `json:"kind"`
+	URL      string `json:"url,omitempty"` // RTMP, SRT, RTSP or remote URL to pull; WebRTC input is the stream's own ingest
+	Priority int    `json:"priority"`
+}
+
+// Redacted returns the input without the password of its URL or the
+// passphrase of an SRT URL, which statuses and snapshots don't show
+func (i Input) Redacted() Input {
+	i.URL = transcoder.RedactSource(i.URL)
+	return i
+}
+
+// RedactInputs returns inputs without the credentials in their URLs, e.g.
+// to log them
+func RedactInputs(inputs []Input) []Input {
+	redacted := make([]Input, len(inputs))
+	for i, input := range inputs {
+		redacted[i] = input.Redacted()
+	}
+	return redacted
+}
+
+// InputStatus is an input of a running pipeline
+type InputStatus struct {
+	Input
+	Active      bool          `json:"active"`
+	Available   bool          `json:"available"` // WebRTC input once a broadcaster connected, remote input while its checks pass
+	LastFrameAt *time.Time    `json:"last_frame_at,omitempty"`
+	Connection  *Connection   `json:"connection,omitempty"` // of inputs the pipeline pulls
+	Health      *SourceHealth `json:"health,omitempty"`     // last check of a remote input
+}
+
+// Connection states of a pulled input
+const (
+	ConnectionConnected    = "connected"
+	ConnectionConnecting   = "connecting"   // no video yet, or again after a failure
+	ConnectionDisconnected = "disconnected" // lost, retried within the failover window
+	ConnectionStandby      = "standby"      // not pulled while another input plays
+)
+
+// Connection is the connection of the pipeline to an input it pulls, e.g.
+// an RTSP camera
+type Connection struct {
+	State       string     `json:"state"`
+	Since       *time.Time `json:"since,omitempty"` // connected since
+	Failures    int        `json:"failures"`        // connections lost or refused
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// ValidateInputs checks the inputs of a stream and returns them ordered by
+// priority. The RTMP, SRT and remote sources the server would pull must be
+// allowed by policy; RTSP cameras usually sit on private networks and aren't
+// restricted.
+func ValidateInputs(ctx context.Context, inputs []Input, policy upstream.Policy) ([]Input, error) {
+	sorted := append([]Input(nil), inputs...)
+	seen := make(map[string]bool)
+	for _, input := range sorted {
+		switch input.Kind {
+		case InputWebRTC:
+			if input.URL != "" {
+				return nil, fmt.Errorf("webrtc input takes no url")
+			}
+		case InputRTMP:
+			if !strings.HasPrefix(input.URL, "rtmp://") && !strings.HasPrefix(input.URL, "rtmps://") {
+				return nil, fmt.Errorf("rtmp input needs an rtmp:// or rtmps:// url")
+			}
+		case InputSRT:
+			if !strings.HasPrefix(input.URL, "srt://") {
+				return nil, fmt.Errorf("srt input needs an srt:// url")
+			}
+		case InputRTSP:
+			if !strings.HasPrefix(input.URL, "rtsp://") && !strings.HasPrefix(input.URL, "rtsps://") {
+				return nil, fmt.Errorf("rtsp input needs an rtsp:// or rtsps:// url")
+			}
+		case InputRemote:
+			if !isRemote(input.URL) {
+				return nil, fmt.Errorf("remote input needs an http:// or https:// url")
+			}
+			if u, err := url.Parse(input.URL); err != nil || u.Host == "" || u.User != nil {
+				return nil, fmt.Errorf("remote input needs a url with a host and no credentials")
+			}
+		default:
+			return nil, fmt.Errorf("unknown input kind %q", input.Kind)
+		}
+		if pulledByURL(input) {
+			if err := policy.Check(ctx, input.URL); err != nil {
+				return nil, fmt.Errorf("%s input not allowed: %w", input.Kind, err)
+			}
+		}
+		key := input.Kind + " " + input.URL
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate %s input", input.Kind)
+		}
+		seen[key] = true
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+	return sorted, nil
+}
+
+// pulledByURL reports whether the server connects to the URL of an input,
+// rather than listening for the encoder like SRT in listener mode
+func pulledByURL(input Input) bool {
+	switch input.Kind {
+	case InputRTMP, InputRemote:
+		return true
+	case InputSRT:
+		u, err := url.Parse(input.URL)
+		return err != nil || u.Query().Get("mode") != "listener"
+	}
+	return false
+}
+
+// HasPullInput reports whether inputs include one the pipeline pulls itself,
+// so it can run without a WebRTC broadcaster
+func HasPullInput(inputs []Input) bool {
+	for _, input := range inputs {
+		if input.Kind != InputWebRTC {
+			return true
+		}
+	}
+	return false
+}
+
+// failover plays the inputs of a stream on a feed transcoder and switches
+// to the next input when the active one delivers nothing for window
+type failover struct {
+	streamID string
+	feed     *transcoder.Feed
+	window   time.Duration
+	events   *events.Bus
+
+	mu           sync.Mutex
+	inputs       []Input // by priority
+	webrtcPath   string  // IVF file of the WebRTC ingest, once connected
+	active       int     // index into inputs, -1 for none
+	activeSince  time.Time
+	started      bool // an input was played
+	switches     int
+	lastSwitchAt *time.Time
+	followers    map[string]*transcoder.Feed // feeds of added renditions, switched along
+	remote       map[string]*remoteSource    // remote inputs by URL
+	policy       upstream.Policy             // addresses remote inputs may be checked on
+}
+
+// SetSourcePolicy lets remote inputs be checked on the addresses policy
+// allows, by default public ones only
+func (o *StreamOrchestrator) SetSourcePolicy(policy upstream.Policy) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sourcePolicy = policy
+}
+
+// SetInputs makes the pipeline play inputs, switching to the next one when
+// the active input delivers no video for window. A running pipeline with
+// inputs takes the new list at once; otherwise it applies on the next Start.
+func (o *StreamOrchestrator) SetInputs(inputs []Input, window time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.inputs = inputs
+	o.failoverWindow = window
+	if o.failover != nil {
+		o.failover.setInputs(inputs)
+	}
+}
+
+// AttachWebRTC makes the WebRTC ingest writing the IVF file at path
+// available to a running pipeline with inputs. It reports false when the
+// pipeline does not switch inputs.
+func (o *StreamOrchestrator) AttachWebRTC(path string) bool {
+	o.mu.Lock()
+	f := o.failover
+	o.mu.Unlock()
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	f.webrtcPath = path
+	f.mu.Unlock()
+	return true
+}
+
+// Inputs returns the state of the pipeline's inputs
+func (o *StreamOrchestrator) Inputs() []InputStatus {
+	o.mu.Lock()
+	f := o.failover
+	o.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	return f.status()
+}
+
+// startFailover plays the first available input on feed and watches it until
+// ctx is done. Callers hold o.mu.
+func (o *StreamOrchestrator) startFailover(ctx context.Context, feed *transcoder.Feed, webrtcPath string) {
+	window := o.failoverWindow
+	if window <= 0 {
+		window = DefaultFailoverWindow
+	}
+	f := &failover{
+		streamID:   o.streamID,
+		feed:       feed,
+		window:     window,
+		events:     o.events,
+		inputs:     o.inputs,
+		webrtcPath: webrtcPath,
+		policy:     o.sourcePolicy,
+		active:     -1,
+	}
+	o.failover = f
+
+	f.mu.Lock()
+	f.switchNext("starting")
+	f.mu.Unlock()
+	go f.watch(ctx)
+	go f.watchRemotes(ctx)
+}
+
+// DefaultFailoverWindow is how long an input may deliver nothing before the
+// pipeline switches to the next one, unless set per stream
+const DefaultFailoverWindow = 5 * time.Second
+
+func (f *failover) watch(ctx context.Context) {
+	interval := f.window / 4
+	if interval < 250*time.Millisecond {
+		interval = 250 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.check()
+		}
+	}
+}
+
+// check fails over from a stalled input and back to a WebRTC input of
+// higher priority that delivers again
+func (f *failover) check() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.active < 0 {
+		f.switchNext("no input")
+		return
+	}
+	for i := 0; i < f.active; i++ {
+		if f.inputs[i].Kind == InputWebRTC && f.webrtcLive() {
+			f.switchTo(i, "higher priority input is back")
+			return
+		}
+	}
+
+	_, lastFrame := f.feed.Source()
+	last := f.activeSince
+	if lastFrame.After(last) {
+		last = lastFrame
+	}
+	if time.Since(last) >= f.window {
+		f.switchNext(fmt.Sprintf("no video for %s", f.window))
+	}
+}
+
+// switchNext switches to the next available input after the active one,
+// wrapping around. Callers hold f.mu.
+func (f *failover) switchNext(reason string) {
+	for step := 1; step <= len(f.inputs); step++ {
+		i := (f.active + step) % len(f.inputs)
+		if f.active < 0 {
+			i = step - 1
+		}
+		if f.source(f.inputs[i]) != "" {
+			if i != f.active {
+				f.switchTo(i, reason)
+				return
+			}
+			// The only available input gets another window, and is
+			// reconnected if the feed gave up on it
+			for _, feed := range append([]*transcoder.Feed{f.feed}, f.followerFeeds()...) {
+				if current, _ := feed.Source(); current == "" {
+					feed.SwitchLive(f.source(f.inputs[i]))
+				}
+			}
+			f.activeSince = time.Now()
+			return
+		}
+	}
+}
+
+// switchTo plays input i. Callers hold f.mu.
+func (f *failover) switchTo(i int, reason string) {
+	from := "none"
+	if f.active >= 0 {
+		from = f.inputs[f.active].Kind
+	}
+	// A WebRTC file switched back to continues where it is written now
+	if !f.started {
+		f.feed.Switch(f.source(f.inputs[i]))
+		f.started = true
+	} else {
+		f.feed.SwitchLive(f.source(f.inputs[i]))
+		f.switches++
+		now := time.Now()
+		f.lastSwitchAt = &now
+	}
+	for _, follower := range f.followers {
+		follower.SwitchLive(f.source(f.inputs[i]))
+	}
+	f.active = i
+	f.activeSince = time.Now()
+	log.Printf("[Orchestrator] Input of %s switched from %s to %s (%s)", f.streamID, from, f.inputs[i].Kind, reason)
+	f.events.Publish(events.PipelineInputSwitched, map[string]any{
+		"stream_id": f.streamID,
+		"from":      from,
+		"to":        f.inputs[i].Kind,
+		"reason":    reason,
+	})
+}
+
+// source returns what the feed plays for input, "" while unavailable: a
+// WebRTC input until a broadcaster connected, a remote input unless its last
+// check passed. Callers hold f.mu.
+func (f *failover) source(input Input) string {
+	switch input.Kind {
+	case InputWebRTC:
+		return f.webrtcPath
+	case InputRemote:
+		if health := f.remoteHealth(input); health == nil || !health.Healthy {
+			return ""
+		}
+	}
+	return input.URL
+}
+
+// webrtcLive reports whether the WebRTC ingest wrote video within the
+// window. Callers hold f.mu.
+func (f *failover) webrtcLive() bool {
+	if f.webrtcPath == "" {
+		return false
+	}
+	info, err := os.Stat(f.webrtcPath)
+	return err == nil && time.Since(info.ModTime()) < f.window
+}
+
+// setInputs replaces the inputs, keeping the active one if it is still
+// listed
+func (f *failover) setInputs(inputs []Input) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var active *Input
+	if f.active >= 0 {
+		active = &f.inputs[f.active]
+	}
+	f.inputs = inputs
+	f.active = -1
+	for i, input := range inputs {
+		if active != nil && input == *active {
+			f.active = i
+		}
+	}
+	if f.active < 0 {
+		f.switchNext("inputs changed")
+	}
+}
+
+// setFeed makes the inputs play on feed, e.g. after the transcoder
+// restarted, continuing with the active input at its live edge
+func (f *failover) setFeed(feed *transcoder.Feed) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.feed = feed
+	if f.active >= 0 {
+		feed.SwitchLive(f.source(f.inputs[f.active]))
+	}
+	f.activeSince = time.Now()
+}
+
+// activeSource returns what the active input plays from, "" for none
+func (f *failover) activeSource() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active < 0 {
+		return ""
+	}
+	return f.source(f.inputs[f.active])
+}
+
+// follow makes feed play the active input and switch along with it
+func (f *failover) follow(name string, feed *transcoder.Feed) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.followers == nil {
+		f.followers = make(map[string]*transcoder.Feed)
+	}
+	f.followers[name] = feed
+	if f.active >= 0 {
+		feed.SwitchLive(f.source(f.inputs[f.active]))
+	}
+}
+
+// unfollow stops switching the feed named name
+func (f *failover) unfollow(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.followers, name)
+}
+
+// followerFeeds returns the feeds switched along. Callers hold f.mu.
+func (f *failover) followerFeeds() []*transcoder.Feed {
+	feeds := make([]*transcoder.Feed, 0, len(f.followers))
+	for _, feed := range f.followers {
+		feeds = append(feeds, feed)
+	}
+	return feeds
+}
+
+func (f *failover) status() []InputStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current, lastFrame := f.feed.Source()
+	statuses := make([]InputStatus, 0, len(f.inputs))
+	for i, input := range f.inputs {
+		status := InputStatus{
+			Input:     input.Redacted(),
+			Active:    i == f.active,
+			Available: f.source(input) != "",
+		}
+		if status.Active && !lastFrame.IsZero() {
+			last := lastFrame
+			status.LastFrameAt = &last
+		}
+		if input.Kind != InputWebRTC {
+			status.Connection = f.connection(input, status.Active, current)
+		}
+		if input.Kind == InputRemote {
+			status.Health = f.remoteHealth(input)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// connection describes the connection to a pulled input, given what the feed
+// plays now. Callers hold f.mu.
+func (f *failover) connection(input Input, active bool, current string) *Connection {
+	pull := f.feed.Pull(input.URL)
+	conn := &Connection{
+		State:    ConnectionStandby,
+		Failures: pull.Failures,
+	}
+	switch {
+	case !active:
+	case pull.Connected:
+		conn.State = ConnectionConnected
+		since := pull.Since
+		conn.Since = &since
+	case current == input.URL:
+		conn.State = ConnectionConnecting
+	default:
+		conn.State = ConnectionDisconnected
+	}
+	if pull.Failures > 0 {
+		conn.LastError = pull.LastError
+		at := pull.LastErrorAt
+		conn.LastErrorAt = &at
+	}
+	return conn
+}
+
+// FailoverSnapshot is the failover state of a pipeline
+type FailoverSnapshot struct {
+	Active        string        `json:"active"` // kind of the active input
+	Switches      int           `json:"switches"`
+	WindowSeconds float64       `json:"window_seconds"`
+	LastSwitchAt  *time.Time    `json:"last_switch_at,omitempty"`
+	Inputs        []InputStatus `json:"inputs"`
+}
+
+// snapshot summarizes failover for the pipeline's snapshot
+func (f *failover) snapshot() *FailoverSnapshot {
+	f.mu.Lock()
+	snap := &FailoverSnapshot{
+		Switches:      f.switches,
+		WindowSeconds: f.window.Seconds(),
+	}
+	if f.active >= 0 {
+		snap.Active = f.inputs[f.active].Kind
+	}
+	if f.lastSwitchAt != nil {
+		at := *f.lastSwitchAt
+		snap.LastSwitchAt = &at
+	}
+	f.mu.Unlock()
+	snap.Inputs = f.status()
+	return snap
+}
+
+// LastFrameAt returns when the pipeline's inputs last delivered video, zero
+// for a pipeline without inputs
+func (o *StreamOrchestrator) LastFrameAt() time.Time {
+	o.mu.Lock()
+	f := o.failover
+	o.mu.Unlock()
+	if f == nil {
+		return time.Time{}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, lastFrame := f.feed.Source()
+	return lastFrame
+}
@@ -0,0 +1,69 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"live-video/pkg/integrity"
+	"live-video/pkg/vod"
+)
+
+// SetSigner makes Start sign the live playlists as they change. It applies
+// on the next Start.
+func (o *StreamOrchestrator) SetSigner(signer *integrity.Signer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.signer = signer
+}
+
+// signPlaylists publishes a detached signature of the master and media
+// playlists in dir whenever they change, until ctx is done. Live playlists
+// change with every segment, so a signature can trail its playlist by up to
+// one segment.
+func (o *StreamOrchestrator) signPlaylists(ctx context.Context, dir string) {
+	names := []string{vod.PlaylistName}
+	for _, profile := range o.config.Profiles {
+		names = append(names, path.Join(profile.Name, vod.PlaylistName))
+	}
+	signed := make(map[string]string, len(names))
+
+	ticker := time.NewTicker(time.Duration(o.config.SegmentDuration) * time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, name := range names {
+			local := filepath.Join(dir, filepath.FromSlash(name))
+			data, err := os.ReadFile(local)
+			if err != nil {
+				continue
+			}
+			digest := integrity.Digest(data)
+			if signed[name] == digest {
+				continue
+			}
+
+			gcsPath := o.storage.Layout().LivePath(o.streamID, name)
+			manifest, err := o.signer.Sign(gcsPath, data, filepath.Dir(local))
+			if err != nil {
+				// Usually a segment rotated out between reading and hashing
+				log.Printf("[Orchestrator] Failed to sign %s of %s: %v", name, o.streamID, err)
+				continue
+			}
+			signature, _ := json.Marshal(manifest)
+			if err := o.storage.UploadBytes(ctx, signature, gcsPath+integrity.SignatureSuffix, "application/json"); err != nil {
+				log.Printf("[Orchestrator] Failed to publish signature of %s of %s: %v", name, o.streamID, err)
+				continue
+			}
+			signed[name] = digest
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package orchestrator
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Load governor timings: contention lasting behindChecks checks degrades a
+// pipeline one level, each pipeline at most every degradeHold. Once there is
+// none and the node is relieved for restoreAfter, pipelines are restored one
+// level at a time.
+const (
+	cpuSampleInterval = 5 * time.Second
+	governInterval    = 10 * time.Second
+	behindSpeed       = 0.95
+	behindChecks      = 3
+	degradeHold       = time.Minute
+	restoreAfter      = 2 * time.Minute
+)
+
+// LoadGovernor is the policy engine for live pipelines under load. It samples
+// node CPU and segment upload times, and when either is high, or a critical
+// pipeline's transcoder falls behind real time, degrades and then pauses
+// best-effort pipelines first and standard ones after; critical pipelines are
+// never touched. A non-critical pipeline whose own transcoder falls behind
+// degrades on its own. Pipelines are restored, standard ones first, once load
+// subsides.
+type LoadGovernor struct {
+	cpuHigh float64 // CPU use, 0 to 1, above which pipelines degrade
+	cpuLow  float64 // below which they restore
+
+	uploadTime func() time.Duration // recent live segment upload time
+	uploadHigh time.Duration        // above which uploads are contended
+
+	mu           sync.Mutex
+	cpu          float64 // last sampled, -1 while unknown
+	prevIdle     uint64
+	prevTotal    uint64
+	pipelines    map[*StreamOrchestrator]int // running pipelines to their own strikes
+	degraded     map[string]int              // stream ID to degradation level
+	strikes      int                         // checks in a row with contention
+	contention   string                      // why the node is contended, "" if it isn't
+	healthySince time.Time
+}
+
+// NewLoadGovernor starts sampling node CPU and governing pipelines.
+// Pipelines degrade above cpuHigh (0 to 1) and restore 20 points below it.
+func NewLoadGovernor(cpuHigh float64) *LoadGovernor {
+	g := &LoadGovernor{
+		cpuHigh:   cpuHigh,
+		cpuLow:    max(0, cpuHigh-0.2),
+		cpu:       -1,
+		pipelines: make(map[*StreamOrchestrator]int),
+		degraded:  make(map[string]int),
+	}
+	g.sample()
+	go func() {
+		sample := time.NewTicker(cpuSampleInterval)
+		govern := time.NewTicker(governInterval)
+		defer sample.Stop()
+		defer govern.Stop()
+		for {
+			select {
+			case <-sample.C:
+				g.sample()
+			case <-govern.C:
+				g.govern()
+			}
+		}
+	}()
+	return g
+}
+
+// SetUploadTime makes segment uploads slower than high on average, as
+// reported by uploadTime, count as contention
+func (g *LoadGovernor) SetUploadTime(uploadTime func() time.Duration, high time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.uploadTime, g.uploadHigh = uploadTime, high
+}
+
+// add starts governing a running pipeline
+func (g *LoadGovernor) add(o *StreamOrchestrator) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pipelines[o] = 0
+}
+
+// remove stops governing a pipeline
+func (g *LoadGovernor) remove(o *StreamOrchestrator) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.pipelines, o)
+	delete(g.degraded, o.streamID)
+}
+
+// governed is a pipeline and its state during a check
+type governed struct {
+	o *StreamOrchestrator
+	loadState
+}
+
+// govern checks for contention and degrades or restores pipelines
+func (g *LoadGovernor) govern() {
+	g.mu.Lock()
+	pipelines := make([]governed, 0, len(g.pipelines))
+	for o := range g.pipelines {
+		pipelines = append(pipelines, governed{o: o})
+	}
+	g.mu.Unlock()
+	for i := range pipelines {
+		pipelines[i].loadState = pipelines[i].o.loadState()
+	}
+
+	// Pipelines behind on their own degrade themselves
+	for _, p := range pipelines {
+		behind := p.speed > 0 && p.speed < behindSpeed
+		g.mu.Lock()
+		strikes := 0
+		if behind {
+			strikes = g.pipelines[p.o] + 1
+		}
+		if _, ok := g.pipelines[p.o]; ok {
+			g.pipelines[p.o] = strikes
+		}
+		g.mu.Unlock()
+		if strikes >= behindChecks && p.priority != PriorityCritical && p.level < degradeFastestPreset {
+			if p.o.degrade(+1, fmt.Sprintf("transcoder at %.2fx real time", p.speed), false) {
+				g.resetStrikes(p.o)
+			}
+		}
+	}
+
+	reason := g.contended(pipelines)
+	g.mu.Lock()
+	g.contention = reason
+	if reason != "" {
+		g.strikes++
+		g.healthySince = time.Time{}
+		strikes := g.strikes
+		g.mu.Unlock()
+		if strikes >= behindChecks && g.degradeOne(pipelines, reason) {
+			g.mu.Lock()
+			g.strikes = 0
+			g.mu.Unlock()
+		}
+		return
+	}
+	g.strikes = 0
+	if g.cpu >= g.cpuLow {
+		g.healthySince = time.Time{}
+		g.mu.Unlock()
+		return
+	}
+	if g.healthySince.IsZero() {
+		g.healthySince = time.Now()
+	}
+	restore := time.Since(g.healthySince) >= restoreAfter
+	g.mu.Unlock()
+	if restore && g.restoreOne(pipelines) {
+		g.mu.Lock()
+		g.healthySince = time.Now()
+		g.mu.Unlock()
+	}
+}
+
+// contended returns why the node is contended, "" if it isn't
+func (g *LoadGovernor) contended(pipelines []governed) string {
+	g.mu.Lock()
+	cpu, cpuHigh := g.cpu, g.cpuHigh
+	uploadTime, uploadHigh := g.uploadTime, g.uploadHigh
+	g.mu.Unlock()
+
+	if cpu >= cpuHigh {
+		return fmt.Sprintf("node CPU at %.0f%%", cpu*100)
+	}
+	if uploadTime != nil && uploadHigh > 0 {
+		if took := uploadTime(); took >= uploadHigh {
+			return fmt.Sprintf("segment uploads take %.1fs", took.Seconds())
+		}
+	}
+	for _, p := range pipelines {
+		if p.priority == PriorityCritical && p.speed > 0 && p.speed < behindSpeed {
+			return fmt.Sprintf("critical stream %s at %.2fx real time", p.o.streamID, p.speed)
+		}
+	}
+	return ""
+}
+
+// degradeOne degrades the pipeline of the lowest class, and least degraded
+// within it, that can go further
+func (g *LoadGovernor) degradeOne(pipelines []governed, reason string) bool {
+	sort.SliceStable(pipelines, func(i, j int) bool {
+		ri, rj := priorityRank(pipelines[i].priority), priorityRank(pipelines[j].priority)
+		if ri != rj {
+			return ri < rj
+		}
+		return pipelines[i].level < pipelines[j].level
+	})
+	for _, p := range pipelines {
+		if p.canStep && p.level < maxLevel(p.priority) && p.o.degrade(+1, reason, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreOne restores the degraded pipeline of the highest class, and most
+// degraded within it, one level
+func (g *LoadGovernor) restoreOne(pipelines []governed) bool {
+	sort.SliceStable(pipelines, func(i, j int) bool {
+		ri, rj := priorityRank(pipelines[i].priority), priorityRank(pipelines[j].priority)
+		if ri != rj {
+			return ri > rj
+		}
+		return pipelines[i].level > pipelines[j].level
+	})
+	for _, p := range pipelines {
+		behind := p.speed > 0 && p.speed < behindSpeed
+		if p.level > 0 && p.canStep && !behind && p.o.degrade(-1, "load subsided", false) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *LoadGovernor) resetStrikes(o *StreamOrchestrator) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.pipelines[o]; ok {
+		g.pipelines[o] = 0
+	}
+}
+
+// CPU returns the node's CPU use from 0 to 1, -1 when it can't be read
+func (g *LoadGovernor) CPU() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cpu
+}
+
+// report records the degradation level of a pipeline, 0 once restored
+func (g *LoadGovernor) report(streamID string, level int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if level == 0 {
+		delete(g.degraded, streamID)
+		return
+	}
+	g.degraded[streamID] = level
+}
+
+// Stats returns node CPU and the degraded pipelines
+func (g *LoadGovernor) Stats() map[string]interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	degraded := make(map[string]int, len(g.degraded))
+	for id, level := range g.degraded {
+		degraded[id] = level
+	}
+	stats := map[string]interface{}{
+		"cpu_high":  g.cpuHigh,
+		"cpu_low":   g.cpuLow,
+		"degraded":  degraded,
+		"pipelines": len(g.pipelines),
+	}
+	if g.cpu >= 0 {
+		stats["cpu"] = g.cpu
+	}
+	if g.contention != "" {
+		stats["contention"] = g.contention
+	}
+	if g.uploadTime != nil {
+		stats["segment_upload_seconds"] = g.uploadTime().Seconds()
+	}
+	return stats
+}
+
+// sample reads CPU use since the last sample from /proc/stat. Where it
+// doesn't exist CPU stays unknown and pipelines go by speed alone.
+func (g *LoadGovernor) sample() {
+	idle, total, ok := readCPUTimes()
+	if !ok {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.prevTotal > 0 && total > g.prevTotal {
+		g.cpu = 1 - float64(idle-g.prevIdle)/float64(total-g.prevTotal)
+	}
+	g.prevIdle, g.prevTotal = idle, total
+}
+
+// readCPUTimes returns the idle and total CPU time of the node in jiffies
+func readCPUTimes() (idle, total uint64, ok bool) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, 0, false
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, false
+	}
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		// user nice system idle iowait irq softirq steal guest guest_nice;
+		// guest time is already counted in user and nice
+		if i >= 8 {
+			break
+		}
+		total += value
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+	return idle, total, true
+}
@@ -0,0 +1,68 @@
+package orchestrator
+
+import "fmt"
+
+// Priority classes of streams. Under contention the load governor degrades
+// and pauses best-effort pipelines first, standard ones after, and never
+// touches critical ones.
+const (
+	PriorityCritical   = "critical"
+	PriorityStandard   = "standard"
+	PriorityBestEffort = "best_effort"
+)
+
+// ValidatePriority checks a priority class, "" meaning standard
+func ValidatePriority(class string) (string, error) {
+	switch class {
+	case "":
+		return PriorityStandard, nil
+	case PriorityCritical, PriorityStandard, PriorityBestEffort:
+		return class, nil
+	}
+	return "", fmt.Errorf("priority must be %s, %s or %s", PriorityCritical, PriorityStandard, PriorityBestEffort)
+}
+
+// priorityRank orders classes from first to degrade to last
+func priorityRank(class string) int {
+	switch class {
+	case PriorityBestEffort:
+		return 0
+	case PriorityCritical:
+		return 2
+	}
+	return 1
+}
+
+// maxLevel returns how far pipelines of class degrade: best-effort ones
+// down to a paused encoder, critical ones not at all
+func maxLevel(class string) int {
+	switch class {
+	case PriorityBestEffort:
+		return degradePaused
+	case PriorityCritical:
+		return 0
+	}
+	return degradeFastestPreset
+}
+
+// SetPriority sets the priority class of the pipeline. A pipeline made
+// critical is restored at once.
+func (o *StreamOrchestrator) SetPriority(class string) {
+	o.mu.Lock()
+	o.priority = class
+	level := o.degradeLevel
+	o.mu.Unlock()
+	if class == PriorityCritical && level > 0 {
+		o.degrade(-level, "made critical", true)
+	}
+}
+
+// Priority returns the priority class of the pipeline
+func (o *StreamOrchestrator) Priority() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.priority == "" {
+		return PriorityStandard
+	}
+	return o.priority
+}
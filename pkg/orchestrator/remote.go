@@ -0,0 +1,148 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"live-video/pkg/transcoder"
+	"live-video/pkg/upstream"
+)
+
+// remoteCheckInterval is how often the remote sources of a running pipeline
+// are checked
+const remoteCheckInterval = 10 * time.Second
+
+// maxPlaylistCheck bounds how much of an HLS playlist a check reads
+const maxPlaylistCheck = 64 << 10
+
+// SourceHealth is the last check of a remote source. A source plays once a
+// check passed and is skipped on failover while its checks fail.
+type SourceHealth struct {
+	Healthy    bool      `json:"healthy"`
+	CheckedAt  time.Time `json:"checked_at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Failures   int       `json:"failures"` // checks failed in a row
+}
+
+// remoteSource is a remote input of a running pipeline and its checks
+type remoteSource struct {
+	client *upstream.Client
+	health *SourceHealth // nil until checked
+}
+
+// isRemote reports whether source is an HTTP(S) URL, of an HLS playlist or
+// an MP4 file
+func isRemote(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// watchRemotes checks the remote inputs right away and then every
+// remoteCheckInterval until ctx is done
+func (f *failover) watchRemotes(ctx context.Context) {
+	ticker := time.NewTicker(remoteCheckInterval)
+	defer ticker.Stop()
+	for {
+		f.checkRemotes(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkRemotes checks each remote input, dropping the checks of inputs that
+// were removed
+func (f *failover) checkRemotes(ctx context.Context) {
+	f.mu.Lock()
+	current := make(map[string]*remoteSource)
+	for _, input := range f.inputs {
+		if input.Kind != InputRemote {
+			continue
+		}
+		source := f.remote[input.URL]
+		if source == nil {
+			opts := upstream.DefaultOptions()
+			opts.AllowPrivate = f.policy.AllowPrivate
+			source = &remoteSource{client: upstream.New(input.URL, opts)}
+		}
+		current[input.URL] = source
+	}
+	f.remote = current
+	f.mu.Unlock()
+
+	for sourceURL, source := range current {
+		status, err := checkRemote(ctx, source.client, sourceURL)
+		if ctx.Err() != nil {
+			return
+		}
+
+		f.mu.Lock()
+		prev := source.health
+		health := &SourceHealth{Healthy: err == nil, CheckedAt: time.Now(), StatusCode: status}
+		if err != nil {
+			health.Error = err.Error()
+			health.Failures = 1
+			if prev != nil {
+				health.Failures = prev.Failures + 1
+			}
+		}
+		source.health = health
+		f.mu.Unlock()
+
+		redacted := transcoder.RedactSource(sourceURL)
+		switch {
+		case err != nil && (prev == nil || prev.Healthy):
+			log.Printf("[Orchestrator] Remote source %s of %s failed its check: %v", redacted, f.streamID, err)
+		case err == nil && prev != nil && !prev.Healthy:
+			log.Printf("[Orchestrator] Remote source %s of %s passed its check again", redacted, f.streamID)
+		}
+	}
+}
+
+// checkRemote fetches a remote source, retrying 5xx responses and
+// connection errors, and checks that it serves an HLS playlist or media. It
+// returns the status of the last response, 0 for none.
+func checkRemote(ctx context.Context, client *upstream.Client, sourceURL string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteCheckInterval)
+	defer cancel()
+
+	resp, err := client.Get(ctx, sourceURL)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, fmt.Errorf("source returned %s", resp.Status)
+	}
+
+	if u, err := url.Parse(sourceURL); err == nil && strings.HasSuffix(u.Path, ".m3u8") {
+		head, err := io.ReadAll(io.LimitReader(resp.Body, maxPlaylistCheck))
+		if err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to read playlist: %w", err)
+		}
+		if !bytes.HasPrefix(bytes.TrimLeft(head, "\ufeff \t\r\n"), []byte("#EXTM3U")) {
+			return resp.StatusCode, fmt.Errorf("source is not an HLS playlist")
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// remoteHealth returns the last check of a remote input, nil until checked.
+// Callers hold f.mu.
+func (f *failover) remoteHealth(input Input) *SourceHealth {
+	source := f.remote[input.URL]
+	if source == nil || source.health == nil {
+		return nil
+	}
+	health := *source.health
+	return &health
+}
@@ -0,0 +1,281 @@
+package orchestrator
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/transcoder"
+	"live-video/pkg/vod"
+)
+
+// renditionReadyTimeout bounds waiting for the first segment of an added
+// rendition before it is listed anyway
+const renditionReadyTimeout = 30 * time.Second
+
+// addedRendition is a rung added to a running pipeline, encoded by its own
+// transcoder from the pipeline's input
+type addedRendition struct {
+	profile    config.TranscodeProfile
+	transcoder *transcoder.FFmpegTranscoder
+	ready      bool // listed in the master playlist
+}
+
+// RenditionStatus is a rendition of a running pipeline
+type RenditionStatus struct {
+	config.TranscodeProfile
+	Added  bool `json:"added"`  // encoded by its own transcoder rather than the ladder's
+	Listed bool `json:"listed"` // in the master playlist; added renditions are once they have a segment
+}
+
+// Renditions returns the renditions of the pipeline, highest first
+func (o *StreamOrchestrator) Renditions() []RenditionStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var statuses []RenditionStatus
+	for _, profile := range o.ladderConfig().Profiles {
+		statuses = append(statuses, RenditionStatus{TranscodeProfile: profile, Listed: true})
+	}
+	for _, added := range o.added {
+		statuses = append(statuses, RenditionStatus{TranscodeProfile: added.profile, Added: true, Listed: added.ready})
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].VideoBitrate > statuses[j].VideoBitrate
+	})
+	return statuses
+}
+
+// Ladder returns the names of the renditions in the master playlist,
+// highest first
+func (o *StreamOrchestrator) Ladder() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var names []string
+	for _, profile := range o.listed() {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+// AddRendition starts encoding profile on the running pipeline next to its
+// ladder, on its own transcoder, and lists it in the master playlist once it
+// has a segment. A rendition dropped from the ladder that the ladder's
+// transcoder still encodes is listed again at once.
+func (o *StreamOrchestrator) AddRendition(profile config.TranscodeProfile) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.running {
+		return fmt.Errorf("pipeline is not running")
+	}
+	if o.offers(profile.Name) {
+		return fmt.Errorf("rendition %s is already encoded", profile.Name)
+	}
+
+	ladder := o.ladderConfig()
+	if o.hidden[profile.Name] {
+		delete(o.hidden, profile.Name)
+		ladder.Profiles = append(ladder.Profiles, profile)
+		sortProfiles(ladder.Profiles)
+		log.Printf("[Orchestrator] Rendition %s of %s listed again", profile.Name, o.streamID)
+		return o.writeMaster()
+	}
+
+	source := o.inputURL
+	if o.failover != nil {
+		source = o.failover.activeSource()
+	}
+	if strings.Contains(source, "|") {
+		return fmt.Errorf("renditions can't be added to a pipeline combining input files")
+	}
+
+	// The rendition's transcoder encodes only its rung, with the program
+	// audio mixed like the ladder's
+	cfg := *ladder
+	cfg.Profiles = []config.TranscodeProfile{profile}
+	cfg.AudioInputs = nil
+	for _, input := range ladder.AudioInputs {
+		if input.Mode == config.AudioModeMix {
+			cfg.AudioInputs = append(cfg.AudioInputs, input)
+		}
+	}
+	cfg.Recording.Enabled = false
+	cfg.StartNumber = 0
+	tc := transcoder.NewRenditionTranscoder(&cfg)
+	feed, err := tc.StartFeedHLSTranscoding(o.ctx, o.streamID+"-"+profile.Name, o.uploadPath)
+	if err != nil {
+		return fmt.Errorf("failed to start transcoder: %w", err)
+	}
+	if o.failover != nil {
+		o.failover.follow(profile.Name, feed)
+	} else {
+		feed.SwitchLive(source)
+	}
+
+	added := &addedRendition{profile: profile, transcoder: tc}
+	o.added = append(o.added, added)
+	go o.listWhenReady(added)
+	log.Printf("[Orchestrator] Added rendition %s to %s", profile.Name, o.streamID)
+	return nil
+}
+
+// DropRendition takes a rendition off the master playlist. An added
+// rendition's transcoder is stopped; a rung of the ladder keeps being encoded
+// until the ladder's transcoder restarts, so it can be listed again at once.
+func (o *StreamOrchestrator) DropRendition(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.running {
+		return fmt.Errorf("pipeline is not running")
+	}
+	if !o.offers(name) {
+		return fmt.Errorf("rendition %s is not encoded", name)
+	}
+	listed := o.listed()
+	if len(listed) == 1 && listed[0].Name == name {
+		return fmt.Errorf("the last rendition can't be dropped")
+	}
+
+	for i, added := range o.added {
+		if added.profile.Name != name {
+			continue
+		}
+		o.added = append(o.added[:i:i], o.added[i+1:]...)
+		if o.failover != nil {
+			o.failover.unfollow(name)
+		}
+		if err := o.writeMaster(); err != nil {
+			log.Printf("[Orchestrator] Failed to write master playlist of %s: %v", o.streamID, err)
+		}
+		added.transcoder.Stop()
+		log.Printf("[Orchestrator] Dropped added rendition %s of %s", name, o.streamID)
+		return nil
+	}
+
+	ladder := o.ladderConfig()
+	for i, profile := range ladder.Profiles {
+		if profile.Name == name {
+			ladder.Profiles = append(ladder.Profiles[:i:i], ladder.Profiles[i+1:]...)
+			break
+		}
+	}
+	if o.hidden == nil {
+		o.hidden = make(map[string]bool)
+	}
+	o.hidden[name] = true
+	log.Printf("[Orchestrator] Dropped rendition %s of %s", name, o.streamID)
+	return o.writeMaster()
+}
+
+// listWhenReady lists an added rendition in the master playlist once its
+// playlist has a segment, so players picking it don't find it empty
+func (o *StreamOrchestrator) listWhenReady(added *addedRendition) {
+	playlist := filepath.Join(o.uploadPath, added.profile.Name, vod.PlaylistName)
+	deadline := time.Now().Add(renditionReadyTimeout)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		data, err := os.ReadFile(playlist)
+		segments, _ := vod.Segments(data)
+		if (err == nil && len(segments) > 0) || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, current := range o.added {
+		if current == added && o.running {
+			added.ready = true
+			if err := o.writeMaster(); err != nil {
+				log.Printf("[Orchestrator] Failed to write master playlist of %s: %v", o.streamID, err)
+			}
+			return
+		}
+	}
+}
+
+// stopAdded stops the transcoders of added renditions. Callers hold o.mu.
+func (o *StreamOrchestrator) stopAdded() {
+	for _, added := range o.added {
+		if err := added.transcoder.Stop(); err != nil {
+			log.Printf("[Orchestrator] Error stopping rendition %s: %v", added.profile.Name, err)
+		}
+	}
+}
+
+// ladderConfig returns the config of the ladder's transcoder. Callers hold
+// o.mu.
+func (o *StreamOrchestrator) ladderConfig() *config.FFmpegConfig {
+	if o.warm != nil {
+		return o.warm.config
+	}
+	return o.config
+}
+
+// listed returns the renditions of the master playlist, highest first.
+// Callers hold o.mu.
+func (o *StreamOrchestrator) listed() []config.TranscodeProfile {
+	profiles := append([]config.TranscodeProfile(nil), o.ladderConfig().Profiles...)
+	for _, added := range o.added {
+		if added.ready {
+			profiles = append(profiles, added.profile)
+		}
+	}
+	sortProfiles(profiles)
+	return profiles
+}
+
+// offers reports whether a rendition named name is encoded and listed, or
+// being added. Callers hold o.mu.
+func (o *StreamOrchestrator) offers(name string) bool {
+	for _, profile := range o.ladderConfig().Profiles {
+		if profile.Name == name {
+			return true
+		}
+	}
+	for _, added := range o.added {
+		if added.profile.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMaster writes the master playlist of the listed renditions for the
+// uploader to publish. Callers hold o.mu.
+func (o *StreamOrchestrator) writeMaster() error {
+	cfg := *o.ladderConfig()
+	cfg.Profiles = o.listed()
+	return transcoder.WriteMasterPlaylist(&cfg, o.uploadPath)
+}
+
+// sortProfiles orders profiles from the highest bitrate down
+func sortProfiles(profiles []config.TranscodeProfile) {
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].VideoBitrate > profiles[j].VideoBitrate
+	})
+}
+
+// renditionNames returns every rendition the pipeline encodes, listed or
+// not. Callers hold o.mu.
+func (o *StreamOrchestrator) renditionNames() []string {
+	var names []string
+	for _, profile := range o.ladderConfig().Profiles {
+		names = append(names, profile.Name)
+	}
+	for _, added := range o.added {
+		names = append(names, added.profile.Name)
+	}
+	for name := range o.hidden {
+		names = append(names, name)
+	}
+	return names
+}
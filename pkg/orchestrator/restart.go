@@ -0,0 +1,198 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"live-video/pkg/events"
+	"live-video/pkg/transcoder"
+	"live-video/pkg/vod"
+)
+
+// Transcoder restarts: FFmpeg exiting mid-stream is restarted this many
+// times, waiting twice as long each time, before the pipeline fails. A
+// transcoder that ran for stableRun resets the count.
+const (
+	maxTranscoderRestarts = 5
+	firstRestartDelay     = time.Second
+	stableRun             = 5 * time.Minute
+)
+
+// SetFailureHandler sets a function called when the pipeline gives up
+// restarting its transcoder. It is called without o.mu held and may Stop the
+// orchestrator.
+func (o *StreamOrchestrator) SetFailureHandler(handler func(err error)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.onFailure = handler
+}
+
+// SetEvents publishes what the pipeline does on its own, degrading,
+// restarting its transcoder, giving up on it and switching inputs, on bus
+func (o *StreamOrchestrator) SetEvents(bus *events.Bus) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = bus
+}
+
+// Discontinuities returns the media sequence numbers of the first segments
+// after transcoder restarts, which published playlists mark with
+// EXT-X-DISCONTINUITY
+func (o *StreamOrchestrator) Discontinuities() []int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]int(nil), o.discontinuities...)
+}
+
+// supervise restarts the transcoder whenever FFmpeg exits while the pipeline
+// runs, until ctx is done
+func (o *StreamOrchestrator) supervise(ctx context.Context, inputURL, dir string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.transcoder.Done():
+		}
+
+		stopped, err := o.restartTranscoder(ctx, inputURL, dir)
+		if stopped {
+			return
+		}
+		if err != nil {
+			log.Printf("[Orchestrator] Giving up on transcoder of %s: %v", o.streamID, err)
+			o.mu.Lock()
+			handler := o.onFailure
+			o.events.Publish(events.PipelineFailed, map[string]any{
+				"stream_id": o.streamID,
+				"error":     err.Error(),
+			})
+			o.mu.Unlock()
+			if handler != nil {
+				handler(err)
+			}
+			return
+		}
+	}
+}
+
+// restartTranscoder starts FFmpeg again on the same output with a feed
+// playing the live input from its edge. The new segments continue the media
+// sequence after a discontinuity. It reports stopped when the pipeline was
+// stopped meanwhile.
+func (o *StreamOrchestrator) restartTranscoder(ctx context.Context, inputURL, dir string) (stopped bool, err error) {
+	for {
+		o.mu.Lock()
+		if !o.running || ctx.Err() != nil {
+			o.mu.Unlock()
+			return true, nil
+		}
+		if o.paused {
+			unpause := o.unpause
+			o.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return true, nil
+			case <-unpause:
+			}
+			continue
+		}
+		if o.restart == nil {
+			o.mu.Unlock()
+			return false, fmt.Errorf("transcoder exited")
+		}
+		// A transcoder stopped for a new ladder restarts at once
+		delay := time.Duration(0)
+		if o.reconfiguring {
+			o.reconfiguring = false
+		} else {
+			if time.Since(o.transcoderStartedAt) >= stableRun {
+				o.restarts = 0
+			}
+			if o.restarts >= maxTranscoderRestarts {
+				o.mu.Unlock()
+				return false, fmt.Errorf("transcoder exited %d times in a row", o.restarts+1)
+			}
+			delay = firstRestartDelay << o.restarts
+			o.restarts++
+		}
+		o.totalRestarts++
+		o.mu.Unlock()
+
+		if delay > 0 {
+			log.Printf("[Orchestrator] Transcoder of %s exited, restarting in %s", o.streamID, delay)
+			select {
+			case <-ctx.Done():
+				return true, nil
+			case <-time.After(delay):
+			}
+		}
+
+		o.mu.Lock()
+		if !o.running || ctx.Err() != nil {
+			o.mu.Unlock()
+			return true, nil
+		}
+		next := o.nextSequence(dir)
+		cfg := o.config
+		if o.warm != nil {
+			cfg = o.warm.config
+		}
+		cfg.StartNumber = next
+		feed, err := o.restart()
+		if err != nil {
+			o.mu.Unlock()
+			log.Printf("[Orchestrator] Failed to restart transcoder of %s: %v", o.streamID, err)
+			continue
+		}
+		o.transcoderStartedAt = time.Now()
+		o.discontinuities = append(o.discontinuities, next)
+		// Rungs dropped from the ladder are no longer encoded, and the
+		// transcoder wrote the master playlist of its ladder alone
+		o.hidden = nil
+		if len(o.added) > 0 {
+			if err := o.writeMaster(); err != nil {
+				log.Printf("[Orchestrator] Failed to write master playlist of %s: %v", o.streamID, err)
+			}
+		}
+		if o.warm != nil {
+			o.warm.feed = feed
+		}
+		if o.failover != nil {
+			o.failover.setFeed(feed)
+		} else {
+			feed.SwitchLive(inputURL)
+		}
+		o.events.Publish(events.PipelineRestarted, map[string]any{
+			"stream_id": o.streamID,
+			"sequence":  next,
+			"restarts":  o.totalRestarts,
+		})
+		o.mu.Unlock()
+		log.Printf("[Orchestrator] Restarted transcoder of %s, continuing at segment %d", o.streamID, next)
+		return false, nil
+	}
+}
+
+// nextSequence returns the media sequence number the next segment written to
+// dir gets. Callers hold o.mu.
+func (o *StreamOrchestrator) nextSequence(dir string) int {
+	ladder := o.ladderConfig()
+	if len(ladder.Profiles) == 0 {
+		return o.config.StartNumber
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ladder.Profiles[0].Name, vod.PlaylistName))
+	if err != nil {
+		return o.config.StartNumber
+	}
+	segments, _ := vod.Segments(data)
+	return vod.MediaSequence(data) + len(segments)
+}
+
+// restartFeed starts the stream's own transcoder again, as a feed
+func (o *StreamOrchestrator) restartFeed() (*transcoder.Feed, error) {
+	return o.transcoder.StartFeedHLSTranscoding(o.ctx, o.streamID, o.outputPath)
+}
@@ -0,0 +1,57 @@
+package orchestrator
+
+import (
+	"slices"
+
+	"live-video/config"
+)
+
+// Snapshot is a copy of a pipeline's state taken under its lock. It shares
+// nothing with the pipeline, so it can be kept and serialized while the
+// pipeline changes.
+type Snapshot struct {
+	StreamID           string              `json:"streamID"`
+	Running            bool                `json:"running"`
+	OutputPath         string              `json:"outputPath"`
+	PlaylistURL        string              `json:"playlistURL"`
+	WarmStart          bool                `json:"warmStart"`
+	Portrait           bool                `json:"portrait"`
+	InputFailover      *FailoverSnapshot   `json:"input_failover,omitempty"`
+	AudioInputs        []config.AudioInput `json:"audio_inputs,omitempty"`
+	Ladder             []string            `json:"ladder,omitempty"` // listed renditions, once renditions were added or dropped
+	Encoding           *EncodingSnapshot   `json:"encoding,omitempty"`
+	TranscoderRestarts int                 `json:"transcoder_restarts,omitempty"`
+	Discontinuities    []int               `json:"discontinuities,omitempty"`
+}
+
+// Snapshot returns a copy of the pipeline's state
+func (o *StreamOrchestrator) Snapshot() *Snapshot {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	snap := &Snapshot{
+		StreamID:    o.streamID,
+		Running:     o.running,
+		OutputPath:  o.outputPath,
+		PlaylistURL: o.GetPlaylistURL(),
+		WarmStart:   o.warm != nil,
+		Portrait:    o.config.Portrait,
+		AudioInputs: slices.Clone(o.config.AudioInputs),
+	}
+	if o.failover != nil {
+		snap.InputFailover = o.failover.snapshot()
+	}
+	if len(o.added) > 0 || len(o.hidden) > 0 {
+		for _, profile := range o.listed() {
+			snap.Ladder = append(snap.Ladder, profile.Name)
+		}
+	}
+	if o.governor != nil && o.running {
+		snap.Encoding = o.encodingSnapshot()
+	}
+	if o.totalRestarts > 0 {
+		snap.TranscoderRestarts = o.totalRestarts
+		snap.Discontinuities = slices.Clone(o.discontinuities)
+	}
+	return snap
+}
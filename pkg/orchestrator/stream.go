@@ -11,35 +11,150 @@ import (
 	"time"
 
 	"live-video/config"
+	"live-video/pkg/captions"
+	"live-video/pkg/events"
 	"live-video/pkg/hls"
+	"live-video/pkg/integrity"
 	"live-video/pkg/storage"
 	"live-video/pkg/transcoder"
+	"live-video/pkg/upstream"
 )
 
 // StreamOrchestrator coordinates the entire streaming pipeline
 type StreamOrchestrator struct {
 	streamID   string
+	config     *config.FFmpegConfig
 	transcoder *transcoder.FFmpegTranscoder
 	uploader   *hls.Uploader
 	storage    *storage.GCSService
 	outputPath string
+	pool       *WarmPool
+	warm       *warmTranscoder // set when the transcoder was claimed from pool
+	signer     *integrity.Signer
 	ctx        context.Context
 	cancel     context.CancelFunc
 	mu         sync.Mutex
 	running    bool
+
+	inputs         []Input         // inputs to fail over between, if any
+	failoverWindow time.Duration   // how long an input may deliver nothing
+	failover       *failover       // set while running with inputs
+	sourcePolicy   upstream.Policy // hosts remote inputs may be checked on
+
+	recognizer       captions.Recognizer // nil without captions
+	translator       captions.Translator
+	captionLanguages []string
+	captioner        *captions.Captioner // set while running with captions
+
+	restart             func() (*transcoder.Feed, error) // starts the transcoder again after it exited, nil if it can't be
+	transcoderStartedAt time.Time
+	restarts            int   // in a row, without a stable run in between
+	totalRestarts       int   // since Start
+	discontinuities     []int // media sequence numbers of the first segments after restarts
+	onFailure           func(err error)
+	events              *events.Bus
+
+	inputURL   string            // what the pipeline was started on
+	uploadPath string            // where the ladder's transcoder writes
+	added      []*addedRendition // renditions added while running
+	hidden     map[string]bool   // rungs dropped from the ladder its transcoder still encodes
+
+	governor       *LoadGovernor
+	degradeLevel   int
+	baseLadder     []config.TranscodeProfile // the ladder before degrading
+	lastDegradeAt  *time.Time
+	degradeActions []DegradeAction
+	reconfiguring  bool          // the transcoder was stopped to restart with a new ladder
+	paused         bool          // the transcoder was stopped until load subsides
+	unpause        chan struct{} // closed when a paused pipeline resumes
+	priority       string
 }
 
-// NewStreamOrchestrator creates a new stream orchestrator
-func NewStreamOrchestrator(streamID string, gcsStorage *storage.GCSService) *StreamOrchestrator {
+// NewStreamOrchestrator creates a new stream orchestrator writing HLS output to outputPath
+func NewStreamOrchestrator(streamID string, gcsStorage *storage.GCSService, outputPath string) *StreamOrchestrator {
 	ffmpegConfig := config.DefaultFFmpegConfig()
 	return &StreamOrchestrator{
 		streamID:   streamID,
+		config:     ffmpegConfig,
 		transcoder: transcoder.NewFFmpegTranscoder(ffmpegConfig),
 		storage:    gcsStorage,
-		outputPath: filepath.Join("/tmp", "hls", streamID),
+		outputPath: outputPath,
+	}
+}
+
+// SetPlaylistWindow makes the live playlists hold at least window of
+// segments, so DVR viewers can seek that far back. It applies on the next
+// Start.
+func (o *StreamOrchestrator) SetPlaylistWindow(window time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	segments := int((window.Seconds() + float64(o.config.SegmentDuration) - 1) / float64(o.config.SegmentDuration))
+	if segments+1 > o.config.PlaylistSize {
+		o.config.PlaylistSize = segments + 1
 	}
 }
 
+// SetStartNumber makes the first segment of the next Start have media
+// sequence number n, e.g. to continue the sequence of a slate
+func (o *StreamOrchestrator) SetStartNumber(n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.config.StartNumber = n
+}
+
+// SetSegmentNaming sets how the next Start names its segments
+func (o *StreamOrchestrator) SetSegmentNaming(segments config.SegmentNaming) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.config.Segments = segments
+}
+
+// SetAudioInputs makes the next Start mix the extra audio inputs into the
+// program audio or offer them as alternate audio renditions
+func (o *StreamOrchestrator) SetAudioInputs(inputs []config.AudioInput) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.config.AudioInputs = inputs
+}
+
+// AudioInputs returns the extra audio inputs of the pipeline
+func (o *StreamOrchestrator) AudioInputs() []config.AudioInput {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.config.AudioInputs
+}
+
+// SetWatermark makes the next Start encode a marked variant of every
+// rendition next to it, see config.WatermarkVariant
+func (o *StreamOrchestrator) SetWatermark(enabled bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.config.Watermark = enabled
+}
+
+// SetContentType makes the next Start tune the ladder for camera or screen
+// content, see config.ContentScreen
+func (o *StreamOrchestrator) SetContentType(contentType string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.config.ContentType = contentType
+}
+
+// Watermarked reports whether the pipeline encodes marked variants
+func (o *StreamOrchestrator) Watermarked() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.ladderConfig().Watermark
+}
+
+// SetWarmPool lets Start claim a running transcoder from pool
+func (o *StreamOrchestrator) SetWarmPool(pool *WarmPool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pool = pool
+}
+
 // Start starts the streaming pipeline
 func (o *StreamOrchestrator) Start(inputURL string) error {
 	o.mu.Lock()
@@ -54,18 +169,54 @@ func (o *StreamOrchestrator) Start(inputURL string) error {
 
 	log.Printf("[Orchestrator] Starting stream pipeline for %s", o.streamID)
 
-	// Wait for WebRTC input files to have data (with timeout)
-	if err := o.waitForInputFiles(inputURL); err != nil {
-		log.Printf("[Orchestrator] Warning: %v, starting FFmpeg anyway", err)
-	}
+	uploadPath := o.outputPath
+	o.detectOrientation(inputURL)
+	var feed *transcoder.Feed
+	if warm := o.claimWarm(inputURL); warm != nil {
+		// The warm transcoder is already writing segments: switch its input
+		// and link its output in place of the stream's
+		if err := linkOutput(warm.outputPath, o.outputPath); err != nil {
+			warm.stop()
+			return fmt.Errorf("failed to link warm transcoder output: %w", err)
+		}
+		warm.feed.Switch(inputURL)
+		o.transcoder = warm.transcoder
+		o.warm = warm
+		feed = warm.feed
+		uploadPath = warm.outputPath
+		o.restart = func() (*transcoder.Feed, error) {
+			return warm.transcoder.StartFeedHLSTranscoding(o.ctx, "warm-"+warm.id, warm.outputPath)
+		}
+		log.Printf("[Orchestrator] Claimed warm transcoder %s for %s", warm.id, o.streamID)
+	} else if len(o.inputs) > 0 {
+		// Inputs are switched on a feed, so FFmpeg and the playlists keep
+		// running across failovers
+		var err error
+		if feed, err = o.transcoder.StartFeedHLSTranscoding(o.ctx, o.streamID, o.outputPath); err != nil {
+			return fmt.Errorf("failed to start transcoder: %w", err)
+		}
+		o.restart = o.restartFeed
+	} else {
+		// Wait for WebRTC input files to have data (with timeout)
+		if err := o.waitForInputFiles(inputURL); err != nil {
+			log.Printf("[Orchestrator] Warning: %v, starting FFmpeg anyway", err)
+		}
+		o.detectOrientation(inputURL)
 
-	// Start FFmpeg transcoder
-	if err := o.transcoder.StartHLSTranscoding(o.ctx, inputURL, o.streamID, o.outputPath); err != nil {
-		return fmt.Errorf("failed to start transcoder: %w", err)
+		// Start FFmpeg transcoder
+		if err := o.transcoder.StartHLSTranscoding(o.ctx, inputURL, o.streamID, o.outputPath); err != nil {
+			return fmt.Errorf("failed to start transcoder: %w", err)
+		}
+		// A restart plays the input from its live edge on a feed; inputs
+		// combining files can't be fed
+		o.restart = nil
+		if !strings.Contains(inputURL, "|") {
+			o.restart = o.restartFeed
+		}
 	}
 
 	// Start HLS uploader
-	uploader, err := hls.NewUploader(o.storage, o.streamID, o.outputPath)
+	uploader, err := hls.NewUploader(o.storage, o.streamID, uploadPath)
 	if err != nil {
 		o.transcoder.Stop()
 		return fmt.Errorf("failed to create uploader: %w", err)
@@ -77,6 +228,22 @@ func (o *StreamOrchestrator) Start(inputURL string) error {
 		o.transcoder.Stop()
 		return fmt.Errorf("failed to start uploader: %w", err)
 	}
+	if o.signer != nil {
+		go o.signPlaylists(o.ctx, uploadPath)
+	}
+	if o.recognizer != nil {
+		o.startCaptions(o.ctx, uploadPath)
+	}
+	if len(o.inputs) > 0 {
+		o.startFailover(o.ctx, feed, inputURL)
+	}
+	o.transcoderStartedAt = time.Now()
+	o.restarts, o.totalRestarts, o.discontinuities = 0, 0, nil
+	o.inputURL, o.uploadPath = inputURL, uploadPath
+	go o.supervise(o.ctx, inputURL, uploadPath)
+	if o.governor != nil {
+		o.governor.add(o)
+	}
 
 	o.running = true
 	log.Printf("[Orchestrator] Stream pipeline started successfully")
@@ -84,6 +251,54 @@ func (o *StreamOrchestrator) Start(inputURL string) error {
 	return nil
 }
 
+// claimWarm claims a warm transcoder for a single IVF input, which is what
+// warm transcoders can switch to. Warm transcoders number segments from 0
+// and encode a landscape ladder, so streams continuing a sequence or known to
+// be portrait, and streams with extra audio inputs, watermarks or screen
+// content, start their own.
+func (o *StreamOrchestrator) claimWarm(inputURL string) *warmTranscoder {
+	if o.pool == nil || o.config.StartNumber > 0 || o.config.Portrait || len(o.config.AudioInputs) > 0 || o.config.Watermark || o.config.ContentType == config.ContentScreen || strings.Contains(inputURL, "|") || !strings.HasSuffix(inputURL, ".ivf") {
+		return nil
+	}
+	return o.pool.claim(o.config.PlaylistSize)
+}
+
+// detectOrientation lays the ladder out portrait when the first keyframe of
+// an IVF input is taller than wide. Without a keyframe yet the ladder stays
+// as it is.
+func (o *StreamOrchestrator) detectOrientation(inputURL string) {
+	path := strings.Split(inputURL, "|")[0]
+	if !strings.HasSuffix(path, ".ivf") {
+		return
+	}
+	width, height, err := transcoder.IVFFrameSize(path)
+	if err != nil {
+		return
+	}
+	if portrait := height > width; portrait != o.config.Portrait {
+		o.config.Portrait = portrait
+		log.Printf("[Orchestrator] Input of %s is %dx%d, portrait ladder: %v", o.streamID, width, height, portrait)
+	}
+}
+
+// Portrait reports whether the stream is transcoded to a portrait ladder
+func (o *StreamOrchestrator) Portrait() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.config.Portrait
+}
+
+// linkOutput replaces the output directory path with a symlink to target
+func linkOutput(target, path string) error {
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.Symlink(target, path)
+}
+
 // waitForInputFiles waits for WebRTC input files to have data
 func (o *StreamOrchestrator) waitForInputFiles(inputURL string) error {
 	// Extract file paths - check for | separator
@@ -146,13 +361,36 @@ func (o *StreamOrchestrator) Stop() error {
 	if err := o.transcoder.Stop(); err != nil {
 		log.Printf("[Orchestrator] Error stopping transcoder: %v", err)
 	}
+	o.stopAdded()
 
 	// Cancel context
 	if o.cancel != nil {
 		o.cancel()
 	}
 
+	// Viewers' players stop at the last segment
+	dir := o.outputPath
+	if o.warm != nil {
+		dir = o.warm.outputPath
+	}
+	o.finalizePlaylists(dir)
+
 	o.running = false
+	o.failover = nil
+	o.captioner = nil
+	o.added, o.hidden = nil, nil
+	if o.baseLadder != nil {
+		o.ladderConfig().Profiles = o.baseLadder
+		o.baseLadder = nil
+	}
+	o.degradeLevel, o.lastDegradeAt, o.reconfiguring = 0, nil, false
+	if o.paused {
+		o.paused = false
+		close(o.unpause)
+	}
+	if o.governor != nil {
+		o.governor.remove(o)
+	}
 	log.Printf("[Orchestrator] Stream pipeline stopped successfully")
 
 	return nil
@@ -169,16 +407,3 @@ func (o *StreamOrchestrator) IsRunning() bool {
 func (o *StreamOrchestrator) GetPlaylistURL() string {
 	return o.storage.GetHLSMasterPlaylistURL(o.streamID)
 }
-
-// GetStats returns runtime statistics
-func (o *StreamOrchestrator) GetStats() map[string]interface{} {
-	o.mu.Lock()
-	defer o.mu.Unlock()
-
-	return map[string]interface{}{
-		"streamID":    o.streamID,
-		"running":     o.running,
-		"outputPath":  o.outputPath,
-		"playlistURL": o.GetPlaylistURL(),
-	}
-}
@@ -0,0 +1,186 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/m3u8"
+	"live-video/pkg/transcoder"
+
+	"github.com/google/uuid"
+)
+
+// Warm pool timings
+const (
+	warmReadyTimeout = 30 * time.Second // for a warm transcoder's first segments
+	warmRetryDelay   = 30 * time.Second // after a warm transcoder failed to start
+)
+
+// WarmPool keeps transcoders running on placeholder input so a starting
+// stream can claim one instead of waiting for FFmpeg to start and write its
+// first segments
+type WarmPool struct {
+	size     int
+	dir      string
+	segments config.SegmentNaming
+
+	mu       sync.Mutex
+	ready    []*warmTranscoder
+	starting int
+	claimed  int
+}
+
+// warmTranscoder is a transcoder of the pool writing HLS to outputPath
+type warmTranscoder struct {
+	id         string
+	config     *config.FFmpegConfig
+	transcoder *transcoder.FFmpegTranscoder
+	feed       *transcoder.Feed
+	outputPath string
+}
+
+// NewWarmPool starts size warm transcoders writing segments named by
+// segments to directories in dir and keeps the pool filled as they are
+// claimed
+func NewWarmPool(size int, dir string, segments config.SegmentNaming) *WarmPool {
+	p := &WarmPool{
+		size:     size,
+		dir:      dir,
+		segments: segments,
+	}
+	// Output of a previous run is stale
+	os.RemoveAll(dir)
+	p.fill()
+	return p
+}
+
+// claim takes a ready transcoder whose playlists hold at least playlistSize
+// segments, or returns nil
+func (p *WarmPool) claim(playlistSize int) *warmTranscoder {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := 0; i < len(p.ready); i++ {
+		warm := p.ready[i]
+		if !warm.transcoder.IsRunning() {
+			log.Printf("[WarmPool] Warm transcoder %s exited, replacing it", warm.id)
+			p.ready = append(p.ready[:i], p.ready[i+1:]...)
+			os.RemoveAll(warm.outputPath)
+			i--
+			continue
+		}
+		if warm.config.PlaylistSize < playlistSize {
+			continue
+		}
+		p.ready = append(p.ready[:i], p.ready[i+1:]...)
+		p.claimed++
+		go p.fill()
+		return warm
+	}
+	go p.fill()
+	return nil
+}
+
+// fill starts transcoders until the pool has size ready or starting
+func (p *WarmPool) fill() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.ready)+p.starting < p.size {
+		p.starting++
+		go p.startOne()
+	}
+}
+
+// startOne starts a warm transcoder and adds it to the pool once it writes
+// segments
+func (p *WarmPool) startOne() {
+	warm, err := p.start()
+
+	p.mu.Lock()
+	p.starting--
+	if err == nil {
+		p.ready = append(p.ready, warm)
+		p.mu.Unlock()
+		log.Printf("[WarmPool] Warm transcoder %s ready", warm.id)
+		return
+	}
+	p.mu.Unlock()
+
+	if warm != nil {
+		warm.stop()
+	}
+	log.Printf("[WarmPool] Failed to start warm transcoder: %v", err)
+	time.Sleep(warmRetryDelay)
+	p.fill()
+}
+
+func (p *WarmPool) start() (*warmTranscoder, error) {
+	ffmpegConfig := config.DefaultFFmpegConfig()
+	ffmpegConfig.Segments = p.segments
+	warm := &warmTranscoder{
+		id:         uuid.New().String(),
+		config:     ffmpegConfig,
+		transcoder: transcoder.NewFFmpegTranscoder(ffmpegConfig),
+	}
+	warm.outputPath = filepath.Join(p.dir, warm.id)
+
+	feed, err := warm.transcoder.StartFeedHLSTranscoding(context.Background(), "warm-"+warm.id, warm.outputPath)
+	if err != nil {
+		return nil, err
+	}
+	warm.feed = feed
+
+	// Ready once every rendition has a segment
+	deadline := time.Now().Add(warmReadyTimeout)
+	for time.Now().Before(deadline) {
+		if !warm.transcoder.IsRunning() {
+			return warm, fmt.Errorf("transcoder exited")
+		}
+		if warm.hasSegments() {
+			return warm, nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return warm, fmt.Errorf("no segments after %s", warmReadyTimeout)
+}
+
+// hasSegments reports whether every rendition has written a playlist
+func (w *warmTranscoder) hasSegments() bool {
+	for _, profile := range w.config.Profiles {
+		data, err := os.ReadFile(filepath.Join(w.outputPath, profile.Name, "playlist.m3u8"))
+		if err != nil {
+			return false
+		}
+		if playlist, err := m3u8.ParseMedia(data); err != nil || len(playlist.Segments) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// stop stops the transcoder and removes its output
+func (w *warmTranscoder) stop() {
+	w.transcoder.Stop()
+	os.RemoveAll(w.outputPath)
+}
+
+// Stats returns the state of the pool
+func (p *WarmPool) Stats() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[string]interface{}{
+		"size":     p.size,
+		"ready":    len(p.ready),
+		"starting": p.starting,
+		"claimed":  p.claimed,
+	}
+}
@@ -0,0 +1,260 @@
+// Package ownership keeps who owns and shares each stream, video, event and
+// collection, so access, asset lists, erasure, billing and quotas survive
+// restarts. Records are kept in the bucket, shared by all replicas, and
+// announced on the event bus when they change.
+package ownership
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/auth"
+	"live-video/pkg/background"
+	"live-video/pkg/events"
+	"live-video/pkg/storage"
+)
+
+// Prefix is where ownership records are kept in the bucket, outside the
+// layout prefixes so no lifecycle rule removes them
+const Prefix = "ownership"
+
+const (
+	syncTimeout  = time.Minute      // bounds one sync with the bucket
+	writeTimeout = 30 * time.Second // bounds storing one record
+)
+
+// record is the ownership of one resource, as stored and announced
+type record struct {
+	Kind       string `json:"kind"`
+	ResourceID string `json:"resource_id"`
+	auth.Ownership
+}
+
+// stored is what is known of a record in the bucket
+type stored struct {
+	generation int64     // of its object, 0 until listed
+	updated    time.Time // when it was last written or read
+}
+
+// Store keeps the ownership records of an account service in the bucket. It
+// implements auth.OwnershipStore.
+type Store struct {
+	gcsService  *storage.GCSService
+	bus         *events.Bus
+	authService *auth.Service
+
+	mu     sync.Mutex
+	stored map[string]*stored // by kind and resource ID
+}
+
+// NewStore creates the store of authService's ownership records, kept in
+// the bucket of gcsService
+func NewStore(gcsService *storage.GCSService, bus *events.Bus, authService *auth.Service) *Store {
+	return &Store{
+		gcsService:  gcsService,
+		bus:         bus,
+		authService: authService,
+		stored:      make(map[string]*stored),
+	}
+}
+
+// SaveOwnership stores the ownership record of a resource and announces it
+// to the other replicas. Failures are logged; the record stays in memory.
+func (s *Store) SaveOwnership(kind, resourceID string, own *auth.Ownership) {
+	r := &record{Kind: kind, ResourceID: resourceID, Ownership: *own}
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("[Ownership] Failed to encode ownership of %s %s: %v", kind, resourceID, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	if err := s.gcsService.UploadBytes(ctx, data, objectPath(kind, resourceID), "application/json"); err != nil {
+		log.Printf("[Ownership] Failed to store ownership of %s %s: %v", kind, resourceID, err)
+		return
+	}
+	s.mark(kind, resourceID)
+	s.bus.Publish(events.OwnershipChanged, r.eventData())
+}
+
+// DeleteOwnership removes the ownership record of a deleted resource and
+// announces it to the other replicas
+func (s *Store) DeleteOwnership(kind, resourceID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	if err := s.gcsService.DeleteVideo(ctx, objectPath(kind, resourceID)); err != nil && !storage.IsNotExist(err) {
+		log.Printf("[Ownership] Failed to delete ownership of %s %s: %v", kind, resourceID, err)
+		return
+	}
+	s.unmark(kind, resourceID)
+	s.bus.Publish(events.OwnershipRemoved, map[string]any{"kind": kind, "resource_id": resourceID})
+}
+
+// Len returns the number of ownership records in the bucket
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.stored)
+}
+
+// Start loads the ownership records in the bucket into the account service,
+// follows the changes announced by other replicas and syncs with the bucket
+// in group every interval, should an announcement be lost
+func (s *Store) Start(group *background.Group, interval time.Duration) {
+	s.bus.Subscribe(events.OwnershipChanged, func(event events.Event) {
+		if event.Origin == s.bus.Node() {
+			return // already applied, and maybe changed since
+		}
+		if r, err := recordOf(event.Data); err == nil {
+			s.authService.LoadOwnership(r.Kind, r.ResourceID, &r.Ownership)
+			s.mark(r.Kind, r.ResourceID)
+		}
+	})
+	s.bus.Subscribe(events.OwnershipRemoved, func(event events.Event) {
+		if event.Origin == s.bus.Node() {
+			return
+		}
+		kind, _ := event.Data["kind"].(string)
+		resourceID, _ := event.Data["resource_id"].(string)
+		if kind != "" && resourceID != "" {
+			s.authService.ForgetOwnership(kind, resourceID)
+			s.unmark(kind, resourceID)
+		}
+	})
+
+	sync := func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+		defer cancel()
+		if err := s.Sync(ctx); err != nil {
+			log.Printf("[Ownership] Failed to sync ownership: %v", err)
+		}
+	}
+	sync(group.Context())
+	group.Every(interval, sync)
+}
+
+// Sync makes the account service's ownership records what the bucket
+// holds, reading only the records that changed since they were last read
+func (s *Store) Sync(ctx context.Context) error {
+	listedAt := time.Now()
+	objects, err := s.gcsService.ListObjects(ctx, Prefix+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list ownership: %w", err)
+	}
+
+	listed := make(map[string]bool, len(objects))
+	for _, attrs := range objects {
+		kind, resourceID, ok := keyOf(attrs.Name)
+		if !ok {
+			continue
+		}
+		key := resourceKey(kind, resourceID)
+		listed[key] = true
+
+		s.mu.Lock()
+		known, ok := s.stored[key]
+		s.mu.Unlock()
+		if ok && known.generation == attrs.Generation {
+			continue
+		}
+		data, err := s.gcsService.ReadFile(ctx, attrs.Name)
+		if storage.IsNotExist(err) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read ownership of %s %s: %w", kind, resourceID, err)
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil || r.Kind != kind || r.ResourceID != resourceID {
+			log.Printf("[Ownership] Skipping unreadable ownership record %s", attrs.Name)
+			continue
+		}
+		s.authService.LoadOwnership(kind, resourceID, &r.Ownership)
+		s.mu.Lock()
+		s.stored[key] = &stored{generation: attrs.Generation, updated: time.Now()}
+		s.mu.Unlock()
+	}
+
+	// Records written since the listing are kept for the next sync
+	var gone []string
+	s.mu.Lock()
+	for key, known := range s.stored {
+		if !listed[key] && known.updated.Before(listedAt) {
+			delete(s.stored, key)
+			gone = append(gone, key)
+		}
+	}
+	s.mu.Unlock()
+	for _, key := range gone {
+		kind, resourceID, _ := strings.Cut(key, ":")
+		s.authService.ForgetOwnership(kind, resourceID)
+	}
+	return nil
+}
+
+// mark notes a record was written, to be re-read on the next sync
+func (s *Store) mark(kind, resourceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stored[resourceKey(kind, resourceID)] = &stored{updated: time.Now()}
+}
+
+func (s *Store) unmark(kind, resourceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.stored, resourceKey(kind, resourceID))
+}
+
+// eventData returns a record as the data of its event
+func (r *record) eventData() map[string]any {
+	data, _ := json.Marshal(r)
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// recordOf decodes the record of an event, relayed through JSON or not
+func recordOf(fields map[string]any) (*record, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if r.Kind == "" || r.ResourceID == "" || r.OwnerID == "" {
+		return nil, fmt.Errorf("incomplete ownership record")
+	}
+	return &r, nil
+}
+
+// objectPath returns where the record of a resource is kept, its ID escaped
+// so it is one path segment
+func objectPath(kind, resourceID string) string {
+	return path.Join(Prefix, kind, url.PathEscape(resourceID)+".json")
+}
+
+// keyOf returns the resource whose record is kept at name
+func keyOf(name string) (kind, resourceID string, ok bool) {
+	kind, file, ok := strings.Cut(strings.TrimPrefix(name, Prefix+"/"), "/")
+	if !ok || !strings.HasSuffix(file, ".json") {
+		return "", "", false
+	}
+	resourceID, err := url.PathUnescape(strings.TrimSuffix(file, ".json"))
+	if err != nil || resourceID == "" {
+		return "", "", false
+	}
+	return kind, resourceID, true
+}
+
+func resourceKey(kind, resourceID string) string {
+	return kind + ":" + resourceID
+}
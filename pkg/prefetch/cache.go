@@ -0,0 +1,245 @@
+package prefetch
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/m3u8"
+	"live-video/pkg/vod"
+)
+
+// Defaults of a cache
+const (
+	DefaultAhead    = 2
+	DefaultPartSize = 2 << 20 // bytes fetched per request of a large segment
+	entryTTL        = 10 * time.Minute
+	maxFetches      = 8 // prefetches running at once
+)
+
+// Fetcher reads an object in parts of partSize bytes
+type Fetcher func(ctx context.Context, objectPath string, partSize int64) ([]byte, error)
+
+// Cache keeps HLS segments read from storage in memory, up to a byte budget,
+// and prefetches the segments after each one a player asks for, so players
+// fetching segments just in time don't wait on storage latency. What comes
+// next is taken from the latest state of each playlist served through it.
+type Cache struct {
+	fetch    Fetcher
+	maxBytes int64
+	ahead    int
+	partSize int64
+	slots    chan struct{}
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lru       *list.List // most recently used first
+	size      int64
+	playlists map[string]playlistState
+	following map[string][]string // segments after a segment, by segment path
+	stats     Stats
+}
+
+// playlistState is the last seen state of a media playlist
+type playlistState struct {
+	digest   [sha256.Size]byte
+	segments []string // object paths, in playlist order
+}
+
+type entry struct {
+	path      string
+	data      []byte
+	err       error
+	ready     chan struct{} // closed once data or err is set
+	fetchedAt time.Time
+	elem      *list.Element
+	prefetch  bool // fetched ahead and not requested yet
+}
+
+// Stats counts how segment requests were served
+type Stats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Prefetched    uint64 `json:"prefetched"`
+	PrefetchHits  uint64 `json:"prefetch_hits"` // requests served by a prefetch, done or in flight
+	Evictions     uint64 `json:"evictions"`
+	Bytes         int64  `json:"bytes"`
+	Segments      int    `json:"segments"`
+	PrefetchAhead int    `json:"prefetch_ahead"`
+}
+
+// NewCache creates a cache of at most maxBytes prefetching ahead segments
+// after each requested one
+func NewCache(fetch Fetcher, maxBytes int64, ahead int) *Cache {
+	return &Cache{
+		fetch:     fetch,
+		maxBytes:  maxBytes,
+		ahead:     ahead,
+		partSize:  DefaultPartSize,
+		slots:     make(chan struct{}, maxFetches),
+		entries:   make(map[string]*entry),
+		lru:       list.New(),
+		playlists: make(map[string]playlistState),
+		following: make(map[string][]string),
+	}
+}
+
+// ObservePlaylist records the segment order of a media playlist at
+// playlistPath as it is served. Segment URIs are resolved against the
+// playlist's directory; master playlists list no segments and are ignored.
+func (c *Cache) ObservePlaylist(playlistPath string, data []byte) {
+	if m3u8.IsMaster(data) {
+		return
+	}
+	digest := sha256.Sum256(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous, ok := c.playlists[playlistPath]
+	if ok && previous.digest == digest {
+		return
+	}
+	for _, p := range previous.segments {
+		delete(c.following, p)
+	}
+
+	segments, _ := vod.Segments(data)
+	dir := path.Dir(playlistPath)
+	paths := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		if strings.Contains(segment.URI, "://") || path.IsAbs(segment.URI) {
+			continue
+		}
+		paths = append(paths, path.Join(dir, segment.URI))
+	}
+	for i, p := range paths {
+		c.following[p] = paths[i+1 : min(i+1+c.ahead, len(paths))]
+	}
+	c.playlists[playlistPath] = playlistState{digest: digest, segments: paths}
+}
+
+// Get returns a segment, from memory when it is cached or being prefetched,
+// and prefetches the segments after it. hit is set when storage wasn't
+// waited on from scratch.
+func (c *Cache) Get(segmentPath string) (data []byte, hit bool, err error) {
+	c.mu.Lock()
+	e, ok := c.entries[segmentPath]
+	if ok && e.isStale() {
+		c.remove(e)
+		ok = false
+	}
+	if ok {
+		c.stats.Hits++
+		if e.prefetch {
+			e.prefetch = false
+			c.stats.PrefetchHits++
+		}
+		c.lru.MoveToFront(e.elem)
+	} else {
+		c.stats.Misses++
+		e = c.add(segmentPath, false)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		c.load(e)
+	}
+	c.prefetchAfter(segmentPath)
+
+	<-e.ready
+	return e.data, ok, e.err
+}
+
+// Stats returns the counters of the cache
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Bytes = c.size
+	stats.Segments = len(c.entries)
+	stats.PrefetchAhead = c.ahead
+	return stats
+}
+
+// prefetchAfter starts fetching the segments after segmentPath that aren't
+// cached. Prefetches beyond maxFetches running at once are skipped.
+func (c *Cache) prefetchAfter(segmentPath string) {
+	c.mu.Lock()
+	var queued []*entry
+	for _, next := range c.following[segmentPath] {
+		if e, ok := c.entries[next]; ok && !e.isStale() {
+			continue
+		} else if ok {
+			c.remove(e)
+		}
+		select {
+		case c.slots <- struct{}{}:
+		default:
+			continue
+		}
+		c.stats.Prefetched++
+		queued = append(queued, c.add(next, true))
+	}
+	c.mu.Unlock()
+
+	for _, e := range queued {
+		go func(e *entry) {
+			defer func() { <-c.slots }()
+			c.load(e)
+		}(e)
+	}
+}
+
+// add registers an entry being fetched. Callers hold c.mu.
+func (c *Cache) add(segmentPath string, prefetch bool) *entry {
+	e := &entry{path: segmentPath, ready: make(chan struct{}), prefetch: prefetch}
+	e.elem = c.lru.PushFront(e)
+	c.entries[segmentPath] = e
+	return e
+}
+
+// load fetches an entry's segment and evicts the least recently used
+// segments over the budget. Failed fetches aren't kept. Fetches aren't tied
+// to the request that started them, as other requests wait on them.
+func (c *Cache) load(e *entry) {
+	data, err := c.fetch(context.Background(), e.path, c.partSize)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.data, e.err, e.fetchedAt = data, err, time.Now()
+	close(e.ready)
+	if c.entries[e.path] != e {
+		return
+	}
+	if err != nil {
+		c.remove(e)
+		return
+	}
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		oldest := c.lru.Back().Value.(*entry)
+		c.remove(oldest)
+		c.stats.Evictions++
+		if oldest == e {
+			break
+		}
+	}
+}
+
+// remove forgets an entry. Callers hold c.mu.
+func (c *Cache) remove(e *entry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.path)
+	if !e.fetchedAt.IsZero() && e.err == nil {
+		c.size -= int64(len(e.data))
+	}
+}
+
+// isStale reports whether a fetched entry is past its TTL. Callers hold c.mu.
+func (e *entry) isStale() bool {
+	return !e.fetchedAt.IsZero() && time.Since(e.fetchedAt) > entryTTL
+}
@@ -0,0 +1,242 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Status of a preview
+type Status string
+
+const (
+	StatusQueued     Status = "queued"
+	StatusProcessing Status = "processing"
+	StatusCompleted  Status = "completed"
+	StatusFailed     Status = "failed"
+)
+
+// Formats
+const (
+	FormatGIF  = "gif"
+	FormatWebM = "webm"
+)
+
+// Limits and defaults of a preview
+const (
+	DefaultDuration = 3.0
+	MaxDuration     = 10.0
+	DefaultWidth    = 480
+	MaxWidth        = 1280
+	DefaultFPS      = 12
+	MaxFPS          = 30
+)
+
+// retention is how long finished previews are tracked
+const retention = 24 * time.Hour
+
+// ContentTypes maps preview formats to their content type
+var ContentTypes = map[string]string{
+	FormatGIF:  "image/gif",
+	FormatWebM: "video/webm",
+}
+
+// Spec describes a preview clip. Start is in seconds from the start of the
+// source; for live streams it is from the start of the live window.
+type Spec struct {
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+	Width    int     `json:"width"`
+	FPS      int     `json:"fps"`
+	Format   string  `json:"format"`
+}
+
+// Normalize fills in defaults and validates the spec
+func (s *Spec) Normalize() error {
+	if s.Format == "" {
+		s.Format = FormatGIF
+	}
+	if _, ok := ContentTypes[s.Format]; !ok {
+		return fmt.Errorf("format must be %s or %s", FormatGIF, FormatWebM)
+	}
+	if s.Duration == 0 {
+		s.Duration = DefaultDuration
+	}
+	if s.Duration < 0 || s.Duration > MaxDuration {
+		return fmt.Errorf("duration must be between 0 and %g seconds", MaxDuration)
+	}
+	if s.Width == 0 {
+		s.Width = DefaultWidth
+	}
+	if s.Width < 16 || s.Width > MaxWidth {
+		return fmt.Errorf("width must be between 16 and %d", MaxWidth)
+	}
+	if s.FPS == 0 {
+		s.FPS = DefaultFPS
+	}
+	if s.FPS < 1 || s.FPS > MaxFPS {
+		return fmt.Errorf("fps must be between 1 and %d", MaxFPS)
+	}
+	if s.Start < 0 {
+		return fmt.Errorf("start must not be negative")
+	}
+	return nil
+}
+
+// Preview tracks the generation of a preview clip
+type Preview struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"` // "video" or "stream"
+	AssetID     string     `json:"asset_id"`
+	Spec        Spec       `json:"spec"`
+	Status      Status     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	GCSPath     string     `json:"gcs_path,omitempty"`
+	Size        int64      `json:"size,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Tracker keeps previews in memory and limits how many render at once
+type Tracker struct {
+	mu       sync.RWMutex
+	previews map[string]*Preview
+	slots    chan struct{}
+}
+
+// NewTracker creates a tracker rendering at most concurrency previews at a time
+func NewTracker(concurrency int) *Tracker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Tracker{
+		previews: make(map[string]*Preview),
+		slots:    make(chan struct{}, concurrency),
+	}
+}
+
+// Create registers a queued preview
+func (t *Tracker) Create(kind, assetID string, spec Spec) *Preview {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune()
+	p := &Preview{
+		ID:        uuid.New().String(),
+		Kind:      kind,
+		AssetID:   assetID,
+		Spec:      spec,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+	}
+	t.previews[p.ID] = p
+	snapshot := *p
+	return &snapshot
+}
+
+// Get returns a snapshot of a preview
+func (t *Tracker) Get(id string) (*Preview, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	p, ok := t.previews[id]
+	if !ok {
+		return nil, fmt.Errorf("preview not found: %s", id)
+	}
+	snapshot := *p
+	return &snapshot, nil
+}
+
+// Run waits for a free slot, marks the preview processing and runs render.
+// render returns the GCS path and size of the stored clip.
+func (t *Tracker) Run(id string, render func(p Preview) (string, int64, error)) {
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+
+	p, err := t.Get(id)
+	if err != nil {
+		return
+	}
+	t.update(id, func(p *Preview) { p.Status = StatusProcessing })
+
+	gcsPath, size, err := render(*p)
+	t.update(id, func(p *Preview) {
+		now := time.Now()
+		p.CompletedAt = &now
+		if err != nil {
+			p.Status = StatusFailed
+			p.Error = err.Error()
+			return
+		}
+		p.Status = StatusCompleted
+		p.GCSPath = gcsPath
+		p.Size = size
+	})
+}
+
+func (t *Tracker) update(id string, fn func(p *Preview)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.previews[id]; ok {
+		fn(p)
+	}
+}
+
+// prune forgets previews that finished more than retention ago. Callers
+// hold t.mu.
+func (t *Tracker) prune() {
+	for id, p := range t.previews {
+		if p.CompletedAt != nil && time.Since(*p.CompletedAt) > retention {
+			delete(t.previews, id)
+		}
+	}
+}
+
+// Render encodes the clip described by spec from inputPath, starting offset
+// seconds into it, to outputPath. GIFs get a palette generated from the clip
+// and loop forever; WebM clips are VP9 without audio.
+func Render(ctx context.Context, inputPath string, offset float64, spec Spec, outputPath string) error {
+	args := []string{"-y", "-hide_banner", "-loglevel", "error",
+		"-ss", strconv.FormatFloat(offset, 'f', 3, 64),
+		"-t", strconv.FormatFloat(spec.Duration, 'f', 3, 64),
+		"-i", inputPath,
+		"-an",
+	}
+	scale := fmt.Sprintf("fps=%d,scale=%d:-2:flags=lanczos", spec.FPS, spec.Width)
+	switch spec.Format {
+	case FormatGIF:
+		args = append(args,
+			"-filter_complex", scale+",split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=bayer",
+			"-loop", "0",
+			"-f", "gif",
+		)
+	case FormatWebM:
+		args = append(args,
+			"-vf", scale,
+			"-c:v", "libvpx-vp9", "-b:v", "0", "-crf", "40",
+			"-deadline", "good", "-row-mt", "1",
+			"-f", "webm",
+		)
+	default:
+		return fmt.Errorf("unsupported format %s", spec.Format)
+	}
+
+	tmpPath := outputPath + ".tmp"
+	args = append(args, tmpPath)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return os.Rename(tmpPath, outputPath)
+}
@@ -0,0 +1,146 @@
+package qoe
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// minSampleBytes is the smallest transfer counted; smaller ones say more
+	// about latency than about bandwidth
+	minSampleBytes = 16 << 10
+
+	// Half-lives of the fast and slow averages, in seconds of transfer time
+	fastHalfLife = 3.0
+	slowHalfLife = 9.0
+
+	// sustainableShare is the share of the estimate a player can rely on
+	sustainableShare = 0.8
+
+	// bandwidthSessionTTL is how long a session without transfers is kept
+	bandwidthSessionTTL = 5 * time.Minute
+
+	// maxBandwidthSessions bounds the sessions tracked at once
+	maxBandwidthSessions = 100000
+)
+
+// BandwidthEstimate is the download rate observed for a playback session
+type BandwidthEstimate struct {
+	EstimatedKbps   int       `json:"estimated_kbps"`   // lower of the fast and slow averages
+	SustainableKbps int       `json:"sustainable_kbps"` // what a player should pick renditions by
+	Samples         int       `json:"samples"`
+	Bytes           int64     `json:"bytes"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Bandwidth estimates the download rate of playback sessions from the
+// transfers the server makes to them. Each transfer is weighted by how long
+// it took, so short bursts into socket buffers barely move the estimate.
+type Bandwidth struct {
+	mu        sync.Mutex
+	sessions  map[string]*bandwidthSession
+	lastPrune time.Time
+}
+
+// bandwidthSession holds the averages of one session
+type bandwidthSession struct {
+	fast, slow ewma
+	samples    int
+	bytes      int64
+	updatedAt  time.Time
+}
+
+// NewBandwidth creates an empty bandwidth estimator
+func NewBandwidth() *Bandwidth {
+	return &Bandwidth{
+		sessions:  make(map[string]*bandwidthSession),
+		lastPrune: time.Now(),
+	}
+}
+
+// Observe records that n bytes were sent to a session in elapsed. A nil
+// estimator ignores it.
+func (b *Bandwidth) Observe(sessionID string, n int64, elapsed time.Duration) {
+	if b == nil || sessionID == "" || n < minSampleBytes {
+		return
+	}
+	if elapsed < time.Millisecond {
+		elapsed = time.Millisecond
+	}
+	seconds := elapsed.Seconds()
+	bps := float64(n) * 8 / seconds
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Sub(b.lastPrune) > time.Minute {
+		for id, s := range b.sessions {
+			if now.Sub(s.updatedAt) > bandwidthSessionTTL {
+				delete(b.sessions, id)
+			}
+		}
+		b.lastPrune = now
+	}
+
+	s, ok := b.sessions[sessionID]
+	if !ok {
+		if len(b.sessions) >= maxBandwidthSessions {
+			return
+		}
+		s = &bandwidthSession{
+			fast: ewma{halfLife: fastHalfLife},
+			slow: ewma{halfLife: slowHalfLife},
+		}
+		b.sessions[sessionID] = s
+	}
+	s.fast.sample(seconds, bps)
+	s.slow.sample(seconds, bps)
+	s.samples++
+	s.bytes += n
+	s.updatedAt = now
+}
+
+// Estimate returns the estimate of a session, if it has one
+func (b *Bandwidth) Estimate(sessionID string) (BandwidthEstimate, bool) {
+	if b == nil {
+		return BandwidthEstimate{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.sessions[sessionID]
+	if !ok || time.Since(s.updatedAt) > bandwidthSessionTTL {
+		return BandwidthEstimate{}, false
+	}
+
+	bps := math.Min(s.fast.value(), s.slow.value())
+	return BandwidthEstimate{
+		EstimatedKbps:   int(bps / 1000),
+		SustainableKbps: int(bps * sustainableShare / 1000),
+		Samples:         s.samples,
+		Bytes:           s.bytes,
+		UpdatedAt:       s.updatedAt,
+	}, true
+}
+
+// ewma is an exponentially weighted moving average whose samples carry a
+// weight, corrected for its zero start
+type ewma struct {
+	halfLife    float64
+	estimate    float64
+	totalWeight float64
+}
+
+func (e *ewma) sample(weight, value float64) {
+	alpha := math.Pow(0.5, weight/e.halfLife)
+	e.estimate = value*(1-alpha) + e.estimate*alpha
+	e.totalWeight += weight
+}
+
+func (e *ewma) value() float64 {
+	zeroFactor := 1 - math.Pow(0.5, e.totalWeight/e.halfLife)
+	if zeroFactor <= 0 {
+		return 0
+	}
+	return e.estimate / zeroFactor
+}
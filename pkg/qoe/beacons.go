@@ -0,0 +1,230 @@
+package qoe
+
+import (
+	"sync"
+	"time"
+
+	"live-video/pkg/events"
+)
+
+// maxRecentBeacons is how many raw beacons are kept for inspection
+const maxRecentBeacons = 1000
+
+// Beacon is a periodic playback quality report from a viewer. Counters are
+// deltas since the session's previous beacon.
+type Beacon struct {
+	SessionID     string `json:"session_id"`
+	StreamID      string `json:"stream_id"`
+	StartupMs     int64  `json:"startup_ms"` // time to first frame, sent once
+	WatchMs       int64  `json:"watch_ms"`
+	RebufferMs    int64  `json:"rebuffer_ms"`
+	RebufferCount int    `json:"rebuffer_count"`
+	BitrateKbps   int    `json:"bitrate_kbps"`
+	LatencyMs     int64  `json:"latency_ms"` // distance behind the live edge
+	Errors        int    `json:"errors"`
+	// Where playback is in a video or replay, for viewers to resume it.
+	// Live players leave them out.
+	PositionMs int64 `json:"position_ms,omitempty"`
+	DurationMs int64 `json:"duration_ms,omitempty"`
+
+	// Filled in by the server
+	Cohorts    map[string]string `json:"cohorts,omitempty"` // experiment ID -> variant
+	ReceivedAt time.Time         `json:"received_at"`
+}
+
+// VariantResult aggregates the beacons of one cohort
+type VariantResult struct {
+	Variant          string  `json:"variant"`
+	Sessions         int     `json:"sessions"`
+	Beacons          int     `json:"beacons"`
+	WatchSeconds     float64 `json:"watch_seconds"`
+	AvgStartupMs     float64 `json:"avg_startup_ms"`
+	RebufferRatio    float64 `json:"rebuffer_ratio"`
+	RebuffersPerHour float64 `json:"rebuffers_per_hour"`
+	AvgBitrateKbps   float64 `json:"avg_bitrate_kbps"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+	Errors           int     `json:"errors"`
+}
+
+// cohortStats accumulates beacons for one experiment variant
+type cohortStats struct {
+	sessions      map[string]bool
+	beacons       int
+	watchMs       int64
+	rebufferMs    int64
+	rebufferCount int
+	errors        int
+	startupSum    int64
+	startupCount  int
+	bitrateSum    int64
+	bitrateCount  int
+	latencySum    int64
+	latencyCount  int
+}
+
+// Collector records QoE beacons and aggregates them per experiment cohort
+type Collector struct {
+	mu      sync.Mutex
+	cohorts map[string]map[string]*cohortStats // experiment ID -> variant -> stats
+	recent  []Beacon
+	events  *events.Bus
+}
+
+// NewCollector creates an empty beacon collector
+func NewCollector() *Collector {
+	return &Collector{
+		cohorts: make(map[string]map[string]*cohortStats),
+	}
+}
+
+// SetEvents publishes every recorded beacon on bus, for analytics consumers
+func (c *Collector) SetEvents(bus *events.Bus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = bus
+}
+
+// Record stores a beacon tagged with the session's cohorts
+func (c *Collector) Record(beacon Beacon, assignments []Assignment) Beacon {
+	beacon.ReceivedAt = time.Now()
+	beacon.Cohorts = nil
+	if len(assignments) > 0 {
+		beacon.Cohorts = make(map[string]string, len(assignments))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, a := range assignments {
+		beacon.Cohorts[a.ExperimentID] = a.Variant.Name
+
+		variants, ok := c.cohorts[a.ExperimentID]
+		if !ok {
+			variants = make(map[string]*cohortStats)
+			c.cohorts[a.ExperimentID] = variants
+		}
+		stats, ok := variants[a.Variant.Name]
+		if !ok {
+			stats = &cohortStats{sessions: make(map[string]bool)}
+			variants[a.Variant.Name] = stats
+		}
+		stats.add(beacon)
+	}
+
+	c.recent = append(c.recent, beacon)
+	if len(c.recent) > maxRecentBeacons {
+		c.recent = c.recent[len(c.recent)-maxRecentBeacons:]
+	}
+	c.events.Publish(events.QoEBeacon, map[string]any{
+		"session_id":     beacon.SessionID,
+		"stream_id":      beacon.StreamID,
+		"startup_ms":     beacon.StartupMs,
+		"watch_ms":       beacon.WatchMs,
+		"rebuffer_ms":    beacon.RebufferMs,
+		"rebuffer_count": beacon.RebufferCount,
+		"bitrate_kbps":   beacon.BitrateKbps,
+		"latency_ms":     beacon.LatencyMs,
+		"errors":         beacon.Errors,
+		"cohorts":        beacon.Cohorts,
+		"received_at":    beacon.ReceivedAt,
+	})
+	return beacon
+}
+
+// Recent returns the latest beacons, optionally only those of one stream
+func (c *Collector) Recent(streamID string, limit int) []Beacon {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	beacons := make([]Beacon, 0, limit)
+	for i := len(c.recent) - 1; i >= 0 && len(beacons) < limit; i-- {
+		if streamID == "" || c.recent[i].StreamID == streamID {
+			beacons = append(beacons, c.recent[i])
+		}
+	}
+	return beacons
+}
+
+// ForgetStream drops the recent beacons of a stream or video and returns
+// how many it dropped. Cohort aggregates keep no reference to it.
+func (c *Collector) ForgetStream(streamID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	kept := c.recent[:0]
+	for _, beacon := range c.recent {
+		if beacon.StreamID != streamID {
+			kept = append(kept, beacon)
+		}
+	}
+	dropped := len(c.recent) - len(kept)
+	clear(c.recent[len(kept):])
+	c.recent = kept
+	return dropped
+}
+
+// Results returns the per-variant aggregates of an experiment
+func (c *Collector) Results(experiment *Experiment) []VariantResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := make([]VariantResult, 0, len(experiment.Variants))
+	for _, v := range experiment.Variants {
+		result := VariantResult{Variant: v.Name}
+		if stats, ok := c.cohorts[experiment.ID][v.Name]; ok {
+			stats.fill(&result)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Forget drops the aggregates of a deleted experiment
+func (c *Collector) Forget(experimentID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cohorts, experimentID)
+}
+
+func (s *cohortStats) add(b Beacon) {
+	s.sessions[b.SessionID] = true
+	s.beacons++
+	s.watchMs += b.WatchMs
+	s.rebufferMs += b.RebufferMs
+	s.rebufferCount += b.RebufferCount
+	s.errors += b.Errors
+	if b.StartupMs > 0 {
+		s.startupSum += b.StartupMs
+		s.startupCount++
+	}
+	if b.BitrateKbps > 0 {
+		s.bitrateSum += int64(b.BitrateKbps)
+		s.bitrateCount++
+	}
+	if b.LatencyMs > 0 {
+		s.latencySum += b.LatencyMs
+		s.latencyCount++
+	}
+}
+
+func (s *cohortStats) fill(r *VariantResult) {
+	r.Sessions = len(s.sessions)
+	r.Beacons = s.beacons
+	r.WatchSeconds = float64(s.watchMs) / 1000
+	r.Errors = s.errors
+	if s.startupCount > 0 {
+		r.AvgStartupMs = float64(s.startupSum) / float64(s.startupCount)
+	}
+	if total := s.watchMs + s.rebufferMs; total > 0 {
+		r.RebufferRatio = float64(s.rebufferMs) / float64(total)
+	}
+	if s.watchMs > 0 {
+		r.RebuffersPerHour = float64(s.rebufferCount) / (float64(s.watchMs) / float64(time.Hour/time.Millisecond))
+	}
+	if s.bitrateCount > 0 {
+		r.AvgBitrateKbps = float64(s.bitrateSum) / float64(s.bitrateCount)
+	}
+	if s.latencyCount > 0 {
+		r.AvgLatencyMs = float64(s.latencySum) / float64(s.latencyCount)
+	}
+}
@@ -0,0 +1,205 @@
+package qoe
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Variant is one cohort of an experiment and the playback settings its
+// sessions get
+type Variant struct {
+	Name       string   `json:"name"`
+	Percent    int      `json:"percent"`               // share of sessions placed in this cohort
+	LowLatency *bool    `json:"low_latency,omitempty"` // LL-HLS player settings
+	Ladder     []string `json:"ladder,omitempty"`      // rendition names offered, e.g. ["720p", "480p"]
+}
+
+// Experiment splits playback sessions into cohorts. Sessions not drawn into
+// any variant play with the default settings and are not recorded.
+type Experiment struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	StreamID  string     `json:"stream_id,omitempty"` // empty applies to every stream
+	Variants  []Variant  `json:"variants"`
+	Active    bool       `json:"active"`
+	CreatedAt time.Time  `json:"created_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// Assignment places a session in a variant of an experiment
+type Assignment struct {
+	ExperimentID string  `json:"experiment_id"`
+	Variant      Variant `json:"variant"`
+}
+
+// PlayerConfig is the playback configuration resulting from a session's cohorts
+type PlayerConfig struct {
+	LowLatency *bool    `json:"low_latency,omitempty"` // unset keeps the player default
+	Ladder     []string `json:"ladder,omitempty"`
+}
+
+// Experiments keeps the experiment definitions in memory
+type Experiments struct {
+	mu          sync.RWMutex
+	experiments map[string]*Experiment
+}
+
+// NewExperiments creates an empty experiment registry
+func NewExperiments() *Experiments {
+	return &Experiments{
+		experiments: make(map[string]*Experiment),
+	}
+}
+
+// Create validates and registers a new active experiment
+func (e *Experiments) Create(name, streamID string, variants []Variant) (*Experiment, error) {
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("experiment needs at least one variant")
+	}
+
+	total := 0
+	seen := make(map[string]bool, len(variants))
+	for _, v := range variants {
+		if v.Name == "" || seen[v.Name] {
+			return nil, fmt.Errorf("variant names must be unique and non-empty")
+		}
+		if v.Percent <= 0 {
+			return nil, fmt.Errorf("variant %s: percent must be positive", v.Name)
+		}
+		seen[v.Name] = true
+		total += v.Percent
+	}
+	if total > 100 {
+		return nil, fmt.Errorf("variant percentages add up to %d, more than 100", total)
+	}
+
+	experiment := &Experiment{
+		ID:        uuid.New().String(),
+		Name:      name,
+		StreamID:  streamID,
+		Variants:  variants,
+		Active:    true,
+		CreatedAt: time.Now(),
+	}
+
+	e.mu.Lock()
+	e.experiments[experiment.ID] = experiment
+	e.mu.Unlock()
+	return experiment.copy(), nil
+}
+
+// Get returns a snapshot of an experiment
+func (e *Experiments) Get(id string) (*Experiment, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	experiment, exists := e.experiments[id]
+	if !exists {
+		return nil, fmt.Errorf("experiment not found: %s", id)
+	}
+	return experiment.copy(), nil
+}
+
+// List returns all experiments, newest first
+func (e *Experiments) List() []*Experiment {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	list := make([]*Experiment, 0, len(e.experiments))
+	for _, experiment := range e.experiments {
+		list = append(list, experiment.copy())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// End stops assigning sessions to an experiment. Its results are kept.
+func (e *Experiments) End(id string) (*Experiment, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	experiment, exists := e.experiments[id]
+	if !exists {
+		return nil, fmt.Errorf("experiment not found: %s", id)
+	}
+	if experiment.Active {
+		now := time.Now()
+		experiment.Active = false
+		experiment.EndedAt = &now
+	}
+	return experiment.copy(), nil
+}
+
+// Delete removes an experiment
+func (e *Experiments) Delete(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, exists := e.experiments[id]; !exists {
+		return fmt.Errorf("experiment not found: %s", id)
+	}
+	delete(e.experiments, id)
+	return nil
+}
+
+// Assign returns the cohorts of a playback session on a stream. Assignment
+// is a hash of the session and experiment IDs, so a session always lands in
+// the same cohort and beacons can be attributed without storing sessions.
+func (e *Experiments) Assign(sessionID, streamID string) []Assignment {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var assignments []Assignment
+	for _, experiment := range e.experiments {
+		if !experiment.Active || (experiment.StreamID != "" && experiment.StreamID != streamID) {
+			continue
+		}
+		if variant, ok := experiment.pick(sessionID); ok {
+			assignments = append(assignments, Assignment{ExperimentID: experiment.ID, Variant: variant})
+		}
+	}
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].ExperimentID < assignments[j].ExperimentID })
+	return assignments
+}
+
+// PlayerConfigFor merges the playback settings of a session's cohorts. When
+// experiments disagree, the first assignment wins.
+func PlayerConfigFor(assignments []Assignment) PlayerConfig {
+	var config PlayerConfig
+	for _, a := range assignments {
+		if a.Variant.LowLatency != nil && config.LowLatency == nil {
+			lowLatency := *a.Variant.LowLatency
+			config.LowLatency = &lowLatency
+		}
+		if len(a.Variant.Ladder) > 0 && config.Ladder == nil {
+			config.Ladder = append([]string(nil), a.Variant.Ladder...)
+		}
+	}
+	return config
+}
+
+// pick maps a session to one of the variants, or none
+func (x *Experiment) pick(sessionID string) (Variant, bool) {
+	h := fnv.New32a()
+	h.Write([]byte(x.ID + ":" + sessionID))
+	bucket := int(h.Sum32() % 100)
+
+	for _, v := range x.Variants {
+		if bucket < v.Percent {
+			return v, true
+		}
+		bucket -= v.Percent
+	}
+	return Variant{}, false
+}
+
+func (x *Experiment) copy() *Experiment {
+	c := *x
+	c.Variants = append([]Variant(nil), x.Variants...)
+	return &c
+}
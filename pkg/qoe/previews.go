@@ -0,0 +1,100 @@
+package qoe
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// previewSessionTTL is how long an unused preview session stays flagged
+	previewSessionTTL = 12 * time.Hour
+
+	// maxPreviewSessions bounds the preview sessions flagged at once
+	maxPreviewSessions = 10000
+)
+
+// Previews flags the playback sessions of operators and moderation tools
+// previewing streams. Flagged sessions play like any other, but are left out
+// of viewer counts, audiences, watch histories, experiments and beacons.
+type Previews struct {
+	mu        sync.Mutex
+	sessions  map[string]*previewSession
+	lastPrune time.Time
+}
+
+// previewSession is a flagged session of one stream
+type previewSession struct {
+	streamID string
+	usedAt   time.Time
+}
+
+// NewPreviews creates an empty set of preview sessions
+func NewPreviews() *Previews {
+	return &Previews{
+		sessions:  make(map[string]*previewSession),
+		lastPrune: time.Now(),
+	}
+}
+
+// Begin flags a new preview session of a stream and returns its ID
+func (p *Previews) Begin(streamID string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.lastPrune) >= time.Minute || len(p.sessions) >= maxPreviewSessions {
+		p.prune(now)
+	}
+	id := uuid.New().String()
+	p.sessions[id] = &previewSession{streamID: streamID, usedAt: now}
+	return id
+}
+
+// Flagged reports whether sessionID is a preview session, of any stream
+func (p *Previews) Flagged(sessionID string) bool {
+	_, ok := p.stream(sessionID)
+	return ok
+}
+
+// Of reports whether sessionID is a preview session of streamID
+func (p *Previews) Of(sessionID, streamID string) bool {
+	id, ok := p.stream(sessionID)
+	return ok && id == streamID
+}
+
+// stream returns the stream of a preview session and keeps it flagged
+func (p *Previews) stream(sessionID string) (string, bool) {
+	if p == nil || sessionID == "" {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	session, ok := p.sessions[sessionID]
+	if !ok || time.Since(session.usedAt) >= previewSessionTTL {
+		return "", false
+	}
+	session.usedAt = time.Now()
+	return session.streamID, true
+}
+
+// prune drops expired sessions, and the least recently used ones while
+// there are too many. Callers hold p.mu.
+func (p *Previews) prune(now time.Time) {
+	p.lastPrune = now
+	for id, session := range p.sessions {
+		if now.Sub(session.usedAt) >= previewSessionTTL {
+			delete(p.sessions, id)
+		}
+	}
+	for len(p.sessions) >= maxPreviewSessions {
+		var oldest string
+		for id, session := range p.sessions {
+			if oldest == "" || session.usedAt.Before(p.sessions[oldest].usedAt) {
+				oldest = id
+			}
+		}
+		delete(p.sessions, oldest)
+	}
+}
@@ -0,0 +1,177 @@
+// Package region picks the ingest region nearest a broadcaster. Each region
+// runs replicas of the server sharing the bucket and event bus, so streams
+// are managed from any of them while their contribution lands nearby.
+package region
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"live-video/pkg/geoip"
+)
+
+// How a region was selected
+const (
+	ByRequest = "requested" // the broadcaster named it
+	ByRTT     = "rtt"       // lowest round trip time the broadcaster probed
+	ByCountry = "country"   // it serves the broadcaster's country
+	ByDefault = "default"   // nothing else matched
+)
+
+// Region is where a region's broadcasters send their streams
+type Region struct {
+	Name       string   `json:"name"`
+	APIBaseURL string   `json:"api_base_url"` // WebRTC offers go to the region's replicas here
+	Countries  []string `json:"countries,omitempty"`
+	// ProbeURL is what broadcasters time to measure their round trip to the
+	// region, /health of its API when empty
+	ProbeURL string `json:"probe_url,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+}
+
+// Selection is the region picked for a broadcaster
+type Selection struct {
+	Region  *Region
+	By      string
+	Country string // broadcaster's country, empty when unknown
+}
+
+// Selector picks ingest regions by the round trip times broadcasters probed,
+// else by their country
+type Selector struct {
+	regions   []*Region
+	byCountry map[string]*Region
+	fallback  *Region
+	locator   *geoip.Locator
+}
+
+// Load reads region configurations from a JSON file
+func Load(path string) ([]Region, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ingest regions: %w", err)
+	}
+
+	var regions []Region
+	if err := json.Unmarshal(data, &regions); err != nil {
+		return nil, fmt.Errorf("failed to parse ingest regions: %w", err)
+	}
+	return regions, nil
+}
+
+// NewSelector creates a selector of regions, locating broadcasters with
+// locator. A country belongs to one region; broadcasters from countries of
+// none go to the default region, the first when none is marked.
+func NewSelector(regions []Region, locator *geoip.Locator) (*Selector, error) {
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("no ingest regions")
+	}
+
+	s := &Selector{byCountry: make(map[string]*Region), locator: locator}
+	names := make(map[string]bool)
+	for i := range regions {
+		r := regions[i]
+		if r.Name == "" || r.APIBaseURL == "" {
+			return nil, fmt.Errorf("ingest region %d needs a name and api_base_url", i)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate ingest region %q", r.Name)
+		}
+		names[r.Name] = true
+		r.APIBaseURL = strings.TrimSuffix(r.APIBaseURL, "/")
+		if r.ProbeURL == "" {
+			r.ProbeURL = r.APIBaseURL + "/health"
+		}
+
+		countries := r.Countries
+		r.Countries = nil
+		for _, country := range countries {
+			country = strings.ToUpper(strings.TrimSpace(country))
+			if len(country) != 2 {
+				return nil, fmt.Errorf("ingest region %q: invalid country %q", r.Name, country)
+			}
+			if other, ok := s.byCountry[country]; ok {
+				return nil, fmt.Errorf("country %s is in ingest regions %q and %q", country, other.Name, r.Name)
+			}
+			r.Countries = append(r.Countries, country)
+			s.byCountry[country] = &r
+		}
+		if r.Default {
+			if s.fallback != nil {
+				return nil, fmt.Errorf("ingest regions %q and %q are both the default", s.fallback.Name, r.Name)
+			}
+			s.fallback = &r
+		}
+		s.regions = append(s.regions, &r)
+	}
+	if s.fallback == nil {
+		s.fallback = s.regions[0]
+	}
+	return s, nil
+}
+
+// Regions returns the regions, in the order configured
+func (s *Selector) Regions() []Region {
+	regions := make([]Region, len(s.regions))
+	for i, r := range s.regions {
+		regions[i] = *r
+	}
+	return regions
+}
+
+// Get returns a region by name
+func (s *Selector) Get(name string) (*Region, bool) {
+	for _, r := range s.regions {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Select picks the region of the broadcaster sending r: the region with the
+// lowest round trip time in rtts (milliseconds by region name) when it has
+// any, else the one serving its country, else the default
+func (s *Selector) Select(r *http.Request, clientIP string, rtts map[string]int) Selection {
+	country := s.locator.Locate(r, clientIP).Country
+
+	var nearest *Region
+	best := 0
+	for _, region := range s.regions {
+		if rtt, ok := rtts[region.Name]; ok && rtt >= 0 && (nearest == nil || rtt < best) {
+			nearest, best = region, rtt
+		}
+	}
+	if nearest != nil {
+		return Selection{Region: nearest, By: ByRTT, Country: country}
+	}
+	if region, ok := s.byCountry[country]; ok {
+		return Selection{Region: region, By: ByCountry, Country: country}
+	}
+	return Selection{Region: s.fallback, By: ByDefault, Country: country}
+}
+
+// ParseRTTs parses round trip times as name:ms pairs separated by commas,
+// e.g. "eu-west:38,us-east:112"
+func ParseRTTs(value string) (map[string]int, error) {
+	rtts := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, ms, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid round trip time %q, use region:ms", pair)
+		}
+		rtt, err := strconv.Atoi(strings.TrimSpace(ms))
+		if err != nil || rtt < 0 {
+			return nil, fmt.Errorf("invalid round trip time %q, use region:ms", pair)
+		}
+		rtts[strings.TrimSpace(name)] = rtt
+	}
+	return rtts, nil
+}
@@ -0,0 +1,288 @@
+// Package revocation revokes embed tokens before they expire, e.g. after a
+// refund or a ban. Revocations are kept in the bucket, shared by all
+// replicas, and announced on the event bus, so every replica rejects a
+// revoked token within moments, or within the sync interval should an
+// announcement be lost.
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/auth"
+	"live-video/pkg/background"
+	"live-video/pkg/events"
+	"live-video/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// Prefix is where revocations are kept in the bucket, outside the layout
+// prefixes so no lifecycle rule removes them
+const Prefix = "revocations"
+
+// ErrNotFound is returned for revocations that don't exist
+var ErrNotFound = errors.New("revocation not found")
+
+// Revocation revokes one token, or every token issued to a viewer up to
+// RevokedAt, for one stream or all of them
+type Revocation struct {
+	ID        string    `json:"id"`
+	TokenID   string    `json:"token_id,omitempty"` // see auth.TokenID
+	Viewer    string    `json:"viewer,omitempty"`
+	StreamID  string    `json:"stream_id,omitempty"` // "" for every stream
+	Reason    string    `json:"reason,omitempty"`
+	RevokedBy string    `json:"revoked_by,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	// ExpiresAt is when every token the revocation matches expired, after
+	// which it is dropped
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// matches reports whether the revocation covers a token
+func (r *Revocation) matches(tokenID string, claims *auth.EmbedClaims) bool {
+	if r.StreamID != "" && r.StreamID != claims.StreamID {
+		return false
+	}
+	if r.TokenID != "" {
+		return r.TokenID == tokenID
+	}
+	return claims.Viewer == r.Viewer && claims.IssuedAt <= r.RevokedAt.Unix()
+}
+
+// List is the revocations of all replicas. It implements auth.Revoker.
+type List struct {
+	gcsService *storage.GCSService
+	bus        *events.Bus
+	maxTTL     time.Duration // longest lifetime of a token
+
+	mu          sync.RWMutex
+	revocations map[string]*Revocation // by ID
+}
+
+// NewList creates the list of revocations kept in the bucket of gcsService.
+// maxTTL is the longest lifetime of an embed token, which viewer
+// revocations last.
+func NewList(gcsService *storage.GCSService, bus *events.Bus, maxTTL time.Duration) *List {
+	return &List{
+		gcsService:  gcsService,
+		bus:         bus,
+		maxTTL:      maxTTL,
+		revocations: make(map[string]*Revocation),
+	}
+}
+
+// Revoke stores a revocation of a token (TokenID) or a viewer, and announces
+// it to the other replicas. A token revocation without ExpiresAt lasts as
+// long as the longest token could.
+func (l *List) Revoke(ctx context.Context, r Revocation) (*Revocation, error) {
+	if (r.TokenID == "") == (r.Viewer == "") {
+		return nil, fmt.Errorf("revoke either a token or a viewer")
+	}
+	now := time.Now().UTC()
+	r.ID = uuid.New().String()
+	r.RevokedAt = now
+	if r.Viewer != "" || r.ExpiresAt.IsZero() || r.ExpiresAt.After(now.Add(l.maxTTL)) {
+		r.ExpiresAt = now.Add(l.maxTTL)
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.gcsService.UploadBytes(ctx, data, objectPath(r.ID), "application/json"); err != nil {
+		return nil, fmt.Errorf("failed to store revocation: %w", err)
+	}
+	l.add(&r)
+	l.bus.Publish(events.TokenRevoked, r.eventData())
+	return &r, nil
+}
+
+// Lift removes a revocation, e.g. one made by mistake, on all replicas
+func (l *List) Lift(ctx context.Context, id string) error {
+	l.mu.RLock()
+	_, ok := l.revocations[id]
+	l.mu.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	if err := l.gcsService.DeleteVideo(ctx, objectPath(id)); err != nil {
+		return fmt.Errorf("failed to delete revocation: %w", err)
+	}
+	l.remove(id)
+	l.bus.Publish(events.TokenRevocationLifted, map[string]any{"id": id})
+	return nil
+}
+
+// Revoked returns the reason a token was revoked for, false when it was not
+func (l *List) Revoked(tokenID string, claims *auth.EmbedClaims) (string, bool) {
+	now := time.Now()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, r := range l.revocations {
+		if now.Before(r.ExpiresAt) && r.matches(tokenID, claims) {
+			return r.Reason, true
+		}
+	}
+	return "", false
+}
+
+// List returns the revocations in force, newest first, or with a stream ID
+// those covering that stream
+func (l *List) List(streamID string) []Revocation {
+	now := time.Now()
+	l.mu.RLock()
+	list := make([]Revocation, 0, len(l.revocations))
+	for _, r := range l.revocations {
+		if now.Before(r.ExpiresAt) && (streamID == "" || r.StreamID == "" || r.StreamID == streamID) {
+			list = append(list, *r)
+		}
+	}
+	l.mu.RUnlock()
+	slices.SortFunc(list, func(a, b Revocation) int { return b.RevokedAt.Compare(a.RevokedAt) })
+	return list
+}
+
+// Get returns a revocation
+func (l *List) Get(id string) (*Revocation, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	r, ok := l.revocations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *r
+	return &copied, nil
+}
+
+// syncTimeout bounds one sync with the bucket
+const syncTimeout = time.Minute
+
+// Start loads the revocations in the bucket, follows those announced by
+// other replicas and syncs with the bucket in group every interval. Syncing
+// drops lifted revocations and deletes expired ones.
+func (l *List) Start(group *background.Group, interval time.Duration) {
+	l.bus.Subscribe(events.TokenRevoked, func(event events.Event) {
+		if r, err := revocationOf(event.Data); err == nil {
+			l.add(r)
+		}
+	})
+	l.bus.Subscribe(events.TokenRevocationLifted, func(event events.Event) {
+		if id, ok := event.Data["id"].(string); ok {
+			l.remove(id)
+		}
+	})
+
+	sync := func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+		defer cancel()
+		if err := l.Sync(ctx); err != nil {
+			log.Printf("[Revocation] Failed to sync revocations: %v", err)
+		}
+	}
+	sync(group.Context())
+	group.Every(interval, sync)
+}
+
+// Sync makes the list what the bucket holds, reading only revocations it
+// doesn't know yet
+func (l *List) Sync(ctx context.Context) error {
+	listedAt := time.Now()
+	objects, err := l.gcsService.ListObjects(ctx, Prefix+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list revocations: %w", err)
+	}
+
+	now := time.Now()
+	stored := make(map[string]bool, len(objects))
+	for _, attrs := range objects {
+		id := strings.TrimSuffix(path.Base(attrs.Name), ".json")
+		stored[id] = true
+
+		l.mu.RLock()
+		r, known := l.revocations[id]
+		l.mu.RUnlock()
+		if !known {
+			data, err := l.gcsService.ReadFile(ctx, attrs.Name)
+			if storage.IsNotExist(err) {
+				continue // lifted meanwhile
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read revocation %s: %w", id, err)
+			}
+			r = &Revocation{}
+			if err := json.Unmarshal(data, r); err != nil {
+				log.Printf("[Revocation] Skipping unreadable revocation %s: %v", attrs.Name, err)
+				continue
+			}
+			l.add(r)
+		}
+		// Any replica may delete an expired revocation; the others drop it
+		// on their next sync
+		if !now.Before(r.ExpiresAt) {
+			if err := l.gcsService.DeleteVideo(ctx, attrs.Name); err != nil {
+				log.Printf("[Revocation] Failed to delete expired revocation %s: %v", id, err)
+			}
+			stored[id] = false
+		}
+	}
+
+	// Revocations made since the listing are kept for the next sync
+	l.mu.Lock()
+	for id, r := range l.revocations {
+		if !stored[id] && (r.RevokedAt.Before(listedAt) || !now.Before(r.ExpiresAt)) {
+			delete(l.revocations, id)
+		}
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *List) add(r *Revocation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.revocations[r.ID] = r
+}
+
+func (l *List) remove(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.revocations, id)
+}
+
+// eventData returns the revocation as the data of its event
+func (r *Revocation) eventData() map[string]any {
+	data, _ := json.Marshal(r)
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// revocationOf decodes the revocation of an event, relayed through JSON or
+// not
+func revocationOf(fields map[string]any) (*Revocation, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var r Revocation
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if r.ID == "" {
+		return nil, fmt.Errorf("revocation without ID")
+	}
+	return &r, nil
+}
+
+func objectPath(id string) string {
+	return path.Join(Prefix, id+".json")
+}
@@ -0,0 +1,253 @@
+package slate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/background"
+	"live-video/pkg/broadcast"
+	"live-video/pkg/m3u8"
+	"live-video/pkg/storage"
+)
+
+// Slate file and playlist layout
+const (
+	segmentName  = "slate.ts"
+	playlistName = "playlist.m3u8"
+	// playlistSize is how many slate entries a primed playlist lists
+	playlistSize = 5
+)
+
+// maxSlateTime is how long after its scheduled start a stream that never
+// started keeps its slate
+const maxSlateTime = 2 * time.Hour
+
+// Primer publishes the live playlists of scheduled streams ahead of their
+// start: a master playlist and, per rendition, a live playlist repeating a
+// slate segment. The watch URL is valid before the broadcaster connects and
+// players switch to the broadcast when its segments replace the slate.
+type Primer struct {
+	gcsService *storage.GCSService
+	dir        string // local cache of the rendered slate segments
+	image      string // optional slate picture; black without it
+	config     *config.FFmpegConfig
+
+	renderOnce sync.Once
+	renderErr  error
+
+	mu     sync.Mutex
+	primed map[string]*primedStream
+}
+
+// primedStream is a stream whose slate is published
+type primedStream struct {
+	mu       sync.Mutex
+	since    time.Time
+	released bool
+}
+
+// NewPrimer creates a primer rendering slates into dir, from image when set
+func NewPrimer(gcsService *storage.GCSService, dir, image string) *Primer {
+	return &Primer{
+		gcsService: gcsService,
+		dir:        dir,
+		image:      image,
+		config:     config.DefaultFFmpegConfig(),
+		primed:     make(map[string]*primedStream),
+	}
+}
+
+// Start primes scheduled streams, in group, from lead before their start
+// until they start, are deleted, or maxSlateTime after their start passed
+func (p *Primer) Start(group *background.Group, bm *broadcast.BroadcastManager, lead time.Duration) {
+	group.Every(time.Duration(p.config.SegmentDuration)*time.Second, func(ctx context.Context) {
+		due := make(map[string]bool)
+		for _, stream := range bm.ListStreams() {
+			at := stream.Schedule()
+			if at == nil || !stream.AwaitingStart() {
+				continue
+			}
+			if now := time.Now(); now.Before(at.Add(-lead)) || now.After(at.Add(maxSlateTime)) {
+				continue
+			}
+			due[stream.ID] = true
+			if err := p.refresh(ctx, stream.ID); err != nil {
+				log.Printf("[Slate] Failed to prime stream %s: %v", stream.ID, err)
+			}
+		}
+		p.forget(due)
+	})
+}
+
+// Release stops refreshing the slate of a stream that is starting and
+// returns the media sequence number its first segment should have, so
+// players see the sequence continue. It is 0 for streams that were not
+// primed.
+func (p *Primer) Release(streamID string) int {
+	if p == nil {
+		return 0
+	}
+	// Released streams stay known until the next tick, so a refresh racing
+	// with the start does not publish the slate again
+	p.mu.Lock()
+	primed, ok := p.primed[streamID]
+	if !ok {
+		p.primed[streamID] = &primedStream{released: true}
+	}
+	p.mu.Unlock()
+	if !ok {
+		return 0
+	}
+
+	primed.mu.Lock()
+	defer primed.mu.Unlock()
+	if primed.released {
+		return 0
+	}
+	primed.released = true
+	log.Printf("[Slate] Released stream %s", streamID)
+	return p.sequence(primed.since) + playlistSize
+}
+
+// refresh publishes the slate of a stream, uploading the slate segments and
+// master playlist the first time
+func (p *Primer) refresh(ctx context.Context, streamID string) error {
+	p.renderOnce.Do(func() {
+		p.renderErr = p.render()
+	})
+	if p.renderErr != nil {
+		return p.renderErr
+	}
+
+	p.mu.Lock()
+	primed, ok := p.primed[streamID]
+	if !ok {
+		primed = &primedStream{since: time.Now()}
+		p.primed[streamID] = primed
+	}
+	p.mu.Unlock()
+
+	primed.mu.Lock()
+	defer primed.mu.Unlock()
+	if primed.released {
+		return nil
+	}
+
+	layout := p.gcsService.Layout()
+	if !ok {
+		for _, profile := range p.config.Profiles {
+			local := filepath.Join(p.dir, profile.Name, segmentName)
+			if err := p.gcsService.UploadFile(ctx, local, layout.LivePath(streamID, profile.Name, segmentName), "video/MP2T"); err != nil {
+				p.forgetStream(streamID, primed)
+				return err
+			}
+		}
+		master := broadcast.MasterPlaylist([]broadcast.PlaybackSource{{StreamID: streamID}}, func(_, rendition string) string {
+			return rendition + "/" + playlistName
+		}, nil)
+		if err := p.gcsService.UploadBytes(ctx, []byte(master), layout.LivePath(streamID, playlistName), "application/vnd.apple.mpegurl"); err != nil {
+			p.forgetStream(streamID, primed)
+			return err
+		}
+		log.Printf("[Slate] Primed stream %s", streamID)
+	}
+
+	playlist := p.playlist(p.sequence(primed.since))
+	for _, profile := range p.config.Profiles {
+		if err := p.gcsService.UploadBytes(ctx, playlist, layout.LivePath(streamID, profile.Name, playlistName), "application/vnd.apple.mpegurl"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forget drops the state of primed streams that are no longer due
+func (p *Primer) forget(due map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for streamID := range p.primed {
+		if !due[streamID] {
+			delete(p.primed, streamID)
+		}
+	}
+}
+
+func (p *Primer) forgetStream(streamID string, primed *primedStream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.primed[streamID] == primed {
+		delete(p.primed, streamID)
+	}
+}
+
+// sequence returns the media sequence of a slate playlist primed since
+func (p *Primer) sequence(since time.Time) int {
+	return int(time.Since(since) / (time.Duration(p.config.SegmentDuration) * time.Second))
+}
+
+// playlist returns a live playlist of slate entries starting at sequence.
+// Every entry repeats the same segment, so each is a discontinuity.
+func (p *Primer) playlist(sequence int) []byte {
+	playlist := &m3u8.MediaPlaylist{
+		Header: []m3u8.Tag{
+			{Name: m3u8.TagVersion, Value: "3"},
+			{Name: m3u8.TagTargetDuration, Value: fmt.Sprint(p.config.SegmentDuration)},
+		},
+		MediaSequence:         sequence,
+		DiscontinuitySequence: sequence,
+	}
+	for i := 0; i < playlistSize; i++ {
+		playlist.Segments = append(playlist.Segments, m3u8.Segment{
+			URI:      segmentName,
+			Duration: float64(p.config.SegmentDuration),
+			Tags:     []m3u8.Tag{{Name: m3u8.TagDiscontinuity}},
+		})
+	}
+	return playlist.Encode()
+}
+
+// render encodes one slate segment per rendition of the ladder
+func (p *Primer) render() error {
+	duration := fmt.Sprint(p.config.SegmentDuration)
+	for _, profile := range p.config.Profiles {
+		// Encode the slate like the live renditions it is followed by
+		rate := profile.OutputRate(config.Rate{Num: p.config.InputFramerate, Den: 1})
+		dir := filepath.Join(p.dir, profile.Name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+
+		args := []string{"-y", "-hide_banner", "-loglevel", "error"}
+		if p.image != "" {
+			args = append(args, "-loop", "1", "-framerate", rate.String(), "-t", duration, "-i", p.image)
+		} else {
+			args = append(args, "-f", "lavfi", "-i", fmt.Sprintf("color=c=black:s=%dx%d:r=%s:d=%s", profile.Width, profile.Height, rate, duration))
+		}
+		args = append(args,
+			"-f", "lavfi", "-t", duration, "-i", "anullsrc=channel_layout=stereo:sample_rate=48000",
+			"-vf", fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,format=yuv420p", profile.Width, profile.Height, profile.Width, profile.Height),
+			"-c:v", "libx264", "-preset", profile.Preset, "-b:v", fmt.Sprintf("%dk", profile.VideoBitrate),
+			"-g", fmt.Sprint(rate.Frames(p.config.SegmentDuration)),
+			"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", profile.AudioBitrate), "-ar", "48000", "-ac", "2",
+			"-t", duration, "-f", "mpegts", filepath.Join(dir, segmentName),
+		)
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(context.Background(), "ffmpeg", args...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to render %s slate: %v: %s", profile.Name, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	log.Printf("[Slate] Rendered slate segments in %s", p.dir)
+	return nil
+}
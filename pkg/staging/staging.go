@@ -0,0 +1,384 @@
+package staging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"live-video/pkg/background"
+
+	"github.com/google/uuid"
+)
+
+// State is the lifecycle state of a staged source file
+type State string
+
+const (
+	StateReceived    State = "received"    // written to the staging area
+	StateValidated   State = "validated"   // probed and accepted for conversion
+	StateConverting  State = "converting"  // HLS conversion or upload in progress
+	StateUploaded    State = "uploaded"    // HLS output published, source no longer needed
+	StateFailed      State = "failed"      // conversion failed, source kept for a retry
+	StateQuarantined State = "quarantined" // rejected by validation, kept for inspection
+)
+
+// transitions lists the states each state may move to
+var transitions = map[State][]State{
+	StateReceived:   {StateValidated, StateQuarantined, StateFailed},
+	StateValidated:  {StateConverting, StateFailed},
+	StateConverting: {StateUploaded, StateFailed},
+	StateFailed:     {StateConverting},
+}
+
+// entryFile is the metadata file kept next to each staged source
+const entryFile = "entry.json"
+
+// Entry is a source file in the staging area
+type Entry struct {
+	ID          string    `json:"id"`
+	VideoID     string    `json:"video_id"`
+	JobID       string    `json:"job_id,omitempty"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	State       State     `json:"state"`
+	Error       string    `json:"error,omitempty"`
+	Attempts    int       `json:"attempts"`
+	SourcePath  string    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Policy controls how long staged files are kept in each final state
+type Policy struct {
+	UploadedRetention   time.Duration // 0 removes the source as soon as it is published
+	FailedRetention     time.Duration // failed sources stay retryable this long
+	QuarantineRetention time.Duration
+}
+
+// Area manages source files between upload and publication. Each entry has
+// its own directory holding the source and its metadata, so files are only
+// removed by an explicit state change or the cleanup policy, never while a
+// conversion may still read them.
+//
+//	{dir}/{id}/source{ext}
+//	{dir}/{id}/entry.json
+//	{quarantineDir}/{id}/...
+type Area struct {
+	mu            sync.Mutex
+	dir           string
+	quarantineDir string
+	policy        Policy
+	entries       map[string]*Entry
+}
+
+// New opens the staging area, reloading entries left by a previous run.
+// Entries that were converting without a job to resume them are marked failed.
+func New(dir, quarantineDir string, policy Policy) (*Area, error) {
+	for _, d := range []string{dir, quarantineDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create staging directory %s: %w", d, err)
+		}
+	}
+
+	a := &Area{
+		dir:           dir,
+		quarantineDir: quarantineDir,
+		policy:        policy,
+		entries:       make(map[string]*Entry),
+	}
+
+	for _, d := range []string{dir, quarantineDir} {
+		dirs, err := os.ReadDir(d)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read staging directory %s: %w", d, err)
+		}
+		for _, de := range dirs {
+			if !de.IsDir() {
+				continue
+			}
+			entry, err := loadEntry(filepath.Join(d, de.Name()))
+			if err != nil {
+				log.Printf("[Staging] Removing unreadable entry %s: %v", de.Name(), err)
+				os.RemoveAll(filepath.Join(d, de.Name()))
+				continue
+			}
+			if entry.State == StateConverting && entry.JobID == "" {
+				entry.State = StateFailed
+				entry.Error = "interrupted by restart"
+				entry.UpdatedAt = time.Now()
+				a.persist(entry)
+			}
+			a.entries[entry.ID] = entry
+		}
+	}
+
+	return a, nil
+}
+
+// Receive creates an entry and lets write store the source at the given
+// path. The entry is dropped again if write fails.
+func (a *Area) Receive(videoID, fileName, contentType string, write func(path string) error) (*Entry, error) {
+	id := uuid.New().String()
+	entryDir := filepath.Join(a.dir, id)
+	if err := os.MkdirAll(entryDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create staging entry: %w", err)
+	}
+
+	sourcePath := filepath.Join(entryDir, "source"+filepath.Ext(fileName))
+	if err := write(sourcePath); err != nil {
+		os.RemoveAll(entryDir)
+		return nil, err
+	}
+
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		os.RemoveAll(entryDir)
+		return nil, fmt.Errorf("staged source missing: %w", err)
+	}
+
+	now := time.Now()
+	entry := &Entry{
+		ID:          id,
+		VideoID:     videoID,
+		FileName:    fileName,
+		ContentType: contentType,
+		Size:        info.Size(),
+		State:       StateReceived,
+		SourcePath:  sourcePath,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries[id] = entry
+	a.persist(entry)
+	return entry.copy(), nil
+}
+
+// Transition moves an entry to a new state. Entering converting counts an
+// attempt; entering failed records err.
+func (a *Area) Transition(id string, state State, err error) (*Entry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry, exists := a.entries[id]
+	if !exists {
+		return nil, fmt.Errorf("staging entry not found: %s", id)
+	}
+	if !allowed(entry.State, state) {
+		return nil, fmt.Errorf("cannot move staging entry from %s to %s", entry.State, state)
+	}
+
+	entry.State = state
+	entry.Error = ""
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if state == StateConverting {
+		entry.Attempts++
+	}
+	entry.UpdatedAt = time.Now()
+
+	if state == StateUploaded && a.policy.UploadedRetention == 0 {
+		a.remove(entry)
+		return entry.copy(), nil
+	}
+	a.persist(entry)
+	return entry.copy(), nil
+}
+
+// Quarantine moves a received entry out of the staging area for inspection
+func (a *Area) Quarantine(id, reason string) (*Entry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry, exists := a.entries[id]
+	if !exists {
+		return nil, fmt.Errorf("staging entry not found: %s", id)
+	}
+	if !allowed(entry.State, StateQuarantined) {
+		return nil, fmt.Errorf("cannot quarantine staging entry in state %s", entry.State)
+	}
+
+	target := filepath.Join(a.quarantineDir, id)
+	if err := os.Rename(filepath.Dir(entry.SourcePath), target); err != nil {
+		return nil, fmt.Errorf("failed to quarantine %s: %w", id, err)
+	}
+
+	entry.SourcePath = filepath.Join(target, filepath.Base(entry.SourcePath))
+	entry.State = StateQuarantined
+	entry.Error = reason
+	entry.UpdatedAt = time.Now()
+	a.persist(entry)
+	log.Printf("[Staging] Quarantined %s (%s): %s", id, entry.FileName, reason)
+	return entry.copy(), nil
+}
+
+// SetJob links an entry to the transcode job converting it
+func (a *Area) SetJob(id, jobID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry, exists := a.entries[id]
+	if !exists {
+		return fmt.Errorf("staging entry not found: %s", id)
+	}
+	entry.JobID = jobID
+	a.persist(entry)
+	return nil
+}
+
+// Get returns a snapshot of an entry
+func (a *Area) Get(id string) (*Entry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry, exists := a.entries[id]
+	if !exists {
+		return nil, fmt.Errorf("staging entry not found: %s", id)
+	}
+	return entry.copy(), nil
+}
+
+// List returns all entries, optionally only those in one state, newest first
+func (a *Area) List(state State) []*Entry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := make([]*Entry, 0, len(a.entries))
+	for _, entry := range a.entries {
+		if state == "" || entry.State == state {
+			list = append(list, entry.copy())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// Remove deletes an entry and its files. Entries being converted are kept.
+func (a *Area) Remove(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry, exists := a.entries[id]
+	if !exists {
+		return fmt.Errorf("staging entry not found: %s", id)
+	}
+	if entry.State == StateConverting {
+		return fmt.Errorf("staging entry %s is being converted", id)
+	}
+	a.remove(entry)
+	return nil
+}
+
+// Sweep applies the cleanup policy and returns how many entries it removed.
+// Entries stuck in received, validated or converting, e.g. because their job
+// gave up, are treated like failed ones.
+func (a *Area) Sweep() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	removed := 0
+	for _, entry := range a.entries {
+		var retention time.Duration
+		switch entry.State {
+		case StateUploaded:
+			retention = a.policy.UploadedRetention
+		case StateFailed, StateReceived, StateValidated, StateConverting:
+			retention = a.policy.FailedRetention
+		case StateQuarantined:
+			retention = a.policy.QuarantineRetention
+		default:
+			continue
+		}
+		if time.Since(entry.UpdatedAt) > retention {
+			a.remove(entry)
+			removed++
+		}
+	}
+	return removed
+}
+
+// StartSweeper runs Sweep in group every interval
+func (a *Area) StartSweeper(group *background.Group, interval time.Duration) {
+	group.Every(interval, func(context.Context) {
+		if n := a.Sweep(); n > 0 {
+			log.Printf("[Staging] Cleaned up %d staged sources", n)
+		}
+	})
+}
+
+// remove deletes an entry's directory. Callers hold a.mu.
+func (a *Area) remove(entry *Entry) {
+	if err := os.RemoveAll(filepath.Dir(entry.SourcePath)); err != nil {
+		log.Printf("[Staging] Failed to remove %s: %v", entry.ID, err)
+	}
+	delete(a.entries, entry.ID)
+}
+
+// persist writes the entry's metadata file. Callers hold a.mu.
+func (a *Area) persist(entry *Entry) {
+	data, err := json.Marshal(entryRecord{Entry: entry, SourceFile: filepath.Base(entry.SourcePath)})
+	if err != nil {
+		return
+	}
+
+	path := filepath.Join(filepath.Dir(entry.SourcePath), entryFile)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		log.Printf("[Staging] Failed to save entry %s: %v", entry.ID, err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("[Staging] Failed to save entry %s: %v", entry.ID, err)
+	}
+}
+
+// entryRecord is the on-disk form of an entry
+type entryRecord struct {
+	*Entry
+	SourceFile string `json:"source_file"`
+}
+
+func loadEntry(entryDir string) (*Entry, error) {
+	data, err := os.ReadFile(filepath.Join(entryDir, entryFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var entry Entry
+	record := entryRecord{Entry: &entry}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	if entry.ID == "" || record.SourceFile == "" {
+		return nil, fmt.Errorf("incomplete entry metadata")
+	}
+	entry.SourcePath = filepath.Join(entryDir, record.SourceFile)
+	return &entry, nil
+}
+
+func allowed(from, to State) bool {
+	// Re-entering converting happens when a job resumes after a restart
+	if from == to && to == StateConverting {
+		return true
+	}
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Entry) copy() *Entry {
+	c := *e
+	return &c
+}
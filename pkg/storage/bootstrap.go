@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// corsMethods are what browsers use on the bucket: playback and resumable
+// direct uploads
+var corsMethods = []string{"GET", "HEAD", "PUT", "POST", "OPTIONS"}
+
+// corsResponseHeaders are the response headers browsers may read
+var corsResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Range", "ETag", "Location", "X-Goog-Resumable"}
+
+// BucketSettings are the bucket settings the service manages. Unset ones
+// are left as they are.
+type BucketSettings struct {
+	CORSOrigins            []string          `json:"cors_origins,omitempty"`
+	CORSMaxAge             time.Duration     `json:"-"`
+	UniformAccess          *bool             `json:"uniform_access,omitempty"`
+	PublicAccessPrevention string            `json:"public_access_prevention,omitempty"` // enforced or inherited
+	Lifecycle              []PrefixLifecycle `json:"lifecycle,omitempty"`
+}
+
+// Empty reports whether no setting is managed
+func (s BucketSettings) Empty() bool {
+	return len(s.CORSOrigins) == 0 && s.UniformAccess == nil && s.PublicAccessPrevention == "" && len(s.Lifecycle) == 0
+}
+
+// ParsePublicAccessPrevention validates a public access prevention setting
+func ParsePublicAccessPrevention(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case "", "enforced", "inherited":
+		return value, nil
+	}
+	return "", fmt.Errorf("public access prevention must be enforced or inherited, got %q", value)
+}
+
+// BootstrapReport lists the bucket settings that were, or in a dry run would
+// be, changed
+type BootstrapReport struct {
+	Bucket    string   `json:"bucket"`
+	DryRun    bool     `json:"dry_run"`
+	Changed   []string `json:"changed"`
+	Unchanged []string `json:"unchanged"`
+}
+
+// Bootstrap brings the bucket's CORS rules, access settings and lifecycle in
+// line with settings in one update. CORS rules are replaced as a whole;
+// lifecycle rules for other prefixes than the layout's are kept.
+func (g *GCSService) Bootstrap(ctx context.Context, settings BucketSettings, dryRun bool) (*BootstrapReport, error) {
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	bucket := g.bucket()
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bucket: %w", err)
+	}
+
+	report := &BootstrapReport{Bucket: g.bucketName, DryRun: dryRun, Changed: []string{}, Unchanged: []string{}}
+	var update storage.BucketAttrsToUpdate
+	track := func(name string, changed bool) bool {
+		if changed {
+			report.Changed = append(report.Changed, name)
+		} else {
+			report.Unchanged = append(report.Unchanged, name)
+		}
+		return changed
+	}
+
+	if len(settings.CORSOrigins) > 0 {
+		cors := []storage.CORS{{
+			Origins:         settings.CORSOrigins,
+			Methods:         corsMethods,
+			ResponseHeaders: corsResponseHeaders,
+			MaxAge:          settings.CORSMaxAge,
+		}}
+		if track("cors", !reflect.DeepEqual(attrs.CORS, cors)) {
+			update.CORS = cors
+		}
+	}
+	if settings.UniformAccess != nil {
+		if track("uniform_access", attrs.UniformBucketLevelAccess.Enabled != *settings.UniformAccess) {
+			update.UniformBucketLevelAccess = &storage.UniformBucketLevelAccess{Enabled: *settings.UniformAccess}
+		}
+	}
+	if settings.PublicAccessPrevention != "" {
+		prevention := storage.PublicAccessPreventionInherited
+		if settings.PublicAccessPrevention == "enforced" {
+			prevention = storage.PublicAccessPreventionEnforced
+		}
+		if track("public_access_prevention", attrs.PublicAccessPrevention != prevention) {
+			update.PublicAccessPrevention = prevention
+		}
+	}
+	if len(settings.Lifecycle) > 0 {
+		lifecycle := g.mergeLifecycle(attrs.Lifecycle, settings.Lifecycle)
+		if track("lifecycle", !reflect.DeepEqual(attrs.Lifecycle.Rules, lifecycle.Rules)) {
+			update.Lifecycle = &lifecycle
+		}
+	}
+
+	if dryRun || len(report.Changed) == 0 {
+		return report, nil
+	}
+	if _, err := bucket.Update(ctx, update); err != nil {
+		return nil, fmt.Errorf("failed to update bucket: %w", err)
+	}
+	return report, nil
+}
+
+// mergeLifecycle returns current with the rules for the layout prefixes
+// replaced by rules
+func (g *GCSService) mergeLifecycle(current storage.Lifecycle, rules []PrefixLifecycle) storage.Lifecycle {
+	managed := make(map[string]bool)
+	for _, kind := range []string{"live", "vod", "recordings", "thumbnails"} {
+		prefix, _ := g.layout.Prefix(kind)
+		managed[prefix+"/"] = true
+	}
+
+	var lifecycle storage.Lifecycle
+	for _, rule := range current.Rules {
+		ours := false
+		for _, prefix := range rule.Condition.MatchesPrefix {
+			ours = ours || managed[prefix]
+		}
+		if !ours {
+			lifecycle.Rules = append(lifecycle.Rules, rule)
+		}
+	}
+
+	for _, rule := range rules {
+		prefix := []string{rule.Prefix + "/"}
+		if rule.ColdAfter > 0 {
+			lifecycle.Rules = append(lifecycle.Rules, storage.LifecycleRule{
+				Action:    storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: rule.ColdClass},
+				Condition: storage.LifecycleCondition{AgeInDays: int64(rule.ColdAfter), MatchesPrefix: prefix},
+			})
+		}
+		if rule.DeleteAfter > 0 {
+			lifecycle.Rules = append(lifecycle.Rules, storage.LifecycleRule{
+				Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+				Condition: storage.LifecycleCondition{AgeInDays: int64(rule.DeleteAfter), MatchesPrefix: prefix},
+			})
+		}
+	}
+	return lifecycle
+}
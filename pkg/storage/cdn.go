@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"live-video/config"
+)
+
+// CDNCookieName is the cookie Cloud CDN reads signed cookies from
+const CDNCookieName = "Cloud-CDN-Cookie"
+
+// CDNSigner signs URLs and cookies with a Cloud CDN signing key, so a CDN
+// backend on a private bucket serves only requests it signed until they
+// expire
+type CDNSigner struct {
+	keyName      string
+	key          []byte
+	ttl          time.Duration
+	cookieDomain string
+}
+
+// NewCDNSigner creates a signer from the CDN settings of cfg. Without a key
+// name it returns nil: CDN URLs stay unsigned.
+func NewCDNSigner(cfg config.GCSConfig) (*CDNSigner, error) {
+	if cfg.CDNKeyName == "" {
+		return nil, nil
+	}
+	key, err := base64.URLEncoding.DecodeString(cfg.CDNKey)
+	if err != nil {
+		key, err = base64.RawURLEncoding.DecodeString(cfg.CDNKey)
+	}
+	if err != nil || len(key) != 16 {
+		return nil, fmt.Errorf("CDN signing key must be 16 bytes, base64url-encoded")
+	}
+	if cfg.CDNSignedTTL <= 0 {
+		return nil, fmt.Errorf("CDN signed TTL must be positive")
+	}
+	return &CDNSigner{
+		keyName:      cfg.CDNKeyName,
+		key:          key,
+		ttl:          time.Duration(cfg.CDNSignedTTL) * time.Second,
+		cookieDomain: cfg.CDNCookieDomain,
+	}, nil
+}
+
+// KeyName returns the name of the signing key
+func (s *CDNSigner) KeyName() string {
+	return s.keyName
+}
+
+// TTL returns how long signatures are valid
+func (s *CDNSigner) TTL() time.Duration {
+	return s.ttl
+}
+
+// SignURL returns rawURL signed for itself alone until the TTL elapses
+func (s *CDNSigner) SignURL(rawURL string) string {
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	signed := fmt.Sprintf("%s%sExpires=%d&KeyName=%s", rawURL, separator, s.Expires().Unix(), url.QueryEscape(s.keyName))
+	return signed + "&Signature=" + s.sign(signed)
+}
+
+// PrefixQuery returns query parameters that sign every URL starting with
+// prefix until the TTL elapses, to append to any of them
+func (s *CDNSigner) PrefixQuery(prefix string) string {
+	policy := fmt.Sprintf("URLPrefix=%s&Expires=%d&KeyName=%s",
+		base64.URLEncoding.EncodeToString([]byte(prefix)), s.Expires().Unix(), url.QueryEscape(s.keyName))
+	return policy + "&Signature=" + s.sign(policy)
+}
+
+// Cookie returns a signed cookie for every URL starting with prefix, valid
+// until the TTL elapses. Its path is the path of prefix; it is set for the
+// cookie domain, so the API and the CDN must share it.
+func (s *CDNSigner) Cookie(prefix string) *http.Cookie {
+	expires := s.Expires()
+	policy := fmt.Sprintf("URLPrefix=%s:Expires=%d:KeyName=%s",
+		base64.URLEncoding.EncodeToString([]byte(prefix)), expires.Unix(), s.keyName)
+
+	path := "/"
+	if u, err := url.Parse(prefix); err == nil && u.Path != "" {
+		path = u.Path
+	}
+	return &http.Cookie{
+		Name:     CDNCookieName,
+		Value:    policy + ":Signature=" + s.sign(policy),
+		Path:     path,
+		Domain:   s.cookieDomain,
+		Expires:  expires,
+		MaxAge:   int(s.ttl.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	}
+}
+
+// Expires returns when signatures made now expire
+func (s *CDNSigner) Expires() time.Time {
+	return time.Now().Add(s.ttl).Truncate(time.Second)
+}
+
+func (s *CDNSigner) sign(message string) string {
+	mac := hmac.New(sha1.New, s.key)
+	mac.Write([]byte(message))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -2,15 +2,22 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"mime/multipart"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -18,9 +25,19 @@ import (
 type GCSService struct {
 	client           *storage.Client
 	bucketName       string
-	ctx              context.Context
 	serviceAccountID string
 	credentialsFile  string
+	layout           Layout
+	policies         map[string]OperationPolicy
+	usage            UsageRecorder
+	segmentUploads   uploadTimes
+}
+
+// UsageRecorder is told about every object the service writes or deletes,
+// so stored bytes can be tracked without scanning the bucket
+type UsageRecorder interface {
+	Stored(gcsPath string, size int64)
+	Removed(gcsPath string)
 }
 
 // VideoMetadata contains information about uploaded videos
@@ -37,7 +54,8 @@ type VideoMetadata struct {
 	Duration       float64   `json:"duration,omitempty"` // Video duration in seconds
 }
 
-// NewGCSService creates a new GCS service instance
+// NewGCSService creates a new GCS service instance. ctx is only used to set
+// up the client; operations take a context of their own.
 func NewGCSService(ctx context.Context, bucketName string, credentialsFile string) (*GCSService, error) {
 	var client *storage.Client
 	var err error
@@ -52,20 +70,93 @@ func NewGCSService(ctx context.Context, bucketName string, credentialsFile strin
 		return nil, fmt.Errorf("failed to create GCS client: %v", err)
 	}
 
-	// Get the service account email from gcloud config
-	serviceAccountID := "palash.paul@ingka.ikea.com"
-
 	return &GCSService{
-		client:           client,
-		bucketName:       bucketName,
-		ctx:              ctx,
-		serviceAccountID: serviceAccountID,
-		credentialsFile:  credentialsFile,
+		client:          client,
+		bucketName:      bucketName,
+		credentialsFile: credentialsFile,
+		layout:          DefaultLayout(),
+		policies:        DefaultOperationPolicies(),
 	}, nil
 }
 
+// SetServiceAccount sets the e-mail of the service account URLs are signed
+// as. Without a credentials file, URLs are then signed through the IAM
+// signBlob API, which takes the Service Account Token Creator role on it.
+func (g *GCSService) SetServiceAccount(email string) {
+	g.serviceAccountID = email
+}
+
+// ServiceAccount returns the e-mail of the service account URLs are signed
+// as, or "" when unknown
+func (g *GCSService) ServiceAccount() string {
+	return g.serviceAccountID
+}
+
+// canSign reports whether URLs can be signed: with the key of a credentials
+// file or as a known service account
+func (g *GCSService) canSign() bool {
+	return g.credentialsFile != "" || g.serviceAccountID != ""
+}
+
+// signer names who URLs are signed as in logs
+func (g *GCSService) signer() string {
+	if g.serviceAccountID != "" {
+		return g.serviceAccountID
+	}
+	return "the credentials file's key"
+}
+
+// signedURLOptions returns the options of a V4 signed URL, signed as the
+// service account
+func (g *GCSService) signedURLOptions(method string, expires time.Time) *storage.SignedURLOptions {
+	return &storage.SignedURLOptions{
+		GoogleAccessID: g.serviceAccountID,
+		Scheme:         storage.SigningSchemeV4,
+		Method:         method,
+		Expires:        expires,
+	}
+}
+
+// SetLayout changes the bucket prefixes objects are written to
+func (g *GCSService) SetLayout(layout Layout) {
+	g.layout = layout
+}
+
+// Layout returns the bucket prefixes objects are written to
+func (g *GCSService) Layout() Layout {
+	return g.layout
+}
+
+// BucketName returns the name of the bucket objects are written to
+func (g *GCSService) BucketName() string {
+	return g.bucketName
+}
+
+// SetUsageRecorder registers the recorder of stored and deleted objects
+func (g *GCSService) SetUsageRecorder(recorder UsageRecorder) {
+	g.usage = recorder
+}
+
+// TrackObject records an object written by someone else, e.g. through a
+// signed upload URL
+func (g *GCSService) TrackObject(attrs *storage.ObjectAttrs) {
+	g.stored(attrs)
+}
+
+func (g *GCSService) stored(attrs *storage.ObjectAttrs) {
+	if g.usage != nil && attrs != nil {
+		g.usage.Stored(attrs.Name, attrs.Size)
+	}
+}
+
+func (g *GCSService) removed(gcsPath string) {
+	if g.usage != nil {
+		g.usage.Removed(gcsPath)
+	}
+}
+
 // UploadVideo uploads a video file to GCS in a UUID-based folder
-func (g *GCSService) UploadVideo(file *multipart.FileHeader, folder, videoID string) (*VideoMetadata, error) {
+func (g *GCSService) UploadVideo(ctx context.Context, file *multipart.FileHeader, folder, videoID string) (*VideoMetadata, error) {
 	src, err := file.Open()
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %v", err)
@@ -79,7 +170,9 @@ func (g *GCSService) UploadVideo(file *multipart.FileHeader, folder, videoID str
 	// Upload to folder/videoID/video.ext
 	gcsPath := filepath.Join(folder, videoID, fileName)
 
-	wc := g.client.Bucket(g.bucketName).Object(gcsPath).NewWriter(g.ctx)
+	ctx, cancel := g.withDeadline(ctx, OpWrite)
+	defer cancel()
+	wc := g.object(gcsPath, OpWrite).NewWriter(ctx)
 	wc.ContentType = file.Header.Get("Content-Type")
 	wc.CacheControl = "public, max-age=86400"
 
@@ -91,6 +184,7 @@ func (g *GCSService) UploadVideo(file *multipart.FileHeader, folder, videoID str
 	if err := wc.Close(); err != nil {
 		return nil, fmt.Errorf("failed to close writer: %v", err)
 	}
+	g.stored(wc.Attrs())
 
 	log.Printf("Uploaded %s to gs://%s/%s", file.Filename, g.bucketName, gcsPath)
 
@@ -107,14 +201,16 @@ func (g *GCSService) UploadVideo(file *multipart.FileHeader, folder, videoID str
 }
 
 // UploadFile uploads any file to GCS
-func (g *GCSService) UploadFile(filePath, gcsPath, contentType string) error {
+func (g *GCSService) UploadFile(ctx context.Context, filePath, gcsPath, contentType string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
 
-	wc := g.client.Bucket(g.bucketName).Object(gcsPath).NewWriter(g.ctx)
+	ctx, cancel := g.withDeadline(ctx, OpWrite)
+	defer cancel()
+	wc := g.object(gcsPath, OpWrite).NewWriter(ctx)
 	wc.ContentType = contentType
 	wc.CacheControl = "public, max-age=86400"
 
@@ -125,11 +221,70 @@ func (g *GCSService) UploadFile(filePath, gcsPath, contentType string) error {
 	if err := wc.Close(); err != nil {
 		return fmt.Errorf("failed to close writer: %v", err)
 	}
+	g.stored(wc.Attrs())
 
 	log.Printf("Uploaded %s to gs://%s/%s", filepath.Base(filePath), g.bucketName, gcsPath)
 	return nil
 }
 
+// UploadFileAs uploads a file with a storage class and custom metadata. An
+// empty storage class uses the bucket default.
+func (g *GCSService) UploadFileAs(ctx context.Context, filePath, gcsPath, contentType, storageClass string, metadata map[string]string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	ctx, cancel := g.withDeadline(ctx, OpWrite)
+	defer cancel()
+	wc := g.object(gcsPath, OpWrite).NewWriter(ctx)
+	wc.ContentType = contentType
+	wc.StorageClass = storageClass
+	wc.Metadata = metadata
+
+	if _, err := io.Copy(wc, file); err != nil {
+		return fmt.Errorf("failed to copy file: %v", err)
+	}
+
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("failed to close writer: %v", err)
+	}
+	g.stored(wc.Attrs())
+
+	log.Printf("Uploaded %s to gs://%s/%s (%s)", filepath.Base(filePath), g.bucketName, gcsPath, wc.Attrs().StorageClass)
+	return nil
+}
+
+// CopyObject copies an object with a storage class and custom metadata added
+// to its own. Copying an object onto itself rewrites it in place.
+func (g *GCSService) CopyObject(ctx context.Context, from, to, storageClass string, metadata map[string]string) (*storage.ObjectAttrs, error) {
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	src, err := g.object(from, OpMetadata).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+
+	copier := g.object(to, OpMetadata).CopierFrom(g.object(from, OpMetadata))
+	copier.ContentType = src.ContentType
+	copier.StorageClass = storageClass
+	copier.Metadata = make(map[string]string, len(src.Metadata)+len(metadata))
+	for k, v := range src.Metadata {
+		copier.Metadata[k] = v
+	}
+	for k, v := range metadata {
+		copier.Metadata[k] = v
+	}
+
+	attrs, err := copier.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy object: %w", err)
+	}
+	g.stored(attrs)
+	return attrs, nil
+}
+
 // GetPublicURL returns the public URL for a GCS object
 func (g *GCSService) GetPublicURL(gcsPath string) string {
 	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", g.bucketName, gcsPath)
@@ -137,41 +292,140 @@ func (g *GCSService) GetPublicURL(gcsPath string) string {
 
 // GetSignedURL generates a signed URL with expiration
 func (g *GCSService) GetSignedURL(gcsPath string, expiration time.Duration) (string, error) {
-	// If no credentials file, return public URL
-	if g.credentialsFile == "" {
-		log.Printf("No credentials file, using public URL for %s", gcsPath)
+	// If URLs can't be signed, return public URL
+	if !g.canSign() {
+		log.Printf("No signing credentials, using public URL for %s", gcsPath)
 		return g.GetPublicURL(gcsPath), nil
 	}
+	return g.signURL(gcsPath, time.Now().Add(expiration)), nil
+}
 
-	// Generate signed URL using service account credentials
-	opts := &storage.SignedURLOptions{
-		Scheme:  storage.SigningSchemeV4,
-		Method:  "GET",
-		Expires: time.Now().Add(expiration),
+// GetSignedURLs generates signed URLs of many objects, keyed by path, that
+// all expire at expires
+func (g *GCSService) GetSignedURLs(gcsPaths []string, expires time.Time) map[string]string {
+	urls := make(map[string]string, len(gcsPaths))
+	if !g.canSign() {
+		log.Printf("No signing credentials, using public URLs for %d objects", len(gcsPaths))
+		for _, gcsPath := range gcsPaths {
+			urls[gcsPath] = g.GetPublicURL(gcsPath)
+		}
+		return urls
+	}
+	for _, gcsPath := range gcsPaths {
+		urls[gcsPath] = g.signURL(gcsPath, expires)
 	}
+	log.Printf("Signed %d URLs as %s, expiring %s", len(gcsPaths), g.signer(), expires.Format(time.RFC3339))
+	return urls
+}
+
+// signURL signs a GET URL of an object with the service account
+// credentials, falling back to its public URL
+func (g *GCSService) signURL(gcsPath string, expires time.Time) string {
+	opts := g.signedURLOptions("GET", expires)
 
 	url, err := g.client.Bucket(g.bucketName).SignedURL(gcsPath, opts)
 	if err != nil {
-		log.Printf("Failed to generate signed URL for %s: %v. Using public URL.", gcsPath, err)
+		log.Printf("Failed to sign URL for %s as %s: %v. Using public URL.", gcsPath, g.signer(), err)
+		return g.GetPublicURL(gcsPath)
+	}
+
+	return url
+}
+
+// GetSignedDownloadURL generates a signed URL that makes browsers save the
+// object as fileName instead of displaying it
+func (g *GCSService) GetSignedDownloadURL(gcsPath, fileName string, expiration time.Duration) (string, error) {
+	if !g.canSign() {
+		log.Printf("No signing credentials, using public URL for %s", gcsPath)
 		return g.GetPublicURL(gcsPath), nil
 	}
 
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": fileName})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	opts := g.signedURLOptions("GET", time.Now().Add(expiration))
+	opts.QueryParameters = url.Values{"response-content-disposition": {disposition}}
+
+	signed, err := g.client.Bucket(g.bucketName).SignedURL(gcsPath, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign download URL as %s: %w", g.signer(), err)
+	}
+	return signed, nil
+}
+
+// GetSignedUploadURL generates a V4 signed URL that lets clients upload an
+// object directly to the bucket. When resumable is true the URL is signed for
+// the POST that initiates a resumable upload session; the client must send the
+// "x-goog-resumable: start" header and then PUT the data to the returned
+// session URI.
+func (g *GCSService) GetSignedUploadURL(gcsPath, contentType string, resumable bool, expiration time.Duration) (string, error) {
+	opts := g.signedURLOptions("PUT", time.Now().Add(expiration))
+	opts.ContentType = contentType
+	if resumable {
+		opts.Method = "POST"
+		opts.Headers = []string{"x-goog-resumable:start"}
+	}
+
+	url, err := g.client.Bucket(g.bucketName).SignedURL(gcsPath, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign upload URL as %s: %w", g.signer(), err)
+	}
+	log.Printf("Signed %s upload URL for %s as %s, expiring %s", opts.Method, gcsPath, g.signer(), opts.Expires.Format(time.RFC3339))
+
 	return url, nil
 }
 
+// GetObjectAttrs returns the attributes of a GCS object
+func (g *GCSService) GetObjectAttrs(ctx context.Context, gcsPath string) (*storage.ObjectAttrs, error) {
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	attrs, err := g.object(gcsPath, OpMetadata).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	return attrs, nil
+}
+
+// DownloadFile downloads a GCS object to a local file
+func (g *GCSService) DownloadFile(ctx context.Context, gcsPath, localPath string) error {
+	ctx, cancel := g.withDeadline(ctx, OpRead)
+	defer cancel()
+	reader, err := g.object(gcsPath, OpRead).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create reader: %w", err)
+	}
+	defer reader.Close()
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	log.Printf("Downloaded gs://%s/%s to %s", g.bucketName, gcsPath, localPath)
+	return nil
+}
+
 // ListVideos lists all videos in a folder
-func (g *GCSService) ListVideos(folder string) ([]*VideoMetadata, error) {
+func (g *GCSService) ListVideos(ctx context.Context, folder string) ([]*VideoMetadata, error) {
 	var videos []*VideoMetadata
 
 	query := &storage.Query{
 		Prefix: folder + "/",
 	}
 
-	it := g.client.Bucket(g.bucketName).Objects(g.ctx, query)
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	it := g.bucket().Objects(ctx, query)
 
 	for {
 		attrs, err := it.Next()
-		if err == storage.ErrObjectNotExist {
+		if err == iterator.Done {
 			break
 		}
 		if err != nil {
@@ -195,25 +449,222 @@ func (g *GCSService) ListVideos(folder string) ([]*VideoMetadata, error) {
 	return videos, nil
 }
 
+// ListObjects returns the attributes of all objects under a prefix
+func (g *GCSService) ListObjects(ctx context.Context, prefix string) ([]*storage.ObjectAttrs, error) {
+	var objects []*storage.ObjectAttrs
+
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	it := g.bucket().Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		objects = append(objects, attrs)
+	}
+
+	return objects, nil
+}
+
+// SetStorageClass rewrites an object in place with a new storage class
+func (g *GCSService) SetStorageClass(ctx context.Context, gcsPath, storageClass string) (*storage.ObjectAttrs, error) {
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	obj := g.object(gcsPath, OpMetadata)
+	copier := obj.CopierFrom(obj)
+	copier.StorageClass = storageClass
+
+	attrs, err := copier.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set storage class: %w", err)
+	}
+	return attrs, nil
+}
+
+// UploadBytes uploads an in-memory object to GCS
+func (g *GCSService) UploadBytes(ctx context.Context, data []byte, gcsPath, contentType string) error {
+	ctx, cancel := g.withDeadline(ctx, OpWrite)
+	defer cancel()
+	wc := g.object(gcsPath, OpWrite).NewWriter(ctx)
+	wc.ContentType = contentType
+	wc.CacheControl = "no-cache"
+
+	if _, err := wc.Write(data); err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("failed to close writer: %w", err)
+	}
+	g.stored(wc.Attrs())
+
+	return nil
+}
+
+// CreateBytes uploads an in-memory object unless one exists at gcsPath, and
+// reports whether it did. Of several replicas creating the same object only
+// one succeeds, so it can claim work. The write isn't retried: a retry of a
+// write that succeeded would find the object and report false.
+func (g *GCSService) CreateBytes(ctx context.Context, data []byte, gcsPath, contentType string) (bool, error) {
+	ctx, cancel := g.withDeadline(ctx, OpWrite)
+	defer cancel()
+	wc := g.client.Bucket(g.bucketName).Object(gcsPath).
+		If(storage.Conditions{DoesNotExist: true}).
+		Retryer(storage.WithPolicy(storage.RetryNever)).
+		NewWriter(ctx)
+	wc.ContentType = contentType
+	wc.CacheControl = "no-cache"
+
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		return false, fmt.Errorf("failed to write object: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to close writer: %w", err)
+	}
+	g.stored(wc.Attrs())
+	return true, nil
+}
+
+// ReadFile reads a whole GCS object into memory
+func (g *GCSService) ReadFile(ctx context.Context, gcsPath string) ([]byte, error) {
+	reader, err := g.GetFileReader(ctx, gcsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return data, nil
+}
+
+// ReadGeneration reads a whole GCS object into memory, with the generation
+// it read
+func (g *GCSService) ReadGeneration(ctx context.Context, gcsPath string) ([]byte, int64, error) {
+	ctx, cancel := g.withDeadline(ctx, OpRead)
+	defer cancel()
+	reader, err := g.object(gcsPath, OpRead).NewReader(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create reader: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read object: %w", err)
+	}
+	return data, reader.Attrs.Generation, nil
+}
+
+// ReadFileParallel reads a whole GCS object into memory in parts of
+// partSize bytes, fetched in parallel once the first one tells the size
+func (g *GCSService) ReadFileParallel(ctx context.Context, gcsPath string, partSize int64) ([]byte, error) {
+	ctx, cancel := g.withDeadline(ctx, OpRead)
+	defer cancel()
+	obj := g.object(gcsPath, OpRead)
+	first, err := obj.NewRangeReader(ctx, 0, partSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	size := first.Attrs.Size
+	data := make([]byte, size)
+	_, err = io.ReadFull(first, data[:min(partSize, size)])
+	first.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	// The other parts come from the same generation of the object
+	obj = obj.Generation(first.Attrs.Generation)
+	var wg sync.WaitGroup
+	errs := make(chan error, size/partSize+1)
+	for offset := partSize; offset < size; offset += partSize {
+		wg.Add(1)
+		go func(offset int64) {
+			defer wg.Done()
+			part := data[offset:min(offset+partSize, size)]
+			reader, err := obj.NewRangeReader(ctx, offset, int64(len(part)))
+			if err != nil {
+				errs <- fmt.Errorf("failed to create reader: %w", err)
+				return
+			}
+			defer reader.Close()
+			if _, err := io.ReadFull(reader, part); err != nil {
+				errs <- fmt.Errorf("failed to read object: %w", err)
+			}
+		}(offset)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // DeleteVideo deletes a video from GCS
-func (g *GCSService) DeleteVideo(gcsPath string) error {
-	obj := g.client.Bucket(g.bucketName).Object(gcsPath)
-	if err := obj.Delete(g.ctx); err != nil {
-		return fmt.Errorf("failed to delete object: %v", err)
+func (g *GCSService) DeleteVideo(ctx context.Context, gcsPath string) error {
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	if err := g.object(gcsPath, OpMetadata).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
 	}
+	g.removed(gcsPath)
 
 	log.Printf("Deleted gs://%s/%s", g.bucketName, gcsPath)
 	return nil
 }
 
-// GetFileReader returns a reader for a GCS object
-func (g *GCSService) GetFileReader(gcsPath string) (io.ReadCloser, error) {
-	obj := g.client.Bucket(g.bucketName).Object(gcsPath)
-	reader, err := obj.NewReader(g.ctx)
+// DeleteGeneration deletes an object if generation is still its live one.
+// It reports false when the object has changed or is gone.
+func (g *GCSService) DeleteGeneration(ctx context.Context, gcsPath string, generation int64) (bool, error) {
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	err := g.object(gcsPath, OpMetadata).If(storage.Conditions{GenerationMatch: generation}).Delete(ctx)
+	var apiErr *googleapi.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed, IsNotExist(err):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to delete object: %w", err)
+	}
+	g.removed(gcsPath)
+	return true, nil
+}
+
+// GetRangeReader returns a reader for length bytes of an object starting at
+// offset; a negative length reads to the end. The read deadline runs until
+// the reader is closed.
+func (g *GCSService) GetRangeReader(ctx context.Context, gcsPath string, offset, length int64) (io.ReadCloser, error) {
+	ctx, cancel := g.withDeadline(ctx, OpRead)
+	reader, err := g.object(gcsPath, OpRead).NewRangeReader(ctx, offset, length)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create reader: %v", err)
+		cancel()
+		return nil, fmt.Errorf("failed to create reader: %w", err)
 	}
-	return reader, nil
+	return &cancelReader{ReadCloser: reader, cancel: cancel}, nil
+}
+
+// GetFileReader returns a reader for a GCS object. The read deadline runs
+// until the reader is closed.
+func (g *GCSService) GetFileReader(ctx context.Context, gcsPath string) (io.ReadCloser, error) {
+	return g.GetRangeReader(ctx, gcsPath, 0, -1)
+}
+
+// IsNotExist reports whether err is about an object that does not exist
+func IsNotExist(err error) bool {
+	return errors.Is(err, storage.ErrObjectNotExist)
 }
 
 // Close closes the GCS client
@@ -222,18 +673,20 @@ func (g *GCSService) Close() error {
 }
 
 // UploadHLSSegment uploads an HLS segment (.ts file) to GCS
-func (g *GCSService) UploadHLSSegment(localPath, streamID, variantName string) error {
+func (g *GCSService) UploadHLSSegment(ctx context.Context, localPath, streamID, variantName string) error {
 	file, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
 
-	// Path: upload/videos/{streamID}/{variantName}/segment_XXX.ts
-	fileName := filepath.Base(localPath)
-	gcsPath := filepath.Join("upload/videos", streamID, variantName, fileName)
+	// Path: live/{streamID}/{variantName}/segment_XXX.ts
+	gcsPath := g.layout.LivePath(streamID, variantName, filepath.Base(localPath))
+	start := time.Now()
 
-	wc := g.client.Bucket(g.bucketName).Object(gcsPath).NewWriter(g.ctx)
+	ctx, cancel := g.withDeadline(ctx, OpWrite)
+	defer cancel()
+	wc := g.object(gcsPath, OpWrite).NewWriter(ctx)
 	wc.ContentType = "video/MP2T"
 	wc.CacheControl = "public, max-age=60" // Cache for 60 seconds
 
@@ -244,28 +697,26 @@ func (g *GCSService) UploadHLSSegment(localPath, streamID, variantName string) e
 	if err := wc.Close(); err != nil {
 		return fmt.Errorf("failed to close writer: %v", err)
 	}
+	g.stored(wc.Attrs())
+	g.segmentUploads.add(time.Since(start))
 
 	return nil
 }
 
 // UploadHLSPlaylist uploads an HLS playlist (.m3u8 file) to GCS
-func (g *GCSService) UploadHLSPlaylist(localPath, streamID, variantName string) error {
+func (g *GCSService) UploadHLSPlaylist(ctx context.Context, localPath, streamID, variantName string) error {
 	file, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
 
-	// Path: upload/videos/{streamID}/{variantName}/playlist.m3u8 or upload/videos/{streamID}/playlist.m3u8
-	fileName := filepath.Base(localPath)
-	var gcsPath string
-	if variantName != "" {
-		gcsPath = filepath.Join("upload/videos", streamID, variantName, fileName)
-	} else {
-		gcsPath = filepath.Join("upload/videos", streamID, fileName)
-	}
+	// Path: live/{streamID}/{variantName}/playlist.m3u8 or live/{streamID}/playlist.m3u8
+	gcsPath := g.layout.LivePath(streamID, variantName, filepath.Base(localPath))
 
-	wc := g.client.Bucket(g.bucketName).Object(gcsPath).NewWriter(g.ctx)
+	ctx, cancel := g.withDeadline(ctx, OpWrite)
+	defer cancel()
+	wc := g.object(gcsPath, OpWrite).NewWriter(ctx)
 	wc.ContentType = "application/vnd.apple.mpegurl"
 	wc.CacheControl = "public, max-age=2" // Very short cache for playlists
 
@@ -276,13 +727,15 @@ func (g *GCSService) UploadHLSPlaylist(localPath, streamID, variantName string)
 	if err := wc.Close(); err != nil {
 		return fmt.Errorf("failed to close writer: %v", err)
 	}
+	g.stored(wc.Attrs())
 
 	return nil
 }
 
 // GetHLSMasterPlaylistURL returns the URL for the HLS master playlist
 func (g *GCSService) GetHLSMasterPlaylistURL(streamID string) string {
-	// Direct CDN URL (CORS configured on load balancer)
+	// Direct CDN URL (CORS configured on load balancer). The CDN backend
+	// serves the live prefix of the bucket.
 	// Replace with your CDN URL
 	cdnBaseURL := os.Getenv("CDN_BASE_URL")
 	if cdnBaseURL == "" {
@@ -292,31 +745,82 @@ func (g *GCSService) GetHLSMasterPlaylistURL(streamID string) string {
 }
 
 // DeleteOldHLSSegments deletes HLS segments older than the specified duration
-func (g *GCSService) DeleteOldHLSSegments(streamID string, olderThan time.Duration) error {
-	prefix := filepath.Join("upload/videos", streamID)
+func (g *GCSService) DeleteOldHLSSegments(ctx context.Context, streamID string, olderThan time.Duration) error {
+	_, err := g.deleteOldSegments(ctx, g.layout.LivePath(streamID)+"/", olderThan)
+	return err
+}
+
+// DeleteOldLiveSegments deletes the segments of every live stream that are
+// older than olderThan and returns how many it deleted. Playlists and init
+// segments are kept.
+func (g *GCSService) DeleteOldLiveSegments(ctx context.Context, olderThan time.Duration) (int, error) {
+	return g.deleteOldSegments(ctx, g.layout.Live+"/", olderThan)
+}
+
+// DeleteLiveOutput deletes every object of a live stream, playlists included,
+// and returns how many it deleted
+func (g *GCSService) DeleteLiveOutput(ctx context.Context, streamID string) (int, error) {
+	return g.DeletePrefix(ctx, g.layout.LivePath(streamID)+"/")
+}
+
+// DeletePrefix deletes every object under a prefix and returns how many it
+// deleted. It carries on past failures and returns the first.
+func (g *GCSService) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	objects, err := g.ListObjects(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	deleted := 0
+	var firstErr error
+	for _, attrs := range objects {
+		if err := g.object(attrs.Name, OpMetadata).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to delete %s: %w", attrs.Name, err)
+			}
+			continue
+		}
+		g.removed(attrs.Name)
+		deleted++
+	}
+	return deleted, firstErr
+}
+
+// deleteOldSegments deletes the media segments under prefix last written
+// before olderThan ago
+func (g *GCSService) deleteOldSegments(ctx context.Context, prefix string, olderThan time.Duration) (int, error) {
 	cutoffTime := time.Now().Add(-olderThan)
 
 	query := &storage.Query{
 		Prefix: prefix,
 	}
 
-	it := g.client.Bucket(g.bucketName).Objects(g.ctx, query)
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	deleted := 0
+	it := g.bucket().Objects(ctx, query)
 	for {
 		attrs, err := it.Next()
-		if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			return err
+			return deleted, err
 		}
 
 		// Delete if older than cutoff and is a segment file
-		if attrs.Updated.Before(cutoffTime) && filepath.Ext(attrs.Name) == ".ts" {
-			if err := g.client.Bucket(g.bucketName).Object(attrs.Name).Delete(g.ctx); err != nil {
+		ext := filepath.Ext(attrs.Name)
+		if attrs.Updated.Before(cutoffTime) && (ext == ".ts" || ext == ".m4s") {
+			if err := g.object(attrs.Name, OpMetadata).Delete(ctx); err != nil {
 				log.Printf("Failed to delete %s: %v", attrs.Name, err)
+			} else {
+				g.removed(attrs.Name)
+				deleted++
 			}
 		}
 	}
 
-	return nil
+	return deleted, nil
 }
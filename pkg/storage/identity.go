@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+// Where the service account that signs URLs was found
+const (
+	IdentityConfigured      = "configured"
+	IdentityCredentialsFile = "credentials file"
+	IdentityMetadataServer  = "metadata server"
+)
+
+// metadataTimeout bounds the metadata server lookup off Google Cloud
+const metadataTimeout = 3 * time.Second
+
+// impersonationURL holds the e-mail of an impersonated service account, e.g.
+// https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/
+// signer@project.iam.gserviceaccount.com:generateAccessToken
+var impersonationURL = regexp.MustCompile(`/serviceAccounts/([^/:]+):generateAccessToken$`)
+
+// ErrNoServiceAccount is returned when the active credentials are not a
+// service account, e.g. gcloud user credentials
+var ErrNoServiceAccount = errors.New("no service account in the active credentials")
+
+// DiscoverServiceAccount returns the e-mail of the service account the
+// service runs as and where it was found: the credentials file, or else
+// GOOGLE_APPLICATION_CREDENTIALS, or else the metadata server on Google
+// Cloud.
+func DiscoverServiceAccount(ctx context.Context, credentialsFile string) (email, source string, err error) {
+	if credentialsFile == "" {
+		credentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if credentialsFile != "" {
+		email, err := serviceAccountOf(credentialsFile)
+		return email, IdentityCredentialsFile, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+	if !metadata.OnGCEWithContext(ctx) {
+		return "", "", ErrNoServiceAccount
+	}
+	email, err = metadata.EmailWithContext(ctx, "default")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read service account from metadata server: %w", err)
+	}
+	return email, IdentityMetadataServer, nil
+}
+
+// serviceAccountOf reads the service account of a credentials file: a
+// service account key, or credentials impersonating one
+func serviceAccountOf(credentialsFile string) (string, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	var credentials struct {
+		Type             string `json:"type"`
+		ClientEmail      string `json:"client_email"`
+		ImpersonationURL string `json:"service_account_impersonation_url"`
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return "", fmt.Errorf("failed to parse credentials file: %w", err)
+	}
+	switch {
+	case credentials.ClientEmail != "":
+		return credentials.ClientEmail, nil
+	case credentials.ImpersonationURL != "":
+		if match := impersonationURL.FindStringSubmatch(credentials.ImpersonationURL); match != nil {
+			return match[1], nil
+		}
+	}
+	return "", fmt.Errorf("%w (%s credentials)", ErrNoServiceAccount, credentials.Type)
+}
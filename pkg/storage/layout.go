@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// LegacyPrefix is where live segments and uploaded videos were both stored
+// before the bucket was split into per-purpose prefixes
+const LegacyPrefix = "upload/videos"
+
+// Layout names the bucket prefixes for each kind of object, so each can get
+// its own lifecycle:
+//
+//	live/{streamID}/{variant}/segment_XXX.ts   live HLS output
+//	vod/{videoID}/...                          uploaded videos and their HLS
+//	recordings/{streamID}/...                  recordings of live streams
+//	thumbnails/{id}/...                        posters and preview images
+type Layout struct {
+	Live       string `json:"live"`
+	VOD        string `json:"vod"`
+	Recordings string `json:"recordings"`
+	Thumbnails string `json:"thumbnails"`
+}
+
+// DefaultLayout returns the standard prefixes
+func DefaultLayout() Layout {
+	return Layout{
+		Live:       "live",
+		VOD:        "vod",
+		Recordings: "recordings",
+		Thumbnails: "thumbnails",
+	}
+}
+
+// Validate cleans the prefixes and checks that none is empty or nested in
+// another, which would make their lifecycle rules overlap
+func (l *Layout) Validate() error {
+	prefixes := map[string]*string{
+		"live":       &l.Live,
+		"vod":        &l.VOD,
+		"recordings": &l.Recordings,
+		"thumbnails": &l.Thumbnails,
+	}
+	for name, prefix := range prefixes {
+		*prefix = strings.Trim(path.Clean("/"+*prefix), "/")
+		if *prefix == "" {
+			return fmt.Errorf("%s prefix is empty", name)
+		}
+	}
+	for name, prefix := range prefixes {
+		for other, otherPrefix := range prefixes {
+			if name != other && (*prefix == *otherPrefix || strings.HasPrefix(*prefix+"/", *otherPrefix+"/")) {
+				return fmt.Errorf("%s prefix %q overlaps %s prefix %q", name, *prefix, other, *otherPrefix)
+			}
+		}
+	}
+	return nil
+}
+
+// Prefix returns the prefix of a kind: "live", "vod", "recordings" or
+// "thumbnails"
+func (l Layout) Prefix(kind string) (string, bool) {
+	switch kind {
+	case "live":
+		return l.Live, true
+	case "vod":
+		return l.VOD, true
+	case "recordings":
+		return l.Recordings, true
+	case "thumbnails":
+		return l.Thumbnails, true
+	}
+	return "", false
+}
+
+// Overlaps reports whether prefix is, contains or is inside a layout prefix
+func (l Layout) Overlaps(prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	for _, p := range []string{l.Live, l.VOD, l.Recordings, l.Thumbnails} {
+		if strings.HasPrefix(prefix+"/", p+"/") || strings.HasPrefix(p+"/", prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// LivePath returns the path of a live stream object
+func (l Layout) LivePath(streamID string, elem ...string) string {
+	return path.Join(append([]string{l.Live, streamID}, elem...)...)
+}
+
+// VODPath returns the path of an uploaded video object
+func (l Layout) VODPath(videoID string, elem ...string) string {
+	return path.Join(append([]string{l.VOD, videoID}, elem...)...)
+}
+
+// RecordingPath returns the path of a live stream recording
+func (l Layout) RecordingPath(streamID string, elem ...string) string {
+	return path.Join(append([]string{l.Recordings, streamID}, elem...)...)
+}
+
+// ThumbnailPath returns the path of a thumbnail of a stream or video
+func (l Layout) ThumbnailPath(id string, elem ...string) string {
+	return path.Join(append([]string{l.Thumbnails, id}, elem...)...)
+}
+
+// AssetOf returns the kind ("live", "vod", "recordings", "thumbnails" or
+// "legacy") and the ID of the stream or video whose folder holds an object.
+// It is false for objects outside every asset folder.
+func (l Layout) AssetOf(objectPath string) (kind, id string, ok bool) {
+	prefixes := []struct{ kind, prefix string }{
+		{"live", l.Live},
+		{"vod", l.VOD},
+		{"recordings", l.Recordings},
+		{"thumbnails", l.Thumbnails},
+		{"legacy", LegacyPrefix},
+	}
+	for _, p := range prefixes {
+		rest, found := strings.CutPrefix(objectPath, p.prefix+"/")
+		if !found {
+			continue
+		}
+		id, _, inFolder := strings.Cut(rest, "/")
+		return p.kind, id, id != "" && inFolder
+	}
+	return "", "", false
+}
+
+// AssetFolders returns the folders of a video or stream under every layout
+// prefix and the legacy one, which together hold all of its objects
+func (l Layout) AssetFolders(id string) []string {
+	folders := make([]string, 0, 5)
+	for _, prefix := range []string{l.Live, l.VOD, l.Recordings, l.Thumbnails, LegacyPrefix} {
+		folders = append(folders, path.Join(prefix, id)+"/")
+	}
+	return folders
+}
+
+// PrefixLifecycle is the lifecycle of the objects under one layout prefix.
+// Zero days disables the corresponding rule.
+type PrefixLifecycle struct {
+	Kind        string `json:"kind"`
+	Prefix      string `json:"prefix"`
+	DeleteAfter int    `json:"delete_after_days,omitempty"`
+	ColdAfter   int    `json:"cold_after_days,omitempty"`
+	ColdClass   string `json:"cold_storage_class,omitempty"`
+}
+
+// ParseLifecycle parses a lifecycle spec such as
+//
+//	live=delete:3,recordings=coldline:30,recordings=delete:365
+//
+// Each entry applies an action to a layout kind after a number of days. The
+// action is "delete" or a storage class (nearline, coldline, archive).
+func ParseLifecycle(spec string, layout Layout) ([]PrefixLifecycle, error) {
+	var rules []PrefixLifecycle
+	index := make(map[string]int)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kind, rule, ok := strings.Cut(entry, "=")
+		action, daysText, ok2 := strings.Cut(rule, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid lifecycle entry %q, expected kind=action:days", entry)
+		}
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		prefix, ok := layout.Prefix(kind)
+		if !ok {
+			return nil, fmt.Errorf("unknown lifecycle kind %q", kind)
+		}
+		days, err := strconv.Atoi(strings.TrimSpace(daysText))
+		if err != nil || days <= 0 {
+			return nil, fmt.Errorf("invalid lifecycle days in %q", entry)
+		}
+
+		i, seen := index[kind]
+		if !seen {
+			i = len(rules)
+			index[kind] = i
+			rules = append(rules, PrefixLifecycle{Kind: kind, Prefix: prefix})
+		}
+
+		switch action = strings.ToUpper(strings.TrimSpace(action)); action {
+		case "DELETE":
+			rules[i].DeleteAfter = days
+		case "NEARLINE", "COLDLINE", "ARCHIVE":
+			rules[i].ColdAfter = days
+			rules[i].ColdClass = action
+		default:
+			return nil, fmt.Errorf("unknown lifecycle action %q", action)
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.DeleteAfter > 0 && rule.ColdAfter > 0 && rule.ColdAfter >= rule.DeleteAfter {
+			return nil, fmt.Errorf("%s objects are deleted before moving to %s", rule.Kind, rule.ColdClass)
+		}
+	}
+	return rules, nil
+}
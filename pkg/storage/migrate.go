@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// manifestFileName is the archive manifest kept in archived VOD folders. Its
+// object paths are rewritten when the folder moves.
+const manifestFileName = "archive-manifest.json"
+
+// ObjectMove is one object moved out of the legacy prefix
+type ObjectMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+	Size int64  `json:"size"`
+}
+
+// MigrationReport summarizes a migration of the legacy prefix
+type MigrationReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Assets  int            `json:"assets"`
+	Objects int            `json:"objects"`
+	Bytes   int64          `json:"bytes"`
+	ByKind  map[string]int `json:"by_kind"`
+	Moves   []ObjectMove   `json:"moves"`
+	Failed  []string       `json:"failed,omitempty"`
+}
+
+// MigrateLegacy moves the objects under the legacy prefix into the layout.
+// Each asset folder is classified as a live stream when it holds variant
+// playlists and as a video otherwise; recordings and images go to their own
+// prefixes. With dryRun nothing is moved.
+func (g *GCSService) MigrateLegacy(ctx context.Context, legacy string, dryRun bool) (*MigrationReport, error) {
+	legacy = strings.Trim(legacy, "/")
+	if legacy == "" || g.layout.Overlaps(legacy) {
+		return nil, fmt.Errorf("cannot migrate %q: it overlaps the layout prefixes", legacy)
+	}
+	objects, err := g.ListObjects(ctx, legacy+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	assets := make(map[string][]*storage.ObjectAttrs)
+	for _, attrs := range objects {
+		rel := strings.TrimPrefix(attrs.Name, legacy+"/")
+		assetID, _, ok := strings.Cut(rel, "/")
+		if !ok || assetID == "" {
+			continue
+		}
+		assets[assetID] = append(assets[assetID], attrs)
+	}
+
+	report := &MigrationReport{DryRun: dryRun, Assets: len(assets), ByKind: make(map[string]int), Moves: []ObjectMove{}}
+	ids := make([]string, 0, len(assets))
+	for id := range assets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, assetID := range ids {
+		folder := path.Join(legacy, assetID)
+		moves := g.layout.planAsset(folder, assetID, assets[assetID])
+		for _, move := range moves {
+			report.Objects++
+			report.Bytes += move.Size
+			report.ByKind[move.Kind]++
+			report.Moves = append(report.Moves, move)
+		}
+		if dryRun {
+			continue
+		}
+
+		renamed := make(map[string]string, len(moves))
+		for _, move := range moves {
+			renamed[move.From] = move.To
+		}
+		for i, move := range moves {
+			var err error
+			if path.Base(move.From) == manifestFileName {
+				err = g.moveManifest(ctx, move, folder, path.Dir(move.To), renamed)
+			} else {
+				err = g.moveObject(ctx, assets[assetID][i], move.To)
+			}
+			if err != nil {
+				log.Printf("[Storage] Failed to migrate %s: %v", move.From, err)
+				report.Failed = append(report.Failed, move.From)
+			}
+		}
+	}
+
+	if !dryRun {
+		log.Printf("[Storage] Migrated %d objects in %d assets out of %s (%d failed)", report.Objects-len(report.Failed), report.Assets, legacy, len(report.Failed))
+	}
+	return report, nil
+}
+
+// planAsset decides where each object of a legacy asset folder goes. The
+// moves are in the same order as objects.
+func (l Layout) planAsset(folder, assetID string, objects []*storage.ObjectAttrs) []ObjectMove {
+	live := false
+	for _, attrs := range objects {
+		rel := strings.TrimPrefix(attrs.Name, folder+"/")
+		if strings.Contains(rel, "/") && !strings.HasPrefix(rel, "recording/") && path.Ext(rel) == ".m3u8" {
+			live = true
+			break
+		}
+	}
+
+	moves := make([]ObjectMove, 0, len(objects))
+	for _, attrs := range objects {
+		rel := strings.TrimPrefix(attrs.Name, folder+"/")
+		move := ObjectMove{From: attrs.Name, Size: attrs.Size}
+		switch {
+		case strings.HasPrefix(rel, "recording/"):
+			move.Kind = "recordings"
+			move.To = l.RecordingPath(assetID, strings.TrimPrefix(rel, "recording/"))
+		case isImage(rel):
+			move.Kind = "thumbnails"
+			move.To = l.ThumbnailPath(assetID, rel)
+		case live:
+			move.Kind = "live"
+			move.To = l.LivePath(assetID, rel)
+		default:
+			move.Kind = "vod"
+			move.To = l.VODPath(assetID, rel)
+		}
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+// moveObject copies an object to its new name, keeping its storage class and
+// metadata, and deletes the original
+func (g *GCSService) moveObject(ctx context.Context, attrs *storage.ObjectAttrs, to string) error {
+	ctx, cancel := g.withDeadline(ctx, OpMetadata)
+	defer cancel()
+	copier := g.object(to, OpMetadata).CopierFrom(g.object(attrs.Name, OpMetadata))
+	copier.ContentType = attrs.ContentType
+	copier.CacheControl = attrs.CacheControl
+	copier.Metadata = attrs.Metadata
+	copier.StorageClass = attrs.StorageClass
+
+	copied, err := copier.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+	g.stored(copied)
+	if err := g.object(attrs.Name, OpMetadata).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete original: %w", err)
+	}
+	g.removed(attrs.Name)
+	return nil
+}
+
+// moveManifest rewrites an archive manifest for the new object paths and
+// writes it to its new name
+func (g *GCSService) moveManifest(ctx context.Context, move ObjectMove, oldFolder, newFolder string, renamed map[string]string) error {
+	data, err := g.ReadFile(ctx, move.From)
+	if err != nil {
+		return err
+	}
+
+	quote := func(s string) []byte { return []byte(`"` + s + `"`) }
+	data = bytes.ReplaceAll(data, quote(oldFolder), quote(newFolder))
+	for from, to := range renamed {
+		data = bytes.ReplaceAll(data, quote(from), quote(to))
+	}
+
+	if err := g.UploadBytes(ctx, data, move.To, "application/json"); err != nil {
+		return err
+	}
+	return g.DeleteVideo(ctx, move.From)
+}
+
+func isImage(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return true
+	}
+	return false
+}
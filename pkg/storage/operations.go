@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Classes of storage operations, each with its own deadline and retries
+const (
+	OpMetadata = "metadata" // attributes, listings, copies, deletes and bucket settings
+	OpRead     = "read"     // object downloads and readers
+	OpWrite    = "write"    // object uploads
+)
+
+// OperationPolicy bounds the operations of a class: Timeout is the deadline
+// of a whole call, retries included, and MaxAttempts how often a request is
+// tried. A zero Timeout leaves the caller's deadline alone.
+type OperationPolicy struct {
+	Timeout     time.Duration `json:"timeout"`
+	MaxAttempts int           `json:"max_attempts"`
+}
+
+// DefaultOperationPolicies returns the policies used unless configured
+func DefaultOperationPolicies() map[string]OperationPolicy {
+	return map[string]OperationPolicy{
+		OpMetadata: {Timeout: 30 * time.Second, MaxAttempts: 3},
+		OpRead:     {Timeout: 10 * time.Minute, MaxAttempts: 3},
+		OpWrite:    {Timeout: 30 * time.Minute, MaxAttempts: 3},
+	}
+}
+
+// ParseOperationPolicies parses a comma separated list of class=timeout:attempts
+// entries, e.g. "read=2m:5,write=1h:3", over the default policies. Either half
+// of an entry may be left out, e.g. "metadata=10s" or "write=:5".
+func ParseOperationPolicies(spec string) (map[string]OperationPolicy, error) {
+	policies := DefaultOperationPolicies()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		class, value, ok := strings.Cut(entry, "=")
+		policy, known := policies[class]
+		if !ok || !known {
+			return nil, fmt.Errorf("invalid operation policy %q (class=timeout:attempts, class one of metadata, read, write)", entry)
+		}
+		timeout, attempts, _ := strings.Cut(value, ":")
+		if timeout != "" {
+			d, err := time.ParseDuration(timeout)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid timeout in %q", entry)
+			}
+			policy.Timeout = d
+		}
+		if attempts != "" {
+			n, err := strconv.Atoi(attempts)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid attempts in %q", entry)
+			}
+			policy.MaxAttempts = n
+		}
+		policies[class] = policy
+	}
+	return policies, nil
+}
+
+// SetOperationPolicies changes the deadlines and retries of operation classes
+func (g *GCSService) SetOperationPolicies(policies map[string]OperationPolicy) {
+	for class, policy := range policies {
+		g.policies[class] = policy
+	}
+}
+
+// OperationPolicies returns the deadlines and retries of operation classes
+func (g *GCSService) OperationPolicies() map[string]OperationPolicy {
+	policies := make(map[string]OperationPolicy, len(g.policies))
+	for class, policy := range g.policies {
+		policies[class] = policy
+	}
+	return policies
+}
+
+// withDeadline bounds ctx by the timeout of an operation class
+func (g *GCSService) withDeadline(ctx context.Context, class string) (context.Context, context.CancelFunc) {
+	if timeout := g.policies[class].Timeout; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// object returns a handle retrying the requests of an operation class.
+// Everything the service writes goes to a path of its own choosing, so
+// uploads are retried even without a generation precondition.
+func (g *GCSService) object(gcsPath, class string) *storage.ObjectHandle {
+	policy := storage.RetryIdempotent
+	if class == OpWrite {
+		policy = storage.RetryAlways
+	}
+	return g.client.Bucket(g.bucketName).Object(gcsPath).Retryer(
+		storage.WithMaxAttempts(max(g.policies[class].MaxAttempts, 1)),
+		storage.WithPolicy(policy),
+	)
+}
+
+// bucket returns a handle retrying metadata requests
+func (g *GCSService) bucket() *storage.BucketHandle {
+	return g.client.Bucket(g.bucketName).Retryer(
+		storage.WithMaxAttempts(max(g.policies[OpMetadata].MaxAttempts, 1)),
+	)
+}
+
+// cancelReader releases the deadline of a reader when it is closed
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
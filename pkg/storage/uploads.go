@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// uploadWindow is how far back segment upload times are averaged
+const uploadWindow = time.Minute
+
+// uploadTimes keeps how long recent live segment uploads took
+type uploadTimes struct {
+	mu    sync.Mutex
+	times []timedUpload
+}
+
+type timedUpload struct {
+	at   time.Time
+	took time.Duration
+}
+
+func (u *uploadTimes) add(took time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.times = append(u.prune(time.Now()), timedUpload{at: time.Now(), took: took})
+}
+
+// prune drops uploads older than uploadWindow. Callers hold u.mu.
+func (u *uploadTimes) prune(now time.Time) []timedUpload {
+	i := 0
+	for i < len(u.times) && now.Sub(u.times[i].at) > uploadWindow {
+		i++
+	}
+	return u.times[i:]
+}
+
+// SegmentUploadTime returns how long live segment uploads took on average
+// in the last minute, 0 without uploads
+func (g *GCSService) SegmentUploadTime() time.Duration {
+	u := &g.segmentUploads
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.times = u.prune(time.Now())
+	if len(u.times) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, upload := range u.times {
+		total += upload.took
+	}
+	return total / time.Duration(len(u.times))
+}
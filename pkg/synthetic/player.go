@@ -0,0 +1,363 @@
+// Package synthetic plays every live stream once after it starts, the way a
+// viewer's player would, so a stream nobody can play is noticed before its
+// viewers notice it
+package synthetic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/broadcast"
+	"live-video/pkg/events"
+	"live-video/pkg/m3u8"
+)
+
+const (
+	// pollInterval is how often playlists are fetched again while the
+	// stream's first segments are not published yet
+	pollInterval = 2 * time.Second
+
+	// maxSegmentBytes bounds what is downloaded of a segment
+	maxSegmentBytes = 64 << 20
+)
+
+// Player checks streams of this replica when they start. Each check loads
+// the stream's master playlist from where viewers load it, and downloads
+// and decodes the newest segment of every rendition with ffprobe.
+type Player struct {
+	manager     *broadcast.BroadcastManager
+	playlistURL func(streamID string) string
+	timeout     time.Duration
+	client      *http.Client
+	sign        func(streamID, uri string) string
+	bus         *events.Bus
+
+	mu      sync.Mutex
+	running map[string]*run // by stream ID
+}
+
+// run is a check in progress
+type run struct {
+	cancel context.CancelFunc
+}
+
+// NewPlayer creates a player that finds the master playlist of a stream at
+// playlistURL and waits up to timeout for its segments to be published
+func NewPlayer(manager *broadcast.BroadcastManager, playlistURL func(streamID string) string, timeout time.Duration) *Player {
+	return &Player{
+		manager:     manager,
+		playlistURL: playlistURL,
+		timeout:     timeout,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		running:     make(map[string]*run),
+	}
+}
+
+// SetSigner signs the URLs the player fetches, for CDNs that only serve
+// signed requests
+func (p *Player) SetSigner(sign func(streamID, uri string) string) {
+	p.sign = sign
+}
+
+// Follow checks every stream of this replica that starts, and publishes the
+// outcome on bus as events.StreamPlaybackVerified or
+// events.StreamPlaybackFailed. A stream stopped while it is checked ends
+// the check.
+func (p *Player) Follow(bus *events.Bus) {
+	p.bus = bus
+	bus.SubscribeLocal(events.StreamStatusChanged, func(e events.Event) {
+		streamID, _ := e.Data["stream_id"].(string)
+		switch fmt.Sprint(e.Data["to"]) {
+		case string(broadcast.StatusStreaming):
+			// Resuming from a pause is not a start
+			if fmt.Sprint(e.Data["from"]) == string(broadcast.StatusStarting) {
+				p.start(streamID)
+			}
+		case string(broadcast.StatusStopping), string(broadcast.StatusStopped), string(broadcast.StatusErrored):
+			p.cancel(streamID)
+		}
+	})
+}
+
+// start checks a stream in the background, ending a check still running
+func (p *Player) start(streamID string) {
+	stream, err := p.manager.GetStream(streamID)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout+time.Minute)
+	current := &run{cancel: cancel}
+	p.mu.Lock()
+	if previous, ok := p.running[streamID]; ok {
+		previous.cancel()
+	}
+	p.running[streamID] = current
+	p.mu.Unlock()
+
+	stream.SetPlaybackCheck(&broadcast.PlaybackCheck{Status: broadcast.PlaybackCheckPending, StartedAt: time.Now().UTC()})
+	go func() {
+		defer p.finish(streamID, current)
+		check := p.Check(ctx, streamID)
+		if ctx.Err() == context.Canceled {
+			return // stopped or restarted meanwhile
+		}
+		stream.SetPlaybackCheck(check)
+		p.publish(streamID, check)
+	}()
+}
+
+// finish forgets a check that ended, unless a newer one replaced it
+func (p *Player) finish(streamID string, finished *run) {
+	finished.cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running[streamID] == finished {
+		delete(p.running, streamID)
+	}
+}
+
+// cancel ends the check of a stream, if one runs. A check that didn't get
+// to a result is dropped; a finished one stays until the next start.
+func (p *Player) cancel(streamID string) {
+	p.mu.Lock()
+	running, ok := p.running[streamID]
+	delete(p.running, streamID)
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	running.cancel()
+	stream, err := p.manager.GetStream(streamID)
+	if err != nil {
+		return
+	}
+	if check := stream.PlaybackCheck(); check != nil && check.Status == broadcast.PlaybackCheckPending {
+		stream.SetPlaybackCheck(nil)
+	}
+}
+
+// publish reports the outcome of a check
+func (p *Player) publish(streamID string, check *broadcast.PlaybackCheck) {
+	var names, failed []string
+	for _, rendition := range check.Renditions {
+		names = append(names, rendition.Name)
+		if rendition.Error != "" {
+			failed = append(failed, rendition.Name)
+		}
+	}
+	if check.Playable() {
+		log.Printf("[Synthetic] Stream %s verified playable (%s)", streamID, strings.Join(names, ", "))
+		p.bus.Publish(events.StreamPlaybackVerified, map[string]any{"stream_id": streamID, "renditions": names})
+		return
+	}
+	log.Printf("[Synthetic] Stream %s is not playable: %s", streamID, check.Error)
+	p.bus.Publish(events.StreamPlaybackFailed, map[string]any{"stream_id": streamID, "error": check.Error, "renditions": failed})
+}
+
+// Check plays a stream: it waits for the master playlist and, for every
+// playlist it lists, the first segment, then decodes the newest segment of
+// each
+func (p *Player) Check(ctx context.Context, streamID string) *broadcast.PlaybackCheck {
+	check := &broadcast.PlaybackCheck{Status: broadcast.PlaybackCheckFailed, StartedAt: time.Now().UTC()}
+	defer func() {
+		checkedAt := time.Now().UTC()
+		check.CheckedAt = &checkedAt
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	masterURL := p.playlistURL(streamID)
+	var master *m3u8.MasterPlaylist
+	err := p.poll(ctx, func() error {
+		data, err := p.fetch(ctx, streamID, masterURL)
+		if err != nil {
+			return err
+		}
+		if master, err = m3u8.ParseMaster(data); err != nil {
+			return err
+		}
+		if len(master.URIs()) == 0 {
+			return fmt.Errorf("master playlist lists no renditions")
+		}
+		return nil
+	})
+	if err != nil {
+		check.Error = "master playlist: " + err.Error()
+		return check
+	}
+
+	var failed []string
+	for _, uri := range master.URIs() {
+		rendition := p.checkRendition(ctx, streamID, resolve(masterURL, uri))
+		if rendition.Error != "" {
+			failed = append(failed, rendition.Name)
+		}
+		check.Renditions = append(check.Renditions, rendition)
+	}
+	if len(failed) > 0 {
+		check.Error = "renditions not playable: " + strings.Join(failed, ", ")
+		return check
+	}
+	check.Status = broadcast.PlaybackCheckPlayable
+	return check
+}
+
+// checkRendition decodes the newest segment of a media playlist
+func (p *Player) checkRendition(ctx context.Context, streamID, playlistURL string) broadcast.RenditionCheck {
+	rendition := broadcast.RenditionCheck{Name: path.Base(path.Dir(urlPath(playlistURL)))}
+
+	var playlist *m3u8.MediaPlaylist
+	err := p.poll(ctx, func() error {
+		data, err := p.fetch(ctx, streamID, playlistURL)
+		if err != nil {
+			return err
+		}
+		if playlist, err = m3u8.ParseMedia(data); err != nil {
+			return err
+		}
+		if len(playlist.Segments) == 0 {
+			return fmt.Errorf("no segments yet")
+		}
+		return nil
+	})
+	if err != nil {
+		rendition.Error = "playlist: " + err.Error()
+		return rendition
+	}
+
+	segment := playlist.Segments[len(playlist.Segments)-1]
+	rendition.Segment = segment.URI
+	var media []byte
+	if init := playlist.InitURI(); init != "" {
+		if media, err = p.fetch(ctx, streamID, resolve(playlistURL, init)); err != nil {
+			rendition.Error = "init section: " + err.Error()
+			return rendition
+		}
+	}
+	data, err := p.fetch(ctx, streamID, resolve(playlistURL, segment.URI))
+	if err != nil {
+		rendition.Error = "segment: " + err.Error()
+		return rendition
+	}
+	if err := probe(ctx, append(media, data...), &rendition); err != nil {
+		rendition.Error = "decode: " + err.Error()
+	}
+	return rendition
+}
+
+// poll runs try until it succeeds or ctx ends, returning its last error
+func (p *Player) poll(ctx context.Context, try func() error) error {
+	for {
+		err := try()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// fetch downloads a URL of a stream's playback
+func (p *Player) fetch(ctx context.Context, streamID, uri string) ([]byte, error) {
+	if p.sign != nil {
+		uri = p.sign(streamID, uri)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSegmentBytes))
+}
+
+// probe decodes a segment with ffprobe and notes its first stream and how
+// many video frames decoded
+func probe(ctx context.Context, data []byte, rendition *broadcast.RenditionCheck) error {
+	file, err := os.CreateTemp("", "synthetic-*"+path.Ext(rendition.Segment))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-count_frames",
+		"-show_entries", "stream=codec_type,codec_name,width,height,nb_read_frames", "-of", "json", file.Name()).Output()
+	if err != nil {
+		return fmt.Errorf("ffprobe failed: %w", err)
+	}
+	var result struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+			Frames    string `json:"nb_read_frames"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return fmt.Errorf("unreadable ffprobe output: %w", err)
+	}
+	if len(result.Streams) == 0 {
+		return fmt.Errorf("no streams")
+	}
+	for _, stream := range result.Streams {
+		if rendition.Codec == "" || stream.CodecType == "video" {
+			rendition.Codec = stream.CodecName
+			rendition.Width, rendition.Height = stream.Width, stream.Height
+		}
+		if stream.CodecType == "video" {
+			fmt.Sscan(stream.Frames, &rendition.Frames)
+			if rendition.Frames == 0 {
+				return fmt.Errorf("no video frames decoded")
+			}
+		}
+	}
+	return nil
+}
+
+// resolve resolves a playlist URI against the URL of the playlist listing it
+func resolve(base, uri string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return b.ResolveReference(u).String()
+}
+
+// urlPath returns the path of a URL, or the URL itself when it doesn't parse
+func urlPath(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Path
+	}
+	return rawURL
+}
@@ -0,0 +1,81 @@
+package transcoder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"live-video/config"
+)
+
+// audioSource is an extra audio input and its FFmpeg input index
+type audioSource struct {
+	config.AudioInput
+	index int
+}
+
+// audioInputArgs returns the FFmpeg arguments reading an extra audio input.
+// HTTP sources reconnect on their own; the others end when their source does.
+func audioInputArgs(input config.AudioInput) []string {
+	var args []string
+	if strings.HasPrefix(input.URL, "http://") || strings.HasPrefix(input.URL, "https://") {
+		args = append(args, "-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "5")
+	}
+	return append(args, "-thread_queue_size", "1024", "-i", input.URL)
+}
+
+// mixGraph returns a filter graph mixing the mixed sources into program at
+// their volume and splitting the result into outputs labels [amix0], ...
+// The mix lasts as long as program, so a source that drops out only goes
+// silent.
+func mixGraph(program string, mixed []audioSource, outputs int) string {
+	var in strings.Builder
+	weights := []string{"1"}
+	fmt.Fprintf(&in, "[%s]", program)
+	for _, source := range mixed {
+		fmt.Fprintf(&in, "[%d:a:0]", source.index)
+		weights = append(weights, fmt.Sprintf("%g", source.Volume))
+	}
+
+	var out strings.Builder
+	for i := 0; i < outputs; i++ {
+		fmt.Fprintf(&out, "[amix%d]", i)
+	}
+	return fmt.Sprintf("%samix=inputs=%d:duration=first:dropout_transition=0:normalize=0:weights='%s',aresample=48000,asplit=%d%s",
+		in.String(), len(mixed)+1, strings.Join(weights, " "), outputs, out.String())
+}
+
+// alternateAudioArgs returns a separate HLS output for an alternate audio
+// source, in its own rendition folder next to the ladder's, so the ladder's
+// variants keep their program audio
+func (t *FFmpegTranscoder) alternateAudioArgs(source audioSource, outputPath string) []string {
+	bitrate := 0
+	for _, profile := range t.config.Profiles {
+		if profile.AudioBitrate > bitrate {
+			bitrate = profile.AudioBitrate
+		}
+	}
+
+	name := source.Rendition()
+	args := []string{
+		"-map", fmt.Sprintf("%d:a:0", source.index),
+		"-c:a", "aac",
+		"-b:a", fmt.Sprintf("%dk", bitrate),
+		"-ar", "48000",
+		"-ac", "2",
+	}
+	if source.Volume != 1 {
+		args = append(args, "-filter:a", fmt.Sprintf("volume=%g", source.Volume))
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", fmt.Sprint(t.config.SegmentDuration),
+		"-hls_list_size", fmt.Sprint(t.config.PlaylistSize),
+		"-hls_flags", "delete_segments+append_list+omit_endlist",
+	)
+	args = append(args, t.config.Segments.HLSArgs(filepath.Join(outputPath, name), name)...)
+	return append(args,
+		"-start_number", fmt.Sprint(t.config.StartNumber),
+		filepath.Join(outputPath, name, "playlist.m3u8"),
+	)
+}
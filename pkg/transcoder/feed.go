@@ -0,0 +1,422 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+)
+
+// Placeholder video fed to warm transcoders until a stream claims them
+const (
+	placeholderWidth  = 640
+	placeholderHeight = 360
+)
+
+// feedPollInterval is how often a Feed checks a growing input file for data
+const feedPollInterval = 20 * time.Millisecond
+
+// pullBitrate is the VP8 bitrate pulled sources are re-encoded at before
+// the transcoder, high enough for the top rendition
+const pullBitrate = "6M"
+
+// remoteReconnectMax caps the backoff of FFmpeg reconnecting to a remote
+// HLS or MP4 source that failed or answered with a 5xx
+const remoteReconnectMax = 5 * time.Second
+
+// rtspTimeout is how long an RTSP camera may send nothing before FFmpeg
+// gives up on it, so a camera that drops off the network is reconnected
+// rather than waited on forever
+const rtspTimeout = 5 * time.Second
+
+// Feed writes VP8 IVF video to a transcoder's stdin. It starts with a
+// generated black placeholder and switches to the sources it is given: an
+// IVF file a WebRTC ingest writes, or an RTMP, SRT, RTSP, HLS or MP4 URL,
+// re-encoded to VP8. FFmpeg keeps running across switches, and frame timestamps are
+// rewritten to stay continuous. When a source ends the placeholder plays
+// until the next switch.
+type Feed struct {
+	out      io.WriteCloser
+	rate     int // frames per second the transcoder reads the feed at
+	switchTo chan feedSwitch
+	frames   uint64
+
+	mu          sync.Mutex
+	source      string    // "" while the placeholder plays
+	firstFrame  time.Time // first frame of source
+	lastFrame   time.Time // last frame of source
+	pullFailure map[string]*PullState
+}
+
+// PullState is the connection of a feed to a pulled source
+type PullState struct {
+	Connected   bool      // the source plays and delivered a frame
+	Since       time.Time // first frame of the connection
+	Failures    int       // connections lost or refused so far
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// feedSwitch is a pending switch of a Feed
+type feedSwitch struct {
+	source string
+	live   bool // start a file at its end rather than its first keyframe
+}
+
+// errFeedClosed is returned once the transcoder stopped reading the feed
+var errFeedClosed = errors.New("feed closed")
+
+func newFeed(out io.WriteCloser, rate int) *Feed {
+	return &Feed{
+		out:      out,
+		rate:     rate,
+		switchTo: make(chan feedSwitch, 1),
+	}
+}
+
+// IsPullSource reports whether source is a URL the feed pulls with FFmpeg
+// rather than an IVF file
+func IsPullSource(source string) bool {
+	for _, scheme := range []string{"rtmp://", "rtmps://", "srt://", "rtsp://", "rtsps://", "http://", "https://"} {
+		if strings.HasPrefix(source, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Switch makes the feed play source, an IVF file from its first keyframe or
+// a URL to pull, instead of what it plays now. A file does not need to
+// exist yet. "" switches back to the placeholder.
+func (f *Feed) Switch(source string) {
+	f.queueSwitch(feedSwitch{source: source})
+}
+
+// SwitchLive is Switch, except that a file is played from the first
+// keyframe written after the switch, for files that were written for a while
+func (f *Feed) SwitchLive(source string) {
+	f.queueSwitch(feedSwitch{source: source, live: true})
+}
+
+func (f *Feed) queueSwitch(next feedSwitch) {
+	// A pending switch is replaced by the latest one
+	select {
+	case <-f.switchTo:
+	default:
+	}
+	select {
+	case f.switchTo <- next:
+	default:
+	}
+}
+
+// Source returns what the feed plays, "" for the placeholder, and when it
+// last got a frame from it
+func (f *Feed) Source() (string, time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.source, f.lastFrame
+}
+
+// Pull returns the connection of the feed to a pulled source, which
+// survives switching away from it
+func (f *Feed) Pull(source string) PullState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var state PullState
+	if failure := f.pullFailure[source]; failure != nil {
+		state = *failure
+	}
+	if f.source == source && !f.firstFrame.IsZero() {
+		state.Connected = true
+		state.Since = f.firstFrame
+	}
+	return state
+}
+
+// pullFailed records that a pulled source failed for reason
+func (f *Feed) pullFailed(source, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pullFailure == nil {
+		f.pullFailure = make(map[string]*PullState)
+	}
+	failure := f.pullFailure[source]
+	if failure == nil {
+		failure = &PullState{}
+		f.pullFailure[source] = failure
+	}
+	failure.Failures++
+	failure.LastError = reason
+	failure.LastErrorAt = time.Now()
+}
+
+// run feeds the placeholder and then the sources switched to until ctx is
+// done or the transcoder stops reading
+func (f *Feed) run(ctx context.Context) {
+	defer f.out.Close()
+
+	header := make([]byte, 32)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[6:], 32)
+	copy(header[8:], "VP80")
+	binary.LittleEndian.PutUint16(header[12:], placeholderWidth)
+	binary.LittleEndian.PutUint16(header[14:], placeholderHeight)
+	binary.LittleEndian.PutUint32(header[16:], uint32(f.rate))
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	if _, err := f.out.Write(header); err != nil {
+		return
+	}
+
+	var current feedSwitch
+	for {
+		source := current.source
+		f.mu.Lock()
+		f.source, f.firstFrame, f.lastFrame = source, time.Time{}, time.Time{}
+		f.mu.Unlock()
+
+		var next feedSwitch
+		var err error
+		switch {
+		case source == "":
+			next, err = f.forward(ctx, source, f.readPlaceholder)
+		case IsPullSource(source):
+			next, err = f.forward(ctx, source, func(ctx context.Context, frames chan<- []byte) error {
+				return f.readPull(ctx, source, frames)
+			})
+		default:
+			live := current.live
+			next, err = f.forward(ctx, source, func(ctx context.Context, frames chan<- []byte) error {
+				return readFile(ctx, source, live, frames)
+			})
+		}
+		if ctx.Err() != nil || errors.Is(err, errFeedClosed) {
+			return
+		}
+		if err != nil {
+			if source == "" {
+				log.Printf("[FFmpeg] Placeholder feed failed: %v", err)
+				return
+			}
+			// FFmpeg's errors may quote the source with its credentials
+			redacted := RedactSource(source)
+			reason := strings.ReplaceAll(err.Error(), source, redacted)
+			if IsPullSource(source) {
+				f.pullFailed(source, reason)
+			}
+			log.Printf("[FFmpeg] Input feed from %s failed: %s", redacted, reason)
+		}
+		current = next
+	}
+}
+
+// forward writes the frames read from source until Switch is called and
+// returns what to switch to. It returns an error when source ends first.
+func (f *Feed) forward(ctx context.Context, source string, read func(ctx context.Context, frames chan<- []byte) error) (feedSwitch, error) {
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	frames := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- read(readCtx, frames)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return feedSwitch{}, ctx.Err()
+		case next := <-f.switchTo:
+			return next, nil
+		case err := <-readErr:
+			if err == nil {
+				err = io.EOF
+			}
+			return feedSwitch{}, err
+		case frame := <-frames:
+			if err := f.writeFrame(frame); err != nil {
+				return feedSwitch{}, fmt.Errorf("%w: %v", errFeedClosed, err)
+			}
+			if source != "" {
+				f.mu.Lock()
+				f.lastFrame = time.Now()
+				if f.firstFrame.IsZero() {
+					f.firstFrame = f.lastFrame
+				}
+				f.mu.Unlock()
+			}
+		}
+	}
+}
+
+// readPlaceholder reads black frames
+func (f *Feed) readPlaceholder(ctx context.Context, frames chan<- []byte) error {
+	return readCommand(ctx, frames,
+		"-re", "-f", "lavfi", "-i", fmt.Sprintf("color=c=black:s=%dx%d:r=%d", placeholderWidth, placeholderHeight, f.rate),
+		"-c:v", "libvpx", "-deadline", "realtime", "-cpu-used", "8", "-b:v", "100k", "-g", fmt.Sprint(f.rate),
+		"-f", "ivf", "pipe:1",
+	)
+}
+
+// readPull reads the video of an RTMP, SRT, RTSP, HLS or MP4 URL re-encoded
+// to VP8 at the feed's frame rate. RTSP is pulled over TCP, which passes the
+// NATs and firewalls cameras sit behind and loses no packets. HTTP sources
+// are read at their own pace, reconnecting on errors and 5xx responses.
+func (f *Feed) readPull(ctx context.Context, url string, frames chan<- []byte) error {
+	var args []string
+	switch {
+	case strings.HasPrefix(url, "rtsp://") || strings.HasPrefix(url, "rtsps://"):
+		args = append(args, "-rtsp_transport", "tcp", "-timeout", fmt.Sprint(rtspTimeout.Microseconds()))
+	case strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://"):
+		args = append(args, "-re",
+			"-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_on_http_error", "5xx",
+			"-reconnect_delay_max", fmt.Sprint(int(remoteReconnectMax.Seconds())))
+	}
+	return readCommand(ctx, frames, append(args,
+		"-fflags", "nobuffer", "-i", url,
+		"-an", "-r", fmt.Sprint(f.rate),
+		"-c:v", "libvpx", "-deadline", "realtime", "-cpu-used", "8", "-b:v", pullBitrate, "-g", fmt.Sprint(f.rate),
+		"-f", "ivf", "pipe:1",
+	)...)
+}
+
+// RedactSource returns a source URL without the secrets it may carry: the
+// password of RTSP and RTMP credentials and an SRT passphrase
+func RedactSource(source string) string {
+	u, err := url.Parse(source)
+	if err != nil || u.Scheme == "" {
+		return source
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	if query := u.Query(); query.Has("passphrase") {
+		query.Set("passphrase", "redacted")
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// readCommand reads the IVF frames FFmpeg writes with args
+func readCommand(ctx context.Context, frames chan<- []byte, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-hide_banner", "-loglevel", "error"}, args...)...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	defer cmd.Wait()
+
+	reader, _, err := ivfreader.NewWith(stdout)
+	for err == nil {
+		var frame []byte
+		if frame, _, err = reader.ParseNextFrame(); err == nil {
+			select {
+			case frames <- frame:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+}
+
+// readFile reads the frames of a growing IVF file from its first keyframe,
+// or with live from the first keyframe after its end at the time it is read
+func readFile(ctx context.Context, path string, live bool, frames chan<- []byte) error {
+	var file *os.File
+	for file == nil {
+		var err error
+		if file, err = os.Open(path); err != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(feedPollInterval):
+			}
+		}
+	}
+	defer file.Close()
+
+	tail := &tailReader{ctx: ctx, file: file}
+	reader, header, err := ivfreader.NewWith(tail)
+	if err != nil {
+		return err
+	}
+	if header.FourCC != "VP80" {
+		return fmt.Errorf("unsupported codec %s", header.FourCC)
+	}
+
+	keyframe := false
+	for {
+		frame, _, err := reader.ParseNextFrame()
+		if err != nil {
+			return err
+		}
+		if live && !tail.caughtUp {
+			continue
+		}
+		// Bit 0 of a VP8 frame tag is 0 on keyframes
+		if !keyframe && (len(frame) == 0 || frame[0]&0x01 != 0) {
+			continue
+		}
+		keyframe = true
+		select {
+		case frames <- frame:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *Feed) writeFrame(frame []byte) error {
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(frame)))
+	binary.LittleEndian.PutUint64(header[4:], f.frames)
+	f.frames++
+	if _, err := f.out.Write(header); err != nil {
+		return err
+	}
+	_, err := f.out.Write(frame)
+	return err
+}
+
+// tailReader reads a file that is still being written, waiting for more data
+// at its end
+type tailReader struct {
+	ctx      context.Context
+	file     *os.File
+	caughtUp bool // reached the end of the file once
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	for {
+		n, err := t.file.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		t.caughtUp = true
+		select {
+		case <-t.ctx.Done():
+			return 0, t.ctx.Err()
+		case <-time.After(feedPollInterval):
+		}
+	}
+}
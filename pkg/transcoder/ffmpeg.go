@@ -3,12 +3,14 @@ package transcoder
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"live-video/config"
 )
@@ -20,6 +22,10 @@ type FFmpegTranscoder struct {
 	mu      sync.Mutex
 	running bool
 	cancel  context.CancelFunc
+	exited  chan struct{} // closed once FFmpeg exited
+
+	rendition bool // encodes a rendition added next to a ladder, without a master playlist
+	progress  progress
 }
 
 // NewFFmpegTranscoder creates a new FFmpeg transcoder
@@ -29,6 +35,16 @@ func NewFFmpegTranscoder(cfg *config.FFmpegConfig) *FFmpegTranscoder {
 	}
 }
 
+// NewRenditionTranscoder creates a transcoder for renditions added to a
+// running ladder, writing into its output. It leaves the ladder's master
+// playlist alone.
+func NewRenditionTranscoder(cfg *config.FFmpegConfig) *FFmpegTranscoder {
+	return &FFmpegTranscoder{
+		config:    cfg,
+		rendition: true,
+	}
+}
+
 // StartHLSTranscoding starts FFmpeg transcoding for HLS output
 func (t *FFmpegTranscoder) StartHLSTranscoding(ctx context.Context, inputURL string, streamID string, outputPath string) error {
 	t.mu.Lock()
@@ -42,10 +58,60 @@ func (t *FFmpegTranscoder) StartHLSTranscoding(ctx context.Context, inputURL str
 	if err := t.createOutputDirs(outputPath); err != nil {
 		return fmt.Errorf("failed to create output directories: %w", err)
 	}
+	if err := t.writeMasterPlaylist(outputPath); err != nil {
+		return fmt.Errorf("failed to write master playlist: %w", err)
+	}
 
 	// Build FFmpeg command
 	args := t.buildFFmpegArgs(inputURL, streamID, outputPath)
+	if _, err := t.start(ctx, args, nil); err != nil {
+		return err
+	}
+
+	log.Printf("[FFmpeg] Started successfully for stream %s", streamID)
+	return nil
+}
+
+// StartFeedHLSTranscoding starts FFmpeg transcoding a Feed to HLS in
+// outputPath. The feed plays a placeholder until it is switched to a
+// stream's input, and switches between inputs without a restart.
+func (t *FFmpegTranscoder) StartFeedHLSTranscoding(ctx context.Context, name string, outputPath string) (*Feed, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
+	if t.running {
+		return nil, fmt.Errorf("transcoder already running")
+	}
+	if err := t.createOutputDirs(outputPath); err != nil {
+		return nil, fmt.Errorf("failed to create output directories: %w", err)
+	}
+	if err := t.writeMasterPlaylist(outputPath); err != nil {
+		return nil, fmt.Errorf("failed to write master playlist: %w", err)
+	}
+
+	reader, writer := io.Pipe()
+	args := t.buildFFmpegArgs("pipe:0", name, outputPath)
+	cmdCtx, err := t.start(ctx, args, reader)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	// The feed ends with FFmpeg
+	feed := newFeed(writer, t.config.InputFramerate)
+	go feed.run(cmdCtx)
+	go func() {
+		<-cmdCtx.Done()
+		reader.Close()
+	}()
+
+	log.Printf("[FFmpeg] Started feed transcoder %s", name)
+	return feed, nil
+}
+
+// start runs FFmpeg with args and stdin and monitors it. The returned context
+// is done once FFmpeg is stopped or exits. Callers hold t.mu.
+func (t *FFmpegTranscoder) start(ctx context.Context, args []string, stdin io.Reader) (context.Context, error) {
 	log.Printf("[FFmpeg] Starting with args: ffmpeg %s", strings.Join(args, " "))
 
 	// Create context with cancel
@@ -54,20 +120,29 @@ func (t *FFmpegTranscoder) StartHLSTranscoding(ctx context.Context, inputURL str
 
 	// Create FFmpeg command
 	t.cmd = exec.CommandContext(cmdCtx, "ffmpeg", args...)
+	t.cmd.Stdin = stdin
 	t.cmd.Stdout = os.Stdout
-	t.cmd.Stderr = os.Stderr
+	t.cmd.Stderr = &progressWriter{out: os.Stderr, progress: &t.progress}
+	t.progress.reset()
+	// Don't wait for a feed stalled on its input once FFmpeg is gone
+	t.cmd.WaitDelay = time.Second
 
 	// Start FFmpeg
 	if err := t.cmd.Start(); err != nil {
 		cancel()
-		return fmt.Errorf("failed to start ffmpeg: %w", err)
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
 
 	t.running = true
+	exited := make(chan struct{})
+	t.exited = exited
 
 	// Monitor FFmpeg process
+	cmd := t.cmd
 	go func() {
-		err := t.cmd.Wait()
+		err := cmd.Wait()
+		defer close(exited)
+		cancel()
 		t.mu.Lock()
 		t.running = false
 		t.mu.Unlock()
@@ -78,17 +153,14 @@ func (t *FFmpegTranscoder) StartHLSTranscoding(ctx context.Context, inputURL str
 			log.Printf("[FFmpeg] Exited normally")
 		}
 	}()
-
-	log.Printf("[FFmpeg] Started successfully for stream %s", streamID)
-	return nil
+	return cmdCtx, nil
 }
 
 // Stop stops the FFmpeg transcoder
 func (t *FFmpegTranscoder) Stop() error {
 	t.mu.Lock()
-	defer t.mu.Unlock()
-
 	if !t.running {
+		t.mu.Unlock()
 		return nil
 	}
 
@@ -99,6 +171,11 @@ func (t *FFmpegTranscoder) Stop() error {
 	}
 
 	t.running = false
+	exited := t.exited
+	t.mu.Unlock()
+
+	// FFmpeg writes nothing more once Stop returns
+	<-exited
 	return nil
 }
 
@@ -109,6 +186,14 @@ func (t *FFmpegTranscoder) IsRunning() bool {
 	return t.running
 }
 
+// Done returns a channel closed once the last started FFmpeg exited, nil
+// before the first start
+func (t *FFmpegTranscoder) Done() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.exited
+}
+
 // createOutputDirs creates the output directory structure
 func (t *FFmpegTranscoder) createOutputDirs(basePath string) error {
 	// Create base directory
@@ -122,6 +207,20 @@ func (t *FFmpegTranscoder) createOutputDirs(basePath string) error {
 		if err := os.MkdirAll(profilePath, 0o755); err != nil {
 			return err
 		}
+		if t.config.Watermark {
+			if err := os.MkdirAll(filepath.Join(basePath, config.WatermarkVariant(profile.Name)), 0o755); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Create alternate audio directories
+	for _, input := range t.config.AudioInputs {
+		if input.Mode == config.AudioModeAlternate {
+			if err := os.MkdirAll(filepath.Join(basePath, input.Rendition()), 0o755); err != nil {
+				return err
+			}
+		}
 	}
 
 	// Create recording directory if enabled
@@ -155,32 +254,87 @@ func (t *FFmpegTranscoder) buildFFmpegArgs(inputURL string, streamID string, out
 		// Single input (video only)
 		// IVF files don't have timestamps, so we need to specify input framerate
 		// Use -re to read at native frame rate for live streaming
-		args = append(args, "-re", "-f", "ivf", "-r", "30", "-i", inputURL)
+		args = append(args, "-re", "-f", "ivf", "-r", fmt.Sprint(t.config.InputFramerate), "-i", inputURL)
 		// Add silent audio source since we don't have audio input
 		args = append(args, "-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=48000")
 	}
 
+	// Extra audio inputs follow the program's video and audio
+	next := 2
+	if len(files) > 1 {
+		next = len(files)
+	}
+	var mixed, alternates []audioSource
+	for _, input := range t.config.AudioInputs {
+		args = append(args, audioInputArgs(input)...)
+		source := audioSource{AudioInput: input, index: next}
+		next++
+		if input.Mode == config.AudioModeAlternate {
+			alternates = append(alternates, source)
+		} else {
+			mixed = append(mixed, source)
+		}
+	}
+	// The marked variants are encoded as streams after the ladder's
+	variants := len(t.config.Profiles)
+	if t.config.Watermark {
+		variants *= 2
+	}
+	if len(mixed) > 0 {
+		args = append(args, "-filter_complex", mixGraph("1:a:0", mixed, variants))
+	}
+
 	// Add global output options
 	args = append(args, "-fps_mode", "cfr")
 
 	// Add video encoding settings for each profile
 	varStreamMap := make([]string, 0)
+	inputRate := config.Rate{Num: t.config.InputFramerate, Den: 1}
+	screen := t.config.ContentType == config.ContentScreen
+
+	for i := 0; i < variants; i++ {
+		profile := t.config.Profiles[i%len(t.config.Profiles)]
+		marked := i >= len(t.config.Profiles)
+
+		// Fit the input into the profile size, turned portrait for portrait
+		// input, keeping its aspect ratio. Input of the other orientation,
+		// e.g. on a warm transcoder, is pillarboxed rather than squashed.
+		width, height := profile.Size(t.config.Portrait)
+		// GOPs of 2 seconds at the rendition's own frame rate; screen
+		// content keeps keyframes to segment starts
+		rate := profile.OutputRate(inputRate)
+		gopSeconds, scaler := 2, ""
+		if screen {
+			gopSeconds, scaler = max(t.config.SegmentDuration, 2), ":flags=lanczos"
+		}
+		gop := fmt.Sprint(rate.Frames(gopSeconds))
+
+		// The marked variant differs only by its mark, so its segments start
+		// on the same frames and carry the same media sequence numbers
+		filter := fmt.Sprintf("fps=%s,scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2%s,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1", rate, width, height, scaler, width, height)
+		name := profile.Name
+		if marked {
+			filter += "," + config.WatermarkFilter(width, height)
+			name = config.WatermarkVariant(profile.Name)
+		}
 
-	for i, profile := range t.config.Profiles {
 		// Video encoding (always from input 0)
 		args = append(args,
 			"-map", "0:v:0",
 			"-c:v:"+fmt.Sprint(i), "libx264",
-			"-s:v:"+fmt.Sprint(i), fmt.Sprintf("%dx%d", profile.Width, profile.Height),
+			"-filter:v:"+fmt.Sprint(i), filter,
 			"-b:v:"+fmt.Sprint(i), fmt.Sprintf("%dk", profile.VideoBitrate),
 			"-maxrate:v:"+fmt.Sprint(i), fmt.Sprintf("%dk", profile.VideoBitrate),
 			"-bufsize:v:"+fmt.Sprint(i), fmt.Sprintf("%dk", profile.VideoBitrate*2),
 			"-preset", profile.Preset,
-			"-g", fmt.Sprint(profile.Framerate*2), // GOP size = 2 seconds
-			"-keyint_min", fmt.Sprint(profile.Framerate*2),
+			"-g:v:"+fmt.Sprint(i), gop,
+			"-keyint_min:v:"+fmt.Sprint(i), gop,
 			"-sc_threshold", "0",
 			"-profile:v:"+fmt.Sprint(i), "high",
 		)
+		if screen {
+			args = append(args, "-tune:v:"+fmt.Sprint(i), "stillimage")
+		}
 
 		// Audio encoding
 		// If single input (video only), audio is from input 1 (anullsrc)
@@ -190,6 +344,10 @@ func (t *FFmpegTranscoder) buildFFmpegArgs(inputURL string, streamID string, out
 			// Single input with generated silent audio
 			audioInput = "1:a:0"
 		}
+		if len(mixed) > 0 {
+			// Program audio mixed with the extra inputs
+			audioInput = fmt.Sprintf("[amix%d]", i)
+		}
 
 		args = append(args,
 			"-map", audioInput,
@@ -200,7 +358,7 @@ func (t *FFmpegTranscoder) buildFFmpegArgs(inputURL string, streamID string, out
 		)
 
 		// Build var_stream_map
-		varStreamMap = append(varStreamMap, fmt.Sprintf("v:%d,a:%d,name:%s", i, i, profile.Name))
+		varStreamMap = append(varStreamMap, fmt.Sprintf("v:%d,a:%d,name:%s", i, i, name))
 	}
 
 	// HLS settings
@@ -209,11 +367,11 @@ func (t *FFmpegTranscoder) buildFFmpegArgs(inputURL string, streamID string, out
 		"-hls_time", fmt.Sprint(t.config.SegmentDuration),
 		"-hls_list_size", fmt.Sprint(t.config.PlaylistSize),
 		"-hls_flags", "delete_segments+append_list+omit_endlist+independent_segments",
-		"-hls_segment_type", "mpegts",
-		"-hls_segment_filename", filepath.Join(outputPath, "%v", "segment_%03d.ts"),
-		"-master_pl_name", "playlist.m3u8",
+	)
+	args = append(args, t.config.Segments.HLSArgs(filepath.Join(outputPath, "%v"), "%v")...)
+	args = append(args,
 		"-var_stream_map", strings.Join(varStreamMap, " "),
-		"-start_number", "0", // Start from segment 0
+		"-start_number", fmt.Sprint(t.config.StartNumber),
 	)
 
 	// Low latency mode
@@ -227,6 +385,11 @@ func (t *FFmpegTranscoder) buildFFmpegArgs(inputURL string, streamID string, out
 	// Output path pattern
 	args = append(args, filepath.Join(outputPath, "%v", "playlist.m3u8"))
 
+	// Alternate audio renditions
+	for _, source := range alternates {
+		args = append(args, t.alternateAudioArgs(source, outputPath)...)
+	}
+
 	// Add recording output if enabled
 	if t.config.Recording.Enabled {
 		recordPath := filepath.Join(outputPath, "recording", fmt.Sprintf("%s.%s", streamID, t.config.Recording.Format))
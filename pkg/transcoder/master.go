@@ -0,0 +1,86 @@
+package transcoder
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"live-video/config"
+	"live-video/pkg/m3u8"
+)
+
+// playlistName is the name of the master playlist and of the media playlist
+// in each rendition's folder
+const playlistName = "playlist.m3u8"
+
+// audioGroup is the group of the alternate audio renditions
+const audioGroup = "audio"
+
+// MasterPlaylist returns the master playlist of the ladder cfg encodes, with
+// the alternate audio renditions as an audio group. It is written by Go
+// rather than FFmpeg, which knows neither the codec levels nor the alternate
+// renditions.
+func MasterPlaylist(cfg *config.FFmpegConfig) []byte {
+	playlist := &m3u8.MasterPlaylist{Tags: []m3u8.Tag{
+		{Name: m3u8.TagVersion, Value: "3"},
+		{Name: m3u8.TagIndependentSegments},
+	}}
+
+	grouped := false
+	for _, input := range cfg.AudioInputs {
+		if input.Mode != config.AudioModeAlternate {
+			continue
+		}
+		if !grouped {
+			playlist.Tags = append(playlist.Tags, m3u8.NewTag(m3u8.TagMedia, m3u8.Attributes{
+				{Key: "TYPE", Value: "AUDIO"},
+				{Key: "GROUP-ID", Value: m3u8.Quote(audioGroup)},
+				{Key: "NAME", Value: m3u8.Quote("Main")},
+				{Key: "DEFAULT", Value: "YES"},
+				{Key: "AUTOSELECT", Value: "YES"},
+			}))
+			grouped = true
+		}
+		attrs := m3u8.Attributes{
+			{Key: "TYPE", Value: "AUDIO"},
+			{Key: "GROUP-ID", Value: m3u8.Quote(audioGroup)},
+			{Key: "NAME", Value: m3u8.Quote(input.Label)},
+		}
+		if input.Language != "" {
+			attrs.Set("LANGUAGE", m3u8.Quote(input.Language))
+		}
+		attrs.Set("DEFAULT", "NO")
+		attrs.Set("AUTOSELECT", "YES")
+		attrs.Set("URI", m3u8.Quote(path.Join(input.Rendition(), playlistName)))
+		playlist.Tags = append(playlist.Tags, m3u8.NewTag(m3u8.TagMedia, attrs))
+	}
+
+	for _, profile := range cfg.Profiles {
+		attrs := m3u8.ParseAttributes(cfg.StreamInf(profile))
+		if grouped {
+			attrs.Set("AUDIO", m3u8.Quote(audioGroup))
+		}
+		playlist.Variants = append(playlist.Variants, m3u8.Variant{Attributes: attrs, URI: path.Join(profile.Name, playlistName)})
+	}
+	return playlist.Encode()
+}
+
+// WriteMasterPlaylist writes the master playlist of the ladder cfg encodes
+// to outputPath, replacing it at once so the uploader never reads half of it
+func WriteMasterPlaylist(cfg *config.FFmpegConfig, outputPath string) error {
+	target := filepath.Join(outputPath, playlistName)
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, MasterPlaylist(cfg), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// writeMasterPlaylist writes the master playlist of the transcoder's ladder.
+// Callers hold t.mu.
+func (t *FFmpegTranscoder) writeMasterPlaylist(outputPath string) error {
+	if t.rendition {
+		return nil
+	}
+	return WriteMasterPlaylist(t.config, outputPath)
+}
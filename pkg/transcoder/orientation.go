@@ -0,0 +1,47 @@
+package transcoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+)
+
+// IVFFrameSize returns the size of the first VP8 keyframe of an IVF file.
+// Browsers rotate camera frames before encoding them, so portrait phone
+// video arrives as portrait frames; the IVF header size is not reliable.
+func IVFFrameSize(path string) (width, height int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	reader, header, err := ivfreader.NewWith(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	if header.FourCC != "VP80" {
+		return 0, 0, fmt.Errorf("unsupported codec %s", header.FourCC)
+	}
+
+	for {
+		frame, _, err := reader.ParseNextFrame()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, 0, fmt.Errorf("no keyframe yet")
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		// A keyframe tag (bit 0 clear) is followed by the start code and
+		// the 14-bit width and height
+		if len(frame) < 10 || frame[0]&0x01 != 0 || frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+			continue
+		}
+		width = int(binary.LittleEndian.Uint16(frame[6:]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(frame[8:]) & 0x3fff)
+		return width, height, nil
+	}
+}
@@ -0,0 +1,87 @@
+package transcoder
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// progress is the last speed FFmpeg reported: how many seconds of input it
+// encodes per second, below 1 when it falls behind real time
+type progress struct {
+	mu    sync.Mutex
+	speed float64
+	at    time.Time
+}
+
+func (p *progress) reset() {
+	p.mu.Lock()
+	p.speed, p.at = 0, time.Time{}
+	p.mu.Unlock()
+}
+
+// progressWriter passes FFmpeg's stderr through and keeps the speed= of its
+// progress lines
+type progressWriter struct {
+	out      io.Writer
+	progress *progress
+	line     []byte
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c == '\r' || c == '\n' {
+			w.parse()
+			w.line = w.line[:0]
+		} else if len(w.line) < 1024 {
+			w.line = append(w.line, c)
+		}
+	}
+	return w.out.Write(p)
+}
+
+// parse reads speed= off a progress line such as
+// "frame= 240 fps= 30 ... speed=0.998x"
+func (w *progressWriter) parse() {
+	i := bytes.LastIndex(w.line, []byte("speed="))
+	if i < 0 {
+		return
+	}
+	value := bytes.TrimSpace(w.line[i+len("speed="):])
+	value, _, _ = bytes.Cut(value, []byte("x"))
+	speed, err := strconv.ParseFloat(string(bytes.TrimSpace(value)), 64)
+	if err != nil {
+		return
+	}
+	w.progress.mu.Lock()
+	w.progress.speed, w.progress.at = speed, time.Now()
+	w.progress.mu.Unlock()
+}
+
+// Speed returns the last speed FFmpeg reported and when, zero before the
+// first report
+func (t *FFmpegTranscoder) Speed() (float64, time.Time) {
+	t.progress.mu.Lock()
+	defer t.progress.mu.Unlock()
+	return t.progress.speed, t.progress.at
+}
+
+// FasterPreset returns the libx264 preset steps faster than preset, the
+// fastest of all for steps < 0. Unknown presets are kept.
+func FasterPreset(preset string, steps int) string {
+	for i, known := range presets {
+		if known != preset {
+			continue
+		}
+		if steps < 0 || i+steps >= len(presets) {
+			return presets[len(presets)-1]
+		}
+		return presets[i+steps]
+	}
+	return preset
+}
+
+// presets are the libx264 presets from slowest to fastest
+var presets = []string{"veryslow", "slower", "slow", "medium", "fast", "faster", "veryfast", "superfast", "ultrafast"}
@@ -0,0 +1,385 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Breaker states
+const (
+	StateClosed   = "closed"    // requests go through
+	StateOpen     = "open"      // requests fail fast until the cooldown passes
+	StateHalfOpen = "half_open" // one probe request decides whether to close
+)
+
+// ErrOpen is returned instead of a request while the breaker is open
+var ErrOpen = errors.New("upstream circuit breaker is open")
+
+// ErrNotAllowed is returned for requests to hosts or addresses the client
+// may not reach
+var ErrNotAllowed = errors.New("upstream host not allowed")
+
+// maxRedirects bounds the redirects followed within the allowed hosts
+const maxRedirects = 3
+
+// retryBase is the backoff ceiling before the first retry, doubling after
+const retryBase = 100 * time.Millisecond
+
+// Options tune a Client. Timeout bounds each attempt until response headers
+// arrive, so long bodies aren't cut off; MaxAttempts is how often a request
+// is tried; the breaker opens after FailureThreshold failed requests in a row
+// and lets a probe through after Cooldown. Requests and redirects may only go
+// to the base URL's host and AllowedHosts ("cdn.example.com" or
+// "*.example.com"), and never to loopback, private or link-local addresses
+// unless AllowPrivate is set.
+type Options struct {
+	Timeout          time.Duration `json:"timeout"`
+	MaxAttempts      int           `json:"max_attempts"`
+	FailureThreshold int           `json:"failure_threshold"`
+	Cooldown         time.Duration `json:"cooldown"`
+	AllowedHosts     []string      `json:"allowed_hosts,omitempty"`
+	AllowPrivate     bool          `json:"allow_private"`
+}
+
+// DefaultOptions returns the options used unless configured
+func DefaultOptions() Options {
+	return Options{
+		Timeout:          10 * time.Second,
+		MaxAttempts:      3,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}
+}
+
+// Client fetches objects from an HTTP upstream over pooled connections,
+// retrying failed attempts with jittered backoff and failing fast behind a
+// circuit breaker while the upstream keeps failing
+type Client struct {
+	baseURL string
+	opts    Options
+	client  *http.Client
+
+	mu        sync.Mutex
+	state     string
+	failures  int // failed requests in a row
+	openedAt  time.Time
+	probing   bool // a half-open probe is in flight
+	lastError string
+	lastErrAt time.Time
+	lastOKAt  time.Time
+}
+
+// New creates a client of the upstream at baseURL
+func New(baseURL string, opts Options) *Client {
+	defaults := DefaultOptions()
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaults.Timeout
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.FailureThreshold < 1 {
+		opts.FailureThreshold = defaults.FailureThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = defaults.Cooldown
+	}
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		opts:    opts,
+		state:   StateClosed,
+	}
+	if u, err := url.Parse(c.baseURL); err == nil && u.Hostname() != "" {
+		c.opts.AllowedHosts = append([]string{strings.ToLower(u.Hostname())}, opts.AllowedHosts...)
+	}
+	dialer := &net.Dialer{
+		Timeout:   opts.Timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	if !opts.AllowPrivate {
+		// Checked on the resolved address, so names resolving to internal
+		// addresses are refused too
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+				return fmt.Errorf("%w: %s is an internal address", ErrNotAllowed, host)
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		// No proxy from the environment: it would bypass the address check
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          256,
+		MaxIdleConnsPerHost:   64,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	c.client = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return c.check(req.URL)
+		},
+	}
+	return c
+}
+
+// check returns ErrNotAllowed unless u is an http(s) URL of an allowed host
+func (c *Client) check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q", ErrNotAllowed, u.Scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: URL with credentials", ErrNotAllowed)
+	}
+	host := strings.ToLower(u.Hostname())
+	if !hostAllowed(host, c.opts.AllowedHosts) {
+		return fmt.Errorf("%w: %s", ErrNotAllowed, host)
+	}
+	return nil
+}
+
+// hostAllowed reports whether host is one of allowedHosts ("cdn.example.com")
+// or under one of their wildcards ("*.example.com")
+func hostAllowed(host string, allowedHosts []string) bool {
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok && strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy restricts the hosts of URLs callers ask the service to pull, such
+// as the inputs of a stream, so they can't make it reach internal services
+// or the cloud metadata server. Without AllowedHosts any host is allowed;
+// hosts resolving to loopback, private or link-local addresses are refused
+// unless AllowPrivate is set.
+type Policy struct {
+	AllowedHosts []string `json:"allowed_hosts,omitempty"`
+	AllowPrivate bool     `json:"allow_private"`
+}
+
+// Check returns ErrNotAllowed unless the host of rawURL is allowed and
+// resolves to public addresses only
+func (p Policy) Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: url without a host", ErrNotAllowed)
+	}
+	if len(p.AllowedHosts) > 0 && !hostAllowed(host, p.AllowedHosts) {
+		return fmt.Errorf("%w: %s", ErrNotAllowed, host)
+	}
+	if p.AllowPrivate {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if internalIP(addr.IP) {
+			return fmt.Errorf("%w: %s is an internal address", ErrNotAllowed, host)
+		}
+	}
+	return nil
+}
+
+// internalIP reports whether ip is loopback, private, link-local (cloud
+// metadata servers included), unspecified or multicast
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// BaseURL returns the URL objects are fetched under
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// URL returns the upstream URL of an object path
+func (c *Client) URL(path string) string {
+	return c.baseURL + "/" + strings.TrimPrefix(path, "/")
+}
+
+// Get fetches rawURL, retrying connection errors and 5xx responses. Any
+// other response, 4xx included, counts as the upstream being healthy and is
+// returned as is. The caller closes the body of the returned response.
+func (c *Client) Get(ctx context.Context, rawURL string) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.check(u); err != nil {
+		return nil, err
+	}
+	if !c.allow() {
+		return nil, ErrOpen
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < c.opts.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			// Full jitter keeps proxies from retrying in lockstep
+			backoff := time.Duration(rand.Int63n(int64(retryBase << (attempt - 1))))
+			select {
+			case <-ctx.Done():
+				c.done(ctx.Err(), true)
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			c.done(nil, true)
+			return nil, err
+		}
+		resp, err := c.client.Do(req)
+		if errors.Is(err, ErrNotAllowed) {
+			// A refused address or redirect is not the upstream failing
+			c.done(nil, true)
+			return nil, err
+		}
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			c.done(nil, false)
+			return resp, nil
+		}
+		if err == nil {
+			lastErr = fmt.Errorf("upstream returned %s", resp.Status)
+			if attempt == c.opts.MaxAttempts-1 {
+				c.done(lastErr, false)
+				return resp, nil
+			}
+			resp.Body.Close()
+			continue
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			// The client went away; that says nothing about the upstream
+			c.done(ctx.Err(), true)
+			return nil, err
+		}
+	}
+	c.done(lastErr, false)
+	return nil, lastErr
+}
+
+// allow reports whether a request may go to the upstream, turning an open
+// breaker half-open once its cooldown has passed
+func (c *Client) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case StateOpen:
+		if time.Since(c.openedAt) < c.opts.Cooldown {
+			return false
+		}
+		c.state = StateHalfOpen
+		c.probing = true
+		return true
+	case StateHalfOpen:
+		if c.probing {
+			return false
+		}
+		c.probing = true
+		return true
+	}
+	return true
+}
+
+// done records the outcome of a request. A nil err is a success; ignore
+// releases a probe without counting the request either way.
+func (c *Client) done(err error, ignore bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	probe := c.state == StateHalfOpen
+	if probe {
+		c.probing = false
+	}
+	if ignore {
+		return
+	}
+	if err == nil {
+		c.failures = 0
+		c.state = StateClosed
+		c.lastOKAt = time.Now()
+		return
+	}
+	c.failures++
+	c.lastError = err.Error()
+	c.lastErrAt = time.Now()
+	if probe || c.failures >= c.opts.FailureThreshold {
+		c.state = StateOpen
+		c.openedAt = time.Now()
+	}
+}
+
+// Health is the state of an upstream as seen by its client
+type Health struct {
+	URL         string     `json:"url"`
+	State       string     `json:"state"`
+	Failures    int        `json:"consecutive_failures"`
+	RetryAt     *time.Time `json:"retry_at,omitempty"` // when an open breaker lets a probe through
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	LastOKAt    *time.Time `json:"last_ok_at,omitempty"`
+	Options     Options    `json:"options"`
+}
+
+// Healthy reports whether requests go through to the upstream
+func (h Health) Healthy() bool {
+	return h.State != StateOpen
+}
+
+// Health returns the breaker state and recent outcomes of the upstream
+func (c *Client) Health() Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	health := Health{
+		URL:       c.baseURL,
+		State:     c.state,
+		Failures:  c.failures,
+		LastError: c.lastError,
+		Options:   c.opts,
+	}
+	if c.state == StateOpen {
+		retryAt := c.openedAt.Add(c.opts.Cooldown)
+		health.RetryAt = &retryAt
+	}
+	if !c.lastErrAt.IsZero() {
+		at := c.lastErrAt
+		health.LastErrorAt = &at
+	}
+	if !c.lastOKAt.IsZero() {
+		at := c.lastOKAt
+		health.LastOKAt = &at
+	}
+	return health
+}
@@ -0,0 +1,363 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/background"
+	"live-video/pkg/storage"
+)
+
+// AssetUsage is the storage used by one video or stream across all layout
+// prefixes
+type AssetUsage struct {
+	ID         string    `json:"id"`
+	Bytes      int64     `json:"bytes"`
+	Objects    int       `json:"objects"`
+	Segments   int64     `json:"segments_bytes"`
+	Playlists  int64     `json:"playlists_bytes"`
+	Recordings int64     `json:"recordings_bytes"`
+	Thumbnails int64     `json:"thumbnails_bytes"`
+	Other      int64     `json:"other_bytes"` // sources, manifests
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Ledger keeps running totals of the bytes stored per asset. It is updated on
+// every upload and delete instead of scanning the bucket, and persisted to a
+// file so the totals survive restarts. Each replica only sees its own writes,
+// so the ledger is also rebuilt from a listing of the bucket, which all
+// replicas share, every so often (see StartRebuilder).
+type Ledger struct {
+	mu        sync.Mutex
+	flushMu   sync.Mutex
+	rebuildMu sync.Mutex
+	layout    storage.Layout
+	objects   map[string]int64 // object path -> size, to handle overwrites and deletes
+	assets    map[string]*AssetUsage
+	file      string
+	dirty     bool
+	listing   bool            // a rebuild is listing the bucket
+	touched   map[string]bool // objects written or deleted during the listing
+}
+
+// ledgerFile is the on-disk form of the ledger
+type ledgerFile struct {
+	Objects map[string]int64 `json:"objects"`
+}
+
+// NewLedger creates a ledger for objects in layout, loading the totals saved
+// in file. An empty file keeps the ledger in memory only.
+func NewLedger(layout storage.Layout, file string) (*Ledger, error) {
+	l := &Ledger{
+		layout:  layout,
+		objects: make(map[string]int64),
+		assets:  make(map[string]*AssetUsage),
+		file:    file,
+	}
+	if file == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+	var saved ledgerFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse usage ledger: %w", err)
+	}
+	for name, size := range saved.Objects {
+		l.apply(name, size, true)
+	}
+	return l, nil
+}
+
+// Stored records that an object was written, replacing its previous size
+func (l *Ledger) Stored(gcsPath string, size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.classify(gcsPath); !ok {
+		return
+	}
+	if old, ok := l.objects[gcsPath]; ok {
+		l.apply(gcsPath, old, false)
+	}
+	l.apply(gcsPath, size, true)
+	l.touch(gcsPath)
+}
+
+// Removed records that an object was deleted
+func (l *Ledger) Removed(gcsPath string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if old, ok := l.objects[gcsPath]; ok {
+		l.apply(gcsPath, old, false)
+	}
+	l.touch(gcsPath)
+}
+
+// touch marks an object changed, so a listing in progress doesn't undo it.
+// Callers hold l.mu.
+func (l *Ledger) touch(gcsPath string) {
+	l.dirty = true
+	if l.listing {
+		l.touched[gcsPath] = true
+	}
+}
+
+// apply adds or removes an object and its size from its asset's totals.
+// Callers hold l.mu.
+func (l *Ledger) apply(gcsPath string, size int64, added bool) {
+	id, ok := l.classify(gcsPath)
+	if !ok {
+		return
+	}
+
+	asset, exists := l.assets[id]
+	if !exists {
+		asset = &AssetUsage{ID: id}
+		l.assets[id] = asset
+	}
+	delta := size
+	if added {
+		l.objects[gcsPath] = size
+		asset.Objects++
+	} else {
+		delete(l.objects, gcsPath)
+		delta = -size
+		asset.Objects--
+	}
+
+	asset.Bytes += delta
+	switch {
+	case strings.HasPrefix(gcsPath, l.layout.Recordings+"/"):
+		asset.Recordings += delta
+	case strings.HasPrefix(gcsPath, l.layout.Thumbnails+"/"):
+		asset.Thumbnails += delta
+	case path.Ext(gcsPath) == ".ts" || path.Ext(gcsPath) == ".m4s":
+		asset.Segments += delta
+	case path.Ext(gcsPath) == ".m3u8":
+		asset.Playlists += delta
+	default:
+		asset.Other += delta
+	}
+	asset.UpdatedAt = time.Now().UTC()
+
+	if asset.Objects <= 0 {
+		delete(l.assets, id)
+	}
+}
+
+// classify returns the video or stream ID an object belongs to
+func (l *Ledger) classify(gcsPath string) (string, bool) {
+	for _, prefix := range []string{l.layout.Live, l.layout.VOD, l.layout.Recordings, l.layout.Thumbnails} {
+		if rel, ok := strings.CutPrefix(gcsPath, prefix+"/"); ok {
+			id, _, found := strings.Cut(rel, "/")
+			return id, found && id != ""
+		}
+	}
+	return "", false
+}
+
+// Asset returns the storage used by a video or stream
+func (l *Ledger) Asset(id string) *AssetUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if asset, ok := l.assets[id]; ok {
+		copied := *asset
+		return &copied
+	}
+	return &AssetUsage{ID: id}
+}
+
+// Forget drops the objects of an asset from the ledger, for assets deleted
+// without the ledger noticing, and returns how many there were
+func (l *Ledger) Forget(assetID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	forgotten := 0
+	for gcsPath, size := range l.objects {
+		if id, ok := l.classify(gcsPath); ok && id == assetID {
+			l.apply(gcsPath, size, false)
+			l.touch(gcsPath)
+			forgotten++
+		}
+	}
+	return forgotten
+}
+
+// Assets returns the storage used by every asset, largest first
+func (l *Ledger) Assets() []*AssetUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	assets := make([]*AssetUsage, 0, len(l.assets))
+	for _, asset := range l.assets {
+		copied := *asset
+		assets = append(assets, &copied)
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		if assets[i].Bytes != assets[j].Bytes {
+			return assets[i].Bytes > assets[j].Bytes
+		}
+		return assets[i].ID < assets[j].ID
+	})
+	return assets
+}
+
+// Rebuild replaces the totals with a listing of the layout prefixes, keeping
+// what this replica wrote or deleted while listing, and returns the number
+// of objects found
+func (l *Ledger) Rebuild(ctx context.Context, gcsService *storage.GCSService) (int, error) {
+	l.rebuildMu.Lock()
+	defer l.rebuildMu.Unlock()
+
+	l.mu.Lock()
+	l.listing, l.touched = true, make(map[string]bool)
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.listing, l.touched = false, nil
+		l.mu.Unlock()
+	}()
+
+	sizes := make(map[string]int64)
+	for _, prefix := range []string{l.layout.Live, l.layout.VOD, l.layout.Recordings, l.layout.Thumbnails} {
+		objects, err := gcsService.ListObjects(ctx, prefix+"/")
+		if err != nil {
+			return 0, err
+		}
+		for _, attrs := range objects {
+			sizes[attrs.Name] = attrs.Size
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for gcsPath := range l.touched {
+		if size, ok := l.objects[gcsPath]; ok {
+			sizes[gcsPath] = size
+		} else {
+			delete(sizes, gcsPath)
+		}
+	}
+	l.objects = make(map[string]int64, len(sizes))
+	l.assets = make(map[string]*AssetUsage)
+	for name, size := range sizes {
+		l.apply(name, size, true)
+	}
+	l.dirty = true
+	return len(sizes), nil
+}
+
+// Flush writes the ledger to its file if it changed
+func (l *Ledger) Flush() error {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+
+	l.mu.Lock()
+	if l.file == "" || !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(ledgerFile{Objects: l.objects})
+	l.dirty = false
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode usage ledger: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.file), 0o755); err != nil {
+		return fmt.Errorf("failed to create usage ledger directory: %w", err)
+	}
+	tmp := l.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write usage ledger: %w", err)
+	}
+	return os.Rename(tmp, l.file)
+}
+
+// StartRebuilder rebuilds the ledger from the bucket in group now and every
+// interval, for the objects other replicas wrote and deleted
+func (l *Ledger) StartRebuilder(group *background.Group, gcsService *storage.GCSService, interval time.Duration) {
+	group.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			rebuildCtx, cancel := context.WithTimeout(ctx, interval)
+			if _, err := l.Rebuild(rebuildCtx, gcsService); err != nil && ctx.Err() == nil {
+				log.Printf("[Usage] Failed to rebuild the usage ledger: %v", err)
+			}
+			cancel()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// StartFlusher saves the ledger in group periodically. Live streams write a
+// segment every few seconds, so it is not saved on every change.
+func (l *Ledger) StartFlusher(group *background.Group, interval time.Duration) {
+	group.Every(interval, func(context.Context) {
+		if err := l.Flush(); err != nil {
+			log.Printf("[Usage] %v", err)
+		}
+	})
+}
+
+// TenantUsage is the storage used by all assets of one tenant
+type TenantUsage struct {
+	Tenant  string `json:"tenant"` // empty for unowned assets
+	Bytes   int64  `json:"bytes"`
+	Objects int    `json:"objects"`
+	Assets  int    `json:"assets"`
+}
+
+// Tenants sums the usage of every asset by the tenant tenantOf assigns it,
+// largest first
+func (l *Ledger) Tenants(tenantOf func(assetID string) string) []*TenantUsage {
+	byTenant := make(map[string]*TenantUsage)
+	for _, asset := range l.Assets() {
+		tenant := tenantOf(asset.ID)
+		t, ok := byTenant[tenant]
+		if !ok {
+			t = &TenantUsage{Tenant: tenant}
+			byTenant[tenant] = t
+		}
+		t.Bytes += asset.Bytes
+		t.Objects += asset.Objects
+		t.Assets++
+	}
+
+	tenants := make([]*TenantUsage, 0, len(byTenant))
+	for _, t := range byTenant {
+		tenants = append(tenants, t)
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		if tenants[i].Bytes != tenants[j].Bytes {
+			return tenants[i].Bytes > tenants[j].Bytes
+		}
+		return tenants[i].Tenant < tenants[j].Tenant
+	})
+	return tenants
+}
@@ -0,0 +1,309 @@
+// Package viewers stitches the playback sessions of signed in viewers to
+// their accounts and keeps their watch history, so they continue watching
+// where they left off on any device.
+package viewers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"live-video/pkg/background"
+)
+
+// Kinds of watched assets
+const (
+	KindStream = "stream"
+	KindVideo  = "video"
+)
+
+const (
+	// maxEntries is how many assets the history of one user keeps; the
+	// least recently watched go first
+	maxEntries = 500
+	// sessionTTL is how long a session stays linked to its user after its
+	// last report
+	sessionTTL = 12 * time.Hour
+	// completedFraction of an asset's duration counts as watched to the end
+	completedFraction = 0.95
+)
+
+// Entry is what a user watched of one asset
+type Entry struct {
+	Kind            string    `json:"kind"`
+	ID              string    `json:"id"`
+	PositionSeconds float64   `json:"position_seconds"`           // where playback was last reported
+	DurationSeconds float64   `json:"duration_seconds,omitempty"` // 0 for live streams
+	WatchedSeconds  float64   `json:"watched_seconds"`
+	Sessions        int       `json:"sessions"`
+	Completed       bool      `json:"completed"`
+	FirstWatchedAt  time.Time `json:"first_watched_at"`
+	LastWatchedAt   time.Time `json:"last_watched_at"`
+
+	session string // the last session counted
+}
+
+// Resumable reports whether playback can continue where it left off
+func (e *Entry) Resumable() bool {
+	return e.PositionSeconds > 0 && !e.Completed
+}
+
+// Play is a report of a viewer playing an asset
+type Play struct {
+	Kind      string
+	ID        string
+	SessionID string        // empty counts the report as a session of its own
+	Watched   time.Duration // since the session's previous report
+	Position  float64       // seconds into the asset; negative when unknown, e.g. live
+	Duration  float64       // seconds; 0 when unknown
+}
+
+// session is a playback session linked to its user
+type session struct {
+	userID string
+	seen   time.Time
+}
+
+// History keeps what each user watched. It is persisted to a file so it
+// survives restarts. A nil History keeps nothing.
+type History struct {
+	mu       sync.Mutex
+	flushMu  sync.Mutex
+	users    map[string]map[string]*Entry // user ID -> asset ID -> entry
+	sessions map[string]session
+	file     string
+	dirty    bool
+}
+
+// NewHistory creates a history, loading the one saved in file. An empty
+// file keeps it in memory only.
+func NewHistory(file string) (*History, error) {
+	h := &History{
+		users:    make(map[string]map[string]*Entry),
+		sessions: make(map[string]session),
+		file:     file,
+	}
+	if file == "" {
+		return h, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watch history: %w", err)
+	}
+	var saved map[string][]*Entry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse watch history: %w", err)
+	}
+	for userID, entries := range saved {
+		byID := make(map[string]*Entry, len(entries))
+		for _, entry := range entries {
+			byID[entry.ID] = entry
+		}
+		h.users[userID] = byID
+	}
+	return h, nil
+}
+
+// Link stitches a playback session to a signed in user, so reports of the
+// session count for them without credentials of their own
+func (h *History) Link(sessionID, userID string) {
+	if h == nil || sessionID == "" || userID == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for id, s := range h.sessions {
+		if now.Sub(s.seen) > sessionTTL {
+			delete(h.sessions, id)
+		}
+	}
+	h.sessions[sessionID] = session{userID: userID, seen: now}
+}
+
+// UserOf returns the user a session is linked to, or "" for anonymous
+// sessions
+func (h *History) UserOf(sessionID string) string {
+	if h == nil || sessionID == "" {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[sessionID]
+	if !ok || time.Since(s.seen) > sessionTTL {
+		return ""
+	}
+	s.seen = time.Now()
+	h.sessions[sessionID] = s
+	return s.userID
+}
+
+// Record adds a report of userID playing an asset to their history
+func (h *History) Record(userID string, play Play) {
+	if h == nil || userID == "" || play.ID == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := h.users[userID]
+	if entries == nil {
+		entries = make(map[string]*Entry)
+		h.users[userID] = entries
+	}
+	now := time.Now().UTC()
+	entry := entries[play.ID]
+	if entry == nil {
+		entry = &Entry{Kind: play.Kind, ID: play.ID, FirstWatchedAt: now, LastWatchedAt: now}
+		entries[play.ID] = entry
+		h.evict(entries)
+	}
+	if play.SessionID == "" || play.SessionID != entry.session {
+		entry.Sessions++
+		entry.session = play.SessionID
+	}
+	entry.WatchedSeconds += play.Watched.Seconds()
+	if play.Duration > 0 {
+		entry.DurationSeconds = play.Duration
+	}
+	if play.Position >= 0 {
+		entry.PositionSeconds = play.Position
+		entry.Completed = entry.DurationSeconds > 0 && play.Position >= entry.DurationSeconds*completedFraction
+	}
+	entry.LastWatchedAt = now
+	h.dirty = true
+}
+
+// evict drops the least recently watched entries over maxEntries
+func (h *History) evict(entries map[string]*Entry) {
+	for len(entries) > maxEntries {
+		var oldest *Entry
+		for _, entry := range entries {
+			if oldest == nil || entry.LastWatchedAt.Before(oldest.LastWatchedAt) {
+				oldest = entry
+			}
+		}
+		delete(entries, oldest.ID)
+	}
+}
+
+// History returns what userID watched, most recent first
+func (h *History) History(userID string) []Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := make([]Entry, 0, len(h.users[userID]))
+	for _, entry := range h.users[userID] {
+		entries = append(entries, *entry)
+	}
+	slices.SortFunc(entries, func(a, b Entry) int { return b.LastWatchedAt.Compare(a.LastWatchedAt) })
+	return entries
+}
+
+// Entry returns what userID watched of an asset
+func (h *History) Entry(userID, assetID string) (Entry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry, ok := h.users[userID][assetID]
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// Forget removes the history of a user and unlinks their sessions. It
+// returns how many entries it removed.
+func (h *History) Forget(userID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, s := range h.sessions {
+		if s.userID == userID {
+			delete(h.sessions, id)
+		}
+	}
+	removed := len(h.users[userID])
+	delete(h.users, userID)
+	h.dirty = h.dirty || removed > 0
+	return removed
+}
+
+// ForgetAsset removes an asset from every user's history and returns from
+// how many it removed it
+func (h *History) ForgetAsset(assetID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	removed := 0
+	for _, entries := range h.users {
+		if _, ok := entries[assetID]; ok {
+			delete(entries, assetID)
+			removed++
+		}
+	}
+	h.dirty = h.dirty || removed > 0
+	return removed
+}
+
+// Watchers returns how many users have an asset in their history
+func (h *History) Watchers(assetID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	watchers := 0
+	for _, entries := range h.users {
+		if _, ok := entries[assetID]; ok {
+			watchers++
+		}
+	}
+	return watchers
+}
+
+// Flush writes the history to its file if it changed
+func (h *History) Flush() error {
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+
+	h.mu.Lock()
+	if h.file == "" || !h.dirty {
+		h.mu.Unlock()
+		return nil
+	}
+	saved := make(map[string][]*Entry, len(h.users))
+	for userID, entries := range h.users {
+		for _, entry := range entries {
+			saved[userID] = append(saved[userID], entry)
+		}
+	}
+	data, err := json.Marshal(saved)
+	h.dirty = false
+	h.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode watch history: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.file), 0o755); err != nil {
+		return fmt.Errorf("failed to create watch history directory: %w", err)
+	}
+	tmp := h.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write watch history: %w", err)
+	}
+	return os.Rename(tmp, h.file)
+}
+
+// StartFlusher saves the history in group periodically. Players report
+// every few seconds, so it is not saved on every report.
+func (h *History) StartFlusher(group *background.Group, interval time.Duration) {
+	group.Every(interval, func(context.Context) {
+		if err := h.Flush(); err != nil {
+			log.Printf("[Viewers] %v", err)
+		}
+	})
+}
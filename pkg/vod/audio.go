@@ -0,0 +1,76 @@
+package vod
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Downmixes of surround sources to stereo
+const (
+	DownmixITU    = "itu"    // ITU-R BS.775: center and surrounds at -3dB, LFE dropped (default)
+	DownmixDialog = "dialog" // center boosted over the front and surround channels for clearer speech
+)
+
+// Audio renditions of a ladder with a surround source
+const (
+	audioGroup        = "audio"
+	stereoRendition   = "stereo"
+	surroundRendition = "surround"
+	surroundBitrate   = 384 // kbps, E-AC-3 5.1
+)
+
+// AudioInfo describes the first audio stream of a file
+type AudioInfo struct {
+	Channels int    // 0 without audio
+	Layout   string // e.g. stereo, 5.1 or 5.1(side)
+}
+
+// ProbeAudio reads the channels of the first audio stream of a file
+func ProbeAudio(path string) (AudioInfo, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=channels,channel_layout", "-of", "default=noprint_wrappers=1", path).Output()
+	if err != nil {
+		return AudioInfo{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var info AudioInfo
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "channels":
+			info.Channels, _ = strconv.Atoi(value)
+		case "channel_layout":
+			info.Layout = value
+		}
+	}
+	return info, nil
+}
+
+// Surround reports whether the audio has more than two channels
+func (a AudioInfo) Surround() bool {
+	return a.Channels > 2
+}
+
+// downmixArgs returns the options of output audio stream specifier (e.g.
+// "a:0") that mix the source down to stereo
+func (a AudioInfo) downmixArgs(downmix, specifier string) []string {
+	if !a.Surround() {
+		return nil
+	}
+	// pan needs the names of the surround channels, which differ between
+	// the 5.1 layouts; other layouts get FFmpeg's standard downmix
+	surround := ""
+	switch a.Layout {
+	case "5.1":
+		surround = "B"
+	case "5.1(side)":
+		surround = "S"
+	}
+	if downmix == DownmixDialog && surround != "" {
+		pan := fmt.Sprintf("pan=stereo|FL=0.8*FC+0.5*FL+0.35*%[1]sL|FR=0.8*FC+0.5*FR+0.35*%[1]sR", surround)
+		return []string{"-filter:" + specifier, pan}
+	}
+	return []string{"-ac:" + specifier, "2"}
+}
@@ -0,0 +1,111 @@
+package vod
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// outputPixelFormat is the pixel format of SDR renditions: 8-bit 4:2:0, the
+// only one H.264 High and HEVC Main players decode. 10-bit and 4:2:2 or
+// 4:4:4 sources (ProRes, 10-bit HEVC) would otherwise keep theirs and
+// produce High 10 or High 4:2:2 streams most players refuse.
+const outputPixelFormat = "yuv420p"
+
+// SourceFormat is how the first video stream of a source is coded
+type SourceFormat struct {
+	Codec       string `json:"codec"`                  // as ffprobe names it, e.g. h264, hevc or prores
+	Profile     string `json:"profile,omitempty"`      // e.g. Main 10 or 422 HQ
+	PixelFormat string `json:"pixel_format,omitempty"` // e.g. yuv420p or yuv422p10le
+}
+
+// String returns the codec with its profile, e.g. "prores (422 HQ)"
+func (f SourceFormat) String() string {
+	if f.Profile == "" || f.Profile == "unknown" {
+		return f.Codec
+	}
+	return fmt.Sprintf("%s (%s)", f.Codec, f.Profile)
+}
+
+// UnsupportedError is returned for sources FFmpeg can't decode. Its message
+// and suggestion are safe to show to clients.
+type UnsupportedError struct {
+	Format SourceFormat
+	Reason string
+	Fix    string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("Unsupported video %s: %s", e.Format, e.Reason)
+}
+
+// Suggestion returns how to make the source convertible
+func (e *UnsupportedError) Suggestion() string {
+	return e.Fix
+}
+
+// Suggestions for sources that can't be converted
+const (
+	reexportFix = "Re-export the video as H.264, HEVC or ProRes 422 in an MP4 or MOV file, " +
+		"e.g. ffmpeg -i input.mov -c:v libx264 -pix_fmt yuv420p -c:a aac output.mp4"
+	proresRawFix = "ProRes RAW must be debayered first: export ProRes 422 HQ or H.264 " +
+		"from Final Cut Pro or the camera software and upload that"
+	damagedFix = "The file may be truncated or damaged. Upload it again, or re-export it from the original"
+)
+
+// ProbeSourceFormat returns the codec, profile and pixel format of the first
+// video stream of a file
+func ProbeSourceFormat(path string) (SourceFormat, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,profile,pix_fmt", "-of", "default=noprint_wrappers=1", path).Output()
+	if err != nil {
+		return SourceFormat{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var format SourceFormat
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "codec_name":
+			format.Codec = value
+		case "profile":
+			format.Profile = value
+		case "pix_fmt":
+			format.PixelFormat = value
+		}
+	}
+	if format.Codec == "" {
+		return SourceFormat{}, fmt.Errorf("no video stream")
+	}
+	return format, nil
+}
+
+// CheckDecode decodes the first frame of a source to find out whether this
+// FFmpeg build can convert it. ffprobe names codecs FFmpeg has no decoder
+// for, so probing alone doesn't tell.
+func CheckDecode(path string, format SourceFormat) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-nostats", "-v", "error", "-xerror", "-i", path,
+		"-map", "0:v:0", "-frames:v", "1", "-f", "null", "-")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		return nil
+	}
+
+	message := strings.ToLower(stderr.String())
+	switch {
+	case format.Codec == "prores_raw" || strings.Contains(strings.ToLower(format.Profile), "raw"):
+		return &UnsupportedError{Format: format, Reason: "ProRes RAW can't be decoded", Fix: proresRawFix}
+	case strings.Contains(message, "decoder") && (strings.Contains(message, "not found") || strings.Contains(message, "no decoder")):
+		return &UnsupportedError{Format: format, Reason: "this server's FFmpeg has no decoder for it", Fix: reexportFix}
+	case format.PixelFormat == "" || format.PixelFormat == "unknown":
+		return &UnsupportedError{Format: format, Reason: "its pixel format isn't known to FFmpeg", Fix: reexportFix}
+	}
+	return &UnsupportedError{Format: format, Reason: "its first frame couldn't be decoded", Fix: damagedFix}
+}
+
+// normalized reports whether a pixel format is already the output's
+func normalized(pixelFormat string) bool {
+	return pixelFormat == "" || pixelFormat == outputPixelFormat || pixelFormat == "yuvj420p"
+}
@@ -0,0 +1,91 @@
+package vod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"live-video/pkg/m3u8"
+)
+
+// Segment is a media segment of a playlist, with its start time from the
+// beginning of the playlist
+type Segment struct {
+	URI      string
+	Start    float64
+	Duration float64
+}
+
+// Segments returns the media segments of a media playlist and the URI of its
+// fMP4 initialization segment, if any
+func Segments(data []byte) ([]Segment, string) {
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return nil, ""
+	}
+	segments := make([]Segment, len(playlist.Segments))
+	for i, segment := range playlist.Segments {
+		segments[i] = Segment{URI: segment.URI, Start: segment.Start, Duration: segment.Duration}
+	}
+	return segments, playlist.InitURI()
+}
+
+// SegmentAt returns the segment containing time t, in seconds from the start
+// of the playlist
+func SegmentAt(segments []Segment, t float64) (Segment, bool) {
+	if t < 0 {
+		return Segment{}, false
+	}
+	for _, segment := range segments {
+		if t < segment.Start+segment.Duration {
+			return segment, true
+		}
+	}
+	return Segment{}, false
+}
+
+// ExtractFrame writes the frame at offset seconds into inputPath to
+// outputPath as a JPEG or PNG, by the output extension. A negative offset
+// takes the last frame. The input is decoded up to the frame, so the frame is
+// exact rather than the nearest keyframe. With width > 0 the frame is scaled
+// to that width.
+func ExtractFrame(ctx context.Context, inputPath string, offset float64, outputPath string, width int) error {
+	args := []string{"-y", "-hide_banner", "-loglevel", "error"}
+	if offset < 0 {
+		args = append(args, "-sseof", "-1", "-i", inputPath, "-update", "1")
+	} else {
+		args = append(args, "-i", inputPath, "-ss", strconv.FormatFloat(offset, 'f', 3, 64), "-frames:v", "1")
+	}
+	if width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", width))
+	}
+
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".png":
+		args = append(args, "-c:v", "png")
+	case ".jpg", ".jpeg":
+		args = append(args, "-c:v", "mjpeg", "-q:v", "2")
+	default:
+		return fmt.Errorf("unsupported image format %s", filepath.Ext(outputPath))
+	}
+
+	tmpPath := outputPath + ".tmp"
+	args = append(args, "-an", "-f", "image2", tmpPath)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if info, err := os.Stat(tmpPath); err != nil || info.Size() == 0 {
+		os.Remove(tmpPath)
+		return fmt.Errorf("no frame at %.3fs", offset)
+	}
+	return os.Rename(tmpPath, outputPath)
+}
@@ -0,0 +1,41 @@
+package vod
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"live-video/config"
+)
+
+// ProbeFrameRate returns the average frame rate of the first video stream of
+// a file, falling back to its base rate
+func ProbeFrameRate(path string) (config.Rate, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=avg_frame_rate,r_frame_rate", "-of", "default=noprint_wrappers=1", path).Output()
+	if err != nil {
+		return config.Rate{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var avg, base config.Rate
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		rate, err := config.ParseRate(value)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "avg_frame_rate":
+			avg = rate
+		case "r_frame_rate":
+			base = rate
+		}
+	}
+	if avg.Known() {
+		return avg, nil
+	}
+	if base.Known() {
+		return base, nil
+	}
+	return config.Rate{}, fmt.Errorf("no frame rate")
+}
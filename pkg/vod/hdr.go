@@ -0,0 +1,78 @@
+package vod
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// HDR handling of a conversion
+const (
+	HDRToneMap     = "tonemap"     // every rendition SDR
+	HDRPassthrough = "passthrough" // HDR kept on HEVC renditions of hdrMinHeight and up
+)
+
+// Video ranges, as in the VIDEO-RANGE attribute of a master playlist
+const (
+	RangeSDR = "SDR"
+	RangePQ  = "PQ"  // HDR10, SMPTE ST 2084
+	RangeHLG = "HLG" // hybrid log-gamma, ARIB STD-B67
+)
+
+// hdrMinHeight is the smallest rendition HDR is passed through on. Smaller
+// renditions play on small screens and poor connections and are tone-mapped.
+const hdrMinHeight = 720
+
+// toneMapFilter converts HDR frames to BT.709 SDR
+const toneMapFilter = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+
+// ProbeVideoRange returns the range of the first video stream of a file from
+// its transfer characteristics
+func ProbeVideoRange(path string) (string, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=color_transfer", "-of", "csv=p=0", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("ffprobe failed: %w", err)
+	}
+	switch strings.TrimSpace(string(out)) {
+	case "smpte2084":
+		return RangePQ, nil
+	case "arib-std-b67":
+		return RangeHLG, nil
+	}
+	return RangeSDR, nil
+}
+
+// videoRange returns the range of a rendition height pixels tall of a source
+// in sourceRange
+func (p *Pipeline) videoRange(sourceRange string, height int) string {
+	if sourceRange == RangeSDR || p.opts.HDR != HDRPassthrough || p.opts.Codec != CodecHEVC || height < hdrMinHeight {
+		return RangeSDR
+	}
+	return sourceRange
+}
+
+// colorArgs returns the video filter to append to the scaling of a
+// rendition and the color options of its output stream (specifier "v:0"
+// etc., "v" for a single rendition). SDR output is always 8-bit 4:2:0.
+func colorArgs(sourceRange, outputRange, specifier string) (string, []string) {
+	switch {
+	case sourceRange == RangeSDR:
+		return "format=" + outputPixelFormat, nil
+	case outputRange == RangeSDR:
+		return toneMapFilter, []string{
+			"-color_primaries:" + specifier, "bt709",
+			"-color_trc:" + specifier, "bt709",
+			"-colorspace:" + specifier, "bt709",
+		}
+	}
+	trc := "smpte2084"
+	if outputRange == RangeHLG {
+		trc = "arib-std-b67"
+	}
+	return "format=yuv420p10le", []string{
+		"-color_primaries:" + specifier, "bt2020",
+		"-color_trc:" + specifier, trc,
+		"-colorspace:" + specifier, "bt2020nc",
+	}
+}
@@ -0,0 +1,189 @@
+package vod
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"live-video/pkg/m3u8"
+)
+
+// iframePlaylist is the I-frame playlist of a rendition with the bit rates
+// the master playlist declares for it
+type iframePlaylist struct {
+	data             []byte
+	bandwidth        int // peak, bits per second
+	averageBandwidth int
+}
+
+// iframePlaylistName returns the name of the I-frame playlist of the media
+// playlist name, e.g. playlist_720p_iframes.m3u8 for playlist_720p.m3u8
+func iframePlaylistName(name string) string {
+	return strings.TrimSuffix(name, ".m3u8") + "_iframes.m3u8"
+}
+
+// buildIFramePlaylist writes the EXT-X-I-FRAMES-ONLY playlist of the media
+// playlist name in dir. Segments start with a key frame, so each segment
+// contributes the byte range of its first one: from the start of the
+// segment, which carries the PAT/PMT of MPEG-TS or the moof of fMP4, to the
+// end of the key frame.
+func buildIFramePlaylist(dir, name string) (*iframePlaylist, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	media, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse playlist: %w", err)
+	}
+	fmp4 := media.InitURI() != ""
+
+	version := 4 // EXT-X-BYTERANGE
+	if value, ok := media.HeaderValue(m3u8.TagVersion); ok {
+		if v, _ := strconv.Atoi(value); v > version {
+			version = v
+		}
+	}
+	iframes := &m3u8.MediaPlaylist{MediaSequence: media.MediaSequence, Ended: true}
+	iframes.SetHeader(m3u8.TagVersion, strconv.Itoa(version))
+	iframes.SetHeader(m3u8.TagTargetDuration, strconv.Itoa(m3u8.TargetDuration(media.Segments)))
+	iframes.SetHeader(m3u8.TagPlaylistType, m3u8.TypeVOD)
+	iframes.SetHeader(m3u8.TagIFramesOnly, "")
+
+	var bits, seconds, peak float64
+	base := filepath.Dir(filepath.Join(dir, name))
+	for _, segment := range media.Segments {
+		length, err := keyFrameEnd(filepath.Join(base, filepath.FromSlash(segment.URI)), fmp4)
+		if err != nil {
+			return nil, fmt.Errorf("segment %s: %w", segment.URI, err)
+		}
+		tags := append([]m3u8.Tag(nil), segment.Tags...)
+		tags = append(tags, m3u8.Tag{Name: m3u8.TagByteRange, Value: fmt.Sprintf("%d@0", length)})
+		iframes.Segments = append(iframes.Segments, m3u8.Segment{URI: segment.URI, Duration: segment.Duration, Tags: tags})
+
+		bits += float64(length * 8)
+		seconds += segment.Duration
+		if segment.Duration > 0 {
+			peak = max(peak, float64(length*8)/segment.Duration)
+		}
+	}
+	if len(iframes.Segments) == 0 {
+		return nil, fmt.Errorf("playlist lists no segments")
+	}
+	playlist := &iframePlaylist{data: iframes.Encode(), bandwidth: int(peak)}
+	if seconds > 0 {
+		playlist.averageBandwidth = int(bits / seconds)
+	}
+	return playlist, nil
+}
+
+// keyFrameEnd returns the byte offset where the first key frame of a
+// segment ends. In MPEG-TS that is where the next video packet starts; in
+// fMP4 the key frame is the first sample of the fragment's mdat.
+func keyFrameEnd(segmentPath string, fmp4 bool) (int64, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "packet=pos,size,flags", "-of", "default=noprint_wrappers=1", segmentPath).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var pos, size int64 = -1, 0
+	key := false
+	for _, line := range strings.Split(string(out), "\n") {
+		k, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch k {
+		case "pos":
+			pos, _ = strconv.ParseInt(value, 10, 64)
+		case "size":
+			size, _ = strconv.ParseInt(value, 10, 64)
+		case "flags":
+			// flags is the last entry of a packet
+			switch {
+			case key && pos > 0:
+				return pos, nil
+			case strings.HasPrefix(value, "K") && pos > 0:
+				if fmp4 {
+					return pos + size, nil
+				}
+				key = true
+			}
+			pos, size = -1, 0
+		}
+	}
+	if !key {
+		return 0, fmt.Errorf("no key frame")
+	}
+	// The key frame is the last video packet of the segment
+	info, err := os.Stat(segmentPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// videoCodecs returns the video codecs of a CODECS attribute value
+func videoCodecs(codecs string) string {
+	var video []string
+	for _, codec := range strings.Split(codecs, ",") {
+		codec = strings.TrimSpace(codec)
+		switch {
+		case codec == "", strings.HasPrefix(codec, "mp4a."), codec == "ac-3", codec == "ec-3", codec == "Opus", codec == "fLaC":
+		default:
+			video = append(video, codec)
+		}
+	}
+	return strings.Join(video, ",")
+}
+
+// withIFramePlaylists writes and publishes the I-frame playlists of the
+// video renditions of a ladder and returns the master playlist referencing
+// them. A rendition whose I-frames can't be found is left without one, as
+// seeking works without it.
+func (p *Pipeline) withIFramePlaylists(outputDir string, master []byte) []byte {
+	playlist, err := m3u8.ParseMaster(master)
+	if err != nil {
+		return master
+	}
+	for _, variant := range playlist.Variants {
+		resolution, ok := variant.Attributes.Get("RESOLUTION")
+		if !ok {
+			continue // audio-only
+		}
+		iframes, err := buildIFramePlaylist(outputDir, variant.URI)
+		if err != nil {
+			log.Printf("[VOD] No I-frame playlist for %s: %v", variant.URI, err)
+			continue
+		}
+		name := iframePlaylistName(variant.URI)
+		localPath := filepath.Join(outputDir, filepath.FromSlash(name))
+		if err := os.WriteFile(localPath, iframes.data, 0o644); err != nil {
+			log.Printf("[VOD] Failed to write I-frame playlist %s: %v", name, err)
+			continue
+		}
+		if err := p.publisher.PublishPlaylist(localPath, name, iframes.data, true); err != nil {
+			log.Printf("[VOD] Failed to publish I-frame playlist %s: %v", name, err)
+			continue
+		}
+
+		attrs := m3u8.Attributes{
+			{Key: "BANDWIDTH", Value: strconv.Itoa(iframes.bandwidth)},
+			{Key: "AVERAGE-BANDWIDTH", Value: strconv.Itoa(iframes.averageBandwidth)},
+		}
+		if codecs, ok := variant.Attributes.Get("CODECS"); ok {
+			if video := videoCodecs(codecs); video != "" {
+				attrs.Set("CODECS", m3u8.Quote(video))
+			}
+		}
+		attrs.Set("RESOLUTION", resolution)
+		if videoRange, ok := variant.Attributes.Get("VIDEO-RANGE"); ok {
+			attrs.Set("VIDEO-RANGE", videoRange)
+		}
+		attrs.Set("URI", m3u8.Quote(name))
+		playlist.Tags = append(playlist.Tags, m3u8.NewTag(m3u8.TagIFrameStreamInf, attrs))
+	}
+	return playlist.Encode()
+}
@@ -0,0 +1,100 @@
+package vod
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Deinterlacers FFmpeg can insert for interlaced sources
+const (
+	DeinterlaceBwdif = "bwdif" // motion adaptive, sharper on motion (default)
+	DeinterlaceYadif = "yadif" // faster
+	DeinterlaceOff   = "off"   // keep fields as they are
+)
+
+// Field orders as ffprobe reports them
+const (
+	FieldProgressive = "progressive"
+	FieldUnknown     = "unknown"
+)
+
+// Deinterlacing is how a conversion treated the fields of its source
+type Deinterlacing struct {
+	FieldOrder string `json:"field_order"`      // as probed, see ProbeFieldOrder
+	Filter     string `json:"filter,omitempty"` // deinterlacer used, "" for none
+}
+
+// idetFrames is how many frames are analysed when the container doesn't say
+// whether a video is interlaced
+const idetFrames = 300
+
+var idetMultiFrame = regexp.MustCompile(`Multi frame detection: TFF:\s*(\d+)\s+BFF:\s*(\d+)\s+Progressive:\s*(\d+)`)
+
+// ProbeFieldOrder returns the field order of the first video stream of a
+// file: progressive, tt or bb (top or bottom field first), tb or bt (coded
+// and displayed in different orders), or unknown. Streams that don't declare
+// it are analysed with the idet filter.
+func ProbeFieldOrder(path string) (string, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=field_order", "-of", "csv=p=0", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("ffprobe failed: %w", err)
+	}
+	if order := strings.TrimSpace(string(out)); order != "" && order != FieldUnknown {
+		return order, nil
+	}
+	return detectFieldOrder(path)
+}
+
+// detectFieldOrder counts the frames idet finds top field first, bottom field
+// first and progressive at the start of a video
+func detectFieldOrder(path string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-nostats", "-i", path,
+		"-map", "0:v:0", "-frames:v", strconv.Itoa(idetFrames), "-vf", "idet", "-an", "-f", "null", "-")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("idet failed: %w", err)
+	}
+
+	match := idetMultiFrame.FindSubmatch(stderr.Bytes())
+	if match == nil {
+		return FieldUnknown, nil
+	}
+	tff, _ := strconv.Atoi(string(match[1]))
+	bff, _ := strconv.Atoi(string(match[2]))
+	progressive, _ := strconv.Atoi(string(match[3]))
+	switch {
+	case tff+bff <= progressive:
+		return FieldProgressive, nil
+	case tff >= bff:
+		return "tt", nil
+	}
+	return "bb", nil
+}
+
+// Interlaced reports whether a field order is interlaced
+func Interlaced(fieldOrder string) bool {
+	switch fieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
+}
+
+// deinterlaceFilter returns the filter deinterlacing frames with
+// deinterlacer, or "" when it is off. It outputs one frame per frame, so
+// the frame rate stays the source's.
+func deinterlaceFilter(deinterlacer string) string {
+	switch deinterlacer {
+	case DeinterlaceOff:
+		return ""
+	case DeinterlaceYadif:
+		return "yadif=mode=send_frame:parity=auto:deint=all"
+	}
+	return "bwdif=mode=send_frame:parity=auto:deint=all"
+}
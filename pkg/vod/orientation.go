@@ -0,0 +1,79 @@
+package vod
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// Orientation describes how a video is stored and displayed
+type Orientation struct {
+	Width    int `json:"width"`    // coded width
+	Height   int `json:"height"`   // coded height
+	Rotation int `json:"rotation"` // clockwise degrees to display it: 0, 90, 180 or 270
+}
+
+// ProbeOrientation reads the size and rotation metadata of the first video
+// stream of a file. Phones record portrait video as landscape frames with a
+// rotation in a display matrix or a rotate tag.
+func ProbeOrientation(path string) (Orientation, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height:stream_tags=rotate:stream_side_data=rotation",
+		"-of", "json", path).Output()
+	if err != nil {
+		return Orientation{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			Width    int               `json:"width"`
+			Height   int               `json:"height"`
+			Tags     map[string]string `json:"tags"`
+			SideData []struct {
+				Rotation *float64 `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return Orientation{}, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return Orientation{}, fmt.Errorf("no video stream")
+	}
+
+	stream := probe.Streams[0]
+	o := Orientation{Width: stream.Width, Height: stream.Height}
+	// The display matrix rotates counterclockwise, the legacy tag clockwise
+	for _, data := range stream.SideData {
+		if data.Rotation != nil {
+			o.Rotation = normalizeRotation(-int(*data.Rotation))
+			return o, nil
+		}
+	}
+	if tag, err := strconv.Atoi(stream.Tags["rotate"]); err == nil {
+		o.Rotation = normalizeRotation(tag)
+	}
+	return o, nil
+}
+
+// normalizeRotation maps degrees to 0, 90, 180 or 270, rounding to the
+// nearest quarter turn
+func normalizeRotation(degrees int) int {
+	quarter := ((degrees%360+360)%360 + 45) / 90
+	return quarter % 4 * 90
+}
+
+// DisplaySize returns the size the video is displayed at, after rotation
+func (o Orientation) DisplaySize() (width, height int) {
+	if o.Rotation == 90 || o.Rotation == 270 {
+		return o.Height, o.Width
+	}
+	return o.Width, o.Height
+}
+
+// Portrait reports whether the video is displayed taller than wide
+func (o Orientation) Portrait() bool {
+	width, height := o.DisplaySize()
+	return height > width
+}
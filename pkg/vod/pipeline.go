@@ -0,0 +1,569 @@
+package vod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/config"
+	"live-video/pkg/m3u8"
+)
+
+// PlaylistName is the name of the playlist players open: the media playlist
+// of a single rendition or the master playlist of a ladder
+const PlaylistName = "playlist.m3u8"
+
+// Video codecs
+const (
+	CodecH264 = "h264"
+	CodecHEVC = "hevc"
+)
+
+// Publisher receives the pipeline output as it is produced
+type Publisher interface {
+	// PublishSegment uploads a finished segment
+	PublishSegment(localPath, name string) error
+	// PublishPlaylist uploads a playlist. Everything it refers to has been
+	// published; final is set once for each complete playlist.
+	PublishPlaylist(localPath, name string, data []byte, final bool) error
+}
+
+// Options configures a pipelined conversion
+type Options struct {
+	SegmentDuration int                       // seconds, defaults to 6
+	Uploads         int                       // segments uploaded in parallel, defaults to 4
+	PollInterval    time.Duration             // how often new segments are picked up, defaults to 1s
+	OnSegment       func(name string)         // called after each published segment, in playlist order
+	OnProgress      func(converted float64)   // called after each poll with the seconds converted so far
+	Renditions      []config.TranscodeProfile // ABR ladder; empty for a single rendition at source size
+	Codec           string                    // CodecH264 (default) or CodecHEVC
+	Prefix          string                    // prefix of segment and variant playlist names
+	HoldPlaylists   bool                      // publish playlists only once complete
+	HDR             string                    // HDRToneMap (default) or HDRPassthrough
+	Deinterlace     string                    // deinterlacer of interlaced sources, DeinterlaceBwdif by default
+	OnDeinterlace   func(Deinterlacing)       // called once the source's field order is probed
+	OnSource        func(SourceFormat)        // called once the source's video coding is probed
+	Downmix         string                    // stereo mix of surround sources, DownmixITU by default
+	Surround        bool                      // add a 5.1 audio rendition to ladders of surround sources
+	Segments        config.SegmentNaming      // segment names and format; HEVC is always fMP4
+}
+
+// Pipeline converts a video to HLS with FFmpeg and publishes each segment as
+// soon as FFmpeg finishes it, followed by a growing EVENT playlist, so a long
+// video becomes playable long before the conversion is done
+type Pipeline struct {
+	opts            Options
+	publisher       Publisher
+	published       map[string]bool
+	count           int
+	masterPublished bool
+	orientation     Orientation
+	variantAttrs    map[string][]string // master playlist attributes by variant playlist name
+	audioPlaylists  []string            // audio-only playlists of a ladder with a surround rendition
+}
+
+// NewPipeline creates a pipeline that publishes through publisher
+func NewPipeline(publisher Publisher, opts Options) *Pipeline {
+	if opts.SegmentDuration <= 0 {
+		opts.SegmentDuration = 6
+	}
+	if opts.Uploads <= 0 {
+		opts.Uploads = 4
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	return &Pipeline{opts: opts, publisher: publisher, published: make(map[string]bool)}
+}
+
+// Run converts sourcePath into outputDir and publishes the output. It
+// returns the local playlist path once the final playlist is published.
+func (p *Pipeline) Run(ctx context.Context, sourcePath, outputDir string) (string, error) {
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	playlistPath := filepath.Join(outputDir, PlaylistName)
+
+	args, err := p.ffmpegArgs(sourcePath, outputDir)
+	if err != nil {
+		return "", err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	ticker := time.NewTicker(p.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return "", fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			// Whatever FFmpeg wrote since the last poll, then the final playlist
+			if err := p.publish(outputDir, true); err != nil {
+				return "", err
+			}
+			return playlistPath, nil
+		case <-ticker.C:
+			if err := p.publish(outputDir, false); err != nil {
+				cmd.Process.Kill()
+				<-done
+				return "", err
+			}
+		}
+	}
+}
+
+// publish uploads the segments listed in the media playlists that aren't
+// published yet, then the media playlists and, for a ladder, the master
+// playlist. FFmpeg only lists a segment once it is complete.
+func (p *Pipeline) publish(outputDir string, final bool) error {
+	playlists := make(map[string][]byte)
+	queued := make(map[string]bool)
+	var pending []string
+	for _, name := range p.mediaPlaylists() {
+		data, err := os.ReadFile(filepath.Join(outputDir, name))
+		if os.IsNotExist(err) && !final {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read playlist: %w", err)
+		}
+		playlists[name] = data
+		for _, segment := range SegmentNames(data) {
+			if !p.published[segment] && !queued[segment] {
+				queued[segment] = true
+				pending = append(pending, segment)
+			}
+		}
+	}
+
+	errs := make([]error, len(pending))
+	sem := make(chan struct{}, p.opts.Uploads)
+	var wg sync.WaitGroup
+	for i, name := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = p.publisher.PublishSegment(filepath.Join(outputDir, name), name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	for i, name := range pending {
+		if errs[i] != nil {
+			return fmt.Errorf("failed to publish segment %s: %w", name, errs[i])
+		}
+		p.published[name] = true
+		p.count++
+		if p.opts.OnSegment != nil {
+			p.opts.OnSegment(name)
+		}
+	}
+	if len(pending) > 0 {
+		log.Printf("[VOD] Published %d new segments (%d total)", len(pending), p.count)
+	}
+	if p.opts.OnProgress != nil && len(playlists) > 0 {
+		p.opts.OnProgress(converted(playlists))
+	}
+
+	if (len(pending) == 0 || p.opts.HoldPlaylists) && !final {
+		return nil
+	}
+	for _, name := range p.mediaPlaylists() {
+		if data, ok := playlists[name]; ok {
+			if err := p.publisher.PublishPlaylist(filepath.Join(outputDir, name), name, data, final); err != nil {
+				return fmt.Errorf("failed to publish playlist: %w", err)
+			}
+		}
+	}
+
+	// The master playlist goes out once every rendition can be played, and
+	// last when final so it switches players to the complete ladder at once
+	if len(p.opts.Renditions) == 0 || len(playlists) < len(p.mediaPlaylists()) || (p.masterPublished && !final) {
+		return nil
+	}
+	masterPath := filepath.Join(outputDir, PlaylistName)
+	data, err := os.ReadFile(masterPath)
+	if err != nil {
+		return fmt.Errorf("failed to read master playlist: %w", err)
+	}
+	data = withVariantAttributes(data, p.variantAttrs)
+	if final {
+		// I-frame playlists for trick play are listed once every segment
+		// is published
+		data = p.withIFramePlaylists(outputDir, data)
+		// The final playlist is published from the file
+		if err := os.WriteFile(masterPath, data, 0o644); err != nil {
+			return fmt.Errorf("failed to write master playlist: %w", err)
+		}
+	}
+	if err := p.publisher.PublishPlaylist(masterPath, PlaylistName, data, final); err != nil {
+		return fmt.Errorf("failed to publish master playlist: %w", err)
+	}
+	p.masterPublished = true
+	return nil
+}
+
+// converted returns the seconds of video every media playlist lists, which
+// is how far the slowest rendition got
+func converted(playlists map[string][]byte) float64 {
+	slowest := -1.0
+	for _, data := range playlists {
+		listed := 0.0
+		if playlist, err := m3u8.ParseMedia(data); err == nil {
+			listed = playlist.Duration()
+		}
+		if slowest < 0 || listed < slowest {
+			slowest = listed
+		}
+	}
+	return max(slowest, 0)
+}
+
+// mediaPlaylists returns the names of the media playlists FFmpeg writes
+func (p *Pipeline) mediaPlaylists() []string {
+	if len(p.opts.Renditions) == 0 {
+		return []string{PlaylistName}
+	}
+	names := make([]string, 0, len(p.opts.Renditions)+len(p.audioPlaylists))
+	for _, r := range p.opts.Renditions {
+		names = append(names, p.opts.Prefix+"playlist_"+r.Name+".m3u8")
+	}
+	return append(names, p.audioPlaylists...)
+}
+
+// ffmpegArgs builds the FFmpeg command line. A single rendition keeps the
+// source size; a ladder is scaled per rendition with aligned keyframes and
+// described by a master playlist.
+func (p *Pipeline) ffmpegArgs(sourcePath, outputDir string) ([]string, error) {
+	videoCodec := "libx264"
+	segments := p.opts.Segments.WithDefaults()
+	switch p.opts.Codec {
+	case "", CodecH264:
+	case CodecHEVC:
+		videoCodec, segments.Format = "libx265", config.SegmentsFMP4
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", p.opts.Codec)
+	}
+
+	// Sources FFmpeg can't decode fail with a suggested fix rather than
+	// FFmpeg's error; others are converted to 8-bit 4:2:0 whatever their
+	// pixel format, e.g. 10-bit HEVC or ProRes 4:2:2
+	format, err := ProbeSourceFormat(sourcePath)
+	if err != nil {
+		return nil, &UnsupportedError{Format: SourceFormat{Codec: "unknown"}, Reason: "no video stream could be read", Fix: reexportFix}
+	}
+	if err := CheckDecode(sourcePath, format); err != nil {
+		return nil, err
+	}
+	if p.opts.OnSource != nil {
+		p.opts.OnSource(format)
+	}
+	if !normalized(format.PixelFormat) {
+		log.Printf("[VOD] %s source in %s", format, format.PixelFormat)
+	}
+
+	args := []string{"-y", "-hide_banner", "-loglevel", "error", "-i", sourcePath}
+	output := []string{
+		"-preset", "veryfast",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", p.opts.SegmentDuration),
+		"-f", "hls",
+		"-hls_time", fmt.Sprint(p.opts.SegmentDuration),
+		"-hls_playlist_type", "event",
+	}
+	if p.opts.Codec == CodecHEVC {
+		// HEVC in HLS needs fragmented MP4 and the hvc1 tag for Apple players
+		output = append(output, "-tag:v", "hvc1")
+	}
+
+	// FFmpeg rotates frames upright while decoding, so renditions of
+	// portrait video are scaled to the profile height across
+	orientation, err := ProbeOrientation(sourcePath)
+	if err != nil {
+		log.Printf("[VOD] Failed to probe orientation, assuming landscape: %v", err)
+	}
+	p.orientation = orientation
+
+	// HDR sources are tone-mapped to SDR unless passed through
+	sourceRange, err := ProbeVideoRange(sourcePath)
+	if err != nil {
+		log.Printf("[VOD] Failed to probe video range, assuming SDR: %v", err)
+		sourceRange = RangeSDR
+	}
+	if sourceRange != RangeSDR {
+		log.Printf("[VOD] %s source, HDR mode %q", sourceRange, p.opts.HDR)
+	}
+
+	// Interlaced sources, e.g. broadcast archives, are deinterlaced first
+	var deinterlace string
+	fieldOrder, err := ProbeFieldOrder(sourcePath)
+	if err != nil {
+		log.Printf("[VOD] Failed to probe field order, assuming progressive: %v", err)
+		fieldOrder = FieldUnknown
+	}
+	if Interlaced(fieldOrder) {
+		deinterlace = deinterlaceFilter(p.opts.Deinterlace)
+		log.Printf("[VOD] Interlaced source (%s), deinterlacer %q", fieldOrder, p.opts.Deinterlace)
+	}
+	if p.opts.OnDeinterlace != nil {
+		deinterlacer, _, _ := strings.Cut(deinterlace, "=")
+		p.opts.OnDeinterlace(Deinterlacing{FieldOrder: fieldOrder, Filter: deinterlacer})
+	}
+
+	// Surround sources are mixed down to stereo, which every player can play
+	audio, err := ProbeAudio(sourcePath)
+	if err != nil {
+		log.Printf("[VOD] Failed to probe audio, keeping its channels: %v", err)
+	}
+	if audio.Surround() {
+		log.Printf("[VOD] %d channel source (%s), downmix %q, surround rendition %v", audio.Channels, audio.Layout, p.opts.Downmix, p.opts.Surround && len(p.opts.Renditions) > 0)
+	}
+
+	if len(p.opts.Renditions) == 0 {
+		width, height := orientation.DisplaySize()
+		colorFilter, color := colorArgs(sourceRange, p.videoRange(sourceRange, min(width, height)), "v")
+		var filters []string
+		for _, filter := range []string{deinterlace, colorFilter} {
+			if filter != "" {
+				filters = append(filters, filter)
+			}
+		}
+		if len(filters) > 0 {
+			args = append(args, "-vf", strings.Join(filters, ","))
+		}
+		args = append(args, "-c:v", videoCodec)
+		args = append(args, color...)
+		args = append(args, "-c:a", "aac", "-b:a", "128k")
+		args = append(args, audio.downmixArgs(p.opts.Downmix, "a")...)
+		args = append(args, output...)
+		args = append(args, segments.WithPrefix(p.opts.Prefix).HLSArgs(outputDir, "")...)
+		return append(args, filepath.Join(outputDir, PlaylistName)), nil
+	}
+
+	scale := "scale=-2:%d"
+	if orientation.Portrait() {
+		scale = "scale=%d:-2"
+		log.Printf("[VOD] Portrait source (%dx%d, rotated %d°), scaling the ladder portrait", orientation.Width, orientation.Height, orientation.Rotation)
+	}
+
+	// Renditions drop frames evenly from sources faster than their profile
+	sourceRate, err := ProbeFrameRate(sourcePath)
+	if err != nil {
+		log.Printf("[VOD] Failed to probe frame rate, keeping the source's: %v", err)
+	}
+
+	// With a surround rendition, audio moves out of the video renditions
+	// into a group of audio-only renditions players pick from
+	grouped := audio.Surround() && p.opts.Surround
+	var streamMap []string
+	p.variantAttrs = make(map[string][]string, len(p.opts.Renditions))
+	p.audioPlaylists = nil
+	stereoBitrate := 0
+	for i, r := range p.opts.Renditions {
+		if r.Name == "" || r.Height <= 0 || r.VideoBitrate <= 0 {
+			return nil, fmt.Errorf("invalid rendition %q", r.Name)
+		}
+		rate := r.OutputRate(sourceRate)
+		videoRange := p.videoRange(sourceRange, r.Height)
+		colorFilter, color := colorArgs(sourceRange, videoRange, fmt.Sprintf("v:%d", i))
+		var filters []string
+		if deinterlace != "" {
+			filters = append(filters, deinterlace)
+		}
+		if sourceRate.Known() && rate != sourceRate {
+			filters = append(filters, "fps="+rate.String())
+		}
+		filters = append(filters, fmt.Sprintf(scale, r.Height))
+		if colorFilter != "" {
+			filters = append(filters, colorFilter)
+		}
+		attrs := []string{"VIDEO-RANGE=" + videoRange}
+		if sourceRate.Known() {
+			attrs = append(attrs, fmt.Sprintf("FRAME-RATE=%.3f", rate.Float()))
+		}
+		p.variantAttrs[p.opts.Prefix+"playlist_"+r.Name+".m3u8"] = attrs
+
+		args = append(args, "-map", "0:v:0")
+		args = append(args,
+			fmt.Sprintf("-filter:v:%d", i), strings.Join(filters, ","),
+			fmt.Sprintf("-c:v:%d", i), videoCodec,
+			fmt.Sprintf("-g:v:%d", i), fmt.Sprint(rate.Frames(p.opts.SegmentDuration)),
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate),
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate*2),
+		)
+		args = append(args, color...)
+		entry := fmt.Sprintf("v:%d", i)
+		switch {
+		case grouped:
+			stereoBitrate = max(stereoBitrate, r.AudioBitrate)
+			entry += ",agroup:" + audioGroup
+		case audio.Channels > 0:
+			args = append(args, "-map", "0:a:0",
+				fmt.Sprintf("-c:a:%d", i), "aac",
+				fmt.Sprintf("-b:a:%d", i), fmt.Sprintf("%dk", r.AudioBitrate),
+			)
+			args = append(args, audio.downmixArgs(p.opts.Downmix, fmt.Sprintf("a:%d", i))...)
+			entry += fmt.Sprintf(",a:%d", i)
+		}
+		streamMap = append(streamMap, entry+",name:"+r.Name)
+	}
+	if grouped {
+		// Stereo at the ladder's best audio bitrate is the default; the
+		// surround rendition keeps up to 5.1 as E-AC-3
+		args = append(args, "-map", "0:a:0", "-c:a:0", "aac", "-b:a:0", fmt.Sprintf("%dk", stereoBitrate))
+		args = append(args, audio.downmixArgs(p.opts.Downmix, "a:0")...)
+		args = append(args, "-map", "0:a:0", "-c:a:1", "eac3", "-b:a:1", fmt.Sprintf("%dk", surroundBitrate), "-ac:a:1", "6")
+		streamMap = append(streamMap,
+			fmt.Sprintf("a:0,agroup:%s,name:%s,default:yes", audioGroup, stereoRendition),
+			fmt.Sprintf("a:1,agroup:%s,name:%s", audioGroup, surroundRendition),
+		)
+		for _, a := range []struct{ name, channels string }{{stereoRendition, "2"}, {surroundRendition, "6"}} {
+			playlist := p.opts.Prefix + "playlist_" + a.name + ".m3u8"
+			p.audioPlaylists = append(p.audioPlaylists, playlist)
+			p.variantAttrs[playlist] = []string{`CHANNELS="` + a.channels + `"`}
+		}
+	}
+	// Renditions share the output directory, so their segments are named
+	// by rendition
+	args = append(args, output...)
+	args = append(args, segments.SharedDirectory().WithPrefix(p.opts.Prefix).HLSArgs(outputDir, "%v")...)
+	return append(args,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		"-master_pl_name", PlaylistName,
+		filepath.Join(outputDir, p.opts.Prefix+"playlist_%v.m3u8"),
+	), nil
+}
+
+// withVariantAttributes adds attributes to the variants and audio
+// renditions of a master playlist that FFmpeg doesn't write. attrs maps
+// playlist URIs to KEY=VALUE attributes; those a tag already has are left
+// alone.
+func withVariantAttributes(master []byte, attrs map[string][]string) []byte {
+	playlist, err := m3u8.ParseMaster(master)
+	if err != nil {
+		return master
+	}
+	for i, tag := range playlist.Tags {
+		if tag.Name == m3u8.TagMedia && tag.URI() != "" {
+			tagAttrs := tag.Attributes()
+			withAttributes(&tagAttrs, attrs[tag.URI()])
+			playlist.Tags[i] = m3u8.NewTag(tag.Name, tagAttrs)
+		}
+	}
+	for i := range playlist.Variants {
+		withAttributes(&playlist.Variants[i].Attributes, attrs[playlist.Variants[i].URI])
+	}
+	return playlist.Encode()
+}
+
+// withAttributes adds the KEY=VALUE attributes a list doesn't have yet
+func withAttributes(list *m3u8.Attributes, attrs []string) {
+	for _, attr := range attrs {
+		key, value, _ := strings.Cut(attr, "=")
+		list.SetDefault(key, value)
+	}
+}
+
+// Orientation returns the orientation probed from the source of a ladder
+func (p *Pipeline) Orientation() Orientation {
+	return p.orientation
+}
+
+// Published returns the number of segments published by this run
+func (p *Pipeline) Published() int {
+	return p.count
+}
+
+// SegmentNames returns the segment URIs of a media playlist, including its
+// fMP4 initialization sections
+func SegmentNames(data []byte) []string {
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return nil
+	}
+	return playlist.URIs()
+}
+
+// Files returns the files of the HLS output in dir that its PlaylistName
+// refers to, as named by the playlists rather than by any naming scheme:
+// the segments and initialization sections of every media playlist, in
+// playlist order, and the media playlists of a ladder
+func Files(dir string) (segments, playlists []string, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, PlaylistName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	media := map[string][]byte{PlaylistName: data}
+	if m3u8.IsMaster(data) {
+		master, err := m3u8.ParseMaster(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse playlist: %w", err)
+		}
+		media = make(map[string][]byte)
+		for _, uri := range master.URIs() {
+			if !localURI(uri) {
+				return nil, nil, fmt.Errorf("unsupported playlist URI %s", uri)
+			}
+			if media[uri] != nil {
+				continue
+			}
+			if media[uri], err = os.ReadFile(filepath.Join(dir, filepath.FromSlash(uri))); err != nil {
+				return nil, nil, fmt.Errorf("failed to read playlist: %w", err)
+			}
+			playlists = append(playlists, uri)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range append(playlists, PlaylistName) {
+		base := path.Dir(name)
+		for _, uri := range SegmentNames(media[name]) {
+			if !localURI(uri) {
+				return nil, nil, fmt.Errorf("unsupported segment URI %s", uri)
+			}
+			if segment := path.Join(base, uri); !seen[segment] {
+				seen[segment] = true
+				segments = append(segments, segment)
+			}
+		}
+	}
+	return segments, playlists, nil
+}
+
+// localURI reports whether a playlist URI names a file next to the playlist
+// or below it
+func localURI(uri string) bool {
+	return uri != "" && !strings.Contains(uri, "://") && !path.IsAbs(uri) && path.Clean(uri) == uri && !strings.HasPrefix(uri, "..")
+}
+
+// BestVariant returns the URI of the highest bandwidth variant of a master
+// playlist, or "" for a media playlist
+func BestVariant(data []byte) string {
+	playlist, err := m3u8.ParseMaster(data)
+	if err != nil {
+		return ""
+	}
+	if best := playlist.BestVariant(); best != nil {
+		return best.URI
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package vod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// RemuxMP4 copies the streams of a local HLS media playlist into a single
+// MP4 file without re-encoding. The moov atom is moved to the front so the
+// file plays while it downloads, and outputPath only appears once complete.
+func RemuxMP4(ctx context.Context, playlistPath, outputPath string) error {
+	return remuxMP4(ctx, []string{"-i", playlistPath}, outputPath)
+}
+
+// CutMP4 is RemuxMP4 for duration seconds from start seconds into the
+// playlist. Without re-encoding the cut starts at the keyframe before start.
+func CutMP4(ctx context.Context, playlistPath, outputPath string, start, duration float64) error {
+	return remuxMP4(ctx, []string{
+		"-ss", fmt.Sprintf("%.3f", start),
+		"-i", playlistPath,
+		"-t", fmt.Sprintf("%.3f", duration),
+	}, outputPath)
+}
+
+func remuxMP4(ctx context.Context, input []string, outputPath string) error {
+	tmpPath := outputPath + ".tmp"
+	var stderr bytes.Buffer
+	args := append([]string{"-y", "-hide_banner", "-loglevel", "error"}, input...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(args,
+		"-map", "0:v:0?", "-map", "0:a:0?",
+		"-c", "copy",
+		"-bsf:a", "aac_adtstoasc",
+		"-movflags", "+faststart",
+		"-f", "mp4", tmpPath,
+	)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return os.Rename(tmpPath, outputPath)
+}
@@ -0,0 +1,133 @@
+package vod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Slideshow defaults
+const (
+	DefaultImageSeconds = 5.0
+	slideshowWidth      = 1280
+	slideshowHeight     = 720
+	// slideshowRate is the frame rate of a slideshow. Stills compress to
+	// almost nothing between keyframes, so it is a normal rate for players
+	// rather than a low one.
+	slideshowRate = 25
+)
+
+// Slideshow is a video made from still images and an audio track. With audio
+// the images loop until it ends; without images a black poster shows.
+type Slideshow struct {
+	Images       []string `json:"images"`          // local image files, in order
+	Audio        string   `json:"audio,omitempty"` // local audio file, "" for none
+	ImageSeconds float64  `json:"image_seconds"`   // how long each image shows; 0 spreads them over the audio
+}
+
+// Validate checks that a slideshow has something to show
+func (s Slideshow) Validate() error {
+	if len(s.Images) == 0 && s.Audio == "" {
+		return fmt.Errorf("a slideshow needs images, an audio track or both")
+	}
+	if s.ImageSeconds < 0 {
+		return fmt.Errorf("image seconds can't be negative")
+	}
+	return nil
+}
+
+// RenderSlideshow renders a slideshow to an MP4 file at outputPath, with
+// every image fitted into 1280x720 on black. outputPath only appears once
+// complete.
+func RenderSlideshow(ctx context.Context, s Slideshow, outputPath string) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	seconds := s.ImageSeconds
+	if seconds == 0 {
+		seconds = DefaultImageSeconds
+		if s.Audio != "" && len(s.Images) > 0 {
+			duration, err := probeDuration(s.Audio)
+			if err != nil {
+				return fmt.Errorf("failed to read audio duration: %w", err)
+			}
+			seconds = max(duration/float64(len(s.Images)), 1)
+		}
+	}
+
+	args := []string{"-y", "-hide_banner", "-loglevel", "error"}
+	switch {
+	case len(s.Images) > 0:
+		list := outputPath + ".txt"
+		if err := os.WriteFile(list, concatList(s.Images, seconds), 0o644); err != nil {
+			return fmt.Errorf("failed to write image list: %w", err)
+		}
+		defer os.Remove(list)
+		if s.Audio != "" {
+			// The images repeat until the audio ends
+			args = append(args, "-stream_loop", "-1")
+		}
+		args = append(args, "-f", "concat", "-safe", "0", "-i", list)
+	default:
+		args = append(args, "-f", "lavfi", "-i", fmt.Sprintf("color=c=black:s=%dx%d:r=%d", slideshowWidth, slideshowHeight, slideshowRate))
+	}
+	if s.Audio != "" {
+		args = append(args, "-i", s.Audio)
+	}
+
+	filter := fmt.Sprintf("scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%[3]d,format=%[4]s",
+		slideshowWidth, slideshowHeight, slideshowRate, outputPixelFormat)
+	args = append(args, "-map", "0:v:0", "-vf", filter,
+		"-c:v", "libx264", "-preset", "veryfast", "-tune", "stillimage")
+	if s.Audio != "" {
+		args = append(args, "-map", "1:a:0", "-c:a", "aac", "-b:a", "128k", "-shortest")
+	}
+	tmpPath := outputPath + ".tmp"
+	args = append(args, "-movflags", "+faststart", "-f", "mp4", tmpPath)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return os.Rename(tmpPath, outputPath)
+}
+
+// concatList returns a concat demuxer script showing each image for seconds.
+// The last image is listed twice, as the demuxer ignores the duration of the
+// last entry.
+func concatList(images []string, seconds float64) []byte {
+	var b bytes.Buffer
+	for _, image := range images {
+		fmt.Fprintf(&b, "file %s\nduration %.3f\n", quoteConcat(image), seconds)
+	}
+	fmt.Fprintf(&b, "file %s\n", quoteConcat(images[len(images)-1]))
+	return b.Bytes()
+}
+
+// quoteConcat quotes an absolute path for a concat demuxer script
+func quoteConcat(path string) string {
+	path, _ = filepath.Abs(path)
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
+
+// probeDuration returns the duration of a media file in seconds
+func probeDuration(path string) (float64, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "csv=p=0", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("no duration")
+	}
+	return duration, nil
+}
@@ -0,0 +1,158 @@
+package vod
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"live-video/pkg/m3u8"
+)
+
+// MinLiveSegments is the fewest segments a trimmed live playlist keeps, so
+// players can still start three target durations from the live edge
+const MinLiveSegments = 3
+
+// TrimPlaylist returns a live media playlist with only the segments of the
+// last window of it, but at least MinLiveSegments; a negative window keeps
+// every segment. EXT-X-MEDIA-SEQUENCE and EXT-X-DISCONTINUITY-SEQUENCE are
+// advanced past the dropped segments, and the last EXT-X-MAP and EXT-X-KEY
+// of the dropped part are kept. Relative segment, map and key URIs are
+// resolved against base when it is set.
+func TrimPlaylist(data []byte, window time.Duration, base string) []byte {
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return data
+	}
+	if base != "" {
+		playlist.MapURIs(func(uri string) string { return resolveURI(uri, base) })
+	}
+	segments := playlist.Segments
+
+	// Keep segments from the live edge back until they span the window
+	keep := len(segments)
+	span := 0.0
+	for i := len(segments) - 1; i >= 0; i-- {
+		if window >= 0 && len(segments)-i > MinLiveSegments && span >= window.Seconds() {
+			keep = len(segments) - 1 - i
+			break
+		}
+		span += segments[i].Duration
+	}
+	dropped := segments[:len(segments)-keep]
+	kept := segments[len(segments)-keep:]
+
+	// Carry the state the dropped segments set up into the first kept one
+	var carried []m3u8.Tag
+	for _, name := range []string{m3u8.TagMap, m3u8.TagKey} {
+		for i := len(dropped) - 1; i >= 0; i-- {
+			if tag := dropped[i].Tag(name); tag != nil {
+				if len(kept) > 0 && !kept[0].Has(name) {
+					carried = append(carried, *tag)
+				}
+				break
+			}
+		}
+	}
+	for _, segment := range dropped {
+		for _, tag := range segment.Tags {
+			if tag.Name == m3u8.TagDiscontinuity {
+				playlist.DiscontinuitySequence++
+			}
+		}
+	}
+	if len(kept) > 0 {
+		kept[0].Tags = append(carried, kept[0].Tags...)
+	}
+
+	playlist.MediaSequence += len(dropped)
+	playlist.Segments = kept
+	return playlist.Encode()
+}
+
+// MediaSequence returns the media sequence number of the first segment of a
+// media playlist
+func MediaSequence(data []byte) int {
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return 0
+	}
+	return playlist.MediaSequence
+}
+
+// EndPlaylist marks a live media playlist as finished: its type becomes VOD
+// and it ends with EXT-X-ENDLIST, so players play out what is left and stop
+// instead of polling it. It reports false, leaving data alone, for master
+// playlists, playlists already ended and playlists without segments.
+func EndPlaylist(data []byte) ([]byte, bool) {
+	if m3u8.IsMaster(data) {
+		return data, false
+	}
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil || playlist.Ended || len(playlist.Segments) == 0 {
+		return data, false
+	}
+	playlist.SetHeader(m3u8.TagPlaylistType, m3u8.TypeVOD)
+	playlist.Ended = true
+	return playlist.Encode(), true
+}
+
+// MarkDiscontinuities tags the segments of a live media playlist whose media
+// sequence numbers are in starts, the first segments after transcoder
+// restarts, with EXT-X-DISCONTINUITY where the transcoder did not, and sets
+// EXT-X-DISCONTINUITY-SEQUENCE to the number of restarts before the first
+// segment, so players reset their decoders at each restart and keep counting
+// discontinuities as segments slide out
+func MarkDiscontinuities(data []byte, starts []int) []byte {
+	if len(starts) == 0 {
+		return data
+	}
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return data
+	}
+	restarted := make(map[int]bool, len(starts))
+	playlist.DiscontinuitySequence = 0
+	for _, start := range starts {
+		restarted[start] = true
+		if start < playlist.MediaSequence {
+			playlist.DiscontinuitySequence++
+		}
+	}
+	for i := range playlist.Segments {
+		segment := &playlist.Segments[i]
+		if restarted[playlist.MediaSequence+i] && !segment.Has(m3u8.TagDiscontinuity) {
+			segment.Tags = append([]m3u8.Tag{{Name: m3u8.TagDiscontinuity}}, segment.Tags...)
+		}
+	}
+	return playlist.Encode()
+}
+
+// StartBehindEdge makes players start a live media playlist offset behind
+// its live edge with EXT-X-START, replacing any start the playlist had
+func StartBehindEdge(data []byte, offset time.Duration) []byte {
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return data
+	}
+	playlist.SetHeader(m3u8.TagStart, fmt.Sprintf("TIME-OFFSET=-%.3f", offset.Seconds()))
+	return playlist.Encode()
+}
+
+// resolveURI prefixes a relative URI with base
+func resolveURI(uri, base string) string {
+	if base == "" || strings.Contains(uri, "://") || strings.HasPrefix(uri, "/") {
+		return uri
+	}
+	return base + uri
+}
+
+// MapURIs rewrites the segment URIs of a media playlist and the URI
+// attributes of its EXT-X-MAP and EXT-X-KEY tags with fn
+func MapURIs(data []byte, fn func(uri string) string) []byte {
+	playlist, err := m3u8.ParseMedia(data)
+	if err != nil {
+		return data
+	}
+	playlist.MapURIs(fn)
+	return playlist.Encode()
+}
@@ -0,0 +1,394 @@
+package watchparty
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of content a room watches
+const (
+	KindVideo  = "video"
+	KindStream = "stream"
+)
+
+// Host actions
+const (
+	ActionPlay     = "play"
+	ActionPause    = "pause"
+	ActionSeek     = "seek"
+	ActionTransfer = "transfer" // hand the host role to another member
+)
+
+// memberGrace is how long a member whose event connection dropped stays in
+// the room, so a reconnect keeps its membership and host role
+const memberGrace = 30 * time.Second
+
+var (
+	ErrRoomNotFound   = errors.New("room not found")
+	ErrMemberNotFound = errors.New("member not found")
+	ErrNotHost        = errors.New("only the host can control playback")
+	ErrInvalidAction  = errors.New("invalid action")
+)
+
+// State is the shared playback state of a room. Position is the playback
+// position at UpdatedAt; while playing it advances in real time.
+type State struct {
+	Playing   bool      `json:"playing"`
+	Position  float64   `json:"position"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Seq       int       `json:"seq"` // increases with every change
+}
+
+// at returns the state with the position advanced to now
+func (st State) at(now time.Time) State {
+	if st.Playing {
+		st.Position += now.Sub(st.UpdatedAt).Seconds()
+	}
+	st.UpdatedAt = now
+	return st
+}
+
+// Member is a viewer in a room. Token is only returned to the member itself
+// and authorizes its connections and commands.
+type Member struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	JoinedAt  time.Time `json:"joined_at"`
+	Connected bool      `json:"connected"`
+	Token     string    `json:"-"`
+
+	events chan []byte
+	leave  *time.Timer
+}
+
+// send queues an event for the member without blocking. Callers hold the
+// room lock.
+func (m *Member) send(data []byte) {
+	if m.events == nil {
+		return
+	}
+	select {
+	case m.events <- data:
+	default:
+	}
+}
+
+// Room groups viewers of one video or stream and keeps their playback in
+// sync with the host
+type Room struct {
+	ID        string
+	Kind      string
+	TargetID  string
+	CreatedAt time.Time
+
+	mu      sync.Mutex
+	hostID  string
+	state   State
+	members map[string]*Member
+}
+
+// Manager keeps watch-party rooms in memory
+type Manager struct {
+	mu    sync.RWMutex
+	rooms map[string]*Room
+}
+
+// NewManager creates an empty room registry
+func NewManager() *Manager {
+	return &Manager{
+		rooms: make(map[string]*Room),
+	}
+}
+
+// Create opens a room for a video or stream, starting at position, with the
+// creator as its host
+func (m *Manager) Create(kind, targetID, hostName string, position float64, playing bool) (*Room, *Member) {
+	now := time.Now()
+	room := &Room{
+		ID:        uuid.New().String(),
+		Kind:      kind,
+		TargetID:  targetID,
+		CreatedAt: now,
+		state:     State{Playing: playing, Position: position, UpdatedAt: now, Seq: 1},
+		members:   make(map[string]*Member),
+	}
+	host := room.addMember(hostName)
+	room.hostID = host.ID
+
+	m.mu.Lock()
+	m.rooms[room.ID] = room
+	m.mu.Unlock()
+	return room, host
+}
+
+// Get returns a room
+func (m *Manager) Get(roomID string) (*Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	room, ok := m.rooms[roomID]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	return room, nil
+}
+
+// Join adds a member to a room
+func (m *Manager) Join(roomID, name string) (*Room, *Member, error) {
+	room, err := m.Get(roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if len(room.members) == 0 {
+		// Closed while we looked it up
+		return nil, nil, ErrRoomNotFound
+	}
+	member := room.addMember(name)
+	room.broadcastMembers()
+	return room, member, nil
+}
+
+// Leave removes a member. The longest present member becomes host when the
+// host leaves, and the room closes when the last member leaves.
+func (m *Manager) Leave(roomID, token string) error {
+	room, err := m.Get(roomID)
+	if err != nil {
+		return err
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	member := room.memberByToken(token)
+	if member == nil {
+		return ErrMemberNotFound
+	}
+	m.removeMember(room, member)
+	return nil
+}
+
+// Connect opens the event connection of a member. The returned channel gets
+// the room's state and membership as JSON events; it is closed when the
+// member leaves or connects again. Call Disconnect with it when done.
+func (m *Manager) Connect(roomID, token string) (*Room, *Member, <-chan []byte, error) {
+	room, err := m.Get(roomID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	member := room.memberByToken(token)
+	if member == nil {
+		return nil, nil, nil, ErrMemberNotFound
+	}
+	if member.leave != nil {
+		member.leave.Stop()
+		member.leave = nil
+	}
+	if member.events != nil {
+		close(member.events)
+	}
+	events := make(chan []byte, 16)
+	member.events = events
+	member.Connected = true
+
+	member.send(room.stateEvent())
+	room.broadcastMembers()
+	return room, member, events, nil
+}
+
+// Disconnect ends an event connection. The member is removed unless it
+// connects again within memberGrace.
+func (m *Manager) Disconnect(room *Room, member *Member, events <-chan []byte) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if member.events == nil || (<-chan []byte)(member.events) != events {
+		// Replaced by a newer connection, or gone
+		return
+	}
+	close(member.events)
+	member.events = nil
+	member.Connected = false
+	room.broadcastMembers()
+
+	member.leave = time.AfterFunc(memberGrace, func() {
+		room.mu.Lock()
+		defer room.mu.Unlock()
+		if !member.Connected && room.members[member.ID] == member {
+			m.removeMember(room, member)
+		}
+	})
+}
+
+// Control applies a host action and sends the new state to all members.
+// Position is used by seek; target is the member ID for transfer.
+func (m *Manager) Control(roomID, token, action string, position float64, target string) (State, error) {
+	room, err := m.Get(roomID)
+	if err != nil {
+		return State{}, err
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	member := room.memberByToken(token)
+	if member == nil {
+		return State{}, ErrMemberNotFound
+	}
+	if member.ID != room.hostID {
+		return State{}, ErrNotHost
+	}
+
+	state := room.state.at(time.Now())
+	switch action {
+	case ActionPlay:
+		state.Playing = true
+	case ActionPause:
+		state.Playing = false
+	case ActionSeek:
+		if position < 0 {
+			return State{}, ErrInvalidAction
+		}
+		state.Position = position
+	case ActionTransfer:
+		if _, ok := room.members[target]; !ok {
+			return State{}, ErrMemberNotFound
+		}
+		room.hostID = target
+		room.broadcastMembers()
+		return state, nil
+	default:
+		return State{}, ErrInvalidAction
+	}
+	state.Seq = room.state.Seq + 1
+	room.state = state
+	room.broadcast(room.stateEvent())
+	return state, nil
+}
+
+// removeMember drops a member, hands over the host role and closes empty
+// rooms. Callers hold room.mu.
+func (m *Manager) removeMember(room *Room, member *Member) {
+	if member.leave != nil {
+		member.leave.Stop()
+	}
+	if member.events != nil {
+		close(member.events)
+		member.events = nil
+	}
+	delete(room.members, member.ID)
+
+	if len(room.members) == 0 {
+		m.mu.Lock()
+		delete(m.rooms, room.ID)
+		m.mu.Unlock()
+		return
+	}
+	if member.ID == room.hostID {
+		room.hostID = room.sortedMembers()[0].ID
+	}
+	room.broadcastMembers()
+}
+
+// Snapshot returns the room's current state, host and members
+func (r *Room) Snapshot() (State, string, []Member) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	members := make([]Member, 0, len(r.members))
+	for _, member := range r.sortedMembers() {
+		members = append(members, Member{ID: member.ID, Name: member.Name, JoinedAt: member.JoinedAt, Connected: member.Connected})
+	}
+	return r.state.at(time.Now()), r.hostID, members
+}
+
+// StateEvent returns the current state event, which members receive on
+// connect, after every change and periodically to correct drift
+func (r *Room) StateEvent() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stateEvent()
+}
+
+// addMember creates a member. Callers hold r.mu or own r.
+func (r *Room) addMember(name string) *Member {
+	member := &Member{
+		ID:       uuid.New().String(),
+		Name:     name,
+		JoinedAt: time.Now(),
+		Token:    uuid.New().String(),
+	}
+	r.members[member.ID] = member
+	return member
+}
+
+// memberByToken finds a member by its token. Callers hold r.mu.
+func (r *Room) memberByToken(token string) *Member {
+	if token == "" {
+		return nil
+	}
+	for _, member := range r.members {
+		if member.Token == token {
+			return member
+		}
+	}
+	return nil
+}
+
+// sortedMembers returns the members in join order. Callers hold r.mu.
+func (r *Room) sortedMembers() []*Member {
+	members := make([]*Member, 0, len(r.members))
+	for _, member := range r.members {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+	return members
+}
+
+// stateEvent encodes the current state. Callers hold r.mu.
+func (r *Room) stateEvent() []byte {
+	return encode(map[string]interface{}{
+		"type":    "state",
+		"state":   r.state.at(time.Now()),
+		"host_id": r.hostID,
+	})
+}
+
+// broadcastMembers sends the membership to all members. Callers hold r.mu.
+func (r *Room) broadcastMembers() {
+	members := make([]map[string]interface{}, 0, len(r.members))
+	for _, member := range r.sortedMembers() {
+		members = append(members, map[string]interface{}{
+			"id":        member.ID,
+			"name":      member.Name,
+			"connected": member.Connected,
+			"host":      member.ID == r.hostID,
+		})
+	}
+	r.broadcast(encode(map[string]interface{}{
+		"type":    "members",
+		"host_id": r.hostID,
+		"members": members,
+	}))
+}
+
+// broadcast sends an event to all connected members. Callers hold r.mu.
+func (r *Room) broadcast(data []byte) {
+	for _, member := range r.members {
+		member.send(data)
+	}
+}
+
+func encode(event map[string]interface{}) []byte {
+	data, _ := json.Marshal(event)
+	return data
+}
@@ -0,0 +1,324 @@
+// Package watermark traces leaked recordings of live streams to playback
+// sessions. Watermarked streams encode every rendition twice, unmarked (A)
+// and with a faint mark (B), and every session is served its own sequence of
+// A and B segments. The sequence read back from a recording names the
+// session it was played in.
+package watermark
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"live-video/pkg/m3u8"
+
+	"github.com/google/uuid"
+)
+
+// Variants of a segment
+const (
+	VariantA = 0 // unmarked
+	VariantB = 1 // marked
+)
+
+// MaxTraceBits is the longest variant sequence Trace compares
+const MaxTraceBits = 100000
+
+// maxMatches is how many sessions Trace returns
+const maxMatches = 10
+
+// Session is a playback session of a watermarked stream
+type Session struct {
+	ID              string    `json:"id"`
+	StreamID        string    `json:"stream_id"`
+	Seed            string    `json:"seed"` // picks the variant of every segment
+	UserID          string    `json:"user_id,omitempty"`
+	PlayerSessionID string    `json:"player_session_id,omitempty"`
+	ClientIP        string    `json:"client_ip,omitempty"`
+	UserAgent       string    `json:"user_agent,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+}
+
+// Variant returns the variant of the segment with media sequence number
+// sequence the session plays
+func (s *Session) Variant(sequence int) int {
+	mac := hmac.New(sha256.New, []byte(s.Seed))
+	mac.Write([]byte(strconv.Itoa(sequence)))
+	return int(mac.Sum(nil)[0] & 1)
+}
+
+// Mix returns the media playlist a of the unmarked variant with the segments
+// the session plays from b, the playlist of the marked variant, whose
+// segment URIs are resolved against bBase. Segments b doesn't have yet are
+// played from a.
+func (s *Session) Mix(a, b []byte, bBase string) []byte {
+	unmarked, err := m3u8.ParseMedia(a)
+	if err != nil {
+		return a
+	}
+	marked, err := m3u8.ParseMedia(b)
+	if err != nil {
+		return a
+	}
+	for i := range unmarked.Segments {
+		sequence := unmarked.MediaSequence + i
+		j := sequence - marked.MediaSequence
+		if j < 0 || j >= len(marked.Segments) || s.Variant(sequence) != VariantB {
+			continue
+		}
+		uri := marked.Segments[j].URI
+		if !strings.Contains(uri, "://") && !strings.HasPrefix(uri, "/") {
+			uri = bBase + uri
+		}
+		unmarked.Segments[i].URI = uri
+	}
+	return unmarked.Encode()
+}
+
+// Match is a session that played a traced variant sequence
+type Match struct {
+	Session  Session `json:"session"`
+	Matched  int     `json:"matched"`  // segments whose variant matches
+	Compared int     `json:"compared"` // segments with a known variant
+	Score    float64 `json:"score"`    // Matched / Compared
+	// FalseMatch is the chance an unrelated session matches as well
+	FalseMatch float64 `json:"false_match"`
+}
+
+// Ledger keeps the sessions of watermarked streams, journaled per stream in
+// a directory so traces outlive restarts
+type Ledger struct {
+	dir string
+
+	mu       sync.Mutex
+	sessions map[string]*Session   // by ID
+	byStream map[string][]*Session // in start order
+}
+
+// NewLedger creates a ledger journaling to dir and loads the sessions
+// journaled there
+func NewLedger(dir string) *Ledger {
+	l := &Ledger{dir: dir, sessions: make(map[string]*Session), byStream: make(map[string][]*Session)}
+	journals, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	for _, journal := range journals {
+		sessions, err := readJournal(journal)
+		if err != nil {
+			log.Printf("[Watermark] Failed to read %s: %v", filepath.Base(journal), err)
+		}
+		for _, session := range sessions {
+			l.add(session)
+		}
+	}
+	return l
+}
+
+// Begin starts a session of session.StreamID with a new ID and seed and
+// journals it
+func (l *Ledger) Begin(session Session) (*Session, error) {
+	seed := make([]byte, 16)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	session.ID = uuid.New().String()
+	session.Seed = hex.EncodeToString(seed)
+	session.StartedAt = time.Now().UTC()
+
+	line, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	journal, err := os.OpenFile(l.journalPath(session.StreamID), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer journal.Close()
+	if _, err := journal.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to journal session: %w", err)
+	}
+	l.add(&session)
+	return &session, nil
+}
+
+// add indexes a session. Callers hold l.mu or own l.
+func (l *Ledger) add(session *Session) {
+	l.sessions[session.ID] = session
+	l.byStream[session.StreamID] = append(l.byStream[session.StreamID], session)
+}
+
+// Get returns a session of a stream
+func (l *Ledger) Get(streamID, id string) (*Session, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	session, ok := l.sessions[id]
+	if !ok || session.StreamID != streamID {
+		return nil, false
+	}
+	return session, true
+}
+
+// Trace returns the sessions of a stream that played a variant sequence
+// best, most matching first. variants has one character per segment from
+// media sequence number first on: '0' for A, '1' for B and '?' where the
+// variant could not be told.
+func (l *Ledger) Trace(streamID string, first int, variants string) ([]Match, error) {
+	if len(variants) > MaxTraceBits {
+		return nil, fmt.Errorf("at most %d variants can be traced", MaxTraceBits)
+	}
+	if strings.Trim(variants, "01?") != "" {
+		return nil, fmt.Errorf("variants may only be 0, 1 or ?")
+	}
+
+	l.mu.Lock()
+	sessions := append([]*Session(nil), l.byStream[streamID]...)
+	l.mu.Unlock()
+
+	var matches []Match
+	for _, session := range sessions {
+		match := Match{Session: *session}
+		for i, variant := range variants {
+			if variant == '?' {
+				continue
+			}
+			match.Compared++
+			if session.Variant(first+i) == int(variant-'0') {
+				match.Matched++
+			}
+		}
+		if match.Compared == 0 {
+			continue
+		}
+		match.Score = float64(match.Matched) / float64(match.Compared)
+		match.FalseMatch = binomialTail(match.Compared, match.Matched)
+		matches = append(matches, match)
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Matched > matches[j].Matched })
+	if len(matches) > maxMatches {
+		matches = matches[:maxMatches]
+	}
+	return matches, nil
+}
+
+// binomialTail returns the chance of at least k heads in n fair coin flips
+func binomialTail(n, k int) float64 {
+	lgN, _ := math.Lgamma(float64(n + 1))
+	tail := 0.0
+	for i := k; i <= n; i++ {
+		lgI, _ := math.Lgamma(float64(i + 1))
+		lgRest, _ := math.Lgamma(float64(n - i + 1))
+		tail += math.Exp(lgN - lgI - lgRest - float64(n)*math.Ln2)
+	}
+	return min(tail, 1)
+}
+
+// EraseStream drops the sessions of a stream and returns how many it dropped
+func (l *Ledger) EraseStream(streamID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sessions := l.byStream[streamID]
+	for _, session := range sessions {
+		delete(l.sessions, session.ID)
+	}
+	delete(l.byStream, streamID)
+	os.Remove(l.journalPath(streamID))
+	return len(sessions)
+}
+
+// EraseUser drops the sessions played by a user and returns how many it
+// dropped
+func (l *Ledger) EraseUser(userID string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	erased := 0
+	for streamID, sessions := range l.byStream {
+		kept := sessions[:0:0]
+		for _, session := range sessions {
+			if session.UserID == userID {
+				delete(l.sessions, session.ID)
+				continue
+			}
+			kept = append(kept, session)
+		}
+		if len(kept) == len(sessions) {
+			continue
+		}
+		if err := l.writeJournal(streamID, kept); err != nil {
+			return erased, err
+		}
+		erased += len(sessions) - len(kept)
+		l.byStream[streamID] = kept
+	}
+	return erased, nil
+}
+
+// Count returns how many sessions a stream has, or a user played with
+// user set
+func (l *Ledger) Count(id string, user bool) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !user {
+		return len(l.byStream[id])
+	}
+	count := 0
+	for _, session := range l.sessions {
+		if session.UserID == id {
+			count++
+		}
+	}
+	return count
+}
+
+// writeJournal replaces the journal of a stream. Callers hold l.mu.
+func (l *Ledger) writeJournal(streamID string, sessions []*Session) error {
+	var b strings.Builder
+	for _, session := range sessions {
+		line, _ := json.Marshal(session)
+		b.Write(append(line, '\n'))
+	}
+	path := l.journalPath(streamID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (l *Ledger) journalPath(streamID string) string {
+	return filepath.Join(l.dir, streamID+".jsonl")
+}
+
+// readJournal reads the sessions of a journal. A line cut short by a crash
+// is skipped.
+func readJournal(path string) ([]*Session, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sessions []*Session
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var session Session
+		if err := json.Unmarshal(scanner.Bytes(), &session); err != nil || session.ID == "" {
+			continue
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, scanner.Err()
+}
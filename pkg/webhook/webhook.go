@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"live-video/pkg/events"
+
+	"github.com/google/uuid"
+)
+
+// Event types, the topics of the events forwarded from the bus
+const (
+	EventStreamAutoStopped   = "stream.auto_stopped"
+	EventStreamStatusChanged = "stream.status_changed"
+)
+
+// retryDelays are the waits between delivery attempts of an event
+var retryDelays = []time.Duration{time.Second, 5 * time.Second, 25 * time.Second}
+
+// Event is the JSON body posted to the webhook endpoint
+type Event struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// Notifier posts events to a webhook endpoint in the background. Each request
+// carries an X-Webhook-Signature header, "sha256=" and the hex HMAC-SHA256 of
+// the body with the shared secret, so receivers can verify the sender. A nil
+// Notifier drops all events.
+type Notifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+	queue  chan *Event
+}
+
+// NewNotifier creates a notifier for url and starts its delivery worker.
+// It returns nil when url is empty.
+func NewNotifier(url, secret string) *Notifier {
+	if url == "" {
+		return nil
+	}
+	n := &Notifier{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *Event, 100),
+	}
+	go n.run()
+	return n
+}
+
+// Send queues an event. Events are dropped when the queue is full, so a slow
+// endpoint never blocks the caller.
+func (n *Notifier) Send(eventType string, data map[string]interface{}) {
+	if n == nil {
+		return
+	}
+	n.enqueue(&Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+}
+
+// Forward posts the events of bus whose topic matches one of patterns (see
+// events.Match), keeping their IDs. Only events published on this replica
+// are posted, so the endpoint gets each once however many replicas run.
+func (n *Notifier) Forward(bus *events.Bus, patterns ...string) {
+	if n == nil || len(patterns) == 0 {
+		return
+	}
+	bus.SubscribeLocal("*", func(e events.Event) {
+		for _, pattern := range patterns {
+			if events.Match(pattern, e.Type) {
+				n.enqueue(&Event{ID: e.ID, Type: e.Type, CreatedAt: e.At, Data: e.Data})
+				return
+			}
+		}
+	})
+}
+
+// enqueue queues an event, dropping it when the queue is full
+func (n *Notifier) enqueue(event *Event) {
+	select {
+	case n.queue <- event:
+	default:
+		log.Printf("[Webhook] Queue full, dropping %s event %s", event.Type, event.ID)
+	}
+}
+
+func (n *Notifier) run() {
+	for event := range n.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("[Webhook] Failed to encode %s event: %v", event.Type, err)
+			continue
+		}
+
+		err = n.deliver(event, body)
+		for _, delay := range retryDelays {
+			if err == nil {
+				break
+			}
+			time.Sleep(delay)
+			err = n.deliver(event, body)
+		}
+		if err != nil {
+			log.Printf("[Webhook] Giving up on %s event %s: %v", event.Type, event.ID, err)
+		}
+	}
+}
+
+// deliver posts one event; any status outside 2xx is a failure
+func (n *Notifier) deliver(event *Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-ID", event.ID)
+	if len(n.secret) > 0 {
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Ingest bitrate bounds for target-bitrate hints, in kbps
+const (
+	ingestMinKbps     = 300
+	ingestMaxKbps     = 6000
+	ingestInitialKbps = 2500
+)
+
+// BitrateStats describes the ingest uplink as seen by the server
+type BitrateStats struct {
+	ReceiveKbps         int       `json:"receive_kbps"`
+	LossRate            float64   `json:"loss_rate"`
+	BrowserEstimateKbps int       `json:"browser_estimate_kbps,omitempty"` // transport-cc estimate reported by the browser
+	TargetKbps          int       `json:"target_kbps"`                     // hint sent via REMB and the control channel
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// bitrateMessage is exchanged with the browser over the "control" data channel
+type bitrateMessage struct {
+	Type                  string `json:"type"`
+	TargetKbps            int    `json:"target_kbps,omitempty"`
+	AvailableOutgoingKbps int    `json:"available_outgoing_kbps,omitempty"`
+}
+
+// bitrateController estimates the broadcaster's sustainable uplink from the
+// received video packets (rate and sequence gaps) and the browser's own
+// transport-cc estimate, and derives a target bitrate with a loss-based
+// increase/decrease rule similar to the GCC loss controller
+type bitrateController struct {
+	mu sync.Mutex
+
+	target          float64
+	browserEstimate int
+
+	windowStart time.Time
+	windowBytes int
+	windowPkts  int
+	started     bool
+	highestSeq  uint32 // extended sequence number
+	windowSeq   uint32 // highestSeq at the start of the window
+
+	stats BitrateStats
+}
+
+func newBitrateController() *bitrateController {
+	return &bitrateController{
+		target:      ingestInitialKbps,
+		windowStart: time.Now(),
+		stats:       BitrateStats{TargetKbps: ingestInitialKbps},
+	}
+}
+
+// onPacket records a received video RTP packet
+func (b *bitrateController) onPacket(seq uint16, size int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.windowBytes += size
+	b.windowPkts++
+
+	if !b.started {
+		b.started = true
+		b.highestSeq = uint32(seq)
+		b.windowSeq = uint32(seq) - 1
+		return
+	}
+
+	// Extend the 16-bit sequence number across wraparounds
+	ext := (b.highestSeq &^ 0xFFFF) | uint32(seq)
+	if int32(ext-b.highestSeq) < -0x8000 {
+		ext += 0x10000
+	} else if int32(ext-b.highestSeq) > 0x8000 {
+		ext -= 0x10000
+	}
+	if int32(ext-b.highestSeq) > 0 {
+		b.highestSeq = ext
+	}
+}
+
+// onBrowserEstimate records the browser's available outgoing bitrate
+func (b *bitrateController) onBrowserEstimate(kbps int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.browserEstimate = kbps
+}
+
+// update closes the current measurement window and returns the new target
+func (b *bitrateController) update() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	elapsed := time.Since(b.windowStart).Seconds()
+	if elapsed <= 0 || !b.started {
+		return int(b.target)
+	}
+
+	receiveKbps := float64(b.windowBytes*8) / elapsed / 1000
+	loss := 0.0
+	if expected := int(b.highestSeq - b.windowSeq); expected > 0 && b.windowPkts < expected {
+		loss = 1 - float64(b.windowPkts)/float64(expected)
+	}
+
+	switch {
+	case loss > 0.10:
+		b.target *= 1 - 0.5*loss
+	case loss < 0.02:
+		b.target *= 1.08
+	}
+
+	// Don't let the target run away while the encoder is application-limited
+	if ceiling := receiveKbps * 1.5; ceiling > ingestMinKbps && b.target > ceiling {
+		b.target = ceiling
+	}
+	if b.browserEstimate > 0 && b.target > float64(b.browserEstimate) {
+		b.target = float64(b.browserEstimate)
+	}
+	if b.target < ingestMinKbps {
+		b.target = ingestMinKbps
+	}
+	if b.target > ingestMaxKbps {
+		b.target = ingestMaxKbps
+	}
+
+	b.stats = BitrateStats{
+		ReceiveKbps:         int(receiveKbps),
+		LossRate:            loss,
+		BrowserEstimateKbps: b.browserEstimate,
+		TargetKbps:          int(b.target),
+		UpdatedAt:           time.Now(),
+	}
+
+	b.windowStart = time.Now()
+	b.windowBytes = 0
+	b.windowPkts = 0
+	b.windowSeq = b.highestSeq
+
+	return int(b.target)
+}
+
+// snapshot returns the stats of the last completed window
+func (b *bitrateController) snapshot() BitrateStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+func encodeBitrateHint(targetKbps int) []byte {
+	data, _ := json.Marshal(bitrateMessage{Type: "bitrate", TargetKbps: targetKbps})
+	return data
+}
@@ -0,0 +1,264 @@
+package webrtc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// CaptureInfo describes a stored debug capture file
+type CaptureInfo struct {
+	Name      string    `json:"name"`
+	StreamID  string    `json:"stream_id"`
+	Kind      string    `json:"kind"` // video, audio or meta
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// captureMeta is written next to each capture to tell which browser and
+// negotiated codec produced it
+type captureMeta struct {
+	StreamID    string    `json:"stream_id"`
+	Kind        string    `json:"kind"`
+	MimeType    string    `json:"mime_type"`
+	ClockRate   uint32    `json:"clock_rate"`
+	PayloadType uint8     `json:"payload_type"`
+	SDPFmtpLine string    `json:"sdp_fmtp_line,omitempty"`
+	SSRC        uint32    `json:"ssrc"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	OfferSDP    string    `json:"offer_sdp,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// CaptureStore keeps raw RTP debug captures of ingest sessions in rtpdump
+// format (readable by Wireshark and rtpplay) with size and age limits
+type CaptureStore struct {
+	dir             string
+	maxCaptureBytes int64
+	maxTotalBytes   int64
+	retention       time.Duration
+	mu              sync.Mutex
+}
+
+// NewCaptureStore creates a capture store in dir
+func NewCaptureStore(dir string, maxCaptureBytes, maxTotalBytes int64, retention time.Duration) (*CaptureStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	return &CaptureStore{
+		dir:             dir,
+		maxCaptureBytes: maxCaptureBytes,
+		maxTotalBytes:   maxTotalBytes,
+		retention:       retention,
+	}, nil
+}
+
+// List returns the stored captures, newest first
+func (c *CaptureStore) List() ([]CaptureInfo, error) {
+	c.Prune()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list()
+}
+
+// Path resolves a capture name to its file path
+func (c *CaptureStore) Path(name string) (string, error) {
+	if filepath.Base(name) != name || (filepath.Ext(name) != ".rtpdump" && filepath.Ext(name) != ".json") {
+		return "", fmt.Errorf("invalid capture name: %s", name)
+	}
+	path := filepath.Join(c.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("capture not found: %s", name)
+	}
+	return path, nil
+}
+
+// Delete removes a capture file
+func (c *CaptureStore) Delete(name string) error {
+	path, err := c.Path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Prune deletes captures older than the retention period, then the oldest
+// captures until the store fits in its total size limit
+func (c *CaptureStore) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	captures, err := c.list()
+	if err != nil {
+		return
+	}
+
+	var total int64
+	for _, info := range captures {
+		total += info.Size
+	}
+
+	// list is newest first; walk from the oldest
+	for i := len(captures) - 1; i >= 0; i-- {
+		info := captures[i]
+		expired := c.retention > 0 && time.Since(info.CreatedAt) > c.retention
+		overSize := c.maxTotalBytes > 0 && total > c.maxTotalBytes
+		if !expired && !overSize {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, info.Name)); err == nil {
+			total -= info.Size
+			log.Printf("[Capture] Removed %s", info.Name)
+		}
+	}
+}
+
+func (c *CaptureStore) list() ([]CaptureInfo, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture directory: %w", err)
+	}
+
+	captures := make([]CaptureInfo, 0, len(entries))
+	for _, entry := range entries {
+		fileInfo, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		// Names are {streamID}_{timestamp}_{kind}.{ext}
+		base := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		parts := strings.Split(base, "_")
+		if len(parts) < 3 {
+			continue
+		}
+		kind := parts[len(parts)-1]
+		if filepath.Ext(entry.Name()) == ".json" {
+			kind = "meta"
+		}
+		captures = append(captures, CaptureInfo{
+			Name:      entry.Name(),
+			StreamID:  strings.Join(parts[:len(parts)-2], "_"),
+			Kind:      kind,
+			Size:      fileInfo.Size(),
+			CreatedAt: fileInfo.ModTime(),
+		})
+	}
+
+	sort.Slice(captures, func(i, j int) bool {
+		return captures[i].CreatedAt.After(captures[j].CreatedAt)
+	})
+	return captures, nil
+}
+
+// start opens a new rtpdump capture for one track
+func (c *CaptureStore) start(meta captureMeta) (*rtpCapture, error) {
+	c.Prune()
+
+	base := fmt.Sprintf("%s_%s_%s", meta.StreamID, meta.StartedAt.UTC().Format("20060102T150405"), meta.Kind)
+
+	metaData, _ := json.MarshalIndent(meta, "", "  ")
+	if err := os.WriteFile(filepath.Join(c.dir, base+".json"), metaData, 0o640); err != nil {
+		return nil, fmt.Errorf("failed to write capture metadata: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(c.dir, base+".rtpdump"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+
+	// rtpdump file header: text line, then start time, source address and port
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint32(header[0:4], uint32(meta.StartedAt.Unix()))
+	binary.BigEndian.PutUint32(header[4:8], uint32(meta.StartedAt.Nanosecond()/1000))
+	if _, err := file.WriteString("#!rtpplay1.0 0.0.0.0/0\n"); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	log.Printf("[Capture] Recording raw %s RTP of stream %s to %s.rtpdump", meta.Kind, meta.StreamID, base)
+	return &rtpCapture{
+		file:     file,
+		name:     base + ".rtpdump",
+		start:    meta.StartedAt,
+		maxBytes: c.maxCaptureBytes,
+		written:  int64(len("#!rtpplay1.0 0.0.0.0/0\n") + len(header)),
+	}, nil
+}
+
+// rtpCapture writes RTP packets of one track in rtpdump format
+type rtpCapture struct {
+	file     *os.File
+	name     string
+	start    time.Time
+	maxBytes int64
+	written  int64
+	full     bool
+}
+
+// Write appends a packet. Once the size limit is reached further packets are
+// dropped so a forgotten capture can't fill the disk.
+func (r *rtpCapture) Write(packet *rtp.Packet) {
+	if r.full {
+		return
+	}
+
+	raw, err := packet.Marshal()
+	if err != nil {
+		return
+	}
+
+	record := make([]byte, 8+len(raw))
+	binary.BigEndian.PutUint16(record[0:2], uint16(len(record)))
+	binary.BigEndian.PutUint16(record[2:4], uint16(len(raw)))
+	binary.BigEndian.PutUint32(record[4:8], uint32(time.Since(r.start).Milliseconds()))
+	copy(record[8:], raw)
+
+	if r.maxBytes > 0 && r.written+int64(len(record)) > r.maxBytes {
+		r.full = true
+		log.Printf("[Capture] %s reached its size limit, capture stopped", r.name)
+		return
+	}
+
+	n, err := r.file.Write(record)
+	r.written += int64(n)
+	if err != nil {
+		r.full = true
+		log.Printf("[Capture] Failed to write %s: %v", r.name, err)
+	}
+}
+
+// Close finishes the capture file
+func (r *rtpCapture) Close() {
+	if err := r.file.Close(); err != nil {
+		log.Printf("[Capture] Failed to close %s: %v", r.name, err)
+	}
+}
+
+func newCaptureMeta(streamID string, track *webrtc.TrackRemote) captureMeta {
+	codec := track.Codec()
+	return captureMeta{
+		StreamID:    streamID,
+		Kind:        track.Kind().String(),
+		MimeType:    codec.MimeType,
+		ClockRate:   codec.ClockRate,
+		PayloadType: uint8(codec.PayloadType),
+		SDPFmtpLine: codec.SDPFmtpLine,
+		SSRC:        uint32(track.SSRC()),
+		StartedAt:   time.Now(),
+	}
+}
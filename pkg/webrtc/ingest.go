@@ -1,13 +1,18 @@
 package webrtc
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
 ) // IngestService manages WebRTC ingestion from browsers
@@ -17,12 +22,18 @@ type IngestService struct {
 	outputDir      string
 	mu             sync.Mutex
 	closed         bool
+	bitrate        *bitrateController
+	controlChannel *webrtc.DataChannel
+	messages       *webrtc.DataChannel // server messages to the broadcaster
+	capture        atomic.Pointer[CaptureStore]
+	userAgent      string
+	offerSDP       string
+	lastPacket     atomic.Int64 // unix nanoseconds of the last RTP packet received
 }
 
-// NewIngestService creates a new WebRTC ingestion service
-func NewIngestService(streamID string) (*IngestService, error) {
+// NewIngestService creates a new WebRTC ingestion service writing to outputDir
+func NewIngestService(streamID, outputDir string) (*IngestService, error) {
 	// Create output directory
-	outputDir := filepath.Join("/tmp", "webrtc-ingest", streamID)
 	if err := os.MkdirAll(outputDir, 0o755); err != nil {
 		return nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
@@ -30,6 +41,7 @@ func NewIngestService(streamID string) (*IngestService, error) {
 	return &IngestService{
 		streamID:  streamID,
 		outputDir: outputDir,
+		bitrate:   newBitrateController(),
 	}, nil
 }
 
@@ -71,6 +83,8 @@ func (s *IngestService) CreateOffer() (string, error) {
 		log.Printf("[WebRTC] ICE connection state changed: %s", state.String())
 	})
 
+	s.watchControlChannel(peerConnection)
+
 	// Create offer
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
@@ -107,6 +121,7 @@ func (s *IngestService) HandleOffer(offerSDP string) (string, error) {
 	}
 
 	s.peerConnection = peerConnection
+	s.offerSDP = offerSDP
 
 	// Handle incoming tracks
 	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
@@ -124,6 +139,8 @@ func (s *IngestService) HandleOffer(offerSDP string) (string, error) {
 		log.Printf("[WebRTC] ICE connection state changed: %s", state.String())
 	})
 
+	s.watchControlChannel(peerConnection)
+
 	// Set remote description (browser's offer)
 	offer := webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
@@ -133,6 +150,7 @@ func (s *IngestService) HandleOffer(offerSDP string) (string, error) {
 	if err := peerConnection.SetRemoteDescription(offer); err != nil {
 		return "", fmt.Errorf("failed to set remote description: %w", err)
 	}
+	s.openMessages(peerConnection)
 
 	// Create answer
 	answer, err := peerConnection.CreateAnswer(nil)
@@ -183,6 +201,17 @@ func (s *IngestService) saveVideoTrack(track *webrtc.TrackRemote) {
 	}
 	defer ivf.Close()
 
+	done := make(chan struct{})
+	defer close(done)
+	go s.adaptBitrate(uint32(track.SSRC()), done)
+
+	var capture *rtpCapture
+	defer func() {
+		if capture != nil {
+			capture.Close()
+		}
+	}()
+
 	log.Printf("[WebRTC] Saving video track to %s", videoFile) // Read RTP packets and write to IVF
 	for {
 		rtpPacket, _, err := track.ReadRTP()
@@ -193,6 +222,10 @@ func (s *IngestService) saveVideoTrack(track *webrtc.TrackRemote) {
 			break
 		}
 
+		s.lastPacket.Store(time.Now().UnixNano())
+		s.bitrate.onPacket(rtpPacket.SequenceNumber, rtpPacket.MarshalSize())
+		capture = s.captureRTP(track, capture, rtpPacket)
+
 		if err := ivf.WriteRTP(rtpPacket); err != nil {
 			log.Printf("[WebRTC] Error writing video RTP: %v", err)
 			break
@@ -214,6 +247,13 @@ func (s *IngestService) saveAudioTrack(track *webrtc.TrackRemote) {
 
 	log.Printf("[WebRTC] Saving audio track to %s", audioFile)
 
+	var capture *rtpCapture
+	defer func() {
+		if capture != nil {
+			capture.Close()
+		}
+	}()
+
 	// Read RTP packets and write to file
 	for {
 		rtpPacket, _, err := track.ReadRTP()
@@ -224,6 +264,9 @@ func (s *IngestService) saveAudioTrack(track *webrtc.TrackRemote) {
 			break
 		}
 
+		s.lastPacket.Store(time.Now().UnixNano())
+		capture = s.captureRTP(track, capture, rtpPacket)
+
 		// Write payload to file (simplified, should use proper OGG muxing)
 		if _, err := file.Write(rtpPacket.Payload); err != nil {
 			log.Printf("[WebRTC] Error writing audio data: %v", err)
@@ -234,6 +277,138 @@ func (s *IngestService) saveAudioTrack(track *webrtc.TrackRemote) {
 	log.Printf("[WebRTC] Audio track saved successfully")
 }
 
+// watchControlChannel accepts the browser's "control" data channel, which
+// carries target-bitrate hints to the browser and its transport-cc
+// bandwidth estimate back to us
+func (s *IngestService) watchControlChannel(peerConnection *webrtc.PeerConnection) {
+	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() != "control" {
+			return
+		}
+
+		s.mu.Lock()
+		s.controlChannel = dc
+		s.mu.Unlock()
+
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			var m bitrateMessage
+			if err := json.Unmarshal(msg.Data, &m); err != nil {
+				return
+			}
+			if m.Type == "stats" && m.AvailableOutgoingKbps > 0 {
+				s.bitrate.onBrowserEstimate(m.AvailableOutgoingKbps)
+			}
+		})
+	})
+}
+
+// adaptBitrate recomputes the target bitrate every second and sends it to the
+// browser as a REMB message and, when it changed noticeably, as a hint on the
+// control channel so the encoder backs off before the uplink is overloaded
+func (s *IngestService) adaptBitrate(ssrc uint32, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastHint := 0
+	var lastHintAt time.Time
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		target := s.bitrate.update()
+
+		s.mu.Lock()
+		peerConnection, dc, closed := s.peerConnection, s.controlChannel, s.closed
+		s.mu.Unlock()
+		if closed || peerConnection == nil {
+			return
+		}
+
+		remb := &rtcp.ReceiverEstimatedMaximumBitrate{
+			Bitrate: float32(target * 1000),
+			SSRCs:   []uint32{ssrc},
+		}
+		if err := peerConnection.WriteRTCP([]rtcp.Packet{remb}); err != nil {
+			log.Printf("[WebRTC] Failed to send REMB for stream %s: %v", s.streamID, err)
+		}
+
+		changed := lastHint == 0 || target < lastHint*9/10 || target > lastHint*11/10
+		if dc != nil && dc.ReadyState() == webrtc.DataChannelStateOpen && (changed || time.Since(lastHintAt) > 5*time.Second) {
+			if err := dc.Send(encodeBitrateHint(target)); err == nil {
+				if changed {
+					log.Printf("[WebRTC] Target bitrate for stream %s: %d kbps", s.streamID, target)
+				}
+				lastHint, lastHintAt = target, time.Now()
+			}
+		}
+	}
+}
+
+// SetCapture turns raw RTP debug capture on (store) or off (nil). Tracks
+// start or stop capturing with their next packet.
+func (s *IngestService) SetCapture(store *CaptureStore) {
+	s.capture.Store(store)
+}
+
+// CaptureEnabled reports whether raw RTP debug capture is on
+func (s *IngestService) CaptureEnabled() bool {
+	return s.capture.Load() != nil
+}
+
+// SetUserAgent records the broadcaster's browser for debug captures
+func (s *IngestService) SetUserAgent(userAgent string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userAgent = userAgent
+}
+
+// captureRTP starts, feeds or stops the debug capture of a track depending on
+// whether capture is currently enabled, and returns the active capture
+func (s *IngestService) captureRTP(track *webrtc.TrackRemote, capture *rtpCapture, packet *rtp.Packet) *rtpCapture {
+	store := s.capture.Load()
+	if store == nil {
+		if capture != nil {
+			capture.Close()
+		}
+		return nil
+	}
+
+	if capture == nil {
+		meta := newCaptureMeta(s.streamID, track)
+		s.mu.Lock()
+		meta.UserAgent, meta.OfferSDP = s.userAgent, s.offerSDP
+		s.mu.Unlock()
+
+		var err error
+		if capture, err = store.start(meta); err != nil {
+			log.Printf("[Capture] Disabling capture for stream %s: %v", s.streamID, err)
+			s.capture.CompareAndSwap(store, nil)
+			return nil
+		}
+	}
+
+	capture.Write(packet)
+	return capture
+}
+
+// GetBitrateStats returns the latest uplink estimate and target bitrate
+func (s *IngestService) GetBitrateStats() BitrateStats {
+	return s.bitrate.snapshot()
+}
+
+// LastPacketAt returns when the last RTP packet was received, or the zero
+// time if none was
+func (s *IngestService) LastPacketAt() time.Time {
+	if nanos := s.lastPacket.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
 // GetOutputPath returns the path where media files are saved
 func (s *IngestService) GetOutputPath() string {
 	return s.outputDir
@@ -0,0 +1,84 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// MessagesLabel is the label of the data channel the server opens to push
+// messages to the broadcaster
+const MessagesLabel = "messages"
+
+// Types of messages to the broadcaster
+const (
+	MessageViewers    = "viewers"    // data: viewer_count
+	MessageHealth     = "health"     // the stream's delivery health changed
+	MessageCue        = "cue"        // e.g. "wrap up in 2 minutes"
+	MessageModeration = "moderation" // a moderator's notice
+	MessageNotice     = "notice"
+)
+
+// Levels of messages to the broadcaster
+const (
+	LevelInfo     = "info"
+	LevelWarning  = "warning"
+	LevelCritical = "critical"
+)
+
+// Message is pushed to the broadcaster on the messages data channel
+type Message struct {
+	Type   string         `json:"type"`
+	Level  string         `json:"level,omitempty"`
+	Text   string         `json:"text,omitempty"`
+	Data   map[string]any `json:"data,omitempty"`
+	SentAt time.Time      `json:"sent_at"`
+}
+
+// ValidLevel reports whether level is a level of messages, "" for info
+func ValidLevel(level string) bool {
+	switch level {
+	case "", LevelInfo, LevelWarning, LevelCritical:
+		return true
+	}
+	return false
+}
+
+// openMessages opens the messages data channel. It opens along with the
+// connection when the broadcaster's offer negotiated data channels, as the
+// broadcast page's does with its control channel, and never otherwise.
+// Callers hold s.mu.
+func (s *IngestService) openMessages(peerConnection *webrtc.PeerConnection) {
+	dc, err := peerConnection.CreateDataChannel(MessagesLabel, nil)
+	if err != nil {
+		log.Printf("[WebRTC] Failed to open messages channel for stream %s: %v", s.streamID, err)
+		return
+	}
+	s.messages = dc
+}
+
+// Send pushes a message to the broadcaster. It reports false while the
+// messages channel is not open.
+func (s *IngestService) Send(msg Message) bool {
+	s.mu.Lock()
+	dc, closed := s.messages, s.closed
+	s.mu.Unlock()
+	if closed || dc == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return false
+	}
+
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now().UTC()
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	if err := dc.SendText(string(data)); err != nil {
+		log.Printf("[WebRTC] Failed to send %s message to stream %s: %v", msg.Type, s.streamID, err)
+		return false
+	}
+	return true
+}
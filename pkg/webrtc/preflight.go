@@ -0,0 +1,526 @@
+package webrtc
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Preflight run states
+const (
+	PreflightRunning   = "running"
+	PreflightCompleted = "completed"
+	PreflightFailed    = "failed"
+)
+
+// Errors returned by PreflightService.Start
+var (
+	ErrInvalidOffer      = errors.New("invalid offer")
+	ErrTooManyPreflights = errors.New("too many preflight runs")
+)
+
+// Codecs the ingest pipeline can record (see saveVideoTrack/saveAudioTrack)
+var (
+	supportedVideoCodecs = []string{"VP8"}
+	supportedAudioCodecs = []string{"opus"}
+)
+
+// ICEServer is a STUN or TURN server handed to both peers
+type ICEServer = webrtc.ICEServer
+
+// PreflightConfig configures broadcaster preflight checks
+type PreflightConfig struct {
+	ICEServers    []ICEServer
+	ProbeDuration time.Duration // how long the browser sends probe data
+	MinUplinkKbps int           // uplink below this is reported as not ready
+	MaxSessions   int           // runs kept at once, running or reported; 16 when unset
+	ReportTTL     time.Duration // how long a finished run's report is kept; 10 minutes when unset
+}
+
+// ServerCheck is the reachability result of one STUN/TURN server
+type ServerCheck struct {
+	URL       string  `json:"url"`
+	Reachable bool    `json:"reachable"`
+	RTTMs     float64 `json:"rtt_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ICEReport describes the connectivity between the broadcaster and the server
+type ICEReport struct {
+	State         string        `json:"state"`
+	Connected     bool          `json:"connected"`
+	CandidateType string        `json:"candidate_type,omitempty"` // host, srflx, prflx or relay
+	Protocol      string        `json:"protocol,omitempty"`
+	RTTMs         float64       `json:"rtt_ms,omitempty"`
+	Servers       []ServerCheck `json:"servers"`
+}
+
+// BandwidthReport is the uplink estimate from the data channel probe
+type BandwidthReport struct {
+	BytesReceived    uint64 `json:"bytes_received"`
+	DurationMs       int64  `json:"duration_ms"`
+	UplinkKbps       int    `json:"uplink_kbps"`
+	RequiredKbps     int    `json:"required_kbps"`
+	RecommendedLayer string `json:"recommended_layer,omitempty"`
+}
+
+// CodecReport lists the codecs offered by the browser and whether the ingest
+// pipeline can record them
+type CodecReport struct {
+	Video          []string `json:"video"`
+	Audio          []string `json:"audio"`
+	VideoSupported bool     `json:"video_supported"`
+	AudioSupported bool     `json:"audio_supported"`
+}
+
+// PreflightReport is the readiness report returned to the broadcaster
+type PreflightReport struct {
+	ID          string          `json:"id"`
+	Status      string          `json:"status"`
+	Ready       bool            `json:"ready"`
+	Warnings    []string        `json:"warnings"`
+	ICE         ICEReport       `json:"ice"`
+	Bandwidth   BandwidthReport `json:"bandwidth"`
+	Codecs      CodecReport     `json:"codecs"`
+	StartedAt   time.Time       `json:"started_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// PreflightService runs broadcaster preflight checks: ICE connectivity,
+// an uplink bandwidth probe over a data channel, and codec support
+type PreflightService struct {
+	config   PreflightConfig
+	mu       sync.Mutex
+	sessions map[string]*preflightSession
+}
+
+type preflightSession struct {
+	mu             sync.Mutex
+	report         *PreflightReport
+	peerConnection *webrtc.PeerConnection
+	probeChannel   *webrtc.DataChannel
+	probeStart     time.Time
+	probeBytes     uint64
+	done           bool
+}
+
+// NewPreflightService creates a new preflight service
+func NewPreflightService(config PreflightConfig) *PreflightService {
+	if config.ProbeDuration <= 0 {
+		config.ProbeDuration = 5 * time.Second
+	}
+	if config.MaxSessions <= 0 {
+		config.MaxSessions = 16
+	}
+	if config.ReportTTL <= 0 {
+		config.ReportTTL = 10 * time.Minute
+	}
+	return &PreflightService{
+		config:   config,
+		sessions: make(map[string]*preflightSession),
+	}
+}
+
+// ICEServers returns the STUN/TURN servers the browser should use
+func (s *PreflightService) ICEServers() []ICEServer {
+	return s.config.ICEServers
+}
+
+// ProbeDuration returns how long the browser should send probe data
+func (s *PreflightService) ProbeDuration() time.Duration {
+	return s.config.ProbeDuration
+}
+
+// Start answers a browser offer containing a "probe" data channel and
+// begins the checks. The offer should be sent after ICE gathering completed.
+// It returns ErrInvalidOffer for an offer it can't read and
+// ErrTooManyPreflights when MaxSessions runs are already kept.
+func (s *PreflightService) Start(offerSDP string) (string, string, error) {
+	codecs, err := offeredCodecs(offerSDP)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidOffer, err)
+	}
+
+	idBuf := make([]byte, 8)
+	rand.Read(idBuf)
+	id := hex.EncodeToString(idBuf)
+
+	session := &preflightSession{
+		report: &PreflightReport{
+			ID:        id,
+			Status:    PreflightRunning,
+			Warnings:  []string{},
+			Codecs:    codecs,
+			StartedAt: time.Now(),
+		},
+	}
+	session.report.Bandwidth.RequiredKbps = s.config.MinUplinkKbps
+	session.report.ICE.State = webrtc.ICEConnectionStateNew.String()
+
+	// The run takes its place before gathering, so concurrent offers can't
+	// all pass the limit
+	s.pruneSessions()
+	s.mu.Lock()
+	if len(s.sessions) >= s.config.MaxSessions {
+		s.mu.Unlock()
+		return "", "", ErrTooManyPreflights
+	}
+	s.sessions[id] = session
+	s.mu.Unlock()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
+		ICEServers: s.config.ICEServers,
+	})
+	if err != nil {
+		s.forget(id)
+		return "", "", fmt.Errorf("failed to create peer connection: %w", err)
+	}
+	session.peerConnection = peerConnection
+
+	peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		session.mu.Lock()
+		session.report.ICE.State = state.String()
+		if state == webrtc.ICEConnectionStateConnected {
+			session.report.ICE.Connected = true
+		}
+		session.mu.Unlock()
+
+		if state == webrtc.ICEConnectionStateFailed {
+			s.finish(session, "ICE connection failed")
+		}
+	})
+
+	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() != "probe" {
+			return
+		}
+		session.mu.Lock()
+		session.probeChannel = dc
+		session.mu.Unlock()
+
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			session.mu.Lock()
+			if session.probeStart.IsZero() {
+				session.probeStart = time.Now()
+				time.AfterFunc(s.config.ProbeDuration, func() {
+					s.finish(session, "")
+				})
+			}
+			session.probeBytes += uint64(len(msg.Data))
+			session.mu.Unlock()
+		})
+	})
+
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		peerConnection.Close()
+		s.forget(id)
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidOffer, err)
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		peerConnection.Close()
+		s.forget(id)
+		return "", "", fmt.Errorf("failed to create answer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		peerConnection.Close()
+		s.forget(id)
+		return "", "", fmt.Errorf("failed to set local description: %w", err)
+	}
+	<-gatherComplete
+
+	// Server checks run alongside the probe; give up if the browser never connects
+	go func() {
+		servers := checkICEServers(s.config.ICEServers)
+		session.mu.Lock()
+		session.report.ICE.Servers = servers
+		session.mu.Unlock()
+	}()
+	time.AfterFunc(s.config.ProbeDuration+20*time.Second, func() {
+		s.finish(session, "Preflight timed out before the probe completed")
+	})
+
+	log.Printf("[Preflight] Started %s", id)
+	return id, peerConnection.LocalDescription().SDP, nil
+}
+
+// GetReport returns the current report of a preflight run
+func (s *PreflightService) GetReport(id string) (*PreflightReport, error) {
+	s.mu.Lock()
+	session, exists := s.sessions[id]
+	s.mu.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("preflight not found: %s", id)
+	}
+	return session.snapshot(), nil
+}
+
+// finish computes the final report, sends it to the browser over the probe
+// channel and closes the connection. A non-empty failure marks the run as failed.
+func (s *PreflightService) finish(session *preflightSession, failure string) {
+	session.mu.Lock()
+	if session.done {
+		session.mu.Unlock()
+		return
+	}
+	session.done = true
+	report := session.report
+
+	if !session.probeStart.IsZero() {
+		elapsed := time.Since(session.probeStart)
+		report.Bandwidth.BytesReceived = session.probeBytes
+		report.Bandwidth.DurationMs = elapsed.Milliseconds()
+		if elapsed > 0 {
+			report.Bandwidth.UplinkKbps = int(float64(session.probeBytes*8) / elapsed.Seconds() / 1000)
+		}
+		report.Bandwidth.RecommendedLayer = recommendedLayer(report.Bandwidth.UplinkKbps)
+	}
+
+	if pair, err := session.peerConnection.SCTP().Transport().ICETransport().GetSelectedCandidatePair(); err == nil && pair != nil {
+		report.ICE.CandidateType = pair.Remote.Typ.String()
+		report.ICE.Protocol = pair.Remote.Protocol.String()
+	}
+	if stats, ok := session.peerConnection.SCTP().Transport().ICETransport().GetSelectedCandidatePairStats(); ok {
+		report.ICE.RTTMs = stats.CurrentRoundTripTime * 1000
+	}
+
+	now := time.Now()
+	report.CompletedAt = &now
+	if failure != "" {
+		report.Status = PreflightFailed
+		report.Warnings = append(report.Warnings, failure)
+	} else {
+		report.Status = PreflightCompleted
+	}
+
+	if !report.ICE.Connected {
+		report.Warnings = append(report.Warnings, "Could not establish a WebRTC connection to the server")
+	}
+	if report.ICE.CandidateType == webrtc.ICECandidateTypeRelay.String() {
+		report.Warnings = append(report.Warnings, "Connected through a TURN relay, expect higher latency")
+	}
+	for _, server := range report.ICE.Servers {
+		if !server.Reachable {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("ICE server unreachable from the service: %s", server.URL))
+		}
+	}
+	if !report.Codecs.VideoSupported {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("Browser does not offer a supported video codec (%s)", strings.Join(supportedVideoCodecs, ", ")))
+	}
+	if !report.Codecs.AudioSupported {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("Browser does not offer a supported audio codec (%s)", strings.Join(supportedAudioCodecs, ", ")))
+	}
+	bandwidthOK := report.Bandwidth.UplinkKbps >= report.Bandwidth.RequiredKbps
+	if !bandwidthOK {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("Uplink of %d kbps is below the required %d kbps", report.Bandwidth.UplinkKbps, report.Bandwidth.RequiredKbps))
+	}
+
+	report.Ready = failure == "" && report.ICE.Connected && report.Codecs.VideoSupported && bandwidthOK
+	log.Printf("[Preflight] %s finished: ready=%t uplink=%dkbps candidate=%s", report.ID, report.Ready, report.Bandwidth.UplinkKbps, report.ICE.CandidateType)
+
+	final, _ := json.Marshal(report)
+	dc := session.probeChannel
+	session.mu.Unlock()
+
+	// Close outside the lock: state change callbacks take it too. Give the
+	// report a moment to reach the browser before tearing the connection down.
+	if dc != nil && dc.SendText(string(final)) == nil {
+		time.AfterFunc(2*time.Second, func() { session.peerConnection.Close() })
+		return
+	}
+	session.peerConnection.Close()
+}
+
+// forget drops a run that failed to start
+func (s *PreflightService) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// pruneSessions forgets runs whose report is older than ReportTTL
+func (s *PreflightService) pruneSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		session.mu.Lock()
+		expired := session.done && session.report.CompletedAt != nil && time.Since(*session.report.CompletedAt) > s.config.ReportTTL
+		session.mu.Unlock()
+		if expired {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (p *preflightSession) snapshot() *PreflightReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := *p.report
+	c.Warnings = append([]string{}, p.report.Warnings...)
+	c.ICE.Servers = append([]ServerCheck{}, p.report.ICE.Servers...)
+	if !p.done && !p.probeStart.IsZero() {
+		c.Bandwidth.BytesReceived = p.probeBytes
+		c.Bandwidth.DurationMs = time.Since(p.probeStart).Milliseconds()
+	}
+	return &c
+}
+
+// recommendedLayer returns the highest ladder rung the uplink can carry with
+// some headroom for audio and bitrate spikes
+func recommendedLayer(uplinkKbps int) string {
+	switch {
+	case uplinkKbps >= 6500:
+		return "1080p"
+	case uplinkKbps >= 3800:
+		return "720p"
+	case uplinkKbps >= 2000:
+		return "480p"
+	case uplinkKbps >= 1100:
+		return "360p"
+	default:
+		return ""
+	}
+}
+
+// offeredCodecs reads the codec names from the a=rtpmap lines of each media
+// section in an SDP offer
+func offeredCodecs(sdp string) (CodecReport, error) {
+	report := CodecReport{Video: []string{}, Audio: []string{}}
+	seen := make(map[string]bool)
+	kind := ""
+
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "m=") {
+			fields := strings.Fields(strings.TrimPrefix(line, "m="))
+			if len(fields) == 0 {
+				return report, fmt.Errorf("media line without a media type")
+			}
+			kind = fields[0]
+			continue
+		}
+		if !strings.HasPrefix(line, "a=rtpmap:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name := strings.SplitN(fields[1], "/", 2)[0]
+		if seen[kind+name] {
+			continue
+		}
+		seen[kind+name] = true
+
+		switch kind {
+		case "video":
+			if name == "rtx" || name == "red" || name == "ulpfec" || name == "flexfec-03" {
+				continue
+			}
+			report.Video = append(report.Video, name)
+			report.VideoSupported = report.VideoSupported || containsFold(supportedVideoCodecs, name)
+		case "audio":
+			report.Audio = append(report.Audio, name)
+			report.AudioSupported = report.AudioSupported || containsFold(supportedAudioCodecs, name)
+		}
+	}
+	return report, nil
+}
+
+// checkICEServers sends a STUN binding request to every configured STUN/TURN
+// server (TURN servers answer binding requests too). TCP and TLS transports
+// are only checked for a successful connection.
+func checkICEServers(servers []ICEServer) []ServerCheck {
+	var checks []ServerCheck
+	for _, server := range servers {
+		for _, url := range server.URLs {
+			check := ServerCheck{URL: url}
+			rtt, err := probeICEServer(url)
+			if err != nil {
+				check.Error = err.Error()
+			} else {
+				check.Reachable = true
+				check.RTTMs = float64(rtt.Microseconds()) / 1000
+			}
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+func probeICEServer(url string) (time.Duration, error) {
+	scheme, rest, ok := strings.Cut(url, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid ICE server URL")
+	}
+	hostPort, query, _ := strings.Cut(rest, "?")
+	transport := "udp"
+	if strings.Contains(query, "transport=tcp") || scheme == "turns" || scheme == "stuns" {
+		transport = "tcp"
+	}
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		port := "3478"
+		if scheme == "turns" || scheme == "stuns" {
+			port = "5349"
+		}
+		hostPort = net.JoinHostPort(hostPort, port)
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout(transport, hostPort, 3*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if transport == "tcp" {
+		return time.Since(start), nil
+	}
+
+	// STUN binding request: type 0x0001, no attributes, magic cookie, transaction ID
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:2], 0x0001)
+	binary.BigEndian.PutUint32(request[4:8], 0x2112A442)
+	rand.Read(request[8:20])
+
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	start = time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	response := make([]byte, 1500)
+	n, err := conn.Read(response)
+	if err != nil {
+		return 0, fmt.Errorf("no STUN response: %w", err)
+	}
+	if n < 20 || binary.BigEndian.Uint16(response[0:2]) != 0x0101 || string(response[8:20]) != string(request[8:20]) {
+		return 0, fmt.Errorf("unexpected STUN response")
+	}
+	return time.Since(start), nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
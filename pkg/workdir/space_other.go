@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package workdir
+
+// freeSpace is not implemented on this platform; the space check is skipped
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
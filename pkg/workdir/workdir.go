@@ -0,0 +1,188 @@
+package workdir
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WorkDir is the local scratch layout shared by uploads, WebRTC ingest, live
+// transcoding and VOD conversion. Live, latency-sensitive per-stream data can
+// be placed on a separate fast volume (tmpfs or local SSD).
+//
+//	{root}/video-uploads            temporary uploads and downloads
+//	{root}/staging                  staged VOD sources awaiting conversion
+//	{root}/quarantine               sources rejected by validation
+//	{root}/hls                      VOD conversion output
+//	{root}/rtp-captures             raw RTP debug captures
+//	{root}/jobs                     transcode job checkpoints
+//	{root}/usage                    bucket storage usage ledger
+//	{root}/viewers                  watch history of signed in viewers
+//	{root}/downloads                MP4 files built from HLS for download
+//	{fast}/webrtc-ingest/{streamID} WebRTC ingest files
+//	{fast}/hls/{streamID}           live HLS output
+type WorkDir struct {
+	root string
+	fast string
+}
+
+// New validates the work directories and returns the layout. fast defaults to
+// root. Each directory must be writable and, when minFreeBytes is set, have
+// at least that much free space.
+func New(root, fast string, minFreeBytes uint64) (*WorkDir, error) {
+	if root == "" {
+		return nil, fmt.Errorf("work directory is not set")
+	}
+	if fast == "" {
+		fast = root
+	}
+
+	w := &WorkDir{root: filepath.Clean(root), fast: filepath.Clean(fast)}
+	for _, dir := range []string{w.root, w.fast} {
+		if err := validate(dir, minFreeBytes); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Root returns the main work directory
+func (w *WorkDir) Root() string {
+	return w.root
+}
+
+// Fast returns the directory for live per-stream data
+func (w *WorkDir) Fast() string {
+	return w.fast
+}
+
+// Uploads returns the directory for temporary uploads and downloads
+func (w *WorkDir) Uploads() string {
+	return w.ensure(filepath.Join(w.root, "video-uploads"))
+}
+
+// Staging returns the directory for staged VOD sources
+func (w *WorkDir) Staging() string {
+	return w.ensure(filepath.Join(w.root, "staging"))
+}
+
+// Quarantine returns the directory for sources rejected by validation
+func (w *WorkDir) Quarantine() string {
+	return w.ensure(filepath.Join(w.root, "quarantine"))
+}
+
+// VODHLS returns the directory for VOD HLS conversion output
+func (w *WorkDir) VODHLS() string {
+	return w.ensure(filepath.Join(w.root, "hls"))
+}
+
+// Captures returns the directory for raw RTP debug captures
+func (w *WorkDir) Captures() string {
+	return w.ensure(filepath.Join(w.root, "rtp-captures"))
+}
+
+// Jobs returns the directory for transcode job checkpoints
+func (w *WorkDir) Jobs() string {
+	return w.ensure(filepath.Join(w.root, "jobs"))
+}
+
+// StreamRecords returns the directory for the persisted records of streams
+func (w *WorkDir) StreamRecords() string {
+	return w.ensure(filepath.Join(w.root, "streams"))
+}
+
+// Interactions returns the directory for the journals of interactions of
+// live streams, until they are uploaded
+func (w *WorkDir) Interactions() string {
+	return w.ensure(filepath.Join(w.root, "interactions"))
+}
+
+// Watermarks returns the directory for the journals of playback sessions of
+// watermarked streams
+func (w *WorkDir) Watermarks() string {
+	return w.ensure(filepath.Join(w.root, "watermarks"))
+}
+
+// Usage returns the directory for the storage usage ledger
+func (w *WorkDir) Usage() string {
+	return w.ensure(filepath.Join(w.root, "usage"))
+}
+
+// Viewers returns the directory for the watch history of signed in viewers
+func (w *WorkDir) Viewers() string {
+	return w.ensure(filepath.Join(w.root, "viewers"))
+}
+
+// Downloads returns the directory for MP4 files built from HLS for download
+func (w *WorkDir) Downloads() string {
+	return w.ensure(filepath.Join(w.root, "downloads"))
+}
+
+// StreamIngest returns the WebRTC ingest directory of a stream
+func (w *WorkDir) StreamIngest(streamID string) string {
+	return filepath.Join(w.fast, "webrtc-ingest", streamID)
+}
+
+// StreamHLS returns the live HLS output directory of a stream
+func (w *WorkDir) StreamHLS(streamID string) string {
+	return filepath.Join(w.fast, "hls", streamID)
+}
+
+// WarmHLS returns the directory warm transcoders write their HLS output to
+// until a stream claims them
+func (w *WorkDir) WarmHLS() string {
+	return filepath.Join(w.fast, "hls-warm")
+}
+
+// Slates returns the directory for slate segments of scheduled streams
+func (w *WorkDir) Slates() string {
+	return w.ensure(filepath.Join(w.root, "slates"))
+}
+
+// CleanupStream removes all per-stream directories of a stream. An HLS
+// directory linked to the output of a warm transcoder is removed with it.
+func (w *WorkDir) CleanupStream(streamID string) error {
+	dirs := []string{w.StreamIngest(streamID), w.StreamHLS(streamID)}
+	if target, err := os.Readlink(w.StreamHLS(streamID)); err == nil && strings.HasPrefix(target, w.fast+string(filepath.Separator)) {
+		dirs = append(dirs, target)
+	}
+
+	var firstErr error
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (w *WorkDir) ensure(dir string) string {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("[WorkDir] Failed to create %s: %v", dir, err)
+	}
+	return dir
+}
+
+// validate creates dir, checks that files can be written to it and that it
+// has enough free space
+func validate(dir string, minFreeBytes uint64) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create work directory %s: %w", dir, err)
+	}
+
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("work directory %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if minFreeBytes > 0 {
+		if free, ok := freeSpace(dir); ok && free < minFreeBytes {
+			return fmt.Errorf("work directory %s has %d MB free, need at least %d MB", dir, free>>20, minFreeBytes>>20)
+		}
+	}
+	return nil
+}